@@ -4,22 +4,56 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"regexp"
+	"strings"
+	"time"
 
 	"github.com/redis/go-redis/v9"
 	"github.com/yuyang0/goflow/core/sdk"
 	"github.com/yuyang0/goflow/types"
 )
 
+const (
+	// DefaultBucketTemplate is the bucket layout of the data of a request
+	DefaultBucketTemplate = "core-{flow}-{request}"
+
+	// TrackedKeysInitial is the prefix of the sets of the keys of each flow, see TrackKeys
+	TrackedKeysInitial = "goflow-store-keys:data"
+	// TrackedKeysExpiration is how long the set of the keys of a flow is kept once none of its keys is written, so
+	// that the keys dropped by redis, e.g. expired or evicted, don't stay tracked forever
+	TrackedKeysExpiration = 7 * 24 * time.Hour
+)
+
+var placeholderPattern = regexp.MustCompile(`\{[^}]*\}`)
+
 type RedisDataStore struct {
-	bucketName  string
-	redisClient redis.UniversalClient
+	// TrackKeys records the keys written by the requests of a flow in a set, see TrackedKeysSet, so that they
+	// are counted without a scan
+	TrackKeys bool
+
+	flowName       string
+	bucketName     string
+	bucketTemplate string
+	tenant         string
+	redisClient    redis.UniversalClient
 }
 
 func GetRedisDataStore(cfg *types.RedisConfig) (sdk.DataStore, error) {
-	ds := &RedisDataStore{}
+	return GetRedisDataStoreWithTemplate(cfg, DefaultBucketTemplate, "")
+}
+
+// GetRedisDataStoreWithTemplate returns a data store which buckets are named after the template,
+// the template supports the {flow}, {request} and {tenant} placeholders
+func GetRedisDataStoreWithTemplate(cfg *types.RedisConfig, bucketTemplate string, tenant string) (sdk.DataStore, error) {
+	if err := ValidateBucketTemplate(bucketTemplate, tenant); err != nil {
+		return nil, err
+	}
+
+	ds := &RedisDataStore{bucketTemplate: bucketTemplate, tenant: tenant}
 	client := cfg.NewRedisClient()
 	err := client.Ping(context.TODO()).Err()
 	if err != nil {
+		client.Close()
 		return nil, err
 	}
 
@@ -27,10 +61,50 @@ func GetRedisDataStore(cfg *types.RedisConfig) (sdk.DataStore, error) {
 	return ds, nil
 }
 
+// TrackedKeysSet returns the set of the keys of the requests of a flow, see TrackKeys
+func TrackedKeysSet(flowName string) string {
+	return fmt.Sprintf("%s:%s", TrackedKeysInitial, flowName)
+}
+
+// trackKey adds a key to the set of the keys of the flow when TrackKeys is set, and renews the expiration of the set
+func (this *RedisDataStore) trackKey(pl redis.Pipeliner, key string) {
+	if this.TrackKeys {
+		pl.SAdd(context.TODO(), TrackedKeysSet(this.flowName), key)
+		pl.Expire(context.TODO(), TrackedKeysSet(this.flowName), TrackedKeysExpiration)
+	}
+}
+
+// ValidateBucketTemplate checks that a bucket template identifies the request and can be scanned by Cleanup
+func ValidateBucketTemplate(bucketTemplate string, tenant string) error {
+	for _, placeholder := range placeholderPattern.FindAllString(bucketTemplate, -1) {
+		switch placeholder {
+		case "{flow}", "{request}":
+		case "{tenant}":
+			if tenant == "" {
+				return fmt.Errorf("bucket template %s uses {tenant} but no tenant is set", bucketTemplate)
+			}
+		default:
+			return fmt.Errorf("bucket template %s has unknown placeholder %s", bucketTemplate, placeholder)
+		}
+	}
+	if !strings.Contains(bucketTemplate, "{flow}") || !strings.Contains(bucketTemplate, "{request}") {
+		return fmt.Errorf("bucket template %s must contain {flow} and {request}", bucketTemplate)
+	}
+	if strings.ContainsAny(bucketTemplate, "*?[]\\") {
+		return fmt.Errorf("bucket template %s must not contain glob characters", bucketTemplate)
+	}
+	return nil
+}
+
 func (this *RedisDataStore) Configure(flowName string, requestId string) {
-	bucketName := fmt.Sprintf("core-%s-%s", flowName, requestId)
+	bucketTemplate := this.bucketTemplate
+	if bucketTemplate == "" {
+		bucketTemplate = DefaultBucketTemplate
+	}
+	replacer := strings.NewReplacer("{flow}", flowName, "{request}", requestId, "{tenant}", this.tenant)
 
-	this.bucketName = bucketName
+	this.bucketName = replacer.Replace(bucketTemplate)
+	this.flowName = flowName
 }
 
 func (this *RedisDataStore) Init() error {
@@ -41,20 +115,59 @@ func (this *RedisDataStore) Init() error {
 	return nil
 }
 
+// Ping checks the connection to redis
+func (this *RedisDataStore) Ping() error {
+	if this.redisClient == nil {
+		return fmt.Errorf("redis client not initialized, use GetRedisDataStore()")
+	}
+	return this.redisClient.Ping(context.TODO()).Err()
+}
+
+// Close closes the connection of the store to redis
+func (this *RedisDataStore) Close() error {
+	if this.redisClient == nil {
+		return nil
+	}
+	return this.redisClient.Close()
+}
+
 func (this *RedisDataStore) Set(key string, value []byte) error {
 	if this.redisClient == nil {
 		return fmt.Errorf("redis client not initialized, use GetRedisDataStore()")
 	}
 
 	fullPath := getPath(this.bucketName, key)
-	_, err := this.redisClient.Set(context.TODO(), fullPath, string(value), 0).Result()
+	_, err := this.redisClient.Pipelined(context.TODO(), func(pl redis.Pipeliner) error {
+		pl.Set(context.TODO(), fullPath, string(value), 0)
+		this.trackKey(pl, fullPath)
+		return nil
+	})
 	if err != nil {
-		return fmt.Errorf("error writing: %s, error: %s", fullPath, err.Error())
+		return fmt.Errorf("error writing: %s, error: %w", fullPath, err)
 	}
 
 	return nil
 }
 
+// SetIfAbsent stores the value of key with SETNX, it returns false if the key is already set
+func (this *RedisDataStore) SetIfAbsent(key string, value []byte) (bool, error) {
+	if this.redisClient == nil {
+		return false, fmt.Errorf("redis client not initialized, use GetRedisDataStore()")
+	}
+
+	fullPath := getPath(this.bucketName, key)
+	var set *redis.BoolCmd
+	_, err := this.redisClient.Pipelined(context.TODO(), func(pl redis.Pipeliner) error {
+		set = pl.SetNX(context.TODO(), fullPath, string(value), 0)
+		this.trackKey(pl, fullPath)
+		return nil
+	})
+	if err != nil {
+		return false, fmt.Errorf("error writing: %s, error: %w", fullPath, err)
+	}
+	return set.Val(), nil
+}
+
 func (this *RedisDataStore) Get(key string) ([]byte, error) {
 	if this.redisClient == nil {
 		return nil, fmt.Errorf("redis client not initialized, use GetRedisDataStore()")
@@ -67,7 +180,7 @@ func (this *RedisDataStore) Get(key string) ([]byte, error) {
 	}
 	value, err := v.Result()
 	if err != nil {
-		return nil, fmt.Errorf("error reading: %s, error: %s", fullPath, err.Error())
+		return nil, fmt.Errorf("error reading: %s, error: %w", fullPath, err)
 	}
 	return []byte(value), nil
 }
@@ -78,9 +191,15 @@ func (this *RedisDataStore) Del(key string) error {
 	}
 
 	fullPath := getPath(this.bucketName, key)
-	_, err := this.redisClient.Del(context.TODO(), fullPath).Result()
+	_, err := this.redisClient.Pipelined(context.TODO(), func(pl redis.Pipeliner) error {
+		pl.Del(context.TODO(), fullPath)
+		if this.TrackKeys {
+			pl.SRem(context.TODO(), TrackedKeysSet(this.flowName), fullPath)
+		}
+		return nil
+	})
 	if err != nil {
-		return fmt.Errorf("error removing: %s, error: %s", fullPath, err.Error())
+		return fmt.Errorf("error removing: %s, error: %w", fullPath, err)
 	}
 	return nil
 }
@@ -90,17 +209,25 @@ func (this *RedisDataStore) Cleanup() error {
 	client := this.redisClient
 	var rerr error
 
+	var deleted []interface{}
 	iter := client.Scan(context.TODO(), 0, key, 0).Iterator()
 	for iter.Next(context.TODO()) {
 		err := client.Del(context.TODO(), iter.Val()).Err()
 		if err != nil {
 			rerr = err
+			continue
 		}
+		deleted = append(deleted, iter.Val())
 	}
 
 	if err := iter.Err(); err != nil {
 		rerr = err
 	}
+	if this.TrackKeys && len(deleted) > 0 {
+		if err := client.SRem(context.TODO(), TrackedKeysSet(this.flowName), deleted...).Err(); err != nil {
+			rerr = err
+		}
+	}
 	return rerr
 }
 
@@ -111,5 +238,12 @@ func getPath(bucket, key string) string {
 }
 
 func (this *RedisDataStore) CopyStore() (sdk.DataStore, error) {
-	return &RedisDataStore{bucketName: this.bucketName, redisClient: this.redisClient}, nil
+	return &RedisDataStore{
+		TrackKeys:      this.TrackKeys,
+		flowName:       this.flowName,
+		bucketName:     this.bucketName,
+		bucketTemplate: this.bucketTemplate,
+		tenant:         this.tenant,
+		redisClient:    this.redisClient,
+	}, nil
 }
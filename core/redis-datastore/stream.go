@@ -0,0 +1,116 @@
+package RedisDataStore
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// ChunkSize is the size of the chunks a value is streamed from and into redis with
+	ChunkSize = 1 << 20
+)
+
+// chunkReader reads a redis string value chunk by chunk with GETRANGE
+type chunkReader struct {
+	store    *RedisDataStore
+	fullPath string
+	offset   int64
+	chunk    []byte
+	done     bool
+}
+
+func (reader *chunkReader) Read(p []byte) (int, error) {
+	if len(reader.chunk) == 0 {
+		if reader.done {
+			return 0, io.EOF
+		}
+		end := reader.offset + ChunkSize - 1
+		value, err := reader.store.redisClient.GetRange(context.TODO(), reader.fullPath, reader.offset, end).Result()
+		if err != nil {
+			return 0, fmt.Errorf("error reading: %s, error: %w", reader.fullPath, err)
+		}
+		reader.offset += int64(len(value))
+		reader.chunk = []byte(value)
+		reader.done = len(value) < ChunkSize
+		if len(reader.chunk) == 0 {
+			return 0, io.EOF
+		}
+	}
+	n := copy(p, reader.chunk)
+	reader.chunk = reader.chunk[n:]
+	return n, nil
+}
+
+func (reader *chunkReader) Close() error {
+	reader.chunk = nil
+	reader.done = true
+	return nil
+}
+
+// GetReader returns a reader fetching the value in chunks of ChunkSize, the value must not
+// be replaced while being read
+func (this *RedisDataStore) GetReader(key string) (io.ReadCloser, error) {
+	if this.redisClient == nil {
+		return nil, fmt.Errorf("redis client not initialized, use GetRedisDataStore()")
+	}
+
+	fullPath := getPath(this.bucketName, key)
+	exists, err := this.redisClient.Exists(context.TODO(), fullPath).Result()
+	if err != nil {
+		return nil, fmt.Errorf("error reading: %s, error: %w", fullPath, err)
+	}
+	if exists == 0 {
+		return nil, fmt.Errorf("error reading: %s, error: value not found", fullPath)
+	}
+	return &chunkReader{store: this, fullPath: fullPath}, nil
+}
+
+// SetReader appends the content of the reader in chunks of ChunkSize to a temporary value,
+// which replaces the value of key once fully written
+func (this *RedisDataStore) SetReader(key string, reader io.Reader) error {
+	if this.redisClient == nil {
+		return fmt.Errorf("redis client not initialized, use GetRedisDataStore()")
+	}
+
+	ctx := context.TODO()
+	fullPath := getPath(this.bucketName, key)
+	// the temporary value is within the bucket so that Cleanup removes it if the write is abandoned
+	tmpPath := fullPath + ".tmp." + strconv.FormatInt(time.Now().UnixNano(), 36)
+	if err := this.redisClient.Set(ctx, tmpPath, "", 0).Err(); err != nil {
+		return fmt.Errorf("error writing: %s, error: %w", fullPath, err)
+	}
+
+	buf := make([]byte, ChunkSize)
+	for {
+		n, rerr := io.ReadFull(reader, buf)
+		if n > 0 {
+			if err := this.redisClient.Append(ctx, tmpPath, string(buf[:n])).Err(); err != nil {
+				this.redisClient.Del(ctx, tmpPath)
+				return fmt.Errorf("error writing: %s, error: %w", fullPath, err)
+			}
+		}
+		if rerr == io.EOF || rerr == io.ErrUnexpectedEOF {
+			break
+		}
+		if rerr != nil {
+			this.redisClient.Del(ctx, tmpPath)
+			return fmt.Errorf("error writing: %s, error: %w", fullPath, rerr)
+		}
+	}
+
+	_, err := this.redisClient.Pipelined(ctx, func(pl redis.Pipeliner) error {
+		pl.Rename(ctx, tmpPath, fullPath)
+		this.trackKey(pl, fullPath)
+		return nil
+	})
+	if err != nil {
+		this.redisClient.Del(ctx, tmpPath)
+		return fmt.Errorf("error writing: %s, error: %w", fullPath, err)
+	}
+	return nil
+}
@@ -4,16 +4,46 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/redis/go-redis/v9"
 	"github.com/yuyang0/goflow/core/sdk"
+	"github.com/yuyang0/goflow/metrics"
 	"github.com/yuyang0/goflow/types"
 )
 
+const (
+	// DefaultRetryBackoff is the initial wait before retrying an Update that conflicted with a concurrent write
+	DefaultRetryBackoff = 10 * time.Millisecond
+
+	// TrackedKeysInitial is the prefix of the sets of the keys of each flow, see TrackKeys
+	TrackedKeysInitial = "goflow-store-keys:state"
+	// TrackedKeysExpiration is how long the set of the keys of a flow is kept once none of its keys is written, so
+	// that the keys dropped by redis, e.g. expired or evicted, don't stay tracked forever
+	TrackedKeysExpiration = 7 * 24 * time.Hour
+)
+
+var casConflictsCounter = metrics.NewCounterVec("goflow_statestore_cas_conflicts_total",
+	"Updates of the StateStore that conflicted with a concurrent write of the key", "flow", "key")
+
 type RedisStateStore struct {
-	KeyPath    string
-	rds        redis.UniversalClient
+	KeyPath string
+	// RetryCount is the number of times an Update is retried when the key is modified concurrently
 	RetryCount int
+	// RetryBackoff is the wait before the first retry of an Update, it doubles on every retry
+	RetryBackoff time.Duration
+	// StrongConsistency routes all the reads to the primary, including the ones tolerating staleness
+	StrongConsistency bool
+	// StaleReads routes the Gets to the read replicas, for the reads tolerating staleness such as the
+	// state queries of the http api. The reads of the executions always go to the primary
+	StaleReads bool
+	// TrackKeys records the keys written by the requests of a flow in a set, see TrackedKeysSet, so that they
+	// are counted without a scan
+	TrackKeys bool
+
+	writeClient redis.UniversalClient
+	readRouter  *types.ReadRouter
+	flowName    string
 }
 
 // Update Compare and Update a valuer
@@ -28,16 +58,75 @@ func GetRedisStateStore(cfg *types.RedisConfig) (sdk.StateStore, error) {
 
 	err := client.Ping(context.TODO()).Err()
 	if err != nil {
+		client.Close()
 		return nil, err
 	}
 
-	stateStore.rds = client
+	stateStore.writeClient = client
+	stateStore.readRouter = cfg.NewReadRouter("statestore", client)
 	return stateStore, nil
 }
 
+// TrackedKeysSet returns the set of the keys of the requests of a flow, see TrackKeys
+func TrackedKeysSet(flowName string) string {
+	return fmt.Sprintf("%s:%s", TrackedKeysInitial, flowName)
+}
+
+// trackKey adds a key to the set of the keys of the flow when TrackKeys is set, and renews the expiration of the set
+func (this *RedisStateStore) trackKey(pl redis.Pipeliner, key string) {
+	if this.TrackKeys {
+		pl.SAdd(context.TODO(), TrackedKeysSet(this.flowName), key)
+		pl.Expire(context.TODO(), TrackedKeysSet(this.flowName), TrackedKeysExpiration)
+	}
+}
+
+// StaleReadStore returns a copy of the store which Gets tolerate staleness, see StaleReads
+func (this *RedisStateStore) StaleReadStore() *RedisStateStore {
+	store := this.copy()
+	store.StaleReads = true
+	return store
+}
+
+// staleRead runs a read tolerating staleness, on a replica unless StrongConsistency is set
+func (this *RedisStateStore) staleRead(read func(client redis.UniversalClient) error) error {
+	if this.StrongConsistency || this.readRouter == nil {
+		return read(this.writeClient)
+	}
+	return this.readRouter.Read(read)
+}
+
 // Configure
 func (this *RedisStateStore) Configure(flowName string, requestId string) {
 	this.KeyPath = fmt.Sprintf("core.%s.%s", flowName, requestId)
+	this.flowName = flowName
+}
+
+// ListRequestIds lists the ids of the requests of a flow that have state in the store, from the primary unless
+// StaleReads is set
+func (this *RedisStateStore) ListRequestIds(flowName string, stateKey string) ([]string, error) {
+	prefix := fmt.Sprintf("core.%s.", flowName)
+	suffix := "." + stateKey
+
+	var requestIds []string
+	list := func(client redis.UniversalClient) error {
+		requestIds = requestIds[:0]
+		iter := client.Scan(context.TODO(), 0, prefix+"*"+suffix, 0).Iterator()
+		for iter.Next(context.TODO()) {
+			key := iter.Val()
+			requestIds = append(requestIds, key[len(prefix):len(key)-len(suffix)])
+		}
+		return iter.Err()
+	}
+	var err error
+	if this.StaleReads {
+		err = this.staleRead(list)
+	} else {
+		err = list(this.writeClient)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list requests of flow %s, %v", flowName, err)
+	}
+	return requestIds, nil
 }
 
 // Init (Called only once in a request)
@@ -45,64 +134,124 @@ func (this *RedisStateStore) Init() error {
 	return nil
 }
 
-// Update Compare and Update a valuer
+// Ping checks the connection to redis and to the read replicas when configured
+func (this *RedisStateStore) Ping() error {
+	if err := this.writeClient.Ping(context.TODO()).Err(); err != nil {
+		return err
+	}
+	if this.readRouter != nil {
+		if err := this.readRouter.Ping(context.TODO()); err != nil {
+			return fmt.Errorf("read replica, %v", err)
+		}
+	}
+	return nil
+}
+
+// Close closes the connections of the store to redis and to the read replicas
+func (this *RedisStateStore) Close() error {
+	if this.readRouter != nil {
+		this.readRouter.Close()
+	}
+	return this.writeClient.Close()
+}
+
+// Update Compare and Update a valuer, the transaction is retried with backoff up to RetryCount
+// times when the key is modified concurrently
 func (this *RedisStateStore) Update(key string, oldValue string, newValue string) error {
+	stateKey := key
 	key = this.KeyPath + "." + key
-	client := this.rds
+	client := this.writeClient
 
-	err := client.Watch(context.TODO(), func(tx *redis.Tx) error {
-		value, err := tx.Get(context.TODO(), key).Result()
-		if err == redis.Nil {
-			err = fmt.Errorf("[%v] not exist", key)
+	backoff := this.RetryBackoff
+	if backoff <= 0 {
+		backoff = DefaultRetryBackoff
+	}
+	var err error
+	for attempt := 0; ; attempt++ {
+		err = client.Watch(context.TODO(), func(tx *redis.Tx) error {
+			value, err := tx.Get(context.TODO(), key).Result()
+			if err == redis.Nil {
+				err = fmt.Errorf("[%v] not exist", key)
+				return err
+			} else if err != nil {
+				err = fmt.Errorf("unexpect error %w", err)
+				return err
+			}
+			if value != oldValue {
+				err = fmt.Errorf("Old value doesn't match for key %s", key)
+				return err
+			}
+			_, err = tx.TxPipelined(context.TODO(), func(pl redis.Pipeliner) error {
+				pl.Set(context.TODO(), key, newValue, 0)
+				return nil
+			})
 			return err
-		} else if err != nil {
-			err = fmt.Errorf("unexpect error %v", err)
+		}, key)
+		if err != redis.TxFailedErr {
 			return err
 		}
-		if value != oldValue {
-			err = fmt.Errorf("Old value doesn't match for key %s", key)
-			return err
+		casConflictsCounter.Inc(this.flowName, stateKey)
+		if attempt >= this.RetryCount {
+			return fmt.Errorf("Old value doesn't match for key %s, concurrent update after %d retries", key, attempt)
 		}
-		_, err = tx.Pipelined(context.TODO(), func(pl redis.Pipeliner) error {
-			pl.Set(context.TODO(), key, newValue, 0)
-			return nil
-		})
-		return err
-	}, key)
-	return err
+		time.Sleep(backoff)
+		backoff *= 2
+	}
 }
 
 // Update Compare and Update a valuer
 func (this *RedisStateStore) Incr(key string, value int64) (int64, error) {
 	key = this.KeyPath + "." + key
-	client := this.rds
-	return client.IncrBy(context.TODO(), key, value).Result()
+	client := this.writeClient
+	var incr *redis.IntCmd
+	_, err := client.Pipelined(context.TODO(), func(pl redis.Pipeliner) error {
+		incr = pl.IncrBy(context.TODO(), key, value)
+		this.trackKey(pl, key)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return incr.Val(), nil
 }
 
 // Set Sets a value (override existing, or create one)
 func (this *RedisStateStore) Set(key string, value string) error {
 	key = this.KeyPath + "." + key
-	client := this.rds
-	err := client.Set(context.TODO(), key, value, 0).Err()
+	client := this.writeClient
+	_, err := client.Pipelined(context.TODO(), func(pl redis.Pipeliner) error {
+		pl.Set(context.TODO(), key, value, 0)
+		this.trackKey(pl, key)
+		return nil
+	})
 	if err != nil {
-		return fmt.Errorf("failed to set key %s, error %v", key, err)
+		return fmt.Errorf("failed to set key %s, error %w", key, err)
 	}
 	return nil
 }
 
-// Get Gets a value
+// Get Gets a value, from the primary unless StaleReads is set
 func (this *RedisStateStore) Get(key string) (string, error) {
 	key = this.KeyPath + "." + key
-	client := this.rds
-	v := client.Get(context.TODO(), key)
-	if v == nil {
-		return "", errors.New(fmt.Sprintf("failed to get key %s, nil", key))
+	var value string
+	var err error
+	get := func(client redis.UniversalClient) error {
+		v := client.Get(context.TODO(), key)
+		if v == nil {
+			return errors.New(fmt.Sprintf("failed to get key %s, nil", key))
+		}
+		value, err = v.Result()
+		return err
+	}
+	if this.StaleReads {
+		err = this.staleRead(get)
+	} else {
+		err = get(this.writeClient)
 	}
-	value, err := v.Result()
 	if err == redis.Nil {
 		return "", fmt.Errorf("failed to get key %s, nil", key)
 	} else if err != nil {
-		return "", fmt.Errorf("failed to get key %s, %v", key, err)
+		return "", fmt.Errorf("failed to get key %s, %w", key, err)
 	}
 
 	return value, nil
@@ -111,22 +260,44 @@ func (this *RedisStateStore) Get(key string) (string, error) {
 // Cleanup (Called only once in a request)
 func (this *RedisStateStore) Cleanup() error {
 	key := this.KeyPath + ".*"
-	client := this.rds
+	client := this.writeClient
 	var rerr error
 
+	var deleted []interface{}
 	iter := client.Scan(context.TODO(), 0, key, 0).Iterator()
 	for iter.Next(context.TODO()) {
 		err := client.Del(context.TODO(), iter.Val()).Err()
 		if err != nil {
 			rerr = err
+			continue
 		}
+		deleted = append(deleted, iter.Val())
 	}
 
 	if err := iter.Err(); err != nil {
 		rerr = err
 	}
+	if this.TrackKeys && len(deleted) > 0 {
+		if err := client.SRem(context.TODO(), TrackedKeysSet(this.flowName), deleted...).Err(); err != nil {
+			rerr = err
+		}
+	}
 	return rerr
 }
 func (this *RedisStateStore) CopyStore() (sdk.StateStore, error) {
-	return &RedisStateStore{KeyPath: this.KeyPath, RetryCount: this.RetryCount, rds: this.rds}, nil
+	return this.copy(), nil
+}
+
+func (this *RedisStateStore) copy() *RedisStateStore {
+	return &RedisStateStore{
+		KeyPath:           this.KeyPath,
+		RetryCount:        this.RetryCount,
+		RetryBackoff:      this.RetryBackoff,
+		StrongConsistency: this.StrongConsistency,
+		StaleReads:        this.StaleReads,
+		TrackKeys:         this.TrackKeys,
+		writeClient:       this.writeClient,
+		readRouter:        this.readRouter,
+		flowName:          this.flowName,
+	}
 }
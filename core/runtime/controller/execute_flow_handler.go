@@ -33,7 +33,7 @@ func ExecuteFlowHandler(response *runtime.Response, request *runtime.Request, ex
 	flowExecutor := executor.CreateFlowExecutor(ex, nil)
 	resp, err := flowExecutor.Execute(stateOption)
 	if err != nil {
-		return fmt.Errorf("failed to execute request. %s", err.Error())
+		return fmt.Errorf("failed to execute request. %w", err)
 	}
 
 	response.RequestID = flowExecutor.GetReqId()
@@ -0,0 +1,24 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/yuyang0/goflow/core/runtime"
+	"github.com/yuyang0/goflow/core/sdk/executor"
+)
+
+// FailureFlowHandler handles a dispatched failure of a request, the request body holds the failure
+func FailureFlowHandler(response *runtime.Response, request *runtime.Request, ex executor.Executor) error {
+	log.Printf("Handling failure of request %s for flow %s\n", request.RequestID, request.FlowName)
+
+	flowExecutor := executor.CreateFlowExecutor(ex, nil)
+	err := flowExecutor.HandleFailure(request.RequestID, errors.New(string(request.Body)))
+	if err != nil {
+		return fmt.Errorf("failed to handle failure of request %s, %v", request.RequestID, err)
+	}
+
+	response.Body = []byte("Successfully handled failure of request " + request.RequestID)
+	return nil
+}
@@ -1,6 +1,7 @@
 package controller
 
 import (
+	"encoding/json"
 	"fmt"
 	"log"
 
@@ -9,6 +10,9 @@ import (
 	"github.com/yuyang0/goflow/core/sdk/executor"
 )
 
+// FlowStateHandler responds with the state of the request as a plain string
+//
+// Deprecated: use FlowRequestStateHandler, which also responds with the node being executed
 func FlowStateHandler(response *runtime.Response, request *runtime.Request, ex executor.Executor) error {
 	log.Printf("Getting state of flow %s for request: %s\n", request.FlowName, request.RequestID)
 
@@ -22,3 +26,21 @@ func FlowStateHandler(response *runtime.Response, request *runtime.Request, ex e
 	response.Body = []byte(state)
 	return nil
 }
+
+// FlowRequestStateHandler responds with the json encoded executor.RequestState of the request
+func FlowRequestStateHandler(response *runtime.Response, request *runtime.Request, ex executor.Executor) error {
+	log.Printf("Getting state of flow %s for request: %s\n", request.FlowName, request.RequestID)
+
+	flowExecutor := executor.CreateFlowExecutor(ex, nil)
+	state, err := flowExecutor.GetRequestState(request.RequestID)
+	if err != nil {
+		log.Printf(err.Error())
+		return fmt.Errorf("failed to get request state for %s, check if request is active", request.RequestID)
+	}
+
+	response.Body, err = json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("failed to encode request state for %s, error %v", request.RequestID, err)
+	}
+	return nil
+}
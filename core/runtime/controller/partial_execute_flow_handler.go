@@ -25,7 +25,7 @@ func PartialExecuteFlowHandler(response *runtime.Response, request *runtime.Requ
 	flowExecutor := executor.CreateFlowExecutor(ex, nil)
 	resp, err := flowExecutor.Execute(stateOption)
 	if err != nil {
-		return fmt.Errorf("failed to execute request. %s", err.Error())
+		return fmt.Errorf("failed to execute request. %w", err)
 	}
 
 	response.Body = resp
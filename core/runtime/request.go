@@ -1,12 +1,17 @@
 package runtime
 
+import "time"
+
 type Request struct {
-	FlowName  string
-	RequestID string
-	Header    map[string][]string
-	RawQuery  string
-	Query     map[string][]string
-	Body      []byte
+	FlowName     string
+	RequestID    string
+	Header       map[string][]string
+	RawQuery     string
+	Query        map[string][]string
+	Body         []byte
+	PartitionKey string    // tasks sharing a partition key are processed in order
+	Deadline     time.Time // zero if the request has no deadline set by the client
+	SubmittedAt  time.Time // time the request was queued, zero if executed synchronously
 }
 
 func (request *Request) GetHeader(header string) string {
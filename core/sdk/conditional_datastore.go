@@ -0,0 +1,28 @@
+package sdk
+
+// ConditionalDataStore can be implemented by a DataStore to set a value only if its key is absent atomically,
+// so that concurrent executions can't both claim the same key
+type ConditionalDataStore interface {
+	// SetIfAbsent stores the value of key if the key is not set, it returns whether the value was stored
+	SetIfAbsent(key string, value []byte) (bool, error)
+}
+
+// SetIfAbsent stores the value of key if the key is not set and returns whether the value was stored. The
+// DataStores not implementing ConditionalDataStore are checked with Get before Set, which isn't atomic
+func SetIfAbsent(store DataStore, key string, value []byte) (bool, error) {
+	if conditional, ok := store.(ConditionalDataStore); ok {
+		return conditional.SetIfAbsent(key, value)
+	}
+	existing, err := store.Get(key)
+	if err != nil {
+		// a missing key is an error of the DataStore, an unreachable store must not be mistaken for it
+		if pinger, ok := store.(Pinger); ok {
+			if err := pinger.Ping(); err != nil {
+				return false, err
+			}
+		}
+	} else if len(existing) > 0 {
+		return false, nil
+	}
+	return true, store.Set(key, value)
+}
@@ -25,12 +25,23 @@ var (
 // Aggregator definition for the data aggregator of nodes
 type Aggregator func(map[string][]byte) ([]byte, error)
 
+// BranchAggregator aggregates the outputs of the branches of a foreach along with the sorted keys of the branches
+// canceled with CancelBranches, which have no output
+type BranchAggregator func(results map[string][]byte, canceled []string) ([]byte, error)
+
 // Forwarder definition for the data forwarder of nodes
 type Forwarder func([]byte) []byte
 
 // ForEach definition for the foreach function
 type ForEach func([]byte) map[string][]byte
 
+// ForEachStream definition for the producer of a streaming foreach, it emits the input of each branch
+// with NodeContext.Emit
+type ForEachStream func(*NodeContext, []byte) error
+
+// DefaultMaxStreamBranches is the number of items a streaming foreach may emit unless set on the node
+const DefaultMaxStreamBranches = 10000
+
 // Condition definition for the condition function
 type Condition func([]byte) []string
 
@@ -67,8 +78,11 @@ type Node struct {
 	dynamic       bool                 // Denotes if the node is dynamic
 	aggregator    Aggregator           // The aggregator aggregates multiple inputs to a node into one
 	foreach       ForEach              // If specified foreach allows to execute the vertex in parallel
+	foreachStream ForEachStream        // If specified the parallel executions are emitted by the producer
+	maxBranches   int                  // The maximum of items the foreach stream emits
 	condition     Condition            // If specified condition allows to execute only selected sub-dag
 	subAggregator Aggregator           // Aggregates foreach/condition outputs into one
+	branchAggr    BranchAggregator     // Aggregates foreach outputs along with the canceled branches
 	forwarder     map[string]Forwarder // The forwarder handle forwarding output to a children
 
 	parentDag       *Dag    // The reference of the dag this node part of
@@ -447,6 +461,18 @@ func (this *Node) AddForEach(foreach ForEach) {
 	this.AddForwarder("dynamic", DefaultForwarder)
 }
 
+// AddForEachStream add a streaming foreach producer to a node
+func (this *Node) AddForEachStream(producer ForEachStream) {
+	this.foreachStream = producer
+	this.dynamic = true
+	this.AddForwarder("dynamic", DefaultForwarder)
+}
+
+// SetMaxBranches sets the maximum of items the streaming foreach of the node emits
+func (this *Node) SetMaxBranches(max int) {
+	this.maxBranches = max
+}
+
 // AddCondition add a condition to a node
 func (this *Node) AddCondition(condition Condition) {
 	this.condition = condition
@@ -459,6 +485,12 @@ func (this *Node) AddSubAggregator(aggregator Aggregator) {
 	this.subAggregator = aggregator
 }
 
+// AddBranchAggregator add a foreach aggregator to a node which is also told the canceled branches, it is used
+// instead of the sub aggregator
+func (this *Node) AddBranchAggregator(aggregator BranchAggregator) {
+	this.branchAggr = aggregator
+}
+
 // AddForwarder adds a forwarder for a specific children
 func (this *Node) AddForwarder(children string, forwarder Forwarder) {
 	this.forwarder[children] = forwarder
@@ -541,6 +573,11 @@ func (this *Node) GetSubAggregator() Aggregator {
 	return this.subAggregator
 }
 
+// GetBranchAggregator gets the foreach aggregator told the canceled branches, nil if not set
+func (this *Node) GetBranchAggregator() BranchAggregator {
+	return this.branchAggr
+}
+
 // GetCondition get the condition function
 func (this *Node) GetCondition() Condition {
 	return this.condition
@@ -551,6 +588,24 @@ func (this *Node) GetForEach() ForEach {
 	return this.foreach
 }
 
+// GetForEachStream get the producer of the streaming foreach
+func (this *Node) GetForEachStream() ForEachStream {
+	return this.foreachStream
+}
+
+// IsForEach checks if the node is a foreach, streaming or not
+func (this *Node) IsForEach() bool {
+	return this.foreach != nil || this.foreachStream != nil
+}
+
+// MaxBranches returns the maximum of items the streaming foreach of the node emits
+func (this *Node) MaxBranches() int {
+	if this.maxBranches <= 0 {
+		return DefaultMaxStreamBranches
+	}
+	return this.maxBranches
+}
+
 // GetAllConditionalDags get all the subdags for all conditions
 func (this *Node) GetAllConditionalDags() map[string]*Dag {
 	return this.conditionalDags
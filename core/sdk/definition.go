@@ -66,7 +66,7 @@ func exportNode(exportNode *NodeExporter, node *Node) {
 			exportDag(exportNode.ConditionalDags[condition], sdag)
 		}
 	}
-	if node.GetForEach() != nil {
+	if node.IsForEach() {
 		exportNode.IsForeach = true
 		exportNode.ForeachDag = &DagExporter{}
 		exportDag(exportNode.ForeachDag, node.subDag)
@@ -77,7 +77,7 @@ func exportNode(exportNode *NodeExporter, node *Node) {
 	if node.aggregator != nil {
 		exportNode.HasAggregator = true
 	}
-	if node.subAggregator != nil {
+	if node.subAggregator != nil || node.branchAggr != nil {
 		exportNode.HasSubAggregator = true
 	}
 	if node.subDag != nil && !node.dynamic {
@@ -0,0 +1,361 @@
+package executor
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/yuyang0/goflow/core/sdk"
+)
+
+const (
+	// foreachExecutionCountKey counts the executions of the foreach nodes of a request, each one is registered
+	// under its own key for CancelBranches
+	foreachExecutionCountKey = "foreach-executions"
+
+	// branchCancelCheckInterval bounds how often a running node reads whether its branch was canceled, the state
+	// is cached by the execution in between
+	branchCancelCheckInterval = time.Second
+
+	// branchCancelIncrement is added to the settle counter of a branch by CancelBranches, the arrivals of the
+	// branch at the end of its foreach add 1, so that the counter tells which came first
+	branchCancelIncrement = 1 << 20
+)
+
+// BranchSelector selects the branches of the foreach nodes of a request, a branch matches if its index or the
+// hash of its input is listed. The branches of a streaming foreach are indexed in the order of emission and only
+// matched by index, the hashes of their inputs are not recorded
+type BranchSelector struct {
+	Node        string   // the id of the foreach node, every foreach node of the request when empty
+	Indices     []int    // the positions of the branches among the sorted keys returned by the foreach
+	InputHashes []string // the hashes of the inputs of the branches, see BranchInputHash
+}
+
+// CanceledBranch is a branch canceled with CancelBranches
+type CanceledBranch struct {
+	Node        string `json:"node"`
+	ExecutionId string `json:"execution_id"` // the foreach execution, prefixed with the keys of the enclosing branches
+	Index       int    `json:"index"`
+	Key         string `json:"key"`
+	InputHash   string `json:"input_hash"`
+}
+
+// BranchCanceler can be implemented by an Executor to let the branches of the foreach nodes of a flow be canceled
+// with CancelBranches. The foreach executions and the arrivals of their branches are only tracked then
+type BranchCanceler interface {
+	// CancelableBranches checks if the branches of the foreach nodes of the flow can be canceled
+	CancelableBranches() bool
+}
+
+// foreachExecution is an execution of a foreach node, registered under foreachExecutionKey
+type foreachExecution struct {
+	Node        string `json:"node"`
+	ExecutionId string `json:"execution_id"`
+	Stream      bool   `json:"stream,omitempty"`
+}
+
+// BranchInputHash returns the hash of the input of a foreach branch the BranchSelector matches
+func BranchInputHash(input []byte) string {
+	sum := sha256.Sum256(input)
+	return hex.EncodeToString(sum[:])
+}
+
+func (selector *BranchSelector) matches(index int, inputHash string) bool {
+	for _, i := range selector.Indices {
+		if i == index {
+			return true
+		}
+	}
+	for _, hash := range selector.InputHashes {
+		if hash == inputHash {
+			return true
+		}
+	}
+	return false
+}
+
+// branchCancelCheck is the cached cancellation state of a branch
+type branchCancelCheck struct {
+	canceled  bool
+	checkedAt time.Time
+}
+
+func foreachExecutionKey(idx int) string {
+	return fmt.Sprintf("%s-%d", foreachExecutionCountKey, idx)
+}
+
+func branchInputsKey(executionId string) string {
+	return executionId + "-dynamic-branch-inputs"
+}
+
+func branchSettledKey(executionId string, option string) string {
+	return fmt.Sprintf("%s-branch-settled-%s", executionId, option)
+}
+
+func canceledBranchesKey(executionId string) string {
+	return executionId + "-canceled-branches"
+}
+
+// cancelableBranches checks if the branches of the foreach nodes of the flow can be canceled
+func (fexec *FlowExecutor) cancelableBranches() bool {
+	canceler, ok := fexec.executor.(BranchCanceler)
+	return ok && canceler.CancelableBranches()
+}
+
+// tracksBranches checks if the arrivals of the branches of a foreach node are tracked, for CancelBranches and
+// for the streaming foreach which counts each branch once
+func (fexec *FlowExecutor) tracksBranches(node *sdk.Node) bool {
+	return node.GetForEachStream() != nil || fexec.cancelableBranches()
+}
+
+// registerForeach records an execution of a foreach node along with the hashes of the inputs of its branches,
+// under a key of its own so that the foreach executions don't contend
+func (fexec *FlowExecutor) registerForeach(node *sdk.Node, executionId string, inputs map[string][]byte) error {
+	if !fexec.cancelableBranches() {
+		return nil
+	}
+	if len(inputs) > 0 {
+		hashes := make(map[string]string, len(inputs))
+		for option, input := range inputs {
+			hashes[option] = BranchInputHash(input)
+		}
+		encoded, err := json.Marshal(hashes)
+		if err != nil {
+			return err
+		}
+		if err := fexec.stateStore.Set(branchInputsKey(executionId), string(encoded)); err != nil {
+			return err
+		}
+	}
+	execution, err := json.Marshal(&foreachExecution{Node: node.Id, ExecutionId: executionId,
+		Stream: node.GetForEachStream() != nil})
+	if err != nil {
+		return err
+	}
+	idx, err := fexec.incrementCounter(foreachExecutionCountKey, 1)
+	if err != nil {
+		return err
+	}
+	return fexec.stateStore.Set(foreachExecutionKey(idx), string(execution))
+}
+
+// appendStateList appends a value to a json list of the StateStore
+func (fexec *FlowExecutor) appendStateList(key string, value string) error {
+	var serr error
+	for i := 0; i < counterUpdateRetryCount; i++ {
+		values := []string{}
+		encoded, err := fexec.stateStore.Get(key)
+		if err != nil {
+			data, _ := json.Marshal(append(values, value))
+			// if doesn't exist try to create
+			err := fexec.stateStore.Set(key, string(data))
+			if err != nil {
+				serr = fmt.Errorf("failed to update %s, error %v", key, err)
+				continue
+			}
+			return nil
+		}
+
+		err = json.Unmarshal([]byte(encoded), &values)
+		if err != nil {
+			return fmt.Errorf("failed to update %s, error %v", key, err)
+		}
+		data, _ := json.Marshal(append(values, value))
+		err = fexec.stateStore.Update(key, encoded, string(data))
+		if err == nil {
+			return nil
+		}
+		serr = err
+	}
+	return fmt.Errorf("failed to update %s after max retry, error %v", key, serr)
+}
+
+// getStateList returns a json list of the StateStore, empty if it doesn't exist
+func (fexec *FlowExecutor) getStateList(key string) []string {
+	values := []string{}
+	encoded, err := fexec.stateStore.Get(key)
+	if err != nil {
+		return values
+	}
+	json.Unmarshal([]byte(encoded), &values)
+	return values
+}
+
+// canceledBranchDepth returns the depth of the outermost foreach whose branch being executed has been canceled,
+// -1 if none is. The state of a branch is read again once older than branchCancelCheckInterval, or right away when
+// fresh is set, a canceled branch stays canceled
+func (fexec *FlowExecutor) canceledBranchDepth(fresh bool) int {
+	pipeline := fexec.flow
+	dag := pipeline.Dag
+	optionStr := ""
+	for depth := 0; depth < pipeline.ExecutionDepth; depth++ {
+		node := dag.GetNode(pipeline.ExecutionPosition[strconv.Itoa(depth)])
+		option := pipeline.CurrentDynamicOption[node.GetUniqueId()]
+		if node.IsForEach() && fexec.tracksBranches(node) {
+			executionId := node.GetUniqueId()
+			if optionStr != "" {
+				executionId = optionStr + "--" + executionId
+			}
+			if fexec.branchCanceled(branchSettledKey(executionId, option), fresh) {
+				return depth
+			}
+		}
+		if node.SubDag() != nil {
+			dag = node.SubDag()
+		} else {
+			dag = node.GetConditionalDag(option)
+		}
+		if optionStr == "" {
+			optionStr = option
+		} else {
+			optionStr = option + "--" + optionStr
+		}
+	}
+	return -1
+}
+
+// branchCanceled checks if a branch was canceled from the settle counter of the branch, cached by the execution
+func (fexec *FlowExecutor) branchCanceled(settledKey string, fresh bool) bool {
+	fexec.branchCancelMu.Lock()
+	defer fexec.branchCancelMu.Unlock()
+	check, ok := fexec.branchCancelChecks[settledKey]
+	if ok && (check.canceled || (!fresh && time.Since(check.checkedAt) < branchCancelCheckInterval)) {
+		return check.canceled
+	}
+	settled, err := fexec.retrieveCounter(settledKey)
+	check = branchCancelCheck{canceled: err == nil && settled >= branchCancelIncrement, checkedAt: time.Now()}
+	if fexec.branchCancelChecks == nil {
+		fexec.branchCancelChecks = make(map[string]branchCancelCheck)
+	}
+	fexec.branchCancelChecks[settledKey] = check
+	return check.canceled
+}
+
+// leaveCanceledBranch moves the execution position of a canceled branch to the end of its foreach, so that the
+// remaining nodes of the branch are skipped
+func (fexec *FlowExecutor) leaveCanceledBranch(fresh bool) bool {
+	depth := fexec.canceledBranchDepth(fresh)
+	if depth < 0 {
+		return false
+	}
+	fexec.flow.ExecutionDepth = depth
+	fexec.log("[request `%s`] branch of node %s canceled, skipping to its end\n", fexec.id,
+		fexec.flow.ExecutionPosition[strconv.Itoa(depth)])
+	return true
+}
+
+// settleBranch records the arrival of a branch at the end of its foreach. It returns whether the branch was
+// canceled, and whether the arrival is to be counted, only the first arrival of a branch is. The arrivals are
+// only recorded when the branches are tracked, every arrival is counted otherwise
+func (fexec *FlowExecutor) settleBranch(node *sdk.Node, executionId string, option string) (canceled bool, counted bool, err error) {
+	if !fexec.tracksBranches(node) {
+		return false, true, nil
+	}
+	settled, err := fexec.incrementCounter(branchSettledKey(executionId, option), 1)
+	if err != nil {
+		return false, false, err
+	}
+	arrivals := settled % branchCancelIncrement
+	canceled = settled >= branchCancelIncrement
+	if canceled && arrivals == 1 && option != streamSealOption {
+		err = fexec.appendStateList(canceledBranchesKey(executionId), option)
+	}
+	return canceled, arrivals == 1, err
+}
+
+// canceledBranches returns the sorted keys of the branches of a foreach execution canceled with CancelBranches
+func (fexec *FlowExecutor) canceledBranches(node *sdk.Node, executionId string) []string {
+	if !fexec.cancelableBranches() || !node.IsForEach() {
+		return nil
+	}
+	canceled := fexec.getStateList(canceledBranchesKey(executionId))
+	sort.Strings(canceled)
+	return canceled
+}
+
+// CancelBranches cancels the branches of the foreach nodes of an active request matched by the selector. The
+// pending branches skip their nodes, the running ones see the context of their node interrupted, and the foreach
+// aggregates the results of the remaining branches. The branches that already completed are not canceled
+func (fexec *FlowExecutor) CancelBranches(reqId string, selector BranchSelector) ([]*CanceledBranch, error) {
+
+	fexec.executor.Configure(reqId)
+	fexec.flowName = fexec.executor.GetFlowName()
+	fexec.id = reqId
+	fexec.partial = true
+
+	// Init Stores: Get definition of StateStore and DataStore from user
+	_, _, err := fexec.initializeStore()
+	if err != nil {
+		return nil, fmt.Errorf("[request `%s`] Failed to init stores, %v", fexec.id, err)
+	}
+
+	if !fexec.cancelableBranches() {
+		return nil, fmt.Errorf("[request `%s`] Failed to cancel branches, the branches of flow %s are not cancelable",
+			fexec.id, fexec.flowName)
+	}
+	if !fexec.isActive() {
+		return nil, fmt.Errorf("[request `%s`] Failed to cancel branches, request is not active", fexec.id)
+	}
+
+	count, err := fexec.retrieveCounter(foreachExecutionCountKey)
+	if err != nil {
+		count = 0
+	}
+	canceled := []*CanceledBranch{}
+	// a foreach executed again, e.g. retried, is registered again
+	seen := make(map[string]bool)
+	for idx := 1; idx <= count; idx++ {
+		encoded, err := fexec.stateStore.Get(foreachExecutionKey(idx))
+		if err != nil {
+			continue
+		}
+		execution := &foreachExecution{}
+		if err := json.Unmarshal([]byte(encoded), execution); err != nil || seen[execution.ExecutionId] {
+			continue
+		}
+		seen[execution.ExecutionId] = true
+		if selector.Node != "" && selector.Node != execution.Node {
+			continue
+		}
+		var options []string
+		if execution.Stream {
+			options, err = fexec.forwardedStreamOptions(execution.ExecutionId)
+		} else {
+			options, err = fexec.getDynamicBranchOptions(execution.ExecutionId + "-dynamic-branch-options")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("[request `%s`] Failed to get branches of %s, error %v", fexec.id, execution.ExecutionId, err)
+		}
+		hashes := make(map[string]string)
+		if encoded, err := fexec.stateStore.Get(branchInputsKey(execution.ExecutionId)); err == nil {
+			json.Unmarshal([]byte(encoded), &hashes)
+		}
+
+		for index, option := range options {
+			if !selector.matches(index, hashes[option]) {
+				continue
+			}
+			settled, err := fexec.incrementCounter(branchSettledKey(execution.ExecutionId, option), branchCancelIncrement)
+			if err != nil {
+				return nil, fmt.Errorf("[request `%s`] Failed to cancel branch %s of %s, error %v", fexec.id, option,
+					execution.ExecutionId, err)
+			}
+			// the branch has already completed or been canceled
+			if settled != branchCancelIncrement {
+				continue
+			}
+			canceled = append(canceled, &CanceledBranch{
+				Node:        execution.Node,
+				ExecutionId: execution.ExecutionId,
+				Index:       index,
+				Key:         option,
+				InputHash:   hashes[option],
+			})
+		}
+	}
+	return canceled, nil
+}
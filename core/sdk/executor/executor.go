@@ -1,12 +1,16 @@
 package executor
 
 import (
+	"context"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"log"
 	"net/url"
+	"sort"
 	"strconv"
+	"sync"
+	"time"
 
 	hmac "github.com/alexellis/hmac"
 	xid "github.com/rs/xid"
@@ -81,6 +85,81 @@ type Executor interface {
 	ExecutionRuntime
 }
 
+// NodeInterrupter can be implemented by an Executor to interrupt the execution of a single node
+type NodeInterrupter interface {
+	// IsNodeInterrupted checks if the node of the request has been interrupted
+	IsNodeInterrupted(nodeId string, requestId string) bool
+	// ClearNodeInterrupt clears the interruption once the node has stopped
+	ClearNodeInterrupt(nodeId string, requestId string)
+}
+
+// RequestContextProvider can be implemented by an Executor to bound the execution of the nodes with a context,
+// e.g. canceled at the deadline of the request
+type RequestContextProvider interface {
+	// RequestContext returns the context the nodes of the request are executed with
+	RequestContext(requestId string) context.Context
+}
+
+// FailureDispatcher can be implemented by an Executor to run the failure handling of a request
+// asynchronously, the dispatched failure must be handled with HandleFailure
+type FailureDispatcher interface {
+	// DispatchFailure dispatches the failure handling of the request
+	DispatchFailure(requestId string, err error) error
+}
+
+// NodeDurationReporter can be implemented by an Executor to be notified of the time taken by every node execution
+type NodeDurationReporter interface {
+	// ReportNodeDuration reports the time taken by the operations of a node
+	ReportNodeDuration(nodeId string, requestId string, duration time.Duration)
+}
+
+// RequestOutcomeReporter can be implemented by an Executor to be notified of the completion or failure of every request
+type RequestOutcomeReporter interface {
+	// ReportRequestOutcome reports the end of a request, err is nil when the request completed successfully
+	ReportRequestOutcome(requestId string, err error)
+}
+
+// OutboxProvider can be implemented by an Executor to apply the side effects of the nodes exactly once
+type OutboxProvider interface {
+	// GetOutbox returns the outbox of an execution of the node of the request
+	GetOutbox(nodeId string, requestId string) sdk.Outbox
+}
+
+// NodeOutcomeReporter can be implemented by an Executor to be notified of the outcome of every node execution
+type NodeOutcomeReporter interface {
+	// ReportNodeOutcome reports a node execution along with its duration, err is nil when the node succeeded
+	ReportNodeOutcome(nodeId string, requestId string, duration time.Duration, err error)
+}
+
+// NodeOutputPublisher can be implemented by an Executor to publish the output of every node as it completes
+type NodeOutputPublisher interface {
+	// PublishNodeOutput publishes the output of a node that completed successfully
+	PublishNodeOutput(nodeId string, requestId string, output []byte)
+}
+
+// DataStoreScoper can be implemented by an Executor to keep the flat DataStore namespace of the requests, the
+// nodes get a view of the DataStore scoped to their id otherwise
+type DataStoreScoper interface {
+	// FlatDataStore checks if the nodes of the flow share the namespace of the request
+	FlatDataStore() bool
+}
+
+// MigrationState is the partial state of a request handed to a StateMigrator
+type MigrationState struct {
+	ExecutionState string            // the execution position of the request in the dag
+	Data           []byte            // the data forwarded to the next node, empty if kept in the DataStore
+	ContextStore   map[string][]byte // the values of the default DataStore, empty if an external DataStore is used
+}
+
+// StateMigrator can be implemented by an Executor to migrate the partial states produced by another version of
+// the flow, e.g. the in-flight and paused requests once a new version is deployed
+type StateMigrator interface {
+	// FlowVersion returns the version of the flow the partial states are stamped with
+	FlowVersion() string
+	// MigrateState transforms a partial state produced by the version oldVersion into the one of the current version
+	MigrateState(requestId string, state *MigrationState, oldVersion string) (*MigrationState, error)
+}
+
 // FlowExecutor goflow executor
 type FlowExecutor struct {
 	flow *sdk.Pipeline // the faas-flow
@@ -106,6 +185,9 @@ type FlowExecutor struct {
 
 	executor   Executor    // executor
 	notifyChan chan string // notify about execution complete, if not nil
+
+	branchCancelMu     sync.Mutex
+	branchCancelChecks map[string]branchCancelCheck // cancellation state of the branches, by settle counter
 }
 
 const (
@@ -115,9 +197,33 @@ const (
 )
 
 const (
-	RequestStateKey = "request-state"
+	RequestStateKey     = "request-state"
+	CurrentNodeKey      = "current-node"
+	CurrentNodeStartKey = "current-node-start"
 )
 
+// RequestState the state of a request along with the node it is executing
+type RequestState struct {
+	State         string `json:"state"`
+	CurrentNode   string `json:"current-node,omitempty"`
+	NodeStartTime int64  `json:"node-start-time,omitempty"` // unix time the current node started
+	// ExecutionSeconds is the execution budget consumed by the request, reported by the runtime
+	ExecutionSeconds float64 `json:"execution-seconds,omitempty"`
+	// Queue is the queue the request was last on, e.g. main or push-0, and QueueTransitionTime the unix time it
+	// last moved, reported by the runtime
+	Queue               string `json:"queue,omitempty"`
+	QueueTransitionTime int64  `json:"queue-transition-time,omitempty"`
+	// Annotations are the notes attached to the request by the operators, oldest first, reported by the runtime
+	Annotations []*Annotation `json:"annotations,omitempty"`
+}
+
+// Annotation is a note attached to a request by an operator
+type Annotation struct {
+	Author string    `json:"author"`
+	Text   string    `json:"text"`
+	Time   time.Time `json:"time"`
+}
+
 type ExecutionStateOptions struct {
 	newRequest   *RawRequest
 	partialState *PartialState
@@ -163,6 +269,42 @@ func (fexec *FlowExecutor) getRequestState() (string, error) {
 	return value, err
 }
 
+// setCurrentNode records the node being executed along with its start time
+func (fexec *FlowExecutor) setCurrentNode(nodeUniqueId string) {
+	err := fexec.stateStore.Set(CurrentNodeKey, nodeUniqueId)
+	if err == nil {
+		err = fexec.stateStore.Set(CurrentNodeStartKey, strconv.FormatInt(time.Now().Unix(), 10))
+	}
+	if err != nil {
+		fexec.log("[request `%s`] failed to record current node %s, error %v\n", fexec.id, nodeUniqueId, err)
+	}
+}
+
+// clearCurrentNode clears the node being executed once the execution is forwarded
+func (fexec *FlowExecutor) clearCurrentNode() {
+	err := fexec.stateStore.Set(CurrentNodeKey, "")
+	if err == nil {
+		err = fexec.stateStore.Set(CurrentNodeStartKey, "")
+	}
+	if err != nil {
+		fexec.log("[request `%s`] failed to clear current node, error %v\n", fexec.id, err)
+	}
+}
+
+// getCurrentNode get the node being executed and its start time
+func (fexec *FlowExecutor) getCurrentNode() (string, int64) {
+	node, err := fexec.stateStore.Get(CurrentNodeKey)
+	if err != nil || node == "" {
+		return "", 0
+	}
+	encoded, err := fexec.stateStore.Get(CurrentNodeStartKey)
+	if err != nil {
+		return node, 0
+	}
+	startTime, _ := strconv.ParseInt(encoded, 10, 64)
+	return node, startTime
+}
+
 // setDynamicBranchOptions set dynamic options for a dynamic node
 func (fexec *FlowExecutor) setDynamicBranchOptions(nodeUniqueId string, options []string) error {
 	encoded, err := json.Marshal(options)
@@ -324,20 +466,75 @@ func (fexec *FlowExecutor) isPaused() bool {
 }
 
 // executeNode  executes a node on a faas-flow dag
-func (fexec *FlowExecutor) executeNode(request []byte) ([]byte, error) {
-	var result []byte
-	var err error
-
+func (fexec *FlowExecutor) executeNode(request []byte) (result []byte, err error) {
 	pipeline := fexec.flow
 
 	currentNode, _ := pipeline.GetCurrentNodeDag()
 
 	// mark as start of node
+	fexec.setCurrentNode(currentNode.GetUniqueId())
 	if fexec.executor.MonitoringEnabled() {
 		fexec.eventHandler.ReportNodeStart(currentNode.GetUniqueId(), fexec.id)
 	}
 
+	if reporter, ok := fexec.executor.(NodeDurationReporter); ok {
+		start := time.Now()
+		defer func() {
+			reporter.ReportNodeDuration(currentNode.GetUniqueId(), fexec.id, time.Since(start))
+		}()
+	}
+
+	// a node stopped because the request is no longer active has no outcome
+	inactive := false
+	if reporter, ok := fexec.executor.(NodeOutcomeReporter); ok {
+		start := time.Now()
+		defer func() {
+			if !inactive {
+				reporter.ReportNodeOutcome(currentNode.GetUniqueId(), fexec.id, time.Since(start), err)
+			}
+		}()
+	}
+
+	// the node is interrupted with InterruptNode, or when the branch of a foreach it belongs to is canceled
+	nodeId := currentNode.GetUniqueId()
+	interrupter, interruptible := fexec.executor.(NodeInterrupter)
+	inBranch := pipeline.ExecutionDepth > 0
+	nodeContext := sdk.NewNodeContext(fexec.id, nodeId, func() bool {
+		if interruptible && interrupter.IsNodeInterrupted(nodeId, fexec.id) {
+			return true
+		}
+		return inBranch && fexec.canceledBranchDepth(false) >= 0
+	})
+	if interruptible {
+		defer func() {
+			if nodeContext.WasInterrupted() {
+				interrupter.ClearNodeInterrupt(nodeId, fexec.id)
+			}
+		}()
+	}
+	if provider, ok := fexec.executor.(OutboxProvider); ok {
+		nodeContext.SetOutbox(provider.GetOutbox(currentNode.GetUniqueId(), fexec.id))
+	}
+	nodeContext.SetDataStore(fexec.dataStore, fexec.flatDataStore())
+	if provider, ok := fexec.executor.(RequestContextProvider); ok {
+		nodeContext.SetContext(provider.RequestContext(fexec.id))
+	}
+
 	for _, operation := range currentNode.Operations() {
+		// Check if the request went beyond its deadline, or the node has been interrupted
+		cause := nodeContext.Context().Err()
+		if cause == nil && nodeContext.IsInterrupted() {
+			cause = sdk.ErrNodeInterrupted
+		}
+		if cause != nil {
+			err = fmt.Errorf("node(%s), Operation (%s), error: %w",
+				currentNode.GetUniqueId(), operation.GetId(), cause)
+			if fexec.executor.MonitoringEnabled() {
+				fexec.eventHandler.ReportOperationFailure(operation.GetId(), currentNode.GetUniqueId(), fexec.id, err)
+			}
+			return nil, err
+		}
+
 		// Check if request is terminate
 		if !fexec.isActive() {
 			fexec.log("[request `%s`] pipeline is not active\n", fexec.id)
@@ -355,6 +552,7 @@ func (fexec *FlowExecutor) executeNode(request []byte) ([]byte, error) {
 				}
 			}
 
+			inactive = true
 			return nil, fmt.Errorf("[request `%s`] pipeline is not active", fexec.id)
 		}
 
@@ -363,6 +561,12 @@ func (fexec *FlowExecutor) executeNode(request []byte) ([]byte, error) {
 		}
 
 		options := fexec.executor.GetExecutionOption(operation)
+		if nodeContext != nil {
+			if options == nil {
+				options = make(map[string]interface{})
+			}
+			options[sdk.NodeContextOption] = nodeContext
+		}
 
 		if result == nil {
 			result, err = operation.Execute(request, options)
@@ -373,7 +577,7 @@ func (fexec *FlowExecutor) executeNode(request []byte) ([]byte, error) {
 			if fexec.executor.MonitoringEnabled() {
 				fexec.eventHandler.ReportOperationFailure(operation.GetId(), currentNode.GetUniqueId(), fexec.id, err)
 			}
-			err = fmt.Errorf("node(%s), Operation (%s), error: execution failed, %v",
+			err = fmt.Errorf("node(%s), Operation (%s), error: execution failed, %w",
 				currentNode.GetUniqueId(), operation.GetId(), err)
 			return nil, err
 		}
@@ -384,6 +588,10 @@ func (fexec *FlowExecutor) executeNode(request []byte) ([]byte, error) {
 
 	fexec.log("[request `%s`] completed execution of node %s\n", fexec.id, currentNode.GetUniqueId())
 
+	if publisher, ok := fexec.executor.(NodeOutputPublisher); ok {
+		publisher.PublishNodeOutput(currentNode.GetUniqueId(), fexec.id, result)
+	}
+
 	return result, nil
 }
 
@@ -441,7 +649,7 @@ func (fexec *FlowExecutor) forwardState(currentNodeId string, nextNodeId string,
 	}
 
 	// Build request
-	uprequest := buildRequest(fexec.id, string(pipelineState), fexec.query, result, store, sign)
+	uprequest := buildRequest(fexec.id, string(pipelineState), fexec.query, result, store, sign, fexec.flowVersion())
 
 	if fexec.executor.MonitoringEnabled() {
 		fexec.eventHandler.ReportExecutionForward(currentNodeId, fexec.id)
@@ -449,6 +657,9 @@ func (fexec *FlowExecutor) forwardState(currentNodeId string, nextNodeId string,
 
 	partialState := &PartialState{uprequest: uprequest}
 
+	// the current node has finished once its execution is forwarded
+	fexec.clearCurrentNode()
+
 	var err error
 
 	if fexec.isPaused() {
@@ -474,6 +685,7 @@ func (fexec *FlowExecutor) executeDynamic(context *sdk.Context, result []byte) (
 	currentNode, _ := pipeline.GetCurrentNodeDag()
 
 	// trace node - mark as start of the dynamic node
+	fexec.setCurrentNode(currentNode.GetUniqueId())
 	if fexec.executor.MonitoringEnabled() {
 		fexec.eventHandler.ReportNodeStart(currentNode.GetUniqueId(),
 			fexec.id)
@@ -486,6 +698,11 @@ func (fexec *FlowExecutor) executeDynamic(context *sdk.Context, result []byte) (
 
 	fexec.log("[request `%s`] processing dynamic node %s\n", fexec.id, currentNodeUniqueId)
 
+	// the branches of a streaming foreach are forwarded as the producer emits them
+	if producer := currentNode.GetForEachStream(); producer != nil {
+		return fexec.executeForEachStream(context, currentNode, producer, result)
+	}
+
 	// sub results and sub dags
 	subresults := make(map[string][]byte)
 	subdags := make(map[string]*sdk.Dag)
@@ -531,6 +748,12 @@ func (fexec *FlowExecutor) executeDynamic(context *sdk.Context, result []byte) (
 			subresults[foreachKey] = foreachResult
 			options = append(options, foreachKey)
 		}
+		// the branches are indexed by their sorted keys for CancelBranches
+		sort.Strings(options)
+		executionId := pipeline.GetNodeExecutionUniqueId(currentNode)
+		if err := fexec.registerForeach(currentNode, executionId, subresults); err != nil {
+			return nil, fmt.Errorf("[request `%s`] Failed to register foreach %s, error %v", fexec.id, executionId, err)
+		}
 	}
 
 	branchCount := len(options)
@@ -562,50 +785,59 @@ func (fexec *FlowExecutor) executeDynamic(context *sdk.Context, result []byte) (
 		fexec.id, key)
 
 	for option, subdag := range subdags {
+		if err := fexec.forwardBranch(context, currentNode, option, subdag, subresults[option]); err != nil {
+			return nil, err
+		}
+	}
 
-		subNode := subdag.GetInitialNode()
-		intermediateData := subresults[option]
+	return []byte(""), nil
+}
 
-		// If forwarder is not nil its not an execution flow
-		if currentNode.GetForwarder("dynamic") != nil {
-			key := fmt.Sprintf("%s--%s--%s", option,
-				pipeline.GetNodeExecutionUniqueId(currentNode), subNode.GetUniqueId())
+// forwardBranch forwards the request to the initial node of a branch of a dynamic node
+func (fexec *FlowExecutor) forwardBranch(context *sdk.Context, currentNode *sdk.Node, option string, subdag *sdk.Dag,
+	intermediateData []byte) error {
+	pipeline := fexec.flow
+	currentNodeUniqueId := currentNode.GetUniqueId()
+	subNode := subdag.GetInitialNode()
 
-			serr := context.Set(key, intermediateData)
-			if serr != nil {
-				return []byte(""), fmt.Errorf("failed to store intermediate result, error %v", serr)
-			}
-			fexec.log("[request `%s`] intermediate result for option %s from Node %s to %s stored as %s\n",
-				fexec.id, option, currentNodeUniqueId, subNode.GetUniqueId(), key)
+	// If forwarder is not nil its not an execution flow
+	if currentNode.GetForwarder("dynamic") != nil {
+		key := fmt.Sprintf("%s--%s--%s", option,
+			pipeline.GetNodeExecutionUniqueId(currentNode), subNode.GetUniqueId())
 
-			// intermediateData is set to blank once its stored in storage
-			intermediateData = []byte("")
+		serr := context.Set(key, intermediateData)
+		if serr != nil {
+			return fmt.Errorf("failed to store intermediate result, error %v", serr)
 		}
+		fexec.log("[request `%s`] intermediate result for option %s from Node %s to %s stored as %s\n",
+			fexec.id, option, currentNodeUniqueId, subNode.GetUniqueId(), key)
 
-		// Increment the depth to execute the dynamic branch
-		pipeline.UpdatePipelineExecutionPosition(sdk.DEPTH_INCREMENT, subNode.Id)
-		// Set the option the dynamic branch is performing
-		pipeline.CurrentDynamicOption[currentNode.GetUniqueId()] = option
-
-		// forward the flow request
-		forwardErr := fexec.forwardState(currentNode.GetUniqueId(), subNode.GetUniqueId(),
-			intermediateData)
-		if forwardErr != nil {
-			// reset dag execution position
-			pipeline.UpdatePipelineExecutionPosition(sdk.DEPTH_DECREMENT, currentNode.Id)
-			return nil, fmt.Errorf("Node(%s): error: %v",
-				currentNode.GetUniqueId(), forwardErr)
-		}
+		// intermediateData is set to blank once its stored in storage
+		intermediateData = []byte("")
+	}
 
-		fexec.log("[request `%s`] request submitted for node %s option %s\n",
-			fexec.id, subNode.GetUniqueId(), option)
+	// Increment the depth to execute the dynamic branch
+	pipeline.UpdatePipelineExecutionPosition(sdk.DEPTH_INCREMENT, subNode.Id)
+	// Set the option the dynamic branch is performing
+	pipeline.CurrentDynamicOption[currentNode.GetUniqueId()] = option
 
-		// reset pipeline
+	// forward the flow request
+	forwardErr := fexec.forwardState(currentNode.GetUniqueId(), subNode.GetUniqueId(),
+		intermediateData)
+	if forwardErr != nil {
+		// reset dag execution position
 		pipeline.UpdatePipelineExecutionPosition(sdk.DEPTH_DECREMENT, currentNode.Id)
-		delete(pipeline.CurrentDynamicOption, currentNode.GetUniqueId())
+		return fmt.Errorf("Node(%s): error: %v",
+			currentNode.GetUniqueId(), forwardErr)
 	}
 
-	return []byte(""), nil
+	fexec.log("[request `%s`] request submitted for node %s option %s\n",
+		fexec.id, subNode.GetUniqueId(), option)
+
+	// reset pipeline
+	pipeline.UpdatePipelineExecutionPosition(sdk.DEPTH_DECREMENT, currentNode.Id)
+	delete(pipeline.CurrentDynamicOption, currentNode.GetUniqueId())
+	return nil
 }
 
 // findNextNodeToExecute find the next node(s) to execute after the current node
@@ -678,25 +910,47 @@ func (fexec *FlowExecutor) handleDynamicEnd(context *sdk.Context, result []byte)
 	pipeline := fexec.flow
 	currentNode, _ := pipeline.GetCurrentNodeDag()
 
-	// Get dynamic options computed for the current dynamic node
+	// the branches of a streaming foreach are counted until the producer seals their count
+	stream := currentNode.GetForEachStream() != nil
+
+	// Get dynamic options computed for the current dynamic node, the ones of a streaming foreach once sealed
 	key := pipeline.GetNodeExecutionUniqueId(currentNode) + "-dynamic-branch-options"
-	options, err := fexec.getDynamicBranchOptions(key)
-	if err != nil {
-		return nil, fmt.Errorf("failed to retrive dynamic options for %v, error %v",
-			currentNode.GetUniqueId(), err)
+	var options []string
+	var err error
+	if !stream {
+		options, err = fexec.getDynamicBranchOptions(key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to retrive dynamic options for %v, error %v",
+				currentNode.GetUniqueId(), err)
+		}
 	}
 	// Get unique execution id of the node
 	branchkey := pipeline.GetNodeExecutionUniqueId(currentNode) + "-branch-completion"
 
+	// a branch canceled with CancelBranches is counted once, without its result
+	canceled := false
+	if currentNode.IsForEach() {
+		option := pipeline.CurrentDynamicOption[currentNode.GetUniqueId()]
+		var counted bool
+		canceled, counted, err = fexec.settleBranch(currentNode, pipeline.GetNodeExecutionUniqueId(currentNode), option)
+		if err != nil {
+			return nil, fmt.Errorf("failed to settle branch of dynamic node %s for option %s, error %v",
+				currentNode.GetUniqueId(), option, err)
+		}
+		if !counted {
+			return nil, nil
+		}
+	}
+
 	// if in-degree is > 1 then use state-store to get in-degree completion state
-	if len(options) > 1 {
+	if stream || len(options) > 1 {
 
 		// Get unique execution id of the node
 		key = "-branch-completion" + pipeline.GetNodeExecutionUniqueId(currentNode)
 		// Update the state of in-degree completion and get the updated state
 
 		// Skip if dynamic node data forwarding is not disabled
-		if currentNode.GetForwarder("dynamic") != nil {
+		if currentNode.GetForwarder("dynamic") != nil && !canceled {
 			option := pipeline.CurrentDynamicOption[currentNode.GetUniqueId()]
 			key = fmt.Sprintf("%s--%s--%s", option, pipeline.GetNodeExecutionUniqueId(currentNode), currentNode.GetUniqueId())
 
@@ -713,11 +967,25 @@ func (fexec *FlowExecutor) handleDynamicEnd(context *sdk.Context, result []byte)
 			return []byte(""), fmt.Errorf("failed to update inDegree counter for node %s", currentNode.GetUniqueId())
 		}
 
+		expected := len(options)
+		if stream {
+			// 0 while the producer is emitting, or if it died before finishing
+			expected, err = fexec.retrieveCounter(streamBranchCountKey(pipeline.GetNodeExecutionUniqueId(currentNode)))
+			if err != nil {
+				return nil, fmt.Errorf("failed to get branch count of foreach stream %s, error %v",
+					currentNode.GetUniqueId(), err)
+			}
+			if expected > 0 {
+				// the seal branch is counted along with the emitted ones
+				options = streamOptions(expected - 1)
+			}
+		}
+
 		fexec.log("[request `%s`] executing end of dynamic node %s, completed in-degree: %d/%d\n",
-			fexec.id, currentNode.GetUniqueId(), realIndegree, len(options))
+			fexec.id, currentNode.GetUniqueId(), realIndegree, expected)
 
 		//not last branch return
-		if realIndegree < len(options) {
+		if expected == 0 || realIndegree < expected {
 			return nil, nil
 		}
 	} else {
@@ -732,17 +1000,25 @@ func (fexec *FlowExecutor) handleDynamicEnd(context *sdk.Context, result []byte)
 		return []byte(""), nil
 	}
 
+	canceledBranches := fexec.canceledBranches(currentNode, pipeline.GetNodeExecutionUniqueId(currentNode))
+	canceledOptions := make(map[string]bool, len(canceledBranches))
+	for _, option := range canceledBranches {
+		canceledOptions[option] = true
+	}
+
 	subDataMap := make(map[string][]byte)
 	// Store the current data
-	subDataMap[currentOption] = result
+	if !canceled {
+		subDataMap[currentOption] = result
+	}
 
 	// Receive data from a dynamic graph for each options
 	for _, option := range options {
 		key := fmt.Sprintf("%s--%s--%s",
 			option, pipeline.GetNodeExecutionUniqueId(currentNode), currentNode.GetUniqueId())
 
-		// skip retrieving data for current option
-		if option == currentOption {
+		// skip retrieving data for current option, and the canceled branches which have none
+		if option == currentOption || canceledOptions[option] {
 			context.Del(key)
 			continue
 		}
@@ -759,8 +1035,13 @@ func (fexec *FlowExecutor) handleDynamicEnd(context *sdk.Context, result []byte)
 	// Get SubAggregator and call
 	fexec.log("[request `%s`] executing aggregator of dynamic node %s\n",
 		fexec.id, currentNode.GetUniqueId())
-	aggregator := currentNode.GetSubAggregator()
-	data, serr := aggregator(subDataMap)
+	var data []byte
+	var serr error
+	if branchAggregator := currentNode.GetBranchAggregator(); branchAggregator != nil {
+		data, serr = branchAggregator(subDataMap, canceledBranches)
+	} else {
+		data, serr = currentNode.GetSubAggregator()(subDataMap)
+	}
 	if serr != nil {
 		serr := fmt.Errorf("failed to aggregate dynamic node data, error %v", serr)
 		return nil, serr
@@ -861,11 +1142,46 @@ func (fexec *FlowExecutor) handleNextNodes(context *sdk.Context, result []byte)
 	return []byte(""), nil
 }
 
-// handleFailure handles failure with failure handler and call finally
+// handleFailure handles failure with failure handler and call finally,
+// the handlers are dispatched to the FailureDispatcher when the executor implements it
 func (fexec *FlowExecutor) handleFailure(context *sdk.Context, err error) {
+	context.State = sdk.StateFailure
+	fexec.finished = true
+
+	dispatched := false
+	if dispatcher, ok := fexec.executor.(FailureDispatcher); ok &&
+		(fexec.flow.FailureHandler != nil || fexec.flow.Finally != nil) {
+		// the state is kept for the failure handling, mark it finished so that no other node gets executed
+		derr := fexec.setRequestState(STATE_FINISHED)
+		if derr == nil {
+			derr = dispatcher.DispatchFailure(fexec.id, err)
+		}
+		if derr != nil {
+			fexec.log("[request `%s`] failed to dispatch failure, handling it inline, %v\n", fexec.id, derr)
+		} else {
+			fexec.log("[request `%s`] failure dispatched\n", fexec.id)
+			dispatched = true
+		}
+	}
+	if !dispatched {
+		fexec.processFailure(err)
+	}
+	if reporter, ok := fexec.executor.(RequestOutcomeReporter); ok {
+		reporter.ReportRequestOutcome(fexec.id, err)
+	}
+
+	if fexec.executor.MonitoringEnabled() {
+		fexec.eventHandler.ReportRequestFailure(fexec.id, err)
+		fexec.eventHandler.Flush()
+	}
+
+	fmt.Sprintf("[request `%s`] Failed, %v\n", fexec.id, err)
+}
+
+// processFailure calls the failure handler and finally, then cleans up the request
+func (fexec *FlowExecutor) processFailure(err error) {
 	var data []byte
 
-	context.State = sdk.StateFailure
 	// call failure handler if available
 	if fexec.flow.FailureHandler != nil {
 		fexec.log("[request `%s`] calling failure handler for error, %v\n",
@@ -873,8 +1189,6 @@ func (fexec *FlowExecutor) handleFailure(context *sdk.Context, err error) {
 		data, err = fexec.flow.FailureHandler(err)
 	}
 
-	fexec.finished = true
-
 	// call finally handler if available
 	if fexec.flow.Finally != nil {
 		fexec.log("[request `%s`] calling Finally handler with state: %s\n",
@@ -890,13 +1204,6 @@ func (fexec *FlowExecutor) handleFailure(context *sdk.Context, err error) {
 		fexec.stateStore.Cleanup()
 	}
 	fexec.dataStore.Cleanup()
-
-	if fexec.executor.MonitoringEnabled() {
-		fexec.eventHandler.ReportRequestFailure(fexec.id, err)
-		fexec.eventHandler.Flush()
-	}
-
-	fmt.Sprintf("[request `%s`] Failed, %v\n", fexec.id, err)
 }
 
 // getDagIntermediateData gets the intermediate data from earlier vertex
@@ -1021,6 +1328,49 @@ func (fexec *FlowExecutor) initializeStore() (stateSDefined bool, dataSOverride
 	return
 }
 
+// flatDataStore checks if the nodes share the namespace of the request rather than using scoped views
+func (fexec *FlowExecutor) flatDataStore() bool {
+	scoper, ok := fexec.executor.(DataStoreScoper)
+	return ok && scoper.FlatDataStore()
+}
+
+// flowVersion returns the version of the flow the partial states are stamped with, empty if the executor has none
+func (fexec *FlowExecutor) flowVersion() string {
+	migrator, ok := fexec.executor.(StateMigrator)
+	if !ok {
+		return ""
+	}
+	return migrator.FlowVersion()
+}
+
+// migratePartialState migrates a partial state produced by another version of the flow with the StateMigrator,
+// the states of an unknown version are kept as is
+func (fexec *FlowExecutor) migratePartialState(request *Request) error {
+	migrator, ok := fexec.executor.(StateMigrator)
+	if !ok || request.Version == "" {
+		return nil
+	}
+	version := migrator.FlowVersion()
+	if version == "" || version == request.Version {
+		return nil
+	}
+
+	state := &MigrationState{
+		ExecutionState: request.ExecutionState,
+		Data:           request.Data,
+		ContextStore:   request.ContextStore,
+	}
+	state, err := migrator.MigrateState(request.ID, state, request.Version)
+	if err != nil {
+		return fmt.Errorf("failed to migrate partial request from version %s, error %v", request.Version, err)
+	}
+	request.ExecutionState = state.ExecutionState
+	request.Data = state.Data
+	request.ContextStore = state.ContextStore
+	request.Version = version
+	return nil
+}
+
 // createContext create a context from request handler
 func (fexec *FlowExecutor) createContext() *sdk.Context {
 	context := sdk.CreateContext(fexec.id, "",
@@ -1084,7 +1434,7 @@ func (fexec *FlowExecutor) init() ([]byte, error) {
 			if err != nil {
 				return nil, fmt.Errorf("failed to initiate logger, error %v", err)
 			}
-			fexec.logger.Configure(requestId, fexec.flowName)
+			fexec.logger.Configure(fexec.flowName, requestId)
 			err = fexec.logger.Init()
 			if err != nil {
 				return nil, fmt.Errorf("failed to initiate logger, error %v", err)
@@ -1111,6 +1461,11 @@ func (fexec *FlowExecutor) init() ([]byte, error) {
 		requestId = request.getID()
 		fexec.executor.Configure(requestId)
 
+		err = fexec.migratePartialState(request)
+		if err != nil {
+			return nil, err
+		}
+
 		fexec.flowName = fexec.executor.GetFlowName()
 		fexec.flow = sdk.CreatePipeline()
 		fexec.flow.ApplyState(request.getExecutionState())
@@ -1138,7 +1493,7 @@ func (fexec *FlowExecutor) init() ([]byte, error) {
 			if err != nil {
 				return nil, fmt.Errorf("failed to initiate logger, error %v", err)
 			}
-			fexec.logger.Configure(requestId, fexec.flowName)
+			fexec.logger.Configure(fexec.flowName, requestId)
 			err = fexec.logger.Init()
 			if err != nil {
 				return nil, fmt.Errorf("failed to initiate logger, error %v", err)
@@ -1198,7 +1553,7 @@ func (fexec *FlowExecutor) Execute(state ExecutionStateOption) ([]byte, error) {
 	// Init Stores: Get definition of StateStore and DataStore from user
 	stateSDefined, dataSOverride, err := fexec.initializeStore()
 	if err != nil {
-		return nil, fmt.Errorf("[request `%s`] Failed to init flow, %v", fexec.id, err)
+		return nil, fmt.Errorf("[request `%s`] Failed to init flow, %w", fexec.id, err)
 	}
 
 	// Make Context: make the request context from flow
@@ -1207,13 +1562,13 @@ func (fexec *FlowExecutor) Execute(state ExecutionStateOption) ([]byte, error) {
 	// Get Definition: Get Pipeline definition from user implemented Define()
 	err = fexec.executor.GetFlowDefinition(fexec.flow, context)
 	if err != nil {
-		return nil, fmt.Errorf("[request `%s`] Failed to define flow, %v", fexec.id, err)
+		return nil, fmt.Errorf("[request `%s`] Failed to define flow, %w", fexec.id, err)
 	}
 
 	// Validate Definition: Validate Pipeline Definition
 	err = fexec.flow.Dag.Validate()
 	if err != nil {
-		return nil, fmt.Errorf("[request `%s`] Invalid dag, %v", fexec.id, err)
+		return nil, fmt.Errorf("[request `%s`] Invalid dag, %w", fexec.id, err)
 	}
 
 	// Check Configuration: Check if executor is properly configured to execute flow
@@ -1246,7 +1601,7 @@ func (fexec *FlowExecutor) Execute(state ExecutionStateOption) ([]byte, error) {
 		// For a new dag pipeline that has edges Create the vertex in stateStore
 		serr := fexec.setRequestState(STATE_RUNNING)
 		if serr != nil {
-			return nil, fmt.Errorf("[request `%s`] Failed to mark dag state, error %v", fexec.id, serr)
+			return nil, fmt.Errorf("[request `%s`] Failed to mark dag state, error %w", fexec.id, serr)
 		}
 		fexec.log("[request `%s`] dag state initiated at StateStore\n", fexec.id)
 
@@ -1262,7 +1617,7 @@ func (fexec *FlowExecutor) Execute(state ExecutionStateOption) ([]byte, error) {
 		// Get intermediate data from data store
 		data, gerr = fexec.getDagIntermediateData(context)
 		if gerr != nil {
-			gerr := fmt.Errorf("failed to retrive intermediate result, error %v", gerr)
+			gerr := fmt.Errorf("failed to retrive intermediate result, error %w", gerr)
 			fexec.log("[request `%s`] Failed: %v\n", fexec.id, gerr)
 			fexec.handleFailure(context, gerr)
 			return nil, gerr
@@ -1274,9 +1629,12 @@ func (fexec *FlowExecutor) Execute(state ExecutionStateOption) ([]byte, error) {
 	currentNode, _ := fexec.flow.GetCurrentNodeDag()
 	var result []byte
 
+	// a branch canceled with CancelBranches skips its remaining nodes to the end of its foreach
+	canceled := fexec.flow.ExecutionDepth > 0 && fexec.leaveCanceledBranch(false)
+
 	switch {
 	// Execute the dynamic node
-	case currentNode.Dynamic():
+	case currentNode.Dynamic() && !canceled:
 		result, err = fexec.executeDynamic(context, data)
 		if err != nil {
 			fexec.log("[request `%s`] failed: %v\n", fexec.id, err)
@@ -1285,15 +1643,24 @@ func (fexec *FlowExecutor) Execute(state ExecutionStateOption) ([]byte, error) {
 		}
 		// Execute the node
 	default:
-		result, err = fexec.executeNode(data)
-		if err != nil {
-			fexec.log("[request `%s`] failed: %v\n", fexec.id, err)
-			fexec.handleFailure(context, err)
-			return nil, err
+		if !canceled {
+			result, err = fexec.executeNode(data)
+			// the node may have been interrupted as its branch was canceled
+			if err != nil && fexec.flow.ExecutionDepth > 0 && fexec.leaveCanceledBranch(true) {
+				canceled = true
+			} else if err != nil {
+				fexec.log("[request `%s`] failed: %v\n", fexec.id, err)
+				fexec.handleFailure(context, err)
+				return nil, err
+			}
 		}
 
 		// Find the right node to execute next
-		fexec.findNextNodeToExecute()
+		if !canceled {
+			fexec.findNextNodeToExecute()
+		} else {
+			result = []byte("")
+		}
 
 	NodeCompletionLoop:
 		for !fexec.finished {
@@ -1358,6 +1725,9 @@ func (fexec *FlowExecutor) Execute(state ExecutionStateOption) ([]byte, error) {
 		if err != nil {
 			fexec.log("[request `%s`] completion handler failed, error %v\n", fexec.id, err)
 		}
+		if reporter, ok := fexec.executor.(RequestOutcomeReporter); ok {
+			reporter.ReportRequestOutcome(fexec.id, nil)
+		}
 		if fexec.notifyChan != nil {
 			fexec.notifyChan <- fexec.id
 		}
@@ -1406,6 +1776,45 @@ func (fexec *FlowExecutor) Stop(reqId string) error {
 	return nil
 }
 
+// HandleFailure calls the failure handler and finally of a failed request dispatched
+// by the FailureDispatcher, then cleans up the request
+func (fexec *FlowExecutor) HandleFailure(reqId string, cause error) error {
+
+	fexec.executor.Configure(reqId)
+	fexec.flowName = fexec.executor.GetFlowName()
+	fexec.id = reqId
+	fexec.partial = true
+	fexec.flow = sdk.CreatePipeline()
+	fexec.dataStore = createDataStore()
+
+	// Init Stores: Get definition of StateStore and DataStore from user
+	_, _, err := fexec.initializeStore()
+	if err != nil {
+		return fmt.Errorf("[request `%s`] Failed to init stores, %v", fexec.id, err)
+	}
+
+	if fexec.executor.LoggingEnabled() {
+		fexec.logger, err = fexec.executor.GetLogger()
+		if err != nil {
+			return fmt.Errorf("failed to initiate logger, error %v", err)
+		}
+		fexec.logger.Configure(fexec.flowName, reqId)
+		err = fexec.logger.Init()
+		if err != nil {
+			return fmt.Errorf("failed to initiate logger, error %v", err)
+		}
+	}
+
+	// Get Definition: the handlers are part of the pipeline definition
+	err = fexec.executor.GetFlowDefinition(fexec.flow, fexec.createContext())
+	if err != nil {
+		return fmt.Errorf("[request `%s`] Failed to define flow, %v", fexec.id, err)
+	}
+
+	fexec.processFailure(cause)
+	return nil
+}
+
 // Pause pauses an active dag execution
 func (fexec *FlowExecutor) Pause(reqId string) error {
 
@@ -1488,6 +1897,20 @@ func (fexec *FlowExecutor) GetState(reqId string) (string, error) {
 	return state, nil
 }
 
+// GetRequestState returns the state of the request along with the node it is executing
+func (fexec *FlowExecutor) GetRequestState(reqId string) (*RequestState, error) {
+	state, err := fexec.GetState(reqId)
+	if err != nil {
+		return nil, err
+	}
+
+	requestState := &RequestState{State: state}
+	if state != STATE_FINISHED {
+		requestState.CurrentNode, requestState.NodeStartTime = fexec.getCurrentNode()
+	}
+	return requestState, nil
+}
+
 // CreateFlowExecutor initiate a FlowExecutor with a provided Executor
 func CreateFlowExecutor(executor Executor, notifyChan chan string) (fexec *FlowExecutor) {
 	fexec = &FlowExecutor{executor: executor, notifyChan: notifyChan}
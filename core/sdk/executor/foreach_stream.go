@@ -0,0 +1,152 @@
+package executor
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/yuyang0/goflow/core/sdk"
+)
+
+// streamSealOption is the branch forwarded once the producer of a streaming foreach has finished. It is canceled
+// upfront, so its nodes are skipped and its arrival only tells the end of the foreach that the count is sealed
+const streamSealOption = "sealed"
+
+// streamBranchCountKey holds the count of branches the end of a streaming foreach waits for, including the seal
+// branch. It is 0 until the producer has finished
+func streamBranchCountKey(executionId string) string {
+	return executionId + "-stream-branch-count"
+}
+
+// streamForwardedKey holds the count of the branches forwarded by the producer of a streaming foreach
+func streamForwardedKey(executionId string) string {
+	return executionId + "-stream-forwarded"
+}
+
+// streamOptions returns the options of the first count branches of a streaming foreach, keyed by their emission index
+func streamOptions(count int) []string {
+	options := make([]string, count)
+	for idx := range options {
+		options[idx] = strconv.Itoa(idx)
+	}
+	return options
+}
+
+// forwardedStreamOptions returns the options of the branches forwarded so far by the producer of a streaming foreach
+func (fexec *FlowExecutor) forwardedStreamOptions(executionId string) ([]string, error) {
+	forwarded, err := fexec.incrementCounter(streamForwardedKey(executionId), 0)
+	if err != nil {
+		return nil, err
+	}
+	return streamOptions(forwarded), nil
+}
+
+// executeForEachStream executes the producer of a streaming foreach, a branch is forwarded for each item as soon as
+// it is emitted. The branches already forwarded by a previous execution of the node are not forwarded again, and
+// the end of the foreach can't complete until the producer has finished and sealed the count of branches.
+// The branches are identified by their emission index, a producer executed again is assumed to emit the same items
+// in the same order
+func (fexec *FlowExecutor) executeForEachStream(context *sdk.Context, currentNode *sdk.Node,
+	producer sdk.ForEachStream, input []byte) ([]byte, error) {
+	pipeline := fexec.flow
+	currentNodeUniqueId := currentNode.GetUniqueId()
+	executionId := pipeline.GetNodeExecutionUniqueId(currentNode)
+
+	fexec.log("[request `%s`] executing foreach stream\n", fexec.id)
+
+	// the counters are initialized to 0 by the first execution, and kept by the next ones
+	forwarded, err := fexec.incrementCounter(streamForwardedKey(executionId), 0)
+	if err != nil {
+		return nil, fmt.Errorf("[request `%s`] Failed to get forwarded branches of foreach stream %s, error %v",
+			fexec.id, currentNodeUniqueId, err)
+	}
+	if forwarded == 0 {
+		if err := fexec.registerForeach(currentNode, executionId, nil); err != nil {
+			return nil, fmt.Errorf("[request `%s`] Failed to register foreach %s, error %v", fexec.id, executionId, err)
+		}
+	} else {
+		fexec.log("[request `%s`] foreach stream %s resumed, %d branches already forwarded\n",
+			fexec.id, executionId, forwarded)
+	}
+	if _, err := fexec.incrementCounter(executionId+"-branch-completion", 0); err != nil {
+		return nil, fmt.Errorf("[request `%s`] Failed to initiate dynamic in-degree count for %s, err %v",
+			fexec.id, executionId, err)
+	}
+	if _, err := fexec.incrementCounter(streamBranchCountKey(executionId), 0); err != nil {
+		return nil, fmt.Errorf("[request `%s`] Failed to initiate branch count of foreach stream %s, err %v",
+			fexec.id, currentNodeUniqueId, err)
+	}
+
+	subdag := currentNode.SubDag()
+	maxBranches := currentNode.MaxBranches()
+	var mutex sync.Mutex
+	emitted := 0
+	exceeded := false
+	finished := false
+	emit := func(item []byte) error {
+		mutex.Lock()
+		defer mutex.Unlock()
+		// no more item is emitted once the producer has returned
+		if finished {
+			return sdk.ErrNoEmitter
+		}
+		if emitted >= maxBranches {
+			exceeded = true
+			return sdk.ErrTooManyBranches
+		}
+		option := strconv.Itoa(emitted)
+		emitted++
+		if emitted <= forwarded {
+			return nil
+		}
+		// counted once forwarded, a branch forwarded again after a crash in between is only counted once
+		if err := fexec.forwardBranch(context, currentNode, option, subdag, item); err != nil {
+			return err
+		}
+		_, err := fexec.incrementCounter(streamForwardedKey(executionId), 1)
+		return err
+	}
+
+	nodeContext := sdk.NewNodeContext(fexec.id, currentNodeUniqueId, func() bool {
+		interrupter, ok := fexec.executor.(NodeInterrupter)
+		return ok && interrupter.IsNodeInterrupted(currentNodeUniqueId, fexec.id)
+	})
+	nodeContext.SetDataStore(fexec.dataStore, fexec.flatDataStore())
+	nodeContext.SetEmitter(emit)
+
+	err = producer(nodeContext, input)
+	mutex.Lock()
+	finished = true
+	mutex.Unlock()
+	switch {
+	case err != nil:
+		return nil, fmt.Errorf("[request `%s`] Foreach stream %s failed after %d branches, error %v",
+			fexec.id, currentNodeUniqueId, emitted, err)
+	case exceeded:
+		return nil, fmt.Errorf("[request `%s`] Foreach stream %s, error %v, %d branches",
+			fexec.id, currentNodeUniqueId, sdk.ErrTooManyBranches, maxBranches)
+	case emitted == 0:
+		return nil, fmt.Errorf("[request `%s`] Dynamic Node %s, failed to execute as foreach stream emitted no item",
+			fexec.id, currentNodeUniqueId)
+	case emitted < forwarded:
+		return nil, fmt.Errorf("[request `%s`] Foreach stream %s emitted %d items, %d were already forwarded",
+			fexec.id, currentNodeUniqueId, emitted, forwarded)
+	}
+	// seal the count of branches, the seal branch is counted along with the emitted ones
+	err = fexec.stateStore.Set(streamBranchCountKey(executionId), strconv.Itoa(emitted+1))
+	if err != nil {
+		return nil, fmt.Errorf("[request `%s`] Failed to seal branch count of foreach stream %s, error %v",
+			fexec.id, currentNodeUniqueId, err)
+	}
+	_, err = fexec.incrementCounter(branchSettledKey(executionId, streamSealOption), branchCancelIncrement)
+	if err != nil {
+		return nil, fmt.Errorf("[request `%s`] Failed to seal foreach stream %s, error %v",
+			fexec.id, currentNodeUniqueId, err)
+	}
+	if err := fexec.forwardBranch(context, currentNode, streamSealOption, subdag, []byte("")); err != nil {
+		return nil, err
+	}
+	fexec.log("[request `%s`] foreach stream %s sealed with %d branches\n", fexec.id, currentNodeUniqueId, emitted)
+
+	return []byte(""), nil
+}
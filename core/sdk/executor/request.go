@@ -18,6 +18,8 @@ type Request struct {
 
 	ContextStore map[string][]byte `json: "store"` // Context State for default DataStore
 	// (empty if external Store is used)
+
+	Version string `json:"version"` // version of the flow the state was produced by (empty if unknown)
 }
 
 func buildRequest(id string,
@@ -25,7 +27,8 @@ func buildRequest(id string,
 	query string,
 	data []byte,
 	contextState map[string][]byte,
-	sign string) *Request {
+	sign string,
+	version string) *Request {
 
 	request := &Request{
 		Sign:           sign,
@@ -34,6 +37,7 @@ func buildRequest(id string,
 		Query:          query,
 		Data:           data,
 		ContextStore:   contextState,
+		Version:        version,
 	}
 	return request
 }
@@ -0,0 +1,43 @@
+package sdk
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/PaesslerAG/jsonpath"
+)
+
+// DefaultConditionCase denotes the case selected when no other case matches
+const DefaultConditionCase = "*"
+
+// NewJSONPathConditionNode returns a Condition that evaluates a JSONPath expression (e.g. `$.status`)
+// on the data and selects the branch mapped to the resulting value in cases.
+// The branch mapped to DefaultConditionCase is selected when no case matches or the
+// expression can't be evaluated, no branch is selected if there is no default
+func NewJSONPathConditionNode(path string, cases map[string]string) Condition {
+	return func(data []byte) []string {
+		value, err := evaluateJSONPath(path, data)
+		if err == nil {
+			if branch, ok := cases[value]; ok {
+				return []string{branch}
+			}
+		}
+		if branch, ok := cases[DefaultConditionCase]; ok {
+			return []string{branch}
+		}
+		return []string{}
+	}
+}
+
+// evaluateJSONPath returns the value of the JSONPath expression on the json data in its string form
+func evaluateJSONPath(path string, data []byte) (string, error) {
+	var document interface{}
+	if err := json.Unmarshal(data, &document); err != nil {
+		return "", fmt.Errorf("failed to parse data as json, error %v", err)
+	}
+	value, err := jsonpath.Get(path, document)
+	if err != nil {
+		return "", fmt.Errorf("failed to evaluate jsonpath %s, error %v", path, err)
+	}
+	return fmt.Sprint(value), nil
+}
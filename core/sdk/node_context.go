@@ -0,0 +1,141 @@
+package sdk
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+)
+
+// NodeContextOption is the execution option holding the NodeContext of the executed node
+const NodeContextOption = "node-context"
+
+var (
+	// ErrNodeInterrupted is returned by a node that stopped because it was interrupted,
+	// the flow handles it as a failure of the node
+	ErrNodeInterrupted = errors.New("node interrupted")
+	// ErrNoEmitter is returned when an item is emitted outside of the producer of a streaming foreach
+	ErrNoEmitter = errors.New("no streaming foreach to emit item")
+	// ErrTooManyBranches is returned when a streaming foreach emits more items than its maximum of branches
+	ErrTooManyBranches = errors.New("streaming foreach exceeded its maximum of branches")
+)
+
+// NodeContext provides the state of a node execution to the node workload
+type NodeContext struct {
+	requestId   string
+	nodeId      string
+	ctx         context.Context // bounded by the deadline of the request
+	interrupt   func() bool
+	interrupted atomic.Bool
+	outbox      Outbox
+	store       DataStore // the DataStore of the request
+	flatStore   bool      // the node shares the namespace of the request
+	emit        func([]byte) error
+}
+
+// NewNodeContext creates the context of a node execution, interrupt reports whether the node has been interrupted
+func NewNodeContext(requestId string, nodeId string, interrupt func() bool) *NodeContext {
+	return &NodeContext{
+		requestId: requestId,
+		nodeId:    nodeId,
+		interrupt: interrupt,
+	}
+}
+
+// GetRequestId returns the request id
+func (nodeContext *NodeContext) GetRequestId() string {
+	return nodeContext.requestId
+}
+
+// GetNodeId returns the unique id of the executed node
+func (nodeContext *NodeContext) GetNodeId() string {
+	return nodeContext.nodeId
+}
+
+// SetContext sets the context of the request the node is executed for
+func (nodeContext *NodeContext) SetContext(ctx context.Context) {
+	nodeContext.ctx = ctx
+}
+
+// Context returns the context of the request, it is done once the request goes beyond its deadline.
+// A long running node should pass it to its calls, or stop once it is done
+func (nodeContext *NodeContext) Context() context.Context {
+	if nodeContext == nil || nodeContext.ctx == nil {
+		return context.Background()
+	}
+	return nodeContext.ctx
+}
+
+// SetOutbox sets the outbox the side effects of the node are applied with
+func (nodeContext *NodeContext) SetOutbox(outbox Outbox) {
+	nodeContext.outbox = outbox
+}
+
+// SetEmitter sets the function starting a branch of the streaming foreach the node produces
+func (nodeContext *NodeContext) SetEmitter(emit func([]byte) error) {
+	nodeContext.emit = emit
+}
+
+// SetDataStore sets the DataStore of the request, the node gets a view of it scoped to its id unless flat is set
+func (nodeContext *NodeContext) SetDataStore(store DataStore, flat bool) {
+	nodeContext.store = store
+	nodeContext.flatStore = flat
+}
+
+// Store returns the DataStore of the node, its keys are prefixed with the id of the node so that they don't
+// collide with the ones of the other nodes. Use RequestStore to share values between the nodes
+func (nodeContext *NodeContext) Store() DataStore {
+	if nodeContext == nil || nodeContext.store == nil {
+		return nil
+	}
+	if nodeContext.flatStore {
+		return nodeContext.store
+	}
+	return NewScopedDataStore(nodeContext.store, nodeContext.nodeId)
+}
+
+// RequestStore returns the DataStore of the request, shared by all its nodes
+func (nodeContext *NodeContext) RequestStore() DataStore {
+	if nodeContext == nil {
+		return nil
+	}
+	return nodeContext.store
+}
+
+// ApplyEffect applies a side effect of the node exactly once across the redeliveries of the request,
+// the result of the effect is returned by the later executions of the node instead of applying it again
+func (nodeContext *NodeContext) ApplyEffect(effect SideEffect) ([]byte, error) {
+	if nodeContext == nil || nodeContext.outbox == nil {
+		return nil, ErrNoOutbox
+	}
+	return nodeContext.outbox.Apply(effect)
+}
+
+// Emit starts a branch of the streaming foreach produced by the node with the item as its input, without
+// waiting for the other items. It returns ErrTooManyBranches once the maximum of branches is reached
+func (nodeContext *NodeContext) Emit(item []byte) error {
+	if nodeContext == nil || nodeContext.emit == nil {
+		return ErrNoEmitter
+	}
+	return nodeContext.emit(item)
+}
+
+// IsInterrupted returns true once the node has been interrupted, a long running node should
+// check it regularly and return ErrNodeInterrupted
+func (nodeContext *NodeContext) IsInterrupted() bool {
+	if nodeContext == nil {
+		return false
+	}
+	if nodeContext.interrupted.Load() {
+		return true
+	}
+	if nodeContext.interrupt != nil && nodeContext.interrupt() {
+		nodeContext.interrupted.Store(true)
+		return true
+	}
+	return false
+}
+
+// WasInterrupted returns true if the interruption has been observed during the node execution
+func (nodeContext *NodeContext) WasInterrupted() bool {
+	return nodeContext != nil && nodeContext.interrupted.Load()
+}
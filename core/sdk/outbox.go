@@ -0,0 +1,32 @@
+package sdk
+
+import (
+	"errors"
+)
+
+var (
+	// ErrEffectInDoubt is returned when a side effect was recorded but the execution stopped before its outcome,
+	// and it can't be reconciled as the SideEffect has no Reconcile
+	ErrEffectInDoubt = errors.New("side effect in doubt")
+	// ErrNoOutbox is returned when a side effect is applied outside of a runtime providing an Outbox
+	ErrNoOutbox = errors.New("no outbox to apply side effect")
+)
+
+// SideEffect is an effectful operation of a node, e.g. a write to an external system, applied once per token
+// across the redeliveries of the request
+type SideEffect struct {
+	// Token identifies the effect within the node, e.g. the id of the record written
+	Token string
+	// Apply performs the effect, the token should be passed to the external system as an idempotency key
+	// when supported. Its result is returned by the later executions of the node instead of applying it again
+	Apply func(token string) ([]byte, error)
+	// Reconcile reports whether an effect which outcome is unknown was applied, along with its result, by
+	// querying the external system. The effect is applied again if not. Without it such an effect fails the
+	// node with ErrEffectInDoubt
+	Reconcile func(token string) (result []byte, applied bool, err error)
+}
+
+// Outbox applies the side effects of a node execution exactly once, it records each effect before applying it
+type Outbox interface {
+	Apply(effect SideEffect) ([]byte, error)
+}
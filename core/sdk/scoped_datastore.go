@@ -0,0 +1,77 @@
+package sdk
+
+import (
+	"fmt"
+	"io"
+)
+
+// ScopedDataStore is a view of a DataStore whose keys are prefixed with a scope, e.g. the id of a node, so that
+// the keys of different scopes don't collide. The underlying store is configured, initialized and cleaned up by
+// its owner, not through the view
+type ScopedDataStore struct {
+	store DataStore
+	scope string
+}
+
+// NewScopedDataStore creates a view of the store scoped to scope
+func NewScopedDataStore(store DataStore, scope string) *ScopedDataStore {
+	return &ScopedDataStore{store: store, scope: scope}
+}
+
+// ScopedKey returns the key of the underlying store a key of a scope is stored at
+func ScopedKey(scope string, key string) string {
+	return fmt.Sprintf("node:%s:%s", scope, key)
+}
+
+// Scope returns the scope of the view
+func (view *ScopedDataStore) Scope() string {
+	return view.scope
+}
+
+// Configure is a no-op, the underlying store is configured by its owner
+func (view *ScopedDataStore) Configure(flowName string, requestId string) {
+}
+
+// Init is a no-op, the underlying store is initialized by its owner
+func (view *ScopedDataStore) Init() error {
+	return nil
+}
+
+// Set stores a value for a key of the scope
+func (view *ScopedDataStore) Set(key string, value []byte) error {
+	return view.store.Set(ScopedKey(view.scope, key), value)
+}
+
+// Get retrieves the value of a key of the scope
+func (view *ScopedDataStore) Get(key string) ([]byte, error) {
+	return view.store.Get(ScopedKey(view.scope, key))
+}
+
+// Del deletes a key of the scope
+func (view *ScopedDataStore) Del(key string) error {
+	return view.store.Del(ScopedKey(view.scope, key))
+}
+
+// Cleanup is a no-op, the resources of the request are cleaned up by the owner of the underlying store
+func (view *ScopedDataStore) Cleanup() error {
+	return nil
+}
+
+// CopyStore returns a view of a copy of the underlying store
+func (view *ScopedDataStore) CopyStore() (DataStore, error) {
+	store, err := view.store.CopyStore()
+	if err != nil {
+		return nil, err
+	}
+	return NewScopedDataStore(store, view.scope), nil
+}
+
+// GetReader returns a reader of the value of a key of the scope, streamed if the underlying store streams
+func (view *ScopedDataStore) GetReader(key string) (io.ReadCloser, error) {
+	return GetReader(view.store, ScopedKey(view.scope, key))
+}
+
+// SetReader stores the content of the reader as the value of a key of the scope
+func (view *ScopedDataStore) SetReader(key string, reader io.Reader) error {
+	return SetReader(view.store, ScopedKey(view.scope, key), reader)
+}
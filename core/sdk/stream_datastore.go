@@ -0,0 +1,41 @@
+package sdk
+
+import (
+	"bytes"
+	"io"
+)
+
+// StreamingDataStore can be implemented by a DataStore to read and write large values
+// without loading them fully in memory
+type StreamingDataStore interface {
+	// GetReader returns a reader of the value of key, the reader must be closed
+	GetReader(key string) (io.ReadCloser, error)
+	// SetReader stores the content of the reader as the value of key
+	SetReader(key string, reader io.Reader) error
+}
+
+// GetReader returns a reader of the value of key, the value is buffered with Get
+// when the DataStore doesn't implement StreamingDataStore
+func GetReader(store DataStore, key string) (io.ReadCloser, error) {
+	if streaming, ok := store.(StreamingDataStore); ok {
+		return streaming.GetReader(key)
+	}
+	value, err := store.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(value)), nil
+}
+
+// SetReader stores the content of the reader as the value of key, the content is buffered
+// and stored with Set when the DataStore doesn't implement StreamingDataStore
+func SetReader(store DataStore, key string, reader io.Reader) error {
+	if streaming, ok := store.(StreamingDataStore); ok {
+		return streaming.SetReader(key, reader)
+	}
+	value, err := io.ReadAll(reader)
+	if err != nil {
+		return err
+	}
+	return store.Set(key, value)
+}
@@ -81,3 +81,9 @@ type Logger interface {
 	// Log logs a flow log
 	Log(str string)
 }
+
+// Pinger is implemented by the stores that can check the connection to their backend
+type Pinger interface {
+	// Ping returns an error if the backend can't be reached
+	Ping() error
+}
@@ -0,0 +1,210 @@
+// Package dag is a typed model of the flow definitions exported by the runtime, for the tools
+// inspecting the nodes and edges of a flow such as validators and visualizers
+package dag
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/yuyang0/goflow/core/sdk"
+)
+
+// DAG is a flow definition, or a sub dag of one of its nodes
+type DAG struct {
+	ID            string           `json:"id"`
+	StartNode     string           `json:"start_node"`
+	EndNode       string           `json:"end_node"`
+	HasBranch     bool             `json:"has_branch"`
+	ExecutionOnly bool             `json:"execution_only"` // no data is forwarded between the nodes
+	Nodes         map[string]*Node `json:"nodes"`
+	Edges         []Edge           `json:"edges"` // ordered by the index of their nodes
+
+	Valid           bool   `json:"valid"`
+	ValidationError string `json:"validation_error,omitempty"`
+}
+
+// Node is a node of a DAG along with its operations and its sub dags
+type Node struct {
+	ID       string `json:"id"`
+	UniqueID string `json:"unique_id"` // the id of the intermediate data and state of the node
+	Index    int    `json:"index"`     // the order the node was defined in
+
+	Dynamic         bool `json:"dynamic"`
+	DynamicExecOnly bool `json:"dynamic_exec_only"`
+	Condition       bool `json:"condition"`
+	Foreach         bool `json:"foreach"`
+	Aggregator      bool `json:"aggregator"`
+	SubAggregator   bool `json:"sub_aggregator"`
+	InDegree        int  `json:"in_degree"`
+	OutDegree       int  `json:"out_degree"`
+
+	Operations      []Operation     `json:"operations,omitempty"`
+	SubDAG          *DAG            `json:"sub_dag,omitempty"`
+	ForeachDAG      *DAG            `json:"foreach_dag,omitempty"`
+	ConditionalDAGs map[string]*DAG `json:"conditional_dags,omitempty"` // keyed by condition
+	Children        []string        `json:"children,omitempty"`
+}
+
+// Operation is an operation of a node
+type Operation struct {
+	Name       string              `json:"name"`
+	Properties map[string][]string `json:"properties,omitempty"`
+}
+
+// Edge connects a node to one of its children
+type Edge struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	// ExecutionOnly is set when the child only runs after the node, without receiving its data
+	ExecutionOnly bool `json:"execution_only"`
+}
+
+// Parse parses a flow definition exported by the runtime
+func Parse(definition []byte) (*DAG, error) {
+	exported := &sdk.DagExporter{}
+	if err := json.Unmarshal(definition, exported); err != nil {
+		return nil, fmt.Errorf("failed to parse flow definition, error %v", err)
+	}
+	return FromExporter(exported), nil
+}
+
+// FromExporter builds a DAG from an exported definition
+func FromExporter(exported *sdk.DagExporter) *DAG {
+	if exported == nil {
+		return nil
+	}
+	dag := &DAG{
+		ID:              exported.Id,
+		StartNode:       exported.StartNode,
+		EndNode:         exported.EndNode,
+		HasBranch:       exported.HasBranch,
+		ExecutionOnly:   exported.ExecutionOnlyDag,
+		Nodes:           make(map[string]*Node, len(exported.Nodes)),
+		Valid:           exported.IsValid,
+		ValidationError: exported.ValidationError,
+	}
+	for id, exportedNode := range exported.Nodes {
+		dag.Nodes[id] = fromNodeExporter(exportedNode)
+	}
+
+	for _, node := range dag.SortedNodes() {
+		children := make([]string, len(node.Children))
+		copy(children, node.Children)
+		sort.SliceStable(children, func(i, j int) bool {
+			return dag.index(children[i]) < dag.index(children[j])
+		})
+		for _, child := range children {
+			dag.Edges = append(dag.Edges, Edge{
+				From:          node.ID,
+				To:            child,
+				ExecutionOnly: exported.Nodes[node.ID].ChildrenExecOnly[child],
+			})
+		}
+	}
+	return dag
+}
+
+func fromNodeExporter(exported *sdk.NodeExporter) *Node {
+	node := &Node{
+		ID:              exported.Id,
+		UniqueID:        exported.UniqueId,
+		Index:           exported.Index,
+		Dynamic:         exported.IsDynamic,
+		DynamicExecOnly: exported.DynamicExecOnly,
+		Condition:       exported.IsCondition,
+		Foreach:         exported.IsForeach,
+		Aggregator:      exported.HasAggregator,
+		SubAggregator:   exported.HasSubAggregator,
+		InDegree:        exported.InDegree,
+		OutDegree:       exported.OutDegree,
+		SubDAG:          FromExporter(exported.SubDag),
+		ForeachDAG:      FromExporter(exported.ForeachDag),
+		Children:        exported.Children,
+	}
+	for _, operation := range exported.Operations {
+		node.Operations = append(node.Operations, Operation{Name: operation.Name, Properties: operation.Properties})
+	}
+	if len(exported.ConditionalDags) > 0 {
+		node.ConditionalDAGs = make(map[string]*DAG, len(exported.ConditionalDags))
+		for condition, conditionalDag := range exported.ConditionalDags {
+			node.ConditionalDAGs[condition] = FromExporter(conditionalDag)
+		}
+	}
+	return node
+}
+
+func (dag *DAG) index(id string) int {
+	if node, ok := dag.Nodes[id]; ok {
+		return node.Index
+	}
+	return -1
+}
+
+// Node returns a node of the DAG, the nodes of the sub dags are not included
+func (dag *DAG) Node(id string) (*Node, bool) {
+	node, ok := dag.Nodes[id]
+	return node, ok
+}
+
+// SortedNodes returns the nodes in the order they were defined
+func (dag *DAG) SortedNodes() []*Node {
+	nodes := make([]*Node, 0, len(dag.Nodes))
+	for _, node := range dag.Nodes {
+		nodes = append(nodes, node)
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		if nodes[i].Index != nodes[j].Index {
+			return nodes[i].Index < nodes[j].Index
+		}
+		return nodes[i].ID < nodes[j].ID
+	})
+	return nodes
+}
+
+// Parents returns the ids of the nodes with an edge to a node
+func (dag *DAG) Parents(id string) []string {
+	var parents []string
+	for _, edge := range dag.Edges {
+		if edge.To == id {
+			parents = append(parents, edge.From)
+		}
+	}
+	return parents
+}
+
+// SubDAGs returns the sub dags of a node, the conditional ones ordered by condition
+func (node *Node) SubDAGs() []*DAG {
+	var dags []*DAG
+	if node.SubDAG != nil {
+		dags = append(dags, node.SubDAG)
+	}
+	if node.ForeachDAG != nil {
+		dags = append(dags, node.ForeachDAG)
+	}
+	conditions := make([]string, 0, len(node.ConditionalDAGs))
+	for condition := range node.ConditionalDAGs {
+		conditions = append(conditions, condition)
+	}
+	sort.Strings(conditions)
+	for _, condition := range conditions {
+		dags = append(dags, node.ConditionalDAGs[condition])
+	}
+	return dags
+}
+
+// Walk calls fn on every node of the DAG and of its sub dags, depth first in the order the nodes
+// were defined, along with the DAG the node belongs to. The walk stops when fn returns false
+func (dag *DAG) Walk(fn func(dag *DAG, node *Node) bool) bool {
+	for _, node := range dag.SortedNodes() {
+		if !fn(dag, node) {
+			return false
+		}
+		for _, subDag := range node.SubDAGs() {
+			if !subDag.Walk(fn) {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -19,6 +19,11 @@ func (eh *GoFlowEventHandler) Configure(flowName string, requestID string) {
 	eh.flowName = flowName
 }
 
+// SetHeader sets the header of the request the trace context is extracted from on continuation
+func (eh *GoFlowEventHandler) SetHeader(header map[string][]string) {
+	eh.Header = header
+}
+
 func (eh *GoFlowEventHandler) Init() error {
 	var err error
 
@@ -0,0 +1,338 @@
+package eventhandler
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yuyang0/goflow/core/sdk"
+	"github.com/yuyang0/goflow/metrics"
+)
+
+const (
+	KafkaSerializationJSON = "json"
+	// KafkaSerializationAvro encodes the events with the avro schema registered in the schema registry,
+	// in the wire format of the confluent serializers
+	KafkaSerializationAvro = "avro"
+
+	DefaultKafkaBufferSize   = 10000
+	DefaultKafkaBatchSize    = 100
+	DefaultKafkaBatchTimeout = time.Second
+	KafkaWriteTimeout        = 10 * time.Second
+
+	EventRequestStart          = "request_start"
+	EventRequestEnd            = "request_end"
+	EventRequestFailure        = "request_failure"
+	EventExecutionForward      = "execution_forward"
+	EventExecutionContinuation = "execution_continuation"
+	EventNodeStart             = "node_start"
+	EventNodeEnd               = "node_end"
+	EventNodeFailure           = "node_failure"
+	EventOperationStart        = "operation_start"
+	EventOperationEnd          = "operation_end"
+	EventOperationFailure      = "operation_failure"
+
+	DropReasonOverflow = "overflow"
+	DropReasonFailed   = "failed"
+)
+
+// eventAvroSchema is the avro schema of Event, the optional fields are empty strings
+const eventAvroSchema = `{"type":"record","name":"Event","namespace":"goflow","fields":[` +
+	`{"name":"type","type":"string"},{"name":"flow","type":"string"},{"name":"request_id","type":"string"},` +
+	`{"name":"node_id","type":"string"},{"name":"operation_id","type":"string"},{"name":"error","type":"string"},` +
+	`{"name":"time","type":{"type":"long","logicalType":"timestamp-millis"}}]}`
+
+var droppedEventsCounter = metrics.NewCounterVec("goflow_kafka_dropped_events_total",
+	"Events not written to kafka as the buffer was full or the write failed", "topic", "reason")
+
+// KafkaMessage is a message of a batch written to kafka
+type KafkaMessage struct {
+	Topic string
+	Key   []byte
+	Value []byte
+}
+
+// KafkaProducer writes batches of messages to the brokers, e.g. a kafka-go Writer wrapped to convert the messages
+type KafkaProducer interface {
+	WriteMessages(ctx context.Context, msgs ...KafkaMessage) error
+}
+
+// KafkaConfig is the configuration of a KafkaEventSink
+type KafkaConfig struct {
+	Topic             string
+	Producer          KafkaProducer
+	Serialization     string // json if not set
+	SchemaRegistryURL string // registry of the avro schema, required with avro
+	BufferSize        int    // events buffered before they are dropped, DefaultKafkaBufferSize if not set
+	BatchSize         int
+	BatchTimeout      time.Duration // maximum time an event waits for its batch to fill up
+}
+
+// Event is a lifecycle event of a request
+type Event struct {
+	Type        string    `json:"type"`
+	Flow        string    `json:"flow"`
+	RequestID   string    `json:"request_id"`
+	NodeID      string    `json:"node_id,omitempty"`
+	OperationID string    `json:"operation_id,omitempty"`
+	Error       string    `json:"error,omitempty"`
+	Time        time.Time `json:"time"`
+}
+
+// KafkaEventSink writes the lifecycle events to a kafka topic in batches. The events are buffered
+// so that their emission never blocks the execution, they are dropped when the buffer is full
+type KafkaEventSink struct {
+	config   KafkaConfig
+	schemaID uint32
+	events   chan *Event
+
+	closeOnce sync.Once
+	stop      chan struct{}
+	stopped   chan struct{}
+}
+
+// NewKafkaEventSink creates a sink and starts writing its events, the avro schema is registered first
+func NewKafkaEventSink(config KafkaConfig) (*KafkaEventSink, error) {
+	if config.Topic == "" || config.Producer == nil {
+		return nil, fmt.Errorf("topic and producer must be provided to write events to kafka")
+	}
+	if config.Serialization == "" {
+		config.Serialization = KafkaSerializationJSON
+	}
+	if config.BufferSize <= 0 {
+		config.BufferSize = DefaultKafkaBufferSize
+	}
+	if config.BatchSize <= 0 {
+		config.BatchSize = DefaultKafkaBatchSize
+	}
+	if config.BatchTimeout <= 0 {
+		config.BatchTimeout = DefaultKafkaBatchTimeout
+	}
+
+	sink := &KafkaEventSink{
+		config:  config,
+		events:  make(chan *Event, config.BufferSize),
+		stop:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	switch config.Serialization {
+	case KafkaSerializationJSON:
+	case KafkaSerializationAvro:
+		schemaID, err := registerAvroSchema(config.SchemaRegistryURL, config.Topic+"-value")
+		if err != nil {
+			return nil, err
+		}
+		sink.schemaID = schemaID
+	default:
+		return nil, fmt.Errorf("invalid serialization %s of kafka events", config.Serialization)
+	}
+
+	go sink.run()
+	return sink, nil
+}
+
+// EventHandler returns a handler emitting the lifecycle events to the sink
+func (sink *KafkaEventSink) EventHandler() sdk.EventHandler {
+	return &KafkaEventHandler{sink: sink}
+}
+
+// Close writes the buffered events and stops the sink
+func (sink *KafkaEventSink) Close() {
+	sink.closeOnce.Do(func() {
+		close(sink.stop)
+	})
+	<-sink.stopped
+}
+
+func (sink *KafkaEventSink) emit(event *Event) {
+	select {
+	case sink.events <- event:
+	default:
+		droppedEventsCounter.Inc(sink.config.Topic, DropReasonOverflow)
+	}
+}
+
+func (sink *KafkaEventSink) run() {
+	defer close(sink.stopped)
+
+	ticker := time.NewTicker(sink.config.BatchTimeout)
+	defer ticker.Stop()
+	batch := make([]*Event, 0, sink.config.BatchSize)
+	flush := func() {
+		if len(batch) > 0 {
+			sink.write(batch)
+			batch = batch[:0]
+		}
+	}
+	for {
+		select {
+		case event := <-sink.events:
+			batch = append(batch, event)
+			if len(batch) >= sink.config.BatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-sink.stop:
+			for len(sink.events) > 0 {
+				batch = append(batch, <-sink.events)
+				if len(batch) >= sink.config.BatchSize {
+					flush()
+				}
+			}
+			flush()
+			return
+		}
+	}
+}
+
+func (sink *KafkaEventSink) write(batch []*Event) {
+	msgs := make([]KafkaMessage, 0, len(batch))
+	for _, event := range batch {
+		value, err := sink.encode(event)
+		if err != nil {
+			droppedEventsCounter.Inc(sink.config.Topic, DropReasonFailed)
+			continue
+		}
+		// the events of a request are kept in order in a partition
+		msgs = append(msgs, KafkaMessage{Topic: sink.config.Topic, Key: []byte(event.RequestID), Value: value})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), KafkaWriteTimeout)
+	defer cancel()
+	if err := sink.config.Producer.WriteMessages(ctx, msgs...); err != nil {
+		droppedEventsCounter.Add(float64(len(msgs)), sink.config.Topic, DropReasonFailed)
+	}
+}
+
+func (sink *KafkaEventSink) encode(event *Event) ([]byte, error) {
+	if sink.config.Serialization == KafkaSerializationJSON {
+		return json.Marshal(event)
+	}
+
+	// magic byte and schema id of the confluent wire format
+	buf := []byte{0, 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(buf[1:], sink.schemaID)
+	for _, value := range []string{event.Type, event.Flow, event.RequestID, event.NodeID, event.OperationID, event.Error} {
+		buf = binary.AppendVarint(buf, int64(len(value)))
+		buf = append(buf, value...)
+	}
+	buf = binary.AppendVarint(buf, event.Time.UnixMilli())
+	return buf, nil
+}
+
+// registerAvroSchema registers the avro schema of the events under a subject of the schema registry, it returns
+// the id of the schema
+func registerAvroSchema(registryURL string, subject string) (uint32, error) {
+	if registryURL == "" {
+		return 0, fmt.Errorf("schema registry url must be provided to write avro events")
+	}
+	data, _ := json.Marshal(map[string]string{"schema": eventAvroSchema})
+	url := fmt.Sprintf("%s/subjects/%s/versions", strings.TrimSuffix(registryURL, "/"), subject)
+	client := &http.Client{Timeout: KafkaWriteTimeout}
+	res, err := client.Post(url, "application/vnd.schemaregistry.v1+json", bytes.NewReader(data))
+	if err != nil {
+		return 0, fmt.Errorf("failed to register avro schema, error %v", err)
+	}
+	defer res.Body.Close()
+	resData, _ := ioutil.ReadAll(res.Body)
+	if res.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("failed to register avro schema, registry returned %d: %s", res.StatusCode, string(resData))
+	}
+
+	registered := struct {
+		ID uint32 `json:"id"`
+	}{}
+	if err := json.Unmarshal(resData, &registered); err != nil {
+		return 0, fmt.Errorf("failed to parse schema registry response, error %v", err)
+	}
+	return registered.ID, nil
+}
+
+// KafkaEventHandler emits the lifecycle events of a request to a KafkaEventSink, see KafkaEventSink.EventHandler
+type KafkaEventHandler struct {
+	sink     *KafkaEventSink
+	flowName string
+}
+
+func (eh *KafkaEventHandler) Configure(flowName string, requestID string) {
+	eh.flowName = flowName
+}
+
+func (eh *KafkaEventHandler) Init() error {
+	return nil
+}
+
+func (eh *KafkaEventHandler) Copy() (sdk.EventHandler, error) {
+	return &KafkaEventHandler{sink: eh.sink}, nil
+}
+
+func (eh *KafkaEventHandler) emit(eventType string, requestID string, nodeID string, operationID string, err error) {
+	event := &Event{
+		Type:        eventType,
+		Flow:        eh.flowName,
+		RequestID:   requestID,
+		NodeID:      nodeID,
+		OperationID: operationID,
+		Time:        time.Now(),
+	}
+	if err != nil {
+		event.Error = err.Error()
+	}
+	eh.sink.emit(event)
+}
+
+func (eh *KafkaEventHandler) ReportRequestStart(requestID string) {
+	eh.emit(EventRequestStart, requestID, "", "", nil)
+}
+
+func (eh *KafkaEventHandler) ReportRequestEnd(requestID string) {
+	eh.emit(EventRequestEnd, requestID, "", "", nil)
+}
+
+func (eh *KafkaEventHandler) ReportRequestFailure(requestID string, err error) {
+	eh.emit(EventRequestFailure, requestID, "", "", err)
+}
+
+func (eh *KafkaEventHandler) ReportExecutionForward(nodeID string, requestID string) {
+	eh.emit(EventExecutionForward, requestID, nodeID, "", nil)
+}
+
+func (eh *KafkaEventHandler) ReportExecutionContinuation(requestID string) {
+	eh.emit(EventExecutionContinuation, requestID, "", "", nil)
+}
+
+func (eh *KafkaEventHandler) ReportNodeStart(nodeID string, requestID string) {
+	eh.emit(EventNodeStart, requestID, nodeID, "", nil)
+}
+
+func (eh *KafkaEventHandler) ReportNodeEnd(nodeID string, requestID string) {
+	eh.emit(EventNodeEnd, requestID, nodeID, "", nil)
+}
+
+func (eh *KafkaEventHandler) ReportNodeFailure(nodeID string, requestID string, err error) {
+	eh.emit(EventNodeFailure, requestID, nodeID, "", err)
+}
+
+func (eh *KafkaEventHandler) ReportOperationStart(operationID string, nodeID string, requestID string) {
+	eh.emit(EventOperationStart, requestID, nodeID, operationID, nil)
+}
+
+func (eh *KafkaEventHandler) ReportOperationEnd(operationID string, nodeID string, requestID string) {
+	eh.emit(EventOperationEnd, requestID, nodeID, operationID, nil)
+}
+
+func (eh *KafkaEventHandler) ReportOperationFailure(operationID string, nodeID string, requestID string, err error) {
+	eh.emit(EventOperationFailure, requestID, nodeID, operationID, err)
+}
+
+// Flush does nothing, the events are written by the sink in the background
+func (eh *KafkaEventHandler) Flush() {
+}
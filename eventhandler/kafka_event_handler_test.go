@@ -0,0 +1,188 @@
+package eventhandler_test
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/yuyang0/goflow/eventhandler"
+	"github.com/yuyang0/goflow/metrics"
+)
+
+// fakeProducer records the batches written to kafka, it blocks the writes until released when release is set
+type fakeProducer struct {
+	mu      sync.Mutex
+	batches [][]eventhandler.KafkaMessage
+	release chan struct{}
+	err     error
+}
+
+func (producer *fakeProducer) WriteMessages(ctx context.Context, msgs ...eventhandler.KafkaMessage) error {
+	if producer.release != nil {
+		<-producer.release
+	}
+	producer.mu.Lock()
+	defer producer.mu.Unlock()
+	producer.batches = append(producer.batches, msgs)
+	return producer.err
+}
+
+func (producer *fakeProducer) written() [][]eventhandler.KafkaMessage {
+	producer.mu.Lock()
+	defer producer.mu.Unlock()
+	return producer.batches
+}
+
+func droppedEvents(topic string, reason string) float64 {
+	return metrics.Snapshot()[fmt.Sprintf("goflow_kafka_dropped_events_total_%s_%s", topic, reason)]
+}
+
+// TestKafkaEventSinkWritesBatches checks that the events are written in batches of BatchSize, keyed by their request,
+// and that closing the sink writes the last batch
+func TestKafkaEventSinkWritesBatches(t *testing.T) {
+	producer := &fakeProducer{}
+	sink, err := eventhandler.NewKafkaEventSink(eventhandler.KafkaConfig{
+		Topic: "batched", Producer: producer, BatchSize: 2, BatchTimeout: time.Hour,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := sink.EventHandler()
+	handler.Configure("flow", "request")
+	handler.ReportRequestStart("request")
+	handler.ReportNodeStart("node", "request")
+	handler.ReportNodeEnd("node", "request")
+	handler.ReportNodeFailure("next", "request", fmt.Errorf("failed"))
+	handler.ReportRequestFailure("request", fmt.Errorf("failed"))
+	sink.Close()
+
+	batches := producer.written()
+	if len(batches) != 3 || len(batches[0]) != 2 || len(batches[1]) != 2 || len(batches[2]) != 1 {
+		t.Fatalf("expected batches of 2, 2 and 1 events, got %v", batches)
+	}
+	msg := batches[1][1]
+	if msg.Topic != "batched" || string(msg.Key) != "request" {
+		t.Fatalf("expected the event to be written to the topic keyed by its request, got %+v", msg)
+	}
+	event := &eventhandler.Event{}
+	if err := json.Unmarshal(msg.Value, event); err != nil {
+		t.Fatal(err)
+	}
+	if event.Type != eventhandler.EventNodeFailure || event.Flow != "flow" || event.NodeID != "next" ||
+		event.Error != "failed" {
+		t.Fatalf("expected the node failure event, got %+v", event)
+	}
+}
+
+// TestKafkaEventSinkDropsOverflow checks that the emission doesn't block while the producer does, and that the
+// events not fitting in the buffer are dropped and counted
+func TestKafkaEventSinkDropsOverflow(t *testing.T) {
+	producer := &fakeProducer{release: make(chan struct{})}
+	sink, err := eventhandler.NewKafkaEventSink(eventhandler.KafkaConfig{
+		Topic: "overflow", Producer: producer, BufferSize: 1, BatchSize: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := sink.EventHandler()
+	handler.Configure("flow", "request")
+
+	emitted := make(chan struct{})
+	go func() {
+		for i := 0; i < 10; i++ {
+			handler.ReportRequestStart(fmt.Sprintf("request-%d", i))
+		}
+		close(emitted)
+	}()
+	select {
+	case <-emitted:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the emission not to block on the producer")
+	}
+	// at most one event is being written and one is buffered
+	if dropped := droppedEvents("overflow", eventhandler.DropReasonOverflow); dropped < 8 {
+		t.Fatalf("expected at least 8 events to be dropped, got %v", dropped)
+	}
+
+	close(producer.release)
+	sink.Close()
+}
+
+// TestKafkaEventSinkCountsFailedWrites checks that the events of a batch the producer failed to write are counted
+func TestKafkaEventSinkCountsFailedWrites(t *testing.T) {
+	producer := &fakeProducer{err: fmt.Errorf("broker unavailable")}
+	sink, err := eventhandler.NewKafkaEventSink(eventhandler.KafkaConfig{
+		Topic: "failed", Producer: producer, BatchSize: 3,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := sink.EventHandler()
+	handler.Configure("flow", "request")
+	handler.ReportRequestStart("request")
+	handler.ReportRequestEnd("request")
+	sink.Close()
+
+	if dropped := droppedEvents("failed", eventhandler.DropReasonFailed); dropped != 2 {
+		t.Fatalf("expected the 2 events of the failed write to be dropped, got %v", dropped)
+	}
+}
+
+// TestKafkaEventSinkAvro checks that the avro schema is registered for the topic, and that the events are written in
+// the confluent wire format with its id
+func TestKafkaEventSinkAvro(t *testing.T) {
+	registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/subjects/avro-value/versions" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`{"id": 7}`))
+	}))
+	defer registry.Close()
+
+	producer := &fakeProducer{}
+	sink, err := eventhandler.NewKafkaEventSink(eventhandler.KafkaConfig{
+		Topic:             "avro",
+		Producer:          producer,
+		Serialization:     eventhandler.KafkaSerializationAvro,
+		SchemaRegistryURL: registry.URL,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := sink.EventHandler()
+	handler.Configure("flow", "request")
+	handler.ReportRequestStart("request")
+	sink.Close()
+
+	batches := producer.written()
+	if len(batches) != 1 || len(batches[0]) != 1 {
+		t.Fatalf("expected a single event to be written, got %v", batches)
+	}
+	value := batches[0][0].Value
+	if len(value) < 5 || value[0] != 0 || binary.BigEndian.Uint32(value[1:5]) != 7 {
+		t.Fatalf("expected the event to be prefixed by the magic byte and the schema id, got %v", value)
+	}
+}
+
+// TestNewKafkaEventSinkValidatesConfig checks that a sink isn't created without a topic, a producer or a registry
+// for avro
+func TestNewKafkaEventSinkValidatesConfig(t *testing.T) {
+	producer := &fakeProducer{}
+	for _, config := range []eventhandler.KafkaConfig{
+		{Producer: producer},
+		{Topic: "events"},
+		{Topic: "events", Producer: producer, Serialization: "xml"},
+		{Topic: "events", Producer: producer, Serialization: eventhandler.KafkaSerializationAvro},
+	} {
+		if _, err := eventhandler.NewKafkaEventSink(config); err == nil {
+			t.Fatalf("expected the config %+v to be rejected", config)
+		}
+	}
+}
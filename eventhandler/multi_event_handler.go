@@ -0,0 +1,122 @@
+package eventhandler
+
+import (
+	"github.com/yuyang0/goflow/core/sdk"
+)
+
+// HeaderCarrier is implemented by the event handlers using the header of the request, e.g. to continue its trace
+type HeaderCarrier interface {
+	SetHeader(header map[string][]string)
+}
+
+// MultiEventHandler forwards every event to several handlers, e.g. the tracer and the event sinks
+type MultiEventHandler struct {
+	Handlers []sdk.EventHandler
+}
+
+func (eh *MultiEventHandler) SetHeader(header map[string][]string) {
+	for _, handler := range eh.Handlers {
+		if carrier, ok := handler.(HeaderCarrier); ok {
+			carrier.SetHeader(header)
+		}
+	}
+}
+
+func (eh *MultiEventHandler) Configure(flowName string, requestID string) {
+	for _, handler := range eh.Handlers {
+		handler.Configure(flowName, requestID)
+	}
+}
+
+func (eh *MultiEventHandler) Init() error {
+	for _, handler := range eh.Handlers {
+		if err := handler.Init(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (eh *MultiEventHandler) Copy() (sdk.EventHandler, error) {
+	newHandler := &MultiEventHandler{Handlers: make([]sdk.EventHandler, 0, len(eh.Handlers))}
+	for _, handler := range eh.Handlers {
+		copied, err := handler.Copy()
+		if err != nil {
+			return nil, err
+		}
+		newHandler.Handlers = append(newHandler.Handlers, copied)
+	}
+	return newHandler, nil
+}
+
+func (eh *MultiEventHandler) ReportRequestStart(requestID string) {
+	for _, handler := range eh.Handlers {
+		handler.ReportRequestStart(requestID)
+	}
+}
+
+func (eh *MultiEventHandler) ReportRequestEnd(requestID string) {
+	for _, handler := range eh.Handlers {
+		handler.ReportRequestEnd(requestID)
+	}
+}
+
+func (eh *MultiEventHandler) ReportRequestFailure(requestID string, err error) {
+	for _, handler := range eh.Handlers {
+		handler.ReportRequestFailure(requestID, err)
+	}
+}
+
+func (eh *MultiEventHandler) ReportExecutionForward(nodeID string, requestID string) {
+	for _, handler := range eh.Handlers {
+		handler.ReportExecutionForward(nodeID, requestID)
+	}
+}
+
+func (eh *MultiEventHandler) ReportExecutionContinuation(requestID string) {
+	for _, handler := range eh.Handlers {
+		handler.ReportExecutionContinuation(requestID)
+	}
+}
+
+func (eh *MultiEventHandler) ReportNodeStart(nodeID string, requestID string) {
+	for _, handler := range eh.Handlers {
+		handler.ReportNodeStart(nodeID, requestID)
+	}
+}
+
+func (eh *MultiEventHandler) ReportNodeEnd(nodeID string, requestID string) {
+	for _, handler := range eh.Handlers {
+		handler.ReportNodeEnd(nodeID, requestID)
+	}
+}
+
+func (eh *MultiEventHandler) ReportNodeFailure(nodeID string, requestID string, err error) {
+	for _, handler := range eh.Handlers {
+		handler.ReportNodeFailure(nodeID, requestID, err)
+	}
+}
+
+func (eh *MultiEventHandler) ReportOperationStart(operationID string, nodeID string, requestID string) {
+	for _, handler := range eh.Handlers {
+		handler.ReportOperationStart(operationID, nodeID, requestID)
+	}
+}
+
+func (eh *MultiEventHandler) ReportOperationEnd(operationID string, nodeID string, requestID string) {
+	for _, handler := range eh.Handlers {
+		handler.ReportOperationEnd(operationID, nodeID, requestID)
+	}
+}
+
+func (eh *MultiEventHandler) ReportOperationFailure(operationID string, nodeID string, requestID string, err error) {
+	for _, handler := range eh.Handlers {
+		handler.ReportOperationFailure(operationID, nodeID, requestID, err)
+	}
+}
+
+func (eh *MultiEventHandler) Flush() {
+	for _, handler := range eh.Handlers {
+		handler.Flush()
+	}
+}
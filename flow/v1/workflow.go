@@ -14,9 +14,11 @@ type DataStore sdk.DataStore
 // ExecutionOptions options for branching in DAG
 type ExecutionOptions struct {
 	aggregator     sdk.Aggregator
+	branchAggr     sdk.BranchAggregator
 	forwarder      sdk.Forwarder
 	noForwarder    bool
 	failureHandler operation.FuncErrorHandler
+	maxBranches    int
 }
 
 type Workflow struct {
@@ -42,8 +44,10 @@ var (
 // reset reset the ExecutionOptions
 func (o *ExecutionOptions) reset() {
 	o.aggregator = nil
+	o.branchAggr = nil
 	o.noForwarder = false
 	o.forwarder = nil
+	o.maxBranches = 0
 }
 
 // Aggregator aggregates all outputs into one
@@ -53,6 +57,14 @@ func Aggregator(aggregator sdk.Aggregator) Option {
 	}
 }
 
+// BranchAggregator aggregates the outputs of the branches of a foreach into one, it is also told the keys of the
+// branches canceled with CancelBranches
+func BranchAggregator(aggregator sdk.BranchAggregator) Option {
+	return func(o *ExecutionOptions) {
+		o.branchAggr = aggregator
+	}
+}
+
 // InvokeEdge denotes a edge doesn't forwards a data,
 // but rather provides only an execution flow
 func InvokeEdge() Option {
@@ -76,6 +88,13 @@ func OnFailure(handler operation.FuncErrorHandler) Option {
 	}
 }
 
+// MaxBranches caps the items a streaming foreach emits, see ForEachStreamBranch
+func MaxBranches(max int) Option {
+	return func(o *ExecutionOptions) {
+		o.maxBranches = max
+	}
+}
+
 // GetWorkflow initiates a flow with a pipeline
 func GetWorkflow(pipeline *sdk.Pipeline) *Workflow {
 	workflow := &Workflow{}
@@ -130,11 +149,22 @@ func (currentDag *Dag) Append(dag *Dag) {
 
 // Node adds a new vertex by id
 func (currentDag *Dag) Node(vertex string, workload operation.Modifier, options ...Option) *Node {
+	return currentDag.addNode(vertex, createWorkload(vertex, workload), options...)
+}
+
+// NodeWithContext adds a new vertex by id whose workload receives the node context,
+// e.g. to stop when the node is interrupted
+func (currentDag *Dag) NodeWithContext(vertex string, workload operation.ContextModifier, options ...Option) *Node {
+	newWorkload := createWorkload(vertex, nil)
+	newWorkload.CtxMod = workload
+	return currentDag.addNode(vertex, newWorkload, options...)
+}
+
+func (currentDag *Dag) addNode(vertex string, newWorkload *operation.GoFlowOperation, options ...Option) *Node {
 	node := currentDag.udag.GetNode(vertex)
 	if node == nil {
 		node = currentDag.udag.AddVertex(vertex, []sdk.Operation{})
 	}
-	newWorkload := createWorkload(vertex, workload)
 	node.AddOperation(newWorkload)
 	o := &ExecutionOptions{}
 	for _, opt := range options {
@@ -201,6 +231,9 @@ func (currentDag *Dag) ForEachBranch(vertex string, foreach sdk.ForEach, options
 		if o.aggregator != nil {
 			node.AddSubAggregator(o.aggregator)
 		}
+		if o.branchAggr != nil {
+			node.AddBranchAggregator(o.branchAggr)
+		}
 		if o.noForwarder == true {
 			node.AddForwarder("dynamic", nil)
 		}
@@ -214,6 +247,43 @@ func (currentDag *Dag) ForEachBranch(vertex string, foreach sdk.ForEach, options
 	return
 }
 
+// ForEachStreamBranch composites a sub-dag which executes for each item
+// the producer emits with ctx.Emit, each branch starts as soon as its item is emitted
+// The branches are aggregated once the producer has returned and every branch has completed
+// It returns the sub-dag that will be executed for each item
+func (currentDag *Dag) ForEachStreamBranch(vertex string, producer sdk.ForEachStream, options ...Option) (dag *Dag) {
+	node := currentDag.udag.AddVertex(vertex, []sdk.Operation{})
+	if producer == nil {
+		panic(fmt.Sprintf("Error at AddForEachStreamBranch for %s, producer function not specified", vertex))
+	}
+	node.AddForEachStream(producer)
+
+	for _, option := range options {
+		o := &ExecutionOptions{}
+		o.reset()
+		option(o)
+		if o.aggregator != nil {
+			node.AddSubAggregator(o.aggregator)
+		}
+		if o.branchAggr != nil {
+			node.AddBranchAggregator(o.branchAggr)
+		}
+		if o.noForwarder == true {
+			node.AddForwarder("dynamic", nil)
+		}
+		if o.maxBranches > 0 {
+			node.SetMaxBranches(o.maxBranches)
+		}
+	}
+
+	dag = NewDag()
+	err := node.AddForEachDag(dag.udag)
+	if err != nil {
+		panic(fmt.Sprintf("Error at AddForEachStreamBranch for %s, %v", vertex, err))
+	}
+	return
+}
+
 // ConditionalBranch composites multiple dags as a sub-dag which executes for each
 // conditions returned by the Condition function dynamically
 // It returns the set of dags based on the set of condition passed
@@ -0,0 +1,201 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// defaultRegistry holds every metric created by the package
+var defaultRegistry = &registry{}
+
+var invalidNameChars = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
+type registry struct {
+	mu      sync.Mutex
+	metrics []*metricVec
+}
+
+func (r *registry) register(m *metricVec) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.metrics = append(r.metrics, m)
+}
+
+func (r *registry) all() []*metricVec {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]*metricVec{}, r.metrics...)
+}
+
+// metricVec is a set of values of a metric partitioned by labels
+type metricVec struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+
+	mu     sync.Mutex
+	values map[string]*labeledValue // keyed by the encoded label values
+}
+
+type labeledValue struct {
+	labelValues []string
+	value       float64
+}
+
+func newMetricVec(name string, help string, kind string, labels []string) *metricVec {
+	m := &metricVec{
+		name:   name,
+		help:   help,
+		kind:   kind,
+		labels: labels,
+		values: make(map[string]*labeledValue),
+	}
+	defaultRegistry.register(m)
+	return m
+}
+
+func (m *metricVec) update(labelValues []string, update func(value float64) float64) {
+	key := m.encodeLabels(labelValues)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entry, ok := m.values[key]
+	if !ok {
+		entry = &labeledValue{labelValues: append([]string{}, labelValues...)}
+		m.values[key] = entry
+	}
+	entry.value = update(entry.value)
+}
+
+func (m *metricVec) get(labelValues []string) float64 {
+	key := m.encodeLabels(labelValues)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if entry, ok := m.values[key]; ok {
+		return entry.value
+	}
+	return 0
+}
+
+func (m *metricVec) encodeLabels(labelValues []string) string {
+	pairs := make([]string, len(m.labels))
+	for idx, label := range m.labels {
+		value := ""
+		if idx < len(labelValues) {
+			value = labelValues[idx]
+		}
+		pairs[idx] = fmt.Sprintf("%s=%q", label, value)
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (m *metricVec) write(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n", m.name, m.help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", m.name, m.kind)
+	keys := make([]string, 0, len(m.values))
+	for key := range m.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if key == "" {
+			fmt.Fprintf(w, "%s %g\n", m.name, m.values[key].value)
+			continue
+		}
+		fmt.Fprintf(w, "%s{%s} %g\n", m.name, key, m.values[key].value)
+	}
+}
+
+// snapshot adds the values of the metric to a flat map, keyed by the metric name followed by the label values
+func (m *metricVec) snapshot(values map[string]float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, entry := range m.values {
+		name := m.name
+		for _, labelValue := range entry.labelValues {
+			name += "_" + invalidNameChars.ReplaceAllString(labelValue, "_")
+		}
+		values[name] += entry.value
+	}
+}
+
+// CounterVec is a set of monotonically increasing counters partitioned by labels
+type CounterVec struct {
+	vec *metricVec
+}
+
+// NewCounterVec creates and registers a counter with the given label names
+func NewCounterVec(name string, help string, labels ...string) *CounterVec {
+	return &CounterVec{vec: newMetricVec(name, help, "counter", labels)}
+}
+
+// Add increases the counter of the label values by value, negative values are ignored
+func (c *CounterVec) Add(value float64, labelValues ...string) {
+	if value < 0 {
+		return
+	}
+	c.vec.update(labelValues, func(current float64) float64 { return current + value })
+}
+
+// Inc increases the counter of the label values by one
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Value returns the current value of the counter of the label values
+func (c *CounterVec) Value(labelValues ...string) float64 {
+	return c.vec.get(labelValues)
+}
+
+// GaugeVec is a set of values that can go up and down partitioned by labels
+type GaugeVec struct {
+	vec *metricVec
+}
+
+// NewGaugeVec creates and registers a gauge with the given label names
+func NewGaugeVec(name string, help string, labels ...string) *GaugeVec {
+	return &GaugeVec{vec: newMetricVec(name, help, "gauge", labels)}
+}
+
+// Set sets the gauge of the label values
+func (g *GaugeVec) Set(value float64, labelValues ...string) {
+	g.vec.update(labelValues, func(float64) float64 { return value })
+}
+
+// Value returns the current value of the gauge of the label values
+func (g *GaugeVec) Value(labelValues ...string) float64 {
+	return g.vec.get(labelValues)
+}
+
+// WritePrometheus writes every registered metric in the prometheus text format
+func WritePrometheus(w io.Writer) {
+	for _, m := range defaultRegistry.all() {
+		m.write(w)
+	}
+}
+
+// Snapshot returns the current value of every registered metric as a flat map,
+// e.g. `goflow_queue_depth_my_flow` for the gauge `goflow_queue_depth{flow="my-flow"}`
+func Snapshot() map[string]float64 {
+	values := make(map[string]float64)
+	for _, m := range defaultRegistry.all() {
+		m.snapshot(values)
+	}
+	return values
+}
+
+// Handler returns an http handler exposing the registered metrics to prometheus
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		WritePrometheus(w)
+	})
+}
@@ -2,6 +2,8 @@ package operation
 
 import (
 	"fmt"
+
+	"github.com/yuyang0/goflow/core/sdk"
 )
 
 // FuncErrorHandler the error handler for OnFailure() options
@@ -10,9 +12,14 @@ type FuncErrorHandler func(error) error
 // Modifier definition for Modify() call
 type Modifier func([]byte, map[string][]string) ([]byte, error)
 
+// ContextModifier is a Modifier that receives the context of the node execution,
+// it should return sdk.ErrNodeInterrupted once the node is interrupted
+type ContextModifier func(*sdk.NodeContext, []byte, map[string][]string) ([]byte, error)
+
 type GoFlowOperation struct {
 	Id      string              // ID
 	Mod     Modifier            // Modifier
+	CtxMod  ContextModifier     // Modifier with node context, used instead of Mod when set
 	Options map[string][]string // The option as a input to workload
 
 	FailureHandler FuncErrorHandler // The Failure handler of the operation
@@ -45,24 +52,32 @@ func (operation *GoFlowOperation) Encode() []byte {
 }
 
 // executeWorkload executes a function call
-func executeWorkload(operation *GoFlowOperation, data []byte) ([]byte, error) {
+func executeWorkload(operation *GoFlowOperation, data []byte, nodeContext *sdk.NodeContext) ([]byte, error) {
 	var err error
 	var result []byte
 
 	options := operation.GetOptions()
-	result, err = operation.Mod(data, options)
+	if operation.CtxMod != nil {
+		result, err = operation.CtxMod(nodeContext, data, options)
+	} else {
+		result, err = operation.Mod(data, options)
+	}
 
 	return result, err
 }
 
-func (operation *GoFlowOperation) Execute(data []byte, _ map[string]interface{}) ([]byte, error) {
+func (operation *GoFlowOperation) Execute(data []byte, executionOptions map[string]interface{}) ([]byte, error) {
 	var result []byte
 	var err error
 
-	if operation.Mod != nil {
-		result, err = executeWorkload(operation, data)
+	if operation.Mod != nil || operation.CtxMod != nil {
+		nodeContext, _ := executionOptions[sdk.NodeContextOption].(*sdk.NodeContext)
+		if nodeContext == nil {
+			nodeContext = sdk.NewNodeContext("", operation.Id, nil)
+		}
+		result, err = executeWorkload(operation, data, nodeContext)
 		if err != nil {
-			err = fmt.Errorf("function(%s), error: function execution failed, %v",
+			err = fmt.Errorf("function(%s), error: function execution failed, %w",
 				operation.Id, err)
 			if operation.FailureHandler != nil {
 				err = operation.FailureHandler(err)
@@ -85,7 +100,7 @@ func (operation *GoFlowOperation) GetProperties() map[string][]string {
 	isHttpRequest := "false"
 	hasFailureHandler := "false"
 
-	if operation.Mod != nil {
+	if operation.Mod != nil || operation.CtxMod != nil {
 		isFunction = "true"
 	}
 	if operation.FailureHandler != nil {
@@ -0,0 +1,252 @@
+package runtime
+
+import (
+	"context"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	runtimeCommon "github.com/yuyang0/goflow/runtime/common"
+)
+
+//go:embed ui
+var adminUIAssets embed.FS
+
+// QueueDepth is the number of tasks of a queue by state
+type QueueDepth struct {
+	Ready     int64 `json:"ready"`
+	Rejected  int64 `json:"rejected"`
+	Unacked   int64 `json:"unacked"`
+	Consumers int64 `json:"consumers"`
+}
+
+// ListFlows returns the names of the registered flows
+func (fRuntime *FlowRuntime) ListFlows() []string {
+	flowNames := []string{}
+	fRuntime.ForEachFlow(func(flowName string, _ FlowDefinitionHandler) bool {
+		flowNames = append(flowNames, flowName)
+		return true
+	})
+	return flowNames
+}
+
+// FlowCount returns the number of registered flows
+func (fRuntime *FlowRuntime) FlowCount() int {
+	if fRuntime.Flows == nil {
+		return 0
+	}
+	return int(fRuntime.Flows.Len())
+}
+
+// ForEachFlow calls fn on the registered flows in the order of their names, until it returns false. The flows
+// are read from a snapshot taken when it is called, so it is safe to use while flows are registered or shut down
+// concurrently, fn included: the flows registered after the snapshot are not visited, and the flows removed
+// after it are still visited
+func (fRuntime *FlowRuntime) ForEachFlow(fn func(name string, h FlowDefinitionHandler) bool) {
+	if fRuntime.Flows == nil {
+		return
+	}
+	type flow struct {
+		name    string
+		handler FlowDefinitionHandler
+	}
+	flows := make([]flow, 0, fRuntime.Flows.Len())
+	fRuntime.Flows.ForEach(func(name string, handler FlowDefinitionHandler) bool {
+		flows = append(flows, flow{name: name, handler: handler})
+		return true
+	})
+	sort.Slice(flows, func(i, j int) bool {
+		return flows[i].name < flows[j].name
+	})
+	for _, flow := range flows {
+		if !fn(flow.name, flow.handler) {
+			return
+		}
+	}
+}
+
+// ListWorkers returns the workers that are currently alive
+func (fRuntime *FlowRuntime) ListWorkers(ctx context.Context) ([]*Worker, error) {
+	rdb := fRuntime.redisClient()
+	workers := []*Worker{}
+	iter := rdb.Scan(ctx, 0, WorkerKeyInitial+":*", 0).Iterator()
+	for iter.Next(ctx) {
+		value, err := rdb.Get(ctx, iter.Val()).Result()
+		if err != nil {
+			// the worker has expired in between
+			continue
+		}
+		worker := &Worker{}
+		if err := json.Unmarshal([]byte(value), worker); err != nil {
+			return nil, fmt.Errorf("failed to parse worker details, error %v", err)
+		}
+		workers = append(workers, worker)
+	}
+	if err := iter.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list workers, error %v", err)
+	}
+	return workers, nil
+}
+
+// HealthyWorkerCount returns the number of workers serving a flow whose registration was refreshed within
+// WorkerHeartbeatMaxAge. ListWorkers also returns the workers that died since their last registration, until
+// their registration expires
+func (fRuntime *FlowRuntime) HealthyWorkerCount(flowName string) (int, error) {
+	ctx := context.TODO()
+	rdb := fRuntime.redisClient()
+	var keys []string
+	iter := rdb.Scan(ctx, 0, WorkerKeyInitial+":*", 0).Iterator()
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+	}
+	if err := iter.Err(); err != nil {
+		return 0, fmt.Errorf("failed to list workers, error %v", err)
+	}
+	if len(keys) == 0 {
+		return 0, nil
+	}
+
+	pipe := rdb.Pipeline()
+	values := make([]*redis.StringCmd, len(keys))
+	ttls := make([]*redis.DurationCmd, len(keys))
+	for idx, key := range keys {
+		values[idx] = pipe.Get(ctx, key)
+		ttls[idx] = pipe.PTTL(ctx, key)
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return 0, fmt.Errorf("failed to get worker details, error %v", err)
+	}
+
+	count := 0
+	for idx := range keys {
+		// the registration is set with a ttl of RDBKeyTimeOut, the time it was refreshed is told by the ttl left
+		ttl := ttls[idx].Val()
+		if values[idx].Err() != nil || ttl < 0 || time.Second*RDBKeyTimeOut-ttl > WorkerHeartbeatMaxAge {
+			continue
+		}
+		worker := &Worker{}
+		if err := json.Unmarshal([]byte(values[idx].Val()), worker); err != nil {
+			return 0, fmt.Errorf("failed to parse worker details, error %v", err)
+		}
+		for _, name := range worker.Flows {
+			if name == flowName {
+				count++
+				break
+			}
+		}
+	}
+	return count, nil
+}
+
+// GetQueueDepths returns the depth of each queue of a flow
+func (fRuntime *FlowRuntime) GetQueueDepths(flowName string) (map[string]QueueDepth, error) {
+	if !fRuntime.initialized.Load() {
+		return nil, fmt.Errorf("runtime not initialized, Init must be called first")
+	}
+
+	queueIds := []string{fRuntime.internalRequestQueueId(flowName)}
+	for idx := 0; idx < fRuntime.RetryQueueCount; idx++ {
+		queueIds = append(queueIds, fRuntime.pushQueueId(flowName, idx))
+	}
+	for idx := 0; idx < fRuntime.PartitionCount; idx++ {
+		queueIds = append(queueIds, fRuntime.partitionQueueId(flowName, idx))
+	}
+	queueIds = append(queueIds, fRuntime.failureQueueId(flowName))
+
+	stats, err := fRuntime.rmqConnection.CollectStats(queueIds)
+	if err != nil {
+		return nil, fmt.Errorf("failed to collect queue stats, error %v", err)
+	}
+	depths := make(map[string]QueueDepth, len(queueIds))
+	for _, queueId := range queueIds {
+		stat := stats.QueueStats[queueId]
+		depths[queueId] = QueueDepth{
+			Ready:     stat.ReadyCount,
+			Rejected:  stat.RejectedCount,
+			Unacked:   stat.UnackedCount(),
+			Consumers: stat.ConsumerCount(),
+		}
+	}
+	return depths, nil
+}
+
+// adminUIHandler serves the embedded admin UI, the UI only uses the HTTP APIs of the runtime
+// so it is subject to the same request authentication
+func adminUIHandler() http.Handler {
+	assets, err := fs.Sub(adminUIAssets, "ui")
+	if err != nil {
+		log.Fatalf("failed to load admin ui assets, error %v", err)
+	}
+	return http.StripPrefix("/ui", http.FileServer(http.FS(assets)))
+}
+
+func flowListHandler(runtime *FlowRuntime) func(*gin.Context) {
+	fn := func(c *gin.Context) {
+		if !runtime.authorizeRequest(c, nil, ActionDiagnose, "", "") {
+			return
+		}
+		if withStatus, _ := strconv.ParseBool(c.Query("status")); withStatus {
+			c.JSON(http.StatusOK, runtime.ListFlowStatuses())
+			return
+		}
+		c.JSON(http.StatusOK, runtime.ListFlows())
+	}
+	return fn
+}
+
+func infoHandler(runtime *FlowRuntime) func(*gin.Context) {
+	fn := func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{
+			"flows":                  runtime.ListFlows(),
+			"build":                  GetBuildInfo(),
+			"worker_mode":            runtime.workerMode.Load(),
+			"global_timeout_seconds": runtime.GlobalTimeout().Seconds(),
+		})
+	}
+	return fn
+}
+
+func workerListHandler(runtime *FlowRuntime) func(*gin.Context) {
+	fn := func(c *gin.Context) {
+		if !runtime.authorizeRequest(c, nil, ActionDiagnose, "", "") {
+			return
+		}
+		workers, err := runtime.ListWorkers(c.Request.Context())
+		if err != nil {
+			log.Printf("Failed to list workers, error %v", err)
+			runtimeCommon.HandleError(c.Writer, fmt.Sprintf("Failed to list workers, %v", err))
+			return
+		}
+		c.JSON(http.StatusOK, workers)
+	}
+	return fn
+}
+
+func queueDepthHandler(runtime *FlowRuntime) func(*gin.Context) {
+	fn := func(c *gin.Context) {
+		flowName, ok := flowNameParam(runtime, c)
+		if !ok {
+			return
+		}
+		if !runtime.authorizeRequest(c, nil, ActionDiagnose, flowName, "") {
+			return
+		}
+
+		depths, err := runtime.GetQueueDepths(flowName)
+		if err != nil {
+			log.Printf("Failed to get queue depths for flow %s, error %v", flowName, err)
+			runtimeCommon.HandleError(c.Writer, fmt.Sprintf("Failed to get queue depths, %v", err))
+			return
+		}
+		c.JSON(http.StatusOK, depths)
+	}
+	return fn
+}
@@ -0,0 +1,164 @@
+package runtime
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/yuyang0/goflow/core/sdk"
+	"github.com/yuyang0/goflow/metrics"
+	"github.com/yuyang0/goflow/types"
+)
+
+const (
+	// AMQPPublishers is the number of channels publishing the results concurrently
+	AMQPPublishers = 8
+	// AMQPPublishBufferSize is the number of results waiting to be published, the next ones are dropped
+	AMQPPublishBufferSize = 1000
+	// AMQPPublishTimeout bounds the publication of a result along with its confirmation by the broker
+	AMQPPublishTimeout = 10 * time.Second
+
+	AMQPFlowNameHeader = "goflow-flow"
+)
+
+var publishedResultsCounter = metrics.NewCounterVec("goflow_amqp_published_results_total",
+	"Flow results published to an AMQP exchange", "flow", "result")
+
+// amqpResult is a result waiting to be published
+type amqpResult struct {
+	flowName   string
+	exchange   string
+	routingKey string
+	msg        amqp.Publishing
+}
+
+// amqpPublisher publishes the flow results in the background over a connection shared by the flows, the
+// connection is opened on first use and again once closed
+type amqpPublisher struct {
+	config *types.AMQPConfig
+	logger sdk.Logger
+
+	mu      sync.Mutex
+	conn    *amqp.Connection
+	start   sync.Once
+	results chan *amqpResult
+}
+
+func newAMQPPublisher(config *types.AMQPConfig, logger sdk.Logger) *amqpPublisher {
+	return &amqpPublisher{
+		config:  config,
+		logger:  logger,
+		results: make(chan *amqpResult, AMQPPublishBufferSize),
+	}
+}
+
+// enqueue queues a result to be published, it fails without blocking when the buffer is full
+func (p *amqpPublisher) enqueue(result *amqpResult) error {
+	p.start.Do(func() {
+		for i := 0; i < AMQPPublishers; i++ {
+			go p.run()
+		}
+	})
+	select {
+	case p.results <- result:
+		return nil
+	default:
+		return fmt.Errorf("too many results waiting to be published")
+	}
+}
+
+// openChannel opens a channel in confirm mode, so that the broker acknowledges every publication
+func (p *amqpPublisher) openChannel() (*amqp.Channel, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.conn == nil || p.conn.IsClosed() {
+		conn, err := p.config.Dial()
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to amqp broker, error %v", err)
+		}
+		p.conn = conn
+	}
+	ch, err := p.conn.Channel()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open amqp channel, error %v", err)
+	}
+	if err := ch.Confirm(false); err != nil {
+		ch.Close()
+		return nil, fmt.Errorf("failed to put amqp channel in confirm mode, error %v", err)
+	}
+	return ch, nil
+}
+
+// run publishes the queued results on its own channel, a result is published once the broker confirmed it
+func (p *amqpPublisher) run() {
+	var ch *amqp.Channel
+	for result := range p.results {
+		var err error
+		if ch == nil || ch.IsClosed() {
+			ch, err = p.openChannel()
+		}
+		if err == nil {
+			err = p.publish(ch, result)
+		}
+		if err != nil {
+			// the channel is closed by the broker on error, a channel left with an unconfirmed publication is dropped
+			if ch != nil {
+				ch.Close()
+				ch = nil
+			}
+			publishedResultsCounter.Inc(result.flowName, "failed")
+			p.logger.Log(fmt.Sprintf("[request `%s`] failed to publish result, error %v", result.msg.MessageId, err))
+			continue
+		}
+		publishedResultsCounter.Inc(result.flowName, "published")
+	}
+}
+
+func (p *amqpPublisher) publish(ch *amqp.Channel, result *amqpResult) error {
+	ctx, cancel := context.WithTimeout(context.Background(), AMQPPublishTimeout)
+	defer cancel()
+	confirmation, err := ch.PublishWithDeferredConfirmWithContext(ctx, result.exchange, result.routingKey, false, false, result.msg)
+	if err != nil {
+		return fmt.Errorf("failed to publish to exchange %s, error %v", result.exchange, err)
+	}
+	acked, err := confirmation.WaitContext(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to confirm publication to exchange %s, error %v", result.exchange, err)
+	}
+	if !acked {
+		return fmt.Errorf("publication to exchange %s was rejected by the broker", result.exchange)
+	}
+	return nil
+}
+
+// publishResult queues the result of a completed request to be published to the AMQP exchange of its flow,
+// if the flow has an AMQPOutputExchange or an AMQPOutputRoutingKey. The result is published in the background
+func (fRuntime *FlowRuntime) publishResult(flowName string, requestID string, result []byte) error {
+	options, ok := fRuntime.getFlowOptions(flowName)
+	if !ok || (options.AMQPOutputExchange == "" && options.AMQPOutputRoutingKey == "") {
+		return nil
+	}
+	if fRuntime.amqpPublisher == nil {
+		return fmt.Errorf("no amqp broker configured to publish the results of flow %s", flowName)
+	}
+
+	err := fRuntime.amqpPublisher.enqueue(&amqpResult{
+		flowName:   flowName,
+		exchange:   options.AMQPOutputExchange,
+		routingKey: options.AMQPOutputRoutingKey,
+		msg: amqp.Publishing{
+			Headers:      amqp.Table{AMQPFlowNameHeader: flowName},
+			DeliveryMode: amqp.Persistent,
+			MessageId:    requestID,
+			Timestamp:    fRuntime.clock().Now(),
+			Body:         result,
+		},
+	})
+	if err != nil {
+		publishedResultsCounter.Inc(flowName, "dropped")
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,175 @@
+package runtime
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/alexellis/hmac"
+	"github.com/gin-gonic/gin"
+	"github.com/yuyang0/goflow/core/runtime/controller"
+	"github.com/yuyang0/goflow/metrics"
+	runtimeCommon "github.com/yuyang0/goflow/runtime/common"
+)
+
+const (
+	AuditLogKey = "goflow-audit"
+
+	// AuditLogMaxEntries is the number of entries kept in the redis audit log
+	AuditLogMaxEntries = 1000
+
+	ActionSubmit = "submit"
+	ActionPause  = "pause"
+	ActionResume = "resume"
+	ActionStop   = "stop"
+
+	PrincipalMethodAnonymous    = "anonymous"
+	PrincipalMethodSharedSecret = "shared-secret"
+	PrincipalMethodMTLS         = "mtls"
+)
+
+var deniedOperationsCounter = metrics.NewCounterVec("goflow_denied_operations_total",
+	"Operations denied by the Authorizer", "flow", "action")
+
+// Principal is the identity an operation is performed with, as established by the authentication of the request
+type Principal struct {
+	Name   string `json:"name"`
+	Method string `json:"method"` // how the principal was authenticated, one of the PrincipalMethod constants
+	// Claims are the claims of the bearer token the principal was authenticated with
+	Claims map[string]interface{} `json:"claims,omitempty"`
+}
+
+// Authorizer decides whether a principal may perform an action on a flow, it returns an error giving the
+// reason of a denial. requestID is empty when not known, as for a submission without X-Request-Id
+type Authorizer interface {
+	Authorize(principal Principal, action string, flowName string, requestID string) error
+}
+
+// AllowAllAuthorizer allows every operation, it is used when no Authorizer is set
+type AllowAllAuthorizer struct{}
+
+func (AllowAllAuthorizer) Authorize(principal Principal, action string, flowName string, requestID string) error {
+	return nil
+}
+
+// AuditEntry is an operation denied by the Authorizer, or an annotation written to a request
+type AuditEntry struct {
+	Time      time.Time `json:"time"`
+	Principal Principal `json:"principal"`
+	Action    string    `json:"action"`
+	Flow      string    `json:"flow"`
+	RequestID string    `json:"request_id,omitempty"`
+	Reason    string    `json:"reason,omitempty"` // reason of the denial
+	Note      string    `json:"note,omitempty"`   // author and text of the annotation
+}
+
+func (fRuntime *FlowRuntime) authorizer() Authorizer {
+	if fRuntime.Authorizer == nil {
+		return AllowAllAuthorizer{}
+	}
+	return fRuntime.Authorizer
+}
+
+// requestPrincipal returns the principal of an http request, the subject of its bearer token, the common name
+// of the client certificate with mTLS, or the shared secret identity when the request carries a valid signature
+// of its body. With JWT, the requests are only authenticated with their bearer token
+func (fRuntime *FlowRuntime) requestPrincipal(c *gin.Context, body []byte) Principal {
+	if value, ok := c.Get(principalContextKey); ok {
+		return value.(Principal)
+	}
+	if fRuntime.JWT != nil {
+		return Principal{Name: PrincipalMethodAnonymous, Method: PrincipalMethodAnonymous}
+	}
+	if tls := c.Request.TLS; tls != nil && len(tls.PeerCertificates) > 0 {
+		return Principal{Name: tls.PeerCertificates[0].Subject.CommonName, Method: PrincipalMethodMTLS}
+	}
+	if fRuntime.RequestAuthEnabled {
+		signature := c.Request.Header.Get(controller.AuthSignatureHeader)
+		if signature != "" && hmac.Validate(body, signature, fRuntime.RequestAuthSharedSecret) == nil {
+			return Principal{Name: PrincipalMethodSharedSecret, Method: PrincipalMethodSharedSecret}
+		}
+	}
+	return Principal{Name: PrincipalMethodAnonymous, Method: PrincipalMethodAnonymous}
+}
+
+// authorizeRequest checks that the principal of an http request is within its rate limit and may perform
+// the action, it responds with 403 and records the denial in the audit log otherwise
+func (fRuntime *FlowRuntime) authorizeRequest(c *gin.Context, body []byte, action string, flowName string, requestId string) bool {
+	principal := fRuntime.requestPrincipal(c, body)
+	if !fRuntime.throttleRequest(c, principal, action) {
+		return false
+	}
+	err := fRuntime.authorizer().Authorize(principal, action, flowName, requestId)
+	if err == nil {
+		return true
+	}
+
+	deniedOperationsCounter.Inc(flowName, action)
+	fRuntime.Logger.Log(fmt.Sprintf("[goflow] denied %s of flow %s to %s (%s), %v",
+		action, flowName, principal.Name, principal.Method, err))
+	entry := &AuditEntry{
+		Time:      fRuntime.clock().Now(),
+		Principal: principal,
+		Action:    action,
+		Flow:      flowName,
+		RequestID: requestId,
+		Reason:    err.Error(),
+	}
+	if err := fRuntime.appendAuditLog(entry); err != nil {
+		fRuntime.Logger.Log(fmt.Sprintf("[goflow] failed to append to audit log, error %v", err))
+	}
+	c.String(http.StatusForbidden, "%s of flow %s denied, %v", action, flowName, err)
+	return false
+}
+
+func (fRuntime *FlowRuntime) appendAuditLog(entry *AuditEntry) error {
+	if IsWarmupRequest(entry.RequestID) {
+		return nil
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to marshal audit entry, error %v", err)
+	}
+	ctx := context.TODO()
+	pipe := fRuntime.redisClient().TxPipeline()
+	pipe.LPush(ctx, AuditLogKey, data)
+	pipe.LTrim(ctx, AuditLogKey, 0, AuditLogMaxEntries-1)
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// GetAuditLog returns the latest denied operations, newest first
+func (fRuntime *FlowRuntime) GetAuditLog(ctx context.Context, count int) ([]*AuditEntry, error) {
+	if count <= 0 || count > AuditLogMaxEntries {
+		count = AuditLogMaxEntries
+	}
+	values, err := fRuntime.redisClient().LRange(ctx, AuditLogKey, 0, int64(count-1)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get audit log, error %v", err)
+	}
+	entries := make([]*AuditEntry, 0, len(values))
+	for _, value := range values {
+		entry := &AuditEntry{}
+		if err := json.Unmarshal([]byte(value), entry); err != nil {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+func auditLogHandler(runtime *FlowRuntime) func(*gin.Context) {
+	fn := func(c *gin.Context) {
+		count, _ := strconv.Atoi(c.Query("count"))
+		entries, err := runtime.GetAuditLog(c.Request.Context(), count)
+		if err != nil {
+			runtimeCommon.HandleError(c.Writer, fmt.Sprintf("Failed to get audit log, %v", err))
+			return
+		}
+		c.JSON(http.StatusOK, entries)
+	}
+	return fn
+}
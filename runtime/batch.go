@@ -0,0 +1,114 @@
+package runtime
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/yuyang0/goflow/core/runtime"
+	"github.com/yuyang0/goflow/core/sdk/executor"
+)
+
+// BatchItemResult is the outcome of a single item of a batch operation
+type BatchItemResult[T any] struct {
+	Key   string // identifies the item, the request id for request based batches
+	Value T
+	Err   error
+}
+
+// BatchResult carries the outcome of every item of a batch operation so that
+// callers can retry only the failed items
+type BatchResult[T any] struct {
+	Items []BatchItemResult[T]
+}
+
+func (result *BatchResult[T]) add(key string, value T, err error) {
+	result.Items = append(result.Items, BatchItemResult[T]{Key: key, Value: value, Err: err})
+}
+
+// Succeeded returns the items that succeeded
+func (result *BatchResult[T]) Succeeded() []BatchItemResult[T] {
+	var items []BatchItemResult[T]
+	for _, item := range result.Items {
+		if item.Err == nil {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// Failed returns the items that failed
+func (result *BatchResult[T]) Failed() []BatchItemResult[T] {
+	var items []BatchItemResult[T]
+	for _, item := range result.Items {
+		if item.Err != nil {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// Err returns an error summarizing the failed items, nil if every item succeeded
+func (result *BatchResult[T]) Err() error {
+	failed := result.Failed()
+	if len(failed) == 0 {
+		return nil
+	}
+	var messages []string
+	for _, item := range failed {
+		messages = append(messages, fmt.Sprintf("%s: %v", item.Key, item.Err))
+	}
+	return fmt.Errorf("%d of %d items failed, %s", len(failed), len(result.Items), strings.Join(messages, "; "))
+}
+
+// ExecuteBatch submits multiple requests to a flow, requests without id are assigned one
+// so that each item of the result can be identified
+func (fRuntime *FlowRuntime) ExecuteBatch(flowName string, requests []*runtime.Request) *BatchResult[string] {
+	result := &BatchResult[string]{}
+	for _, request := range requests {
+		if request.RequestID == "" {
+			request.RequestID = getNewId()
+		}
+		err := fRuntime.Execute(flowName, request)
+		result.add(request.RequestID, request.RequestID, err)
+	}
+	return result
+}
+
+// GetStates returns the state of multiple requests of a flow
+func (fRuntime *FlowRuntime) GetStates(flowName string, requestIDs []string) *BatchResult[*executor.RequestState] {
+	result := &BatchResult[*executor.RequestState]{}
+	for _, requestID := range requestIDs {
+		state, err := fRuntime.GetState(flowName, requestID)
+		result.add(requestID, state, err)
+	}
+	return result
+}
+
+// CleanupRequests removes the state and the data of multiple requests of a flow
+func (fRuntime *FlowRuntime) CleanupRequests(flowName string, requestIDs []string) *BatchResult[struct{}] {
+	result := &BatchResult[struct{}]{}
+	for _, requestID := range requestIDs {
+		result.add(requestID, struct{}{}, fRuntime.cleanupRequest(flowName, requestID))
+	}
+	return result
+}
+
+func (fRuntime *FlowRuntime) cleanupRequest(flowName string, requestID string) error {
+	stateStore, err := fRuntime.stateStore.CopyStore()
+	if err != nil {
+		return fmt.Errorf("failed to copy state store, error %v", err)
+	}
+	stateStore.Configure(flowName, requestID)
+	if err := stateStore.Cleanup(); err != nil {
+		return fmt.Errorf("failed to cleanup state, error %v", err)
+	}
+
+	dataStore, err := fRuntime.requestDataStore(flowName, requestID)
+	if err != nil {
+		return err
+	}
+	if err := dataStore.Cleanup(); err != nil {
+		return fmt.Errorf("failed to cleanup data, error %v", err)
+	}
+	return nil
+}
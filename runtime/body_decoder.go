@@ -0,0 +1,122 @@
+package runtime
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/alexellis/hmac"
+	"github.com/yuyang0/goflow/core/runtime"
+	"github.com/yuyang0/goflow/core/runtime/controller"
+)
+
+const (
+	ContentTypeHeader = "Content-Type"
+	// OriginalContentTypeHeader keeps the content type of a request body decoded into json
+	OriginalContentTypeHeader = "X-Goflow-Original-Content-Type"
+)
+
+// ErrBodyDecode is returned when a request body can't be decoded by the decoder of its content type
+var ErrBodyDecode = errors.New("failed to decode request body")
+
+// BodyDecoder decodes a request body into a value, which is marshalled as the json body handed to the nodes
+type BodyDecoder func(body []byte) (interface{}, error)
+
+// RegisterBodyDecoder sets the decoder of the request bodies of a content type, e.g. application/x-msgpack.
+// The bodies of the content types without a decoder are handed to the nodes as is
+func (fRuntime *FlowRuntime) RegisterBodyDecoder(contentType string, decoder BodyDecoder) error {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return fmt.Errorf("invalid content type %s, error %v", contentType, err)
+	}
+	fRuntime.bodyDecodersMu.Lock()
+	defer fRuntime.bodyDecodersMu.Unlock()
+	if fRuntime.bodyDecoders == nil {
+		fRuntime.bodyDecoders = make(map[string]BodyDecoder)
+	}
+	fRuntime.bodyDecoders[mediaType] = decoder
+	return nil
+}
+
+func (fRuntime *FlowRuntime) bodyDecoder(contentType string) (BodyDecoder, bool) {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, false
+	}
+	fRuntime.bodyDecodersMu.RLock()
+	defer fRuntime.bodyDecodersMu.RUnlock()
+	decoder, ok := fRuntime.bodyDecoders[mediaType]
+	return decoder, ok
+}
+
+// decodeRequestBody replaces the body of a request by its json encoding when a decoder is registered for its
+// content type, see decodeBody
+func (fRuntime *FlowRuntime) decodeRequestBody(request *runtime.Request) error {
+	header, body, err := fRuntime.decodeBody(request.Header, request.Body)
+	if err != nil {
+		return err
+	}
+	request.Header = header
+	request.Body = body
+	return nil
+}
+
+// decodeBody returns the json encoding of a body when a decoder is registered for its content type, along
+// with the header of the decoded body. The content type becomes application/json, the original one is kept
+// in the X-Goflow-Original-Content-Type header, and a valid signature of the body is replaced by the
+// signature of the decoded body
+func (fRuntime *FlowRuntime) decodeBody(header map[string][]string, body []byte) (map[string][]string, []byte, error) {
+	contentType := http.Header(header).Get(ContentTypeHeader)
+	if contentType == "" {
+		return header, body, nil
+	}
+	decoder, ok := fRuntime.bodyDecoder(contentType)
+	if !ok {
+		return header, body, nil
+	}
+
+	value, err := decoder(body)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w of content type %s, %v", ErrBodyDecode, contentType, err)
+	}
+	decoded, err := json.Marshal(value)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w of content type %s, %v", ErrBodyDecode, contentType, err)
+	}
+
+	// the header may be shared with the caller
+	decodedHeader := http.Header(header).Clone()
+	decodedHeader.Set(OriginalContentTypeHeader, contentType)
+	decodedHeader.Set(ContentTypeHeader, "application/json")
+	if fRuntime.RequestAuthEnabled {
+		signature := decodedHeader.Get(controller.AuthSignatureHeader)
+		if signature != "" && hmac.Validate(body, signature, fRuntime.RequestAuthSharedSecret) == nil {
+			decodedHeader.Set(controller.AuthSignatureHeader,
+				"sha1="+hex.EncodeToString(hmac.Sign(decoded, []byte(fRuntime.RequestAuthSharedSecret))))
+		}
+	}
+	return decodedHeader, decoded, nil
+}
+
+// FormDecoder decodes form-encoded bodies into an object, the fields with several values are decoded into arrays.
+// It is not registered by default
+func FormDecoder(body []byte) (interface{}, error) {
+	values, err := url.ParseQuery(strings.TrimSpace(string(body)))
+	if err != nil {
+		return nil, err
+	}
+	decoded := make(map[string]interface{}, len(values))
+	for key, value := range values {
+		if len(value) == 1 {
+			decoded[key] = value[0]
+		} else {
+			decoded[key] = value
+		}
+	}
+	return decoded, nil
+}
@@ -0,0 +1,29 @@
+package runtime
+
+import (
+	"fmt"
+
+	"github.com/yuyang0/goflow/core/runtime"
+	"github.com/yuyang0/goflow/core/sdk/executor"
+)
+
+// CancelBranches cancels the branches of the foreach nodes of a request matched by the selector, e.g. the items
+// found pointless to process mid-run. The pending branches skip their nodes, the running ones see the context of
+// their node interrupted, and the foreach aggregates the results of the remaining branches. It returns the
+// branches canceled, the ones already completed are not
+func (fRuntime *FlowRuntime) CancelBranches(flowName string, requestID string, selector executor.BranchSelector) ([]*executor.CanceledBranch, error) {
+	flowName, err := fRuntime.resolveFlowName(flowName)
+	if err != nil {
+		return nil, err
+	}
+	request := &runtime.Request{
+		FlowName:  flowName,
+		RequestID: requestID,
+		Header:    make(map[string][]string),
+	}
+	ex, err := fRuntime.CreateExecutor(request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to cancel branches of request %s, error %v", requestID, err)
+	}
+	return executor.CreateFlowExecutor(ex, nil).CancelBranches(requestID, selector)
+}
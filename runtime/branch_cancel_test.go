@@ -0,0 +1,102 @@
+package runtime_test
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/yuyang0/goflow/core/sdk"
+	"github.com/yuyang0/goflow/core/sdk/executor"
+	flow "github.com/yuyang0/goflow/flow/v1"
+	"github.com/yuyang0/goflow/runtime"
+	goflow "github.com/yuyang0/goflow/v1"
+)
+
+type branchAggregation struct {
+	results  map[string][]byte
+	canceled []string
+}
+
+// foreachFlow fans out three branches, the branch b runs until it is interrupted
+func foreachFlow(started chan<- struct{}, aggregated chan<- branchAggregation) runtime.FlowDefinitionHandler {
+	var once sync.Once
+	return func(wf *flow.Workflow, _ *flow.Context) error {
+		dag := wf.Dag()
+		branch := dag.ForEachBranch("items", func(data []byte) map[string][]byte {
+			return map[string][]byte{"a": []byte("a"), "b": []byte("b"), "c": []byte("c")}
+		}, flow.BranchAggregator(func(results map[string][]byte, canceled []string) ([]byte, error) {
+			select {
+			case aggregated <- branchAggregation{results: results, canceled: canceled}:
+			default:
+			}
+			return []byte("done"), nil
+		}))
+		branch.NodeWithContext("work", func(nodeContext *sdk.NodeContext, data []byte, _ map[string][]string) ([]byte, error) {
+			if string(data) != "b" {
+				return data, nil
+			}
+			once.Do(func() { close(started) })
+			deadline := time.Now().Add(10 * time.Second)
+			for !nodeContext.IsInterrupted() && time.Now().Before(deadline) {
+				time.Sleep(50 * time.Millisecond)
+			}
+			return nil, sdk.ErrNodeInterrupted
+		})
+		return nil
+	}
+}
+
+func TestCancelBranchesAggregatesRemainingBranches(t *testing.T) {
+	started := make(chan struct{})
+	aggregated := make(chan branchAggregation, 1)
+	fs := &goflow.FlowService{WorkerConcurrency: 4}
+	_, client := startWorker(t, fs, map[string]runtime.FlowDefinitionHandler{"fanout": foreachFlow(started, aggregated)},
+		map[string]runtime.FlowOptions{"fanout": {CancelableBranches: true}})
+	if err := client.Execute("fanout", &goflow.Request{RequestId: "fanout-request", Body: []byte("{}")}); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-started:
+	case <-time.After(15 * time.Second):
+		t.Fatal("the branch b was not started")
+	}
+	canceled, err := fs.CancelBranches("fanout", "fanout-request", executor.BranchSelector{Node: "items", Indices: []int{1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(canceled) != 1 || canceled[0].Key != "b" {
+		t.Fatalf("expected the branch b to be canceled, got %+v", canceled)
+	}
+
+	select {
+	case aggregation := <-aggregated:
+		if !reflect.DeepEqual(aggregation.canceled, []string{"b"}) {
+			t.Fatalf("expected the aggregator to be told the branch b was canceled, got %v", aggregation.canceled)
+		}
+		if len(aggregation.results) != 2 || string(aggregation.results["a"]) != "a" || string(aggregation.results["c"]) != "c" {
+			t.Fatalf("expected the results of the branches a and c, got %v", aggregation.results)
+		}
+	case <-time.After(15 * time.Second):
+		t.Fatal("the foreach was not aggregated")
+	}
+}
+
+func TestCancelBranchesRequiresCancelableBranches(t *testing.T) {
+	started := make(chan struct{})
+	aggregated := make(chan branchAggregation, 1)
+	fs := &goflow.FlowService{}
+	_, client := startWorker(t, fs, map[string]runtime.FlowDefinitionHandler{"fanout": foreachFlow(started, aggregated)}, nil)
+	if err := client.Execute("fanout", &goflow.Request{RequestId: "fanout-request", Body: []byte("{}")}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-started:
+	case <-time.After(15 * time.Second):
+		t.Fatal("the branch b was not started")
+	}
+	if _, err := fs.CancelBranches("fanout", "fanout-request", executor.BranchSelector{Node: "items"}); err == nil {
+		t.Fatal("expected the branches of the flow not to be cancelable")
+	}
+}
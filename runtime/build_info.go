@@ -0,0 +1,100 @@
+package runtime
+
+import (
+	"fmt"
+	"net/http"
+	goRuntime "runtime"
+	"runtime/debug"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yuyang0/goflow/metrics"
+)
+
+// GoflowModule is the path of the goflow module, looked up in the build info of the binary
+const GoflowModule = "github.com/yuyang0/goflow"
+
+// Version and Commit identify the goflow build, they are read from the build info of the binary unless set with
+// -ldflags "-X github.com/yuyang0/goflow/runtime.Version=v0.4.2 -X github.com/yuyang0/goflow/runtime.Commit=1a2b3c4"
+var (
+	Version string
+	Commit  string
+)
+
+var buildInfoGauge = metrics.NewGaugeVec("goflow_build_info",
+	"Always 1, labelled with the goflow version and commit of the worker", "version", "commit", "go_version")
+
+// BuildInfo is the goflow build a worker runs
+type BuildInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit,omitempty"`
+	GoVersion string `json:"go_version"`
+}
+
+// String formats the build as `v0.4.2 (commit 1a2b3c4, go1.21.5)`
+func (info BuildInfo) String() string {
+	if info.Commit == "" {
+		return fmt.Sprintf("%s (%s)", info.Version, info.GoVersion)
+	}
+	return fmt.Sprintf("%s (commit %s, %s)", info.Version, info.Commit, info.GoVersion)
+}
+
+var (
+	buildInfo     BuildInfo
+	buildInfoOnce sync.Once
+)
+
+// GetBuildInfo returns the goflow build of the binary. The version is the one of the goflow module the binary
+// depends on, `(devel)` when built from the goflow tree, and the commit is only known in the latter case or when
+// set with -ldflags
+func GetBuildInfo() BuildInfo {
+	buildInfoOnce.Do(func() {
+		buildInfo = BuildInfo{Version: Version, Commit: Commit, GoVersion: goRuntime.Version()}
+		info, ok := debug.ReadBuildInfo()
+		if !ok {
+			return
+		}
+		var module *debug.Module
+		if info.Main.Path == GoflowModule {
+			module = &info.Main
+		}
+		for _, dep := range info.Deps {
+			if dep.Path == GoflowModule {
+				module = dep
+				if dep.Replace != nil {
+					module = dep.Replace
+				}
+			}
+		}
+		if module == nil {
+			return
+		}
+		if buildInfo.Version == "" {
+			buildInfo.Version = module.Version
+		}
+		if buildInfo.Commit == "" && module == &info.Main {
+			for _, setting := range info.Settings {
+				if setting.Key == "vcs.revision" {
+					buildInfo.Commit = setting.Value
+				}
+			}
+		}
+	})
+	if buildInfo.Version == "" {
+		return BuildInfo{Version: "unknown", Commit: buildInfo.Commit, GoVersion: buildInfo.GoVersion}
+	}
+	return buildInfo
+}
+
+// recordBuildInfo exposes the build of the worker in the goflow_build_info metric
+func recordBuildInfo() {
+	info := GetBuildInfo()
+	buildInfoGauge.Set(1, info.Version, info.Commit, info.GoVersion)
+}
+
+func versionHandler(runtime *FlowRuntime) func(*gin.Context) {
+	fn := func(c *gin.Context) {
+		c.JSON(http.StatusOK, GetBuildInfo())
+	}
+	return fn
+}
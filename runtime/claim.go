@@ -0,0 +1,236 @@
+package runtime
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/yuyang0/goflow/core/runtime"
+	"github.com/yuyang0/goflow/metrics"
+)
+
+const (
+	ClaimKeyInitial        = "goflow-claim"
+	ClaimRequestKeyInitial = "goflow-claim-request"
+
+	// IdempotencyKeyHeader carries the key of a request processed once cluster-wide
+	IdempotencyKeyHeader = "X-Idempotency-Key"
+
+	// DefaultClaimTTL is how long a claim is held while its request is processed, when ClaimTTL is not set
+	DefaultClaimTTL = time.Hour
+	// ClaimProcessedTTL is how long a key is remembered as processed
+	ClaimProcessedTTL = 24 * time.Hour
+
+	ClaimStateProcessing = "processing"
+	ClaimStateProcessed  = "processed"
+
+	DuplicateReasonClaimed   = "claimed"
+	DuplicateReasonProcessed = "processed"
+)
+
+var (
+	// ErrAlreadyClaimed is returned when the key is claimed by another request being processed
+	ErrAlreadyClaimed = errors.New("already claimed")
+	// ErrAlreadyProcessed is returned when a request with the key was already processed
+	ErrAlreadyProcessed = errors.New("already processed")
+)
+
+var duplicateRequestsCounter = metrics.NewCounterVec("goflow_duplicate_requests_total",
+	"Requests skipped as their idempotency key is claimed or processed by another request", "flow", "reason")
+
+// Claim is the exclusive right of a request to process an idempotency key across the workers
+type Claim struct {
+	Key       string    `json:"key"`
+	FlowName  string    `json:"flow"`
+	RequestID string    `json:"request_id"`
+	WorkerID  string    `json:"worker_id"` // the worker that claimed the key
+	State     string    `json:"state"`
+	ClaimedAt time.Time `json:"claimed_at"`
+
+	runtime *FlowRuntime
+}
+
+// updateClaimScript replaces the claim of KEYS[1] with ARGV[2] for ARGV[3] milliseconds,
+// or deletes it if ARGV[2] is empty, provided it is held by the request ARGV[1]
+var updateClaimScript = redis.NewScript(`
+local value = redis.call("GET", KEYS[1])
+if not value then
+	return 0
+end
+if cjson.decode(value)["request_id"] ~= ARGV[1] then
+	return 0
+end
+if ARGV[2] == "" then
+	redis.call("DEL", KEYS[1])
+else
+	redis.call("SET", KEYS[1], ARGV[2], "PX", ARGV[3])
+end
+return 1
+`)
+
+func claimKey(key string) string {
+	return fmt.Sprintf("%s:%s", ClaimKeyInitial, key)
+}
+
+func claimRequestKey(requestID string) string {
+	return fmt.Sprintf("%s:%s", ClaimRequestKeyInitial, requestID)
+}
+
+func (fRuntime *FlowRuntime) claimTTL() time.Duration {
+	if fRuntime.ClaimTTL <= 0 {
+		return DefaultClaimTTL
+	}
+	return fRuntime.ClaimTTL
+}
+
+// Claim claims an idempotency key for a request of a flow, so that a single request processes it across
+// the workers. It returns ErrAlreadyClaimed while another request holds the key and ErrAlreadyProcessed once
+// it was processed. A request claiming a key it already holds gets its claim back. The claim must be
+// completed or released, it expires after ClaimTTL otherwise
+func (fRuntime *FlowRuntime) Claim(ctx context.Context, key string, flowName string, requestID string) (*Claim, error) {
+	if key == "" || requestID == "" {
+		return nil, fmt.Errorf("idempotency key and request id must be provided to claim")
+	}
+	claim := &Claim{
+		Key:       key,
+		FlowName:  flowName,
+		RequestID: requestID,
+		WorkerID:  fRuntime.WorkerID(),
+		State:     ClaimStateProcessing,
+		ClaimedAt: fRuntime.clock().Now(),
+		runtime:   fRuntime,
+	}
+	data, _ := json.Marshal(claim)
+
+	rdb := fRuntime.redisClient()
+	claimed, err := rdb.SetNX(ctx, claimKey(key), data, fRuntime.claimTTL()).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim %s, error %v", key, err)
+	}
+	if !claimed {
+		existing, err := fRuntime.GetClaim(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		if existing == nil {
+			// released in between
+			return fRuntime.Claim(ctx, key, flowName, requestID)
+		}
+		if existing.State == ClaimStateProcessed {
+			return nil, fmt.Errorf("%w, %s by request %s", ErrAlreadyProcessed, key, existing.RequestID)
+		}
+		if existing.RequestID != requestID {
+			return nil, fmt.Errorf("%w, %s by request %s on worker %s", ErrAlreadyClaimed, key, existing.RequestID, existing.WorkerID)
+		}
+		claim = existing
+	}
+
+	if err := rdb.Set(ctx, claimRequestKey(requestID), key, fRuntime.claimTTL()).Err(); err != nil {
+		return nil, fmt.Errorf("failed to claim %s, error %v", key, err)
+	}
+	return claim, nil
+}
+
+// GetClaim returns the claim of an idempotency key, nil if not claimed
+func (fRuntime *FlowRuntime) GetClaim(ctx context.Context, key string) (*Claim, error) {
+	value, err := fRuntime.redisClient().Get(ctx, claimKey(key)).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get claim %s, error %v", key, err)
+	}
+	claim := &Claim{runtime: fRuntime}
+	if err := json.Unmarshal([]byte(value), claim); err != nil {
+		return nil, fmt.Errorf("failed to parse claim %s, error %v", key, err)
+	}
+	return claim, nil
+}
+
+// Complete marks the key as processed, the next claims of the key fail with ErrAlreadyProcessed
+func (claim *Claim) Complete(ctx context.Context) error {
+	processed := *claim
+	processed.State = ClaimStateProcessed
+	data, _ := json.Marshal(&processed)
+	if err := claim.update(ctx, string(data), ClaimProcessedTTL); err != nil {
+		return err
+	}
+	claim.State = ClaimStateProcessed
+	return nil
+}
+
+// Release gives up the claim so that the key can be processed again, e.g. after a failure
+func (claim *Claim) Release(ctx context.Context) error {
+	return claim.update(ctx, "", 0)
+}
+
+func (claim *Claim) update(ctx context.Context, value string, ttl time.Duration) error {
+	rdb := claim.runtime.redisClient()
+	updated, err := updateClaimScript.Run(ctx, rdb, []string{claimKey(claim.Key)},
+		claim.RequestID, value, ttl.Milliseconds()).Int()
+	if err != nil {
+		return fmt.Errorf("failed to update claim %s, error %v", claim.Key, err)
+	}
+	rdb.Del(ctx, claimRequestKey(claim.RequestID))
+	if updated == 0 {
+		return fmt.Errorf("claim %s is no longer held by request %s", claim.Key, claim.RequestID)
+	}
+	return nil
+}
+
+// claimRequest claims the idempotency key of a new request if it has one, it returns false when
+// the request must be skipped as the key is claimed or processed by another request
+func (fRuntime *FlowRuntime) claimRequest(request *runtime.Request) (bool, error) {
+	key := request.GetHeader(IdempotencyKeyHeader)
+	if key == "" {
+		return true, nil
+	}
+	_, err := fRuntime.Claim(context.TODO(), key, request.FlowName, request.RequestID)
+	switch {
+	case errors.Is(err, ErrAlreadyProcessed):
+		duplicateRequestsCounter.Inc(request.FlowName, DuplicateReasonProcessed)
+	case errors.Is(err, ErrAlreadyClaimed):
+		duplicateRequestsCounter.Inc(request.FlowName, DuplicateReasonClaimed)
+	default:
+		return err == nil, err
+	}
+	fRuntime.Logger.Log(fmt.Sprintf("[request `%s`] skipped, idempotency key %v", request.RequestID, err))
+	return false, nil
+}
+
+// finishClaim completes the claim held by a request that completed, or releases it when the request
+// failed or was stopped
+func (fRuntime *FlowRuntime) finishClaim(requestID string, completed bool) {
+	claimKey := fRuntime.redisClient().Get(context.TODO(), claimRequestKey(requestID))
+	fRuntime.finishFetchedClaim(requestID, claimKey, completed)
+}
+
+// finishFetchedClaim finishes the claim held by a request, claimKey is the key of the claim of the request fetched
+// along with other bookkeeping
+func (fRuntime *FlowRuntime) finishFetchedClaim(requestID string, claimKey *redis.StringCmd, completed bool) {
+	ctx := context.TODO()
+	key, err := claimKey.Result()
+	if err == redis.Nil {
+		return
+	}
+	if err != nil {
+		fRuntime.Logger.Log(fmt.Sprintf("[request `%s`] failed to get claim, error %v", requestID, err))
+		return
+	}
+
+	claim := &Claim{Key: key, RequestID: requestID, runtime: fRuntime}
+	if completed {
+		if existing, err := fRuntime.GetClaim(ctx, key); err == nil && existing != nil {
+			claim = existing
+		}
+		err = claim.Complete(ctx)
+	} else {
+		err = claim.Release(ctx)
+	}
+	if err != nil {
+		fRuntime.Logger.Log(fmt.Sprintf("[request `%s`] failed to finish claim, error %v", requestID, err))
+	}
+}
@@ -0,0 +1,60 @@
+package runtime_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/alphadose/haxmap"
+	runtimepkg "github.com/yuyang0/goflow/core/runtime"
+	"github.com/yuyang0/goflow/runtime"
+	"github.com/yuyang0/goflow/types"
+	goflow "github.com/yuyang0/goflow/v1"
+)
+
+// TestRedeliveredRequestReclaimsKey checks that the task of a request with an idempotency key is published with
+// its id, so that its redelivery, after the worker holding the claim died, gets the claim back and is processed
+func TestRedeliveredRequestReclaimsKey(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := &runtime.FlowRuntime{
+		Flows:    haxmap.New[string, runtime.FlowDefinitionHandler](),
+		RedisCfg: types.RedisConfig{Addr: mr.Addr()},
+	}
+	if err := client.Init(); err != nil {
+		t.Fatal(err)
+	}
+	request := &runtimepkg.Request{
+		Body:   []byte("{}"),
+		Header: map[string][]string{runtime.IdempotencyKeyHeader: {"redelivered"}},
+	}
+	if err := client.Execute("claimed", request); err != nil {
+		t.Fatal(err)
+	}
+	if request.RequestID == "" {
+		t.Fatal("expected the request with an idempotency key to be published with its id")
+	}
+	// the key claimed by the delivery of the task to a worker that died
+	if _, err := client.Claim(context.Background(), "redelivered", "claimed", request.RequestID); err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	executed := 0
+	fs := &goflow.FlowService{RedisCfg: client.RedisCfg, WorkerConcurrency: 2, CleanerInterval: time.Hour}
+	if err := fs.Register("claimed", countingFlow(&mu, &executed, nil)); err != nil {
+		t.Fatal(err)
+	}
+	go fs.StartWorker()
+
+	eventually(t, 10*time.Second, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return executed == 1
+	}, "the redelivered request was skipped as a duplicate of itself")
+	eventually(t, 5*time.Second, func() bool {
+		claim, err := client.GetClaim(context.Background(), "redelivered")
+		return err == nil && claim != nil && claim.State == runtime.ClaimStateProcessed
+	}, "the claim of the redelivered request was not completed")
+}
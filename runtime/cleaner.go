@@ -0,0 +1,50 @@
+package runtime
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/adjust/rmq/v5"
+)
+
+const (
+	DefaultCleanerInterval = time.Minute
+
+	LeaderRoleCleaner = "cleaner"
+)
+
+// cleanerInterval returns the interval at which abandoned deliveries are reclaimed
+func (fRuntime *FlowRuntime) cleanerInterval() time.Duration {
+	if fRuntime.CleanerInterval < time.Second {
+		return DefaultCleanerInterval
+	}
+	return fRuntime.CleanerInterval
+}
+
+// cleanDeliveries returns the unacked deliveries of dead consumers to their ready queue, and requeues the
+// tasks of the queues of dead workers to the queues of their flow. Only the leader of the cleaner role performs
+// the cleanup
+func (fRuntime *FlowRuntime) cleanDeliveries() {
+	leader, err := fRuntime.acquireLeadership(context.TODO(), LeaderRoleCleaner, 2*fRuntime.cleanerInterval())
+	if err != nil {
+		fRuntime.Logger.Log(fmt.Sprintf("[goflow] failed to run cleaner, error %v", err))
+		return
+	}
+	if !leader {
+		return
+	}
+
+	returned, err := rmq.NewCleaner(fRuntime.rmqConnection).Clean()
+	if err != nil {
+		fRuntime.Logger.Log(fmt.Sprintf("[goflow] failed to clean abandoned deliveries, error %v", err))
+		return
+	}
+	if returned > 0 {
+		fRuntime.Logger.Log(fmt.Sprintf("[goflow] cleaner returned %d abandoned deliveries", returned))
+	}
+
+	if err := fRuntime.requeueStrandedTasks(context.TODO()); err != nil {
+		fRuntime.Logger.Log(fmt.Sprintf("[goflow] failed to requeue tasks of dead workers, error %v", err))
+	}
+}
@@ -0,0 +1,143 @@
+package runtime_test
+
+import (
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/adjust/rmq/v5"
+	"github.com/alicebob/miniredis/v2"
+	flow "github.com/yuyang0/goflow/flow/v1"
+	"github.com/yuyang0/goflow/runtime"
+	"github.com/yuyang0/goflow/types"
+	goflow "github.com/yuyang0/goflow/v1"
+)
+
+// TestCleanerReturnsDeliveriesOfDeadConsumer checks that the request delivered to a consumer which died before
+// acknowledging it is returned to the ready queue by the cleaner and executed by a live worker
+func TestCleanerReturnsDeliveriesOfDeadConsumer(t *testing.T) {
+	mr := miniredis.RunT(t)
+	cfg := types.RedisConfig{Addr: mr.Addr()}
+
+	// the dead consumer takes the request and never acknowledges it
+	deadClient := cfg.NewRedisClient()
+	dead, err := rmq.OpenConnectionWithRedisClient("dead", deadClient, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	queue, err := dead.OpenQueue(runtime.InternalRequestQueueInitial + ":cleaned")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := queue.StartConsuming(1, 10*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	delivered := make(chan struct{})
+	var deliverOnce sync.Once
+	if _, err := queue.AddConsumerFunc("dead", func(rmq.Delivery) {
+		deliverOnce.Do(func() { close(delivered) })
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	client := &goflow.FlowService{RedisCfg: cfg}
+	if err := client.Execute("cleaned", &goflow.Request{Body: []byte("{}")}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-delivered:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the request was not delivered to the dead consumer")
+	}
+	// the heartbeat of the dead consumer expires once it can't reach redis anymore
+	deadClient.Close()
+	mr.FastForward(2 * time.Minute)
+
+	var mu sync.Mutex
+	executed := 0
+	handler := func(wf *flow.Workflow, _ *flow.Context) error {
+		wf.Dag().Node("work", func(data []byte, _ map[string][]string) ([]byte, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			executed++
+			return data, nil
+		})
+		return nil
+	}
+	fs := &goflow.FlowService{RedisCfg: cfg, WorkerConcurrency: 1, CleanerInterval: time.Second}
+	if err := fs.Register("cleaned", handler); err != nil {
+		t.Fatal(err)
+	}
+	go fs.StartWorker()
+
+	eventually(t, 15*time.Second, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return executed > 0
+	}, "the request of the dead consumer was not returned to the ready queue")
+	mu.Lock()
+	defer mu.Unlock()
+	if executed != 1 {
+		t.Fatalf("expected the returned request to be executed once, got %d", executed)
+	}
+}
+
+// TestCleanerRequeuesTasksOfDeadWorker checks that a request routed to the queue of a worker that went down is
+// moved to the queue of its flow by the cleaner and executed by a live worker
+func TestCleanerRequeuesTasksOfDeadWorker(t *testing.T) {
+	mr := miniredis.RunT(t)
+	cfg := types.RedisConfig{Addr: mr.Addr()}
+
+	connection, err := rmq.OpenConnectionWithRedisClient("stranding", cfg.NewRedisClient(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	queue, err := connection.OpenQueue(runtime.WorkerQueueInitial + ":dead")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(&runtime.Task{FlowName: "stranded", RequestID: "stranded-request",
+		Body: []byte("{}"), RequestType: runtime.NewRequest})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := queue.PublishBytes(data); err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	executed := 0
+	handler := func(wf *flow.Workflow, _ *flow.Context) error {
+		wf.Dag().Node("work", func(data []byte, _ map[string][]string) ([]byte, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			executed++
+			return data, nil
+		})
+		return nil
+	}
+	fs := &goflow.FlowService{RedisCfg: cfg, WorkerConcurrency: 1, CleanerInterval: time.Second}
+	if err := fs.Register("stranded", handler); err != nil {
+		t.Fatal(err)
+	}
+	go fs.StartWorker()
+
+	eventually(t, 15*time.Second, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return executed > 0
+	}, "the request of the dead worker was not requeued")
+	eventually(t, 5*time.Second, func() bool {
+		queues, err := connection.GetOpenQueues()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, queueId := range queues {
+			if queueId == runtime.WorkerQueueInitial+":dead" {
+				return false
+			}
+		}
+		return true
+	}, "the queue of the dead worker was not removed")
+}
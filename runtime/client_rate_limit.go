@@ -0,0 +1,145 @@
+package runtime
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/xid"
+	"github.com/yuyang0/goflow/metrics"
+)
+
+const (
+	ClientRateKeyInitial = "goflow-client-rate"
+
+	RetryAfterHeader = "Retry-After"
+
+	RateLimitClassSubmit  = "submit"
+	RateLimitClassControl = "control"
+
+	RateLimitResultAllowed   = "allowed"
+	RateLimitResultThrottled = "throttled"
+)
+
+var clientRequestsCounter = metrics.NewCounterVec("goflow_client_requests_total",
+	"Submit and control requests of each client by rate limit result", "client", "class", "result")
+
+// RateLimit allows Requests in any sliding Window, a zero value disables the limit
+type RateLimit struct {
+	Requests int           `json:"requests"`
+	Window   time.Duration `json:"window"`
+}
+
+func (limit RateLimit) enabled() bool {
+	return limit.Requests > 0 && limit.Window > 0
+}
+
+// ClientRateLimits are the limits of the http requests of a client, the control requests
+// (pause, resume and stop) are limited separately from the submissions
+type ClientRateLimits struct {
+	Submit  RateLimit `json:"submit"`
+	Control RateLimit `json:"control"`
+}
+
+// slidingWindowScript adds a request to the sorted set KEYS[1] if it holds less than ARGV[3] requests over
+// the last ARGV[2] milliseconds, it returns 0 when added or the milliseconds until a request can be added
+var slidingWindowScript = redis.NewScript(`
+local now = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+redis.call("ZREMRANGEBYSCORE", KEYS[1], "-inf", now - window)
+if redis.call("ZCARD", KEYS[1]) < tonumber(ARGV[3]) then
+	redis.call("ZADD", KEYS[1], now, ARGV[4])
+	redis.call("PEXPIRE", KEYS[1], window)
+	return 0
+end
+local oldest = redis.call("ZRANGE", KEYS[1], 0, 0, "WITHSCORES")
+return math.max(tonumber(oldest[2]) + window - now, 1)
+`)
+
+// SetClientRateLimits overrides the rate limits of a client, identified as returned by clientIdentity
+func (fRuntime *FlowRuntime) SetClientRateLimits(client string, limits ClientRateLimits) {
+	fRuntime.clientRateLimitsMu.Lock()
+	defer fRuntime.clientRateLimitsMu.Unlock()
+	if fRuntime.clientRateLimits == nil {
+		fRuntime.clientRateLimits = make(map[string]ClientRateLimits)
+	}
+	fRuntime.clientRateLimits[client] = limits
+}
+
+// RemoveClientRateLimits removes the override of the rate limits of a client, the default limits apply again
+func (fRuntime *FlowRuntime) RemoveClientRateLimits(client string) {
+	fRuntime.clientRateLimitsMu.Lock()
+	defer fRuntime.clientRateLimitsMu.Unlock()
+	delete(fRuntime.clientRateLimits, client)
+}
+
+// clientRateLimit returns the limit of a class of requests of a client
+func (fRuntime *FlowRuntime) clientRateLimit(client string, class string) RateLimit {
+	fRuntime.clientRateLimitsMu.RLock()
+	limits, ok := fRuntime.clientRateLimits[client]
+	fRuntime.clientRateLimitsMu.RUnlock()
+	if !ok {
+		limits = fRuntime.DefaultClientRateLimits
+	}
+	if class == RateLimitClassSubmit {
+		return limits.Submit
+	}
+	return limits.Control
+}
+
+// clientIdentity identifies the client of a request by its principal, e.g. `jwt:alice`,
+// or by its ip address for anonymous requests, e.g. `ip:10.0.0.1`
+func clientIdentity(c *gin.Context, principal Principal) string {
+	if principal.Method == PrincipalMethodAnonymous {
+		return "ip:" + c.ClientIP()
+	}
+	return principal.Method + ":" + principal.Name
+}
+
+// clientMetricLabel returns the client label of the metrics, the anonymous clients share the `anonymous` label as
+// their ip addresses are unbounded
+func clientMetricLabel(principal Principal, client string) string {
+	if principal.Method == PrincipalMethodAnonymous {
+		return PrincipalMethodAnonymous
+	}
+	return client
+}
+
+// throttleRequest checks that a client is within the rate limit of the action, it responds with 429
+// along with a Retry-After header otherwise. The requests are allowed if redis can't be reached
+func (fRuntime *FlowRuntime) throttleRequest(c *gin.Context, principal Principal, action string) bool {
+	class := RateLimitClassControl
+	if action == ActionSubmit {
+		class = RateLimitClassSubmit
+	}
+	client := clientIdentity(c, principal)
+	limit := fRuntime.clientRateLimit(client, class)
+	if !limit.enabled() {
+		return true
+	}
+
+	now := fRuntime.clock().Now().UnixMilli()
+	key := fmt.Sprintf("%s:%s:%s", ClientRateKeyInitial, class, client)
+	wait, err := slidingWindowScript.Run(context.TODO(), fRuntime.redisClient(), []string{key},
+		now, limit.Window.Milliseconds(), limit.Requests, strconv.FormatInt(now, 10)+"-"+xid.New().String()).Int64()
+	if err != nil {
+		fRuntime.Logger.Log(fmt.Sprintf("[goflow] failed to check rate limit of client %s, error %v", client, err))
+		return true
+	}
+	if wait == 0 {
+		clientRequestsCounter.Inc(clientMetricLabel(principal, client), class, RateLimitResultAllowed)
+		return true
+	}
+
+	clientRequestsCounter.Inc(clientMetricLabel(principal, client), class, RateLimitResultThrottled)
+	retryAfter := int64(math.Ceil(float64(wait) / 1000))
+	c.Header(RetryAfterHeader, strconv.FormatInt(retryAfter, 10))
+	c.String(http.StatusTooManyRequests, "rate limit of %d %s requests per %s exceeded by client %s",
+		limit.Requests, class, limit.Window, client)
+	return false
+}
@@ -0,0 +1,11 @@
+package runtime
+
+import "github.com/yuyang0/goflow/runtime/clock"
+
+// clock returns the time source of the runtime, the real clock if Clock is not set
+func (fRuntime *FlowRuntime) clock() clock.Clock {
+	if fRuntime.Clock == nil {
+		return clock.Real{}
+	}
+	return fRuntime.Clock
+}
@@ -0,0 +1,91 @@
+// Package clock provides the time source of the runtime, so that it can be replaced in tests, see clocktest
+package clock
+
+import (
+	"context"
+	"time"
+)
+
+// Clock is the time source of the schedules, deadlines, grace periods, watchdogs and heartbeats of the runtime
+type Clock interface {
+	Now() time.Time
+	NewTimer(d time.Duration) Timer
+	NewTicker(d time.Duration) Ticker
+	Sleep(d time.Duration)
+}
+
+// Timer is a time.Timer of a Clock
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+	Reset(d time.Duration) bool
+}
+
+// Ticker is a time.Ticker of a Clock
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// Real is the Clock of the time package
+type Real struct{}
+
+func (Real) Now() time.Time { return time.Now() }
+
+func (Real) NewTimer(d time.Duration) Timer { return &realTimer{timer: time.NewTimer(d)} }
+
+func (Real) NewTicker(d time.Duration) Ticker { return &realTicker{ticker: time.NewTicker(d)} }
+
+func (Real) Sleep(d time.Duration) { time.Sleep(d) }
+
+type realTimer struct {
+	timer *time.Timer
+}
+
+func (t *realTimer) C() <-chan time.Time { return t.timer.C }
+
+func (t *realTimer) Stop() bool { return t.timer.Stop() }
+
+func (t *realTimer) Reset(d time.Duration) bool { return t.timer.Reset(d) }
+
+type realTicker struct {
+	ticker *time.Ticker
+}
+
+func (t *realTicker) C() <-chan time.Time { return t.ticker.C }
+
+func (t *realTicker) Stop() { t.ticker.Stop() }
+
+// WithDeadline returns a copy of the parent context canceled once the clock reaches the deadline, with
+// context.DeadlineExceeded as its error, as context.WithDeadline does for the real clock
+func WithDeadline(parent context.Context, c Clock, deadline time.Time) (context.Context, context.CancelFunc) {
+	if _, ok := c.(Real); ok {
+		return context.WithDeadline(parent, deadline)
+	}
+	ctx, cancel := context.WithCancelCause(parent)
+	timer := c.NewTimer(deadline.Sub(c.Now()))
+	go func() {
+		defer timer.Stop()
+		select {
+		case <-timer.C():
+			cancel(context.DeadlineExceeded)
+		case <-ctx.Done():
+		}
+	}()
+	return &deadlineContext{Context: ctx, deadline: deadline}, func() { cancel(context.Canceled) }
+}
+
+// deadlineContext is a context canceled at the deadline of a clock other than the real one
+type deadlineContext struct {
+	context.Context
+	deadline time.Time
+}
+
+func (ctx *deadlineContext) Deadline() (time.Time, bool) { return ctx.deadline, true }
+
+func (ctx *deadlineContext) Err() error {
+	if ctx.Context.Err() == nil {
+		return nil
+	}
+	return context.Cause(ctx.Context)
+}
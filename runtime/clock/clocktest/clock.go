@@ -0,0 +1,167 @@
+// Package clocktest provides a fake clock.Clock, so that the tests of the schedules, deadlines and watchdogs of
+// the runtime run without waiting for the real time to pass
+package clocktest
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/yuyang0/goflow/runtime/clock"
+)
+
+// FakeClock is a clock.Clock whose time only moves with Advance and Set. Its timers and tickers fire as the
+// time moves past them, and Sleep blocks until it does
+type FakeClock struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	now     time.Time
+	waiters []*waiter
+}
+
+// waiter is a timer, a ticker or a sleep waiting for the time to reach at
+type waiter struct {
+	fake   *FakeClock
+	at     time.Time
+	period time.Duration // the period of a ticker, zero for a timer
+	c      chan time.Time
+}
+
+// NewFakeClock returns a FakeClock set at now
+func NewFakeClock(now time.Time) *FakeClock {
+	fc := &FakeClock{now: now}
+	fc.cond = sync.NewCond(&fc.mu)
+	return fc
+}
+
+func (fc *FakeClock) Now() time.Time {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	return fc.now
+}
+
+func (fc *FakeClock) NewTimer(d time.Duration) clock.Timer {
+	return fc.addWaiter(d, 0)
+}
+
+func (fc *FakeClock) NewTicker(d time.Duration) clock.Ticker {
+	if d <= 0 {
+		panic("non-positive interval for NewTicker")
+	}
+	return &fakeTicker{waiter: fc.addWaiter(d, d)}
+}
+
+func (fc *FakeClock) Sleep(d time.Duration) {
+	<-fc.addWaiter(d, 0).c
+}
+
+// Advance moves the time forward by d, firing the timers and tickers due in order
+func (fc *FakeClock) Advance(d time.Duration) {
+	fc.Set(fc.Now().Add(d))
+}
+
+// Set moves the time to now, firing the timers and tickers due in order. The time never goes backward
+func (fc *FakeClock) Set(now time.Time) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	if now.Before(fc.now) {
+		return
+	}
+	for {
+		sort.SliceStable(fc.waiters, func(i, j int) bool {
+			return fc.waiters[i].at.Before(fc.waiters[j].at)
+		})
+		if len(fc.waiters) == 0 || fc.waiters[0].at.After(now) {
+			break
+		}
+		w := fc.waiters[0]
+		fc.now = w.at
+		// as the ones of the time package, the channels drop the ticks of a slow receiver
+		select {
+		case w.c <- w.at:
+		default:
+		}
+		if w.period > 0 {
+			w.at = w.at.Add(w.period)
+		} else {
+			fc.waiters = fc.waiters[1:]
+		}
+	}
+	fc.now = now
+}
+
+// Waiters returns the number of the timers, tickers and sleeps pending
+func (fc *FakeClock) Waiters() int {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	return len(fc.waiters)
+}
+
+// BlockUntil blocks until at least n timers, tickers and sleeps are pending, e.g. for a goroutine under test to
+// reach its Sleep before the time is advanced
+func (fc *FakeClock) BlockUntil(n int) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	for len(fc.waiters) < n {
+		fc.cond.Wait()
+	}
+}
+
+func (fc *FakeClock) addWaiter(d time.Duration, period time.Duration) *waiter {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	w := &waiter{fake: fc, at: fc.now.Add(d), period: period, c: make(chan time.Time, 1)}
+	if d <= 0 {
+		w.c <- fc.now
+		return w
+	}
+	fc.waiters = append(fc.waiters, w)
+	fc.cond.Broadcast()
+	return w
+}
+
+// remove removes a waiter, it returns whether it was pending
+func (w *waiter) remove() bool {
+	for idx, pending := range w.fake.waiters {
+		if pending == w {
+			w.fake.waiters = append(w.fake.waiters[:idx], w.fake.waiters[idx+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func (w *waiter) C() <-chan time.Time { return w.c }
+
+func (w *waiter) Stop() bool {
+	w.fake.mu.Lock()
+	defer w.fake.mu.Unlock()
+	return w.remove()
+}
+
+func (w *waiter) Reset(d time.Duration) bool {
+	w.fake.mu.Lock()
+	defer w.fake.mu.Unlock()
+	active := w.remove()
+	w.at = w.fake.now.Add(d)
+	if d <= 0 {
+		select {
+		case w.c <- w.fake.now:
+		default:
+		}
+		return active
+	}
+	w.fake.waiters = append(w.fake.waiters, w)
+	w.fake.cond.Broadcast()
+	return active
+}
+
+type fakeTicker struct {
+	*waiter
+}
+
+func (t *fakeTicker) Stop() {
+	t.waiter.Stop()
+}
+
+var _ clock.Clock = (*FakeClock)(nil)
@@ -0,0 +1,141 @@
+package clocktest_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/yuyang0/goflow/runtime/clock"
+	"github.com/yuyang0/goflow/runtime/clock/clocktest"
+)
+
+var epoch = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func fired(c <-chan time.Time) (time.Time, bool) {
+	select {
+	case at := <-c:
+		return at, true
+	default:
+		return time.Time{}, false
+	}
+}
+
+func TestTimersFireAsTheTimeMoves(t *testing.T) {
+	fc := clocktest.NewFakeClock(epoch)
+	late := fc.NewTimer(2 * time.Minute)
+	early := fc.NewTimer(time.Minute)
+	stopped := fc.NewTimer(time.Minute)
+	if !stopped.Stop() {
+		t.Fatal("expected the pending timer to be stopped")
+	}
+
+	fc.Advance(90 * time.Second)
+	if at, ok := fired(early.C()); !ok || !at.Equal(epoch.Add(time.Minute)) {
+		t.Fatalf("expected the timer of a minute to fire at its due time, got %v %v", at, ok)
+	}
+	if _, ok := fired(late.C()); ok {
+		t.Fatal("expected the timer of two minutes not to fire yet")
+	}
+	if _, ok := fired(stopped.C()); ok {
+		t.Fatal("expected the stopped timer not to fire")
+	}
+	if now := fc.Now(); !now.Equal(epoch.Add(90 * time.Second)) {
+		t.Fatalf("expected the clock to be moved by 90s, got %v", now)
+	}
+
+	// a reset timer is due from the current time
+	late.Reset(time.Minute)
+	fc.Advance(59 * time.Second)
+	if _, ok := fired(late.C()); ok {
+		t.Fatal("expected the reset timer not to fire before its new due time")
+	}
+	fc.Advance(time.Second)
+	if _, ok := fired(late.C()); !ok {
+		t.Fatal("expected the reset timer to fire at its new due time")
+	}
+	if fc.Waiters() != 0 {
+		t.Fatalf("expected no pending waiters, got %d", fc.Waiters())
+	}
+}
+
+func TestTickerFiresEveryPeriod(t *testing.T) {
+	fc := clocktest.NewFakeClock(epoch)
+	ticker := fc.NewTicker(time.Second)
+	for idx := 1; idx <= 3; idx++ {
+		fc.Advance(time.Second)
+		if at, ok := fired(ticker.C()); !ok || !at.Equal(epoch.Add(time.Duration(idx)*time.Second)) {
+			t.Fatalf("expected tick %d, got %v %v", idx, at, ok)
+		}
+	}
+	// as a time.Ticker, the ticks missed by a slow receiver are dropped
+	fc.Advance(5 * time.Second)
+	if _, ok := fired(ticker.C()); !ok {
+		t.Fatal("expected a tick after 5 periods")
+	}
+	if _, ok := fired(ticker.C()); ok {
+		t.Fatal("expected the ticks missed to be dropped")
+	}
+	ticker.Stop()
+	fc.Advance(time.Second)
+	if _, ok := fired(ticker.C()); ok {
+		t.Fatal("expected the stopped ticker not to tick")
+	}
+}
+
+func TestSleepBlocksUntilAdvanced(t *testing.T) {
+	fc := clocktest.NewFakeClock(epoch)
+	woken := make(chan time.Time)
+	go func() {
+		fc.Sleep(time.Hour)
+		woken <- fc.Now()
+	}()
+	fc.BlockUntil(1)
+	fc.Advance(30 * time.Minute)
+	select {
+	case <-woken:
+		t.Fatal("expected the sleep to last an hour")
+	case <-time.After(10 * time.Millisecond):
+	}
+	fc.Advance(30 * time.Minute)
+	if now := <-woken; !now.Equal(epoch.Add(time.Hour)) {
+		t.Fatalf("expected the sleep to end an hour later, got %v", now)
+	}
+}
+
+func TestTimeNeverGoesBackward(t *testing.T) {
+	fc := clocktest.NewFakeClock(epoch)
+	fc.Set(epoch.Add(-time.Hour))
+	if now := fc.Now(); !now.Equal(epoch) {
+		t.Fatalf("expected the clock to stay at %v, got %v", epoch, now)
+	}
+}
+
+func TestWithDeadlineFollowsClock(t *testing.T) {
+	fc := clocktest.NewFakeClock(epoch)
+	ctx, cancel := clock.WithDeadline(context.Background(), fc, epoch.Add(time.Minute))
+	defer cancel()
+	if deadline, ok := ctx.Deadline(); !ok || !deadline.Equal(epoch.Add(time.Minute)) {
+		t.Fatalf("expected the deadline to be set, got %v", deadline)
+	}
+	fc.BlockUntil(1)
+
+	fc.Advance(30 * time.Second)
+	if ctx.Err() != nil {
+		t.Fatalf("expected the context to be live before the deadline, got %v", ctx.Err())
+	}
+	fc.Advance(30 * time.Second)
+	select {
+	case <-ctx.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the context to be done at the deadline")
+	}
+	if ctx.Err() != context.DeadlineExceeded {
+		t.Fatalf("expected the context to exceed its deadline, got %v", ctx.Err())
+	}
+
+	ctx, cancel = clock.WithDeadline(context.Background(), fc, fc.Now().Add(time.Minute))
+	cancel()
+	if ctx.Err() != context.Canceled {
+		t.Fatalf("expected the canceled context to be canceled, got %v", ctx.Err())
+	}
+}
@@ -0,0 +1,172 @@
+package runtime_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alphadose/haxmap"
+	flow "github.com/yuyang0/goflow/flow/v1"
+	"github.com/yuyang0/goflow/runtime"
+	"github.com/yuyang0/goflow/runtime/clock/clocktest"
+	"github.com/yuyang0/goflow/types"
+	goflow "github.com/yuyang0/goflow/v1"
+)
+
+// recordingLogger records the logs of the runtime
+type recordingLogger struct {
+	mu   sync.Mutex
+	logs []string
+}
+
+func (logger *recordingLogger) Configure(string, string) {}
+
+func (logger *recordingLogger) Init() error { return nil }
+
+func (logger *recordingLogger) Log(str string) {
+	logger.mu.Lock()
+	defer logger.mu.Unlock()
+	logger.logs = append(logger.logs, str)
+}
+
+func (logger *recordingLogger) contains(substr string) bool {
+	logger.mu.Lock()
+	defer logger.mu.Unlock()
+	for _, log := range logger.logs {
+		if strings.Contains(log, substr) {
+			return true
+		}
+	}
+	return false
+}
+
+// countingFlow counts the executions of its single node, which fails with err when set
+func countingFlow(mu *sync.Mutex, executed *int, err error) runtime.FlowDefinitionHandler {
+	return func(wf *flow.Workflow, _ *flow.Context) error {
+		wf.Dag().Node("count", func(data []byte, _ map[string][]string) ([]byte, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			*executed++
+			return data, err
+		})
+		return nil
+	}
+}
+
+func TestScheduledRequestFollowsClock(t *testing.T) {
+	fc := clocktest.NewFakeClock(time.Now())
+	var mu sync.Mutex
+	executed := 0
+	fs := &goflow.FlowService{Clock: fc}
+	_, client := startWorker(t, fs, map[string]runtime.FlowDefinitionHandler{"scheduled": countingFlow(&mu, &executed, nil)}, nil)
+	// the ticker of the scheduler polls
+	fc.BlockUntil(1)
+	if err := client.ExecuteAt("scheduled", &goflow.Request{Body: []byte("{}")}, fc.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	fc.Advance(59 * time.Minute)
+	time.Sleep(300 * time.Millisecond)
+	mu.Lock()
+	if executed != 0 {
+		mu.Unlock()
+		t.Fatal("expected the request not to be submitted before its time")
+	}
+	mu.Unlock()
+
+	fc.Advance(time.Minute)
+	eventually(t, 5*time.Second, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return executed == 1
+	}, "the scheduled request was not submitted once due")
+}
+
+func TestSaturationWatchdogFollowsClock(t *testing.T) {
+	fc := clocktest.NewFakeClock(time.Now())
+	logger := &recordingLogger{}
+	release := make(chan struct{})
+	var mu sync.Mutex
+	started := 0
+	blocking := func(wf *flow.Workflow, _ *flow.Context) error {
+		wf.Dag().Node("block", func(data []byte, _ map[string][]string) ([]byte, error) {
+			mu.Lock()
+			started++
+			mu.Unlock()
+			<-release
+			return data, nil
+		})
+		return nil
+	}
+	fs := &goflow.FlowService{Clock: fc, Logger: logger, MaxGoroutinesPerWorker: 1, SaturationWarnThreshold: time.Minute}
+	_, client := startWorker(t, fs, map[string]runtime.FlowDefinitionHandler{"saturated": blocking}, nil)
+	defer close(release)
+	for idx := 0; idx < 2; idx++ {
+		if err := client.Execute("saturated", &goflow.Request{Body: []byte("{}")}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	eventually(t, 5*time.Second, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return started == 1
+	}, "the first request did not take the execution slot")
+	if logger.contains("worker saturated") {
+		t.Fatal("expected no saturation warning before the threshold")
+	}
+
+	// the second request waits for the slot, the clock is moved until its watchdog fires
+	eventually(t, 5*time.Second, func() bool {
+		fc.Advance(time.Minute)
+		return logger.contains("worker saturated")
+	}, "the saturation was not reported once the threshold elapsed")
+}
+
+func TestErrorBudgetWindowFollowsClock(t *testing.T) {
+	fc := clocktest.NewFakeClock(time.Now())
+	var mu sync.Mutex
+	executed := 0
+	options := runtime.FlowOptions{ErrorBudgetRules: []runtime.ErrorBudgetRule{
+		{Name: "failures", Window: 5 * time.Minute, MaxFailureRate: 0.5, MinRequests: 1},
+	}}
+	fs := &goflow.FlowService{Clock: fc}
+	mr, client := startWorker(t, fs,
+		map[string]runtime.FlowDefinitionHandler{"budget": countingFlow(&mu, &executed, errors.New("failed"))},
+		map[string]runtime.FlowOptions{"budget": options})
+	for idx := 0; idx < 2; idx++ {
+		if err := client.Execute("budget", &goflow.Request{Body: []byte("{}")}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	fRuntime := &runtime.FlowRuntime{
+		Flows:    haxmap.New[string, runtime.FlowDefinitionHandler](),
+		RedisCfg: types.RedisConfig{Addr: mr.Addr()},
+		Clock:    fc,
+	}
+	if err := fRuntime.Init(); err != nil {
+		t.Fatal(err)
+	}
+	failures := func() int64 {
+		t.Helper()
+		statuses, err := fRuntime.GetErrorBudget(context.Background(), "budget")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(statuses) != 1 {
+			t.Fatalf("expected the status of the rule, got %v", statuses)
+		}
+		return statuses[0].Failures
+	}
+	eventually(t, 5*time.Second, func() bool {
+		return failures() == 2
+	}, "the failures were not counted in the window")
+
+	fc.Advance(10 * time.Minute)
+	if count := failures(); count != 0 {
+		t.Fatalf("expected the failures to leave the window once the clock moved past it, got %d", count)
+	}
+}
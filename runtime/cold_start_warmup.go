@@ -0,0 +1,79 @@
+package runtime
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/yuyang0/goflow/core/runtime"
+	"github.com/yuyang0/goflow/core/runtime/controller"
+)
+
+// WarmupRequestPrefix prefixes the id of the warmup requests, so that they can be told apart from the real ones
+const WarmupRequestPrefix = "goflow-warmup-"
+
+// IsWarmupRequest checks if a request is a cold start warmup request of its flow
+func IsWarmupRequest(requestId string) bool {
+	return strings.HasPrefix(requestId, WarmupRequestPrefix)
+}
+
+// ExecuteSync executes a request in the calling goroutine, like a request submitted over http without `X-Async`,
+// and returns its response. The nodes following the first one are queued for the workers, but for the warmup
+// requests which execute all their nodes in the calling goroutine
+func (fRuntime *FlowRuntime) ExecuteSync(flowName string, request *runtime.Request) (*runtime.Response, error) {
+	flowName, err := fRuntime.resolveFlowName(flowName)
+	if err != nil {
+		return nil, err
+	}
+	if err := fRuntime.decodeRequestBody(request); err != nil {
+		return nil, err
+	}
+	if err := fRuntime.validateRequestBody(flowName, request.Body); err != nil {
+		return nil, err
+	}
+	request.FlowName = flowName
+	if request.RequestID == "" {
+		request.RequestID = getNewId()
+	}
+
+	ex, err := fRuntime.CreateExecutor(request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute request %s, error %v", request.RequestID, err)
+	}
+	response := &runtime.Response{}
+	response.RequestID = request.RequestID
+	response.Header = make(map[string][]string)
+	if err := controller.ExecuteFlowHandler(response, request, ex); err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+// executeWarmupContinuation executes the next nodes of a warmup in the calling goroutine instead of queueing them,
+// so that they warm up the worker executing the warmup and are never consumed by the workers from the shared queue
+func (fRuntime *FlowRuntime) executeWarmupContinuation(request *runtime.Request) error {
+	ex, err := fRuntime.CreateExecutor(request)
+	if err != nil {
+		return fmt.Errorf("failed to execute request %s, error %w", request.RequestID, err)
+	}
+	response := &runtime.Response{}
+	response.RequestID = request.RequestID
+	response.Header = make(map[string][]string)
+	return controller.PartialExecuteFlowHandler(response, request, ex)
+}
+
+// warmupFlow executes a synthetic request of a flow with the ColdStartWarmupBody of its options, so that its nodes
+// initialize before the first real request. A failing warmup is logged, the flow stays registered
+func (fRuntime *FlowRuntime) warmupFlow(flowName string, options FlowOptions) {
+	request := &runtime.Request{
+		Body:      options.ColdStartWarmupBody,
+		Header:    make(map[string][]string),
+		RequestID: WarmupRequestPrefix + getNewId(),
+		Query:     make(map[string][]string),
+	}
+	start := fRuntime.clock().Now()
+	if _, err := fRuntime.ExecuteSync(flowName, request); err != nil {
+		fRuntime.Logger.Log(fmt.Sprintf("[goflow] cold start warmup of flow %s failed, error %v", flowName, err))
+		return
+	}
+	fRuntime.Logger.Log(fmt.Sprintf("[goflow] flow %s warmed up in %v", flowName, fRuntime.clock().Now().Sub(start)))
+}
@@ -0,0 +1,74 @@
+package runtime_test
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/yuyang0/goflow/core/sdk"
+	flow "github.com/yuyang0/goflow/flow/v1"
+	"github.com/yuyang0/goflow/runtime"
+	"github.com/yuyang0/goflow/types"
+	goflow "github.com/yuyang0/goflow/v1"
+)
+
+// TestColdStartWarmupRunsAllNodesWithoutSideEffects checks that the registration runs every node of the warmup on
+// the registering worker, without queueing its continuation, applying its effects nor recording its usage
+func TestColdStartWarmupRunsAllNodesWithoutSideEffects(t *testing.T) {
+	var mu sync.Mutex
+	executed := make(map[string]string)
+	applied := 0
+	handler := func(wf *flow.Workflow, _ *flow.Context) error {
+		dag := wf.Dag()
+		dag.NodeWithContext("load", func(nodeContext *sdk.NodeContext, data []byte, _ map[string][]string) ([]byte, error) {
+			mu.Lock()
+			executed["load"] = nodeContext.GetRequestId()
+			mu.Unlock()
+			return data, nil
+		})
+		dag.NodeWithContext("notify", func(nodeContext *sdk.NodeContext, data []byte, _ map[string][]string) ([]byte, error) {
+			mu.Lock()
+			executed["notify"] = nodeContext.GetRequestId()
+			mu.Unlock()
+			return nodeContext.ApplyEffect(sdk.SideEffect{Token: "notify", Apply: func(string) ([]byte, error) {
+				mu.Lock()
+				defer mu.Unlock()
+				applied++
+				return []byte("sent"), nil
+			}})
+		})
+		dag.Edge("load", "notify")
+		return nil
+	}
+
+	mr := miniredis.RunT(t)
+	fs := &goflow.FlowService{RedisCfg: types.RedisConfig{Addr: mr.Addr()}, CleanerInterval: time.Hour}
+	err := fs.RegisterWithOptions("classify", handler, runtime.FlowOptions{
+		ColdStartWarmupEnabled: true,
+		ColdStartWarmupBody:    []byte("{}"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	for _, node := range []string{"load", "notify"} {
+		if !runtime.IsWarmupRequest(executed[node]) {
+			t.Fatalf("expected the node %s to be executed by the warmup during the registration, got %q", node, executed[node])
+		}
+	}
+	if applied != 0 {
+		t.Fatalf("expected the warmup not to apply the side effect, applied %d times", applied)
+	}
+	for _, key := range mr.Keys() {
+		if strings.HasPrefix(key, runtime.UsageKeyInitial) || strings.HasPrefix(key, runtime.AuditLogKey) {
+			t.Fatalf("expected the warmup to be left out of the usage and the audit log, found %s", key)
+		}
+		if items, _ := mr.List(key); strings.HasSuffix(key, "::ready") && len(items) > 0 {
+			t.Fatalf("expected the warmup continuation not to be queued, found %v in %s", items, key)
+		}
+	}
+}
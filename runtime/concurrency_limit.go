@@ -0,0 +1,46 @@
+package runtime
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	DefaultSaturationWarnThreshold = 10 * time.Second
+)
+
+// acquireExecutionSlot blocks until less than MaxGoroutinesPerWorker tasks are being handled by the worker,
+// across all flows. While blocked, new deliveries stay in the prefetch buffer of the queues.
+// It returns the function releasing the slot
+func (fRuntime *FlowRuntime) acquireExecutionSlot() func() {
+	if fRuntime.MaxGoroutinesPerWorker <= 0 {
+		return func() {}
+	}
+	fRuntime.executionSlotsOnce.Do(func() {
+		fRuntime.executionSlots = make(chan struct{}, fRuntime.MaxGoroutinesPerWorker)
+	})
+	release := func() { <-fRuntime.executionSlots }
+
+	select {
+	case fRuntime.executionSlots <- struct{}{}:
+		return release
+	default:
+	}
+
+	threshold := fRuntime.SaturationWarnThreshold
+	if threshold <= 0 {
+		threshold = DefaultSaturationWarnThreshold
+	}
+	timer := fRuntime.clock().NewTimer(threshold)
+	defer timer.Stop()
+
+	select {
+	case fRuntime.executionSlots <- struct{}{}:
+		return release
+	case <-timer.C():
+		fRuntime.Logger.Log(fmt.Sprintf("[goflow] worker saturated for more than %v, all %d execution slots are busy",
+			threshold, fRuntime.MaxGoroutinesPerWorker))
+	}
+	fRuntime.executionSlots <- struct{}{}
+	return release
+}
@@ -0,0 +1,124 @@
+package runtime
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+const (
+	// ConfigUpdatesChannelInitial prefixes the Pub/Sub channels the FlowOptions updates of the flows are pushed to
+	ConfigUpdatesChannelInitial = "goflow-config-updates"
+	// ConfigUpdateKeyInitial prefixes the keys the last FlowOptions update of every flow is kept in, for the
+	// runtimes started after it was pushed
+	ConfigUpdateKeyInitial = "goflow-config-update"
+)
+
+func configUpdatesChannel(flowName string) string {
+	return fmt.Sprintf("%s:%s", ConfigUpdatesChannelInitial, flowName)
+}
+
+func configUpdateKey(flowName string) string {
+	return fmt.Sprintf("%s:%s", ConfigUpdateKeyInitial, flowName)
+}
+
+// PushConfigUpdate publishes new options of a flow to all the runtimes, which apply them with UpdateFlowOptions
+// when the flow is registered. The functions of the options, e.g. Validate, can't be distributed, every runtime
+// keeps its own. The options are kept until the next update, the runtimes started later apply them too
+func (fRuntime *FlowRuntime) PushConfigUpdate(ctx context.Context, flowName string, opts FlowOptions) error {
+	flowName, err := fRuntime.resolveFlowName(flowName)
+	if err != nil {
+		return err
+	}
+	if err := validateFlowOptions(flowName, opts); err != nil {
+		return err
+	}
+	data, err := json.Marshal(opts)
+	if err != nil {
+		return fmt.Errorf("failed to marshal options of flow %s, error %v", flowName, err)
+	}
+	pipe := fRuntime.redisClient().TxPipeline()
+	pipe.Set(ctx, configUpdateKey(flowName), data, 0)
+	pipe.Publish(ctx, configUpdatesChannel(flowName), data)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to push options of flow %s, error %v", flowName, err)
+	}
+	return nil
+}
+
+// watchConfigUpdates applies the options pushed with PushConfigUpdate to the flows registered on the runtime, the
+// ones pushed before the runtime started first, until ctx is done
+func (fRuntime *FlowRuntime) watchConfigUpdates(ctx context.Context) error {
+	pubsub := fRuntime.redisClient().PSubscribe(ctx, configUpdatesChannel("*"))
+	if _, err := pubsub.Receive(ctx); err != nil {
+		pubsub.Close()
+		return fmt.Errorf("failed to subscribe to config updates, error %v", err)
+	}
+	// loaded once subscribed, an update pushed in between is applied again by the subscription
+	if err := fRuntime.loadConfigUpdates(ctx); err != nil {
+		pubsub.Close()
+		return err
+	}
+
+	go func() {
+		defer pubsub.Close()
+		messages := pubsub.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case message, ok := <-messages:
+				if !ok {
+					return
+				}
+				flowName := strings.TrimPrefix(message.Channel, ConfigUpdatesChannelInitial+":")
+				fRuntime.applyConfigUpdate(flowName, []byte(message.Payload))
+			}
+		}
+	}()
+	return nil
+}
+
+// applyConfigUpdate updates the options of a flow with the ones pushed, along with the functions of its current
+// options. The flows not registered on the runtime are skipped
+func (fRuntime *FlowRuntime) applyConfigUpdate(flowName string, data []byte) {
+	if _, ok := fRuntime.Flows.Get(flowName); !ok {
+		return
+	}
+	options := FlowOptions{}
+	if err := json.Unmarshal(data, &options); err != nil {
+		fRuntime.Logger.Log(fmt.Sprintf("[goflow] invalid config update of flow %s, error %v", flowName, err))
+		return
+	}
+	current, _ := fRuntime.getFlowOptions(flowName)
+	options.StickyRoutingKey = current.StickyRoutingKey
+	options.Validate = current.Validate
+	options.Migrate = current.Migrate
+	options.ShouldRetry = current.ShouldRetry
+	if err := fRuntime.UpdateFlowOptions(flowName, options); err != nil {
+		fRuntime.Logger.Log(fmt.Sprintf("[goflow] failed to apply config update of flow %s, error %v", flowName, err))
+	}
+}
+
+// loadConfigUpdates applies the last options pushed with PushConfigUpdate to the flows registered on the runtime
+func (fRuntime *FlowRuntime) loadConfigUpdates(ctx context.Context) error {
+	flowNames := fRuntime.ListFlows()
+	if len(flowNames) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(flowNames))
+	for _, flowName := range flowNames {
+		keys = append(keys, configUpdateKey(flowName))
+	}
+	values, err := fRuntime.redisClient().MGet(ctx, keys...).Result()
+	if err != nil {
+		return fmt.Errorf("failed to get config updates, error %v", err)
+	}
+	for i, value := range values {
+		if data, ok := value.(string); ok {
+			fRuntime.applyConfigUpdate(flowNames[i], []byte(data))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+package runtime
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronSearchLimit bounds the search of the next time matching a cron expression, e.g. for `0 0 30 2 *`
+const cronSearchLimit = 5 * 366 * 24 * time.Hour
+
+// cronSchedule is a standard 5 fields cron expression: minute, hour, day of month, month and day of week
+type cronSchedule struct {
+	minutes, hours, days, months, weekdays uint64 // bit sets of the allowed values
+	anyDay, anyWeekday                     bool
+}
+
+var cronFieldBounds = [5][2]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 6}}
+
+// parseCron parses a cron expression, the fields support `*`, values, ranges, lists and steps such as `*/15`
+// or `1-5`. The day of week 7 is sunday as 0
+func parseCron(expression string) (*cronSchedule, error) {
+	fields := strings.Fields(expression)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid cron expression %q, 5 fields are expected", expression)
+	}
+	var sets [5]uint64
+	for idx, field := range fields {
+		bounds := cronFieldBounds[idx]
+		max := bounds[1]
+		if idx == 4 {
+			max = 7
+		}
+		set, err := parseCronField(field, bounds[0], max)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cron expression %q, %v", expression, err)
+		}
+		sets[idx] = set
+	}
+	if sets[4]&(1<<7) != 0 {
+		sets[4] |= 1
+	}
+	return &cronSchedule{
+		minutes:    sets[0],
+		hours:      sets[1],
+		days:       sets[2],
+		months:     sets[3],
+		weekdays:   sets[4],
+		anyDay:     fields[2] == "*",
+		anyWeekday: fields[4] == "*",
+	}, nil
+}
+
+func parseCronField(field string, min int, max int) (uint64, error) {
+	var set uint64
+	for _, part := range strings.Split(field, ",") {
+		rangePart, step := part, 1
+		if idx := strings.Index(part, "/"); idx >= 0 {
+			var err error
+			rangePart = part[:idx]
+			step, err = strconv.Atoi(part[idx+1:])
+			if err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step in %q", part)
+			}
+		}
+
+		start, end := min, max
+		if rangePart != "*" {
+			bounds := strings.SplitN(rangePart, "-", 2)
+			var err error
+			if start, err = strconv.Atoi(bounds[0]); err != nil {
+				return 0, fmt.Errorf("invalid value in %q", part)
+			}
+			end = start
+			if len(bounds) == 2 {
+				if end, err = strconv.Atoi(bounds[1]); err != nil {
+					return 0, fmt.Errorf("invalid value in %q", part)
+				}
+			} else if step > 1 {
+				end = max
+			}
+		}
+		if start < min || end > max || start > end {
+			return 0, fmt.Errorf("%q is out of the range %d-%d", part, min, max)
+		}
+		for value := start; value <= end; value += step {
+			set |= 1 << uint(value)
+		}
+	}
+	return set, nil
+}
+
+func (schedule *cronSchedule) matchesDay(t time.Time) bool {
+	dayMatch := schedule.days&(1<<uint(t.Day())) != 0
+	weekdayMatch := schedule.weekdays&(1<<uint(t.Weekday())) != 0
+	// as in cron, a day matches either field when both are restricted
+	switch {
+	case schedule.anyDay && schedule.anyWeekday:
+		return true
+	case schedule.anyDay:
+		return weekdayMatch
+	case schedule.anyWeekday:
+		return dayMatch
+	}
+	return dayMatch || weekdayMatch
+}
+
+// next returns the first time matching the schedule at or after t, truncated to the minute. The candidate times
+// are built in the location of t, so that the zones which offset isn't a whole number of hours match their local
+// hours and minutes
+func (schedule *cronSchedule) next(t time.Time) (time.Time, error) {
+	if start := time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), 0, 0, t.Location()); start.Before(t) {
+		t = start.Add(time.Minute)
+	}
+	limit := t.Add(cronSearchLimit)
+	for t.Before(limit) {
+		switch {
+		case schedule.months&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !schedule.matchesDay(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case schedule.hours&(1<<uint(t.Hour())) == 0:
+			next := time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			if !next.After(t) {
+				// the next hour is skipped by a daylight saving transition
+				next = t.Add(time.Hour)
+			}
+			t = next
+		case schedule.minutes&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("no time matches the cron expression")
+}
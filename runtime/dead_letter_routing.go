@@ -0,0 +1,129 @@
+package runtime
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+
+	"github.com/adjust/rmq/v5"
+	"github.com/yuyang0/goflow/metrics"
+)
+
+const (
+	ErrorCategoryValidation = "validation"
+	ErrorCategoryTimeout    = "timeout"
+	// ErrorCategoryOther is the category of the errors that don't match any other category
+	ErrorCategoryOther = "other"
+)
+
+var deadLetteredFailuresCounter = metrics.NewCounterVec("goflow_dead_lettered_failures_total",
+	"Failed tasks dead lettered after their last retry by error category", "flow", "category", "queue")
+
+// CategorizedError sets the category of an error, the failed requests are routed to the dead letter queue
+// of their category by the DeadLetterRouting of the runtime
+type CategorizedError struct {
+	Category string
+	Err      error
+}
+
+func (err *CategorizedError) Error() string {
+	return err.Err.Error()
+}
+
+func (err *CategorizedError) Unwrap() error {
+	return err.Err
+}
+
+// DeadLetterRouting dead letters the tasks failing their last retry to a queue picked by the category of the error,
+// instead of leaving them in the rejected deliveries of the queue
+type DeadLetterRouting struct {
+	Routes  map[string]string // dead letter queue by error category, e.g. `validation` to `validation-dlq`
+	Default string            // queue of the categories without route, the dead letter list if not set
+}
+
+func validateDeadLetterRouting(routing *DeadLetterRouting) error {
+	for category, queue := range routing.Routes {
+		if !flowNamePattern.MatchString(queue) {
+			return fmt.Errorf("invalid dead letter queue %q of category %s, it must only contain [a-zA-Z0-9_-]",
+				queue, category)
+		}
+	}
+	if routing.Default != "" && !flowNamePattern.MatchString(routing.Default) {
+		return fmt.Errorf("invalid default dead letter queue %q, it must only contain [a-zA-Z0-9_-]", routing.Default)
+	}
+	return nil
+}
+
+// queue returns the dead letter queue of an error category, the dead letter list without routing
+func (routing *DeadLetterRouting) queue(category string) string {
+	if routing == nil {
+		return ""
+	}
+	if queue, ok := routing.Routes[category]; ok {
+		return queue
+	}
+	return routing.Default
+}
+
+// deadLetterQueueKey returns the list of a dead letter queue, the default dead letter list for an empty name
+func deadLetterQueueKey(queue string) string {
+	if queue == "" {
+		return DeadLetterKey
+	}
+	return fmt.Sprintf("%s:%s", DeadLetterKey, queue)
+}
+
+// ErrorCategory returns the category of the error of a request, the one of a CategorizedError in its chain or
+// the category of the known validation and timeout errors
+func ErrorCategory(err error) string {
+	var categorized *CategorizedError
+	if errors.As(err, &categorized) {
+		return categorized.Category
+	}
+
+	var flowNameErr *InvalidFlowNameError
+	if errors.Is(err, ErrInvalidJSON) || errors.Is(err, ErrBodyDecode) || errors.As(err, &flowNameErr) {
+		return ErrorCategoryValidation
+	}
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, os.ErrDeadlineExceeded) ||
+		(errors.As(err, &netErr) && netErr.Timeout()) {
+		return ErrorCategoryTimeout
+	}
+	return ErrorCategoryOther
+}
+
+// deadLetterFailure routes a task that failed for good, e.g. on its last retry, to the dead letter queue of the
+// category of its error
+func (fRuntime *FlowRuntime) deadLetterFailure(message rmq.Delivery, task *Task, cause error, failure string) {
+	category := ErrorCategory(cause)
+	queue := fRuntime.DeadLetterRouting.queue(category)
+	reason := fmt.Sprintf("%s (category %s), %v", failure, category, cause)
+	if err := fRuntime.deadLetterTo(queue, &DeadLetter{Task: task, Reason: reason, Category: category}); err != nil {
+		fRuntime.Logger.Log(fmt.Sprintf("[request `%s`] failed to dead letter task, error %v", task.RequestID, err))
+		if err := message.Reject(); err != nil {
+			fRuntime.handleQueueError(QueueOperationAck, task, err)
+		}
+		return
+	}
+	deadLetteredFailuresCounter.Inc(task.FlowName, category, queue)
+	fRuntime.Logger.Log(fmt.Sprintf("[request `%s`] dead lettered to %s, %s", task.RequestID, deadLetterQueueKey(queue), reason))
+	if err := message.Ack(); err != nil {
+		fRuntime.handleQueueError(QueueOperationAck, task, err)
+	}
+}
+
+// retryConsumer returns the consumer of a queue whose failed tasks are pushed to the pushIdx-th push queue of the
+// retries, they are rejected past the last one. The last queue of the retries dead letters the failed tasks when
+// the DeadLetterRouting is set
+func (fRuntime *FlowRuntime) retryConsumer(queue string, pushIdx int, last bool) rmq.Consumer {
+	return &queueConsumer{
+		runtime:   fRuntime,
+		queue:     queue,
+		pushQueue: fRuntime.retryQueueLocation(pushIdx),
+		pushIdx:   pushIdx,
+		lastRetry: last && fRuntime.DeadLetterRouting != nil,
+	}
+}
@@ -0,0 +1,116 @@
+package runtime
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/adjust/rmq/v5"
+	"github.com/yuyang0/goflow/metrics"
+)
+
+const (
+	FlowStatusOK                 = "ok"
+	FlowStatusDefinitionConflict = "definition-conflict"
+
+	// ConflictRequeueDelay is the wait before a task of a flow with a definition conflict is queued again
+	ConflictRequeueDelay = time.Second
+)
+
+var (
+	definitionConflictGauge = metrics.NewGaugeVec("goflow_flow_definition_conflict",
+		"Set to 1 while live workers register the flow with different definitions", "flow")
+	conflictingTasksCounter = metrics.NewCounterVec("goflow_conflicting_flow_tasks_total",
+		"Tasks requeued as their flow has conflicting definitions across workers", "flow")
+)
+
+// FlowStatus is a registered flow along with its status
+type FlowStatus struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	// ConflictingWorkers are the live workers registering the flow with a different definition
+	ConflictingWorkers []string `json:"conflicting_workers,omitempty"`
+}
+
+// definitionHash returns the hash of a flow definition that workers publish to detect mismatches
+func definitionHash(definition string) string {
+	sum := sha256.Sum256([]byte(definition))
+	return hex.EncodeToString(sum[:])
+}
+
+// detectDefinitionConflicts compares the definition hashes of the flows of this worker against the
+// ones published by the other live workers, and records the flows whose definitions differ
+func (fRuntime *FlowRuntime) detectDefinitionConflicts(ctx context.Context, hashes map[string]string) error {
+	workers, err := fRuntime.ListWorkers(ctx)
+	if err != nil {
+		return err
+	}
+
+	conflicts := make(map[string][]string)
+	for _, worker := range workers {
+		if worker.ID == fRuntime.WorkerID() {
+			continue
+		}
+		for flowName, hash := range worker.FlowHashes {
+			if localHash, ok := hashes[flowName]; ok && localHash != hash {
+				conflicts[flowName] = append(conflicts[flowName], worker.ID)
+			}
+		}
+	}
+
+	fRuntime.conflictMu.Lock()
+	defer fRuntime.conflictMu.Unlock()
+	for flowName, workerIDs := range conflicts {
+		sort.Strings(workerIDs)
+		if _, ok := fRuntime.conflicts[flowName]; !ok {
+			fRuntime.Logger.Log(fmt.Sprintf("[goflow] ERROR flow %s is registered with a different definition on workers %s,"+
+				" the workers must be deployed with the same definition", flowName, strings.Join(workerIDs, ", ")))
+		}
+		definitionConflictGauge.Set(1, flowName)
+	}
+	for flowName := range fRuntime.conflicts {
+		if _, ok := conflicts[flowName]; !ok {
+			fRuntime.Logger.Log(fmt.Sprintf("[goflow] definition conflict of flow %s resolved", flowName))
+			definitionConflictGauge.Set(0, flowName)
+		}
+	}
+	fRuntime.conflicts = conflicts
+	return nil
+}
+
+// conflictingWorkers returns the live workers registering the flow with a different definition
+func (fRuntime *FlowRuntime) conflictingWorkers(flowName string) []string {
+	fRuntime.conflictMu.Lock()
+	defer fRuntime.conflictMu.Unlock()
+	return fRuntime.conflicts[flowName]
+}
+
+// ListFlowStatuses returns the registered flows along with their status, a flow whose definition differs
+// from the one registered by another live worker has the status FlowStatusDefinitionConflict
+func (fRuntime *FlowRuntime) ListFlowStatuses() []FlowStatus {
+	statuses := []FlowStatus{}
+	for _, flowName := range fRuntime.ListFlows() {
+		status := FlowStatus{Name: flowName, Status: FlowStatusOK}
+		if workerIDs := fRuntime.conflictingWorkers(flowName); len(workerIDs) > 0 {
+			status.Status = FlowStatusDefinitionConflict
+			status.ConflictingWorkers = workerIDs
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+// requeueConflictingTask queues a task of a flow with a definition conflict again after a short delay,
+// so that it is not executed against a definition other workers don't agree on
+func (fRuntime *FlowRuntime) requeueConflictingTask(message rmq.Delivery, task *Task) {
+	if err := fRuntime.requeueDelivery(message, task, ConflictRequeueDelay); err != nil {
+		fRuntime.Logger.Log(fmt.Sprintf("[request `%s`] failed to requeue task of conflicting flow %s, error %v",
+			task.RequestID, task.FlowName, err))
+		return
+	}
+	conflictingTasksCounter.Inc(task.FlowName)
+}
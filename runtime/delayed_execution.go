@@ -0,0 +1,246 @@
+package runtime
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/adjust/rmq/v5"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"github.com/yuyang0/goflow/core/runtime"
+	runtimeCommon "github.com/yuyang0/goflow/runtime/common"
+)
+
+const (
+	ScheduledTasksKey    = "goflow-scheduled"      // sorted set of the scheduled request ids by due time
+	ScheduledTaskDataKey = "goflow-scheduled-task" // hash of the scheduled tasks by request id
+
+	LeaderRoleScheduler = "scheduler"
+
+	// SchedulerPollInterval is the interval at which due scheduled tasks are submitted
+	SchedulerPollInterval = time.Second
+	// SchedulerRetryDelay is the delay before retrying to submit a scheduled task that failed to be submitted
+	SchedulerRetryDelay = time.Minute
+
+	schedulerBatchSize = 100
+)
+
+// popScheduledTaskScript removes a scheduled task and returns it, nil if it was already taken or cancelled
+var popScheduledTaskScript = redis.NewScript(`
+if redis.call("ZREM", KEYS[1], ARGV[1]) == 0 then
+	return false
+end
+local data = redis.call("HGET", KEYS[2], ARGV[1])
+redis.call("HDEL", KEYS[2], ARGV[1])
+return data
+`)
+
+// ExecuteAt schedules a request of a flow to be submitted at the given time, it can be
+// cancelled with CancelScheduled until then. A request id is generated if not provided
+func (fRuntime *FlowRuntime) ExecuteAt(flowName string, request *runtime.Request, at time.Time) error {
+	flowName, err := fRuntime.resolveFlowName(flowName)
+	if err != nil {
+		return err
+	}
+	if err := fRuntime.decodeRequestBody(request); err != nil {
+		return err
+	}
+	if err := fRuntime.validateRequestBody(flowName, request.Body); err != nil {
+		return err
+	}
+	if request.RequestID == "" {
+		request.RequestID = getNewId()
+	}
+
+	task := &Task{
+		FlowName:     flowName,
+		RequestID:    request.RequestID,
+		Body:         request.Body,
+		Header:       request.Header,
+		RawQuery:     request.RawQuery,
+		Query:        request.Query,
+		RequestType:  NewRequest,
+		PartitionKey: request.PartitionKey,
+	}
+	if !request.Deadline.IsZero() {
+		task.Deadline = request.Deadline.UnixNano()
+	}
+	return fRuntime.scheduleTask(context.TODO(), task, at)
+}
+
+func (fRuntime *FlowRuntime) scheduleTask(ctx context.Context, task *Task, at time.Time) error {
+	data, err := marshalTask(task)
+	if err != nil {
+		return err
+	}
+	pipe := fRuntime.redisClient().TxPipeline()
+	pipe.HSet(ctx, ScheduledTaskDataKey, task.RequestID, data)
+	pipe.ZAdd(ctx, ScheduledTasksKey, redis.Z{Score: float64(at.UnixMilli()), Member: task.RequestID})
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to schedule request %s, error %v", task.RequestID, err)
+	}
+	return nil
+}
+
+// CancelScheduled cancels a request scheduled with ExecuteAt that has not been submitted yet
+func (fRuntime *FlowRuntime) CancelScheduled(requestID string) error {
+	data, err := popScheduledTaskScript.Run(context.TODO(), fRuntime.redisClient(),
+		[]string{ScheduledTasksKey, ScheduledTaskDataKey}, requestID).Result()
+	if err == redis.Nil || (err == nil && data == nil) {
+		return fmt.Errorf("request %s is not scheduled", requestID)
+	} else if err != nil {
+		return fmt.Errorf("failed to cancel request %s, error %v", requestID, err)
+	}
+	return nil
+}
+
+// scheduledTaskFlow returns the flow of a request scheduled with ExecuteAt, empty if the request is not scheduled
+func (fRuntime *FlowRuntime) scheduledTaskFlow(ctx context.Context, requestID string) (string, error) {
+	data, err := fRuntime.redisClient().HGet(ctx, ScheduledTaskDataKey, requestID).Result()
+	if err == redis.Nil {
+		return "", nil
+	} else if err != nil {
+		return "", fmt.Errorf("failed to get scheduled request %s, error %v", requestID, err)
+	}
+	task := &Task{}
+	if err := json.Unmarshal([]byte(data), task); err != nil {
+		return "", fmt.Errorf("failed to parse scheduled request %s, error %v", requestID, err)
+	}
+	return task.FlowName, nil
+}
+
+// runScheduler submits the due scheduled tasks every SchedulerPollInterval until ctx is done, the polls follow the
+// clock of the runtime so that a fake clock moves the scheduler along
+func (fRuntime *FlowRuntime) runScheduler(ctx context.Context) {
+	ticker := fRuntime.clock().NewTicker(SchedulerPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+		}
+		fRuntime.submitScheduledTasks()
+	}
+}
+
+// submitScheduledTasks submits the scheduled tasks that are due, only the leader of the scheduler role polls
+func (fRuntime *FlowRuntime) submitScheduledTasks() {
+	ctx := context.TODO()
+	leader, err := fRuntime.acquireLeadership(ctx, LeaderRoleScheduler, 10*SchedulerPollInterval)
+	if err != nil {
+		fRuntime.Logger.Log(fmt.Sprintf("[goflow] failed to run scheduler, error %v", err))
+		return
+	}
+	if !leader {
+		return
+	}
+
+	rdb := fRuntime.redisClient()
+	now := strconv.FormatInt(fRuntime.clock().Now().UnixMilli(), 10)
+	for {
+		requestIDs, err := rdb.ZRangeByScore(ctx, ScheduledTasksKey, &redis.ZRangeBy{
+			Min: "-inf", Max: now, Count: schedulerBatchSize,
+		}).Result()
+		if err != nil {
+			fRuntime.Logger.Log(fmt.Sprintf("[goflow] failed to get due scheduled tasks, error %v", err))
+			return
+		}
+		for _, requestID := range requestIDs {
+			fRuntime.submitScheduledTask(ctx, requestID)
+		}
+		if len(requestIDs) < schedulerBatchSize {
+			return
+		}
+	}
+}
+
+func (fRuntime *FlowRuntime) submitScheduledTask(ctx context.Context, requestID string) {
+	data, err := popScheduledTaskScript.Run(ctx, fRuntime.redisClient(),
+		[]string{ScheduledTasksKey, ScheduledTaskDataKey}, requestID).Result()
+	if err == redis.Nil || (err == nil && data == nil) {
+		// cancelled in between
+		return
+	} else if err != nil {
+		fRuntime.Logger.Log(fmt.Sprintf("[request `%s`] failed to take scheduled task, error %v", requestID, err))
+		return
+	}
+	value, _ := data.(string)
+	task := Task{}
+	if err := json.Unmarshal([]byte(value), &task); err != nil {
+		fRuntime.Logger.Log(fmt.Sprintf("[request `%s`] dropping scheduled task, failed to parse it, error %v", requestID, err))
+		return
+	}
+
+	submit := func() error { return fRuntime.Execute(task.FlowName, makeRequestFromTask(task)) }
+	if task.Requeued {
+		submit = func() error { return fRuntime.requeueTask(&task) }
+	}
+	if err := submit(); err != nil {
+		fRuntime.Logger.Log(fmt.Sprintf("[request `%s`] failed to submit scheduled task, retrying in %s, error %v",
+			requestID, SchedulerRetryDelay, err))
+		if err := fRuntime.scheduleTask(ctx, &task, fRuntime.clock().Now().Add(SchedulerRetryDelay)); err != nil {
+			fRuntime.Logger.Log(fmt.Sprintf("[request `%s`] scheduled task lost, error %v", requestID, err))
+		}
+	}
+}
+
+// requeueTask queues again a task that was already submitted, as it was
+func (fRuntime *FlowRuntime) requeueTask(task *Task) error {
+	requeued := *task
+	requeued.Requeued = false
+	queue, err := fRuntime.rmqConnection.OpenQueue(fRuntime.publishQueueId(task.FlowName, task.PartitionKey))
+	if err != nil {
+		return err
+	}
+	data, err := marshalTask(&requeued)
+	if err != nil {
+		return err
+	}
+	return fRuntime.wrapQueue(queue).PublishBytes(data)
+}
+
+// requeueDelivery queues the task of a delivery again after a delay, for another worker or a later attempt to handle
+// it. The task is published again rather than the delivery rejected, as the rejected deliveries are only returned
+// manually. The delivery is acknowledged once the task is published, and rejected if it can't be
+func (fRuntime *FlowRuntime) requeueDelivery(message rmq.Delivery, task *Task, delay time.Duration) error {
+	fRuntime.clock().Sleep(delay)
+	if err := fRuntime.requeueTask(task); err != nil {
+		if err := message.Reject(); err != nil {
+			fRuntime.handleQueueError(QueueOperationAck, task, err)
+		}
+		return err
+	}
+	if err := message.Ack(); err != nil {
+		fRuntime.handleQueueError(QueueOperationAck, task, err)
+	}
+	return nil
+}
+
+func cancelScheduledHandler(runtime *FlowRuntime) func(*gin.Context) {
+	fn := func(c *gin.Context) {
+		requestId := c.Param(RequestIdParamName)
+		flowName, err := runtime.scheduledTaskFlow(c.Request.Context(), requestId)
+		if err != nil {
+			runtimeCommon.HandleError(c.Writer, fmt.Sprintf("Failed to cancel scheduled request, %v", err))
+			return
+		}
+		if flowName == "" {
+			c.String(http.StatusNotFound, "request %s is not scheduled", requestId)
+			return
+		}
+		if !runtime.authorizeRequest(c, nil, ActionCancel, flowName, requestId) {
+			return
+		}
+		if err := runtime.CancelScheduled(requestId); err != nil {
+			runtimeCommon.HandleError(c.Writer, fmt.Sprintf("Failed to cancel scheduled request, %v", err))
+			return
+		}
+		c.String(http.StatusOK, "Scheduled request cancelled")
+	}
+	return fn
+}
@@ -0,0 +1,412 @@
+package runtime
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"github.com/yuyang0/goflow/metrics"
+	runtimeCommon "github.com/yuyang0/goflow/runtime/common"
+)
+
+const (
+	OutcomeKeyInitial          = "goflow-outcomes"
+	ErrorBudgetRulesKeyInitial = "goflow-error-budget-rules"
+	ErrorBudgetStateKeyInitial = "goflow-error-budget-state"
+
+	// MaxErrorBudgetWindow is the longest window of an error budget rule, the outcome counters are kept as long
+	MaxErrorBudgetWindow = 24 * time.Hour
+	// DefaultErrorBudgetInterval is the default interval at which the error budget rules are evaluated
+	DefaultErrorBudgetInterval = 30 * time.Second
+	// AlertWebhookTimeout bounds the call to the AlertWebhookURL
+	AlertWebhookTimeout = 10 * time.Second
+
+	LeaderRoleErrorBudget = "error-budget"
+
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure"
+
+	ErrorBudgetStatusTripped   = "tripped"
+	ErrorBudgetStatusRecovered = "recovered"
+)
+
+var errorBudgetAlertsCounter = metrics.NewCounterVec("goflow_error_budget_alerts_total",
+	"Error budget rules that tripped or recovered", "flow", "rule", "status")
+
+// ErrorBudgetRule alerts when the failure rate of a flow over a sliding window exceeds MaxFailureRate
+type ErrorBudgetRule struct {
+	Name           string        `json:"name"`
+	Window         time.Duration `json:"window"`           // sliding window, rounded up to the minute
+	MaxFailureRate float64       `json:"max_failure_rate"` // ratio of failed requests, e.g. 0.05 for 5%
+	MinRequests    int64         `json:"min_requests"`     // requests needed within the window for the rule to trip
+}
+
+// ErrorBudgetStatus is the current state of an error budget rule of a flow
+type ErrorBudgetStatus struct {
+	Rule           string  `json:"rule"`
+	WindowSeconds  float64 `json:"window_seconds"`
+	MaxFailureRate float64 `json:"max_failure_rate"`
+	Requests       int64   `json:"requests"`
+	Failures       int64   `json:"failures"`
+	FailureRate    float64 `json:"failure_rate"`
+	Tripped        bool    `json:"tripped"`
+}
+
+// ErrorBudgetAlert is sent to the AlertWebhookURL and OnErrorBudgetAlert when a rule trips or recovers
+type ErrorBudgetAlert struct {
+	Flow   string `json:"flow"`
+	Status string `json:"status"` // tripped or recovered
+	ErrorBudgetStatus
+	Time time.Time `json:"time"`
+}
+
+// FlowStats holds the statistics of a flow
+type FlowStats struct {
+	Name        string               `json:"name"`
+	ErrorBudget []ErrorBudgetStatus  `json:"error_budget"`
+	Nodes       map[string]NodeStats `json:"nodes"`
+	InFlight    int64                `json:"in_flight"` // new requests being processed, see GetInFlightCount
+}
+
+func validateErrorBudgetRules(rules []ErrorBudgetRule) error {
+	names := make(map[string]bool, len(rules))
+	for _, rule := range rules {
+		if rule.Name == "" {
+			return fmt.Errorf("error budget rule name must be provided")
+		}
+		if names[rule.Name] {
+			return fmt.Errorf("duplicate error budget rule %s", rule.Name)
+		}
+		names[rule.Name] = true
+		if rule.Window < time.Minute || rule.Window > MaxErrorBudgetWindow {
+			return fmt.Errorf("window of error budget rule %s must be between %v and %v", rule.Name, time.Minute, MaxErrorBudgetWindow)
+		}
+		if rule.MaxFailureRate <= 0 || rule.MaxFailureRate > 1 {
+			return fmt.Errorf("max failure rate of error budget rule %s must be within (0, 1]", rule.Name)
+		}
+	}
+	return nil
+}
+
+func (fRuntime *FlowRuntime) errorBudgetInterval() time.Duration {
+	if fRuntime.ErrorBudgetInterval < time.Second {
+		return DefaultErrorBudgetInterval
+	}
+	return fRuntime.ErrorBudgetInterval
+}
+
+func outcomeKey(flowName string, at time.Time) string {
+	return fmt.Sprintf("%s:%s:%d", OutcomeKeyInitial, flowName, at.Unix()/60)
+}
+
+func errorBudgetRulesKey(flowName string) string {
+	return fmt.Sprintf("%s:%s", ErrorBudgetRulesKeyInitial, flowName)
+}
+
+func errorBudgetStateKey(flowName string) string {
+	return fmt.Sprintf("%s:%s", ErrorBudgetStateKeyInitial, flowName)
+}
+
+// ReportRequestOutcome counts the completed and failed requests of the flow for the error budget,
+// records them in the execution history and their location, finishes the claim of their idempotency key and ends
+// their stream. The warmup requests are left out, see IsWarmupRequest
+func (fe *FlowExecutor) ReportRequestOutcome(requestId string, err error) {
+	if IsWarmupRequest(requestId) {
+		return
+	}
+	status, location := HistoryStatusCompleted, LocationStatusCompleted
+	if err != nil {
+		status, location = HistoryStatusFailed, LocationStatusFailed
+	}
+	fe.Runtime.recordOutcome(fe.flowName, err == nil)
+	fe.Runtime.recordHistory(fe.flowName, requestId, status, err)
+	// the claim of the request, held in the rare case it has an idempotency key, is fetched along with the location
+	ctx := context.TODO()
+	writes := fe.Runtime.redisClient().Pipeline()
+	fe.Runtime.addLocation(ctx, writes, fe.flowName, requestId, "", location, "")
+	claimKey := writes.Get(ctx, claimRequestKey(requestId))
+	fe.Runtime.flushWrites(writes)
+	fe.Runtime.finishFetchedClaim(requestId, claimKey, err == nil)
+	fe.Runtime.publishStreamEnd(fe.flowName, requestId, err)
+}
+
+// recordOutcome counts a completed or failed request of a flow in the current minute bucket, only the outcomes of
+// the flows with error budget rules are counted
+func (fRuntime *FlowRuntime) recordOutcome(flowName string, success bool) {
+	if options, ok := fRuntime.getFlowOptions(flowName); !ok || len(options.ErrorBudgetRules) == 0 {
+		return
+	}
+	outcome := OutcomeSuccess
+	if !success {
+		outcome = OutcomeFailure
+	}
+	ctx := context.TODO()
+	key := outcomeKey(flowName, fRuntime.clock().Now())
+	pipe := fRuntime.redisClient().TxPipeline()
+	pipe.HIncrBy(ctx, key, outcome, 1)
+	pipe.Expire(ctx, key, MaxErrorBudgetWindow+time.Minute)
+	if _, err := pipe.Exec(ctx); err != nil {
+		fRuntime.Logger.Log(fmt.Sprintf("[goflow] failed to record outcome of flow %s, error %v", flowName, err))
+	}
+}
+
+// getOutcomes returns the completed and failed requests of a flow within the window, the current
+// minute bucket is included so that the window slides by the minute
+func (fRuntime *FlowRuntime) getOutcomes(ctx context.Context, flowName string, window time.Duration) (int64, int64, error) {
+	now := fRuntime.clock().Now()
+	buckets := int((window + time.Minute - 1) / time.Minute)
+	pipe := fRuntime.redisClient().Pipeline()
+	cmds := make([]*redis.SliceCmd, 0, buckets)
+	for idx := 0; idx < buckets; idx++ {
+		cmds = append(cmds, pipe.HMGet(ctx, outcomeKey(flowName, now.Add(-time.Duration(idx)*time.Minute)), OutcomeSuccess, OutcomeFailure))
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return 0, 0, fmt.Errorf("failed to get outcomes of flow %s, error %v", flowName, err)
+	}
+
+	var successes, failures int64
+	for _, cmd := range cmds {
+		values := cmd.Val()
+		if len(values) != 2 {
+			continue
+		}
+		successes += parseCount(values[0])
+		failures += parseCount(values[1])
+	}
+	return successes, failures, nil
+}
+
+func parseCount(value interface{}) int64 {
+	str, ok := value.(string)
+	if !ok {
+		return 0
+	}
+	count, _ := strconv.ParseInt(str, 10, 64)
+	return count
+}
+
+// saveErrorBudgetRules stores the error budget rules of the local flows, so that the leader evaluates
+// the rules of the flows registered on any worker
+func (fRuntime *FlowRuntime) saveErrorBudgetRules(flowNames []string) error {
+	ctx := context.TODO()
+	pipe := fRuntime.redisClient().Pipeline()
+	for _, flowName := range flowNames {
+		options, _ := fRuntime.getFlowOptions(flowName)
+		if len(options.ErrorBudgetRules) == 0 {
+			continue
+		}
+		data, err := json.Marshal(options.ErrorBudgetRules)
+		if err != nil {
+			return fmt.Errorf("failed to marshal error budget rules of flow %s, error %v", flowName, err)
+		}
+		pipe.Set(ctx, errorBudgetRulesKey(flowName), data, time.Second*RDBKeyTimeOut)
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// getErrorBudgetRules returns the error budget rules of a flow, the flow may be registered on any worker
+func (fRuntime *FlowRuntime) getErrorBudgetRules(ctx context.Context, flowName string) ([]ErrorBudgetRule, error) {
+	if _, ok := fRuntime.Flows.Get(flowName); ok {
+		options, _ := fRuntime.getFlowOptions(flowName)
+		return options.ErrorBudgetRules, nil
+	}
+
+	data, err := fRuntime.redisClient().Get(ctx, errorBudgetRulesKey(flowName)).Result()
+	if err == redis.Nil {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to get error budget rules of flow %s, error %v", flowName, err)
+	}
+	rules := []ErrorBudgetRule{}
+	if err := json.Unmarshal([]byte(data), &rules); err != nil {
+		return nil, fmt.Errorf("failed to parse error budget rules of flow %s, error %v", flowName, err)
+	}
+	return rules, nil
+}
+
+// GetErrorBudget returns the current status of the error budget rules of a flow
+func (fRuntime *FlowRuntime) GetErrorBudget(ctx context.Context, flowName string) ([]ErrorBudgetStatus, error) {
+	rules, err := fRuntime.getErrorBudgetRules(ctx, flowName)
+	if err != nil {
+		return nil, err
+	}
+	return fRuntime.errorBudgetStatuses(ctx, flowName, rules)
+}
+
+func (fRuntime *FlowRuntime) errorBudgetStatuses(ctx context.Context, flowName string, rules []ErrorBudgetRule) ([]ErrorBudgetStatus, error) {
+	tripped, err := fRuntime.redisClient().HGetAll(ctx, errorBudgetStateKey(flowName)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get error budget state of flow %s, error %v", flowName, err)
+	}
+
+	statuses := make([]ErrorBudgetStatus, 0, len(rules))
+	for _, rule := range rules {
+		successes, failures, err := fRuntime.getOutcomes(ctx, flowName, rule.Window)
+		if err != nil {
+			return nil, err
+		}
+		status := ErrorBudgetStatus{
+			Rule:           rule.Name,
+			WindowSeconds:  rule.Window.Seconds(),
+			MaxFailureRate: rule.MaxFailureRate,
+			Requests:       successes + failures,
+			Failures:       failures,
+		}
+		if status.Requests > 0 {
+			status.FailureRate = float64(failures) / float64(status.Requests)
+		}
+		_, status.Tripped = tripped[rule.Name]
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// GetFlowStats returns the statistics of a flow
+func (fRuntime *FlowRuntime) GetFlowStats(ctx context.Context, flowName string) (*FlowStats, error) {
+	flowName, err := fRuntime.resolveFlowName(flowName)
+	if err != nil {
+		return nil, err
+	}
+	budget, err := fRuntime.GetErrorBudget(ctx, flowName)
+	if err != nil {
+		return nil, err
+	}
+	nodes, err := fRuntime.GetNodeExecutionCounts(ctx, flowName)
+	if err != nil {
+		return nil, err
+	}
+	inFlight, err := fRuntime.GetInFlightCount(flowName)
+	if err != nil {
+		return nil, err
+	}
+	return &FlowStats{Name: flowName, ErrorBudget: budget, Nodes: nodes, InFlight: inFlight}, nil
+}
+
+// evaluateErrorBudgets evaluates the error budget rules of all the flows and alerts on the rules that
+// tripped or recovered since the last evaluation, only the leader of the error budget role evaluates
+func (fRuntime *FlowRuntime) evaluateErrorBudgets() {
+	ctx := context.TODO()
+	leader, err := fRuntime.acquireLeadership(ctx, LeaderRoleErrorBudget, 3*fRuntime.errorBudgetInterval())
+	if err != nil {
+		fRuntime.Logger.Log(fmt.Sprintf("[goflow] failed to evaluate error budgets, error %v", err))
+		return
+	}
+	if !leader {
+		return
+	}
+
+	rdb := fRuntime.redisClient()
+	iter := rdb.Scan(ctx, 0, ErrorBudgetRulesKeyInitial+":*", 0).Iterator()
+	for iter.Next(ctx) {
+		flowName := strings.TrimPrefix(iter.Val(), ErrorBudgetRulesKeyInitial+":")
+		if err := fRuntime.evaluateErrorBudget(ctx, flowName); err != nil {
+			fRuntime.Logger.Log(fmt.Sprintf("[goflow] failed to evaluate error budget of flow %s, error %v", flowName, err))
+		}
+	}
+	if err := iter.Err(); err != nil {
+		fRuntime.Logger.Log(fmt.Sprintf("[goflow] failed to list error budget rules, error %v", err))
+	}
+}
+
+func (fRuntime *FlowRuntime) evaluateErrorBudget(ctx context.Context, flowName string) error {
+	rules, err := fRuntime.getErrorBudgetRules(ctx, flowName)
+	if err != nil {
+		return err
+	}
+	statuses, err := fRuntime.errorBudgetStatuses(ctx, flowName, rules)
+	if err != nil {
+		return err
+	}
+
+	rdb := fRuntime.redisClient()
+	stateKey := errorBudgetStateKey(flowName)
+	for idx, status := range statuses {
+		exceeded := status.Requests >= rules[idx].MinRequests && status.Requests > 0 &&
+			status.FailureRate > status.MaxFailureRate
+
+		var alertStatus string
+		switch {
+		case exceeded && !status.Tripped:
+			if err := rdb.HSet(ctx, stateKey, status.Rule, fRuntime.clock().Now().Unix()).Err(); err != nil {
+				return fmt.Errorf("failed to save error budget state, error %v", err)
+			}
+			alertStatus = ErrorBudgetStatusTripped
+		case !exceeded && status.Tripped:
+			if err := rdb.HDel(ctx, stateKey, status.Rule).Err(); err != nil {
+				return fmt.Errorf("failed to save error budget state, error %v", err)
+			}
+			alertStatus = ErrorBudgetStatusRecovered
+		default:
+			continue
+		}
+		status.Tripped = exceeded
+		fRuntime.sendErrorBudgetAlert(&ErrorBudgetAlert{
+			Flow:              flowName,
+			Status:            alertStatus,
+			ErrorBudgetStatus: status,
+			Time:              fRuntime.clock().Now(),
+		})
+	}
+	return rdb.Expire(ctx, stateKey, MaxErrorBudgetWindow).Err()
+}
+
+// sendErrorBudgetAlert reports an alert to OnErrorBudgetAlert and the AlertWebhookURL
+func (fRuntime *FlowRuntime) sendErrorBudgetAlert(alert *ErrorBudgetAlert) {
+	errorBudgetAlertsCounter.Inc(alert.Flow, alert.Rule, alert.Status)
+	fRuntime.Logger.Log(fmt.Sprintf("[goflow] error budget rule %s of flow %s %s, failure rate %.4f over %d requests",
+		alert.Rule, alert.Flow, alert.Status, alert.FailureRate, alert.Requests))
+
+	if fRuntime.OnErrorBudgetAlert != nil {
+		fRuntime.OnErrorBudgetAlert(alert)
+	}
+	if fRuntime.AlertWebhookURL == "" {
+		return
+	}
+	if err := postAlert(fRuntime.AlertWebhookURL, alert); err != nil {
+		fRuntime.Logger.Log(fmt.Sprintf("[goflow] failed to call alert webhook for flow %s, error %v", alert.Flow, err))
+	}
+}
+
+// postAlert posts an alert to the webhook, an ErrorBudgetAlert or a PoisonMessage told apart by their status
+func postAlert(webhookURL string, alert interface{}) error {
+	data, err := json.Marshal(alert)
+	if err != nil {
+		return fmt.Errorf("failed to marshal alert, error %v", err)
+	}
+	client := &http.Client{Timeout: AlertWebhookTimeout}
+	res, err := client.Post(webhookURL, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		resData, _ := io.ReadAll(res.Body)
+		return fmt.Errorf("webhook returned %d: %s", res.StatusCode, string(resData))
+	}
+	return nil
+}
+
+func flowStatsHandler(runtime *FlowRuntime) func(*gin.Context) {
+	fn := func(c *gin.Context) {
+		flowName, ok := flowNameParam(runtime, c)
+		if !ok {
+			return
+		}
+		stats, err := runtime.GetFlowStats(c.Request.Context(), flowName)
+		if err != nil {
+			runtimeCommon.HandleError(c.Writer, fmt.Sprintf("Failed to get flow stats, %v", err))
+			return
+		}
+		c.JSON(http.StatusOK, stats)
+	}
+	return fn
+}
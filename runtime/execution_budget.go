@@ -0,0 +1,94 @@
+package runtime
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/yuyang0/goflow/core/runtime"
+)
+
+const (
+	ExecutionBudgetKeyInitial = "goflow-budget"
+
+	// FailureCategoryBudget denotes a request that failed for exceeding its execution budget
+	FailureCategoryBudget = "Budget"
+)
+
+func executionBudgetKey(flowName string, requestID string) string {
+	return fmt.Sprintf("%s:%s:%s", ExecutionBudgetKeyInitial, flowName, requestID)
+}
+
+// recordExecutionTime adds the time spent executing a request to its consumed budget, the consumed
+// budget outlives the request state so that it can be inspected after the request completes. Only the requests
+// of the flows with an ExecutionBudget are tracked
+func (fRuntime *FlowRuntime) recordExecutionTime(request *runtime.Request, elapsed time.Duration) {
+	if request.RequestID == "" {
+		return
+	}
+	if options, ok := fRuntime.getFlowOptions(request.FlowName); !ok || options.ExecutionBudget <= 0 {
+		return
+	}
+	ctx := context.TODO()
+	key := executionBudgetKey(request.FlowName, request.RequestID)
+	pipe := fRuntime.redisClient().TxPipeline()
+	consumed := pipe.IncrBy(ctx, key, elapsed.Milliseconds())
+	pipe.Expire(ctx, key, TaskArchiveTimeOut)
+	if _, err := pipe.Exec(ctx); err != nil {
+		fRuntime.Logger.Log(fmt.Sprintf("[request `%s`] failed to record execution time, error %v", request.RequestID, err))
+		return
+	}
+
+	err := fRuntime.updateArchivedTask(request.RequestID, func(task *Task) {
+		task.ExecutionSeconds = float64(consumed.Val()) / 1000
+	})
+	if err != nil {
+		fRuntime.Logger.Log(fmt.Sprintf("[request `%s`] failed to update archived task, error %v", request.RequestID, err))
+	}
+}
+
+// consumedExecutionTime returns the time spent executing a request so far
+func (fRuntime *FlowRuntime) consumedExecutionTime(ctx context.Context, flowName string, requestID string) (time.Duration, error) {
+	consumed, err := fRuntime.redisClient().Get(ctx, executionBudgetKey(flowName, requestID)).Int64()
+	if err == redis.Nil {
+		return 0, nil
+	} else if err != nil {
+		return 0, fmt.Errorf("failed to get consumed execution time, error %v", err)
+	}
+	return time.Duration(consumed) * time.Millisecond, nil
+}
+
+// exceedsExecutionBudget reports whether a request consumed more than the ExecutionBudget of its flow,
+// independently of the wall clock deadline
+func (fRuntime *FlowRuntime) exceedsExecutionBudget(request *runtime.Request) (bool, error) {
+	options, ok := fRuntime.getFlowOptions(request.FlowName)
+	if !ok || options.ExecutionBudget <= 0 {
+		return false, nil
+	}
+	consumed, err := fRuntime.consumedExecutionTime(context.TODO(), request.FlowName, request.RequestID)
+	if err != nil {
+		return false, err
+	}
+	return consumed > options.ExecutionBudget, nil
+}
+
+// updateArchivedTask applies an update to the archived task of a request, if archived
+func (fRuntime *FlowRuntime) updateArchivedTask(requestID string, update func(task *Task)) error {
+	if !fRuntime.DurableTasksEnabled {
+		return nil
+	}
+	task, err := fRuntime.getArchivedTask(context.TODO(), requestID)
+	if err != nil {
+		// the request was not archived
+		return nil
+	}
+	update(task)
+	data, err := json.Marshal(task)
+	if err != nil {
+		return fmt.Errorf("failed to marshal task, error %v", err)
+	}
+	key := fmt.Sprintf("%s:%s", TaskArchiveKeyInitial, requestID)
+	return fRuntime.redisClient().Set(context.TODO(), key, data, TaskArchiveTimeOut).Err()
+}
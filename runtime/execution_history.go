@@ -0,0 +1,159 @@
+package runtime
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"github.com/yuyang0/goflow/core/runtime"
+	"github.com/yuyang0/goflow/core/sdk/executor"
+	runtimeCommon "github.com/yuyang0/goflow/runtime/common"
+)
+
+const (
+	HistoryKeyInitial           = "goflow-history"
+	HistorySubmissionKeyInitial = "goflow-history-submitted"
+
+	// HistorySubmissionTimeOut is how long the submission time of a request is kept until it ends
+	HistorySubmissionTimeOut = 7 * 24 * time.Hour
+
+	HistoryStatusCompleted = "completed"
+	HistoryStatusFailed    = "failed"
+	HistoryStatusStopped   = "stopped"
+	HistoryStatusCanceled  = "canceled" // canceled before being started
+)
+
+// HistoryRecord is a request of a flow that reached a terminal state
+type HistoryRecord struct {
+	RequestID   string    `json:"request_id"`
+	Status      string    `json:"status"`
+	SubmittedAt time.Time `json:"submitted_at"` // zero if the submission was not recorded
+	CompletedAt time.Time `json:"completed_at"`
+	Error       string    `json:"error,omitempty"`
+	// Annotations are the annotations of the request when it ended
+	Annotations []*executor.Annotation `json:"annotations,omitempty"`
+}
+
+func historyKey(flowName string) string {
+	return fmt.Sprintf("%s:%s", HistoryKeyInitial, flowName)
+}
+
+func historySubmissionKey(flowName string, requestID string) string {
+	return fmt.Sprintf("%s:%s:%s", HistorySubmissionKeyInitial, flowName, requestID)
+}
+
+// historySize returns the number of requests kept in the execution history of the flow, zero if disabled
+func (fRuntime *FlowRuntime) historySize(flowName string) int {
+	options, _ := fRuntime.getFlowOptions(flowName)
+	return options.HistorySize
+}
+
+// recordSubmission keeps the submission time of a new request until it reaches a terminal state.
+// The request id must be set
+func (fRuntime *FlowRuntime) recordSubmission(request *runtime.Request) {
+	if fRuntime.historySize(request.FlowName) <= 0 {
+		return
+	}
+	submittedAt := request.SubmittedAt
+	if submittedAt.IsZero() {
+		submittedAt = fRuntime.clock().Now()
+	}
+	// retries of the request keep the time of the first submission
+	err := fRuntime.redisClient().SetNX(context.TODO(), historySubmissionKey(request.FlowName, request.RequestID),
+		submittedAt.UnixNano(), HistorySubmissionTimeOut).Err()
+	if err != nil {
+		fRuntime.Logger.Log(fmt.Sprintf("[request `%s`] failed to record submission time, error %v", request.RequestID, err))
+	}
+}
+
+// recordHistory appends a request that reached a terminal state to the execution history of the flow,
+// capped at the HistorySize of the flow
+func (fRuntime *FlowRuntime) recordHistory(flowName string, requestID string, status string, cause error) {
+	size := fRuntime.historySize(flowName)
+	if size <= 0 {
+		return
+	}
+
+	ctx := context.TODO()
+	rdb := fRuntime.redisClient()
+	record := &HistoryRecord{RequestID: requestID, Status: status, CompletedAt: fRuntime.clock().Now()}
+	if cause != nil {
+		record.Error = cause.Error()
+	}
+	var submitted *redis.StringCmd
+	var annotations *redis.StringSliceCmd
+	_, err := rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		submitted = pipe.Get(ctx, historySubmissionKey(flowName, requestID))
+		pipe.Del(ctx, historySubmissionKey(flowName, requestID))
+		annotations = pipe.LRange(ctx, annotationKey(flowName, requestID), 0, -1)
+		return nil
+	})
+	if err == nil || err == redis.Nil {
+		if nanos, err := submitted.Int64(); err == nil {
+			record.SubmittedAt = time.Unix(0, nanos)
+		}
+		if values := annotations.Val(); len(values) > 0 {
+			record.Annotations = parseAnnotations(values)
+		}
+	}
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		fRuntime.Logger.Log(fmt.Sprintf("[request `%s`] failed to marshal history record, error %v", requestID, err))
+		return
+	}
+	pipe := rdb.TxPipeline()
+	pipe.LPush(ctx, historyKey(flowName), data)
+	pipe.LTrim(ctx, historyKey(flowName), 0, int64(size-1))
+	if _, err := pipe.Exec(ctx); err != nil {
+		fRuntime.Logger.Log(fmt.Sprintf("[request `%s`] failed to record execution history, error %v", requestID, err))
+	}
+}
+
+// GetExecutionHistory returns the latest requests of a flow that reached a terminal state, newest first.
+// All the kept requests are returned when limit is not positive
+func (fRuntime *FlowRuntime) GetExecutionHistory(ctx context.Context, flowName string, limit int) ([]*HistoryRecord, error) {
+	flowName, err := fRuntime.resolveFlowName(flowName)
+	if err != nil {
+		return nil, err
+	}
+
+	if limit < 0 {
+		limit = 0
+	}
+	values, err := fRuntime.redisClient().LRange(ctx, historyKey(flowName), 0, int64(limit-1)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get execution history of flow %s, error %v", flowName, err)
+	}
+	records := make([]*HistoryRecord, 0, len(values))
+	for _, value := range values {
+		record := &HistoryRecord{}
+		if err := json.Unmarshal([]byte(value), record); err != nil {
+			continue
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+func executionHistoryHandler(runtime *FlowRuntime) func(*gin.Context) {
+	fn := func(c *gin.Context) {
+		flowName, ok := flowNameParam(runtime, c)
+		if !ok {
+			return
+		}
+		limit, _ := strconv.Atoi(c.Query("limit"))
+		records, err := runtime.GetExecutionHistory(c.Request.Context(), flowName, limit)
+		if err != nil {
+			runtimeCommon.HandleError(c.Writer, fmt.Sprintf("Failed to get execution history, %v", err))
+			return
+		}
+		c.JSON(http.StatusOK, records)
+	}
+	return fn
+}
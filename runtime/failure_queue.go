@@ -0,0 +1,123 @@
+package runtime
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/adjust/rmq/v5"
+	"github.com/yuyang0/goflow/core/runtime"
+	"github.com/yuyang0/goflow/core/runtime/controller"
+)
+
+const (
+	FailurePendingKeyInitial = "goflow-failure-pending"
+
+	// FailurePendingTimeOut bounds how long a request id is held by a failure waiting to be handled
+	FailurePendingTimeOut = 24 * time.Hour
+
+	DefaultFailureConcurrency = 1
+)
+
+func (fRuntime *FlowRuntime) failureQueueId(flowName string) string {
+	return fmt.Sprintf("%s-failure", fRuntime.internalRequestQueueId(flowName))
+}
+
+func failurePendingKey(flowName string, requestID string) string {
+	return fmt.Sprintf("%s:%s:%s", FailurePendingKeyInitial, flowName, requestID)
+}
+
+// initializeFailureQueue starts the consumers of the failure queue of a flow, they are kept apart from the
+// request consumers so that slow failure handlers don't delay the execution of other requests
+func (fRuntime *FlowRuntime) initializeFailureQueue(conn rmq.Connection, flowName string) (rmq.Queue, error) {
+	failureQueue, err := conn.OpenQueue(fRuntime.failureQueueId(flowName))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open failure queue, error %v", err)
+	}
+	err = failureQueue.StartConsuming(10, time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start consumer failure queue, error %v", err)
+	}
+
+	concurrency := fRuntime.FailureConcurrency
+	if concurrency <= 0 {
+		concurrency = DefaultFailureConcurrency
+	}
+	for idx := 0; idx < concurrency; idx++ {
+		_, err = failureQueue.AddConsumer(fmt.Sprintf("failure-consumer-%d", idx), fRuntime)
+		if err != nil {
+			return nil, fmt.Errorf("failed to add failure consumer, error %v", err)
+		}
+	}
+	return failureQueue, nil
+}
+
+// DispatchFailure queues the failure handling of a request to the failure queue of the flow.
+// The state of the request is kept until the failure is handled
+func (fe *FlowExecutor) DispatchFailure(requestId string, err error) error {
+	return fe.Runtime.enqueueFailureRequest(fe.flowName, requestId, err)
+}
+
+func (fRuntime *FlowRuntime) enqueueFailureRequest(flowName string, requestID string, cause error) error {
+	// a new request with the same id is held back until the failure is handled as they share the state
+	err := fRuntime.redisClient().Set(context.TODO(), failurePendingKey(flowName, requestID), cause.Error(), FailurePendingTimeOut).Err()
+	if err != nil {
+		return fmt.Errorf("failed to mark failure of request %s, error %v", requestID, err)
+	}
+
+	failureQueue, err := fRuntime.rmqConnection.OpenQueue(fRuntime.failureQueueId(flowName))
+	if err != nil {
+		return fmt.Errorf("failed to get failure queue, error %v", err)
+	}
+	data, err := marshalTask(&Task{
+		FlowName:    flowName,
+		RequestID:   requestID,
+		Body:        TaskBody(cause.Error()),
+		Header:      make(map[string][]string),
+		Query:       make(map[string][]string),
+		RequestType: FailureRequest,
+	})
+	if err != nil {
+		fRuntime.redisClient().Del(context.TODO(), failurePendingKey(flowName, requestID))
+		return err
+	}
+	err = fRuntime.wrapQueue(failureQueue).PublishBytes(data)
+	if err != nil {
+		fRuntime.redisClient().Del(context.TODO(), failurePendingKey(flowName, requestID))
+		return fmt.Errorf("failed to publish failure, error %v", err)
+	}
+	return nil
+}
+
+// failurePending checks if the failure of a previous execution of the request is still to be handled
+func (fRuntime *FlowRuntime) failurePending(flowName string, requestID string) (bool, error) {
+	if requestID == "" {
+		return false, nil
+	}
+	count, err := fRuntime.redisClient().Exists(context.TODO(), failurePendingKey(flowName, requestID)).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to check pending failure of request %s, error %v", requestID, err)
+	}
+	return count > 0, nil
+}
+
+func (fRuntime *FlowRuntime) handleFailureRequest(request *runtime.Request) error {
+	flowExecutor, err := fRuntime.CreateExecutor(request)
+	if err != nil {
+		fRuntime.Logger.Log(fmt.Sprintf("[request `%s`] failed to handle failure. error: %v", request.RequestID, err.Error()))
+		return fmt.Errorf("request %s failed to handle failure. error: %v", request.RequestID, err.Error())
+	}
+	response := &runtime.Response{}
+	response.RequestID = request.RequestID
+	err = controller.FailureFlowHandler(response, request, flowExecutor)
+	if err != nil {
+		fRuntime.Logger.Log(fmt.Sprintf("[request `%s`] failed to handle failure. error: %v", request.RequestID, err.Error()))
+		return fmt.Errorf("request %s failed to handle failure. error: %v", request.RequestID, err.Error())
+	}
+
+	err = fRuntime.redisClient().Del(context.TODO(), failurePendingKey(request.FlowName, request.RequestID)).Err()
+	if err != nil {
+		fRuntime.Logger.Log(fmt.Sprintf("[request `%s`] failed to clear pending failure. error: %v", request.RequestID, err))
+	}
+	return nil
+}
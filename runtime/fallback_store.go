@@ -0,0 +1,579 @@
+package runtime
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/yuyang0/goflow/core/sdk"
+	"github.com/yuyang0/goflow/metrics"
+	"github.com/yuyang0/goflow/runtime/clock"
+)
+
+const (
+	// FallbackWritePrimary writes to the primary store only, the writes fail while it is unavailable. The reads
+	// fall back to a secondary kept in sync out of goflow, e.g. by the replication of redis
+	FallbackWritePrimary = "primary"
+	// FallbackWriteMirror writes to both stores, the primary is authoritative and the writes go to the secondary
+	// only while it is unavailable
+	FallbackWriteMirror = "mirror"
+
+	// FallbackRetryInterval is how long the operations go straight to the secondary store once the primary is
+	// found unavailable, before the primary is tried again
+	FallbackRetryInterval = 10 * time.Second
+)
+
+// ErrPrimaryStoreUnavailable is returned by the writes of a fallback store with the FallbackWritePrimary policy
+// while its primary is unavailable
+var ErrPrimaryStoreUnavailable = errors.New("primary store unavailable")
+
+var (
+	storeFallbacksCounter = metrics.NewCounterVec("goflow_store_fallbacks_total",
+		"Store operations served by the secondary store as the primary was unavailable", "store", "operation")
+	storeMirrorFailuresCounter = metrics.NewCounterVec("goflow_store_mirror_failures_total",
+		"Writes applied to the primary store that failed to be mirrored to the secondary", "store")
+)
+
+// IsStoreUnavailable checks if a store operation failed as its backend couldn't be reached, rather than being
+// rejected by it, e.g. a missing key or a conflicting update
+func IsStoreUnavailable(err error) bool {
+	if err == nil {
+		return false
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, net.ErrClosed) || errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.EPIPE) || errors.Is(err, redis.ErrClosed)
+}
+
+// fallback is the state of a fallback store shared by its copies, so that the degradation is logged once
+type fallback struct {
+	store         string
+	policy        string
+	logger        sdk.Logger
+	retryInterval time.Duration
+	clock         clock.Clock
+
+	mu          sync.Mutex
+	degradedAt  time.Time // zero while the primary is available
+	reconciling bool
+	replays     []func() error // the writes the secondary took, replayed on the primary before it is used again
+}
+
+func newFallback(store string, policy string, logger sdk.Logger) *fallback {
+	if policy == "" {
+		policy = FallbackWritePrimary
+	}
+	return &fallback{store: store, policy: policy, logger: logger, retryInterval: FallbackRetryInterval,
+		clock: clock.Real{}}
+}
+
+func (fb *fallback) log(message string) {
+	if fb.logger != nil {
+		fb.logger.Log(message)
+	}
+}
+
+func (fb *fallback) setRetryInterval(interval time.Duration) {
+	fb.mu.Lock()
+	defer fb.mu.Unlock()
+	if interval <= 0 {
+		interval = FallbackRetryInterval
+	}
+	fb.retryInterval = interval
+}
+
+func (fb *fallback) setClock(c clock.Clock) {
+	fb.mu.Lock()
+	defer fb.mu.Unlock()
+	fb.clock = c
+}
+
+// primaryAvailable checks if the primary is to be tried, it is skipped for the retry interval once unavailable,
+// and until the writes the secondary took meanwhile are replayed on it
+func (fb *fallback) primaryAvailable() bool {
+	fb.mu.Lock()
+	if fb.degradedAt.IsZero() {
+		fb.mu.Unlock()
+		return true
+	}
+	if fb.reconciling || fb.clock.Now().Sub(fb.degradedAt) < fb.retryInterval {
+		fb.mu.Unlock()
+		return false
+	}
+	fb.reconciling = true
+	replays := fb.replays
+	fb.replays = nil
+	fb.mu.Unlock()
+	return fb.reconcile(replays)
+}
+
+// reconcile replays the writes the secondary took while the primary was unavailable, the primary stays skipped
+// when it is still unavailable. A replay rejected by the primary is logged and dropped
+func (fb *fallback) reconcile(replays []func() error) bool {
+	for idx, replay := range replays {
+		err := replay()
+		if IsStoreUnavailable(err) {
+			fb.mu.Lock()
+			fb.replays = append(replays[idx:], fb.replays...)
+			fb.degradedAt = fb.clock.Now()
+			fb.reconciling = false
+			fb.mu.Unlock()
+			return false
+		}
+		if err != nil {
+			fb.log(fmt.Sprintf("[goflow] failed to replay a write of the secondary %s store on the primary, error %v",
+				fb.store, err))
+		}
+	}
+	if len(replays) > 0 {
+		fb.log(fmt.Sprintf("[goflow] replayed %d writes of the secondary %s store on the primary", len(replays),
+			fb.store))
+	}
+	fb.mu.Lock()
+	fb.reconciling = false
+	fb.mu.Unlock()
+	return true
+}
+
+// replayLater records a write of the secondary to replay on the primary
+func (fb *fallback) replayLater(replay func() error) {
+	fb.mu.Lock()
+	defer fb.mu.Unlock()
+	if fb.degradedAt.IsZero() {
+		// the primary recovered while the write ran on the secondary, it is replayed by the next operation
+		fb.degradedAt = fb.clock.Now().Add(-fb.retryInterval)
+	}
+	fb.replays = append(fb.replays, replay)
+}
+
+// failed records the outcome of an operation of the primary, it returns whether the primary was unavailable
+func (fb *fallback) failed(err error) bool {
+	unavailable := IsStoreUnavailable(err)
+	fb.mu.Lock()
+	defer fb.mu.Unlock()
+	if unavailable {
+		if fb.degradedAt.IsZero() {
+			fb.log(fmt.Sprintf("[goflow] primary %s store unavailable, falling back to the secondary, error %v",
+				fb.store, err))
+		}
+		fb.degradedAt = fb.clock.Now()
+		return true
+	}
+	// the primary is only available again once the writes of the secondary are replayed
+	if !fb.degradedAt.IsZero() && len(fb.replays) == 0 && !fb.reconciling {
+		fb.log(fmt.Sprintf("[goflow] primary %s store available again, after %s", fb.store,
+			fb.clock.Now().Sub(fb.degradedAt).Round(time.Second)))
+		fb.degradedAt = time.Time{}
+	}
+	return false
+}
+
+// read runs a read on the primary, and on the secondary when the primary is unavailable
+func (fb *fallback) read(operation string, primary func() error, secondary func() error) error {
+	if fb.primaryAvailable() {
+		err := primary()
+		if !fb.failed(err) {
+			return err
+		}
+	}
+	storeFallbacksCounter.Inc(fb.store, operation)
+	return secondary()
+}
+
+// write runs a write on the stores as per the write policy. With FallbackWritePrimary, every write tries the
+// primary so that its recovery is noticed right away. With FallbackWriteMirror, a write applied to the primary is
+// mirrored to the secondary, and the secondary runs the write itself when the primary is unavailable, the write
+// being replayed on the primary before it is used again
+func (fb *fallback) write(operation string, primary func() error, mirror func() error, secondary func() error,
+	replay func() error) error {
+	if fb.policy != FallbackWriteMirror {
+		err := primary()
+		if fb.failed(err) {
+			return fmt.Errorf("%w, failed to %s on the %s store, error %w", ErrPrimaryStoreUnavailable, operation,
+				fb.store, err)
+		}
+		return err
+	}
+
+	if fb.primaryAvailable() {
+		err := primary()
+		if !fb.failed(err) {
+			if err != nil {
+				return err
+			}
+			if merr := mirror(); merr != nil {
+				storeMirrorFailuresCounter.Inc(fb.store)
+				fb.log(fmt.Sprintf("[goflow] failed to mirror %s to the secondary %s store, error %v",
+					operation, fb.store, merr))
+			}
+			return nil
+		}
+	}
+	storeFallbacksCounter.Inc(fb.store, operation)
+	if err := secondary(); err != nil {
+		return err
+	}
+	fb.replayLater(replay)
+	return nil
+}
+
+// FallbackStateStore is a StateStore which falls back to a secondary store, e.g. a redis in another region,
+// while its primary is unavailable. The reads go to the secondary, and the writes follow the write policy,
+// FallbackWritePrimary or FallbackWriteMirror
+type FallbackStateStore struct {
+	primary   sdk.StateStore
+	secondary sdk.StateStore
+	fallback  *fallback
+}
+
+// NewFallbackStateStore creates a StateStore falling back to secondary when primary is unavailable, the
+// degradation and the recovery of the primary are logged with the logger
+func NewFallbackStateStore(primary sdk.StateStore, secondary sdk.StateStore, policy string,
+	logger sdk.Logger) *FallbackStateStore {
+	return &FallbackStateStore{primary: primary, secondary: secondary, fallback: newFallback(StoreTypeState, policy, logger)}
+}
+
+// SetRetryInterval sets how long the primary is skipped once unavailable, FallbackRetryInterval by default
+func (store *FallbackStateStore) SetRetryInterval(interval time.Duration) {
+	store.fallback.setRetryInterval(interval)
+}
+
+// SetClock sets the clock the retry interval is measured with, the real clock by default
+func (store *FallbackStateStore) SetClock(c clock.Clock) {
+	store.fallback.setClock(c)
+}
+
+// Unwrap returns the primary store
+func (store *FallbackStateStore) Unwrap() sdk.StateStore {
+	return store.primary
+}
+
+func (store *FallbackStateStore) Configure(flowName string, requestId string) {
+	store.primary.Configure(flowName, requestId)
+	store.secondary.Configure(flowName, requestId)
+}
+
+// Init initializes both stores, it only fails when the primary rejects it
+func (store *FallbackStateStore) Init() error {
+	return initFallbackStores(store.primary, store.secondary)
+}
+
+// replay copies the value of key from the secondary to the primary
+func (store *FallbackStateStore) replay(key string) func() error {
+	return func() error {
+		value, err := store.secondary.Get(key)
+		if err != nil {
+			return err
+		}
+		return store.primary.Set(key, value)
+	}
+}
+
+func (store *FallbackStateStore) Set(key string, value string) error {
+	set := func(target sdk.StateStore) func() error {
+		return func() error { return target.Set(key, value) }
+	}
+	return store.fallback.write("set", set(store.primary), set(store.secondary), set(store.secondary),
+		store.replay(key))
+}
+
+func (store *FallbackStateStore) Get(key string) (string, error) {
+	var value string
+	get := func(target sdk.StateStore) func() error {
+		return func() error {
+			var err error
+			value, err = target.Get(key)
+			return err
+		}
+	}
+	err := store.fallback.read("get", get(store.primary), get(store.secondary))
+	return value, err
+}
+
+// Incr increments a counter, the value of the primary is returned when the increment is mirrored
+func (store *FallbackStateStore) Incr(key string, value int64) (int64, error) {
+	var count int64
+	incr := func(target sdk.StateStore, result *int64) func() error {
+		return func() error {
+			var err error
+			*result, err = target.Incr(key, value)
+			return err
+		}
+	}
+	var mirrored int64
+	err := store.fallback.write("incr", incr(store.primary, &count), incr(store.secondary, &mirrored),
+		incr(store.secondary, &count), store.replay(key))
+	return count, err
+}
+
+// Update compares and updates a value, the new value is set on the secondary when the update is mirrored
+func (store *FallbackStateStore) Update(key string, oldValue string, newValue string) error {
+	return store.fallback.write("update",
+		func() error { return store.primary.Update(key, oldValue, newValue) },
+		func() error { return store.secondary.Set(key, newValue) },
+		func() error { return store.secondary.Update(key, oldValue, newValue) },
+		store.replay(key))
+}
+
+func (store *FallbackStateStore) Cleanup() error {
+	return store.fallback.write("cleanup", store.primary.Cleanup, store.secondary.Cleanup, store.secondary.Cleanup,
+		store.primary.Cleanup)
+}
+
+func (store *FallbackStateStore) CopyStore() (sdk.StateStore, error) {
+	primary, err := store.primary.CopyStore()
+	if err != nil {
+		return nil, err
+	}
+	secondary, err := store.secondary.CopyStore()
+	if err != nil {
+		return nil, err
+	}
+	return &FallbackStateStore{primary: primary, secondary: secondary, fallback: store.fallback}, nil
+}
+
+// Ping checks the primary, the runtime doesn't start while its primary store is unreachable
+func (store *FallbackStateStore) Ping() error {
+	if pinger, ok := store.primary.(sdk.Pinger); ok {
+		return pinger.Ping()
+	}
+	return nil
+}
+
+// Close closes both stores
+func (store *FallbackStateStore) Close() error {
+	return closeFallbackStores(store.primary, store.secondary)
+}
+
+// FallbackDataStore is a DataStore which falls back to a secondary store while its primary is unavailable, see
+// FallbackStateStore
+type FallbackDataStore struct {
+	primary   sdk.DataStore
+	secondary sdk.DataStore
+	fallback  *fallback
+}
+
+// NewFallbackDataStore creates a DataStore falling back to secondary when primary is unavailable, the
+// degradation and the recovery of the primary are logged with the logger
+func NewFallbackDataStore(primary sdk.DataStore, secondary sdk.DataStore, policy string,
+	logger sdk.Logger) *FallbackDataStore {
+	return &FallbackDataStore{primary: primary, secondary: secondary, fallback: newFallback(StoreTypeData, policy, logger)}
+}
+
+// SetRetryInterval sets how long the primary is skipped once unavailable, FallbackRetryInterval by default
+func (store *FallbackDataStore) SetRetryInterval(interval time.Duration) {
+	store.fallback.setRetryInterval(interval)
+}
+
+// SetClock sets the clock the retry interval is measured with, the real clock by default
+func (store *FallbackDataStore) SetClock(c clock.Clock) {
+	store.fallback.setClock(c)
+}
+
+// Unwrap returns the primary store
+func (store *FallbackDataStore) Unwrap() sdk.DataStore {
+	return store.primary
+}
+
+func (store *FallbackDataStore) Configure(flowName string, requestId string) {
+	store.primary.Configure(flowName, requestId)
+	store.secondary.Configure(flowName, requestId)
+}
+
+// Init initializes both stores, it only fails when the primary rejects it
+func (store *FallbackDataStore) Init() error {
+	return initFallbackStores(store.primary, store.secondary)
+}
+
+// replay copies the value of key from the secondary to the primary, the value is streamed
+func (store *FallbackDataStore) replay(key string) func() error {
+	return func() error {
+		return copyDataStoreValue(store.secondary, store.primary, key)
+	}
+}
+
+func copyDataStoreValue(from sdk.DataStore, to sdk.DataStore, key string) error {
+	reader, err := sdk.GetReader(from, key)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	return sdk.SetReader(to, key, reader)
+}
+
+func (store *FallbackDataStore) Set(key string, value []byte) error {
+	set := func(target sdk.DataStore) func() error {
+		return func() error { return target.Set(key, value) }
+	}
+	return store.fallback.write("set", set(store.primary), set(store.secondary), set(store.secondary),
+		store.replay(key))
+}
+
+func (store *FallbackDataStore) Get(key string) ([]byte, error) {
+	var value []byte
+	get := func(target sdk.DataStore) func() error {
+		return func() error {
+			var err error
+			value, err = target.Get(key)
+			return err
+		}
+	}
+	err := store.fallback.read("get", get(store.primary), get(store.secondary))
+	return value, err
+}
+
+// GetReader returns a reader of the value of key, from the secondary when the primary is unavailable
+func (store *FallbackDataStore) GetReader(key string) (io.ReadCloser, error) {
+	var reader io.ReadCloser
+	getReader := func(target sdk.DataStore) func() error {
+		return func() error {
+			var err error
+			reader, err = sdk.GetReader(target, key)
+			return err
+		}
+	}
+	err := store.fallback.read("get", getReader(store.primary), getReader(store.secondary))
+	return reader, err
+}
+
+// SetReader stores the content of the reader, the value is copied from the primary to the secondary when the
+// write is mirrored. The secondary only takes the write when the primary failed before reading the content
+func (store *FallbackDataStore) SetReader(key string, reader io.Reader) error {
+	counted := &countingReader{Reader: reader}
+	return store.fallback.write("set",
+		func() error { return sdk.SetReader(store.primary, key, counted) },
+		func() error { return copyDataStoreValue(store.primary, store.secondary, key) },
+		func() error {
+			if counted.count > 0 {
+				return fmt.Errorf("%w, failed to set %s on the %s store, the value was partially read",
+					ErrPrimaryStoreUnavailable, key, StoreTypeData)
+			}
+			return sdk.SetReader(store.secondary, key, counted)
+		},
+		store.replay(key))
+}
+
+// SetIfAbsent sets a value if its key is absent, the value is set on the secondary when the write is mirrored
+func (store *FallbackDataStore) SetIfAbsent(key string, value []byte) (bool, error) {
+	var stored bool
+	setIfAbsent := func(target sdk.DataStore) func() error {
+		return func() error {
+			var err error
+			stored, err = sdk.SetIfAbsent(target, key, value)
+			return err
+		}
+	}
+	err := store.fallback.write("setnx", setIfAbsent(store.primary),
+		func() error {
+			if !stored {
+				return nil
+			}
+			return store.secondary.Set(key, value)
+		}, setIfAbsent(store.secondary), store.replay(key))
+	return stored, err
+}
+
+func (store *FallbackDataStore) Del(key string) error {
+	del := func(target sdk.DataStore) func() error {
+		return func() error { return target.Del(key) }
+	}
+	return store.fallback.write("del", del(store.primary), del(store.secondary), del(store.secondary),
+		del(store.primary))
+}
+
+func (store *FallbackDataStore) Cleanup() error {
+	return store.fallback.write("cleanup", store.primary.Cleanup, store.secondary.Cleanup, store.secondary.Cleanup,
+		store.primary.Cleanup)
+}
+
+func (store *FallbackDataStore) CopyStore() (sdk.DataStore, error) {
+	primary, err := store.primary.CopyStore()
+	if err != nil {
+		return nil, err
+	}
+	secondary, err := store.secondary.CopyStore()
+	if err != nil {
+		return nil, err
+	}
+	return &FallbackDataStore{primary: primary, secondary: secondary, fallback: store.fallback}, nil
+}
+
+// Ping checks the primary, the runtime doesn't start while its primary store is unreachable
+func (store *FallbackDataStore) Ping() error {
+	if pinger, ok := store.primary.(sdk.Pinger); ok {
+		return pinger.Ping()
+	}
+	return nil
+}
+
+// Close closes both stores
+func (store *FallbackDataStore) Close() error {
+	return closeFallbackStores(store.primary, store.secondary)
+}
+
+func initFallbackStores(primary interface{ Init() error }, secondary interface{ Init() error }) error {
+	err := primary.Init()
+	if serr := secondary.Init(); err == nil || (IsStoreUnavailable(err) && serr == nil) {
+		return nil
+	}
+	return err
+}
+
+func closeFallbackStores(stores ...interface{}) error {
+	var errs []error
+	for _, store := range stores {
+		if closer, ok := store.(io.Closer); ok {
+			if err := closer.Close(); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// withFallbackStores wraps the stores opened by the runtime with the stores of FallbackRedisCfg, the runtime
+// runs without fallback when they can't be opened
+func (fRuntime *FlowRuntime) withFallbackStores(conns *connections) {
+	if fRuntime.FallbackRedisCfg == nil {
+		return
+	}
+	policy := fRuntime.FallbackWritePolicy
+	if policy != "" && policy != FallbackWritePrimary && policy != FallbackWriteMirror {
+		fRuntime.Logger.Log(fmt.Sprintf("[goflow] unknown fallback write policy %q, %s is used", policy,
+			FallbackWritePrimary))
+		policy = FallbackWritePrimary
+	}
+
+	stateStore, err := initStateStore(fRuntime.FallbackRedisCfg, fRuntime.StrongConsistency,
+		fRuntime.StateStoreRetryCount, fRuntime.StateStoreRetryBackoff, fRuntime.StoreKeyCountsEnabled)
+	if err != nil {
+		fRuntime.Logger.Log(fmt.Sprintf("[goflow] failed to open the fallback statestore, error %v", err))
+		return
+	}
+	var dataStore sdk.DataStore
+	if conns.dataStore != nil {
+		dataStore, err = initDataStore(fRuntime.FallbackRedisCfg, fRuntime.DataStoreBucketTemplate, fRuntime.Tenant,
+			fRuntime.StoreKeyCountsEnabled)
+		if err != nil {
+			closeFallbackStores(stateStore)
+			fRuntime.Logger.Log(fmt.Sprintf("[goflow] failed to open the fallback datastore, error %v", err))
+			return
+		}
+	}
+
+	fallbackStateStore := NewFallbackStateStore(conns.stateStore, stateStore, policy, fRuntime.Logger)
+	fallbackStateStore.SetClock(fRuntime.clock())
+	conns.stateStore = fallbackStateStore
+	// a DataStore provided to the runtime is wrapped with NewFallbackDataStore by its owner
+	if dataStore != nil {
+		fallbackDataStore := NewFallbackDataStore(conns.dataStore, dataStore, policy, fRuntime.Logger)
+		fallbackDataStore.SetClock(fRuntime.clock())
+		conns.dataStore = fallbackDataStore
+	}
+}
@@ -0,0 +1,179 @@
+package runtime_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	redisDataStore "github.com/yuyang0/goflow/core/redis-datastore"
+	redisStateStore "github.com/yuyang0/goflow/core/redis-statestore"
+	"github.com/yuyang0/goflow/core/sdk"
+	"github.com/yuyang0/goflow/runtime"
+	"github.com/yuyang0/goflow/runtime/clock/clocktest"
+	"github.com/yuyang0/goflow/types"
+)
+
+// fallbackStateStores opens a fallback StateStore over two miniredis, the primary and the secondary
+func fallbackStateStores(t *testing.T, policy string) (*miniredis.Miniredis, sdk.StateStore, *runtime.FallbackStateStore) {
+	t.Helper()
+	primaryRedis, secondaryRedis := miniredis.RunT(t), miniredis.RunT(t)
+	primary, err := redisStateStore.GetRedisStateStore(&types.RedisConfig{Addr: primaryRedis.Addr()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	secondary, err := redisStateStore.GetRedisStateStore(&types.RedisConfig{Addr: secondaryRedis.Addr()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := runtime.NewFallbackStateStore(primary, secondary, policy, nil)
+	store.Configure("flow", "request")
+	t.Cleanup(func() { store.Close() })
+	return primaryRedis, primary, store
+}
+
+func TestIsStoreUnavailable(t *testing.T) {
+	unavailable := []error{
+		&net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")},
+		fmt.Errorf("failed to get key k, %w", io.EOF),
+		fmt.Errorf("failed to set key k, error %w", net.ErrClosed),
+	}
+	for _, err := range unavailable {
+		if !runtime.IsStoreUnavailable(err) {
+			t.Errorf("expected %v to be an unavailable store", err)
+		}
+	}
+	available := []error{
+		nil,
+		errors.New("failed to get key k, nil"),
+		errors.New("Old value doesn't match for key k"),
+		errors.New("decoding failed, unexpected eof"),
+	}
+	for _, err := range available {
+		if runtime.IsStoreUnavailable(err) {
+			t.Errorf("expected %v not to be an unavailable store", err)
+		}
+	}
+}
+
+func TestFallbackPrimaryPolicyReprobesWrites(t *testing.T) {
+	primaryRedis, _, store := fallbackStateStores(t, runtime.FallbackWritePrimary)
+
+	primaryRedis.Close()
+	if err := store.Set("key", "down"); !errors.Is(err, runtime.ErrPrimaryStoreUnavailable) {
+		t.Fatalf("expected ErrPrimaryStoreUnavailable, got %v", err)
+	}
+	if err := primaryRedis.Restart(); err != nil {
+		t.Fatal(err)
+	}
+	// the write tries the primary again without waiting for the retry interval
+	if err := store.Set("key", "up"); err != nil {
+		t.Fatalf("expected the write to reach the recovered primary, got %v", err)
+	}
+}
+
+func TestFallbackMirrorReplaysWritesOnRecovery(t *testing.T) {
+	primaryRedis, primary, store := fallbackStateStores(t, runtime.FallbackWriteMirror)
+	store.SetRetryInterval(50 * time.Millisecond)
+
+	if _, err := store.Incr("branches", 1); err != nil {
+		t.Fatal(err)
+	}
+	primaryRedis.Close()
+	count, err := store.Incr("branches", 2)
+	if err != nil {
+		t.Fatalf("expected the increment to fall back to the secondary, got %v", err)
+	}
+	if count != 3 {
+		t.Fatalf("expected the secondary to count 3, got %d", count)
+	}
+	if err := store.Set("status", "waiting"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := primaryRedis.Restart(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if value, err := store.Get("status"); err != nil || value != "waiting" {
+		t.Fatalf("expected the status written to the secondary, got %q, %v", value, err)
+	}
+	// the writes of the secondary are replayed on the primary before it is read again
+	if value, err := primary.Get("branches"); err != nil || value != "3" {
+		t.Fatalf("expected the counter of the secondary to be replayed on the primary, got %q, %v", value, err)
+	}
+	if value, err := primary.Get("status"); err != nil || value != "waiting" {
+		t.Fatalf("expected the status of the secondary to be replayed on the primary, got %q, %v", value, err)
+	}
+}
+
+// TestFallbackRetryIntervalFollowsClock checks that the primary is skipped until the clock of the store moves past
+// the retry interval
+func TestFallbackRetryIntervalFollowsClock(t *testing.T) {
+	primaryRedis, primary, store := fallbackStateStores(t, runtime.FallbackWriteMirror)
+	fc := clocktest.NewFakeClock(time.Now())
+	store.SetClock(fc)
+
+	primaryRedis.Close()
+	if err := store.Set("status", "waiting"); err != nil {
+		t.Fatalf("expected the write to fall back to the secondary, got %v", err)
+	}
+	if err := primaryRedis.Restart(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if _, err := store.Get("status"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := primary.Get("status"); err == nil {
+		t.Fatal("expected the primary to be skipped within the retry interval")
+	}
+
+	fc.Advance(runtime.FallbackRetryInterval)
+	if _, err := store.Get("status"); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := primary.Get("status"); err != nil || value != "waiting" {
+		t.Fatalf("expected the primary to be used again past the retry interval, got %q, %v", value, err)
+	}
+}
+
+func TestFallbackDataStoreStreams(t *testing.T) {
+	primaryRedis, secondaryRedis := miniredis.RunT(t), miniredis.RunT(t)
+	primary, err := redisDataStore.GetRedisDataStore(&types.RedisConfig{Addr: primaryRedis.Addr()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	secondary, err := redisDataStore.GetRedisDataStore(&types.RedisConfig{Addr: secondaryRedis.Addr()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := runtime.NewFallbackDataStore(primary, secondary, runtime.FallbackWriteMirror, nil)
+	store.Configure("flow", "request")
+	defer store.Close()
+	if _, ok := interface{}(store).(sdk.StreamingDataStore); !ok {
+		t.Fatal("expected the fallback store to stream the values")
+	}
+
+	value := bytes.Repeat([]byte("v"), 1024)
+	if err := store.SetReader("output", bytes.NewReader(value)); err != nil {
+		t.Fatal(err)
+	}
+	primaryRedis.Close()
+	reader, err := store.GetReader("output")
+	if err != nil {
+		t.Fatalf("expected the mirrored value to be read from the secondary, got %v", err)
+	}
+	defer reader.Close()
+	read, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(read, value) {
+		t.Fatalf("expected the mirrored value, got %d bytes", len(read))
+	}
+}
@@ -0,0 +1,304 @@
+package runtime
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/adjust/rmq/v5"
+	"github.com/gin-gonic/gin"
+	"github.com/yuyang0/goflow/core/sdk"
+	runtimeCommon "github.com/yuyang0/goflow/runtime/common"
+)
+
+const (
+	FaultOperationPublish    = "publish"
+	FaultOperationAck        = "ack"
+	FaultOperationStateStore = "statestore"
+	FaultOperationDataStore  = "datastore"
+
+	ActionInjectFaults = "inject-faults"
+)
+
+// ErrInjectedFault is returned by operations failed by the FaultInjector
+var ErrInjectedFault = fmt.Errorf("injected fault")
+
+// FaultConfig configures the faults injected into an operation type
+type FaultConfig struct {
+	FailureRate float64 `json:"failure_rate"` // ratio of operations that fail, between 0 and 1
+	DropRate    float64 `json:"drop_rate"`    // ratio of publishes that are silently dropped, between 0 and 1
+	LatencyMs   int     `json:"latency_ms"`   // latency added to each operation
+}
+
+// FaultInjector injects failures, latency and dropped publishes into the queues and stores
+// to validate failure handling. It must never be enabled in production
+type FaultInjector struct {
+	mu     sync.Mutex
+	faults map[string]FaultConfig
+	rand   *rand.Rand
+}
+
+// NewFaultInjector creates a FaultInjector which doesn't inject any fault until configured
+func NewFaultInjector() *FaultInjector {
+	return &FaultInjector{
+		faults: make(map[string]FaultConfig),
+		rand:   rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// Configure sets the faults injected into an operation type
+func (injector *FaultInjector) Configure(operation string, config FaultConfig) {
+	injector.mu.Lock()
+	defer injector.mu.Unlock()
+	injector.faults[operation] = config
+}
+
+// Faults returns the configured faults by operation type
+func (injector *FaultInjector) Faults() map[string]FaultConfig {
+	injector.mu.Lock()
+	defer injector.mu.Unlock()
+	faults := make(map[string]FaultConfig, len(injector.faults))
+	for operation, config := range injector.faults {
+		faults[operation] = config
+	}
+	return faults
+}
+
+// inject applies the configured faults of an operation, it returns whether the operation
+// must be dropped or the error it must fail with
+func (injector *FaultInjector) inject(operation string) (bool, error) {
+	injector.mu.Lock()
+	config, ok := injector.faults[operation]
+	var failRoll, dropRoll float64
+	if ok {
+		failRoll, dropRoll = injector.rand.Float64(), injector.rand.Float64()
+	}
+	injector.mu.Unlock()
+	if !ok {
+		return false, nil
+	}
+
+	if config.LatencyMs > 0 {
+		time.Sleep(time.Duration(config.LatencyMs) * time.Millisecond)
+	}
+	if failRoll < config.FailureRate {
+		return false, fmt.Errorf("%s: %w", operation, ErrInjectedFault)
+	}
+	return dropRoll < config.DropRate, nil
+}
+
+type faultyQueue struct {
+	rmq.Queue
+	injector *FaultInjector
+}
+
+func (queue *faultyQueue) PublishBytes(payload ...[]byte) error {
+	drop, err := queue.injector.inject(FaultOperationPublish)
+	if err != nil || drop {
+		return err
+	}
+	return queue.Queue.PublishBytes(payload...)
+}
+
+type faultyDelivery struct {
+	rmq.Delivery
+	injector *FaultInjector
+}
+
+func (delivery *faultyDelivery) Ack() error {
+	if _, err := delivery.injector.inject(FaultOperationAck); err != nil {
+		return err
+	}
+	return delivery.Delivery.Ack()
+}
+
+type faultyStateStore struct {
+	sdk.StateStore
+	injector *FaultInjector
+}
+
+func (store *faultyStateStore) Set(key string, value string) error {
+	if _, err := store.injector.inject(FaultOperationStateStore); err != nil {
+		return err
+	}
+	return store.StateStore.Set(key, value)
+}
+
+func (store *faultyStateStore) Get(key string) (string, error) {
+	if _, err := store.injector.inject(FaultOperationStateStore); err != nil {
+		return "", err
+	}
+	return store.StateStore.Get(key)
+}
+
+func (store *faultyStateStore) Incr(key string, value int64) (int64, error) {
+	if _, err := store.injector.inject(FaultOperationStateStore); err != nil {
+		return 0, err
+	}
+	return store.StateStore.Incr(key, value)
+}
+
+func (store *faultyStateStore) Update(key string, oldValue string, newValue string) error {
+	if _, err := store.injector.inject(FaultOperationStateStore); err != nil {
+		return err
+	}
+	return store.StateStore.Update(key, oldValue, newValue)
+}
+
+func (store *faultyStateStore) Ping() error {
+	if _, err := store.injector.inject(FaultOperationStateStore); err != nil {
+		return err
+	}
+	return pingStore(store.StateStore)
+}
+
+func (store *faultyStateStore) Close() error {
+	return closeFallbackStores(store.StateStore)
+}
+
+// Unwrap returns the store the faults are injected in
+func (store *faultyStateStore) Unwrap() sdk.StateStore {
+	return store.StateStore
+}
+
+func (store *faultyStateStore) CopyStore() (sdk.StateStore, error) {
+	copied, err := store.StateStore.CopyStore()
+	if err != nil {
+		return nil, err
+	}
+	return &faultyStateStore{StateStore: copied, injector: store.injector}, nil
+}
+
+type faultyDataStore struct {
+	sdk.DataStore
+	injector *FaultInjector
+}
+
+func (store *faultyDataStore) Set(key string, value []byte) error {
+	if _, err := store.injector.inject(FaultOperationDataStore); err != nil {
+		return err
+	}
+	return store.DataStore.Set(key, value)
+}
+
+func (store *faultyDataStore) Get(key string) ([]byte, error) {
+	if _, err := store.injector.inject(FaultOperationDataStore); err != nil {
+		return nil, err
+	}
+	return store.DataStore.Get(key)
+}
+
+func (store *faultyDataStore) SetReader(key string, reader io.Reader) error {
+	if _, err := store.injector.inject(FaultOperationDataStore); err != nil {
+		return err
+	}
+	return sdk.SetReader(store.DataStore, key, reader)
+}
+
+func (store *faultyDataStore) GetReader(key string) (io.ReadCloser, error) {
+	if _, err := store.injector.inject(FaultOperationDataStore); err != nil {
+		return nil, err
+	}
+	return sdk.GetReader(store.DataStore, key)
+}
+
+func (store *faultyDataStore) SetIfAbsent(key string, value []byte) (bool, error) {
+	if _, err := store.injector.inject(FaultOperationDataStore); err != nil {
+		return false, err
+	}
+	return sdk.SetIfAbsent(store.DataStore, key, value)
+}
+
+func (store *faultyDataStore) Del(key string) error {
+	if _, err := store.injector.inject(FaultOperationDataStore); err != nil {
+		return err
+	}
+	return store.DataStore.Del(key)
+}
+
+func (store *faultyDataStore) Ping() error {
+	if _, err := store.injector.inject(FaultOperationDataStore); err != nil {
+		return err
+	}
+	return pingStore(store.DataStore)
+}
+
+func (store *faultyDataStore) Close() error {
+	return closeFallbackStores(store.DataStore)
+}
+
+// Unwrap returns the store the faults are injected in
+func (store *faultyDataStore) Unwrap() sdk.DataStore {
+	return store.DataStore
+}
+
+func (store *faultyDataStore) CopyStore() (sdk.DataStore, error) {
+	copied, err := store.DataStore.CopyStore()
+	if err != nil {
+		return nil, err
+	}
+	return &faultyDataStore{DataStore: copied, injector: store.injector}, nil
+}
+
+// pingStore pings the stores implementing sdk.Pinger, the others are deemed reachable
+func pingStore(store interface{}) error {
+	if pinger, ok := store.(sdk.Pinger); ok {
+		return pinger.Ping()
+	}
+	return nil
+}
+
+// wrapQueue wraps a queue with the fault injector when enabled
+func (fRuntime *FlowRuntime) wrapQueue(queue rmq.Queue) rmq.Queue {
+	if fRuntime.UnsafeFaultInjector == nil {
+		return queue
+	}
+	return &faultyQueue{Queue: queue, injector: fRuntime.UnsafeFaultInjector}
+}
+
+// wrapDelivery wraps a delivery with the fault injector when enabled
+func (fRuntime *FlowRuntime) wrapDelivery(delivery rmq.Delivery) rmq.Delivery {
+	if fRuntime.UnsafeFaultInjector == nil {
+		return delivery
+	}
+	return &faultyDelivery{Delivery: delivery, injector: fRuntime.UnsafeFaultInjector}
+}
+
+// wrapStores wraps the state store and the data store with the fault injector when enabled
+func (fRuntime *FlowRuntime) wrapStores() {
+	if fRuntime.UnsafeFaultInjector == nil {
+		return
+	}
+	fRuntime.stateStore = &faultyStateStore{StateStore: fRuntime.stateStore, injector: fRuntime.UnsafeFaultInjector}
+	fRuntime.DataStore = &faultyDataStore{DataStore: fRuntime.DataStore, injector: fRuntime.UnsafeFaultInjector}
+}
+
+func faultConfigHandler(runtime *FlowRuntime) func(*gin.Context) {
+	fn := func(c *gin.Context) {
+		body, err := ioutil.ReadAll(c.Request.Body)
+		if err != nil {
+			runtimeCommon.HandleError(c.Writer, fmt.Sprintf("failed to read fault config, %v", err))
+			return
+		}
+		if !runtime.authorizeRequest(c, body, ActionInjectFaults, "", "") {
+			return
+		}
+		if c.Request.Method == http.MethodPost {
+			faults := make(map[string]FaultConfig)
+			if err := json.Unmarshal(body, &faults); err != nil {
+				c.String(http.StatusBadRequest, "invalid fault config, %v", err)
+				return
+			}
+			for operation, config := range faults {
+				runtime.UnsafeFaultInjector.Configure(operation, config)
+			}
+		}
+		c.JSON(http.StatusOK, runtime.UnsafeFaultInjector.Faults())
+	}
+	return fn
+}
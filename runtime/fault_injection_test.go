@@ -0,0 +1,60 @@
+package runtime_test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	flow "github.com/yuyang0/goflow/flow/v1"
+	"github.com/yuyang0/goflow/runtime"
+	goflow "github.com/yuyang0/goflow/v1"
+)
+
+// TestFailedPartialPublishIsRetried checks that a partial request failed to be published by the fault injector is
+// recovered by the retry of the node forwarding it
+func TestFailedPartialPublishIsRetried(t *testing.T) {
+	injector := runtime.NewFaultInjector()
+	injector.Configure(runtime.FaultOperationPublish, runtime.FaultConfig{FailureRate: 1})
+
+	var mu sync.Mutex
+	executed := make(map[string]int)
+	handler := func(wf *flow.Workflow, _ *flow.Context) error {
+		dag := wf.Dag()
+		dag.Node("produce", func(data []byte, _ map[string][]string) ([]byte, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			// the publishes fail until the retry of the node
+			if executed["produce"]++; executed["produce"] > 1 {
+				injector.Configure(runtime.FaultOperationPublish, runtime.FaultConfig{})
+			}
+			return data, nil
+		})
+		dag.Node("consume", func(data []byte, _ map[string][]string) ([]byte, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			executed["consume"]++
+			return data, nil
+		})
+		dag.Edge("produce", "consume")
+		return nil
+	}
+
+	fs := &goflow.FlowService{RetryCount: 3, UnsafeFaultInjector: injector}
+	_, client := startWorker(t, fs, map[string]runtime.FlowDefinitionHandler{"faulty": handler}, nil)
+	if err := client.Execute("faulty", &goflow.Request{Body: []byte("{}")}); err != nil {
+		t.Fatal(err)
+	}
+
+	eventually(t, 15*time.Second, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return executed["consume"] > 0
+	}, "the partial request failed to be published was not recovered")
+	time.Sleep(500 * time.Millisecond)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if executed["produce"] != 2 || executed["consume"] != 1 {
+		t.Fatalf("expected the producer to be executed twice and the consumer once, got %v", executed)
+	}
+}
@@ -0,0 +1,112 @@
+package runtime
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/yuyang0/goflow/metrics"
+)
+
+// FlowArchiveKeyInitial prefixes the sets of the archived versions of the flows
+const FlowArchiveKeyInitial = "goflow-flow-archive"
+
+var archivedFlowsCounter = metrics.NewCounterVec("goflow_archived_flow_definitions_total",
+	"Flow definitions archived when replaced by a new version", "flow")
+
+// ArchiveProvider archives the definitions of the flows replaced by a new version, e.g. for compliance as
+// the definitions kept in redis expire. The version is the hash of the definition
+type ArchiveProvider interface {
+	Archive(flowName string, version string, definition string) error
+}
+
+// ArchivedFlow is the document written by the archive providers
+type ArchivedFlow struct {
+	FlowName   string    `json:"flow_name"`
+	Version    string    `json:"version"`
+	Definition string    `json:"definition"`
+	ArchivedAt time.Time `json:"archived_at"`
+}
+
+func flowArchiveKey(flowName string) string {
+	return fmt.Sprintf("%s:%s", FlowArchiveKeyInitial, flowName)
+}
+
+// archiveReplacedFlows archives the definitions stored in redis that the new definitions of the flows replace.
+// A version is only archived once across the workers, it is written to the ArchiveProvider in the background
+func (fRuntime *FlowRuntime) archiveReplacedFlows(ctx context.Context, flows map[string]string) {
+	if fRuntime.ArchiveProvider == nil {
+		return
+	}
+	rdb := fRuntime.redisClient()
+	for flowName, definition := range flows {
+		stored, err := rdb.Get(ctx, fmt.Sprintf("%s:%s", FlowKeyInitial, flowName)).Result()
+		if err == redis.Nil || (err == nil && stored == definition) {
+			continue
+		} else if err != nil {
+			fRuntime.Logger.Log(fmt.Sprintf("[goflow] failed to get definition of flow %s to archive, error %v", flowName, err))
+			continue
+		}
+
+		version := definitionHash(stored)
+		added, err := rdb.SAdd(ctx, flowArchiveKey(flowName), version).Result()
+		if err != nil {
+			fRuntime.Logger.Log(fmt.Sprintf("[goflow] failed to archive definition of flow %s, error %v", flowName, err))
+			continue
+		}
+		if added == 0 {
+			continue
+		}
+		go fRuntime.archiveFlow(flowName, version, stored)
+	}
+}
+
+// archiveFlow writes a replaced version of a flow to the ArchiveProvider
+func (fRuntime *FlowRuntime) archiveFlow(flowName string, version string, definition string) {
+	if err := fRuntime.ArchiveProvider.Archive(flowName, version, definition); err != nil {
+		// released so that the next refresh archives it again
+		fRuntime.redisClient().SRem(context.TODO(), flowArchiveKey(flowName), version)
+		fRuntime.Logger.Log(fmt.Sprintf("[goflow] failed to archive version %s of flow %s, error %v", version, flowName, err))
+		return
+	}
+	archivedFlowsCounter.Inc(flowName)
+	fRuntime.Logger.Log(fmt.Sprintf("[goflow] archived version %s of flow %s", version, flowName))
+}
+
+// FileArchive writes the archived flow definitions as json files in a directory
+type FileArchive struct {
+	Dir string
+}
+
+// FileArchiveProvider returns an ArchiveProvider writing <dir>/<flowName>/<version>.json files
+func FileArchiveProvider(dir string) *FileArchive {
+	return &FileArchive{Dir: dir}
+}
+
+func (archive *FileArchive) Archive(flowName string, version string, definition string) error {
+	data, err := json.Marshal(&ArchivedFlow{FlowName: flowName, Version: version, Definition: definition, ArchivedAt: time.Now()})
+	if err != nil {
+		return fmt.Errorf("failed to marshal archived flow, error %v", err)
+	}
+	// the legacy flow names are not restricted to the characters safe in a path
+	dir := filepath.Join(archive.Dir, strings.ReplaceAll(url.PathEscape(flowName), ".", "%2E"))
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("failed to create archive directory, error %v", err)
+	}
+
+	// written to a temporary file first so that an archive is never left partially written
+	path := filepath.Join(dir, version+".json")
+	if err := os.WriteFile(path+".tmp", data, 0o644); err != nil {
+		return fmt.Errorf("failed to write archived flow, error %v", err)
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return fmt.Errorf("failed to write archived flow, error %v", err)
+	}
+	return nil
+}
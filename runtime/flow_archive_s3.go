@@ -0,0 +1,92 @@
+package runtime
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+const (
+	DefaultS3Region = "us-east-1"
+
+	s3RequestTimeout = 30 * time.Second
+)
+
+// S3Archive writes the archived flow definitions as json objects to a S3 bucket, with the credentials and the
+// region of the AWS config of the environment, e.g. AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_REGION
+type S3Archive struct {
+	Bucket   string
+	Prefix   string
+	Region   string     // region of the AWS config if not set, DefaultS3Region if neither is
+	Endpoint string     // endpoint of a S3 compatible store, e.g. `http://localhost:9000`, the buckets are then addressed by path
+	Client   *s3.Client // created from the AWS config of the environment if nil
+
+	clientOnce sync.Once
+	client     *s3.Client
+	clientErr  error
+}
+
+// S3ArchiveProvider returns an ArchiveProvider writing <prefix>/<flowName>/<version>.json objects to a bucket
+func S3ArchiveProvider(bucket string, prefix string) *S3Archive {
+	return &S3Archive{Bucket: bucket, Prefix: prefix}
+}
+
+// s3Client returns the Client, or the client created from the AWS config of the environment
+func (archive *S3Archive) s3Client(ctx context.Context) (*s3.Client, error) {
+	if archive.Client != nil {
+		return archive.Client, nil
+	}
+	archive.clientOnce.Do(func() {
+		options := []func(*config.LoadOptions) error{}
+		if archive.Region != "" {
+			options = append(options, config.WithRegion(archive.Region))
+		}
+		cfg, err := config.LoadDefaultConfig(ctx, options...)
+		if err != nil {
+			archive.clientErr = fmt.Errorf("failed to load aws config, error %v", err)
+			return
+		}
+		if cfg.Region == "" {
+			cfg.Region = DefaultS3Region
+		}
+		archive.client = s3.NewFromConfig(cfg, func(o *s3.Options) {
+			if archive.Endpoint != "" {
+				o.BaseEndpoint = aws.String(archive.Endpoint)
+				o.UsePathStyle = true
+			}
+		})
+	})
+	return archive.client, archive.clientErr
+}
+
+func (archive *S3Archive) Archive(flowName string, version string, definition string) error {
+	data, err := json.Marshal(&ArchivedFlow{FlowName: flowName, Version: version, Definition: definition, ArchivedAt: time.Now()})
+	if err != nil {
+		return fmt.Errorf("failed to marshal archived flow, error %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), s3RequestTimeout)
+	defer cancel()
+	client, err := archive.s3Client(ctx)
+	if err != nil {
+		return err
+	}
+
+	_, err = client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(archive.Bucket),
+		Key:         aws.String(path.Join(archive.Prefix, flowName, version+".json")),
+		Body:        bytes.NewReader(data),
+		ContentType: aws.String("application/json"),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to put archive to bucket %s, error %v", archive.Bucket, err)
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package runtime_test
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/alphadose/haxmap"
+	"github.com/yuyang0/goflow/runtime"
+	"github.com/yuyang0/goflow/types"
+)
+
+// TestRefreshFlowDefinitionArchivesReplacedVersion checks that refreshing a flow archives the definition it replaces
+func TestRefreshFlowDefinitionArchivesReplacedVersion(t *testing.T) {
+	mr := miniredis.RunT(t)
+	dir := t.TempDir()
+	fRuntime := &runtime.FlowRuntime{
+		Flows:           haxmap.New[string, runtime.FlowDefinitionHandler](),
+		RedisCfg:        types.RedisConfig{Addr: mr.Addr()},
+		ArchiveProvider: runtime.FileArchiveProvider(dir),
+	}
+	if err := fRuntime.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if err := fRuntime.Register(map[string]runtime.FlowDefinitionHandler{"archived": echoFlow}); err != nil {
+		t.Fatal(err)
+	}
+	mr.Set(runtime.FlowKeyInitial+":archived", "previous definition")
+
+	if err := fRuntime.RefreshFlowDefinition(context.Background(), "archived"); err != nil {
+		t.Fatal(err)
+	}
+
+	var files []string
+	eventually(t, 5*time.Second, func() bool {
+		files, _ = filepath.Glob(filepath.Join(dir, "archived", "*.json"))
+		return len(files) > 0
+	}, "the replaced definition was not archived")
+	data, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var archived runtime.ArchivedFlow
+	if err := json.Unmarshal(data, &archived); err != nil {
+		t.Fatal(err)
+	}
+	if archived.FlowName != "archived" || archived.Definition != "previous definition" {
+		t.Fatalf("expected the previous definition to be archived, got %+v", archived)
+	}
+}
@@ -0,0 +1,156 @@
+package runtime
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"github.com/yuyang0/goflow/dag"
+	runtimeCommon "github.com/yuyang0/goflow/runtime/common"
+)
+
+const (
+	FlowDependenciesKeyInitial = "goflow-flow-dependencies"
+)
+
+// ExternalDependency is an external endpoint invoked by a flow
+type ExternalDependency struct {
+	Name     string `json:"name"`
+	URL      string `json:"url"`
+	Protocol string `json:"protocol"`           // e.g. http, https or grpc
+	Optional bool   `json:"optional,omitempty"` // the flow still completes when the endpoint is unavailable
+}
+
+// FlowDetails is the definition of a flow along with its external dependencies
+type FlowDetails struct {
+	Name         string               `json:"name"`
+	Definition   json.RawMessage      `json:"definition"`
+	Dependencies []ExternalDependency `json:"dependencies"`
+}
+
+func validateDependencies(dependencies []ExternalDependency) error {
+	for _, dependency := range dependencies {
+		if dependency.Name == "" {
+			return fmt.Errorf("external dependency name must be provided")
+		}
+		if _, err := url.Parse(dependency.URL); err != nil || dependency.URL == "" {
+			return fmt.Errorf("invalid url %q of external dependency %s", dependency.URL, dependency.Name)
+		}
+	}
+	return nil
+}
+
+func flowDependenciesKey(flowName string) string {
+	return fmt.Sprintf("%s:%s", FlowDependenciesKeyInitial, flowName)
+}
+
+// saveFlowDependencies stores the external dependencies of the local flows next to their definition
+func (fRuntime *FlowRuntime) saveFlowDependencies(flowNames []string) error {
+	ctx := context.TODO()
+	pipe := fRuntime.redisClient().Pipeline()
+	for _, flowName := range flowNames {
+		options, _ := fRuntime.getFlowOptions(flowName)
+		data, err := json.Marshal(options.ExternalDependencies)
+		if err != nil {
+			return fmt.Errorf("failed to marshal dependencies of flow %s, error %v", flowName, err)
+		}
+		pipe.Set(ctx, flowDependenciesKey(flowName), data, time.Second*RDBKeyTimeOut)
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// GetFlowDependencies returns the external dependencies of a flow, the flow may be registered on any worker
+func (fRuntime *FlowRuntime) GetFlowDependencies(flowName string) ([]ExternalDependency, error) {
+	flowName, err := fRuntime.resolveFlowName(flowName)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := fRuntime.Flows.Get(flowName); ok {
+		options, _ := fRuntime.getFlowOptions(flowName)
+		return append([]ExternalDependency{}, options.ExternalDependencies...), nil
+	}
+
+	data, err := fRuntime.redisClient().Get(context.TODO(), flowDependenciesKey(flowName)).Result()
+	if err == redis.Nil {
+		return nil, fmt.Errorf("flow %s is not registered", flowName)
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to get dependencies of flow %s, error %v", flowName, err)
+	}
+	dependencies := []ExternalDependency{}
+	if err := json.Unmarshal([]byte(data), &dependencies); err != nil {
+		return nil, fmt.Errorf("failed to parse dependencies of flow %s, error %v", flowName, err)
+	}
+	return dependencies, nil
+}
+
+// GetFlowDetails returns the definition and the external dependencies of a flow
+func (fRuntime *FlowRuntime) GetFlowDetails(flowName string) (*FlowDetails, error) {
+	flowName, err := fRuntime.resolveFlowName(flowName)
+	if err != nil {
+		return nil, err
+	}
+	dependencies, err := fRuntime.GetFlowDependencies(flowName)
+	if err != nil {
+		return nil, err
+	}
+
+	definition, err := fRuntime.flowDefinition(flowName)
+	if err != nil {
+		return nil, err
+	}
+	if dependencies == nil {
+		dependencies = []ExternalDependency{}
+	}
+	return &FlowDetails{Name: flowName, Definition: json.RawMessage(definition), Dependencies: dependencies}, nil
+}
+
+// GetFlowDAG returns the definition of a flow as a typed DAG
+func (fRuntime *FlowRuntime) GetFlowDAG(flowName string) (*dag.DAG, error) {
+	flowName, err := fRuntime.resolveFlowName(flowName)
+	if err != nil {
+		return nil, err
+	}
+	definition, err := fRuntime.flowDefinition(flowName)
+	if err != nil {
+		return nil, err
+	}
+	return dag.Parse([]byte(definition))
+}
+
+// flowDefinition exports the definition of a flow registered on this worker, or gets the definition
+// registered by another worker
+func (fRuntime *FlowRuntime) flowDefinition(flowName string) (string, error) {
+	var definition string
+	var err error
+	if handler, ok := fRuntime.Flows.Get(flowName); ok {
+		definition, err = getFlowDefinition(handler)
+	} else {
+		definition, err = fRuntime.redisClient().Get(context.TODO(), fmt.Sprintf("%s:%s", FlowKeyInitial, flowName)).Result()
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get definition of flow %s, error %v", flowName, err)
+	}
+	return definition, nil
+}
+
+func flowDetailsHandler(runtime *FlowRuntime) func(*gin.Context) {
+	fn := func(c *gin.Context) {
+		flowName, ok := flowNameParam(runtime, c)
+		if !ok {
+			return
+		}
+		details, err := runtime.GetFlowDetails(flowName)
+		if err != nil {
+			runtimeCommon.HandleError(c.Writer, fmt.Sprintf("Failed to get flow, %v", err))
+			return
+		}
+		c.JSON(http.StatusOK, details)
+	}
+	return fn
+}
@@ -0,0 +1,206 @@
+package runtime
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yuyang0/goflow/core/runtime"
+)
+
+const (
+	DiagnosticPassed  = "passed"
+	DiagnosticFailed  = "failed"
+	DiagnosticWarning = "warning" // an optional dependency is unreachable
+	DiagnosticSkipped = "skipped"
+
+	DiagnosticCheckRegistered   = "registered"
+	DiagnosticCheckDAG          = "dag"
+	DiagnosticCheckDependencies = "dependencies"
+	DiagnosticCheckWarmup       = "warmup"
+	DiagnosticCheckConsumers    = "consumers"
+
+	// DiagnoseDialTimeout bounds the connection to each external dependency of the flow
+	DiagnoseDialTimeout = 5 * time.Second
+
+	ActionDiagnose = "diagnose"
+)
+
+// DiagnosticCheck is the outcome of a check of DiagnoseFlow
+type DiagnosticCheck struct {
+	Name     string        `json:"name"`
+	Status   string        `json:"status"`
+	Error    string        `json:"error,omitempty"`
+	Duration time.Duration `json:"duration"`
+}
+
+// DiagnosticReport lists the checks of a flow run by DiagnoseFlow, it is healthy when none failed
+type DiagnosticReport struct {
+	Flow    string             `json:"flow"`
+	Healthy bool               `json:"healthy"`
+	Checks  []*DiagnosticCheck `json:"checks"`
+	Time    time.Time          `json:"time"`
+}
+
+// DiagnoseFlow runs a self-test of a flow registered on the worker, before routing traffic to it. It checks that
+// the flow is registered, that its DAG is valid, that its external dependencies accept connections, that a warmup
+// request with its ColdStartWarmupBody succeeds when set, and that its queue has consumers. The checks following a
+// failed registration or DAG are skipped
+func (fRuntime *FlowRuntime) DiagnoseFlow(ctx context.Context, flowName string) (*DiagnosticReport, error) {
+	flowName, err := fRuntime.resolveFlowName(flowName)
+	if err != nil {
+		return nil, err
+	}
+	report := &DiagnosticReport{Flow: flowName, Healthy: true, Time: fRuntime.clock().Now()}
+	check := func(name string, run func() (string, error)) bool {
+		start := fRuntime.clock().Now()
+		status, err := run()
+		result := &DiagnosticCheck{Name: name, Status: status, Duration: fRuntime.clock().Now().Sub(start)}
+		if err != nil {
+			result.Error = err.Error()
+		}
+		if status == DiagnosticFailed {
+			report.Healthy = false
+		}
+		report.Checks = append(report.Checks, result)
+		return status != DiagnosticFailed
+	}
+	skip := func(names ...string) {
+		for _, name := range names {
+			report.Checks = append(report.Checks, &DiagnosticCheck{Name: name, Status: DiagnosticSkipped})
+		}
+	}
+
+	handler, registered := fRuntime.Flows.Get(flowName)
+	ok := check(DiagnosticCheckRegistered, func() (string, error) {
+		if !registered {
+			return DiagnosticFailed, fmt.Errorf("flow %s is not registered on this worker", flowName)
+		}
+		return DiagnosticPassed, nil
+	})
+	if ok {
+		ok = check(DiagnosticCheckDAG, func() (string, error) {
+			if _, err := getFlowDefinition(handler); err != nil {
+				return DiagnosticFailed, fmt.Errorf("invalid dag, %v", err)
+			}
+			return DiagnosticPassed, nil
+		})
+	} else {
+		skip(DiagnosticCheckDAG)
+	}
+	if !ok {
+		skip(DiagnosticCheckDependencies, DiagnosticCheckWarmup, DiagnosticCheckConsumers)
+		return report, nil
+	}
+
+	options, _ := fRuntime.getFlowOptions(flowName)
+	check(DiagnosticCheckDependencies, func() (string, error) {
+		return diagnoseDependencies(ctx, options.ExternalDependencies)
+	})
+	if options.ColdStartWarmupBody != nil {
+		check(DiagnosticCheckWarmup, func() (string, error) {
+			request := &runtime.Request{
+				Body:      options.ColdStartWarmupBody,
+				Header:    make(map[string][]string),
+				RequestID: WarmupRequestPrefix + getNewId(),
+				Query:     make(map[string][]string),
+			}
+			if _, err := fRuntime.ExecuteSync(flowName, request); err != nil {
+				return DiagnosticFailed, fmt.Errorf("warmup request %s failed, %v", request.RequestID, err)
+			}
+			return DiagnosticPassed, nil
+		})
+	} else {
+		skip(DiagnosticCheckWarmup)
+	}
+	check(DiagnosticCheckConsumers, func() (string, error) {
+		depths, err := fRuntime.GetQueueDepths(flowName)
+		if err != nil {
+			return DiagnosticFailed, err
+		}
+		if depths[fRuntime.internalRequestQueueId(flowName)].Consumers == 0 {
+			return DiagnosticFailed, fmt.Errorf("no worker consumes the queue of flow %s", flowName)
+		}
+		return DiagnosticPassed, nil
+	})
+	return report, nil
+}
+
+// diagnoseDependencies opens a connection to each external dependency, the check fails when a required one
+// is unreachable and warns when only optional ones are
+func diagnoseDependencies(ctx context.Context, dependencies []ExternalDependency) (string, error) {
+	status := DiagnosticPassed
+	var errs []string
+	dialer := &net.Dialer{Timeout: DiagnoseDialTimeout}
+	for _, dependency := range dependencies {
+		address, err := dependencyAddress(dependency)
+		if err == nil {
+			var conn net.Conn
+			if conn, err = dialer.DialContext(ctx, "tcp", address); err == nil {
+				conn.Close()
+				continue
+			}
+		}
+		errs = append(errs, fmt.Sprintf("dependency %s unreachable, %v", dependency.Name, err))
+		if !dependency.Optional {
+			status = DiagnosticFailed
+		} else if status == DiagnosticPassed {
+			status = DiagnosticWarning
+		}
+	}
+	if len(errs) == 0 {
+		return status, nil
+	}
+	return status, fmt.Errorf("%s", strings.Join(errs, "; "))
+}
+
+// dependencyAddress returns the host and port of an external dependency, the port of its scheme if not set
+func dependencyAddress(dependency ExternalDependency) (string, error) {
+	u, err := url.Parse(dependency.URL)
+	if err != nil {
+		return "", err
+	}
+	if u.Host == "" {
+		return "", fmt.Errorf("no host in url %q", dependency.URL)
+	}
+	if u.Port() != "" {
+		return u.Host, nil
+	}
+	switch u.Scheme {
+	case "http":
+		return net.JoinHostPort(u.Hostname(), "80"), nil
+	case "https", "grpcs":
+		return net.JoinHostPort(u.Hostname(), "443"), nil
+	}
+	return "", fmt.Errorf("no port in url %q", dependency.URL)
+}
+
+// diagnoseFlowHandler runs the self-test of a flow, it responds with 503 when a check failed. The self-test may
+// execute a warmup request, so it is authorized as the diagnose action
+func diagnoseFlowHandler(runtime *FlowRuntime) func(*gin.Context) {
+	fn := func(c *gin.Context) {
+		flowName, ok := flowNameParam(runtime, c)
+		if !ok {
+			return
+		}
+		if !runtime.authorizeRequest(c, nil, ActionDiagnose, flowName, "") {
+			return
+		}
+		report, err := runtime.DiagnoseFlow(c.Request.Context(), flowName)
+		if err != nil {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+		status := http.StatusOK
+		if !report.Healthy {
+			status = http.StatusServiceUnavailable
+		}
+		c.JSON(status, report)
+	}
+	return fn
+}
@@ -2,10 +2,12 @@ package runtime
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"net/http"
+	"time"
 
 	"github.com/yuyang0/goflow/core/runtime"
 	"github.com/yuyang0/goflow/core/sdk"
@@ -19,12 +21,15 @@ type FlowExecutor struct {
 	flowName                string // the name of the function
 	reqID                   string // the request id
 	CallbackURL             string // the callback url
+	partitionKey            string // the partition key of the request
 	RequestAuthSharedSecret string
 	RequestAuthEnabled      bool
 	EnableMonitoring        bool
 	IsLoggingEnabled        bool
 	partialState            []byte
 	rawRequest              *executor.RawRequest
+	deadline                time.Time       // zero if the request has none
+	ctx                     context.Context // the context of the nodes, done at the deadline
 	StateStore              sdk.StateStore
 	DataStore               sdk.DataStore
 	EventHandler            sdk.EventHandler
@@ -44,12 +49,18 @@ func (fe *FlowExecutor) HandleNextNode(partial *executor.PartialState) error {
 	}
 	request.RequestID = fe.reqID
 	request.FlowName = fe.flowName
+	request.PartitionKey = fe.partitionKey
+	// the continuations carry the deadline of the request, so that it is checked without reading it back
+	request.Deadline = fe.deadline
 	request.Header = make(map[string][]string)
 	if fe.MonitoringEnabled() {
 		// TODO: Fix issue
 		//faasHandler := fe.EventHandler.(*eventhandler.GoFlowEventHandler)
 		//faasHandler.Tracer.ExtendReqSpan(fe.reqID, faasHandler.CurrentNodeID, "", request)
 	}
+	if IsWarmupRequest(fe.reqID) {
+		return fe.Runtime.executeWarmupContinuation(request)
+	}
 	err = fe.Runtime.EnqueuePartialRequest(request)
 	if err != nil {
 		return fmt.Errorf("failed to enqueue request, error %v", err)
@@ -66,6 +77,14 @@ func (fe *FlowExecutor) GetExecutionOption(_ sdk.Operation) map[string]interface
 }
 
 func (fe *FlowExecutor) HandleExecutionCompletion(data []byte) error {
+	if IsWarmupRequest(fe.reqID) {
+		// the result of a warmup is neither published nor sent to a callback
+		return nil
+	}
+	if err := fe.Runtime.publishResult(fe.flowName, fe.reqID, data); err != nil {
+		fe.Runtime.Logger.Log(fmt.Sprintf("[request `%s`] failed to publish result, error %v", fe.reqID, err))
+	}
+
 	if fe.CallbackURL == "" {
 		return nil
 	}
@@ -128,10 +147,13 @@ func (fe *FlowExecutor) GetEventHandler() (sdk.EventHandler, error) {
 }
 
 func (fe *FlowExecutor) LoggingEnabled() bool {
-	return fe.IsLoggingEnabled
+	return fe.IsLoggingEnabled || fe.Runtime.RequestLogsEnabled
 }
 
 func (fe *FlowExecutor) GetLogger() (sdk.Logger, error) {
+	if fe.Runtime.RequestLogsEnabled {
+		return &requestLogger{Logger: fe.Logger, runtime: fe.Runtime}, nil
+	}
 	return fe.Logger, nil
 }
 
@@ -143,14 +165,29 @@ func (fe *FlowExecutor) GetDataStore() (sdk.DataStore, error) {
 	return fe.DataStore, nil
 }
 
+// FlatDataStore checks if the nodes of the flow share the DataStore namespace of the request, see FlowOptions
+func (fe *FlowExecutor) FlatDataStore() bool {
+	options, _ := fe.Runtime.getFlowOptions(fe.flowName)
+	return options.FlatDataStore
+}
+
+// CancelableBranches checks if the branches of the foreach nodes of the flow can be canceled, see FlowOptions
+func (fe *FlowExecutor) CancelableBranches() bool {
+	options, _ := fe.Runtime.getFlowOptions(fe.flowName)
+	return options.CancelableBranches
+}
+
 func (fe *FlowExecutor) Init(request *runtime.Request) error {
 	fe.flowName = request.FlowName
+	fe.partitionKey = request.PartitionKey
+	fe.deadline = request.Deadline
 
 	callbackURL := request.GetHeader("X-Faas-Flow-Callback-Url")
 	fe.CallbackURL = callbackURL
 
-	faasHandler := fe.EventHandler.(*eventhandler.GoFlowEventHandler)
-	faasHandler.Header = request.Header
+	if carrier, ok := fe.EventHandler.(eventhandler.HeaderCarrier); ok {
+		carrier.SetHeader(request.Header)
+	}
 
 	return nil
 }
@@ -0,0 +1,53 @@
+package runtime
+
+import (
+	"fmt"
+
+	"github.com/yuyang0/goflow/core/sdk/executor"
+	"github.com/yuyang0/goflow/metrics"
+)
+
+var migratedStatesCounter = metrics.NewCounterVec("goflow_migrated_partial_states_total",
+	"Partial states of in-flight requests migrated to a new version of the flow", "flow")
+
+// FlowVersion returns the version of the flow, the hash of its definition the archived versions are named after
+func (fe *FlowExecutor) FlowVersion() string {
+	fe.Runtime.flowDefinitionVersionsMu.RLock()
+	defer fe.Runtime.flowDefinitionVersionsMu.RUnlock()
+	return fe.Runtime.flowDefinitionVersions[fe.flowName]
+}
+
+// setFlowDefinitionVersion computes the version of a flow being registered once, rather than exporting its
+// definition on every execution. The version is empty when the definition can't be exported
+func (fRuntime *FlowRuntime) setFlowDefinitionVersion(flowName string, handler FlowDefinitionHandler) {
+	version := ""
+	if definition, err := getFlowDefinition(handler); err == nil {
+		version = definitionHash(definition)
+	}
+	fRuntime.flowDefinitionVersionsMu.Lock()
+	defer fRuntime.flowDefinitionVersionsMu.Unlock()
+	if fRuntime.flowDefinitionVersions == nil {
+		fRuntime.flowDefinitionVersions = make(map[string]string)
+	}
+	fRuntime.flowDefinitionVersions[flowName] = version
+}
+
+// MigrateState migrates a partial state produced by another version of the flow with the Migrate of its options
+func (fe *FlowExecutor) MigrateState(requestId string, state *executor.MigrationState,
+	oldVersion string) (*executor.MigrationState, error) {
+	options, _ := fe.Runtime.getFlowOptions(fe.flowName)
+	if options.Migrate == nil {
+		return state, nil
+	}
+	migrated, err := options.Migrate(state, oldVersion)
+	if err != nil {
+		return nil, err
+	}
+	if migrated == nil {
+		return nil, fmt.Errorf("migrator of flow %s returned no state", fe.flowName)
+	}
+	migratedStatesCounter.Inc(fe.flowName)
+	fe.Runtime.Logger.Log(fmt.Sprintf("[request `%s`] partial state migrated from version %s of flow %s",
+		requestId, oldVersion, fe.flowName))
+	return migrated, nil
+}
@@ -0,0 +1,67 @@
+package runtime
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	MaxFlowNameLength = 128
+)
+
+var flowNamePattern = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+// InvalidFlowNameError is returned when a flow name can't be embedded in queue ids and keys
+type InvalidFlowNameError struct {
+	FlowName string
+	Reason   string
+}
+
+func (err *InvalidFlowNameError) Error() string {
+	return fmt.Sprintf("invalid flow name %q, %s", err.FlowName, err.Reason)
+}
+
+// ValidateFlowName checks that a flow name is non-empty, at most MaxFlowNameLength long
+// and only made of [a-zA-Z0-9_-]
+func ValidateFlowName(flowName string) error {
+	if flowName == "" {
+		return &InvalidFlowNameError{FlowName: flowName, Reason: "must not be empty"}
+	}
+	if len(flowName) > MaxFlowNameLength {
+		return &InvalidFlowNameError{FlowName: flowName, Reason: fmt.Sprintf("must be at most %d characters", MaxFlowNameLength)}
+	}
+	if !flowNamePattern.MatchString(flowName) {
+		return &InvalidFlowNameError{FlowName: flowName, Reason: "must only contain [a-zA-Z0-9_-]"}
+	}
+	return nil
+}
+
+// resolveFlowName normalizes a flow name when NormalizeFlowNames is set and validates it,
+// unless AllowLegacyFlowNames is set for deployments with existing noncompliant names
+func (fRuntime *FlowRuntime) resolveFlowName(flowName string) (string, error) {
+	if fRuntime.NormalizeFlowNames {
+		flowName = strings.ToLower(flowName)
+	}
+	if fRuntime.AllowLegacyFlowNames {
+		return flowName, nil
+	}
+	return flowName, ValidateFlowName(flowName)
+}
+
+// flowNameParam returns the resolved flow name of the route, it writes a bad request response if invalid
+func flowNameParam(runtime *FlowRuntime, c *gin.Context) (string, bool) {
+	flowName, err := url.PathUnescape(c.Param(FlowNameParamName))
+	if err == nil {
+		flowName, err = runtime.resolveFlowName(flowName)
+	}
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return "", false
+	}
+	return flowName, true
+}
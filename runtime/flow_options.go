@@ -0,0 +1,230 @@
+package runtime
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/yuyang0/goflow/core/runtime"
+	"github.com/yuyang0/goflow/core/sdk/executor"
+)
+
+// FlowOptions holds the optional per flow configuration
+type FlowOptions struct {
+	InputSchema json.RawMessage `json:",omitempty"` // JSON schema of the request body, used for documentation
+	MaxDuration time.Duration   // maximum duration of a request, bounded by the global timeout
+	// ExecutionBudget is the maximum cumulative execution time of a request across all its nodes and retries,
+	// the execution time of the requests is only tracked when set
+	ExecutionBudget time.Duration
+	// StickyRoutingKey returns the key of a request, the requests sharing a key are routed to the same
+	// worker while it is available, e.g. to benefit from its local caches. Requests with an empty key
+	// or a PartitionKey are not routed
+	StickyRoutingKey func(req *runtime.Request) string `json:"-"`
+	// Validate checks a request submitted over http before it is queued, the requests it returns an error for
+	// are rejected with 400 and the message of the error. It runs along the JSON validation of the body, for
+	// the cross-field and business rules a schema can't express
+	Validate func(req *runtime.Request) error `json:"-"`
+	// ExternalDependencies are the external endpoints invoked by the flow, e.g. to generate network policies
+	ExternalDependencies []ExternalDependency
+	// ErrorBudgetRules alert when the failure rate of the flow over a sliding window exceeds a threshold
+	ErrorBudgetRules []ErrorBudgetRule
+	// RequireJSONBody rejects the requests which body is not valid UTF-8 json before they are queued
+	RequireJSONBody bool
+	// SkipJSONValidation accepts any request body for the flow, even when the runtime RequireJSONBody is set
+	SkipJSONValidation bool
+	// HistorySize is the number of the latest requests that reached a terminal state kept for the flow
+	HistorySize int
+	// AMQPOutputExchange and AMQPOutputRoutingKey publish the result of every completed request to an exchange
+	// of the AMQP broker of the runtime, the default exchange routes it to the queue named by the routing key
+	AMQPOutputExchange   string
+	AMQPOutputRoutingKey string
+	// MaxNodeOutputBytes is the limit of a value written to the DataStore by a node, DefaultMaxNodeOutputBytes
+	// if not set and no limit if negative. The larger values fail the node with ErrOutputTooLarge, unless the
+	// runtime has a LargeOutputStore
+	MaxNodeOutputBytes int64
+	// FlatDataStore keeps the DataStore namespace of the flows written before the nodes had scoped views. The
+	// nodes then share the keys of the request through NodeContext.Store, and the foreach branches are
+	// aggregated on the keys of the request. It must be set while requests started with it are in flight
+	FlatDataStore bool
+	// CancelableBranches tracks the executions of the foreach nodes of the flow and the arrivals of their branches,
+	// so that the branches can be canceled with CancelBranches. It adds a StateStore write per foreach execution
+	// and per branch, and a read of the state of the branch per node of a branch
+	CancelableBranches bool
+	// ColdStartWarmupEnabled executes a request of the flow with the ColdStartWarmupBody on registration, before
+	// the worker consumes its queues when it is registered before entering worker mode, so that the nodes
+	// initializing lazily are ready for the first real request. Its id is prefixed with WarmupRequestPrefix, all its
+	// nodes run on the registering worker and it is left out of the metrics, see IsWarmupRequest
+	ColdStartWarmupEnabled bool
+	ColdStartWarmupBody    []byte
+	// Migrate transforms the partial state of an in-flight request produced by another version of the flow, the
+	// hash of its definition, into the state the current definition expects. It runs when a partial or resumed
+	// request reaches a worker with a different version, the states are kept as is without it
+	Migrate func(oldState *executor.MigrationState, oldVersion string) (*executor.MigrationState, error) `json:"-"`
+	// ShouldRetry decides if a failed task of the flow is pushed to the next queue of its retries, attempt being 1
+	// for its first execution. The tasks it declines are dead lettered right away, routed by the category of their
+	// error as the ones failing their last retry. All the failed tasks are retried without it
+	ShouldRetry func(err error, attempt int) bool `json:"-"`
+}
+
+// RegisterWithOptions registers a flow along with its options
+func (fRuntime *FlowRuntime) RegisterWithOptions(flowName string, handler FlowDefinitionHandler, options FlowOptions) error {
+	flowName, err := fRuntime.resolveFlowName(flowName)
+	if err != nil {
+		return err
+	}
+	if err := validateFlowOptions(flowName, options); err != nil {
+		return err
+	}
+	if err := fRuntime.registerFlowOptions(flowName, handler, options); err != nil {
+		return err
+	}
+	if options.ColdStartWarmupEnabled {
+		fRuntime.warmupFlow(flowName, options)
+	}
+	return nil
+}
+
+// registerFlowOptions registers a flow along with its options. It is serialized with the updates of the options,
+// so that the options of a registered flow are never replaced by a duplicate registration
+func (fRuntime *FlowRuntime) registerFlowOptions(flowName string, handler FlowDefinitionHandler, options FlowOptions) error {
+	fRuntime.flowOptionsUpdateMu.Lock()
+	defer fRuntime.flowOptionsUpdateMu.Unlock()
+	if _, ok := fRuntime.Flows.Get(flowName); ok {
+		return fmt.Errorf("flow %s already registered", flowName)
+	}
+
+	fRuntime.flowOptionsMu.Lock()
+	if fRuntime.flowOptions == nil {
+		fRuntime.flowOptions = make(map[string]FlowOptions)
+	}
+	fRuntime.flowOptions[flowName] = options
+	fRuntime.flowOptionsMu.Unlock()
+
+	if err := fRuntime.Register(map[string]FlowDefinitionHandler{flowName: handler}); err != nil {
+		fRuntime.flowOptionsMu.Lock()
+		delete(fRuntime.flowOptions, flowName)
+		fRuntime.flowOptionsMu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// UpdateFlowOptions replaces the options of a registered flow while it is running. The InputSchema
+// can't be changed, an empty InputSchema keeps the current one
+func (fRuntime *FlowRuntime) UpdateFlowOptions(flowName string, options FlowOptions) error {
+	flowName, err := fRuntime.resolveFlowName(flowName)
+	if err != nil {
+		return err
+	}
+	if _, ok := fRuntime.Flows.Get(flowName); !ok {
+		return fmt.Errorf("flow %s is not registered", flowName)
+	}
+	if err := validateFlowOptions(flowName, options); err != nil {
+		return err
+	}
+
+	// updates are serialized so that the options published to redis match the stored ones
+	fRuntime.flowOptionsUpdateMu.Lock()
+	defer fRuntime.flowOptionsUpdateMu.Unlock()
+
+	fRuntime.flowOptionsMu.Lock()
+	current := fRuntime.flowOptions[flowName]
+	if len(options.InputSchema) == 0 {
+		options.InputSchema = current.InputSchema
+	} else if !bytes.Equal(options.InputSchema, current.InputSchema) {
+		fRuntime.flowOptionsMu.Unlock()
+		return fmt.Errorf("input schema of flow %s can't be updated", flowName)
+	}
+	if fRuntime.flowOptions == nil {
+		fRuntime.flowOptions = make(map[string]FlowOptions)
+	}
+	fRuntime.flowOptions[flowName] = options
+	fRuntime.flowOptionsMu.Unlock()
+
+	// the other workers see the new dependencies, error budget rules and json validation without waiting for the
+	// next registration
+	if err := fRuntime.saveFlowDependencies([]string{flowName}); err != nil {
+		return fmt.Errorf("failed to update dependencies of flow %s, %v", flowName, err)
+	}
+	if len(options.ErrorBudgetRules) == 0 {
+		err = fRuntime.redisClient().Del(context.TODO(), errorBudgetRulesKey(flowName)).Err()
+	} else {
+		err = fRuntime.saveErrorBudgetRules([]string{flowName})
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update error budget rules of flow %s, %v", flowName, err)
+	}
+	if jsonBodyValidation(options) == "" {
+		err = fRuntime.redisClient().Del(context.TODO(), jsonBodyKey(flowName)).Err()
+	} else {
+		err = fRuntime.saveJSONBodyValidations([]string{flowName})
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update json validation of flow %s, %v", flowName, err)
+	}
+	fRuntime.Logger.Log(fmt.Sprintf("[goflow] options of flow %s updated", flowName))
+	return nil
+}
+
+// RefreshFlowDefinition exports the DAG of a registered flow and writes it to redis right away, instead of
+// waiting for the next periodic registration
+func (fRuntime *FlowRuntime) RefreshFlowDefinition(ctx context.Context, flowName string) error {
+	flowName, err := fRuntime.resolveFlowName(flowName)
+	if err != nil {
+		return err
+	}
+	handler, ok := fRuntime.Flows.Get(flowName)
+	if !ok {
+		return fmt.Errorf("flow %s is not registered", flowName)
+	}
+
+	definition, err := getFlowDefinition(handler)
+	if err != nil {
+		return fmt.Errorf("failed to export definition of flow %s, error %v", flowName, err)
+	}
+	fRuntime.archiveReplacedFlows(ctx, map[string]string{flowName: definition})
+	key := fmt.Sprintf("%s:%s", FlowKeyInitial, flowName)
+	if err := fRuntime.redisClient().Set(ctx, key, definition, time.Second*RDBKeyTimeOut).Err(); err != nil {
+		return fmt.Errorf("failed to save definition of flow %s, error %v", flowName, err)
+	}
+	// the documents derived from the definitions are generated again
+	fRuntime.flowsVersion.Add(1)
+	return nil
+}
+
+func validateFlowOptions(flowName string, options FlowOptions) error {
+	if options.RequireJSONBody && options.SkipJSONValidation {
+		return fmt.Errorf("invalid options of flow %s, RequireJSONBody and SkipJSONValidation are exclusive", flowName)
+	}
+	if len(options.InputSchema) > 0 && !json.Valid(options.InputSchema) {
+		return fmt.Errorf("input schema of flow %s is not a valid json", flowName)
+	}
+	if options.HistorySize < 0 {
+		return fmt.Errorf("invalid options of flow %s, HistorySize must not be negative", flowName)
+	}
+	if err := validateDependencies(options.ExternalDependencies); err != nil {
+		return fmt.Errorf("invalid options of flow %s, %v", flowName, err)
+	}
+	if err := validateErrorBudgetRules(options.ErrorBudgetRules); err != nil {
+		return fmt.Errorf("invalid options of flow %s, %v", flowName, err)
+	}
+	return nil
+}
+
+// validateRequest checks a request with the Validate of its flow, if set
+func (fRuntime *FlowRuntime) validateRequest(flowName string, request *runtime.Request) error {
+	options, _ := fRuntime.getFlowOptions(flowName)
+	if options.Validate == nil {
+		return nil
+	}
+	return options.Validate(request)
+}
+
+func (fRuntime *FlowRuntime) getFlowOptions(flowName string) (FlowOptions, bool) {
+	fRuntime.flowOptionsMu.RLock()
+	defer fRuntime.flowOptionsMu.RUnlock()
+	options, ok := fRuntime.flowOptions[flowName]
+	return options, ok
+}
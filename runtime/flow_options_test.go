@@ -0,0 +1,78 @@
+package runtime_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/alphadose/haxmap"
+	"github.com/yuyang0/goflow/runtime"
+	"github.com/yuyang0/goflow/types"
+	goflow "github.com/yuyang0/goflow/v1"
+)
+
+// TestDuplicateRegistrationKeepsOptions checks that registering a flow already registered fails without replacing
+// the options of the registered flow
+func TestDuplicateRegistrationKeepsOptions(t *testing.T) {
+	mr := miniredis.RunT(t)
+	fRuntime := &runtime.FlowRuntime{
+		Flows:    haxmap.New[string, runtime.FlowDefinitionHandler](),
+		RedisCfg: types.RedisConfig{Addr: mr.Addr()},
+	}
+	if err := fRuntime.Init(); err != nil {
+		t.Fatal(err)
+	}
+	registered := runtime.FlowOptions{InputSchema: []byte(`{"type": "object"}`)}
+	if err := fRuntime.RegisterWithOptions("options", echoFlow, registered); err != nil {
+		t.Fatal(err)
+	}
+
+	duplicate := runtime.FlowOptions{InputSchema: []byte(`{"type": "string"}`)}
+	if err := fRuntime.RegisterWithOptions("options", echoFlow, duplicate); err == nil {
+		t.Fatal("expected the duplicate registration to fail")
+	}
+	// the input schema can't be updated, the update only succeeds with the schema of the registered flow
+	if err := fRuntime.UpdateFlowOptions("options", registered); err != nil {
+		t.Fatalf("expected the options of the registered flow to be kept, got %v", err)
+	}
+}
+
+// TestClientEnforcesRequireJSONBody checks that a client rejects the invalid json bodies of a flow which workers
+// require json bodies
+func TestClientEnforcesRequireJSONBody(t *testing.T) {
+	fs := &goflow.FlowService{}
+	options := map[string]runtime.FlowOptions{"strict": {RequireJSONBody: true}}
+	_, client := startWorker(t, fs, map[string]runtime.FlowDefinitionHandler{"strict": echoFlow}, options)
+
+	eventually(t, 10*time.Second, func() bool {
+		err := client.Execute("strict", &goflow.Request{Body: []byte("not json")})
+		return errors.Is(err, runtime.ErrInvalidJSON)
+	}, "expected the client to reject the invalid json body")
+	if err := client.Execute("strict", &goflow.Request{Body: []byte(`{"valid": true}`)}); err != nil {
+		t.Fatalf("expected the json body to be accepted, got %v", err)
+	}
+}
+
+// TestConfigUpdateAppliedByLaterWorker checks that the options pushed while no worker runs are applied by the workers
+// started later
+func TestConfigUpdateAppliedByLaterWorker(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := &goflow.FlowService{RedisCfg: types.RedisConfig{Addr: mr.Addr()}}
+	options := runtime.FlowOptions{RequireJSONBody: true}
+	if err := client.PushConfigUpdate(context.Background(), "pushed", options); err != nil {
+		t.Fatal(err)
+	}
+
+	fs := &goflow.FlowService{RedisCfg: client.RedisCfg, WorkerConcurrency: 2, CleanerInterval: time.Hour}
+	if err := fs.Register("pushed", echoFlow); err != nil {
+		t.Fatal(err)
+	}
+	go fs.StartWorker()
+
+	eventually(t, 10*time.Second, func() bool {
+		err := client.Execute("pushed", &goflow.Request{Body: []byte("not json")})
+		return errors.Is(err, runtime.ErrInvalidJSON)
+	}, "expected the worker to apply the options pushed before it started")
+}
@@ -3,17 +3,17 @@ package runtime
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
-	"reflect"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/adjust/rmq/v5"
 	"github.com/alphadose/haxmap"
-	"github.com/jasonlvhit/gocron"
 	"github.com/redis/go-redis/v9"
 	"github.com/rs/xid"
 	"github.com/yuyang0/goflow/core/runtime"
@@ -23,50 +23,208 @@ import (
 	"github.com/yuyang0/goflow/core/sdk/exporter"
 	"github.com/yuyang0/goflow/eventhandler"
 	log2 "github.com/yuyang0/goflow/log"
+	"github.com/yuyang0/goflow/runtime/clock"
 	"github.com/yuyang0/goflow/types"
 )
 
 type FlowRuntime struct {
 	Flows                   *haxmap.Map[string, FlowDefinitionHandler]
+	Namespace               string
+	NormalizeFlowNames      bool // lowercase the flow names
+	AllowLegacyFlowNames    bool // skip the validation of the flow names for existing deployments
 	OpenTracingUrl          string
 	RedisCfg                types.RedisConfig
+	AMQP                    *types.AMQPConfig // broker the results are published to, see FlowOptions.AMQPOutputExchange
 	stateStore              sdk.StateStore
+	FallbackRedisCfg        *types.RedisConfig // the stores fall back to this redis, e.g. in another region, while RedisCfg is unavailable
+	FallbackWritePolicy     string             // how the stores write with a fallback, FallbackWritePrimary if not set
 	DataStore               sdk.DataStore
+	LargeOutputStore        LargeOutputStore // stores the node outputs exceeding the MaxNodeOutputBytes of their flow
+	DataStoreBucketTemplate string           // bucket layout of the default DataStore, see RedisDataStore
+	Tenant                  string
 	Logger                  sdk.Logger
 	Concurrency             int
+	FailureConcurrency      int // consumers of the failure queue of each flow
+	MaxGoroutinesPerWorker  int
+	SaturationWarnThreshold time.Duration
+	LoadSampleInterval      time.Duration // interval of the readings of the cpu and memory used by the worker
+	LoadThrottle            *LoadThrottle // stop consuming new requests while the worker is overloaded
 	ServerPort              int
+	TLSServerPort           int
 	ReadTimeout             time.Duration
+	MaxRequestTimeout       time.Duration // maximum timeout clients can set with the X-Goflow-Timeout or X-Goflow-Deadline header
+	DropExpiredRequests     bool          // drop the requests exceeding their deadline silently instead of failing them
 	WriteTimeout            time.Duration
+	SyncWriteMode           string        // how the synchronous executions outlive WriteTimeout, extend if not set
+	SyncWriteTimeout        time.Duration // write timeout of the synchronous executions, the request deadline applies if not set
+	SyncHeartbeatInterval   time.Duration
 	RequestAuthSharedSecret string
 	RequestAuthEnabled      bool
+	Authorizer              Authorizer       // authorizes the operations of the http api, allows all if nil
+	JWT                     *JWTConfig       // authenticates the http requests carrying a bearer token
+	DefaultClientRateLimits ClientRateLimits // rate limits of the http requests of each client without override
 	EnableMonitoring        bool
+	EventSinks              []sdk.EventHandler         // receive the lifecycle events of the requests, see sdk.EventHandler
+	KafkaSinks              []eventhandler.KafkaConfig // write the lifecycle events of the requests to kafka topics
+	AdminUIEnabled          bool
 	RetryQueueCount         int
+	MaxFlows                int  // limit of the flows registered on the worker, see the README for the redis resources of a flow
+	PauseConflictingFlows   bool // stop consuming the flows registered with a different definition by another live worker
+	CleanerInterval         time.Duration
+	UnroutableGracePeriod   time.Duration      // requeue period of the tasks of a flow no worker advertises before they are dead lettered
+	DeadLetterRouting       *DeadLetterRouting // dead letter the tasks failing their last retry by error category
+	ArchiveProvider         ArchiveProvider    // archives the flow definitions replaced by a new version
+	MaxContinuations        int
+	DurableTasksEnabled     bool
+	ClaimTTL                time.Duration // how long the idempotency key of a request is claimed while it is processed
+	BodyStoreThreshold      int
+	StoreBinaryBodies       bool          // store the binary request bodies in the DataStore rather than base64 encoded in the task
+	InitTimeout             time.Duration // bound of the connections and checks of Init, DefaultInitTimeout if not set
+	InitRetryDuration       time.Duration // Init is retried with backoff for this long when a subsystem is unreachable
+	RequireJSONBody         bool          // reject the request bodies that are not valid json, unless the flow sets SkipJSONValidation
+	PartitionCount          int
+	StrongConsistency       bool
+	StateStoreRetryCount    int           // retries of a StateStore update that conflicts with a concurrent write
+	StateStoreRetryBackoff  time.Duration // wait before the first retry of a conflicting update, doubled on every retry
+	StoreKeyCountsEnabled   bool          // track the keys of the redis stores of each flow for the goflow_store_keys gauge
+	StoreKeyCountInterval   time.Duration // interval at which the leader counts the tracked keys of the stores
 	DebugEnabled            bool
+	DebugSampleRate         float64       // share of the requests, from 0 to 1, logged in full when DebugEnabled is not set
+	ErrorBudgetInterval     time.Duration // interval at which the error budget rules of the flows are evaluated
+	AlertWebhookURL         string        // called with an ErrorBudgetAlert when an error budget rule trips or recovers, and a PoisonMessage
+	PoisonThreshold         int           // crashes of the consumer by a message before it is routed to the poison queue
+	SlowLogEnabled          bool          // append the slow node executions and store operations to a capped redis list
+	RequestLogsEnabled      bool          // capture the logs of every request in redis, along with the Logger
+	RequestLogMaxEntries    int           // log entries kept for a request, the oldest are dropped
+	RequestLogTTL           time.Duration // how long the logs of a request are kept after its last entry
+	StreamingEnabled        bool          // publish the node outputs of the requests for the stream endpoint
+	RoutingLogsEnabled      bool          // record the queues every request goes through in redis
+	Clock                   clock.Clock   // time source of the schedules, deadlines and watchdogs, the real clock if nil
+	initialized             atomic.Bool   // set once Init succeeds
 	workerMode              atomic.Bool
+	ready                   atomic.Bool // set by Warmup
+	globalTimeout           atomic.Int64
+	slowNodeThreshold       atomic.Int64
+	slowStoreThreshold      atomic.Int64
+
+	// OnQueueError is called when a delivery can not be parsed, pushed or acknowledged,
+	// if nil the error is logged
+	OnQueueError func(operation string, task *Task, err error)
+	// OnErrorBudgetAlert is called when an error budget rule of a flow trips or recovers
+	OnErrorBudgetAlert func(alert *ErrorBudgetAlert)
+	// OnPoisonMessage is called when a message is routed to the poison queue, see PoisonThreshold
+	OnPoisonMessage func(message *PoisonMessage)
+	// UnsafeFaultInjector injects faults into the queues and stores for testing, never set it in production
+	UnsafeFaultInjector *FaultInjector
 
 	eventHandler sdk.EventHandler
 
-	taskQueues    map[string]rmq.Queue
-	srv           *http.Server
-	rdb           *redis.Client
-	rmqConnection rmq.Connection
+	flowOptionsMu       sync.RWMutex
+	flowOptions         map[string]FlowOptions
+	flowOptionsUpdateMu sync.Mutex
+	flowsVersion        atomic.Int64 // incremented on every change of the flow registry
+
+	flowDefinitionVersionsMu sync.RWMutex
+	flowDefinitionVersions   map[string]string // hash of the definition of the flows, computed at registration
+
+	preExecHooksMu sync.RWMutex
+	preExecHooks   []PreExecHook
+
+	bodyDecodersMu sync.RWMutex
+	bodyDecoders   map[string]BodyDecoder // keyed by media type
+
+	jwtVerifier *jwtVerifier
+
+	amqpPublisher *amqpPublisher
+	kafkaSinks    []*eventhandler.KafkaEventSink
+
+	loadSampler loadSampler
+	throttled   atomic.Bool
+
+	inFlight    inFlightRequests
+	usage       usageBuffer
+	interrupts  nodeInterrupts
+	nodeStats   nodeStatsBuffer
+	requestLogs requestLogBuffer
+
+	clientRateLimitsMu sync.RWMutex
+	clientRateLimits   map[string]ClientRateLimits // overrides by client
+
+	samplingRatesMu sync.RWMutex
+	samplingRates   map[string]float64 // by flow
+
+	conflictMu sync.Mutex
+	conflicts  map[string][]string // workers registering a flow with a different definition
+
+	stickyRingMu      sync.Mutex
+	stickyRing        map[string]*hashRing // hash ring of the workers of each flow
+	stickyRingUpdated time.Time
+
+	openAPIMu         sync.Mutex
+	openAPIDoc        []byte
+	openAPIDocVersion int64
+
+	executionSlotsOnce sync.Once
+	executionSlots     chan struct{} // bounds the tasks handled concurrently by the worker
+
+	workerIDOnce sync.Once
+	workerID     string
+
+	started       atomic.Bool // set by StartRuntime, a runtime is only started once
+	lifecycleOnce sync.Once
+	lifecycleCtx  context.Context // done once the runtime is shut down, see lifecycle
+	stopLifecycle context.CancelFunc
+
+	queueMu         sync.Mutex // guards taskQueues and consumer registration
+	taskQueues      map[string]rmq.Queue
+	pushQueues      map[string][]rmq.Queue
+	partitionQueues map[string][]rmq.Queue
+	failureQueues   map[string]rmq.Queue
+	workerQueue     rmq.Queue
+	srv             *http.Server
+	tlsSrv          *http.Server
+	rdbOnce         sync.Once
+	rdb             *redis.Client
+	rmqConnection   rmq.Connection
+
+	// the partition queues consumed by the worker by flow, nil for the partitions leased by other workers
+	partitionConsumers map[string][]rmq.Queue
 }
 
 type Worker struct {
 	mu          sync.Mutex
-	ID          string   `json:"id"`
-	Flows       []string `json:"flows"`
-	Concurrency int      `json:"concurrency"`
+	ID          string            `json:"id"`
+	Flows       []string          `json:"flows"`
+	FlowHashes  map[string]string `json:"flow_hashes,omitempty"` // hash of the definition of each flow
+	Concurrency int               `json:"concurrency"`
+	CPUPercent  float64           `json:"cpu_percent"` // see LoadSample
+	RSSBytes    uint64            `json:"rss_bytes"`
+	Throttled   bool              `json:"throttled,omitempty"`
+	Version     string            `json:"version,omitempty"` // goflow version of the worker, see GetBuildInfo
+	Commit      string            `json:"commit,omitempty"`
 }
 
 type Task struct {
-	FlowName    string              `json:"flow_name"`
-	RequestID   string              `json:"request_id"`
-	Body        string              `json:"body"`
-	Header      map[string][]string `json:"header"`
-	RawQuery    string              `json:"raw_query"`
-	Query       map[string][]string `json:"query"`
-	RequestType string              `json:"request_type"`
+	FlowName     string              `json:"flow_name"`
+	RequestID    string              `json:"request_id"`
+	Body         TaskBody            `json:"body"`
+	Header       map[string][]string `json:"header"`
+	RawQuery     string              `json:"raw_query"`
+	Query        map[string][]string `json:"query"`
+	RequestType  RequestType         `json:"request_type"`
+	BodyRef      string              `json:"body_ref,omitempty"`
+	BodyEncoding string              `json:"body_encoding,omitempty"` // set while the task is queued, see Task.MarshalJSON
+	PartitionKey string              `json:"partition_key,omitempty"`
+
+	Deadline         int64   `json:"deadline,omitempty"`     // unix nano time set by the client
+	SubmittedAt      int64   `json:"submitted_at,omitempty"` // unix nano time the task was queued
+	ExecutionSeconds float64 `json:"execution_seconds,omitempty"`
+	FailureCategory  string  `json:"failure_category,omitempty"`
+	UnroutableSince  int64   `json:"unroutable_since,omitempty"` // unix nano time the flow was first found unregistered
+	Attempt          int     `json:"attempt,omitempty"`          // failed attempts of the task, see pushRetry
+	Requeued         bool    `json:"requeued,omitempty"`         // set on a queued task handed over to the scheduler
+
+	Annotations []*executor.Annotation `json:"annotations,omitempty"` // set on the archived task, see AnnotateRequest
 }
 
 const (
@@ -77,62 +235,147 @@ const (
 	GoFlowRegisterInterval = 4
 	RDBKeyTimeOut          = 10
 
-	PartialRequest = "PARTIAL"
-	NewRequest     = "NEW"
-	PauseRequest   = "PAUSE"
-	ResumeRequest  = "RESUME"
-	StopRequest    = "STOP"
+	// WorkerHeartbeatMaxAge is the age of the registration of a worker past which it is not counted as healthy
+	WorkerHeartbeatMaxAge = 2 * GoFlowRegisterInterval * time.Second
+
+	ContinuationCountKey = "continuation-count"
+	RequestBodyKey       = "goflow-request-body"
+
+	QueueOperationParse = "parse"
+	QueueOperationPush  = "push"
+	QueueOperationAck   = "ack"
 )
 
+// ErrMaxContinuations fails the requests exceeding the MaxContinuations of the runtime
+var ErrMaxContinuations = errors.New("exceeded the maximum of continuations")
+
 func (fRuntime *FlowRuntime) Init() error {
 	var err error
 
-	fRuntime.rdb = fRuntime.RedisCfg.NewRedisClient()
+	if fRuntime.JWT != nil {
+		if fRuntime.RequestAuthEnabled {
+			return fmt.Errorf("invalid auth config, JWT and RequestAuthEnabled are exclusive")
+		}
+		fRuntime.jwtVerifier, err = newJWTVerifier(fRuntime.JWT, fRuntime.clock())
+		if err != nil {
+			return err
+		}
+	}
 
-	fRuntime.stateStore, err = initStateStore(&fRuntime.RedisCfg)
-	if err != nil {
-		return fmt.Errorf("failed to initialize the StateStore, %v", err)
+	switch fRuntime.SyncWriteMode {
+	case "", SyncWriteModeExtend, SyncWriteModeHeartbeat:
+	default:
+		return fmt.Errorf("invalid sync write mode %s", fRuntime.SyncWriteMode)
 	}
 
-	if fRuntime.DataStore == nil {
-		fRuntime.DataStore, err = initDataStore(&fRuntime.RedisCfg)
-		if err != nil {
-			return fmt.Errorf("failed to initialize the StateStore, %v", err)
+	if fRuntime.LoadThrottle != nil {
+		if err := validateLoadThrottle(fRuntime.LoadThrottle); err != nil {
+			return err
 		}
 	}
 
-	fRuntime.rmqConnection, err = OpenConnectionV2("goflow", &fRuntime.RedisCfg, nil)
-	if err != nil {
-		return fmt.Errorf("failed to initiate rmq connection, error %v", err)
+	if fRuntime.DeadLetterRouting != nil {
+		if err := validateDeadLetterRouting(fRuntime.DeadLetterRouting); err != nil {
+			return err
+		}
 	}
 
 	if fRuntime.Logger == nil {
 		fRuntime.Logger = &log2.StdErrLogger{}
 	}
 
+	if fRuntime.AMQP != nil {
+		fRuntime.amqpPublisher = newAMQPPublisher(fRuntime.AMQP, fRuntime.Logger)
+	}
+
+	kafkaSinks := make([]*eventhandler.KafkaEventSink, 0, len(fRuntime.KafkaSinks))
+	closeKafkaSinks := func() {
+		for _, sink := range kafkaSinks {
+			sink.Close()
+		}
+	}
+	for _, config := range fRuntime.KafkaSinks {
+		sink, err := eventhandler.NewKafkaEventSink(config)
+		if err != nil {
+			closeKafkaSinks()
+			return fmt.Errorf("failed to initialize the runtime, %v", err)
+		}
+		kafkaSinks = append(kafkaSinks, sink)
+	}
+
+	// the runtime is only set up once all its connections are checked, nothing is left half initialized
+	conns, err := fRuntime.connect()
+	if err != nil {
+		closeKafkaSinks()
+		return fmt.Errorf("failed to initialize the runtime, %w", err)
+	}
+	fRuntime.kafkaSinks = kafkaSinks
+	fRuntime.rdb = conns.rdb
+	fRuntime.stateStore = conns.stateStore
+	if conns.dataStore != nil {
+		fRuntime.DataStore = conns.dataStore
+	}
+	fRuntime.rmqConnection = conns.transport
+
+	fRuntime.wrapStores()
+
 	fRuntime.eventHandler = &eventhandler.GoFlowEventHandler{
 		TraceURI: fRuntime.OpenTracingUrl,
 	}
+	if sinks := fRuntime.eventSinks(); len(sinks) > 0 {
+		handlers := sinks
+		if fRuntime.EnableMonitoring {
+			handlers = append([]sdk.EventHandler{fRuntime.eventHandler}, handlers...)
+		}
+		fRuntime.eventHandler = &eventhandler.MultiEventHandler{Handlers: handlers}
+	}
 
+	recordBuildInfo()
+	fRuntime.initialized.Store(true)
 	return nil
 }
 
+// eventSinks returns the EventSinks along with the handlers of the KafkaSinks
+func (fRuntime *FlowRuntime) eventSinks() []sdk.EventHandler {
+	if len(fRuntime.kafkaSinks) == 0 {
+		return fRuntime.EventSinks
+	}
+	handlers := append([]sdk.EventHandler{}, fRuntime.EventSinks...)
+	for _, sink := range fRuntime.kafkaSinks {
+		handlers = append(handlers, sink.EventHandler())
+	}
+	return handlers
+}
+
 func (fRuntime *FlowRuntime) CreateExecutor(req *runtime.Request) (executor.Executor, error) {
 	flowHandler, ok := fRuntime.Flows.Get(req.FlowName)
 	if !ok {
 		return nil, fmt.Errorf("could not find handler for flow %s", req.FlowName)
 	}
+	var stateStore sdk.StateStore = fRuntime.stateStore
+	var dataStore sdk.DataStore = &outputLimitDataStore{DataStore: fRuntime.DataStore, flowName: req.FlowName, runtime: fRuntime}
+	monitoring := fRuntime.EnableMonitoring || len(fRuntime.EventSinks) > 0 || len(fRuntime.kafkaSinks) > 0
+	if IsWarmupRequest(req.RequestID) {
+		// the warmups are left out of the usage, the slow log and the tracer
+		monitoring = false
+	} else {
+		stateStore = &slowLogStateStore{StateStore: stateStore, runtime: fRuntime}
+		dataStore = &slowLogDataStore{
+			DataStore: &usageDataStore{DataStore: dataStore, flowName: req.FlowName, runtime: fRuntime},
+			runtime:   fRuntime,
+		}
+	}
 	ex := &FlowExecutor{
-		StateStore:              fRuntime.stateStore,
+		StateStore:              stateStore,
 		RequestAuthSharedSecret: fRuntime.RequestAuthSharedSecret,
 		RequestAuthEnabled:      fRuntime.RequestAuthEnabled,
-		DataStore:               fRuntime.DataStore,
+		DataStore:               dataStore,
 		EventHandler:            fRuntime.eventHandler,
-		EnableMonitoring:        fRuntime.EnableMonitoring,
+		EnableMonitoring:        monitoring,
 		Handler:                 flowHandler,
 		Logger:                  fRuntime.Logger,
 		Runtime:                 fRuntime,
-		IsLoggingEnabled:        fRuntime.DebugEnabled,
+		IsLoggingEnabled:        fRuntime.debugLogging(req.RequestID),
 	}
 	err := ex.Init(req)
 	return ex, err
@@ -141,27 +384,43 @@ func (fRuntime *FlowRuntime) CreateExecutor(req *runtime.Request) (executor.Exec
 // Register flows to the runtime
 // If the flow is already registered, it returns an error
 func (fRuntime *FlowRuntime) Register(flows map[string]FlowDefinitionHandler) error {
-	if reflect.ValueOf(fRuntime.rmqConnection).IsNil() {
-		return fmt.Errorf("unable to register flows, rmq connection not initialized")
+	if !fRuntime.initialized.Load() {
+		return fmt.Errorf("unable to register flows, runtime not initialized, Init must be called first")
 	}
 
 	if len(flows) == 0 {
 		return nil
 	}
 
+	// held for the whole registration so that concurrent registrations can't exceed MaxFlows
+	fRuntime.queueMu.Lock()
+	defer fRuntime.queueMu.Unlock()
+
 	var flowNames []string
-	for flowName := range flows {
+	resolvedFlows := make(map[string]FlowDefinitionHandler, len(flows))
+	for name, flowHandler := range flows {
+		flowName, err := fRuntime.resolveFlowName(name)
+		if err != nil {
+			return err
+		}
 		if _, ok := fRuntime.Flows.Get(flowName); ok {
 			return fmt.Errorf("flow %s already registered", flowName)
 		}
 
 		flowNames = append(flowNames, flowName)
+		resolvedFlows[flowName] = flowHandler
+	}
+	if fRuntime.MaxFlows > 0 && fRuntime.FlowCount()+len(resolvedFlows) > fRuntime.MaxFlows {
+		return fmt.Errorf("unable to register flows %v, the worker is limited to %d flows and has %d registered",
+			flowNames, fRuntime.MaxFlows, fRuntime.FlowCount())
 	}
 
 	// register flows to runtime
-	for flowName, flowHandler := range flows {
+	for flowName, flowHandler := range resolvedFlows {
+		fRuntime.setFlowDefinitionVersion(flowName, flowHandler)
 		fRuntime.Flows.Set(flowName, flowHandler)
 	}
+	fRuntime.flowsVersion.Add(1)
 
 	// initialize task queues when in worker mode
 	if fRuntime.workerMode.Load() {
@@ -178,35 +437,44 @@ func (fRuntime *FlowRuntime) Register(flows map[string]FlowDefinitionHandler) er
 
 // EnterWorkerMode put the runtime into worker mode
 func (fRuntime *FlowRuntime) EnterWorkerMode() error {
-	if reflect.ValueOf(fRuntime.rmqConnection).IsNil() {
-		return fmt.Errorf("unable to enter worker mode, rmq connection not initialized")
+	if !fRuntime.initialized.Load() {
+		return fmt.Errorf("unable to enter worker mode, runtime not initialized, Init must be called first")
 	}
 
-	if fRuntime.workerMode.Load() {
+	fRuntime.queueMu.Lock()
+	defer fRuntime.queueMu.Unlock()
+
+	if !fRuntime.workerMode.CompareAndSwap(false, true) {
 		// already in worker mode
 		return nil
 	}
-	fRuntime.workerMode.Store(true)
 
 	err := fRuntime.initializeTaskQueues(&fRuntime.rmqConnection, fRuntime.Flows)
 	if err != nil {
 		return fmt.Errorf("failed to enter worker mode, error: " + err.Error())
 	}
 
+	err = fRuntime.initializeWorkerQueue()
+	if err != nil {
+		return fmt.Errorf("failed to enter worker mode, error: " + err.Error())
+	}
+
 	return nil
 }
 
 // ExitWorkerMode take the runtime out of worker mode
 func (fRuntime *FlowRuntime) ExitWorkerMode() error {
-	if reflect.ValueOf(fRuntime.rmqConnection).IsNil() {
+	if !fRuntime.initialized.Load() {
 		return nil
 	}
 
-	if !fRuntime.workerMode.Load() {
+	fRuntime.queueMu.Lock()
+	defer fRuntime.queueMu.Unlock()
+
+	if !fRuntime.workerMode.CompareAndSwap(true, false) {
 		// already not in worker mode
 		return nil
 	}
-	fRuntime.workerMode.Store(false)
 
 	err := fRuntime.cleanTaskQueues()
 	if err != nil {
@@ -216,6 +484,23 @@ func (fRuntime *FlowRuntime) ExitWorkerMode() error {
 	return nil
 }
 
+// WorkerID returns the id of the runtime instance
+func (fRuntime *FlowRuntime) WorkerID() string {
+	fRuntime.workerIDOnce.Do(func() {
+		fRuntime.workerID = getNewId()
+	})
+	return fRuntime.workerID
+}
+
+// connectionTag returns the tag of the rmq connections, it identifies the namespace and the worker
+// so that deployments sharing a redis can be told apart in the rmq cleaner and stats
+func (fRuntime *FlowRuntime) connectionTag() string {
+	if fRuntime.Namespace == "" {
+		return fmt.Sprintf("goflow-%s", fRuntime.WorkerID())
+	}
+	return fmt.Sprintf("goflow-%s-%s", fRuntime.Namespace, fRuntime.WorkerID())
+}
+
 // OpenConnection opens and returns a new connection
 func OpenConnectionV2(tag string, cfg *types.RedisConfig, errChan chan<- error) (rmq.Connection, error) {
 	redisClient := cfg.NewRedisClient()
@@ -223,34 +508,113 @@ func OpenConnectionV2(tag string, cfg *types.RedisConfig, errChan chan<- error)
 }
 
 func (fRuntime *FlowRuntime) Execute(flowName string, request *runtime.Request) error {
-
-	connection, err := OpenConnectionV2("goflow", &fRuntime.RedisCfg, nil)
+	flowName, err := fRuntime.resolveFlowName(flowName)
 	if err != nil {
-		return fmt.Errorf("failed to initiate connection, error %v", err)
+		return err
 	}
-	taskQueue, err := connection.OpenQueue(fRuntime.internalRequestQueueId(flowName))
+	if err := fRuntime.decodeRequestBody(request); err != nil {
+		return err
+	}
+	if err := fRuntime.validateRequestBody(flowName, request.Body); err != nil {
+		return err
+	}
+	pending, err := fRuntime.failurePending(flowName, request.RequestID)
+	if err != nil {
+		return err
+	}
+	if pending {
+		return fmt.Errorf("failure of a previous execution of request %s is being handled", request.RequestID)
+	}
+	// a request with an idempotency key is published with its id, so that a redelivery of its task gets back the
+	// claim of the key rather than being skipped as a duplicate of itself
+	if request.RequestID == "" && request.GetHeader(IdempotencyKeyHeader) != "" {
+		request.RequestID = getNewId()
+	}
+	connection, err := OpenConnectionV2(fRuntime.connectionTag(), &fRuntime.RedisCfg, nil)
 	if err != nil {
+		return fmt.Errorf("failed to initiate connection, error %v", err)
+	}
+	queueId := fRuntime.publishQueueId(flowName, request.PartitionKey)
+	queueLocation := fRuntime.publishQueueLocation(flowName, request.PartitionKey)
+	if request.PartitionKey == "" {
+		if stickyQueueId, ok := fRuntime.stickyQueueId(flowName, request); ok {
+			queueId = stickyQueueId
+			queueLocation = workerQueueLocation(strings.TrimPrefix(stickyQueueId, WorkerQueueInitial+":"))
+		}
+	}
+	// opened so that the queue is registered for its consumers, the task is published along with its location
+	if _, err := connection.OpenQueue(queueId); err != nil {
 		return fmt.Errorf("failed to get queue, error %v", err)
 	}
 
-	data, _ := json.Marshal(&Task{
-		FlowName:    flowName,
-		RequestID:   request.RequestID,
-		Body:        string(request.Body),
-		Header:      request.Header,
-		RawQuery:    request.RawQuery,
-		Query:       request.Query,
-		RequestType: NewRequest,
-	})
-	err = taskQueue.PublishBytes(data)
+	task := &Task{
+		FlowName:     flowName,
+		RequestID:    request.RequestID,
+		Body:         request.Body,
+		Header:       request.Header,
+		RawQuery:     request.RawQuery,
+		Query:        request.Query,
+		RequestType:  NewRequest,
+		PartitionKey: request.PartitionKey,
+		SubmittedAt:  fRuntime.clock().Now().UnixNano(),
+	}
+	if !request.Deadline.IsZero() {
+		task.Deadline = request.Deadline.UnixNano()
+	}
+	if err := fRuntime.archiveTask(task); err != nil {
+		return fmt.Errorf("failed to archive task, error %v", err)
+	}
+	fRuntime.sampleTask(task)
+	if err := fRuntime.storeTaskBody(task); err != nil {
+		return fmt.Errorf("failed to store request body, error %v", err)
+	}
+	request.RequestID = task.RequestID
+
+	data, err := marshalTask(task)
+	if err != nil {
+		return err
+	}
+	err = fRuntime.publishTask(queueId, task, data, queueLocation, false)
 	if err != nil {
 		return fmt.Errorf("failed to publish task, error %v", err)
 	}
 	return nil
 }
 
+// publishTask publishes a task on a queue, at its head to be consumed first when head is set, along with the
+// transition of its request to the location of the queue in a single round trip. The transition is best effort,
+// the task is queued already and its location is recorded again once it is consumed
+func (fRuntime *FlowRuntime) publishTask(queueId string, task *Task, data []byte, location string, head bool) error {
+	if fRuntime.UnsafeFaultInjector != nil {
+		drop, err := fRuntime.UnsafeFaultInjector.inject(FaultOperationPublish)
+		if err != nil || drop {
+			return err
+		}
+	}
+	ctx := context.TODO()
+	readyKey := readyQueueKey(queueId)
+	pipe := fRuntime.redisClient().Pipeline()
+	var publish *redis.IntCmd
+	if head {
+		// the consumers pop the tail of the ready list
+		publish = pipe.RPush(ctx, readyKey, data)
+	} else {
+		publish = pipe.LPush(ctx, readyKey, data)
+	}
+	if tracksLocation(task) {
+		fRuntime.addLocation(ctx, pipe, task.FlowName, task.RequestID, location, LocationStatusQueued, "")
+		fRuntime.addRouting(ctx, pipe, task.FlowName, task.RequestID, location, RoutingActionPublished)
+	}
+	fRuntime.flushWrites(pipe)
+	return publish.Err()
+}
+
 func (fRuntime *FlowRuntime) Pause(flowName string, request *runtime.Request) error {
-	connection, err := OpenConnectionV2("goflow", &fRuntime.RedisCfg, nil)
+	flowName, err := fRuntime.resolveFlowName(flowName)
+	if err != nil {
+		return err
+	}
+	connection, err := OpenConnectionV2(fRuntime.connectionTag(), &fRuntime.RedisCfg, nil)
 	if err != nil {
 		return fmt.Errorf("failed to initiate connection, error %v", err)
 	}
@@ -258,15 +622,18 @@ func (fRuntime *FlowRuntime) Pause(flowName string, request *runtime.Request) er
 	if err != nil {
 		return fmt.Errorf("failed to get queue, error %v", err)
 	}
-	data, _ := json.Marshal(&Task{
+	data, err := marshalTask(&Task{
 		FlowName:    flowName,
 		RequestID:   request.RequestID,
-		Body:        string(request.Body),
+		Body:        request.Body,
 		Header:      request.Header,
 		RawQuery:    request.RawQuery,
 		Query:       request.Query,
 		RequestType: PauseRequest,
 	})
+	if err != nil {
+		return err
+	}
 	err = taskQueue.PublishBytes(data)
 	if err != nil {
 		return fmt.Errorf("failed to publish task, error %v", err)
@@ -275,7 +642,11 @@ func (fRuntime *FlowRuntime) Pause(flowName string, request *runtime.Request) er
 }
 
 func (fRuntime *FlowRuntime) Stop(flowName string, request *runtime.Request) error {
-	connection, err := OpenConnectionV2("goflow", &fRuntime.RedisCfg, nil)
+	flowName, err := fRuntime.resolveFlowName(flowName)
+	if err != nil {
+		return err
+	}
+	connection, err := OpenConnectionV2(fRuntime.connectionTag(), &fRuntime.RedisCfg, nil)
 	if err != nil {
 		return fmt.Errorf("failed to initiate connection, error %v", err)
 	}
@@ -283,15 +654,18 @@ func (fRuntime *FlowRuntime) Stop(flowName string, request *runtime.Request) err
 	if err != nil {
 		return fmt.Errorf("failed to get queue, error %v", err)
 	}
-	data, _ := json.Marshal(&Task{
+	data, err := marshalTask(&Task{
 		FlowName:    flowName,
 		RequestID:   request.RequestID,
-		Body:        string(request.Body),
+		Body:        request.Body,
 		Header:      request.Header,
 		RawQuery:    request.RawQuery,
 		Query:       request.Query,
 		RequestType: StopRequest,
 	})
+	if err != nil {
+		return err
+	}
 	err = taskQueue.PublishBytes(data)
 	if err != nil {
 		return fmt.Errorf("failed to publish task, error %v", err)
@@ -300,7 +674,11 @@ func (fRuntime *FlowRuntime) Stop(flowName string, request *runtime.Request) err
 }
 
 func (fRuntime *FlowRuntime) Resume(flowName string, request *runtime.Request) error {
-	connection, err := OpenConnectionV2("goflow", &fRuntime.RedisCfg, nil)
+	flowName, err := fRuntime.resolveFlowName(flowName)
+	if err != nil {
+		return err
+	}
+	connection, err := OpenConnectionV2(fRuntime.connectionTag(), &fRuntime.RedisCfg, nil)
 	if err != nil {
 		return fmt.Errorf("failed to initiate connection, error %v", err)
 	}
@@ -308,15 +686,18 @@ func (fRuntime *FlowRuntime) Resume(flowName string, request *runtime.Request) e
 	if err != nil {
 		return fmt.Errorf("failed to get queue, error %v", err)
 	}
-	data, _ := json.Marshal(&Task{
+	data, err := marshalTask(&Task{
 		FlowName:    flowName,
 		RequestID:   request.RequestID,
-		Body:        string(request.Body),
+		Body:        request.Body,
 		Header:      request.Header,
 		RawQuery:    request.RawQuery,
 		Query:       request.Query,
 		RequestType: ResumeRequest,
 	})
+	if err != nil {
+		return err
+	}
 	err = taskQueue.PublishBytes(data)
 	if err != nil {
 		return fmt.Errorf("failed to publish task, error %v", err)
@@ -326,13 +707,7 @@ func (fRuntime *FlowRuntime) Resume(flowName string, request *runtime.Request) e
 
 // StartServer starts listening for new request
 func (fRuntime *FlowRuntime) StartServer() error {
-	fRuntime.srv = &http.Server{
-		Addr:           fmt.Sprintf(":%d", fRuntime.ServerPort),
-		ReadTimeout:    fRuntime.ReadTimeout,
-		WriteTimeout:   fRuntime.WriteTimeout,
-		Handler:        Router(fRuntime),
-		MaxHeaderBytes: 1 << 20, // Max header of 1MB
-	}
+	fRuntime.srv = fRuntime.newServer(fRuntime.ServerPort)
 
 	return fRuntime.srv.ListenAndServe()
 }
@@ -345,21 +720,98 @@ func (fRuntime *FlowRuntime) StopServer() error {
 	return nil
 }
 
-// StartRuntime starts the runtime
+// StartServerTLS starts listening for new request over HTTPS on TLSServerPort,
+// it runs independently of the server started by StartServer
+func (fRuntime *FlowRuntime) StartServerTLS(certFile, keyFile string) error {
+	fRuntime.tlsSrv = fRuntime.newServer(fRuntime.TLSServerPort)
+
+	return fRuntime.tlsSrv.ListenAndServeTLS(certFile, keyFile)
+}
+
+// StopServerTLS stops the HTTPS server
+func (fRuntime *FlowRuntime) StopServerTLS() error {
+	if fRuntime.tlsSrv == nil {
+		return nil
+	}
+	if err := fRuntime.tlsSrv.Shutdown(context.Background()); err != nil {
+		return err
+	}
+	return nil
+}
+
+// DrainAndShutdown stops both servers from accepting new request and waits for the
+// in-flight requests and tasks to complete before stopping the workers
+func (fRuntime *FlowRuntime) DrainAndShutdown(ctx context.Context) error {
+	fRuntime.ready.Store(false)
+	for _, srv := range []*http.Server{fRuntime.srv, fRuntime.tlsSrv} {
+		if srv == nil {
+			continue
+		}
+		if err := srv.Shutdown(ctx); err != nil {
+			return fmt.Errorf("failed to shutdown server %s, error %v", srv.Addr, err)
+		}
+	}
+
+	if err := fRuntime.ExitWorkerMode(); err != nil {
+		return fmt.Errorf("failed to stop workers, error %v", err)
+	}
+	fRuntime.flushUsage()
+	fRuntime.flushNodeStats()
+	fRuntime.flushRequestLogs()
+	for _, sink := range fRuntime.kafkaSinks {
+		sink.Close()
+	}
+	fRuntime.shutdownLifecycle()
+	return nil
+}
+
+// lifecycle returns the context of the background routines of the runtime, done once the runtime stops or is
+// shut down with DrainAndShutdown
+func (fRuntime *FlowRuntime) lifecycle() context.Context {
+	fRuntime.lifecycleOnce.Do(func() {
+		fRuntime.lifecycleCtx, fRuntime.stopLifecycle = context.WithCancel(context.Background())
+	})
+	return fRuntime.lifecycleCtx
+}
+
+// shutdownLifecycle stops the background routines of the runtime
+func (fRuntime *FlowRuntime) shutdownLifecycle() {
+	fRuntime.lifecycle()
+	fRuntime.stopLifecycle()
+}
+
+func (fRuntime *FlowRuntime) newServer(port int) *http.Server {
+	return &http.Server{
+		Addr:           fmt.Sprintf(":%d", port),
+		ReadTimeout:    fRuntime.ReadTimeout,
+		WriteTimeout:   fRuntime.WriteTimeout,
+		Handler:        Router(fRuntime),
+		MaxHeaderBytes: 1 << 20, // Max header of 1MB
+	}
+}
+
+// StartRuntime starts the runtime, it runs its periodic jobs until the runtime is shut down with DrainAndShutdown
 func (fRuntime *FlowRuntime) StartRuntime() error {
+	if !fRuntime.started.CompareAndSwap(false, true) {
+		return fmt.Errorf("failed to start runtime, runtime already started")
+	}
+	build := GetBuildInfo()
 	worker := &Worker{
-		ID:          getNewId(),
+		ID:          fRuntime.WorkerID(),
 		Concurrency: fRuntime.Concurrency,
+		Version:     build.Version,
+		Commit:      build.Commit,
 	}
+	fRuntime.Logger.Log(fmt.Sprintf("[goflow] worker %s running goflow %s", worker.ID, build))
 
 	registerDetails := func() error {
 		// Get the flow details for each flow
 		flowDetails := make(map[string]string)
+		flowHashes := make(map[string]string)
 		var err error
-		fRuntime.Flows.ForEach(func(flowID string, defHandler FlowDefinitionHandler) bool {
-			worker.mu.Lock()
-			defer worker.mu.Unlock()
-
+		worker.mu.Lock()
+		worker.Flows = worker.Flows[:0]
+		fRuntime.ForEachFlow(func(flowID string, defHandler FlowDefinitionHandler) bool {
 			var dag string
 			worker.Flows = append(worker.Flows, flowID)
 			dag, err = getFlowDefinition(defHandler)
@@ -368,8 +820,15 @@ func (fRuntime *FlowRuntime) StartRuntime() error {
 				return false
 			}
 			flowDetails[flowID] = dag
+			flowHashes[flowID] = definitionHash(dag)
 			return true
 		})
+		worker.FlowHashes = flowHashes
+		sample := fRuntime.LoadSample()
+		worker.CPUPercent = sample.CPUPercent
+		worker.RSSBytes = sample.RSSBytes
+		worker.Throttled = fRuntime.IsThrottled()
+		worker.mu.Unlock()
 		if err != nil {
 			return err
 		}
@@ -389,43 +848,102 @@ func (fRuntime *FlowRuntime) StartRuntime() error {
 		if err := fRuntime.saveFlowDetails(flowDetails); err != nil {
 			return fmt.Errorf("failed to register flow details, %v", err)
 		}
+		if err := fRuntime.saveFlowDependencies(fRuntime.ListFlows()); err != nil {
+			return fmt.Errorf("failed to register flow dependencies, %v", err)
+		}
+		if err := fRuntime.saveErrorBudgetRules(fRuntime.ListFlows()); err != nil {
+			return fmt.Errorf("failed to register error budget rules, %v", err)
+		}
+		if err := fRuntime.saveJSONBodyValidations(fRuntime.ListFlows()); err != nil {
+			return fmt.Errorf("failed to register json validations, %v", err)
+		}
+		if err := fRuntime.detectDefinitionConflicts(context.TODO(), flowHashes); err != nil {
+			return fmt.Errorf("failed to detect flow definition conflicts, %v", err)
+		}
 
 		return nil
 	}
 
+	fRuntime.sampleLoad()
 	err := registerDetails()
 	if err != nil {
 		log.Printf("failed to register details, %v", err)
 		return err
 	}
 
-	err = gocron.Every(GoFlowRegisterInterval).Second().Do(func() {
-		err := registerDetails()
-		if err != nil {
-			log.Printf("failed to register details, %v", err)
-		}
-	})
-	if err != nil {
+	ctx := fRuntime.lifecycle()
+	defer fRuntime.shutdownLifecycle()
+	if err := fRuntime.watchConfigUpdates(ctx); err != nil {
 		return fmt.Errorf("failed to start runtime, %v", err)
 	}
 
-	<-gocron.Start()
+	jobs := []periodicJob{
+		{interval: fRuntime.loadSampleInterval(), run: fRuntime.sampleLoad},
+		{interval: InFlightHeartbeatInterval, run: fRuntime.refreshInFlight},
+		{interval: UsageFlushInterval, run: fRuntime.flushUsage},
+		{interval: InterruptNodeRefreshInterval, run: fRuntime.refreshNodeInterrupts},
+		{interval: NodeStatsFlushInterval, run: fRuntime.flushNodeStats},
+		{interval: GoFlowRegisterInterval * time.Second, run: func() {
+			err := registerDetails()
+			if err != nil {
+				log.Printf("failed to register details, %v", err)
+			}
+		}},
+		{interval: fRuntime.cleanerInterval(), run: fRuntime.cleanDeliveries},
+		{interval: fRuntime.errorBudgetInterval(), run: fRuntime.evaluateErrorBudgets},
+	}
+	if fRuntime.RequestLogsEnabled {
+		jobs = append(jobs, periodicJob{interval: RequestLogFlushInterval, run: fRuntime.flushRequestLogs})
+	}
+	if fRuntime.PartitionCount > 0 {
+		jobs = append(jobs, periodicJob{interval: PartitionLeaseInterval, run: fRuntime.balancePartitions})
+	}
+	if fRuntime.StoreKeyCountsEnabled {
+		jobs = append(jobs, periodicJob{interval: fRuntime.storeKeyCountInterval(), run: fRuntime.countStoreKeys})
+	}
+
+	go fRuntime.runScheduler(ctx)
+	fRuntime.runPeriodicJobs(ctx, jobs)
 
 	return fmt.Errorf("[goflow] runtime stopped")
 }
 
 func (fRuntime *FlowRuntime) EnqueuePartialRequest(pr *runtime.Request) error {
-	data, _ := json.Marshal(&Task{
-		FlowName:    pr.FlowName,
-		RequestID:   pr.RequestID,
-		Body:        string(pr.Body),
-		Header:      pr.Header,
-		RawQuery:    pr.RawQuery,
-		Query:       pr.Query,
-		RequestType: PartialRequest,
-	})
-	err := fRuntime.taskQueues[pr.FlowName].PublishBytes(data)
+	task := &Task{
+		FlowName:     pr.FlowName,
+		RequestID:    pr.RequestID,
+		Body:         pr.Body,
+		Header:       pr.Header,
+		RawQuery:     pr.RawQuery,
+		Query:        pr.Query,
+		RequestType:  PartialRequest,
+		PartitionKey: pr.PartitionKey,
+	}
+	if !pr.Deadline.IsZero() {
+		task.Deadline = pr.Deadline.UnixNano()
+	}
+	data, err := marshalTask(task)
 	if err != nil {
+		return err
+	}
+	// the queues of the flow are replaced as the worker enters or exits worker mode and as the flow is shut down
+	fRuntime.queueMu.Lock()
+	taskQueue, partitionQueues := fRuntime.taskQueues[pr.FlowName], fRuntime.partitionQueues[pr.FlowName]
+	fRuntime.queueMu.Unlock()
+	if taskQueue == nil {
+		// the flow is not consumed by the runtime, e.g. a synchronous execution outside of worker mode
+		_, err = fRuntime.rmqConnection.OpenQueue(fRuntime.internalRequestQueueId(pr.FlowName))
+		if err != nil {
+			return fmt.Errorf("failed to open queue of flow %s, error %v", pr.FlowName, err)
+		}
+	}
+	queueId, queueLocation, head := fRuntime.internalRequestQueueId(pr.FlowName), QueueLocationMain, false
+	// continuations of a partitioned request stay on its partition, ahead of the requests queued behind it
+	if len(partitionQueues) > 0 && pr.PartitionKey != "" {
+		partition := fRuntime.partitionIndex(pr.PartitionKey)
+		queueId, queueLocation, head = fRuntime.partitionQueueId(pr.FlowName, partition), partitionQueueLocation(partition), true
+	}
+	if err := fRuntime.publishTask(queueId, task, data, queueLocation, head); err != nil {
 		return fmt.Errorf("failed to publish task, error %v", err)
 	}
 	return nil
@@ -433,62 +951,183 @@ func (fRuntime *FlowRuntime) EnqueuePartialRequest(pr *runtime.Request) error {
 
 // Consume messages from queue
 func (fRuntime *FlowRuntime) Consume(message rmq.Delivery) {
+	fRuntime.consume(message, &queueConsumer{runtime: fRuntime})
+}
+
+// consume processes a delivery of the queue of the consumer, the tasks failing on the last queue of their
+// retries are dead lettered when lastRetry is set
+func (fRuntime *FlowRuntime) consume(message rmq.Delivery, consumer *queueConsumer) {
+	message = fRuntime.wrapDelivery(message)
 	var task Task
+	// the task body is unquoted into its own slice, the copy of the payload is not retained
 	if err := json.Unmarshal([]byte(message.Payload()), &task); err != nil {
-		fRuntime.Logger.Log("[goflow] rejecting task for parse failure, error " + err.Error())
+		fRuntime.handleQueueError(QueueOperationParse, nil, err)
 		if err := message.Push(); err != nil {
-			fRuntime.Logger.Log("[goflow] failed to push message to retry queue, error " + err.Error())
+			fRuntime.handleQueueError(QueueOperationPush, nil, err)
 			return
 		}
 		return
 	}
-	if err := fRuntime.handleRequest(makeRequestFromTask(task), task.RequestType); err != nil {
+	if !task.RequestType.IsValid() {
+		fRuntime.handleInvalidRequestType(message, &task)
+		return
+	}
+	if _, ok := fRuntime.Flows.Get(task.FlowName); !ok {
+		fRuntime.handleUnregisteredFlow(message, &task)
+		return
+	}
+	if fRuntime.PauseConflictingFlows && len(fRuntime.conflictingWorkers(task.FlowName)) > 0 {
+		fRuntime.requeueConflictingTask(message, &task)
+		return
+	}
+	if task.RequestType == NewRequest && fRuntime.deferThrottledTask(message, &task) {
+		return
+	}
+	tasksCounter.Inc()
+	fRuntime.recordUsage(task.FlowName, UsageQueueMessages, 1)
+
+	writes := fRuntime.redisClient().Pipeline()
+	tracker := fRuntime.trackCrashes(message, writes)
+	started, canceled := consumer.startLocation(&task, writes)
+	fRuntime.flushWrites(writes)
+	// the bookkeeping of the end of the delivery is written once handled, whichever way
+	defer fRuntime.flushWrites(writes)
+	tracker, poisoned := tracker.counted(message, &task)
+	if poisoned {
+		return
+	}
+	if canceled {
+		tracker.finish(message, &task, nil, writes)
+		fRuntime.ackCanceledTask(message, &task)
+		return
+	}
+	release := fRuntime.acquireExecutionSlot()
+	err := fRuntime.loadTaskBody(&task)
+	if err == nil {
+		err = tracker.guard(func() error {
+			return fRuntime.handleRequest(makeRequestFromTask(task), task.RequestType)
+		})
+	}
+	release()
+	if tracker.finish(message, &task, err, writes) {
+		return
+	}
+	if err != nil && consumer.lastRetry {
+		fRuntime.deadLetterFailure(message, &task, err, "failed after the last retry")
+		return
+	}
+	if err != nil && !fRuntime.shouldRetry(task.FlowName, err, task.attempt()) {
+		unretriedFailuresCounter.Inc(task.FlowName)
+		fRuntime.deadLetterFailure(message, &task, err, fmt.Sprintf("failed on attempt %d, not retried", task.attempt()))
+		return
+	}
+	if err != nil {
 		fRuntime.Logger.Log("[goflow] rejecting task for failure, error " + err.Error())
-		if err := message.Push(); err != nil {
-			fRuntime.Logger.Log("[goflow] failed to push message to retry queue, error " + err.Error())
+		if err := consumer.pushRetry(message, &task); err != nil {
+			fRuntime.handleQueueError(QueueOperationPush, &task, err)
 			return
 		}
+		return
 	}
 
-	err := message.Ack()
+	err = message.Ack()
 	if err != nil {
-		fRuntime.Logger.Log("[goflow] failed to acknowledge message, error " + err.Error())
+		fRuntime.handleQueueError(QueueOperationAck, &task, err)
 		return
 	}
+	consumer.ackLocation(&task, started.Val(), writes)
+	fRuntime.addThroughput(context.TODO(), writes, task.FlowName)
 }
 
-func (fRuntime *FlowRuntime) handleRequest(request *runtime.Request, requestType string) error {
-	var err error
-	switch requestType {
-	case PartialRequest:
-		err = fRuntime.handlePartialRequest(request)
-	case NewRequest:
-		err = fRuntime.handleNewRequest(request)
-	case PauseRequest:
-		err = fRuntime.handlePauseRequest(request)
-	case ResumeRequest:
-		err = fRuntime.handleResumeRequest(request)
-	case StopRequest:
-		err = fRuntime.handleStopRequest(request)
-	default:
-		return fmt.Errorf("invalid request %v received with type %s", request, requestType)
+// handleQueueError reports a queue delivery error to OnQueueError if set, otherwise logs it.
+// task is nil when the message could not be parsed
+func (fRuntime *FlowRuntime) handleQueueError(operation string, task *Task, err error) {
+	if fRuntime.OnQueueError != nil {
+		fRuntime.OnQueueError(operation, task, err)
+		return
 	}
+
+	switch operation {
+	case QueueOperationParse:
+		fRuntime.Logger.Log("[goflow] rejecting task for parse failure, error " + err.Error())
+	case QueueOperationPush:
+		fRuntime.Logger.Log("[goflow] failed to push message to retry queue, error " + err.Error())
+	case QueueOperationAck:
+		fRuntime.Logger.Log("[goflow] failed to acknowledge message, error " + err.Error())
+	}
+}
+
+// handleExecutionRequest executes a new or partial request, recording its execution time
+func (fRuntime *FlowRuntime) handleExecutionRequest(request *runtime.Request, handle func(*runtime.Request) error) error {
+	start := fRuntime.clock().Now()
+	err := handle(request)
+	elapsed := fRuntime.clock().Now().Sub(start)
+	fRuntime.recordUsage(request.FlowName, UsageExecutionSeconds, elapsed.Seconds())
+	fRuntime.recordExecutionTime(request, elapsed)
 	return err
 }
 
 func (fRuntime *FlowRuntime) handleNewRequest(request *runtime.Request) error {
+	// the request id is needed upfront to store the deadline and the consumed budget, only a request submitted
+	// with its id can be a replay waiting for the failure of its previous execution
+	if request.RequestID == "" {
+		request.RequestID = getNewId()
+	} else {
+		pending, err := fRuntime.failurePending(request.FlowName, request.RequestID)
+		if err != nil {
+			return err
+		}
+		if pending {
+			// retried once the failure of the previous execution of the request is handled
+			return fmt.Errorf("failure of a previous execution of request %s is being handled", request.RequestID)
+		}
+	}
+
+	if !request.Deadline.IsZero() && fRuntime.clock().Now().After(request.Deadline) {
+		// the request expired while queued, executing it is pointless
+		fRuntime.abandonExpiredRequest(request, "exceeded its deadline before being started")
+		return nil
+	}
+
+	fRuntime.recordSubmission(request)
+
+	hookedRequest, err := fRuntime.runPreExecHooks(request)
+	if err != nil {
+		// a rejected request is not retried, the hooks would reject it again
+		fRuntime.rejectRequest(request, err)
+		return nil
+	}
+	request = hookedRequest
+
+	claimed, err := fRuntime.claimRequest(request)
+	if err != nil {
+		return fmt.Errorf("failed to claim request %s, error %v", request.RequestID, err)
+	}
+	if !claimed {
+		// the idempotency key of the request is processed by another request
+		return nil
+	}
+	deadline, err := fRuntime.applyRequestDeadline(request)
+	if err != nil {
+		return fmt.Errorf("failed to set deadline of request %s, error %v", request.RequestID, err)
+	}
+	request.Deadline = deadline
+
 	flowExecutor, err := fRuntime.CreateExecutor(request)
 	if err != nil {
-		return fmt.Errorf("failed to execute request " + request.RequestID + ", error: " + err.Error())
+		return fmt.Errorf("failed to execute request %s, error: %w", request.RequestID, err)
 	}
+	// the nodes are executed with a context done at the deadline
+	defer fRuntime.withDeadline(flowExecutor, deadline)()
 
 	response := &runtime.Response{}
 	response.RequestID = request.RequestID
 	response.Header = make(map[string][]string)
 
+	defer fRuntime.trackInFlight(request.FlowName, request.RequestID)()
 	err = controller.ExecuteFlowHandler(response, request, flowExecutor)
 	if err != nil {
-		return fmt.Errorf("request failed to be processed. error: " + err.Error())
+		return fmt.Errorf("request failed to be processed. error: %w", err)
 	}
 
 	return nil
@@ -498,20 +1137,102 @@ func (fRuntime *FlowRuntime) handlePartialRequest(request *runtime.Request) erro
 	flowExecutor, err := fRuntime.CreateExecutor(request)
 	if err != nil {
 		fRuntime.Logger.Log(fmt.Sprintf("[request `%s`] failed to execute request, error: %v", request.RequestID, err))
-		return fmt.Errorf("[goflow] failed to execute request " + request.RequestID + ", error: " + err.Error())
+		return fmt.Errorf("[goflow] failed to execute request %s, error: %w", request.RequestID, err)
 	}
 	response := &runtime.Response{}
 	response.RequestID = request.RequestID
 	response.Header = make(map[string][]string)
 
+	exceeded, err := fRuntime.exceedsMaxContinuations(request)
+	if err != nil {
+		fRuntime.Logger.Log(fmt.Sprintf("[request `%s`] failed to update continuation count, error: %v", request.RequestID, err))
+		return fmt.Errorf("[goflow] failed to update continuation count for request %s, error: %v", request.RequestID, err)
+	}
+	if exceeded {
+		// the request can never succeed, so it is failed instead of being retried
+		fRuntime.failExceededContinuations(request, flowExecutor)
+		return nil
+	}
+
+	exceeded, err = fRuntime.exceedsExecutionBudget(request)
+	if err != nil {
+		fRuntime.Logger.Log(fmt.Sprintf("[request `%s`] failed to check execution budget, error: %v", request.RequestID, err))
+		return fmt.Errorf("[goflow] failed to check execution budget for request %s, error: %v", request.RequestID, err)
+	}
+	if exceeded {
+		fRuntime.Logger.Log(fmt.Sprintf("[request `%s`] failed (category %s), exceeded its execution budget, stopping request",
+			request.RequestID, FailureCategoryBudget))
+		err = fRuntime.updateArchivedTask(request.RequestID, func(task *Task) {
+			task.FailureCategory = FailureCategoryBudget
+		})
+		if err != nil {
+			fRuntime.Logger.Log(fmt.Sprintf("[request `%s`] failed to update archived task, error %v", request.RequestID, err))
+		}
+		err = controller.StopFlowHandler(response, request, flowExecutor)
+		if err != nil {
+			fRuntime.Logger.Log(fmt.Sprintf("[request `%s`] failed to be stopped. error: %v", request.RequestID, err.Error()))
+		}
+		return nil
+	}
+
+	// the deadline is checked before the next nodes are started
+	if !request.Deadline.IsZero() && fRuntime.clock().Now().After(request.Deadline) {
+		fRuntime.abandonExpiredRequest(request, "exceeded its deadline, stopping request")
+		err = controller.StopFlowHandler(response, request, flowExecutor)
+		if err != nil {
+			fRuntime.Logger.Log(fmt.Sprintf("[request `%s`] failed to be stopped. error: %v", request.RequestID, err.Error()))
+		}
+		return nil
+	}
+	defer fRuntime.withDeadline(flowExecutor, request.Deadline)()
+
 	err = controller.PartialExecuteFlowHandler(response, request, flowExecutor)
 	if err != nil {
 		fRuntime.Logger.Log(fmt.Sprintf("[request `%s`] failed to be processed. error: %v", request.RequestID, err.Error()))
-		return fmt.Errorf("[goflow] request failed to be processed. error: " + err.Error())
+		return fmt.Errorf("[goflow] request failed to be processed. error: %w", err)
 	}
 	return nil
 }
 
+// failExceededContinuations fails a request which exceeded MaxContinuations with ErrMaxContinuations. The request
+// is marked finished so that none of its branches continues, its failure is handled by the failure handler and
+// finally of the flow as the failure of a node, and it is reported failed
+func (fRuntime *FlowRuntime) failExceededContinuations(request *runtime.Request, flowExecutor executor.Executor) {
+	cause := fmt.Errorf("%w, limited to %d", ErrMaxContinuations, fRuntime.MaxContinuations)
+	fRuntime.Logger.Log(fmt.Sprintf("[request `%s`] failed, %v", request.RequestID, cause))
+
+	response := &runtime.Response{RequestID: request.RequestID, Header: make(map[string][]string)}
+	if err := controller.StopFlowHandler(response, request, flowExecutor); err != nil {
+		fRuntime.Logger.Log(fmt.Sprintf("[request `%s`] failed to be stopped. error: %v", request.RequestID, err))
+	}
+	if err := fRuntime.enqueueFailureRequest(request.FlowName, request.RequestID, cause); err != nil {
+		fRuntime.Logger.Log(fmt.Sprintf("[request `%s`] failed to dispatch failure, error %v", request.RequestID, err))
+	}
+	if reporter, ok := flowExecutor.(executor.RequestOutcomeReporter); ok {
+		reporter.ReportRequestOutcome(request.RequestID, cause)
+	}
+}
+
+// exceedsMaxContinuations increments the continuation counter of a request and
+// reports whether it went beyond MaxContinuations, a zero value disables the check
+func (fRuntime *FlowRuntime) exceedsMaxContinuations(request *runtime.Request) (bool, error) {
+	if fRuntime.MaxContinuations <= 0 {
+		return false, nil
+	}
+
+	stateStore, err := fRuntime.stateStore.CopyStore()
+	if err != nil {
+		return false, err
+	}
+	stateStore.Configure(request.FlowName, request.RequestID)
+
+	count, err := stateStore.Incr(ContinuationCountKey, 1)
+	if err != nil {
+		return false, err
+	}
+	return count > int64(fRuntime.MaxContinuations), nil
+}
+
 func (fRuntime *FlowRuntime) handlePauseRequest(request *runtime.Request) error {
 	flowExecutor, err := fRuntime.CreateExecutor(request)
 	if err != nil {
@@ -529,6 +1250,13 @@ func (fRuntime *FlowRuntime) handlePauseRequest(request *runtime.Request) error
 }
 
 func (fRuntime *FlowRuntime) handleResumeRequest(request *runtime.Request) error {
+	// the continuations of the resumed request carry the deadline it was started with
+	deadline, err := fRuntime.storedDeadline(request)
+	if err != nil {
+		fRuntime.Logger.Log(fmt.Sprintf("[request `%s`] failed to be resumed. error: %v", request.RequestID, err.Error()))
+		return fmt.Errorf("request %s failed to be resumed. error: %v", request.RequestID, err.Error())
+	}
+	request.Deadline = deadline
 	flowExecutor, err := fRuntime.CreateExecutor(request)
 	if err != nil {
 		fRuntime.Logger.Log(fmt.Sprintf("[request `%s`] failed to be resumed. error: %v", request.RequestID, err.Error()))
@@ -557,16 +1285,34 @@ func (fRuntime *FlowRuntime) handleStopRequest(request *runtime.Request) error {
 		fRuntime.Logger.Log(fmt.Sprintf("[request `%s`] failed to be stopped. error: %v", request.RequestID, err.Error()))
 		return fmt.Errorf("request %s failed to be stopped. error: %v", request.RequestID, err.Error())
 	}
+	fRuntime.recordHistory(request.FlowName, request.RequestID, HistoryStatusStopped, nil)
+	fRuntime.finishClaim(request.RequestID, false)
 	return nil
 }
 
+// initializeTaskQueues opens the queues and starts the consumers of the flows,
+// flows that already have consumers are skipped. Caller must hold queueMu
 func (fRuntime *FlowRuntime) initializeTaskQueues(conn *rmq.Connection, flows *haxmap.Map[string, FlowDefinitionHandler]) error {
 
 	if fRuntime.taskQueues == nil {
 		fRuntime.taskQueues = make(map[string]rmq.Queue)
 	}
+	if fRuntime.pushQueues == nil {
+		fRuntime.pushQueues = make(map[string][]rmq.Queue)
+	}
+	if fRuntime.partitionQueues == nil {
+		fRuntime.partitionQueues = make(map[string][]rmq.Queue)
+	}
+	if fRuntime.failureQueues == nil {
+		fRuntime.failureQueues = make(map[string]rmq.Queue)
+	}
 	var outErr error
 	flows.ForEach(func(flowName string, value FlowDefinitionHandler) bool {
+		if _, ok := fRuntime.taskQueues[flowName]; ok {
+			// consumers are already registered for the flow
+			return true
+		}
+
 		baseQId := fRuntime.internalRequestQueueId(flowName)
 		taskQueue, err := (*conn).OpenQueue(baseQId)
 		if err != nil {
@@ -578,8 +1324,7 @@ func (fRuntime *FlowRuntime) initializeTaskQueues(conn *rmq.Connection, flows *h
 		var prevQ = taskQueue
 
 		for idx := 0; idx < fRuntime.RetryQueueCount; idx++ {
-			pushQId := fmt.Sprintf("%s-push-%d", baseQId, idx)
-			pushQueues[idx], err = (*conn).OpenQueue(pushQId)
+			pushQueues[idx], err = (*conn).OpenQueue(fRuntime.pushQueueId(flowName, idx))
 			if err != nil {
 				outErr = fmt.Errorf("failed to open push queue, error %v", err)
 				return false
@@ -594,6 +1339,7 @@ func (fRuntime *FlowRuntime) initializeTaskQueues(conn *rmq.Connection, flows *h
 			return false
 		}
 		fRuntime.taskQueues[flowName] = taskQueue
+		fRuntime.pushQueues[flowName] = pushQueues
 
 		for idx := 0; idx < fRuntime.RetryQueueCount; idx++ {
 			err = pushQueues[idx].StartConsuming(10, time.Second)
@@ -604,7 +1350,8 @@ func (fRuntime *FlowRuntime) initializeTaskQueues(conn *rmq.Connection, flows *h
 		}
 
 		for idx := 0; idx < fRuntime.Concurrency; idx++ {
-			_, err := taskQueue.AddConsumer(fmt.Sprintf("request-consumer-%d", idx), fRuntime)
+			_, err := taskQueue.AddConsumer(fmt.Sprintf("request-consumer-%d", idx),
+				fRuntime.retryConsumer(QueueLocationMain, 0, fRuntime.RetryQueueCount == 0))
 			if err != nil {
 				outErr = fmt.Errorf("failed to add consumer, error %v", err)
 				return false
@@ -612,12 +1359,33 @@ func (fRuntime *FlowRuntime) initializeTaskQueues(conn *rmq.Connection, flows *h
 		}
 
 		for idx := 0; idx < fRuntime.RetryQueueCount; idx++ {
-			_, err = pushQueues[idx].AddConsumer(fmt.Sprintf("request-consumer-%d", idx), fRuntime)
+			_, err = pushQueues[idx].AddConsumer(fmt.Sprintf("request-consumer-%d", idx),
+				fRuntime.retryConsumer(pushQueueLocation(idx), idx+1, idx == fRuntime.RetryQueueCount-1))
 			if err != nil {
 				outErr = fmt.Errorf("failed to add consumer, error %v", err)
 				return false
 			}
 		}
+
+		if fRuntime.PartitionCount > 0 {
+			var retryQueue rmq.Queue
+			if fRuntime.RetryQueueCount > 0 {
+				retryQueue = pushQueues[0]
+			}
+			partitionQueues, err := fRuntime.initializePartitionQueues(*conn, flowName, retryQueue)
+			if err != nil {
+				outErr = err
+				return false
+			}
+			fRuntime.partitionQueues[flowName] = partitionQueues
+		}
+
+		failureQueue, err := fRuntime.initializeFailureQueue(*conn, flowName)
+		if err != nil {
+			outErr = err
+			return false
+		}
+		fRuntime.failureQueues[flowName] = failureQueue
 		return true
 	})
 
@@ -626,12 +1394,17 @@ func (fRuntime *FlowRuntime) initializeTaskQueues(conn *rmq.Connection, flows *h
 
 func (fRuntime *FlowRuntime) cleanTaskQueues() error {
 
-	if !reflect.ValueOf(fRuntime.rmqConnection).IsNil() {
+	if fRuntime.rmqConnection != nil {
 		endChan := fRuntime.rmqConnection.StopAllConsuming()
 		<-endChan
 	}
 
 	fRuntime.taskQueues = map[string]rmq.Queue{}
+	fRuntime.pushQueues = map[string][]rmq.Queue{}
+	fRuntime.partitionQueues = map[string][]rmq.Queue{}
+	fRuntime.partitionConsumers = map[string][]rmq.Queue{}
+	fRuntime.failureQueues = map[string]rmq.Queue{}
+	fRuntime.workerQueue = nil
 
 	return nil
 }
@@ -640,10 +1413,86 @@ func (fRuntime *FlowRuntime) internalRequestQueueId(flowName string) string {
 	return fmt.Sprintf("%s:%s", InternalRequestQueueInitial, flowName)
 }
 
+// pushQueueId returns the idx-th push queue of the retries of the flow
+func (fRuntime *FlowRuntime) pushQueueId(flowName string, idx int) string {
+	return fmt.Sprintf("%s-push-%d", fRuntime.internalRequestQueueId(flowName), idx)
+}
+
 func (fRuntime *FlowRuntime) requestQueueId(flowName string) string {
 	return flowName
 }
 
+// storeTaskBody moves a request body larger than BodyStoreThreshold, or a binary body with StoreBinaryBodies,
+// into the DataStore of the request, leaving only a reference in the task
+func (fRuntime *FlowRuntime) storeTaskBody(task *Task) error {
+	binary := fRuntime.StoreBinaryBodies && isBinaryBody(task.Header, task.Body)
+	if !binary && (fRuntime.BodyStoreThreshold <= 0 || len(task.Body) <= fRuntime.BodyStoreThreshold) {
+		return nil
+	}
+
+	// the body is stored under the request, so the request id must be known upfront
+	if task.RequestID == "" {
+		task.RequestID = getNewId()
+	}
+
+	dataStore, err := fRuntime.requestDataStore(task.FlowName, task.RequestID)
+	if err != nil {
+		return err
+	}
+	if err := dataStore.Set(RequestBodyKey, task.Body); err != nil {
+		return err
+	}
+	task.BodyRef = RequestBodyKey
+	task.Body = nil
+	return nil
+}
+
+// loadTaskBody loads the request body referenced by the task from the DataStore
+func (fRuntime *FlowRuntime) loadTaskBody(task *Task) error {
+	if task.BodyRef == "" {
+		return nil
+	}
+
+	dataStore, err := fRuntime.requestDataStore(task.FlowName, task.RequestID)
+	if err != nil {
+		return err
+	}
+	body, err := dataStore.Get(task.BodyRef)
+	if err != nil {
+		return fmt.Errorf("failed to load request body of %s, error %v", task.RequestID, err)
+	}
+	task.Body = body
+	return nil
+}
+
+// requestDataStore returns a copy of the DataStore configured for the request
+func (fRuntime *FlowRuntime) requestDataStore(flowName string, requestID string) (sdk.DataStore, error) {
+	if fRuntime.DataStore == nil {
+		dataStore, err := initDataStore(&fRuntime.RedisCfg, fRuntime.DataStoreBucketTemplate, fRuntime.Tenant,
+			fRuntime.StoreKeyCountsEnabled)
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize the DataStore, %v", err)
+		}
+		fRuntime.DataStore = dataStore
+	}
+	dataStore, err := fRuntime.DataStore.CopyStore()
+	if err != nil {
+		return nil, err
+	}
+	dataStore.Configure(flowName, requestID)
+	return dataStore, nil
+}
+
+// redisClient returns the runtime redis client, creating it when the runtime was not initialized
+func (fRuntime *FlowRuntime) redisClient() *redis.Client {
+	fRuntime.rdbOnce.Do(func() {
+		if fRuntime.rdb == nil {
+			fRuntime.rdb = fRuntime.RedisCfg.NewRedisClient()
+		}
+	})
+	return fRuntime.rdb
+}
+
 func (fRuntime *FlowRuntime) saveWorkerDetails(worker *Worker) error {
 	rdb := fRuntime.rdb
 	key := fmt.Sprintf("%s:%s", WorkerKeyInitial, worker.ID)
@@ -661,6 +1510,7 @@ func (fRuntime *FlowRuntime) deleteWorkerDetails(worker *Worker) error {
 
 func (fRuntime *FlowRuntime) saveFlowDetails(flows map[string]string) error {
 	rdb := fRuntime.rdb
+	fRuntime.archiveReplacedFlows(context.TODO(), flows)
 	for flowId, definition := range flows {
 		key := fmt.Sprintf("%s:%s", FlowKeyInitial, flowId)
 		rdb.Set(context.TODO(), key, definition, time.Second*RDBKeyTimeOut)
@@ -677,12 +1527,19 @@ func marshalWorker(worker *Worker) string {
 
 func makeRequestFromTask(task Task) *runtime.Request {
 	request := &runtime.Request{
-		FlowName:  task.FlowName,
-		RequestID: task.RequestID,
-		Body:      []byte(task.Body),
-		Header:    task.Header,
-		RawQuery:  task.RawQuery,
-		Query:     task.Query,
+		FlowName:     task.FlowName,
+		RequestID:    task.RequestID,
+		Body:         task.Body,
+		Header:       task.Header,
+		RawQuery:     task.RawQuery,
+		Query:        task.Query,
+		PartitionKey: task.PartitionKey,
+	}
+	if task.Deadline > 0 {
+		request.Deadline = time.Unix(0, task.Deadline)
+	}
+	if task.SubmittedAt > 0 {
+		request.SubmittedAt = time.Unix(0, task.SubmittedAt)
 	}
 	return request
 }
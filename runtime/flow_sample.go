@@ -0,0 +1,207 @@
+package runtime
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yuyang0/goflow/dag"
+	runtimeCommon "github.com/yuyang0/goflow/runtime/common"
+)
+
+// ErrNoInputSchema is returned when a sample is requested for a flow without input schema
+var ErrNoInputSchema = fmt.Errorf("flow has no input schema")
+
+// PathStep is a node of the execution path of a flow
+type PathStep struct {
+	Node     string   `json:"node"`
+	Branches []string `json:"branches,omitempty"` // the possible branches of a conditional node
+	Foreach  bool     `json:"foreach,omitempty"`
+	SubDag   bool     `json:"sub-dag,omitempty"`
+}
+
+// FlowSample is an example request of a flow
+type FlowSample struct {
+	Body          interface{} `json:"body"`
+	ExecutionPath []PathStep  `json:"execution-path,omitempty"`
+}
+
+// GenerateSample generates an example request body from the input schema of a flow, with dryRun
+// the execution path the request would take is included instead of executing the request
+func (fRuntime *FlowRuntime) GenerateSample(flowName string, dryRun bool) (*FlowSample, error) {
+	options, ok := fRuntime.getFlowOptions(flowName)
+	if !ok || len(options.InputSchema) == 0 {
+		return nil, ErrNoInputSchema
+	}
+	var schema interface{}
+	if err := json.Unmarshal(options.InputSchema, &schema); err != nil {
+		return nil, fmt.Errorf("failed to parse input schema, error %v", err)
+	}
+	sample := &FlowSample{Body: sampleFromSchema(schema)}
+
+	if dryRun {
+		handler, ok := fRuntime.Flows.Get(flowName)
+		if !ok {
+			return nil, fmt.Errorf("could not find handler for flow %s", flowName)
+		}
+		definition, err := getFlowDefinition(handler)
+		if err != nil {
+			return nil, fmt.Errorf("failed to export flow definition, error %v", err)
+		}
+		flowDag, err := dag.Parse([]byte(definition))
+		if err != nil {
+			return nil, err
+		}
+		sample.ExecutionPath = executionPath(flowDag)
+	}
+	return sample, nil
+}
+
+// executionPath walks the dag from its start node, nodes are visited once all their
+// dependencies are visited and in the order they were defined otherwise
+func executionPath(flowDag *dag.DAG) []PathStep {
+	inDegree := make(map[string]int, len(flowDag.Nodes))
+	for id, node := range flowDag.Nodes {
+		inDegree[id] = node.InDegree
+	}
+
+	var path []PathStep
+	var ready []string
+	if _, ok := flowDag.Node(flowDag.StartNode); ok {
+		ready = append(ready, flowDag.StartNode)
+	}
+	visited := make(map[string]bool)
+	for len(ready) > 0 {
+		sort.Slice(ready, func(i, j int) bool {
+			return flowDag.Nodes[ready[i]].Index < flowDag.Nodes[ready[j]].Index
+		})
+		id := ready[0]
+		ready = ready[1:]
+		node := flowDag.Nodes[id]
+		if visited[id] {
+			continue
+		}
+		visited[id] = true
+
+		step := PathStep{Node: id, Foreach: node.Foreach, SubDag: node.SubDAG != nil}
+		for branch := range node.ConditionalDAGs {
+			step.Branches = append(step.Branches, branch)
+		}
+		sort.Strings(step.Branches)
+		path = append(path, step)
+
+		for _, child := range node.Children {
+			inDegree[child]--
+			if _, ok := flowDag.Node(child); ok && inDegree[child] <= 0 {
+				ready = append(ready, child)
+			}
+		}
+	}
+	return path
+}
+
+// sampleFromSchema generates a value matching a json schema, it prefers the examples and defaults
+// of the schema and falls back to a filler appropriate for the type
+func sampleFromSchema(schema interface{}) interface{} {
+	definition, ok := schema.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	if example, ok := definition["example"]; ok {
+		return example
+	}
+	if examples, ok := definition["examples"].([]interface{}); ok && len(examples) > 0 {
+		return examples[0]
+	}
+	if value, ok := definition["default"]; ok {
+		return value
+	}
+	if value, ok := definition["const"]; ok {
+		return value
+	}
+	if values, ok := definition["enum"].([]interface{}); ok && len(values) > 0 {
+		return values[0]
+	}
+
+	schemaType, _ := definition["type"].(string)
+	if types, ok := definition["type"].([]interface{}); ok && len(types) > 0 {
+		schemaType, _ = types[0].(string)
+	}
+	if schemaType == "" {
+		if _, ok := definition["properties"]; ok {
+			schemaType = "object"
+		} else if _, ok := definition["items"]; ok {
+			schemaType = "array"
+		}
+	}
+
+	switch schemaType {
+	case "object":
+		object := make(map[string]interface{})
+		properties, _ := definition["properties"].(map[string]interface{})
+		for name, property := range properties {
+			object[name] = sampleFromSchema(property)
+		}
+		return object
+	case "array":
+		return []interface{}{sampleFromSchema(definition["items"])}
+	case "string":
+		return sampleString(definition)
+	case "integer":
+		if minimum, ok := definition["minimum"].(float64); ok {
+			return int64(minimum)
+		}
+		return 0
+	case "number":
+		if minimum, ok := definition["minimum"].(float64); ok {
+			return minimum
+		}
+		return 0.0
+	case "boolean":
+		return false
+	}
+	return nil
+}
+
+func sampleString(definition map[string]interface{}) string {
+	switch definition["format"] {
+	case "date-time":
+		return "2024-01-01T00:00:00Z"
+	case "date":
+		return "2024-01-01"
+	case "email":
+		return "user@example.com"
+	case "uri":
+		return "https://example.com"
+	case "uuid":
+		return "00000000-0000-0000-0000-000000000000"
+	}
+	return "string"
+}
+
+func flowSampleHandler(runtime *FlowRuntime) func(*gin.Context) {
+	fn := func(c *gin.Context) {
+		flowName, ok := flowNameParam(runtime, c)
+		if !ok {
+			return
+		}
+		if !runtime.authorizeRequest(c, nil, ActionDiagnose, flowName, "") {
+			return
+		}
+		dryRun, _ := strconv.ParseBool(c.Query("dry_run"))
+
+		sample, err := runtime.GenerateSample(flowName, dryRun)
+		if err == ErrNoInputSchema {
+			c.String(http.StatusNotFound, "flow %s has no input schema", flowName)
+			return
+		} else if err != nil {
+			runtimeCommon.HandleError(c.Writer, fmt.Sprintf("Failed to generate sample, %v", err))
+			return
+		}
+		c.JSON(http.StatusOK, sample)
+	}
+	return fn
+}
@@ -0,0 +1,81 @@
+package runtime
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/adjust/rmq/v5"
+)
+
+// ShutdownFlow stops a flow on this worker without affecting the other flows. It stops consuming from the
+// queues of the flow, push queues included, waits for the tasks being handled to complete or for ctx to be
+// done, then removes the flow from the runtime and deletes its definition from redis. The queued tasks are
+// kept for the other workers of the flow
+func (fRuntime *FlowRuntime) ShutdownFlow(ctx context.Context, flowName string) error {
+	flowName, err := fRuntime.resolveFlowName(flowName)
+	if err != nil {
+		return err
+	}
+	if _, ok := fRuntime.Flows.Get(flowName); !ok {
+		return fmt.Errorf("flow %s is not registered", flowName)
+	}
+
+	// the queues are kept until the tasks are drained, as they enqueue the continuations of their requests
+	fRuntime.queueMu.Lock()
+	var queues []rmq.Queue
+	if taskQueue, ok := fRuntime.taskQueues[flowName]; ok {
+		queues = append(queues, taskQueue)
+	}
+	queues = append(queues, fRuntime.pushQueues[flowName]...)
+	for _, partitionConsumer := range fRuntime.partitionConsumers[flowName] {
+		if partitionConsumer != nil {
+			queues = append(queues, partitionConsumer)
+		}
+	}
+	if failureQueue, ok := fRuntime.failureQueues[flowName]; ok {
+		queues = append(queues, failureQueue)
+	}
+	fRuntime.queueMu.Unlock()
+
+	stopped := make([]<-chan struct{}, 0, len(queues))
+	for _, queue := range queues {
+		stopped = append(stopped, queue.StopConsuming())
+	}
+	var drainErr error
+drain:
+	for _, done := range stopped {
+		select {
+		case <-done:
+		case <-ctx.Done():
+			drainErr = ctx.Err()
+			break drain
+		}
+	}
+
+	fRuntime.queueMu.Lock()
+	delete(fRuntime.taskQueues, flowName)
+	delete(fRuntime.pushQueues, flowName)
+	delete(fRuntime.partitionQueues, flowName)
+	delete(fRuntime.partitionConsumers, flowName)
+	delete(fRuntime.failureQueues, flowName)
+	fRuntime.Flows.Del(flowName)
+	fRuntime.flowOptionsMu.Lock()
+	delete(fRuntime.flowOptions, flowName)
+	fRuntime.flowOptionsMu.Unlock()
+	fRuntime.flowDefinitionVersionsMu.Lock()
+	delete(fRuntime.flowDefinitionVersions, flowName)
+	fRuntime.flowDefinitionVersionsMu.Unlock()
+	fRuntime.flowsVersion.Add(1)
+	fRuntime.queueMu.Unlock()
+
+	key := fmt.Sprintf("%s:%s", FlowKeyInitial, flowName)
+	if err := fRuntime.redisClient().Del(context.TODO(), key).Err(); err != nil {
+		return fmt.Errorf("failed to delete definition of flow %s, error %v", flowName, err)
+	}
+	if drainErr != nil {
+		return fmt.Errorf("flow %s shut down before its tasks were drained, %w", flowName, drainErr)
+	}
+
+	fRuntime.Logger.Log(fmt.Sprintf("[goflow] flow %s shut down", flowName))
+	return nil
+}
@@ -0,0 +1,117 @@
+package runtime
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	runtimeCommon "github.com/yuyang0/goflow/runtime/common"
+)
+
+const (
+	ThroughputKeyInitial = "goflow-throughput"
+
+	// ThroughputWindow is the rolling window over which the task throughput of a flow is measured
+	ThroughputWindow = 5 * time.Minute
+
+	// InfiniteFlushTime is returned by MeasureQueueFlushTime when the queued tasks are not being processed,
+	// time.Duration can't hold math.Inf so the largest duration stands for it
+	InfiniteFlushTime = time.Duration(math.MaxInt64)
+)
+
+// throughputKey returns the key of the per minute counter of acknowledged tasks of a flow
+func throughputKey(flowName string, at time.Time) string {
+	return fmt.Sprintf("%s:%s:%d", ThroughputKeyInitial, flowName, at.Unix()/60)
+}
+
+// addThroughput counts an acknowledged task of a flow in the current minute bucket, along with the writes of pipe
+func (fRuntime *FlowRuntime) addThroughput(ctx context.Context, pipe redis.Pipeliner, flowName string) {
+	key := throughputKey(flowName, fRuntime.clock().Now())
+	pipe.Incr(ctx, key)
+	pipe.Expire(ctx, key, ThroughputWindow+time.Minute)
+}
+
+// getThroughput returns the tasks acknowledged per second for a flow by all the workers over the ThroughputWindow,
+// the current minute bucket is excluded as it is still being filled
+func (fRuntime *FlowRuntime) getThroughput(ctx context.Context, flowName string) (float64, error) {
+	now := fRuntime.clock().Now()
+	buckets := int(ThroughputWindow / time.Minute)
+	keys := make([]string, 0, buckets)
+	for idx := 1; idx <= buckets; idx++ {
+		keys = append(keys, throughputKey(flowName, now.Add(-time.Duration(idx)*time.Minute)))
+	}
+
+	values, err := fRuntime.redisClient().MGet(ctx, keys...).Result()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get throughput of flow %s, error %v", flowName, err)
+	}
+	var total float64
+	for _, value := range values {
+		str, ok := value.(string)
+		if !ok {
+			continue
+		}
+		count, err := strconv.ParseFloat(str, 64)
+		if err != nil {
+			continue
+		}
+		total += count
+	}
+	return total / ThroughputWindow.Seconds(), nil
+}
+
+// MeasureQueueFlushTime estimates how long the workers need to process the tasks currently queued for a flow
+// at the recent throughput, it returns InfiniteFlushTime when nothing was processed within the ThroughputWindow
+func (fRuntime *FlowRuntime) MeasureQueueFlushTime(flowName string) (time.Duration, error) {
+	depths, err := fRuntime.GetQueueDepths(flowName)
+	if err != nil {
+		return 0, err
+	}
+	var queued int64
+	for _, depth := range depths {
+		queued += depth.Ready + depth.Unacked
+	}
+	if queued == 0 {
+		return 0, nil
+	}
+
+	throughput, err := fRuntime.getThroughput(context.TODO(), flowName)
+	if err != nil {
+		return 0, err
+	}
+	if throughput == 0 {
+		return InfiniteFlushTime, nil
+	}
+	seconds := float64(queued) / throughput
+	if seconds >= InfiniteFlushTime.Seconds() {
+		return InfiniteFlushTime, nil
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+func flushEstimateHandler(runtime *FlowRuntime) func(*gin.Context) {
+	fn := func(c *gin.Context) {
+		flowName, ok := flowNameParam(runtime, c)
+		if !ok {
+			return
+		}
+
+		estimate, err := runtime.MeasureQueueFlushTime(flowName)
+		if err != nil {
+			runtimeCommon.HandleError(c.Writer, fmt.Sprintf("Failed to estimate queue flush time, %v", err))
+			return
+		}
+		// json has no representation of infinity, null is returned when the consumers are stopped
+		var seconds interface{}
+		if estimate != InfiniteFlushTime {
+			seconds = estimate.Seconds()
+		}
+		c.JSON(http.StatusOK, gin.H{"seconds": seconds})
+	}
+	return fn
+}
@@ -0,0 +1,136 @@
+package runtime_test
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/yuyang0/goflow/core/sdk"
+	flow "github.com/yuyang0/goflow/flow/v1"
+	"github.com/yuyang0/goflow/runtime"
+	goflow "github.com/yuyang0/goflow/v1"
+)
+
+var errProducerCrash = errors.New("producer crashed")
+
+// streamFlow emits the items of the attempt of the producer, and records the branches executed and the aggregations
+type streamFlow struct {
+	mu           sync.Mutex
+	attempts     int
+	executed     map[string]int
+	emitErrors   []error
+	aggregations []map[string][]byte
+}
+
+func (sf *streamFlow) definition(items func(attempt int) ([]string, error), options ...flow.Option) runtime.FlowDefinitionHandler {
+	sf.executed = make(map[string]int)
+	return func(wf *flow.Workflow, _ *flow.Context) error {
+		options := append([]flow.Option{flow.Aggregator(func(results map[string][]byte) ([]byte, error) {
+			sf.mu.Lock()
+			defer sf.mu.Unlock()
+			sf.aggregations = append(sf.aggregations, results)
+			return []byte("done"), nil
+		})}, options...)
+		branch := wf.Dag().ForEachStreamBranch("items", func(nodeContext *sdk.NodeContext, _ []byte) error {
+			sf.mu.Lock()
+			sf.attempts++
+			attempt := sf.attempts
+			sf.mu.Unlock()
+			emitted, failure := items(attempt)
+			for _, item := range emitted {
+				if err := nodeContext.Emit([]byte(item)); err != nil {
+					sf.mu.Lock()
+					sf.emitErrors = append(sf.emitErrors, err)
+					sf.mu.Unlock()
+					return err
+				}
+			}
+			if failure == errProducerCrash {
+				panic(failure)
+			}
+			return failure
+		}, options...)
+		branch.Node("work", func(data []byte, _ map[string][]string) ([]byte, error) {
+			sf.mu.Lock()
+			defer sf.mu.Unlock()
+			sf.executed[string(data)]++
+			return data, nil
+		})
+		return nil
+	}
+}
+
+// TestForEachStreamResumesAfterCrash checks that a producer crashing halfway doesn't complete the foreach with the
+// branches emitted so far, and that its next execution only forwards the items not forwarded yet
+func TestForEachStreamResumesAfterCrash(t *testing.T) {
+	sf := &streamFlow{}
+	handler := sf.definition(func(attempt int) ([]string, error) {
+		if attempt == 1 {
+			return []string{"a", "b"}, errProducerCrash
+		}
+		return []string{"a", "b", "c"}, nil
+	})
+	// the panic of the producer is recovered by the crash tracking, leaving the state of the request as a crash does
+	fs := &goflow.FlowService{RetryCount: 1, PoisonThreshold: 3}
+	_, client := startWorker(t, fs, map[string]runtime.FlowDefinitionHandler{"stream": handler}, nil)
+	if err := client.Execute("stream", &goflow.Request{RequestId: "stream-request", Body: []byte("{}")}); err != nil {
+		t.Fatal(err)
+	}
+
+	eventually(t, 15*time.Second, func() bool {
+		sf.mu.Lock()
+		defer sf.mu.Unlock()
+		return len(sf.aggregations) > 0
+	}, "the foreach stream was not aggregated")
+	time.Sleep(500 * time.Millisecond)
+
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	if sf.attempts != 2 {
+		t.Fatalf("expected the producer to be executed twice, got %d", sf.attempts)
+	}
+	if len(sf.aggregations) != 1 {
+		t.Fatalf("expected the foreach to be aggregated once, got %d", len(sf.aggregations))
+	}
+	results := sf.aggregations[0]
+	if len(results) != 3 || string(results["0"]) != "a" || string(results["1"]) != "b" || string(results["2"]) != "c" {
+		t.Fatalf("expected the results of the 3 items keyed by their emission index, got %v", results)
+	}
+	for _, item := range []string{"a", "b", "c"} {
+		if sf.executed[item] != 1 {
+			t.Fatalf("expected the branch of %s to be executed once, got %d", item, sf.executed[item])
+		}
+	}
+}
+
+func TestForEachStreamMaxBranches(t *testing.T) {
+	sf := &streamFlow{}
+	handler := sf.definition(func(int) ([]string, error) {
+		return []string{"a", "b", "c"}, nil
+	}, flow.MaxBranches(2))
+	fs := &goflow.FlowService{}
+	_, client := startWorker(t, fs, map[string]runtime.FlowDefinitionHandler{"stream": handler}, nil)
+	if err := client.Execute("stream", &goflow.Request{RequestId: "stream-request", Body: []byte("{}")}); err != nil {
+		t.Fatal(err)
+	}
+
+	eventually(t, 15*time.Second, func() bool {
+		sf.mu.Lock()
+		defer sf.mu.Unlock()
+		return len(sf.emitErrors) > 0
+	}, "the producer was not capped")
+	time.Sleep(time.Second)
+
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	if !errors.Is(sf.emitErrors[0], sdk.ErrTooManyBranches) {
+		t.Fatalf("expected ErrTooManyBranches, got %v", sf.emitErrors[0])
+	}
+	if len(sf.aggregations) != 0 {
+		t.Fatalf("expected the capped foreach not to be aggregated, got %v", sf.aggregations)
+	}
+	if sf.executed["c"] != 0 {
+		t.Fatal("expected the item over the cap not to be executed")
+	}
+}
@@ -23,12 +23,28 @@ const (
 
 func executeRequestHandler(runtime *FlowRuntime, handler func(*runtimepkg.Response, *runtimepkg.Request, executor.Executor) error) func(*gin.Context) {
 	fn := func(c *gin.Context) {
-		flowName := c.Param(FlowNameParamName)
+		flowName, ok := flowNameParam(runtime, c)
+		if !ok {
+			return
+		}
 		body, err := ioutil.ReadAll(c.Request.Body)
 		if err != nil {
 			runtimeCommon.HandleError(c.Writer, fmt.Sprintf("failed to execute request, "+err.Error()))
 			return
 		}
+		// the principal is established with the signature of the body as received
+		if !runtime.authorizeRequest(c, body, ActionSubmit, flowName, c.Request.Header.Get(RequestIdHeaderName)) {
+			return
+		}
+		header, body, err := runtime.decodeBody(c.Request.Header, body)
+		if err != nil {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+		if err := runtime.validateRequestBody(flowName, body); err != nil {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
 
 		reqParams := make(map[string][]string)
 		for _, param := range c.Params {
@@ -42,17 +58,40 @@ func executeRequestHandler(runtime *FlowRuntime, handler func(*runtimepkg.Respon
 		response := &runtimepkg.Response{}
 		response.Header = make(map[string][]string)
 		request := &runtimepkg.Request{
-			Body:      body,
-			Header:    c.Request.Header,
-			FlowName:  flowName,
-			RequestID: c.Request.Header.Get(RequestIdHeaderName),
-			Query:     reqParams,
-			RawQuery:  c.Request.URL.RawQuery,
+			Body:         body,
+			Header:       header,
+			FlowName:     flowName,
+			RequestID:    c.Request.Header.Get(RequestIdHeaderName),
+			Query:        reqParams,
+			RawQuery:     c.Request.URL.RawQuery,
+			PartitionKey: c.Request.Header.Get(PartitionKeyHeader),
 		}
 
-		ex, err := runtime.CreateExecutor(request)
-		if err != nil {
-			runtimeCommon.HandleError(c.Writer, fmt.Sprintf("failed to execute request, "+err.Error()))
+		if value := c.Request.Header.Get(TimeoutHeader); value != "" {
+			timeout, err := runtime.parseRequestTimeout(value)
+			if err != nil {
+				c.String(http.StatusBadRequest, err.Error())
+				return
+			}
+			request.Deadline = runtime.clock().Now().Add(timeout)
+		}
+		for _, name := range []string{DeadlineHeader, RequestDeadlineHeader} {
+			value := c.Request.Header.Get(name)
+			if value == "" {
+				continue
+			}
+			deadline, err := runtime.parseRequestDeadline(value)
+			if err != nil {
+				c.String(http.StatusBadRequest, err.Error())
+				return
+			}
+			// the earliest of the timeout and the deadlines applies
+			if request.Deadline.IsZero() || deadline.Before(request.Deadline) {
+				request.Deadline = deadline
+			}
+		}
+		if err := runtime.validateRequest(flowName, request); err != nil {
+			c.String(http.StatusBadRequest, err.Error())
 			return
 		}
 
@@ -79,7 +118,70 @@ func executeRequestHandler(runtime *FlowRuntime, handler func(*runtimepkg.Respon
 			return
 		}
 
+		pending, err := runtime.failurePending(flowName, request.RequestID)
+		if err != nil {
+			runtimeCommon.HandleError(c.Writer, fmt.Sprintf("failed to execute request, %v", err))
+			return
+		}
+		if pending {
+			c.String(http.StatusConflict, "failure of a previous execution of request %s is being handled", request.RequestID)
+			return
+		}
+
+		// the request id is generated upfront, it is needed to record a rejection of the request, claim its
+		// idempotency key, store its deadline, record its submission and create its executor, e.g. to sample it
+		if request.RequestID == "" {
+			request.RequestID = xid.New().String()
+		}
+		hookedRequest, err := runtime.runPreExecHooks(request)
+		if err != nil {
+			runtime.rejectRequest(request, err)
+			c.String(http.StatusUnprocessableEntity, "request rejected, %v", err)
+			return
+		}
+		request = hookedRequest
+
+		if key := request.GetHeader(IdempotencyKeyHeader); key != "" {
+			claimed, err := runtime.claimRequest(request)
+			if err != nil {
+				runtimeCommon.HandleError(c.Writer, fmt.Sprintf("failed to claim request, %v", err))
+				return
+			}
+			if !claimed {
+				c.String(http.StatusConflict, "request skipped, idempotency key %s is claimed or processed by another request", key)
+				return
+			}
+		}
+
+		if !request.Deadline.IsZero() || runtime.requestTimeout(flowName) > 0 {
+			// the deadline is stored upfront so that it still applies once the request is paused and resumed
+			deadline, err := runtime.applyRequestDeadline(request)
+			if err != nil {
+				runtimeCommon.HandleError(c.Writer, fmt.Sprintf("failed to set request deadline, %v", err))
+				return
+			}
+			request.Deadline = deadline
+		}
+
+		if runtime.historySize(flowName) > 0 {
+			runtime.recordSubmission(request)
+		}
+
+		ex, err := runtime.CreateExecutor(request)
+		if err != nil {
+			runtimeCommon.HandleError(c.Writer, fmt.Sprintf("failed to execute request, "+err.Error()))
+			return
+		}
+		defer runtime.withDeadline(ex, request.Deadline)()
+
 		response.RequestID = request.RequestID
+		if runtime.SyncWriteMode == SyncWriteModeHeartbeat {
+			runtime.executeWithHeartbeat(c, request, response, func() error {
+				return handler(response, request, ex)
+			})
+			return
+		}
+		runtime.extendSyncWriteDeadline(c, request)
 		err = handler(response, request, ex)
 		if err != nil {
 			runtimeCommon.HandleError(c.Writer, fmt.Sprintf("request failed to be processed, %v", err))
@@ -100,7 +202,10 @@ func executeRequestHandler(runtime *FlowRuntime, handler func(*runtimepkg.Respon
 
 func stopRequestHandler(runtime *FlowRuntime) func(*gin.Context) {
 	fn := func(c *gin.Context) {
-		flowName := c.Param(FlowNameParamName)
+		flowName, ok := flowNameParam(runtime, c)
+		if !ok {
+			return
+		}
 		requestId := c.Param(RequestIdParamName)
 
 		request := &runtimepkg.Request{
@@ -112,6 +217,10 @@ func stopRequestHandler(runtime *FlowRuntime) func(*gin.Context) {
 			RawQuery:  c.Request.URL.RawQuery,
 		}
 
+		if !runtime.authorizeRequest(c, request.Body, ActionStop, flowName, requestId) {
+			return
+		}
+
 		err := runtime.Stop(flowName, request)
 		if err != nil {
 			log.Printf("Failed to submit stop request for requestId %s, error %v", requestId, err)
@@ -127,7 +236,10 @@ func stopRequestHandler(runtime *FlowRuntime) func(*gin.Context) {
 
 func pauseRequestHandler(runtime *FlowRuntime) func(*gin.Context) {
 	fn := func(c *gin.Context) {
-		flowName := c.Param(FlowNameParamName)
+		flowName, ok := flowNameParam(runtime, c)
+		if !ok {
+			return
+		}
 		requestId := c.Param(RequestIdParamName)
 
 		request := &runtimepkg.Request{
@@ -139,6 +251,10 @@ func pauseRequestHandler(runtime *FlowRuntime) func(*gin.Context) {
 			RawQuery:  c.Request.URL.RawQuery,
 		}
 
+		if !runtime.authorizeRequest(c, request.Body, ActionPause, flowName, requestId) {
+			return
+		}
+
 		err := runtime.Pause(flowName, request)
 		if err != nil {
 			log.Printf("Failed to submit pause request for requestId %s, error %v", requestId, err)
@@ -154,7 +270,10 @@ func pauseRequestHandler(runtime *FlowRuntime) func(*gin.Context) {
 
 func resumeRequestHandler(runtime *FlowRuntime) func(*gin.Context) {
 	fn := func(c *gin.Context) {
-		flowName := c.Param(FlowNameParamName)
+		flowName, ok := flowNameParam(runtime, c)
+		if !ok {
+			return
+		}
 		requestId := c.Param(RequestIdParamName)
 
 		request := &runtimepkg.Request{
@@ -166,6 +285,10 @@ func resumeRequestHandler(runtime *FlowRuntime) func(*gin.Context) {
 			RawQuery:  c.Request.URL.RawQuery,
 		}
 
+		if !runtime.authorizeRequest(c, request.Body, ActionResume, flowName, requestId) {
+			return
+		}
+
 		err := runtime.Resume(flowName, request)
 		if err != nil {
 			log.Printf("Failed to submit resume request for requestId %s, error %v", requestId, err)
@@ -181,24 +304,78 @@ func resumeRequestHandler(runtime *FlowRuntime) func(*gin.Context) {
 
 func requestStateHandler(runtime *FlowRuntime) func(*gin.Context) {
 	fn := func(c *gin.Context) {
-		// flowName := c.Param(FlowNameParamName)
-		// requestId := c.Param(RequestIdParamName)
-		// TODO: implement
-		c.Writer.WriteHeader(http.StatusInternalServerError)
-		c.Writer.Write([]byte("Not Implemented"))
-		return
+		flowName, ok := flowNameParam(runtime, c)
+		if !ok {
+			return
+		}
+		requestId := c.Param(RequestIdParamName)
+
+		state, err := runtime.GetState(flowName, requestId)
+		if err != nil {
+			log.Printf("Failed to get state for requestId %s, error %v", requestId, err)
+			runtimeCommon.HandleError(c.Writer, fmt.Sprintf("Failed to get request state, %v", err))
+			return
+		}
+		c.JSON(http.StatusOK, state)
 	}
 	return fn
 }
 
 func requestListHandler(runtime *FlowRuntime) func(*gin.Context) {
 	fn := func(c *gin.Context) {
-		// flowName := c.Param(FlowNameParamName)
-		// requestId := c.Param(RequestIdParamName)
-		// TODO: implement
-		c.Writer.WriteHeader(http.StatusInternalServerError)
-		c.Writer.Write([]byte("Not Implemented"))
-		return
+		flowName, ok := flowNameParam(runtime, c)
+		if !ok {
+			return
+		}
+
+		requests, err := runtime.ListRequests(c.Request.Context(), flowName)
+		if err != nil {
+			log.Printf("Failed to list requests for flow %s, error %v", flowName, err)
+			runtimeCommon.HandleError(c.Writer, fmt.Sprintf("Failed to list requests, %v", err))
+			return
+		}
+		c.JSON(http.StatusOK, requests)
+	}
+	return fn
+}
+
+func nodeRequestCountHandler(runtime *FlowRuntime) func(*gin.Context) {
+	fn := func(c *gin.Context) {
+		flowName, ok := flowNameParam(runtime, c)
+		if !ok {
+			return
+		}
+
+		counts, err := runtime.GetNodeRequestCounts(c.Request.Context(), flowName)
+		if err != nil {
+			log.Printf("Failed to count node requests for flow %s, error %v", flowName, err)
+			runtimeCommon.HandleError(c.Writer, fmt.Sprintf("Failed to count node requests, %v", err))
+			return
+		}
+		c.JSON(http.StatusOK, counts)
+	}
+	return fn
+}
+
+func requestDiffHandler(runtime *FlowRuntime) func(*gin.Context) {
+	fn := func(c *gin.Context) {
+		requestId1 := c.Query("r1")
+		requestId2 := c.Query("r2")
+		if requestId1 == "" || requestId2 == "" {
+			c.Writer.WriteHeader(http.StatusBadRequest)
+			c.Writer.Write([]byte("both r1 and r2 request ids must be provided"))
+			return
+		}
+
+		diff, err := runtime.DiffRequestBodies(c.Request.Context(), requestId1, requestId2)
+		if err != nil {
+			log.Printf("Failed to diff requests %s and %s, error %v", requestId1, requestId2, err)
+			runtimeCommon.HandleError(c.Writer, fmt.Sprintf("Failed to diff requests, %v", err))
+			return
+		}
+		c.Writer.Header().Set("Content-Type", "text/plain")
+		c.Writer.WriteHeader(http.StatusOK)
+		c.Writer.Write([]byte(diff))
 	}
 	return fn
 }
@@ -0,0 +1,130 @@
+package runtime
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	InFlightKeyInitial = "goflow-inflight"
+
+	// InFlightHeartbeatInterval is the interval a worker refreshes the heartbeat of its requests in flight at
+	InFlightHeartbeatInterval = 10 * time.Second
+	// InFlightTTL is how long a request stays in flight without heartbeat, e.g. once its worker died
+	InFlightTTL = 3 * InFlightHeartbeatInterval
+)
+
+// inFlightKey returns the sorted set of the requests of a flow in flight, scored by their last heartbeat
+func inFlightKey(flowName string) string {
+	return fmt.Sprintf("%s:%s", InFlightKeyInitial, flowName)
+}
+
+// inFlightRequests are the requests in flight on the worker, by flow
+type inFlightRequests struct {
+	mu       sync.Mutex
+	requests map[string]map[string]int // number of executions of each request of each flow
+}
+
+func (inFlight *inFlightRequests) add(flowName string, requestID string) {
+	inFlight.mu.Lock()
+	defer inFlight.mu.Unlock()
+	if inFlight.requests == nil {
+		inFlight.requests = make(map[string]map[string]int)
+	}
+	if inFlight.requests[flowName] == nil {
+		inFlight.requests[flowName] = make(map[string]int)
+	}
+	inFlight.requests[flowName][requestID]++
+}
+
+// remove returns whether the request is no more in flight on the worker
+func (inFlight *inFlightRequests) remove(flowName string, requestID string) bool {
+	inFlight.mu.Lock()
+	defer inFlight.mu.Unlock()
+	requests := inFlight.requests[flowName]
+	if requests[requestID]--; requests[requestID] > 0 {
+		return false
+	}
+	delete(requests, requestID)
+	if len(requests) == 0 {
+		delete(inFlight.requests, flowName)
+	}
+	return true
+}
+
+func (inFlight *inFlightRequests) snapshot() map[string][]string {
+	inFlight.mu.Lock()
+	defer inFlight.mu.Unlock()
+	snapshot := make(map[string][]string, len(inFlight.requests))
+	for flowName, requests := range inFlight.requests {
+		for requestID := range requests {
+			snapshot[flowName] = append(snapshot[flowName], requestID)
+		}
+	}
+	return snapshot
+}
+
+// trackInFlight records a request of a flow as in flight across all the workers, it returns the function
+// ending the tracking. A request executed again, e.g. retried, is counted once, and the request of a worker that
+// died is no more in flight after InFlightTTL
+func (fRuntime *FlowRuntime) trackInFlight(flowName string, requestID string) func() {
+	fRuntime.inFlight.add(flowName, requestID)
+	member := redis.Z{Score: float64(fRuntime.clock().Now().UnixMilli()), Member: requestID}
+	if err := fRuntime.redisClient().ZAdd(context.TODO(), inFlightKey(flowName), member).Err(); err != nil {
+		fRuntime.Logger.Log(fmt.Sprintf("[goflow] failed to track in flight request of flow %s, error %v", flowName, err))
+	}
+	return func() {
+		if !fRuntime.inFlight.remove(flowName, requestID) {
+			return
+		}
+		if err := fRuntime.redisClient().ZRem(context.TODO(), inFlightKey(flowName), requestID).Err(); err != nil {
+			fRuntime.Logger.Log(fmt.Sprintf("[goflow] failed to track in flight request of flow %s, error %v", flowName, err))
+		}
+	}
+}
+
+// refreshInFlight refreshes the heartbeat of the requests in flight on the worker
+func (fRuntime *FlowRuntime) refreshInFlight() {
+	snapshot := fRuntime.inFlight.snapshot()
+	if len(snapshot) == 0 {
+		return
+	}
+	now := float64(fRuntime.clock().Now().UnixMilli())
+	ctx := context.TODO()
+	pipe := fRuntime.redisClient().Pipeline()
+	for flowName, requestIDs := range snapshot {
+		members := make([]redis.Z, len(requestIDs))
+		for idx, requestID := range requestIDs {
+			members[idx] = redis.Z{Score: now, Member: requestID}
+		}
+		// only the requests still in flight are refreshed, not the ones ended in between
+		pipe.ZAddXX(ctx, inFlightKey(flowName), members...)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		fRuntime.Logger.Log(fmt.Sprintf("[goflow] failed to refresh in flight requests, error %v", err))
+	}
+}
+
+// GetInFlightCount returns the number of new requests of a flow being processed by the workers
+func (fRuntime *FlowRuntime) GetInFlightCount(flowName string) (int64, error) {
+	flowName, err := fRuntime.resolveFlowName(flowName)
+	if err != nil {
+		return 0, err
+	}
+	ctx := context.TODO()
+	key := inFlightKey(flowName)
+	expired := strconv.FormatInt(fRuntime.clock().Now().Add(-InFlightTTL).UnixMilli(), 10)
+	pipe := fRuntime.redisClient().Pipeline()
+	// the requests of the workers that died are dropped
+	pipe.ZRemRangeByScore(ctx, key, "-inf", "("+expired)
+	count := pipe.ZCard(ctx, key)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, fmt.Errorf("failed to get in flight requests of flow %s, error %v", flowName, err)
+	}
+	return count.Val(), nil
+}
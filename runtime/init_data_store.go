@@ -6,7 +6,14 @@ import (
 	"github.com/yuyang0/goflow/types"
 )
 
-func initDataStore(cfg *types.RedisConfig) (dataStore sdk.DataStore, err error) {
-	dataStore, err = redisDataStore.GetRedisDataStore(cfg)
-	return dataStore, err
+func initDataStore(cfg *types.RedisConfig, bucketTemplate string, tenant string, trackKeys bool) (dataStore sdk.DataStore, err error) {
+	if bucketTemplate == "" {
+		bucketTemplate = redisDataStore.DefaultBucketTemplate
+	}
+	dataStore, err = redisDataStore.GetRedisDataStoreWithTemplate(cfg, bucketTemplate, tenant)
+	if err != nil {
+		return nil, err
+	}
+	dataStore.(*redisDataStore.RedisDataStore).TrackKeys = trackKeys
+	return dataStore, nil
 }
@@ -1,12 +1,23 @@
 package runtime
 
 import (
+	"time"
+
 	redisStateStore "github.com/yuyang0/goflow/core/redis-statestore"
 	"github.com/yuyang0/goflow/core/sdk"
 	"github.com/yuyang0/goflow/types"
 )
 
-func initStateStore(cfg *types.RedisConfig) (stateStore sdk.StateStore, err error) {
+func initStateStore(cfg *types.RedisConfig, strongConsistency bool, retryCount int, retryBackoff time.Duration,
+	trackKeys bool) (stateStore sdk.StateStore, err error) {
 	stateStore, err = redisStateStore.GetRedisStateStore(cfg)
-	return stateStore, err
+	if err != nil {
+		return nil, err
+	}
+	redisStore := stateStore.(*redisStateStore.RedisStateStore)
+	redisStore.StrongConsistency = strongConsistency
+	redisStore.RetryCount = retryCount
+	redisStore.RetryBackoff = retryBackoff
+	redisStore.TrackKeys = trackKeys
+	return stateStore, nil
 }
@@ -0,0 +1,37 @@
+package runtime_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/alphadose/haxmap"
+	runtimepkg "github.com/yuyang0/goflow/core/runtime"
+	"github.com/yuyang0/goflow/runtime"
+	"github.com/yuyang0/goflow/types"
+)
+
+// TestMethodsBeforeInit checks that the runtime methods called before Init return an error instead of panicking,
+// but for the ones also used by the clients, which don't initialize the runtime
+func TestMethodsBeforeInit(t *testing.T) {
+	mr := miniredis.RunT(t)
+	fRuntime := &runtime.FlowRuntime{
+		Flows:    haxmap.New[string, runtime.FlowDefinitionHandler](),
+		RedisCfg: types.RedisConfig{Addr: mr.Addr()},
+	}
+
+	err := fRuntime.Register(map[string]runtime.FlowDefinitionHandler{"early": echoFlow})
+	if err == nil || !strings.Contains(err.Error(), "not initialized") {
+		t.Fatalf("expected Register to fail as the runtime is not initialized, got %v", err)
+	}
+	err = fRuntime.EnterWorkerMode()
+	if err == nil || !strings.Contains(err.Error(), "not initialized") {
+		t.Fatalf("expected EnterWorkerMode to fail as the runtime is not initialized, got %v", err)
+	}
+	if err := fRuntime.ExitWorkerMode(); err != nil {
+		t.Fatalf("expected ExitWorkerMode to do nothing as the runtime never entered the worker mode, got %v", err)
+	}
+	if err := fRuntime.Execute("early", &runtimepkg.Request{Body: []byte("{}")}); err != nil {
+		t.Fatalf("expected a client runtime to queue the request without Init, got %v", err)
+	}
+}
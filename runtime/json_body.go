@@ -0,0 +1,93 @@
+package runtime
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+	"unicode/utf8"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// JSONBodyKeyInitial prefixes the keys of the JSON validation of the flows, so that the runtimes without the
+	// flow registered, e.g. the clients, enforce it
+	JSONBodyKeyInitial = "goflow-json-body"
+
+	jsonBodyRequired = "required"
+	jsonBodySkipped  = "skipped"
+)
+
+// ErrInvalidJSON is returned when a flow requires a json body and the request body is not valid UTF-8 json
+var ErrInvalidJSON = errors.New("request body is not valid json")
+
+func jsonBodyKey(flowName string) string {
+	return fmt.Sprintf("%s:%s", JSONBodyKeyInitial, flowName)
+}
+
+// jsonBodyValidation returns the JSON validation set by the options of a flow, empty if none
+func jsonBodyValidation(options FlowOptions) string {
+	if options.SkipJSONValidation {
+		return jsonBodySkipped
+	}
+	if options.RequireJSONBody {
+		return jsonBodyRequired
+	}
+	return ""
+}
+
+// saveJSONBodyValidations stores the JSON validation of the local flows which options set one
+func (fRuntime *FlowRuntime) saveJSONBodyValidations(flowNames []string) error {
+	ctx := context.TODO()
+	pipe := fRuntime.redisClient().Pipeline()
+	for _, flowName := range flowNames {
+		options, _ := fRuntime.getFlowOptions(flowName)
+		if validation := jsonBodyValidation(options); validation != "" {
+			pipe.Set(ctx, jsonBodyKey(flowName), validation, time.Second*RDBKeyTimeOut)
+		}
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// requiresJSONBody checks if the request bodies of a flow must be valid json, SkipJSONValidation
+// of the flow takes precedence over the RequireJSONBody of the runtime. The options of a flow that is
+// not registered on the runtime are the ones stored by its workers
+func (fRuntime *FlowRuntime) requiresJSONBody(flowName string) (bool, error) {
+	validation := ""
+	registered := false
+	if fRuntime.Flows != nil {
+		_, registered = fRuntime.Flows.Get(flowName)
+	}
+	if registered {
+		options, _ := fRuntime.getFlowOptions(flowName)
+		validation = jsonBodyValidation(options)
+	} else {
+		stored, err := fRuntime.redisClient().Get(context.TODO(), jsonBodyKey(flowName)).Result()
+		if err != nil && err != redis.Nil {
+			return false, fmt.Errorf("failed to get json validation of flow %s, error %v", flowName, err)
+		}
+		validation = stored
+	}
+	if validation == jsonBodySkipped {
+		return false, nil
+	}
+	return fRuntime.RequireJSONBody || validation == jsonBodyRequired, nil
+}
+
+// validateRequestBody returns ErrInvalidJSON when the flow requires a json body and the body is not
+func (fRuntime *FlowRuntime) validateRequestBody(flowName string, body []byte) error {
+	if utf8.Valid(body) && json.Valid(body) {
+		return nil
+	}
+	required, err := fRuntime.requiresJSONBody(flowName)
+	if err != nil {
+		return err
+	}
+	if required {
+		return fmt.Errorf("%w, flow %s requires a json body", ErrInvalidJSON, flowName)
+	}
+	return nil
+}
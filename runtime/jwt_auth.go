@@ -0,0 +1,404 @@
+package runtime
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"hash"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yuyang0/goflow/runtime/clock"
+)
+
+const (
+	PrincipalMethodJWT = "jwt"
+
+	AuthorizationHeader   = "Authorization"
+	WWWAuthenticateHeader = "WWW-Authenticate"
+
+	// DefaultJWKSRefreshInterval is the interval the JWKS is fetched again at when not configured
+	DefaultJWKSRefreshInterval = time.Hour
+	// JWKSMinRefreshInterval bounds how often the JWKS is fetched for a token signed with an unknown key
+	JWKSMinRefreshInterval = time.Minute
+	// JWTClockSkew is the tolerance applied to the expiration and not before times of a token
+	JWTClockSkew = 30 * time.Second
+
+	principalContextKey = "goflow-principal"
+)
+
+// jwtUnauthenticatedPaths are the routes served without a bearer token, the probes of the orchestrators
+var jwtUnauthenticatedPaths = map[string]bool{
+	"/readyz":  true,
+	"/version": true,
+}
+
+// ErrInvalidToken is returned when a bearer token is malformed, expired or not signed by a trusted key
+var ErrInvalidToken = errors.New("invalid token")
+
+// JWTConfig enables the authentication of the http requests with JWT bearer tokens, the tokens are verified
+// with the keys of the JWKS at JWKSURL or with PublicKey
+type JWTConfig struct {
+	Issuer              string        // expected iss claim, not checked if empty
+	Audience            string        // expected in the aud claim, not checked if empty
+	JWKSURL             string        // url of the JSON Web Key Set the tokens are signed with
+	PublicKey           string        // PEM encoded public key or certificate, used when JWKSURL is not set
+	JWKSRefreshInterval time.Duration // interval at which the JWKS is fetched again, an hour by default
+}
+
+// jwtVerifier verifies the bearer tokens of the http requests
+type jwtVerifier struct {
+	config    JWTConfig
+	client    *http.Client
+	staticKey crypto.PublicKey
+	clock     clock.Clock // the time the claims and the JWKS cache are checked at
+
+	mu        sync.Mutex
+	keys      map[string]crypto.PublicKey // keys of the JWKS by kid
+	fetchedAt time.Time
+	fetching  chan struct{} // closed once the fetch of the JWKS in progress completes, nil if none
+}
+
+func newJWTVerifier(config *JWTConfig, c clock.Clock) (*jwtVerifier, error) {
+	verifier := &jwtVerifier{config: *config, client: &http.Client{Timeout: 10 * time.Second}, clock: c}
+	if config.JWKSURL != "" {
+		return verifier, nil
+	}
+	if config.PublicKey == "" {
+		return nil, fmt.Errorf("invalid jwt config, either JWKSURL or PublicKey must be provided")
+	}
+	key, err := parsePEMPublicKey(config.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid jwt config, %v", err)
+	}
+	verifier.staticKey = key
+	return verifier, nil
+}
+
+func parsePEMPublicKey(data string) (crypto.PublicKey, error) {
+	block, _ := pem.Decode([]byte(data))
+	if block == nil {
+		return nil, fmt.Errorf("public key is not PEM encoded")
+	}
+	if block.Type == "CERTIFICATE" {
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse certificate, error %v", err)
+		}
+		return cert.PublicKey, nil
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse public key, error %v", err)
+	}
+	return key, nil
+}
+
+// Verify checks the signature and the claims of a token and returns its principal,
+// the subject along with the claims of the token
+func (verifier *jwtVerifier) Verify(token string) (Principal, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return Principal{}, fmt.Errorf("%w, malformed token", ErrInvalidToken)
+	}
+	header := struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}{}
+	if err := decodeTokenSegment(parts[0], &header); err != nil {
+		return Principal{}, fmt.Errorf("%w, malformed header, %v", ErrInvalidToken, err)
+	}
+	claims := make(map[string]interface{})
+	if err := decodeTokenSegment(parts[1], &claims); err != nil {
+		return Principal{}, fmt.Errorf("%w, malformed claims, %v", ErrInvalidToken, err)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return Principal{}, fmt.Errorf("%w, malformed signature", ErrInvalidToken)
+	}
+
+	key, err := verifier.key(header.Kid)
+	if err != nil {
+		return Principal{}, fmt.Errorf("%w, %v", ErrInvalidToken, err)
+	}
+	if err := verifySignature(header.Alg, key, parts[0]+"."+parts[1], signature); err != nil {
+		return Principal{}, fmt.Errorf("%w, %v", ErrInvalidToken, err)
+	}
+	if err := verifier.verifyClaims(claims); err != nil {
+		return Principal{}, fmt.Errorf("%w, %v", ErrInvalidToken, err)
+	}
+
+	subject, _ := claims["sub"].(string)
+	return Principal{Name: subject, Method: PrincipalMethodJWT, Claims: claims}, nil
+}
+
+func decodeTokenSegment(segment string, value interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, value)
+}
+
+func (verifier *jwtVerifier) verifyClaims(claims map[string]interface{}) error {
+	now := verifier.clock.Now()
+	if exp, ok := claims["exp"].(float64); ok && now.After(time.Unix(int64(exp), 0).Add(JWTClockSkew)) {
+		return fmt.Errorf("token is expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(JWTClockSkew).Before(time.Unix(int64(nbf), 0)) {
+		return fmt.Errorf("token is not valid yet")
+	}
+	if verifier.config.Issuer != "" {
+		if issuer, _ := claims["iss"].(string); issuer != verifier.config.Issuer {
+			return fmt.Errorf("unexpected issuer %q", issuer)
+		}
+	}
+	if verifier.config.Audience != "" {
+		found := false
+		switch audience := claims["aud"].(type) {
+		case string:
+			found = audience == verifier.config.Audience
+		case []interface{}:
+			for _, value := range audience {
+				if value == verifier.config.Audience {
+					found = true
+				}
+			}
+		}
+		if !found {
+			return fmt.Errorf("token is not intended for audience %q", verifier.config.Audience)
+		}
+	}
+	return nil
+}
+
+func verifySignature(alg string, key crypto.PublicKey, signed string, signature []byte) error {
+	var hasher hash.Hash
+	var hashType crypto.Hash
+	switch alg {
+	case "RS256", "ES256":
+		hasher, hashType = sha256.New(), crypto.SHA256
+	case "RS384", "ES384":
+		hasher, hashType = sha512.New384(), crypto.SHA384
+	case "RS512", "ES512":
+		hasher, hashType = sha512.New(), crypto.SHA512
+	default:
+		return fmt.Errorf("unsupported signing algorithm %q", alg)
+	}
+	hasher.Write([]byte(signed))
+	digest := hasher.Sum(nil)
+
+	switch publicKey := key.(type) {
+	case *rsa.PublicKey:
+		if alg[0] != 'R' {
+			return fmt.Errorf("signing algorithm %s does not match the rsa key", alg)
+		}
+		if err := rsa.VerifyPKCS1v15(publicKey, hashType, digest, signature); err != nil {
+			return fmt.Errorf("invalid signature")
+		}
+	case *ecdsa.PublicKey:
+		size := (publicKey.Curve.Params().BitSize + 7) / 8
+		if alg[0] != 'E' || len(signature) != 2*size {
+			return fmt.Errorf("signing algorithm %s does not match the ecdsa key", alg)
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(publicKey, digest, r, s) {
+			return fmt.Errorf("invalid signature")
+		}
+	default:
+		return fmt.Errorf("unsupported key type %T", key)
+	}
+	return nil
+}
+
+// key returns the key a token is signed with, the JWKS is fetched again when expired or when the key is not
+// known, at most every JWKSMinRefreshInterval. The JWKS is fetched by a single caller outside of the lock,
+// the others use the cached keys or wait for the fetch when they don't know the key
+func (verifier *jwtVerifier) key(kid string) (crypto.PublicKey, error) {
+	if verifier.staticKey != nil {
+		return verifier.staticKey, nil
+	}
+
+	verifier.mu.Lock()
+	refreshInterval := verifier.config.JWKSRefreshInterval
+	if refreshInterval <= 0 {
+		refreshInterval = DefaultJWKSRefreshInterval
+	}
+	key, ok := verifier.findKey(kid)
+	sinceFetch := verifier.clock.Now().Sub(verifier.fetchedAt)
+	stale := (!ok && sinceFetch > JWKSMinRefreshInterval) || sinceFetch > refreshInterval
+	fetching := verifier.fetching
+	if stale && fetching == nil {
+		fetching = make(chan struct{})
+		verifier.fetching = fetching
+		verifier.mu.Unlock()
+
+		keys, err := verifier.fetchJWKS()
+
+		verifier.mu.Lock()
+		// the cached keys are kept until the JWKS can be fetched again
+		if err == nil {
+			verifier.keys = keys
+			verifier.fetchedAt = verifier.clock.Now()
+		}
+		verifier.fetching = nil
+		close(fetching)
+		key, ok = verifier.findKey(kid)
+		verifier.mu.Unlock()
+		if !ok && err != nil {
+			return nil, err
+		}
+	} else {
+		verifier.mu.Unlock()
+		if !ok && fetching != nil {
+			// the key may be in the JWKS being fetched
+			<-fetching
+			verifier.mu.Lock()
+			key, ok = verifier.findKey(kid)
+			verifier.mu.Unlock()
+		}
+	}
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	return key, nil
+}
+
+// findKey returns the key of a kid, or the single key of the JWKS when the token has no kid
+func (verifier *jwtVerifier) findKey(kid string) (crypto.PublicKey, bool) {
+	if kid == "" && len(verifier.keys) == 1 {
+		for _, key := range verifier.keys {
+			return key, true
+		}
+	}
+	key, ok := verifier.keys[kid]
+	return key, ok
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (verifier *jwtVerifier) fetchJWKS() (map[string]crypto.PublicKey, error) {
+	resp, err := verifier.client.Get(verifier.config.JWKSURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch jwks, error %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch jwks, status %d", resp.StatusCode)
+	}
+	jwks := struct {
+		Keys []jsonWebKey `json:"keys"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&jwks); err != nil {
+		return nil, fmt.Errorf("failed to parse jwks, error %v", err)
+	}
+
+	keys := make(map[string]crypto.PublicKey, len(jwks.Keys))
+	for _, jwk := range jwks.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			// keys of unsupported types are skipped
+			continue
+		}
+		keys[jwk.Kid] = key
+	}
+	return keys, nil
+}
+
+func (jwk *jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch jwk.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch jwk.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %s", jwk.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %s", jwk.Kty)
+	}
+}
+
+// jwtAuthMiddleware authenticates the requests with their bearer token, the principal of the token is used by
+// the Authorizer. The requests without a token are rejected, but for the probes of jwtUnauthenticatedPaths
+func jwtAuthMiddleware(runtime *FlowRuntime) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		authorization := c.Request.Header.Get(AuthorizationHeader)
+		if !strings.HasPrefix(authorization, "Bearer ") {
+			if jwtUnauthenticatedPaths[c.FullPath()] {
+				c.Next()
+				return
+			}
+			c.Header(WWWAuthenticateHeader, `Bearer realm="goflow"`)
+			c.String(http.StatusUnauthorized, "a bearer token is required")
+			c.Abort()
+			return
+		}
+		if runtime.jwtVerifier == nil {
+			c.String(http.StatusServiceUnavailable, "jwt authentication is not initialized")
+			c.Abort()
+			return
+		}
+
+		principal, err := runtime.jwtVerifier.Verify(strings.TrimSpace(strings.TrimPrefix(authorization, "Bearer ")))
+		if err != nil {
+			description := strings.ReplaceAll(err.Error(), `"`, `'`)
+			c.Header(WWWAuthenticateHeader, fmt.Sprintf(`Bearer realm="goflow", error="invalid_token", error_description="%s"`, description))
+			c.String(http.StatusUnauthorized, err.Error())
+			c.Abort()
+			return
+		}
+		c.Set(principalContextKey, principal)
+		c.Next()
+	}
+}
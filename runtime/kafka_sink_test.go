@@ -0,0 +1,50 @@
+package runtime_test
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/yuyang0/goflow/eventhandler"
+	"github.com/yuyang0/goflow/runtime"
+	goflow "github.com/yuyang0/goflow/v1"
+)
+
+// recordingProducer records the events written to kafka by type
+type recordingProducer struct {
+	mu     sync.Mutex
+	events map[string]int
+}
+
+func (producer *recordingProducer) WriteMessages(ctx context.Context, msgs ...eventhandler.KafkaMessage) error {
+	producer.mu.Lock()
+	defer producer.mu.Unlock()
+	for _, msg := range msgs {
+		event := &eventhandler.Event{}
+		if err := json.Unmarshal(msg.Value, event); err == nil {
+			producer.events[event.Type]++
+		}
+	}
+	return nil
+}
+
+// TestKafkaSinksReceiveEvents checks that the lifecycle events of the requests are written by the configured
+// KafkaSinks, without EnableMonitoring
+func TestKafkaSinksReceiveEvents(t *testing.T) {
+	producer := &recordingProducer{events: map[string]int{}}
+	fs := &goflow.FlowService{KafkaSinks: []eventhandler.KafkaConfig{
+		{Topic: "events", Producer: producer, BatchSize: 1},
+	}}
+	_, client := startWorker(t, fs, map[string]runtime.FlowDefinitionHandler{"published": echoFlow}, nil)
+	if err := client.Execute("published", &goflow.Request{Body: []byte("{}")}); err != nil {
+		t.Fatal(err)
+	}
+
+	eventually(t, 5*time.Second, func() bool {
+		producer.mu.Lock()
+		defer producer.mu.Unlock()
+		return producer.events[eventhandler.EventRequestStart] > 0 && producer.events[eventhandler.EventRequestEnd] > 0
+	}, "the lifecycle events of the request were not written to kafka")
+}
@@ -0,0 +1,49 @@
+package runtime
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	LeaderKeyInitial = "goflow-leader"
+)
+
+// renewLeaseScript extends the lease of a leader only if it is still held by the same worker
+var renewLeaseScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+// acquireLeadership acquires or renews the leadership of a role for the worker, the leadership
+// is a lease that expires after ttl unless renewed, so a dead leader is replaced
+func (fRuntime *FlowRuntime) acquireLeadership(ctx context.Context, role string, ttl time.Duration) (bool, error) {
+	rdb := fRuntime.redisClient()
+	key := fRuntime.leaderKey(role)
+
+	acquired, err := rdb.SetNX(ctx, key, fRuntime.WorkerID(), ttl).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to acquire leadership of %s, error %v", role, err)
+	}
+	if acquired {
+		return true, nil
+	}
+
+	renewed, err := renewLeaseScript.Run(ctx, rdb, []string{key}, fRuntime.WorkerID(), ttl.Milliseconds()).Int()
+	if err != nil {
+		return false, fmt.Errorf("failed to renew leadership of %s, error %v", role, err)
+	}
+	return renewed == 1, nil
+}
+
+func (fRuntime *FlowRuntime) leaderKey(role string) string {
+	if fRuntime.Namespace == "" {
+		return fmt.Sprintf("%s:%s", LeaderKeyInitial, role)
+	}
+	return fmt.Sprintf("%s:%s:%s", LeaderKeyInitial, fRuntime.Namespace, role)
+}
@@ -0,0 +1,53 @@
+package runtime
+
+import (
+	"github.com/yuyang0/goflow/core/runtime"
+)
+
+// PauseLocal pauses a request directly in this process when the runtime is a worker of the flow,
+// otherwise it falls back to Pause which queues the pause request.
+//
+// A local pause is applied immediately instead of in queue order, so it may be applied before a pause,
+// resume or stop queued earlier for the same request. Like the queued path it doesn't interrupt
+// a node executing on another worker, the request is paused once that node completes
+func (fRuntime *FlowRuntime) PauseLocal(flowName string, request *runtime.Request) error {
+	return fRuntime.controlLocal(flowName, request, fRuntime.handlePauseRequest, fRuntime.Pause)
+}
+
+// ResumeLocal resumes a request directly in this process when the runtime is a worker of the flow,
+// otherwise it falls back to Resume. The consistency implications are the same as PauseLocal
+func (fRuntime *FlowRuntime) ResumeLocal(flowName string, request *runtime.Request) error {
+	return fRuntime.controlLocal(flowName, request, fRuntime.handleResumeRequest, fRuntime.Resume)
+}
+
+// StopLocal stops a request directly in this process when the runtime is a worker of the flow,
+// otherwise it falls back to Stop. The consistency implications are the same as PauseLocal
+func (fRuntime *FlowRuntime) StopLocal(flowName string, request *runtime.Request) error {
+	return fRuntime.controlLocal(flowName, request, fRuntime.handleStopRequest, fRuntime.Stop)
+}
+
+func (fRuntime *FlowRuntime) controlLocal(flowName string, request *runtime.Request,
+	handle func(*runtime.Request) error, enqueue func(string, *runtime.Request) error) error {
+
+	flowName, err := fRuntime.resolveFlowName(flowName)
+	if err != nil {
+		return err
+	}
+	if !fRuntime.isWorkerOf(flowName) {
+		return enqueue(flowName, request)
+	}
+
+	request.FlowName = flowName
+	if request.Header == nil {
+		request.Header = make(map[string][]string)
+	}
+	return handle(request)
+}
+
+// isWorkerOf reports whether the runtime is consuming the tasks of the flow
+func (fRuntime *FlowRuntime) isWorkerOf(flowName string) bool {
+	fRuntime.queueMu.Lock()
+	defer fRuntime.queueMu.Unlock()
+	_, ok := fRuntime.taskQueues[flowName]
+	return fRuntime.workerMode.Load() && ok
+}
@@ -0,0 +1,64 @@
+package runtime_test
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	flow "github.com/yuyang0/goflow/flow/v1"
+	"github.com/yuyang0/goflow/runtime"
+	goflow "github.com/yuyang0/goflow/v1"
+)
+
+// TestMaxContinuationsFailsRequest checks that a request going over MaxContinuations is failed with the limit error,
+// its failure handler being called, instead of executing its next node
+func TestMaxContinuationsFailsRequest(t *testing.T) {
+	var mu sync.Mutex
+	executed := make(map[string]int)
+	var failures []error
+	handler := func(wf *flow.Workflow, _ *flow.Context) error {
+		dag := wf.Dag()
+		for _, node := range []string{"first", "second", "third"} {
+			node := node
+			dag.Node(node, func(data []byte, _ map[string][]string) ([]byte, error) {
+				mu.Lock()
+				defer mu.Unlock()
+				executed[node]++
+				return data, nil
+			})
+		}
+		dag.Edge("first", "second")
+		dag.Edge("second", "third")
+		wf.OnFailure(func(err error) ([]byte, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			failures = append(failures, err)
+			return nil, err
+		})
+		return nil
+	}
+
+	// the second node is the first continuation, the third one the second
+	fs := &goflow.FlowService{MaxContinuations: 1}
+	_, client := startWorker(t, fs, map[string]runtime.FlowDefinitionHandler{"looping": handler}, nil)
+	if err := client.Execute("looping", &goflow.Request{Body: []byte("{}")}); err != nil {
+		t.Fatal(err)
+	}
+
+	eventually(t, 15*time.Second, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(failures) > 0
+	}, "the request over the limit was not failed")
+	time.Sleep(500 * time.Millisecond)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(failures) != 1 || !strings.Contains(failures[0].Error(), runtime.ErrMaxContinuations.Error()) {
+		t.Fatalf("expected the request to fail once with the limit error, got %v", failures)
+	}
+	if executed["first"] != 1 || executed["second"] != 1 || executed["third"] != 0 {
+		t.Fatalf("expected the nodes after the limit not to be executed, got %v", executed)
+	}
+}
@@ -0,0 +1,81 @@
+package runtime
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yuyang0/goflow/metrics"
+	runtimeCommon "github.com/yuyang0/goflow/runtime/common"
+)
+
+var (
+	tasksCounter = metrics.NewCounterVec("goflow_tasks_total",
+		"Tasks consumed by the worker across all flows")
+	queueDepthGauge = metrics.NewGaugeVec("goflow_queue_depth",
+		"Tasks ready to be consumed in the queues of the flow", "flow")
+	registeredFlowsGauge = metrics.NewGaugeVec("goflow_registered_flows",
+		"Flows registered on the worker")
+)
+
+// refreshGauges updates the gauges that are computed on collection
+func (fRuntime *FlowRuntime) refreshGauges() {
+	if fRuntime.Flows == nil {
+		return
+	}
+	registeredFlowsGauge.Set(float64(fRuntime.FlowCount()))
+	for _, flowName := range fRuntime.ListFlows() {
+		depths, err := fRuntime.GetQueueDepths(flowName)
+		if err != nil {
+			continue
+		}
+		var ready int64
+		for _, depth := range depths {
+			ready += depth.Ready
+		}
+		queueDepthGauge.Set(float64(ready), flowName)
+	}
+	if fRuntime.StoreKeyCountsEnabled {
+		fRuntime.refreshStoreKeyGauges()
+	}
+}
+
+// ExportMetricsAsJSON returns the metrics as a flat json object along with the collection
+// time in `ts`, for deployments that don't use prometheus
+func (fRuntime *FlowRuntime) ExportMetricsAsJSON() ([]byte, error) {
+	fRuntime.refreshGauges()
+
+	snapshot := make(map[string]interface{})
+	for name, value := range metrics.Snapshot() {
+		snapshot[name] = value
+	}
+	snapshot["ts"] = fRuntime.clock().Now().Unix()
+
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal metrics, error %v", err)
+	}
+	return data, nil
+}
+
+func prometheusMetricsHandler(runtime *FlowRuntime) func(*gin.Context) {
+	handler := metrics.Handler()
+	fn := func(c *gin.Context) {
+		runtime.refreshGauges()
+		handler.ServeHTTP(c.Writer, c.Request)
+	}
+	return fn
+}
+
+func jsonMetricsHandler(runtime *FlowRuntime) func(*gin.Context) {
+	fn := func(c *gin.Context) {
+		data, err := runtime.ExportMetricsAsJSON()
+		if err != nil {
+			runtimeCommon.HandleError(c.Writer, fmt.Sprintf("Failed to export metrics, %v", err))
+			return
+		}
+		c.Data(http.StatusOK, "application/json", data)
+	}
+	return fn
+}
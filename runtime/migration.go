@@ -0,0 +1,97 @@
+package runtime
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/yuyang0/goflow/core/sdk/executor"
+)
+
+const (
+	WorkerQueueInitial = "goflow-worker-queue"
+)
+
+func workerQueueId(workerID string) string {
+	return fmt.Sprintf("%s:%s", WorkerQueueInitial, workerID)
+}
+
+// initializeWorkerQueue starts consuming the queue of tasks routed to this worker only.
+// Caller must hold queueMu
+func (fRuntime *FlowRuntime) initializeWorkerQueue() error {
+	if fRuntime.workerQueue != nil {
+		return nil
+	}
+	workerQueue, err := fRuntime.rmqConnection.OpenQueue(workerQueueId(fRuntime.WorkerID()))
+	if err != nil {
+		return fmt.Errorf("failed to open worker queue, error %v", err)
+	}
+	err = workerQueue.StartConsuming(10, time.Second)
+	if err != nil {
+		return fmt.Errorf("failed to start consumer worker queue, error %v", err)
+	}
+	// the queue also receives the requests routed to the worker by sticky routing
+	consumers := fRuntime.Concurrency
+	if consumers < 1 {
+		consumers = 1
+	}
+	for idx := 0; idx < consumers; idx++ {
+		_, err = workerQueue.AddConsumer(fmt.Sprintf("worker-consumer-%d", idx),
+			fRuntime.retryConsumer(workerQueueLocation(fRuntime.WorkerID()), -1, false))
+		if err != nil {
+			return fmt.Errorf("failed to add worker consumer, error %v", err)
+		}
+	}
+	fRuntime.workerQueue = workerQueue
+	return nil
+}
+
+// MigrateRequest resumes a paused request on the target worker, it allows paused requests
+// of a worker that went down to be picked up by another worker
+func (fRuntime *FlowRuntime) MigrateRequest(ctx context.Context, flowName, requestID, targetWorkerID string) error {
+	flowName, err := fRuntime.resolveFlowName(flowName)
+	if err != nil {
+		return err
+	}
+
+	// the state is read from the primary, a paused request must not be migrated twice
+	state, err := fRuntime.getState(flowName, requestID, fRuntime.stateStore)
+	if err != nil {
+		return err
+	}
+	if state.State != executor.STATE_PAUSED {
+		return fmt.Errorf("request %s can not be migrated, it is not paused but %s", requestID, state.State)
+	}
+
+	alive, err := fRuntime.redisClient().Exists(ctx, fmt.Sprintf("%s:%s", WorkerKeyInitial, targetWorkerID)).Result()
+	if err != nil {
+		return fmt.Errorf("failed to get worker %s, error %v", targetWorkerID, err)
+	}
+	if alive == 0 {
+		return fmt.Errorf("worker %s is not alive", targetWorkerID)
+	}
+
+	connection, err := OpenConnectionV2(fRuntime.connectionTag(), &fRuntime.RedisCfg, nil)
+	if err != nil {
+		return fmt.Errorf("failed to initiate connection, error %v", err)
+	}
+	workerQueue, err := connection.OpenQueue(workerQueueId(targetWorkerID))
+	if err != nil {
+		return fmt.Errorf("failed to get queue, error %v", err)
+	}
+	data, err := marshalTask(&Task{
+		FlowName:    flowName,
+		RequestID:   requestID,
+		Header:      make(map[string][]string),
+		Query:       make(map[string][]string),
+		RequestType: ResumeRequest,
+	})
+	if err != nil {
+		return err
+	}
+	err = workerQueue.PublishBytes(data)
+	if err != nil {
+		return fmt.Errorf("failed to publish task, error %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package runtime
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	InterruptNodeKeyInitial = "goflow-interrupt-node"
+
+	// InterruptNodeTimeOut bounds how long an interruption waits for the node to be executed
+	InterruptNodeTimeOut = 24 * time.Hour
+	// InterruptNodeRefreshInterval is the interval the workers refresh the interruptions of the nodes at
+	InterruptNodeRefreshInterval = time.Second
+)
+
+// interruptNodeMember returns the member of the node of a request in the sorted set of the interruptions, scored by
+// their expiry
+func interruptNodeMember(requestID string, nodeID string) string {
+	return fmt.Sprintf("%s:%s", requestID, nodeID)
+}
+
+// nodeInterrupts are the interruptions of the nodes known to the worker, refreshed from redis
+type nodeInterrupts struct {
+	mu      sync.RWMutex
+	members map[string]bool
+}
+
+func (interrupts *nodeInterrupts) has(member string) bool {
+	interrupts.mu.RLock()
+	defer interrupts.mu.RUnlock()
+	return interrupts.members[member]
+}
+
+func (interrupts *nodeInterrupts) remove(member string) {
+	interrupts.mu.Lock()
+	defer interrupts.mu.Unlock()
+	delete(interrupts.members, member)
+}
+
+func (interrupts *nodeInterrupts) set(members []string) {
+	interrupts.mu.Lock()
+	defer interrupts.mu.Unlock()
+	interrupts.members = make(map[string]bool, len(members))
+	for _, member := range members {
+		interrupts.members[member] = true
+	}
+}
+
+// InterruptNode interrupts the execution of a single node of a request, unlike Pause and Stop which take effect
+// at the next node boundary. The operations of the node that have not started are skipped, and a running
+// workload added with NodeWithContext sees its context interrupted and is expected to return sdk.ErrNodeInterrupted.
+// The interruption fails the node, which goes through its OnFailure handler. The workers see the interruption
+// within InterruptNodeRefreshInterval
+func (fRuntime *FlowRuntime) InterruptNode(requestID string, nodeID string) error {
+	if requestID == "" || nodeID == "" {
+		return fmt.Errorf("request id and node id must be provided")
+	}
+	expiry := float64(fRuntime.clock().Now().Add(InterruptNodeTimeOut).Unix())
+	member := redis.Z{Score: expiry, Member: interruptNodeMember(requestID, nodeID)}
+	if err := fRuntime.redisClient().ZAdd(context.TODO(), InterruptNodeKeyInitial, member).Err(); err != nil {
+		return fmt.Errorf("failed to interrupt node %s of request %s, error %v", nodeID, requestID, err)
+	}
+	return nil
+}
+
+// refreshNodeInterrupts loads the pending interruptions of the nodes, so that checking the interruption of a node
+// doesn't add a round trip to its execution
+func (fRuntime *FlowRuntime) refreshNodeInterrupts() {
+	ctx := context.TODO()
+	now := strconv.FormatInt(fRuntime.clock().Now().Unix(), 10)
+	pipe := fRuntime.redisClient().Pipeline()
+	// the interruptions of the nodes that were never executed again are dropped
+	pipe.ZRemRangeByScore(ctx, InterruptNodeKeyInitial, "-inf", "("+now)
+	members := pipe.ZRange(ctx, InterruptNodeKeyInitial, 0, -1)
+	if _, err := pipe.Exec(ctx); err != nil {
+		fRuntime.Logger.Log(fmt.Sprintf("[goflow] failed to refresh node interruptions, error %v", err))
+		return
+	}
+	fRuntime.interrupts.set(members.Val())
+}
+
+// IsNodeInterrupted checks if InterruptNode has been called for the node of the request
+func (fe *FlowExecutor) IsNodeInterrupted(nodeId string, requestId string) bool {
+	return fe.Runtime.interrupts.has(interruptNodeMember(requestId, nodeId))
+}
+
+// ClearNodeInterrupt removes the interruption of the node so that it doesn't affect a later execution of the node
+func (fe *FlowExecutor) ClearNodeInterrupt(nodeId string, requestId string) {
+	member := interruptNodeMember(requestId, nodeId)
+	fe.Runtime.interrupts.remove(member)
+	err := fe.Runtime.redisClient().ZRem(context.TODO(), InterruptNodeKeyInitial, member).Err()
+	if err != nil {
+		fe.Runtime.Logger.Log(fmt.Sprintf("[request `%s`] failed to clear interruption of node %s, error %v", requestId, nodeId, err))
+	}
+}
@@ -0,0 +1,62 @@
+package runtime_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/yuyang0/goflow/core/sdk"
+	flow "github.com/yuyang0/goflow/flow/v1"
+	"github.com/yuyang0/goflow/runtime"
+	goflow "github.com/yuyang0/goflow/v1"
+)
+
+// TestInterruptNode checks that a running node sees the interruption once the worker refreshed the interruptions,
+// and that the interruption is cleared once observed
+func TestInterruptNode(t *testing.T) {
+	started := make(chan struct{}, 1)
+	stopped := make(chan error, 1)
+	handler := func(wf *flow.Workflow, _ *flow.Context) error {
+		wf.Dag().NodeWithContext("call", func(nodeContext *sdk.NodeContext, data []byte, _ map[string][]string) ([]byte, error) {
+			started <- struct{}{}
+			deadline := time.Now().Add(10 * time.Second)
+			for time.Now().Before(deadline) {
+				if nodeContext.IsInterrupted() {
+					stopped <- sdk.ErrNodeInterrupted
+					return nil, sdk.ErrNodeInterrupted
+				}
+				time.Sleep(50 * time.Millisecond)
+			}
+			stopped <- nil
+			return data, nil
+		})
+		return nil
+	}
+	fs := &goflow.FlowService{}
+	mr, client := startWorker(t, fs, map[string]runtime.FlowDefinitionHandler{"stuck": handler}, nil)
+	if err := client.Execute("stuck", &goflow.Request{RequestId: "stuck-request", Body: []byte("{}")}); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-started:
+	case <-time.After(15 * time.Second):
+		t.Fatal("the node was not started")
+	}
+	// the node is identified by its unique id
+	if err := client.InterruptNode("stuck-request", "0_1_call"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-stopped:
+		if !errors.Is(err, sdk.ErrNodeInterrupted) {
+			t.Fatalf("expected the node to be interrupted, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the node was not interrupted")
+	}
+	eventually(t, 5*time.Second, func() bool {
+		members, err := mr.ZMembers(runtime.InterruptNodeKeyInitial)
+		return err != nil || len(members) == 0
+	}, "the interruption was not cleared")
+}
@@ -0,0 +1,201 @@
+package runtime
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/yuyang0/goflow/core/sdk"
+)
+
+// DefaultMaxNodeOutputBytes is the limit of a value written to the DataStore by a node when the flow doesn't set
+// MaxNodeOutputBytes
+const DefaultMaxNodeOutputBytes int64 = 1 << 20
+
+// ErrOutputTooLarge fails the nodes writing a value exceeding the MaxNodeOutputBytes of their flow to the DataStore
+var ErrOutputTooLarge = errors.New("node output too large")
+
+// largeOutputMarker prefixes the references to the values stored in the LargeOutputStore
+var largeOutputMarker = []byte("\x00goflow-large-output:")
+
+// LargeOutputStore stores the values exceeding the MaxNodeOutputBytes of their flow instead of the DataStore,
+// e.g. in S3 or on a shared filesystem. The values of a request are removed by Cleanup once it completes
+type LargeOutputStore interface {
+	Set(flowName string, requestID string, key string, value []byte) error
+	Get(flowName string, requestID string, key string) ([]byte, error)
+	Del(flowName string, requestID string, key string) error
+	Cleanup(flowName string, requestID string) error
+}
+
+// maxNodeOutputBytes returns the limit of the values written by the nodes of a flow, 0 when it is disabled
+func (fRuntime *FlowRuntime) maxNodeOutputBytes(flowName string) int64 {
+	options, _ := fRuntime.getFlowOptions(flowName)
+	switch {
+	case options.MaxNodeOutputBytes < 0:
+		return 0
+	case options.MaxNodeOutputBytes == 0:
+		return DefaultMaxNodeOutputBytes
+	}
+	return options.MaxNodeOutputBytes
+}
+
+// outputLimitDataStore rejects the values exceeding the MaxNodeOutputBytes of the flow, or moves them to
+// the LargeOutputStore of the runtime and keeps a reference in the DataStore
+type outputLimitDataStore struct {
+	sdk.DataStore
+	flowName  string
+	requestID string
+	runtime   *FlowRuntime
+}
+
+func (store *outputLimitDataStore) Configure(flowName string, requestId string) {
+	store.requestID = requestId
+	store.DataStore.Configure(flowName, requestId)
+}
+
+func (store *outputLimitDataStore) Set(key string, value []byte) error {
+	limit := store.runtime.maxNodeOutputBytes(store.flowName)
+	if limit == 0 || int64(len(value)) <= limit {
+		return store.DataStore.Set(key, value)
+	}
+	return store.setLarge(key, value, limit)
+}
+
+// SetIfAbsent claims the key with the reference to the LargeOutputStore before storing a value exceeding the limit
+func (store *outputLimitDataStore) SetIfAbsent(key string, value []byte) (bool, error) {
+	limit := store.runtime.maxNodeOutputBytes(store.flowName)
+	if limit == 0 || int64(len(value)) <= limit {
+		return sdk.SetIfAbsent(store.DataStore, key, value)
+	}
+	largeStore := store.runtime.LargeOutputStore
+	if largeStore == nil {
+		return false, store.setLarge(key, value, limit)
+	}
+	set, err := sdk.SetIfAbsent(store.DataStore, key, append(append([]byte{}, largeOutputMarker...), key...))
+	if err != nil || !set {
+		return set, err
+	}
+	if err := largeStore.Set(store.flowName, store.requestID, key, value); err != nil {
+		store.DataStore.Del(key)
+		return false, fmt.Errorf("failed to store large output %s, error %v", key, err)
+	}
+	return true, nil
+}
+
+func (store *outputLimitDataStore) Get(key string) ([]byte, error) {
+	value, err := store.DataStore.Get(key)
+	if err != nil || !bytes.HasPrefix(value, largeOutputMarker) {
+		return value, err
+	}
+	return store.getLarge(key)
+}
+
+func (store *outputLimitDataStore) SetReader(key string, reader io.Reader) error {
+	limit := store.runtime.maxNodeOutputBytes(store.flowName)
+	if limit == 0 {
+		return sdk.SetReader(store.DataStore, key, reader)
+	}
+
+	// only the values within the limit are streamed, the larger ones are rejected or moved as a whole
+	value, err := io.ReadAll(io.LimitReader(reader, limit+1))
+	if err != nil {
+		return err
+	}
+	if int64(len(value)) <= limit {
+		return sdk.SetReader(store.DataStore, key, bytes.NewReader(value))
+	}
+	if store.runtime.LargeOutputStore == nil {
+		return store.setLarge(key, value, limit)
+	}
+	rest, err := io.ReadAll(reader)
+	if err != nil {
+		return err
+	}
+	return store.setLarge(key, append(value, rest...), limit)
+}
+
+func (store *outputLimitDataStore) GetReader(key string) (io.ReadCloser, error) {
+	reader, err := sdk.GetReader(store.DataStore, key)
+	if err != nil {
+		return nil, err
+	}
+	buffered := bufio.NewReader(reader)
+	prefix, _ := buffered.Peek(len(largeOutputMarker))
+	if !bytes.Equal(prefix, largeOutputMarker) {
+		return struct {
+			io.Reader
+			io.Closer
+		}{buffered, reader}, nil
+	}
+	reader.Close()
+
+	value, err := store.getLarge(key)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(value)), nil
+}
+
+func (store *outputLimitDataStore) Del(key string) error {
+	if largeStore := store.runtime.LargeOutputStore; largeStore != nil {
+		value, err := store.DataStore.Get(key)
+		if err == nil && bytes.HasPrefix(value, largeOutputMarker) {
+			if err := largeStore.Del(store.flowName, store.requestID, key); err != nil {
+				return fmt.Errorf("failed to delete large output %s, error %v", key, err)
+			}
+		}
+	}
+	return store.DataStore.Del(key)
+}
+
+func (store *outputLimitDataStore) Cleanup() error {
+	if largeStore := store.runtime.LargeOutputStore; largeStore != nil {
+		if err := largeStore.Cleanup(store.flowName, store.requestID); err != nil {
+			return fmt.Errorf("failed to cleanup large outputs of request %s, error %v", store.requestID, err)
+		}
+	}
+	return store.DataStore.Cleanup()
+}
+
+func (store *outputLimitDataStore) Ping() error {
+	return pingStore(store.DataStore)
+}
+
+// Unwrap returns the store the values within the limit are stored in
+func (store *outputLimitDataStore) Unwrap() sdk.DataStore {
+	return store.DataStore
+}
+
+func (store *outputLimitDataStore) CopyStore() (sdk.DataStore, error) {
+	copied, err := store.DataStore.CopyStore()
+	if err != nil {
+		return nil, err
+	}
+	return &outputLimitDataStore{DataStore: copied, flowName: store.flowName, requestID: store.requestID, runtime: store.runtime}, nil
+}
+
+func (store *outputLimitDataStore) setLarge(key string, value []byte, limit int64) error {
+	largeStore := store.runtime.LargeOutputStore
+	if largeStore == nil {
+		return fmt.Errorf("%w, %s is %d bytes, the limit of flow %s is %d bytes", ErrOutputTooLarge, key, len(value),
+			store.flowName, limit)
+	}
+	if err := largeStore.Set(store.flowName, store.requestID, key, value); err != nil {
+		return fmt.Errorf("failed to store large output %s, error %v", key, err)
+	}
+	return store.DataStore.Set(key, append(append([]byte{}, largeOutputMarker...), key...))
+}
+
+func (store *outputLimitDataStore) getLarge(key string) ([]byte, error) {
+	largeStore := store.runtime.LargeOutputStore
+	if largeStore == nil {
+		return nil, fmt.Errorf("failed to read large output %s, no LargeOutputStore is set", key)
+	}
+	value, err := largeStore.Get(store.flowName, store.requestID, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read large output %s, error %v", key, err)
+	}
+	return value, nil
+}
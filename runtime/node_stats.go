@@ -0,0 +1,188 @@
+package runtime
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	NodeStatsKeyInitial = "goflow-node-stats"
+
+	// NodeStatsFlushInterval is the interval the workers add the node executions they counted to the stats at
+	NodeStatsFlushInterval = 10 * time.Second
+
+	nodeStatsTotal      = "total"
+	nodeStatsFailures   = "failures"
+	nodeStatsDurationMs = "duration_ms"
+)
+
+// NodeStats holds the executions of a node of a flow since the stats were last reset
+type NodeStats struct {
+	TotalExecutions int64   `json:"total_executions"`
+	Failures        int64   `json:"failures"`
+	AvgDurationMs   float64 `json:"avg_duration_ms"`
+}
+
+// nodeStatsKey returns the hash of the stats of the nodes of a flow, holding a field per node and counter
+func nodeStatsKey(flowName string) string {
+	return fmt.Sprintf("%s:%s", NodeStatsKeyInitial, flowName)
+}
+
+func nodeStatsField(nodeID string, counter string) string {
+	return fmt.Sprintf("%s:%s", nodeID, counter)
+}
+
+// nodeExecutions are the executions of a node counted by the worker since they were last flushed to redis
+type nodeExecutions struct {
+	total      int64
+	failures   int64
+	durationMs float64
+}
+
+// nodeStatsBuffer is the node executions counted by the worker, by flow and node
+type nodeStatsBuffer struct {
+	mu         sync.Mutex
+	executions map[string]map[string]*nodeExecutions
+}
+
+func (buffer *nodeStatsBuffer) add(flowName string, nodeID string, executions nodeExecutions) {
+	buffer.mu.Lock()
+	defer buffer.mu.Unlock()
+	if buffer.executions == nil {
+		buffer.executions = make(map[string]map[string]*nodeExecutions)
+	}
+	if buffer.executions[flowName] == nil {
+		buffer.executions[flowName] = make(map[string]*nodeExecutions)
+	}
+	counted, ok := buffer.executions[flowName][nodeID]
+	if !ok {
+		counted = &nodeExecutions{}
+		buffer.executions[flowName][nodeID] = counted
+	}
+	counted.total += executions.total
+	counted.failures += executions.failures
+	counted.durationMs += executions.durationMs
+}
+
+func (buffer *nodeStatsBuffer) take() map[string]map[string]*nodeExecutions {
+	buffer.mu.Lock()
+	defer buffer.mu.Unlock()
+	executions := buffer.executions
+	buffer.executions = nil
+	return executions
+}
+
+// ReportNodeOutcome counts the executions, failures and duration of the nodes of the flow
+func (fe *FlowExecutor) ReportNodeOutcome(nodeId string, requestId string, duration time.Duration, err error) {
+	if IsWarmupRequest(requestId) {
+		return
+	}
+	fe.Runtime.recordNodeExecution(fe.flowName, nodeId, duration, err == nil)
+}
+
+// recordNodeExecution counts an execution of a node of a flow, the stats are increased once the executions counted
+// by the worker are flushed, so that counting doesn't add a round trip to the execution of every node
+func (fRuntime *FlowRuntime) recordNodeExecution(flowName string, nodeID string, duration time.Duration, success bool) {
+	executions := nodeExecutions{total: 1, durationMs: float64(duration) / float64(time.Millisecond)}
+	if !success {
+		executions.failures = 1
+	}
+	fRuntime.nodeStats.add(flowName, nodeID, executions)
+}
+
+// flushNodeStats adds the node executions counted by the worker since the last flush to the stats, the executions
+// failing to be flushed are counted again in the next flush
+func (fRuntime *FlowRuntime) flushNodeStats() {
+	executions := fRuntime.nodeStats.take()
+	if len(executions) == 0 {
+		return
+	}
+	ctx := context.TODO()
+	pipe := fRuntime.redisClient().TxPipeline()
+	for flowName, nodes := range executions {
+		key := nodeStatsKey(flowName)
+		for nodeID, counted := range nodes {
+			pipe.HIncrBy(ctx, key, nodeStatsField(nodeID, nodeStatsTotal), counted.total)
+			if counted.failures > 0 {
+				pipe.HIncrBy(ctx, key, nodeStatsField(nodeID, nodeStatsFailures), counted.failures)
+			}
+			pipe.HIncrByFloat(ctx, key, nodeStatsField(nodeID, nodeStatsDurationMs), counted.durationMs)
+		}
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		fRuntime.Logger.Log(fmt.Sprintf("[goflow] failed to record node executions, error %v", err))
+		for flowName, nodes := range executions {
+			for nodeID, counted := range nodes {
+				fRuntime.nodeStats.add(flowName, nodeID, *counted)
+			}
+		}
+	}
+}
+
+// GetNodeExecutionCounts returns the execution stats of the nodes of a flow keyed by the node id,
+// the nodes that were never executed are not included. The workers flush the executions they counted
+// every NodeStatsFlushInterval
+func (fRuntime *FlowRuntime) GetNodeExecutionCounts(ctx context.Context, flowName string) (map[string]NodeStats, error) {
+	flowName, err := fRuntime.resolveFlowName(flowName)
+	if err != nil {
+		return nil, err
+	}
+
+	values, err := fRuntime.redisClient().HGetAll(ctx, nodeStatsKey(flowName)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get node stats of flow %s, error %v", flowName, err)
+	}
+	durations := make(map[string]float64)
+	stats := make(map[string]NodeStats)
+	for field, value := range values {
+		// the node ids may contain the separator, unlike the counters
+		idx := strings.LastIndex(field, ":")
+		if idx < 0 {
+			continue
+		}
+		nodeID, counter := field[:idx], field[idx+1:]
+		nodeStats := stats[nodeID]
+		switch counter {
+		case nodeStatsTotal:
+			nodeStats.TotalExecutions, _ = strconv.ParseInt(value, 10, 64)
+		case nodeStatsFailures:
+			nodeStats.Failures, _ = strconv.ParseInt(value, 10, 64)
+		case nodeStatsDurationMs:
+			durations[nodeID], _ = strconv.ParseFloat(value, 64)
+		}
+		stats[nodeID] = nodeStats
+	}
+	for nodeID, nodeStats := range stats {
+		if nodeStats.TotalExecutions > 0 {
+			nodeStats.AvgDurationMs = durations[nodeID] / float64(nodeStats.TotalExecutions)
+			stats[nodeID] = nodeStats
+		}
+	}
+	return stats, nil
+}
+
+// ResetNodeStats resets the execution stats of a node of a flow, or of all its nodes when nodeID is empty
+func (fRuntime *FlowRuntime) ResetNodeStats(ctx context.Context, flowName string, nodeID string) error {
+	flowName, err := fRuntime.resolveFlowName(flowName)
+	if err != nil {
+		return err
+	}
+
+	rdb := fRuntime.redisClient()
+	if nodeID != "" {
+		err := rdb.HDel(ctx, nodeStatsKey(flowName), nodeStatsField(nodeID, nodeStatsTotal),
+			nodeStatsField(nodeID, nodeStatsFailures), nodeStatsField(nodeID, nodeStatsDurationMs)).Err()
+		if err != nil {
+			return fmt.Errorf("failed to reset stats of node %s of flow %s, error %v", nodeID, flowName, err)
+		}
+		return nil
+	}
+	if err := rdb.Del(ctx, nodeStatsKey(flowName)).Err(); err != nil {
+		return fmt.Errorf("failed to reset node stats of flow %s, error %v", flowName, err)
+	}
+	return nil
+}
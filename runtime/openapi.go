@@ -0,0 +1,199 @@
+package runtime
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yuyang0/goflow/core/runtime/controller"
+	runtimeCommon "github.com/yuyang0/goflow/runtime/common"
+)
+
+const (
+	OpenAPIVersion = "3.0.3"
+
+	openAPIAuthScheme = "hubSignature"
+)
+
+// OpenAPIDocument returns the OpenAPI document describing the HTTP interface of the registered flows,
+// the document is cached until the flow registry changes
+func (fRuntime *FlowRuntime) OpenAPIDocument() ([]byte, error) {
+	fRuntime.openAPIMu.Lock()
+	defer fRuntime.openAPIMu.Unlock()
+
+	version := fRuntime.flowsVersion.Load()
+	if fRuntime.openAPIDoc != nil && fRuntime.openAPIDocVersion == version {
+		return fRuntime.openAPIDoc, nil
+	}
+
+	doc, err := json.Marshal(fRuntime.buildOpenAPIDocument())
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal openapi document, error %v", err)
+	}
+	fRuntime.openAPIDoc = doc
+	fRuntime.openAPIDocVersion = version
+	return doc, nil
+}
+
+func (fRuntime *FlowRuntime) buildOpenAPIDocument() map[string]interface{} {
+	flowNames := fRuntime.ListFlows()
+
+	paths := make(map[string]interface{})
+	for _, flowName := range flowNames {
+		base := "/flow/" + flowName
+		paths[base] = map[string]interface{}{
+			"post": fRuntime.submitOperation(flowName),
+		}
+		paths[base+"/request/stop{requestId}"] = map[string]interface{}{
+			"post": controlOperation(flowName, "stop", "Stop a request"),
+		}
+		paths[base+"/request/pause{requestId}"] = map[string]interface{}{
+			"post": controlOperation(flowName, "pause", "Pause a request"),
+		}
+		paths[base+"/request/resume{requestId}"] = map[string]interface{}{
+			"post": controlOperation(flowName, "resume", "Resume a paused request"),
+		}
+		paths[base+"/request/state{requestId}"] = map[string]interface{}{
+			"post": stateOperation(flowName),
+		}
+	}
+
+	doc := map[string]interface{}{
+		"openapi": OpenAPIVersion,
+		"info": map[string]interface{}{
+			"title":   "goflow",
+			"version": "1.0.0",
+		},
+		"paths": paths,
+	}
+	if fRuntime.RequestAuthEnabled {
+		doc["components"] = map[string]interface{}{
+			"securitySchemes": map[string]interface{}{
+				openAPIAuthScheme: map[string]interface{}{
+					"type":        "apiKey",
+					"in":          "header",
+					"name":        controller.AuthSignatureHeader,
+					"description": "HMAC signature of the request body using the shared secret",
+				},
+			},
+		}
+	}
+	return doc
+}
+
+func (fRuntime *FlowRuntime) submitOperation(flowName string) map[string]interface{} {
+	// the body is accepted as is unless the flow declares an input schema
+	var schema interface{} = map[string]interface{}{}
+	if options, ok := fRuntime.getFlowOptions(flowName); ok && len(options.InputSchema) > 0 {
+		schema = options.InputSchema
+	}
+
+	operation := map[string]interface{}{
+		"operationId": "submit-" + flowName,
+		"summary":     "Submit a request to the flow " + flowName,
+		"tags":        []string{flowName},
+		"parameters": []interface{}{
+			headerParameter(RequestIdHeaderName, "Request id to use instead of a generated one"),
+			headerParameter(AsyncRequestHeader, "Queue the request instead of executing it in the server"),
+			headerParameter(PartitionKeyHeader, "Requests sharing a partition key are processed in order"),
+			headerParameter(controller.CallbackUrlHeader, "Url called with the result of the request"),
+		},
+		"requestBody": map[string]interface{}{
+			"content": map[string]interface{}{
+				"application/json": map[string]interface{}{"schema": schema},
+			},
+		},
+		"responses": map[string]interface{}{
+			"200": textResponse("Request accepted, the request id is returned in the " + controller.RequestIdHeader + " header"),
+			"500": textResponse("Request failed"),
+		},
+	}
+	if fRuntime.RequestAuthEnabled {
+		operation["security"] = []interface{}{
+			map[string]interface{}{openAPIAuthScheme: []string{}},
+		}
+	}
+	return operation
+}
+
+func controlOperation(flowName string, action string, summary string) map[string]interface{} {
+	return map[string]interface{}{
+		"operationId": action + "-" + flowName,
+		"summary":     summary,
+		"tags":        []string{flowName},
+		"parameters":  []interface{}{requestIdParameter()},
+		"responses": map[string]interface{}{
+			"200": textResponse("Request submitted"),
+			"500": textResponse("Request failed"),
+		},
+	}
+}
+
+func stateOperation(flowName string) map[string]interface{} {
+	return map[string]interface{}{
+		"operationId": "state-" + flowName,
+		"summary":     "Get the state of a request",
+		"tags":        []string{flowName},
+		"parameters":  []interface{}{requestIdParameter()},
+		"responses": map[string]interface{}{
+			"200": map[string]interface{}{
+				"description": "Request state",
+				"content": map[string]interface{}{
+					"application/json": map[string]interface{}{
+						"schema": map[string]interface{}{
+							"type": "object",
+							"properties": map[string]interface{}{
+								"state":           map[string]interface{}{"type": "string"},
+								"current-node":    map[string]interface{}{"type": "string"},
+								"node-start-time": map[string]interface{}{"type": "integer"},
+							},
+						},
+					},
+				},
+			},
+			"500": textResponse("Request failed"),
+		},
+	}
+}
+
+func requestIdParameter() map[string]interface{} {
+	return map[string]interface{}{
+		"name":     RequestIdParamName,
+		"in":       "path",
+		"required": true,
+		"schema":   map[string]interface{}{"type": "string"},
+	}
+}
+
+func headerParameter(name string, description string) map[string]interface{} {
+	return map[string]interface{}{
+		"name":        name,
+		"in":          "header",
+		"description": description,
+		"schema":      map[string]interface{}{"type": "string"},
+	}
+}
+
+func textResponse(description string) map[string]interface{} {
+	return map[string]interface{}{
+		"description": description,
+		"content": map[string]interface{}{
+			"text/plain": map[string]interface{}{
+				"schema": map[string]interface{}{"type": "string"},
+			},
+		},
+	}
+}
+
+func openAPIHandler(runtime *FlowRuntime) func(*gin.Context) {
+	fn := func(c *gin.Context) {
+		doc, err := runtime.OpenAPIDocument()
+		if err != nil {
+			runtimeCommon.HandleError(c.Writer, fmt.Sprintf("failed to generate openapi document, %v", err))
+			return
+		}
+		c.Data(http.StatusOK, "application/json", doc)
+	}
+	return fn
+}
@@ -0,0 +1,122 @@
+package runtime
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/yuyang0/goflow/core/sdk"
+	"github.com/yuyang0/goflow/metrics"
+)
+
+const (
+	OutboxKeyInitial = "goflow-outbox"
+
+	OutboxStatusPending = "pending" // recorded before the effect is applied
+	OutboxStatusApplied = "applied"
+
+	OutboxOutcomeApplied      = "applied"
+	OutboxOutcomeDeduplicated = "deduplicated" // applied by a previous execution of the node
+	OutboxOutcomeReconciled   = "reconciled"   // found applied by the Reconcile of the effect
+	OutboxOutcomeInDoubt      = "in_doubt"
+)
+
+var outboxEffectsCounter = metrics.NewCounterVec("goflow_outbox_effects_total",
+	"Side effects applied through the outbox of the nodes by outcome", "flow", "outcome")
+
+// outboxRecord is the state of a side effect kept in the DataStore of the request
+type outboxRecord struct {
+	Status string `json:"status"`
+	Result []byte `json:"result,omitempty"`
+}
+
+// outbox records the side effects of a node in the DataStore of the request, so that a redelivered request
+// returns the result of the effects already applied instead of applying them again
+type outbox struct {
+	runtime   *FlowRuntime
+	flowName  string
+	requestID string
+	nodeID    string
+}
+
+// GetOutbox returns the outbox of the node of the request, see NodeContext.ApplyEffect
+func (fe *FlowExecutor) GetOutbox(nodeId string, requestId string) sdk.Outbox {
+	return &outbox{runtime: fe.Runtime, flowName: fe.flowName, requestID: requestId, nodeID: nodeId}
+}
+
+func (box *outbox) key(token string) string {
+	return fmt.Sprintf("%s--%s--%s", OutboxKeyInitial, box.nodeID, token)
+}
+
+func (box *outbox) Apply(effect sdk.SideEffect) ([]byte, error) {
+	if effect.Token == "" || effect.Apply == nil {
+		return nil, fmt.Errorf("token and apply must be provided to apply a side effect")
+	}
+	if IsWarmupRequest(box.requestID) {
+		// a warmup doesn't apply side effects, the node gets a nil result
+		return nil, nil
+	}
+	dataStore, err := box.runtime.requestDataStore(box.flowName, box.requestID)
+	if err != nil {
+		return nil, err
+	}
+	key := box.key(effect.Token)
+
+	// the effect is claimed atomically, a concurrent execution of the node finds it pending
+	pending, err := json.Marshal(&outboxRecord{Status: OutboxStatusPending})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal outbox record, error %v", err)
+	}
+	claimed, err := sdk.SetIfAbsent(dataStore, key, pending)
+	if err != nil {
+		return nil, fmt.Errorf("failed to record side effect %s in the outbox, error %v", effect.Token, err)
+	}
+	if !claimed {
+		record := &outboxRecord{}
+		data, err := dataStore.Get(key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read outbox record of side effect %s, error %v", effect.Token, err)
+		}
+		if err := json.Unmarshal(data, record); err != nil {
+			return nil, fmt.Errorf("failed to parse outbox record of side effect %s, error %v", effect.Token, err)
+		}
+
+		if record.Status == OutboxStatusApplied {
+			outboxEffectsCounter.Inc(box.flowName, OutboxOutcomeDeduplicated)
+			return record.Result, nil
+		}
+		// the effect is being applied by another execution, or the previous execution stopped between
+		// recording the effect and recording its outcome
+		if effect.Reconcile == nil {
+			outboxEffectsCounter.Inc(box.flowName, OutboxOutcomeInDoubt)
+			return nil, fmt.Errorf("side effect %s of node %s, %w", effect.Token, box.nodeID, sdk.ErrEffectInDoubt)
+		}
+		result, applied, err := effect.Reconcile(effect.Token)
+		if err != nil {
+			return nil, fmt.Errorf("failed to reconcile side effect %s, error %v", effect.Token, err)
+		}
+		if applied {
+			outboxEffectsCounter.Inc(box.flowName, OutboxOutcomeReconciled)
+			return result, box.record(dataStore, key, &outboxRecord{Status: OutboxStatusApplied, Result: result})
+		}
+	}
+
+	result, err := effect.Apply(effect.Token)
+	if err != nil {
+		// the effect is expected not to be applied when it fails, so that the next execution applies it again
+		dataStore.Del(key)
+		return nil, err
+	}
+	outboxEffectsCounter.Inc(box.flowName, OutboxOutcomeApplied)
+	return result, box.record(dataStore, key, &outboxRecord{Status: OutboxStatusApplied, Result: result})
+}
+
+func (box *outbox) record(dataStore sdk.DataStore, key string, record *outboxRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to marshal outbox record, error %v", err)
+	}
+	if err := dataStore.Set(key, data); err != nil {
+		return fmt.Errorf("failed to record side effect in the outbox, error %v", err)
+	}
+	return nil
+}
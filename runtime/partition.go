@@ -0,0 +1,141 @@
+package runtime
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"time"
+
+	"github.com/adjust/rmq/v5"
+)
+
+const (
+	PartitionKeyHeader = "X-Partition-Key"
+
+	// PartitionLeaseInterval is how often a worker renews the partitions it consumes and takes over the
+	// partitions left by dead workers, the lease of a partition expires after three intervals
+	PartitionLeaseInterval = 5 * time.Second
+
+	LeaderRolePartition = "partition"
+)
+
+// partitionIndex returns the partition a partition key is assigned to
+func (fRuntime *FlowRuntime) partitionIndex(partitionKey string) int {
+	hash := fnv.New32a()
+	hash.Write([]byte(partitionKey))
+	return int(hash.Sum32() % uint32(fRuntime.PartitionCount))
+}
+
+func (fRuntime *FlowRuntime) partitionQueueId(flowName string, partition int) string {
+	return fmt.Sprintf("%s-partition-%d", fRuntime.internalRequestQueueId(flowName), partition)
+}
+
+// publishQueueId returns the queue a task of the flow is published to,
+// tasks with a partition key go to the queue of their partition when partitioning is enabled
+func (fRuntime *FlowRuntime) publishQueueId(flowName string, partitionKey string) string {
+	if fRuntime.PartitionCount <= 0 || partitionKey == "" {
+		return fRuntime.internalRequestQueueId(flowName)
+	}
+	return fRuntime.partitionQueueId(flowName, fRuntime.partitionIndex(partitionKey))
+}
+
+// publishQueueLocation returns the location of the queue a task of the flow is published to, see publishQueueId
+func (fRuntime *FlowRuntime) publishQueueLocation(flowName string, partitionKey string) string {
+	if fRuntime.PartitionCount <= 0 || partitionKey == "" {
+		return QueueLocationMain
+	}
+	return partitionQueueLocation(fRuntime.partitionIndex(partitionKey))
+}
+
+// initializePartitionQueues opens the partition queues of a flow the continuations of partitioned requests are
+// published to, and starts consuming the partitions the worker owns. Each partition is consumed by a single
+// consumer across the workers, the owner of its lease, so that tasks sharing a partition key are processed
+// one at a time and in order. Failed tasks are pushed to retryQueue (if any), where they are no longer ordered.
+// Caller must hold queueMu
+func (fRuntime *FlowRuntime) initializePartitionQueues(conn rmq.Connection, flowName string, retryQueue rmq.Queue) ([]rmq.Queue, error) {
+	partitionQueues := make([]rmq.Queue, fRuntime.PartitionCount)
+	for idx := 0; idx < fRuntime.PartitionCount; idx++ {
+		partitionQueue, err := conn.OpenQueue(fRuntime.partitionQueueId(flowName, idx))
+		if err != nil {
+			return nil, fmt.Errorf("failed to open partition queue, error %v", err)
+		}
+		partitionQueues[idx] = partitionQueue
+	}
+	if fRuntime.partitionConsumers == nil {
+		fRuntime.partitionConsumers = make(map[string][]rmq.Queue)
+	}
+	fRuntime.partitionConsumers[flowName] = make([]rmq.Queue, fRuntime.PartitionCount)
+	return partitionQueues, fRuntime.balanceFlowPartitions(conn, flowName, retryQueue)
+}
+
+// balancePartitions renews the leases of the partitions consumed by the worker and starts consuming the
+// partitions whose lease it acquires, the partitions whose lease is lost are no longer consumed
+func (fRuntime *FlowRuntime) balancePartitions() {
+	fRuntime.queueMu.Lock()
+	defer fRuntime.queueMu.Unlock()
+	if fRuntime.rmqConnection == nil {
+		return
+	}
+	for flowName := range fRuntime.partitionConsumers {
+		var retryQueue rmq.Queue
+		if pushQueues := fRuntime.pushQueues[flowName]; len(pushQueues) > 0 {
+			retryQueue = pushQueues[0]
+		}
+		if err := fRuntime.balanceFlowPartitions(fRuntime.rmqConnection, flowName, retryQueue); err != nil {
+			log.Printf("failed to balance partitions of flow %s, %v", flowName, err)
+		}
+	}
+}
+
+// balanceFlowPartitions acquires or renews the lease of each partition of a flow, starting and stopping
+// the partition consumers of the worker accordingly. Caller must hold queueMu
+func (fRuntime *FlowRuntime) balanceFlowPartitions(conn rmq.Connection, flowName string, retryQueue rmq.Queue) error {
+	consumers := fRuntime.partitionConsumers[flowName]
+	for idx := range consumers {
+		owner, err := fRuntime.acquireLeadership(context.TODO(), partitionRole(flowName, idx), 3*PartitionLeaseInterval)
+		if err != nil {
+			// the lease may be taken over by now, the partition is released rather than consumed twice
+			log.Printf("failed to renew lease of partition %d of flow %s, %v", idx, flowName, err)
+		}
+		switch {
+		case owner && consumers[idx] == nil:
+			consumer, err := fRuntime.consumePartition(conn, flowName, idx, retryQueue)
+			if err != nil {
+				return err
+			}
+			consumers[idx] = consumer
+		case !owner && consumers[idx] != nil:
+			consumers[idx].StopConsuming()
+			consumers[idx] = nil
+		}
+	}
+	return nil
+}
+
+// consumePartition starts consuming a partition queue with a single consumer, a stopped queue can't
+// be consumed again so each lease of the partition gets its own queue
+func (fRuntime *FlowRuntime) consumePartition(conn rmq.Connection, flowName string, idx int, retryQueue rmq.Queue) (rmq.Queue, error) {
+	partitionQueue, err := conn.OpenQueue(fRuntime.partitionQueueId(flowName, idx))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open partition queue, error %v", err)
+	}
+	if retryQueue != nil {
+		partitionQueue.SetPushQueue(retryQueue)
+	}
+
+	// prefetch a single delivery so that the order within the partition is preserved
+	err = partitionQueue.StartConsuming(1, time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start consumer partition queue, error %v", err)
+	}
+	_, err = partitionQueue.AddConsumer(fmt.Sprintf("partition-consumer-%d", idx), fRuntime.retryConsumer(partitionQueueLocation(idx), 0, retryQueue == nil))
+	if err != nil {
+		return nil, fmt.Errorf("failed to add partition consumer, error %v", err)
+	}
+	return partitionQueue, nil
+}
+
+func partitionRole(flowName string, partition int) string {
+	return fmt.Sprintf("%s:%s:%d", LeaderRolePartition, flowName, partition)
+}
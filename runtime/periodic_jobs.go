@@ -0,0 +1,43 @@
+package runtime
+
+import (
+	"context"
+	"time"
+)
+
+// PeriodicJobsTick is how often the runtime checks for its periodic jobs due, the granularity of their intervals
+const PeriodicJobsTick = time.Second
+
+// periodicJob is a job the runtime runs every interval, see runPeriodicJobs
+type periodicJob struct {
+	interval time.Duration
+	run      func()
+}
+
+// runPeriodicJobs runs the jobs on the clock of the runtime until ctx is done. The jobs due are run one after the
+// other, every job is due again its interval after it ran
+func (fRuntime *FlowRuntime) runPeriodicJobs(ctx context.Context, jobs []periodicJob) {
+	ticker := fRuntime.clock().NewTicker(PeriodicJobsTick)
+	defer ticker.Stop()
+	next := make([]time.Time, len(jobs))
+	for idx, job := range jobs {
+		next[idx] = fRuntime.clock().Now().Add(job.interval)
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+		}
+		for idx, job := range jobs {
+			if ctx.Err() != nil {
+				return
+			}
+			if fRuntime.clock().Now().Before(next[idx]) {
+				continue
+			}
+			job.run()
+			next[idx] = fRuntime.clock().Now().Add(job.interval)
+		}
+	}
+}
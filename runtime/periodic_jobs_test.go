@@ -0,0 +1,59 @@
+package runtime_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/alphadose/haxmap"
+	"github.com/yuyang0/goflow/runtime"
+	"github.com/yuyang0/goflow/runtime/clock/clocktest"
+	"github.com/yuyang0/goflow/types"
+)
+
+// TestPeriodicJobsFollowClock checks that the periodic jobs of the runtime run as its clock moves, that the runtime
+// can't be started twice, and that its jobs and scheduler stop once it is shut down
+func TestPeriodicJobsFollowClock(t *testing.T) {
+	mr := miniredis.RunT(t)
+	fc := clocktest.NewFakeClock(time.Now())
+	fRuntime := &runtime.FlowRuntime{
+		Flows:    haxmap.New[string, runtime.FlowDefinitionHandler](),
+		RedisCfg: types.RedisConfig{Addr: mr.Addr()},
+		Clock:    fc,
+	}
+	if err := fRuntime.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if err := fRuntime.Register(map[string]runtime.FlowDefinitionHandler{"periodic": echoFlow}); err != nil {
+		t.Fatal(err)
+	}
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- fRuntime.StartRuntime()
+	}()
+	// the tickers of the periodic jobs and of the scheduler
+	fc.BlockUntil(2)
+	if err := fRuntime.StartRuntime(); err == nil {
+		t.Fatal("expected the runtime not to be started twice")
+	}
+
+	flowKey := runtime.FlowKeyInitial + ":periodic"
+	mr.Del(flowKey)
+	fc.Advance(runtime.GoFlowRegisterInterval * time.Second)
+	eventually(t, 5*time.Second, func() bool {
+		return mr.Exists(flowKey)
+	}, "the flow was not registered again as the clock moved")
+
+	if err := fRuntime.DrainAndShutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the runtime to stop once shut down")
+	}
+	eventually(t, 5*time.Second, func() bool {
+		return fc.Waiters() == 0
+	}, "the periodic jobs and the scheduler were not stopped")
+}
@@ -0,0 +1,188 @@
+package runtime
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"runtime/debug"
+	"time"
+
+	"github.com/adjust/rmq/v5"
+	"github.com/redis/go-redis/v9"
+	"github.com/yuyang0/goflow/metrics"
+)
+
+const (
+	CrashKeyInitial = "goflow-crash"
+
+	// PoisonQueue is the dead letter queue of the poison messages, listed with `GET /v1/deadletters?queue=poison`
+	PoisonQueue = "poison"
+
+	// CrashCountTTL is how long the crashes of a message are counted after its last delivery
+	CrashCountTTL = 24 * time.Hour
+
+	PoisonStatus = "poisoned"
+)
+
+var poisonMessagesCounter = metrics.NewCounterVec("goflow_poison_messages_total",
+	"Messages routed to the poison queue as they repeatedly crashed the consumer", "flow")
+
+// PoisonMessage is sent to the AlertWebhookURL and OnPoisonMessage when a message crossed the PoisonThreshold
+type PoisonMessage struct {
+	Flow      string    `json:"flow"`
+	RequestID string    `json:"request_id"`
+	Status    string    `json:"status"` // poisoned
+	Hash      string    `json:"hash"`   // hash of the message the crashes are counted by
+	Crashes   int64     `json:"crashes"`
+	LastPanic string    `json:"last_panic,omitempty"` // empty when the consumer died without recovering
+	Time      time.Time `json:"time"`
+	Task      *Task     `json:"-"`
+}
+
+// ConsumerPanicError is the error of a request whose handling panicked
+type ConsumerPanicError struct {
+	Value interface{}
+	Stack []byte
+}
+
+func (err *ConsumerPanicError) Error() string {
+	return fmt.Sprintf("consumer panicked, %v", err.Value)
+}
+
+func crashKey(hash string) string {
+	return fmt.Sprintf("%s:%s", CrashKeyInitial, hash)
+}
+
+// crashTracker counts the deliveries of a message that didn't return, from a panic or from the worker dying
+// while handling it. A nil tracker tracks nothing
+type crashTracker struct {
+	runtime    *FlowRuntime
+	hash       string
+	deliveries *redis.IntCmd
+	lastPanic  *redis.StringCmd
+	crashes    int64 // including the current delivery once it panicked
+}
+
+// trackCrashes starts tracking the delivery of a message when the PoisonThreshold is set, the delivery is counted
+// along with the bookkeeping writes of the consumer
+func (fRuntime *FlowRuntime) trackCrashes(message rmq.Delivery, writes redis.Pipeliner) *crashTracker {
+	if fRuntime.PoisonThreshold <= 0 {
+		return nil
+	}
+	sum := sha256.Sum256([]byte(message.Payload()))
+	tracker := &crashTracker{runtime: fRuntime, hash: hex.EncodeToString(sum[:16])}
+
+	ctx := context.TODO()
+	key := crashKey(tracker.hash)
+	tracker.deliveries = writes.HIncrBy(ctx, key, "deliveries", 1)
+	tracker.lastPanic = writes.HGet(ctx, key, "last_panic")
+	writes.Expire(ctx, key, CrashCountTTL)
+	return tracker
+}
+
+// counted returns the tracker once the delivery was counted, and true when the message already crashed the consumer
+// PoisonThreshold times, it is then routed to the poison queue
+func (tracker *crashTracker) counted(message rmq.Delivery, task *Task) (*crashTracker, bool) {
+	if tracker == nil {
+		return nil, false
+	}
+	fRuntime := tracker.runtime
+	if err := tracker.deliveries.Err(); err != nil {
+		// the message is handled untracked rather than held up
+		fRuntime.Logger.Log(fmt.Sprintf("[request `%s`] failed to count crashes of message, error %v", task.RequestID, err))
+		return nil, false
+	}
+	// the deliveries that didn't return crashed
+	tracker.crashes = tracker.deliveries.Val() - 1
+	if tracker.crashes < int64(fRuntime.PoisonThreshold) {
+		return tracker, false
+	}
+	tracker.poison(message, task, tracker.lastPanic.Val())
+	return nil, true
+}
+
+// guard handles a request, recovering from a panic as a ConsumerPanicError
+func (tracker *crashTracker) guard(handle func() error) (err error) {
+	if tracker == nil {
+		return handle()
+	}
+	defer func() {
+		if value := recover(); value != nil {
+			err = &ConsumerPanicError{Value: value, Stack: debug.Stack()}
+		}
+	}()
+	return handle()
+}
+
+// finish ends the tracking of a delivery once it was handled, along with the bookkeeping writes of the consumer.
+// When it panicked, it returns true if the message is routed to the poison queue, otherwise the crash is counted and
+// the message handled as failed
+func (tracker *crashTracker) finish(message rmq.Delivery, task *Task, err error, writes redis.Pipeliner) bool {
+	if tracker == nil {
+		return false
+	}
+	fRuntime := tracker.runtime
+	ctx := context.TODO()
+	key := crashKey(tracker.hash)
+
+	panicErr, ok := err.(*ConsumerPanicError)
+	if !ok {
+		writes.Del(ctx, key)
+		return false
+	}
+
+	tracker.crashes++
+	fRuntime.Logger.Log(fmt.Sprintf("[request `%s`] consumer panicked (%d/%d), %v\n%s", task.RequestID,
+		tracker.crashes, fRuntime.PoisonThreshold, panicErr.Value, panicErr.Stack))
+	if tracker.crashes >= int64(fRuntime.PoisonThreshold) {
+		tracker.poison(message, task, fmt.Sprint(panicErr.Value))
+		return true
+	}
+	// the delivery stays counted as a crash
+	writes.HSet(ctx, key, "last_panic", fmt.Sprint(panicErr.Value))
+	return false
+}
+
+// poison routes a message that crashed the consumer PoisonThreshold times to the poison queue, and alerts
+func (tracker *crashTracker) poison(message rmq.Delivery, task *Task, lastPanic string) {
+	fRuntime := tracker.runtime
+	reason := fmt.Sprintf("crashed the consumer %d times", tracker.crashes)
+	if lastPanic != "" {
+		reason = fmt.Sprintf("%s, last panic %s", reason, lastPanic)
+	}
+	if err := fRuntime.deadLetterTo(PoisonQueue, &DeadLetter{Task: task, Reason: reason}); err != nil {
+		// rejected rather than redelivered, so that it doesn't crash the consumer again
+		fRuntime.Logger.Log(fmt.Sprintf("[request `%s`] failed to route poison message, error %v", task.RequestID, err))
+		if err := message.Reject(); err != nil {
+			fRuntime.handleQueueError(QueueOperationAck, task, err)
+		}
+		return
+	}
+	if err := message.Ack(); err != nil {
+		fRuntime.handleQueueError(QueueOperationAck, task, err)
+	}
+	fRuntime.redisClient().Del(context.TODO(), crashKey(tracker.hash))
+
+	poisonMessagesCounter.Inc(task.FlowName)
+	fRuntime.Logger.Log(fmt.Sprintf("[request `%s`] routed to the poison queue, %s", task.RequestID, reason))
+	alert := &PoisonMessage{
+		Flow:      task.FlowName,
+		RequestID: task.RequestID,
+		Status:    PoisonStatus,
+		Hash:      tracker.hash,
+		Crashes:   tracker.crashes,
+		LastPanic: lastPanic,
+		Time:      tracker.runtime.clock().Now(),
+		Task:      task,
+	}
+	if fRuntime.OnPoisonMessage != nil {
+		fRuntime.OnPoisonMessage(alert)
+	}
+	if fRuntime.AlertWebhookURL == "" {
+		return
+	}
+	if err := postAlert(fRuntime.AlertWebhookURL, alert); err != nil {
+		fRuntime.Logger.Log(fmt.Sprintf("[goflow] failed to call alert webhook for flow %s, error %v", task.FlowName, err))
+	}
+}
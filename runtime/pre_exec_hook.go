@@ -0,0 +1,105 @@
+package runtime
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/yuyang0/goflow/core/runtime"
+	"github.com/yuyang0/goflow/metrics"
+)
+
+const (
+	// FailureCategoryRejected denotes a request rejected by a pre-execution hook
+	FailureCategoryRejected = "Rejected"
+)
+
+var rejectedRequestsCounter = metrics.NewCounterVec("goflow_rejected_requests_total",
+	"New requests rejected by a pre-execution hook", "flow")
+
+// PreExecHook validates or transforms a new request before its execution. It returns the request to
+// execute, which may be the one it received, or an error to reject the request
+type PreExecHook func(ctx context.Context, req *runtime.Request) (*runtime.Request, error)
+
+// RegisterPreExecHook adds a hook run on every new request before the executor is created. The hooks are
+// chained in the order they are registered, each one receiving the request returned by the previous one
+func (fRuntime *FlowRuntime) RegisterPreExecHook(hook func(ctx context.Context, req *runtime.Request) (*runtime.Request, error)) {
+	fRuntime.preExecHooksMu.Lock()
+	defer fRuntime.preExecHooksMu.Unlock()
+	fRuntime.preExecHooks = append(fRuntime.preExecHooks, hook)
+}
+
+func (fRuntime *FlowRuntime) hasPreExecHooks() bool {
+	fRuntime.preExecHooksMu.RLock()
+	defer fRuntime.preExecHooksMu.RUnlock()
+	return len(fRuntime.preExecHooks) > 0
+}
+
+// runPreExecHooks runs the hooks on a new request and returns the request to execute, the first hook
+// returning an error rejects the request and the next ones are not run
+func (fRuntime *FlowRuntime) runPreExecHooks(request *runtime.Request) (*runtime.Request, error) {
+	fRuntime.preExecHooksMu.RLock()
+	hooks := fRuntime.preExecHooks
+	fRuntime.preExecHooksMu.RUnlock()
+	if len(hooks) == 0 {
+		return request, nil
+	}
+
+	ctx := context.Background()
+	if !request.Deadline.IsZero() {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, request.Deadline)
+		defer cancel()
+	}
+	for _, hook := range hooks {
+		transformed, err := hook(ctx, request)
+		if err != nil {
+			return nil, err
+		}
+		if transformed == nil {
+			continue
+		}
+		// the identity of the request is kept, the state of the request is stored under it
+		if transformed.FlowName != request.FlowName || transformed.RequestID != request.RequestID {
+			return nil, fmt.Errorf("pre-execution hook changed the flow name or the id of the request")
+		}
+		request = transformed
+	}
+	return request, nil
+}
+
+// rejectRequest records a new request rejected by a pre-execution hook, it is reported as failed with the
+// Rejected category and dead lettered to the queue of the category of the error of the hook, without retry
+func (fRuntime *FlowRuntime) rejectRequest(request *runtime.Request, cause error) {
+	rejectedRequestsCounter.Inc(request.FlowName)
+	fRuntime.Logger.Log(fmt.Sprintf("[request `%s`] failed (category %s), rejected by pre-execution hook, %v",
+		request.RequestID, FailureCategoryRejected, cause))
+	fRuntime.recordHistory(request.FlowName, request.RequestID, HistoryStatusFailed, cause)
+
+	task := &Task{
+		FlowName:        request.FlowName,
+		RequestID:       request.RequestID,
+		Body:            request.Body,
+		Header:          request.Header,
+		RawQuery:        request.RawQuery,
+		Query:           request.Query,
+		RequestType:     NewRequest,
+		PartitionKey:    request.PartitionKey,
+		FailureCategory: FailureCategoryRejected,
+	}
+	category := ErrorCategory(cause)
+	queue := fRuntime.DeadLetterRouting.queue(category)
+	reason := fmt.Sprintf("rejected by pre-execution hook (category %s), %v", category, cause)
+	if err := fRuntime.deadLetterTo(queue, &DeadLetter{Task: task, Reason: reason, Category: category}); err != nil {
+		fRuntime.Logger.Log(fmt.Sprintf("[request `%s`] failed to dead letter rejected request, error %v", request.RequestID, err))
+		fRuntime.finishLocation(request.FlowName, request.RequestID, LocationStatusFailed)
+	} else {
+		deadLetteredFailuresCounter.Inc(request.FlowName, category, queue)
+	}
+
+	err := fRuntime.updateArchivedTask(request.RequestID, func(task *Task) {
+		task.FailureCategory = FailureCategoryRejected
+	})
+	if err != nil {
+		fRuntime.Logger.Log(fmt.Sprintf("[request `%s`] failed to update archived task, error %v", request.RequestID, err))
+	}
+}
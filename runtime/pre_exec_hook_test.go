@@ -0,0 +1,54 @@
+package runtime_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/yuyang0/goflow/core/runtime"
+	flow "github.com/yuyang0/goflow/flow/v1"
+	goflowRuntime "github.com/yuyang0/goflow/runtime"
+	goflow "github.com/yuyang0/goflow/v1"
+)
+
+func TestRejectedRequestIsDeadLettered(t *testing.T) {
+	executed := make(chan struct{}, 1)
+	echo := func(wf *flow.Workflow, _ *flow.Context) error {
+		wf.Dag().Node("echo", func(data []byte, _ map[string][]string) ([]byte, error) {
+			executed <- struct{}{}
+			return data, nil
+		})
+		return nil
+	}
+	reject := func(_ context.Context, req *runtime.Request) (*runtime.Request, error) {
+		return nil, errors.New("missing tenant")
+	}
+
+	fs := &goflow.FlowService{PreExecHooks: []goflowRuntime.PreExecHook{reject}}
+	mr, client := startWorker(t, fs, map[string]goflowRuntime.FlowDefinitionHandler{"hooked": echo}, nil)
+	if err := client.Execute("hooked", &goflow.Request{RequestId: "rejected-1", Body: []byte("{}")}); err != nil {
+		t.Fatal(err)
+	}
+
+	eventually(t, 10*time.Second, func() bool {
+		return mr.Exists(goflowRuntime.DeadLetterKey)
+	}, "the rejected request was not dead lettered")
+	entries, err := mr.List(goflowRuntime.DeadLetterKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var deadLetter goflowRuntime.DeadLetter
+	if err := json.Unmarshal([]byte(entries[0]), &deadLetter); err != nil {
+		t.Fatal(err)
+	}
+	if deadLetter.Task.RequestID != "rejected-1" || deadLetter.Task.FailureCategory != goflowRuntime.FailureCategoryRejected {
+		t.Fatalf("unexpected dead letter %+v", deadLetter.Task)
+	}
+	select {
+	case <-executed:
+		t.Fatal("the rejected request was executed")
+	default:
+	}
+}
@@ -0,0 +1,137 @@
+package runtime
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	runtimeCommon "github.com/yuyang0/goflow/runtime/common"
+)
+
+const (
+	// QueuePositionMaxScan is the number of queued tasks searched for a request, from the oldest
+	QueuePositionMaxScan = 10000
+	// QueuePositionPageSize is the number of queued tasks fetched at once while searching for a request
+	QueuePositionPageSize = 100
+)
+
+// ErrRequestNotQueued is returned when a request is not waiting in the queues of its flow,
+// it was already picked up by a worker, or it is further than QueuePositionMaxScan
+var ErrRequestNotQueued = errors.New("request is not queued")
+
+// QueueEstimate is the position of a queued request and the estimated time until it is picked up
+type QueueEstimate struct {
+	Position   int64    `json:"position"`
+	ETASeconds *float64 `json:"eta_seconds"` // nil when the flow has no recent throughput
+}
+
+// readyQueueKey returns the redis list holding the ready tasks of an rmq queue
+func readyQueueKey(queueId string) string {
+	return fmt.Sprintf("rmq::queue::[%s]::ready", queueId)
+}
+
+// QueuePosition returns the position of a request waiting to be started in the queues of its flow, 1 being
+// the next task picked up. Requests routed to the queue of a sticky worker are not found
+func (fRuntime *FlowRuntime) QueuePosition(flowName string, requestId string) (int64, error) {
+	flowName, err := fRuntime.resolveFlowName(flowName)
+	if err != nil {
+		return 0, err
+	}
+
+	queueIds := []string{fRuntime.internalRequestQueueId(flowName)}
+	for idx := 0; idx < fRuntime.PartitionCount; idx++ {
+		queueIds = append(queueIds, fRuntime.partitionQueueId(flowName, idx))
+	}
+
+	// the tasks are marshalled without spaces, so the request id can be matched before decoding
+	quotedId, _ := json.Marshal(requestId)
+	needle := `"request_id":` + string(quotedId)
+	for _, queueId := range queueIds {
+		position, err := fRuntime.searchReadyQueue(context.TODO(), queueId, needle)
+		if err != nil {
+			return 0, fmt.Errorf("failed to search queue %s, error %v", queueId, err)
+		}
+		if position > 0 {
+			return position, nil
+		}
+	}
+	return 0, fmt.Errorf("%w, request %s of flow %s", ErrRequestNotQueued, requestId, flowName)
+}
+
+// searchReadyQueue searches the ready tasks of a queue for the new request task matching the needle, from the
+// oldest task, and returns its 1-based position or -1 if not found within QueuePositionMaxScan tasks. The tasks are
+// fetched by pages so that redis isn't blocked by the search, the position is thus an estimate as the queue moves
+func (fRuntime *FlowRuntime) searchReadyQueue(ctx context.Context, queueId string, needle string) (int64, error) {
+	// the tasks are pushed on the left of the list and consumed from its right
+	for scanned := int64(0); scanned < QueuePositionMaxScan; {
+		tasks, err := fRuntime.redisClient().LRange(ctx, readyQueueKey(queueId), -scanned-QueuePositionPageSize,
+			-scanned-1).Result()
+		if err != nil {
+			return 0, err
+		}
+		for idx := len(tasks) - 1; idx >= 0 && scanned < QueuePositionMaxScan; idx-- {
+			scanned++
+			if !strings.Contains(tasks[idx], needle) {
+				continue
+			}
+			task := &Task{}
+			if err := json.Unmarshal([]byte(tasks[idx]), task); err == nil && task.RequestType == NewRequest {
+				return scanned, nil
+			}
+		}
+		if len(tasks) < QueuePositionPageSize {
+			break
+		}
+	}
+	return -1, nil
+}
+
+// EstimateQueueWait returns the position of a queued request along with the time until it is picked up
+// at the recent throughput of the flow
+func (fRuntime *FlowRuntime) EstimateQueueWait(flowName string, requestId string) (*QueueEstimate, error) {
+	position, err := fRuntime.QueuePosition(flowName, requestId)
+	if err != nil {
+		return nil, err
+	}
+	flowName, _ = fRuntime.resolveFlowName(flowName)
+	throughput, err := fRuntime.getThroughput(context.TODO(), flowName)
+	if err != nil {
+		return nil, err
+	}
+
+	estimate := &QueueEstimate{Position: position}
+	if throughput > 0 {
+		seconds := float64(position) / throughput
+		estimate.ETASeconds = &seconds
+	}
+	return estimate, nil
+}
+
+func queuePositionHandler(runtime *FlowRuntime) func(*gin.Context) {
+	fn := func(c *gin.Context) {
+		flowName, ok := flowNameParam(runtime, c)
+		if !ok {
+			return
+		}
+		requestId := c.Param(RequestIdParamName)
+		if !runtime.authorizeRequest(c, nil, ActionDiagnose, flowName, requestId) {
+			return
+		}
+
+		estimate, err := runtime.EstimateQueueWait(flowName, requestId)
+		if errors.Is(err, ErrRequestNotQueued) {
+			c.String(http.StatusNotFound, err.Error())
+			return
+		}
+		if err != nil {
+			runtimeCommon.HandleError(c.Writer, fmt.Sprintf("Failed to get queue position, %v", err))
+			return
+		}
+		c.JSON(http.StatusOK, estimate)
+	}
+	return fn
+}
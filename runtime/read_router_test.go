@@ -0,0 +1,44 @@
+package runtime_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/yuyang0/goflow/types"
+)
+
+// TestReadRouterSkipsFailedReplica checks that the reads fall back to the primary when the replica fails, and
+// that the next reads skip the replica instead of waiting for it to fail again
+func TestReadRouterSkipsFailedReplica(t *testing.T) {
+	primary := miniredis.RunT(t)
+	replica := miniredis.RunT(t)
+	replicaAddr := replica.Addr()
+	replica.Close()
+
+	cfg := &types.RedisConfig{Addr: primary.Addr(), ReplicaAddr: replicaAddr}
+	client := cfg.NewRedisClient()
+	defer client.Close()
+	router := cfg.NewReadRouter("test", client)
+	defer router.Close()
+
+	read := func() []string {
+		var addrs []string
+		err := router.Read(func(client redis.UniversalClient) error {
+			addrs = append(addrs, client.(*redis.Client).Options().Addr)
+			return client.Ping(context.Background()).Err()
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return addrs
+	}
+
+	if addrs := read(); len(addrs) != 2 || addrs[0] != replicaAddr || addrs[1] != primary.Addr() {
+		t.Fatalf("expected the read to fall back from the replica to the primary, got %v", addrs)
+	}
+	if addrs := read(); len(addrs) != 1 || addrs[0] != primary.Addr() {
+		t.Fatalf("expected the read to skip the failed replica, got %v", addrs)
+	}
+}
@@ -0,0 +1,166 @@
+package runtime
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yuyang0/goflow/core/sdk/executor"
+	runtimeCommon "github.com/yuyang0/goflow/runtime/common"
+)
+
+const (
+	AnnotationKeyInitial = "goflow-annotations"
+
+	// AnnotationMaxEntries is the number of annotations kept for a request, the oldest are dropped
+	AnnotationMaxEntries = 100
+	// AnnotationMaxLength bounds the text of an annotation
+	AnnotationMaxLength = 4096
+	// AnnotationTTL is how long the annotations of a request are kept after the last one
+	AnnotationTTL = 7 * 24 * time.Hour
+
+	ActionAnnotate = "annotate"
+)
+
+// ErrInvalidAnnotation is returned when an annotation has no author or text, or a text longer than AnnotationMaxLength
+var ErrInvalidAnnotation = errors.New("invalid annotation")
+
+func annotationKey(flowName string, requestId string) string {
+	return fmt.Sprintf("%s:%s:%s", AnnotationKeyInitial, flowName, requestId)
+}
+
+// AnnotateRequest attaches a note of an operator to a request. The annotations are kept apart from the state of the
+// request, so that they survive its pause and resume, are appended to its archived task with DurableTasksEnabled
+// and are copied into its execution history once it ends
+func (fRuntime *FlowRuntime) AnnotateRequest(ctx context.Context, flowName string, requestId string, author string,
+	text string) (*executor.Annotation, error) {
+	flowName, err := fRuntime.resolveFlowName(flowName)
+	if err != nil {
+		return nil, err
+	}
+	if author == "" || text == "" {
+		return nil, fmt.Errorf("%w, author and text must be provided", ErrInvalidAnnotation)
+	}
+	if len(text) > AnnotationMaxLength {
+		return nil, fmt.Errorf("%w, text is longer than %d bytes", ErrInvalidAnnotation, AnnotationMaxLength)
+	}
+
+	annotation := &executor.Annotation{Author: author, Text: text, Time: fRuntime.clock().Now()}
+	data, err := json.Marshal(annotation)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal annotation, error %v", err)
+	}
+	key := annotationKey(flowName, requestId)
+	pipe := fRuntime.redisClient().TxPipeline()
+	pipe.RPush(ctx, key, data)
+	pipe.LTrim(ctx, key, -AnnotationMaxEntries, -1)
+	pipe.Expire(ctx, key, AnnotationTTL)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, fmt.Errorf("failed to annotate request %s, error %v", requestId, err)
+	}
+	err = fRuntime.updateArchivedTask(requestId, func(task *Task) {
+		task.Annotations = append(task.Annotations, annotation)
+		if len(task.Annotations) > AnnotationMaxEntries {
+			task.Annotations = task.Annotations[len(task.Annotations)-AnnotationMaxEntries:]
+		}
+	})
+	if err != nil {
+		fRuntime.Logger.Log(fmt.Sprintf("[request `%s`] failed to annotate archived task, error %v", requestId, err))
+	}
+	return annotation, nil
+}
+
+// GetAnnotations returns the annotations of a request, oldest first
+func (fRuntime *FlowRuntime) GetAnnotations(ctx context.Context, flowName string, requestId string) ([]*executor.Annotation, error) {
+	values, err := fRuntime.redisClient().LRange(ctx, annotationKey(flowName, requestId), 0, -1).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get annotations of request %s, error %v", requestId, err)
+	}
+	return parseAnnotations(values), nil
+}
+
+func parseAnnotations(values []string) []*executor.Annotation {
+	annotations := make([]*executor.Annotation, 0, len(values))
+	for _, value := range values {
+		annotation := &executor.Annotation{}
+		if err := json.Unmarshal([]byte(value), annotation); err != nil {
+			continue
+		}
+		annotations = append(annotations, annotation)
+	}
+	return annotations
+}
+
+// annotateRequestHandler attaches an annotation to a request, authored by the principal of the http request. The
+// annotations written are recorded in the audit log
+func annotateRequestHandler(runtime *FlowRuntime) func(*gin.Context) {
+	fn := func(c *gin.Context) {
+		flowName, ok := flowNameParam(runtime, c)
+		if !ok {
+			return
+		}
+		requestId := c.Param(RequestIdParamName)
+		body, err := ioutil.ReadAll(c.Request.Body)
+		if err != nil {
+			runtimeCommon.HandleError(c.Writer, fmt.Sprintf("failed to read annotation, %v", err))
+			return
+		}
+		principal := runtime.requestPrincipal(c, body)
+		if !runtime.authorizeRequest(c, body, ActionAnnotate, flowName, requestId) {
+			return
+		}
+		input := struct {
+			Text string `json:"text"`
+		}{}
+		if err := json.Unmarshal(body, &input); err != nil {
+			c.String(http.StatusBadRequest, "invalid annotation, %v", err)
+			return
+		}
+
+		annotation, err := runtime.AnnotateRequest(c.Request.Context(), flowName, requestId, principal.Name, input.Text)
+		if errors.Is(err, ErrInvalidAnnotation) {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+		if err != nil {
+			runtimeCommon.HandleError(c.Writer, fmt.Sprintf("Failed to annotate request, %v", err))
+			return
+		}
+		entry := &AuditEntry{
+			Time:      annotation.Time,
+			Principal: principal,
+			Action:    ActionAnnotate,
+			Flow:      flowName,
+			RequestID: requestId,
+			Note:      fmt.Sprintf("%s: %s", annotation.Author, annotation.Text),
+		}
+		if err := runtime.appendAuditLog(entry); err != nil {
+			runtime.Logger.Log(fmt.Sprintf("[goflow] failed to append to audit log, error %v", err))
+		}
+		c.JSON(http.StatusOK, annotation)
+	}
+	return fn
+}
+
+func annotationsHandler(runtime *FlowRuntime) func(*gin.Context) {
+	fn := func(c *gin.Context) {
+		flowName, ok := flowNameParam(runtime, c)
+		if !ok {
+			return
+		}
+		requestId := c.Param(RequestIdParamName)
+
+		annotations, err := runtime.GetAnnotations(c.Request.Context(), flowName, requestId)
+		if err != nil {
+			runtimeCommon.HandleError(c.Writer, fmt.Sprintf("Failed to get annotations, %v", err))
+			return
+		}
+		c.JSON(http.StatusOK, annotations)
+	}
+	return fn
+}
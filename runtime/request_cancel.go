@@ -0,0 +1,232 @@
+package runtime
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/adjust/rmq/v5"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"github.com/yuyang0/goflow/metrics"
+	runtimeCommon "github.com/yuyang0/goflow/runtime/common"
+)
+
+const (
+	CanceledRequestKeyInitial = "goflow-canceled-request"
+
+	// CanceledRequestTTL bounds how long a cancellation waits for the request to be picked up
+	CanceledRequestTTL = 24 * time.Hour
+
+	ActionCancel = "cancel"
+)
+
+// ErrRequestStarted is returned when canceling a request that is already executing or was handled
+var ErrRequestStarted = errors.New("request has already started")
+
+var canceledRequestsCounter = metrics.NewCounterVec("goflow_canceled_requests_total",
+	"Requests canceled before being started", "flow")
+
+// removeQueuedRequestScript removes the new request task of a request from the ready list of a queue, searching
+// ARGV[3] tasks from the oldest like queuePositionScript. It returns 1 if removed, 0 otherwise
+var removeQueuedRequestScript = redis.NewScript(`
+local idx = redis.call("LLEN", KEYS[1]) - 1
+local limit = tonumber(ARGV[3])
+local scanned = 0
+while idx >= 0 and scanned < limit do
+	local from = math.max(idx - 99, 0)
+	local items = redis.call("LRANGE", KEYS[1], from, idx)
+	for i = #items, 1, -1 do
+		scanned = scanned + 1
+		if string.find(items[i], ARGV[1], 1, true) then
+			local task = cjson.decode(items[i])
+			if task["request_type"] == ARGV[2] then
+				return redis.call("LREM", KEYS[1], 1, items[i])
+			end
+		end
+		if scanned >= limit then
+			break
+		end
+	end
+	idx = from - 1
+end
+return 0
+`)
+
+func canceledRequestKey(flowName string, requestId string) string {
+	return fmt.Sprintf("%s:%s:%s", CanceledRequestKeyInitial, flowName, requestId)
+}
+
+// markCanceledScript marks a request canceled unless it has ever started, it returns 0 if it has
+var markCanceledScript = redis.NewScript(`
+if redis.call("HGET", KEYS[1], "started") then
+	return 0
+end
+redis.call("SET", KEYS[2], ARGV[1], "EX", ARGV[2])
+return 1
+`)
+
+// startRequestScript claims the start of a request unless it was canceled before ever starting, and records it
+// as executing on ARGV[1] when set. It returns the sequence number of the transition, 0 if not recorded, and -1
+// if the request is canceled
+var startRequestScript = redis.NewScript(`
+if not redis.call("HGET", KEYS[1], "started") and redis.call("EXISTS", KEYS[2]) == 1 then
+	return -1
+end
+redis.call("HSET", KEYS[1], "started", "1")
+local seq = 0
+if ARGV[1] ~= "" then
+	redis.call("HSET", KEYS[1], "queue", ARGV[1], "status", ARGV[2], "worker", ARGV[3], "transitioned_at", ARGV[4])
+	seq = redis.call("HINCRBY", KEYS[1], "seq", 1)
+end
+redis.call("EXPIRE", KEYS[1], ARGV[5])
+return seq
+`)
+
+// Cancel cancels a request queued with Execute that has never started. The request is removed from the
+// queues of its flow when found there, otherwise it is marked canceled and skipped once a worker picks it up.
+// ErrRequestStarted is returned when the request has started once, even if it is now waiting for a retry
+func (fRuntime *FlowRuntime) Cancel(flowName string, requestId string) error {
+	if requestId == "" {
+		return fmt.Errorf("request id must be provided")
+	}
+	flowName, err := fRuntime.resolveFlowName(flowName)
+	if err != nil {
+		return err
+	}
+
+	// marked first, atomically with the start of the request, so that a worker either skips it or it has started
+	ctx := context.TODO()
+	rdb := fRuntime.redisClient()
+	key := canceledRequestKey(flowName, requestId)
+	marked, err := markCanceledScript.Run(ctx, rdb, []string{locationKey(flowName, requestId), key},
+		fRuntime.clock().Now().Unix(), int64(CanceledRequestTTL.Seconds())).Int()
+	if err != nil {
+		return fmt.Errorf("failed to cancel request %s, error %v", requestId, err)
+	}
+	if marked == 0 {
+		return fmt.Errorf("%w, request %s of flow %s", ErrRequestStarted, requestId, flowName)
+	}
+	removed, err := fRuntime.removeQueuedRequest(ctx, flowName, requestId)
+	if err == nil && removed {
+		fRuntime.finishCanceledRequest(flowName, requestId)
+		return nil
+	}
+	if err == nil {
+		var location *RequestLocation
+		location, err = fRuntime.LocateRequest(ctx, flowName, requestId)
+		if errors.Is(err, ErrRequestNotLocated) {
+			err = fmt.Errorf("%w, request %s of flow %s", ErrRequestNotQueued, requestId, flowName)
+		} else if err == nil && location.Status != LocationStatusQueued {
+			err = fmt.Errorf("%w, request %s of flow %s is %s", ErrRequestStarted, requestId, flowName, location.Status)
+		}
+	}
+	if err != nil {
+		if derr := rdb.Del(ctx, key).Err(); derr != nil {
+			fRuntime.Logger.Log(fmt.Sprintf("[request `%s`] failed to clear cancellation, error %v", requestId, derr))
+		}
+		return err
+	}
+	// the request is on a queue that isn't searched, or is being picked up
+	return nil
+}
+
+// removeQueuedRequest removes the new request task of a request from the queues of its flow, it returns
+// false if the task was not found
+func (fRuntime *FlowRuntime) removeQueuedRequest(ctx context.Context, flowName string, requestId string) (bool, error) {
+	queueIds := []string{fRuntime.internalRequestQueueId(flowName)}
+	for idx := 0; idx < fRuntime.PartitionCount; idx++ {
+		queueIds = append(queueIds, fRuntime.partitionQueueId(flowName, idx))
+	}
+
+	quotedId, _ := json.Marshal(requestId)
+	needle := `"request_id":` + string(quotedId)
+	for _, queueId := range queueIds {
+		removed, err := removeQueuedRequestScript.Run(ctx, fRuntime.redisClient(), []string{readyQueueKey(queueId)},
+			needle, string(NewRequest), QueuePositionMaxScan).Int64()
+		if err != nil {
+			return false, fmt.Errorf("failed to search queue %s, error %v", queueId, err)
+		}
+		if removed > 0 {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// startRequest claims the start of the request of a new request task, recording it as executing on the worker
+// when queue is set. It returns the sequence number of the transition, 0 if not recorded, and whether the request
+// was canceled before ever starting
+func (fRuntime *FlowRuntime) startRequest(task *Task, queue string) (int64, bool) {
+	seq, err := startRequestScript.Run(context.TODO(), fRuntime.redisClient(),
+		[]string{locationKey(task.FlowName, task.RequestID), canceledRequestKey(task.FlowName, task.RequestID)},
+		queue, LocationStatusExecuting, fRuntime.WorkerID(), fRuntime.clock().Now().UnixNano(),
+		int64(LocationTTL.Seconds())).Int64()
+	if err != nil {
+		fRuntime.Logger.Log(fmt.Sprintf("[request `%s`] failed to start request, error %v", task.RequestID, err))
+		return 0, false
+	}
+	if seq < 0 {
+		return 0, true
+	}
+	return seq, false
+}
+
+// ackCanceledTask acknowledges the task of a canceled request without executing it
+func (fRuntime *FlowRuntime) ackCanceledTask(message rmq.Delivery, task *Task) {
+	fRuntime.finishCanceledRequest(task.FlowName, task.RequestID)
+	if err := message.Ack(); err != nil {
+		fRuntime.handleQueueError(QueueOperationAck, task, err)
+	}
+}
+
+// finishCanceledRequest records a request canceled before being started, and removes what was stored for it
+// upfront, such as its body or its deadline
+func (fRuntime *FlowRuntime) finishCanceledRequest(flowName string, requestId string) {
+	canceledRequestsCounter.Inc(flowName)
+	fRuntime.Logger.Log(fmt.Sprintf("[request `%s`] canceled before being started", requestId))
+	if err := fRuntime.redisClient().Del(context.TODO(), canceledRequestKey(flowName, requestId)).Err(); err != nil {
+		fRuntime.Logger.Log(fmt.Sprintf("[request `%s`] failed to clear cancellation, error %v", requestId, err))
+	}
+	fRuntime.recordHistory(flowName, requestId, HistoryStatusCanceled, nil)
+	fRuntime.finishLocation(flowName, requestId, LocationStatusCanceled)
+
+	if dataStore, err := fRuntime.requestDataStore(flowName, requestId); err == nil {
+		dataStore.Cleanup()
+	}
+	if fRuntime.stateStore != nil {
+		if stateStore, err := fRuntime.stateStore.CopyStore(); err == nil {
+			stateStore.Configure(flowName, requestId)
+			stateStore.Cleanup()
+		}
+	}
+}
+
+func cancelRequestHandler(runtime *FlowRuntime) func(*gin.Context) {
+	fn := func(c *gin.Context) {
+		flowName, ok := flowNameParam(runtime, c)
+		if !ok {
+			return
+		}
+		requestId := c.Param(RequestIdParamName)
+		if !runtime.authorizeRequest(c, nil, ActionCancel, flowName, requestId) {
+			return
+		}
+
+		err := runtime.Cancel(flowName, requestId)
+		switch {
+		case errors.Is(err, ErrRequestNotQueued):
+			c.String(http.StatusNotFound, err.Error())
+		case errors.Is(err, ErrRequestStarted):
+			c.String(http.StatusConflict, err.Error())
+		case err != nil:
+			runtimeCommon.HandleError(c.Writer, fmt.Sprintf("Failed to cancel request, %v", err))
+		default:
+			c.String(http.StatusOK, "Request canceled")
+		}
+	}
+	return fn
+}
@@ -0,0 +1,130 @@
+package runtime_test
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	flow "github.com/yuyang0/goflow/flow/v1"
+	"github.com/yuyang0/goflow/runtime"
+	goflow "github.com/yuyang0/goflow/v1"
+)
+
+// TestCancelRacingConsume checks that a request racing with its cancellation is either canceled and never
+// executed, or reported as started and executed
+func TestCancelRacingConsume(t *testing.T) {
+	var mu sync.Mutex
+	executed := make(map[string]int)
+	recorded := func(wf *flow.Workflow, _ *flow.Context) error {
+		wf.Dag().Node("record", func(data []byte, _ map[string][]string) ([]byte, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			executed[string(data)]++
+			return data, nil
+		})
+		return nil
+	}
+
+	fs := &goflow.FlowService{WorkerConcurrency: 4}
+	_, client := startWorker(t, fs, map[string]runtime.FlowDefinitionHandler{"canceled": recorded}, nil)
+	canceler := &goflow.FlowService{RedisCfg: client.RedisCfg}
+	if err := client.Execute("canceled", &goflow.Request{Body: []byte("ready")}); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, 15*time.Second, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return executed["ready"] > 0
+	}, "the worker did not start")
+
+	const requests = 50
+	submitted := make(chan string, requests)
+	results := make(map[string]error)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for requestId := range submitted {
+			// spreads the cancellations over the polls of the consumers
+			time.Sleep(time.Duration(len(results)%5) * 20 * time.Millisecond)
+			results[requestId] = canceler.Cancel("canceled", requestId)
+		}
+	}()
+	for idx := 0; idx < requests; idx++ {
+		requestId := fmt.Sprintf("request-%d", idx)
+		if err := client.Execute("canceled", &goflow.Request{RequestId: requestId, Body: []byte(requestId)}); err != nil {
+			t.Fatal(err)
+		}
+		submitted <- requestId
+	}
+	close(submitted)
+	<-done
+
+	started := 0
+	for requestId, err := range results {
+		switch {
+		case err == nil:
+		case errors.Is(err, runtime.ErrRequestStarted):
+			started++
+		default:
+			t.Fatalf("request %s: unexpected cancellation error %v", requestId, err)
+		}
+	}
+	eventually(t, 15*time.Second, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(executed) >= started+1
+	}, "the started requests were not executed")
+	time.Sleep(time.Second)
+
+	mu.Lock()
+	defer mu.Unlock()
+	for requestId, err := range results {
+		count := executed[requestId]
+		if err == nil && count != 0 {
+			t.Fatalf("request %s was canceled but executed", requestId)
+		}
+		if err != nil && count != 1 {
+			t.Fatalf("request %s was started but executed %d times", requestId, count)
+		}
+	}
+}
+
+// TestCancelRetriedRequest checks that a request retried after its first execution can't be canceled
+func TestCancelRetriedRequest(t *testing.T) {
+	var mu sync.Mutex
+	attempts := 0
+	release := make(chan struct{})
+	failingOnce := func(wf *flow.Workflow, _ *flow.Context) error {
+		wf.Dag().Node("call", func(data []byte, _ map[string][]string) ([]byte, error) {
+			mu.Lock()
+			attempts++
+			attempt := attempts
+			mu.Unlock()
+			if attempt == 1 {
+				return nil, fmt.Errorf("upstream unavailable")
+			}
+			<-release
+			return data, nil
+		})
+		return nil
+	}
+
+	fs := &goflow.FlowService{RetryCount: 1}
+	_, client := startWorker(t, fs, map[string]runtime.FlowDefinitionHandler{"retried": failingOnce}, nil)
+	defer close(release)
+	if err := client.Execute("retried", &goflow.Request{RequestId: "retried-request", Body: []byte("{}")}); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, 15*time.Second, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return attempts >= 1
+	}, "the request was not executed")
+
+	canceler := &goflow.FlowService{RedisCfg: client.RedisCfg}
+	if err := canceler.Cancel("retried", "retried-request"); !errors.Is(err, runtime.ErrRequestStarted) {
+		t.Fatalf("expected ErrRequestStarted, got %v", err)
+	}
+}
@@ -0,0 +1,314 @@
+package runtime
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/adjust/rmq/v5"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	runtimeCommon "github.com/yuyang0/goflow/runtime/common"
+)
+
+const (
+	LocationKeyInitial = "goflow-location"
+
+	// LocationTTL is how long the location of a request is kept after its last transition
+	LocationTTL = 7 * 24 * time.Hour
+
+	// the queues of a flow a request can be on, along with push-<n>, partition-<n>, worker:<id> and dlq:<queue>
+	QueueLocationMain       = "main"
+	QueueLocationDeadLetter = "dlq"
+
+	LocationStatusQueued       = "queued"
+	LocationStatusExecuting    = "executing"
+	LocationStatusIdle         = "idle" // handled, until a continuation or a resume of the request is queued
+	LocationStatusCompleted    = "completed"
+	LocationStatusFailed       = "failed"
+	LocationStatusDeadLettered = "dead_lettered"
+	LocationStatusRejected     = "rejected" // failed on the last queue of its retries, left in its rejected list
+	LocationStatusCanceled     = "canceled" // canceled before being started
+)
+
+// ErrRequestNotLocated is returned when no transition of a request was recorded within LocationTTL
+var ErrRequestNotLocated = errors.New("request location is unknown")
+
+// RequestLocation is where a request is, the queue it is waiting on or executing from, or its terminal status
+type RequestLocation struct {
+	RequestID      string    `json:"request_id"`
+	Status         string    `json:"status"`
+	Queue          string    `json:"queue,omitempty"`
+	Worker         string    `json:"worker,omitempty"` // the worker executing the request
+	Terminal       bool      `json:"terminal"`
+	TransitionedAt time.Time `json:"transitioned_at"`
+}
+
+func locationKey(flowName string, requestId string) string {
+	return fmt.Sprintf("%s:%s:%s", LocationKeyInitial, flowName, requestId)
+}
+
+func pushQueueLocation(idx int) string {
+	return fmt.Sprintf("push-%d", idx)
+}
+
+// retryQueueLocation returns the location of the idx-th push queue of the retries, empty past the last one
+func (fRuntime *FlowRuntime) retryQueueLocation(idx int) string {
+	if idx < 0 || idx >= fRuntime.RetryQueueCount {
+		return ""
+	}
+	return pushQueueLocation(idx)
+}
+
+func partitionQueueLocation(idx int) string {
+	return fmt.Sprintf("partition-%d", idx)
+}
+
+func workerQueueLocation(workerID string) string {
+	return fmt.Sprintf("worker:%s", workerID)
+}
+
+func deadLetterQueueLocation(queue string) string {
+	if queue == "" {
+		return QueueLocationDeadLetter
+	}
+	return fmt.Sprintf("%s:%s", QueueLocationDeadLetter, queue)
+}
+
+// isTerminalLocation checks if a request no longer moves between the queues
+func isTerminalLocation(status string) bool {
+	switch status {
+	case LocationStatusCompleted, LocationStatusFailed, LocationStatusDeadLettered, LocationStatusRejected,
+		LocationStatusCanceled:
+		return true
+	}
+	return false
+}
+
+// tracksLocation checks if the location of the request of a task is recorded, the control tasks of a request
+// are not tracked as they don't move it
+func tracksLocation(task *Task) bool {
+	return task != nil && task.RequestID != "" && (task.RequestType == NewRequest || task.RequestType == PartialRequest)
+}
+
+// addLocation queues a transition of a request on pipe, the queue is kept when empty, e.g. for the terminal
+// transitions. The transitions are written along with the writes they follow, e.g. the publish of the task, and the
+// returned command holds the sequence number of the transition once pipe is executed
+func (fRuntime *FlowRuntime) addLocation(ctx context.Context, pipe redis.Pipeliner, flowName string, requestId string,
+	queue string, status string, worker string) *redis.IntCmd {
+	key := locationKey(flowName, requestId)
+	fields := []interface{}{"status", status, "worker", worker, "transitioned_at", fRuntime.clock().Now().UnixNano()}
+	if queue != "" {
+		fields = append(fields, "queue", queue)
+	}
+	pipe.HSet(ctx, key, fields...)
+	seq := pipe.HIncrBy(ctx, key, "seq", 1)
+	pipe.Expire(ctx, key, LocationTTL)
+	return seq
+}
+
+// addHandledLocation queues the acknowledgment of the execution started by the transition seq on pipe, the request
+// is reported idle from then on unless it moved on since, e.g. it completed or a continuation was queued
+func (fRuntime *FlowRuntime) addHandledLocation(ctx context.Context, pipe redis.Pipeliner, flowName string,
+	requestId string, seq int64) {
+	key := locationKey(flowName, requestId)
+	pipe.HSet(ctx, key, "handled", seq, "handled_at", fRuntime.clock().Now().UnixNano())
+	pipe.Expire(ctx, key, LocationTTL)
+}
+
+// logLocation records a transition of a request on its own, a transition failing to be recorded is logged
+func (fRuntime *FlowRuntime) logLocation(flowName string, requestId string, queue string, status string, worker string) {
+	ctx := context.TODO()
+	pipe := fRuntime.redisClient().Pipeline()
+	fRuntime.addLocation(ctx, pipe, flowName, requestId, queue, status, worker)
+	if _, err := pipe.Exec(ctx); err != nil {
+		fRuntime.Logger.Log(fmt.Sprintf("[request `%s`] failed to record location, error %v", requestId, err))
+	}
+}
+
+// finishLocation records the terminal status of a request, on the queue it was last on
+func (fRuntime *FlowRuntime) finishLocation(flowName string, requestId string, status string) {
+	if requestId == "" {
+		return
+	}
+	fRuntime.logLocation(flowName, requestId, "", status, "")
+}
+
+// flushWrites sends the bookkeeping writes batched on pipe in a single round trip, they are best effort and a
+// failing batch is logged
+func (fRuntime *FlowRuntime) flushWrites(pipe redis.Pipeliner) {
+	if pipe.Len() == 0 {
+		return
+	}
+	if _, err := pipe.Exec(context.TODO()); err != nil && err != redis.Nil {
+		fRuntime.Logger.Log(fmt.Sprintf("[goflow] failed to record task bookkeeping, error %v", err))
+	}
+}
+
+// LocateRequest returns where a request currently is
+func (fRuntime *FlowRuntime) LocateRequest(ctx context.Context, flowName string, requestId string) (*RequestLocation, error) {
+	flowName, err := fRuntime.resolveFlowName(flowName)
+	if err != nil {
+		return nil, err
+	}
+	locations, err := fRuntime.getLocations(ctx, flowName, []string{requestId})
+	if err != nil {
+		return nil, err
+	}
+	location, ok := locations[requestId]
+	if !ok {
+		return nil, fmt.Errorf("%w, request %s of flow %s", ErrRequestNotLocated, requestId, flowName)
+	}
+	return location, nil
+}
+
+// getLocations returns the locations of the requests of a flow that are known, keyed by request id. A request
+// executing on a worker whose registration expired is reported queued, its delivery being returned to its queue
+func (fRuntime *FlowRuntime) getLocations(ctx context.Context, flowName string, requestIds []string) (map[string]*RequestLocation, error) {
+	pipe := fRuntime.redisClient().Pipeline()
+	cmds := make([]*redis.MapStringStringCmd, len(requestIds))
+	for idx, requestId := range requestIds {
+		cmds[idx] = pipe.HGetAll(ctx, locationKey(flowName, requestId))
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, fmt.Errorf("failed to get location of requests, error %v", err)
+	}
+
+	locations := make(map[string]*RequestLocation)
+	workers := make(map[string]*redis.IntCmd)
+	for idx, cmd := range cmds {
+		fields := cmd.Val()
+		if fields["status"] == "" {
+			// unknown, or only started by a consumer that doesn't track the location
+			continue
+		}
+		location := &RequestLocation{
+			RequestID:      requestIds[idx],
+			Status:         fields["status"],
+			Queue:          fields["queue"],
+			Worker:         fields["worker"],
+			Terminal:       isTerminalLocation(fields["status"]),
+			TransitionedAt: time.Unix(0, parseNanos(fields["transitioned_at"])),
+		}
+		if location.Status == LocationStatusExecuting && fields["handled"] != "" && fields["handled"] == fields["seq"] {
+			location.Status, location.Worker = LocationStatusIdle, ""
+			location.TransitionedAt = time.Unix(0, parseNanos(fields["handled_at"]))
+		}
+		if location.Status == LocationStatusExecuting && location.Worker != "" {
+			workers[location.Worker] = nil
+		}
+		locations[requestIds[idx]] = location
+	}
+	if len(workers) == 0 {
+		return locations, nil
+	}
+
+	pipe = fRuntime.redisClient().Pipeline()
+	for workerID := range workers {
+		workers[workerID] = pipe.Exists(ctx, fmt.Sprintf("%s:%s", WorkerKeyInitial, workerID))
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		// the locations are returned as recorded
+		fRuntime.Logger.Log(fmt.Sprintf("[goflow] failed to check workers of requests, error %v", err))
+		return locations, nil
+	}
+	for _, location := range locations {
+		if location.Status == LocationStatusExecuting && workers[location.Worker] != nil && workers[location.Worker].Val() == 0 {
+			location.Status, location.Worker = LocationStatusQueued, ""
+		}
+	}
+	return locations, nil
+}
+
+func parseNanos(value string) int64 {
+	nanos, _ := strconv.ParseInt(value, 10, 64)
+	return nanos
+}
+
+// queueConsumer consumes a queue of the requests of a flow, recording the location of the requests it handles
+type queueConsumer struct {
+	runtime   *FlowRuntime
+	queue     string // location of the tasks of the queue, untracked if empty
+	pushQueue string // location of the tasks pushed on failure, they are rejected if empty
+	pushIdx   int    // index of the push queue of pushQueue
+	lastRetry bool   // dead letter the failed tasks, see DeadLetterRouting
+}
+
+func (consumer *queueConsumer) Consume(message rmq.Delivery) {
+	consumer.runtime.consume(message, consumer)
+}
+
+// startLocation records that the request of a consumed task is executing on the worker, the transition of a partial
+// request being queued on writes. It returns the sequence number of the transition, 0 if not recorded, once writes
+// is flushed, and whether the request of a new request task was canceled
+func (consumer *queueConsumer) startLocation(task *Task, writes redis.Pipeliner) (*redis.IntCmd, bool) {
+	fRuntime := consumer.runtime
+	ctx := context.TODO()
+	if consumer.queue != "" && tracksLocation(task) {
+		fRuntime.addRouting(ctx, writes, task.FlowName, task.RequestID, consumer.queue, RoutingActionConsumed)
+	}
+	if task.RequestType == NewRequest && task.RequestID != "" {
+		seq, canceled := fRuntime.startRequest(task, consumer.queue)
+		return redis.NewIntResult(seq, nil), canceled
+	}
+	if consumer.queue == "" || !tracksLocation(task) {
+		return redis.NewIntResult(0, nil), false
+	}
+	return fRuntime.addLocation(ctx, writes, task.FlowName, task.RequestID, consumer.queue, LocationStatusExecuting,
+		fRuntime.WorkerID()), false
+}
+
+// pushLocation queues on writes that the request of a failed task was pushed to the next queue of its retries
+func (consumer *queueConsumer) pushLocation(task *Task, writes redis.Pipeliner) {
+	if consumer.queue == "" || !tracksLocation(task) {
+		return
+	}
+	ctx := context.TODO()
+	if consumer.pushQueue == "" {
+		consumer.runtime.addRouting(ctx, writes, task.FlowName, task.RequestID, consumer.queue, RoutingActionRejected)
+		consumer.runtime.addLocation(ctx, writes, task.FlowName, task.RequestID, consumer.queue, LocationStatusRejected, "")
+		return
+	}
+	consumer.runtime.addRouting(ctx, writes, task.FlowName, task.RequestID, consumer.pushQueue,
+		pushedToRetryAction(consumer.pushQueue))
+	consumer.runtime.addLocation(ctx, writes, task.FlowName, task.RequestID, consumer.pushQueue, LocationStatusQueued, "")
+}
+
+// ackLocation queues on writes that the request of an acknowledged task is idle, unless it moved on while it was
+// handled, e.g. it completed or a continuation was queued
+func (consumer *queueConsumer) ackLocation(task *Task, seq int64, writes redis.Pipeliner) {
+	if consumer.queue == "" || !tracksLocation(task) {
+		return
+	}
+	ctx := context.TODO()
+	consumer.runtime.addRouting(ctx, writes, task.FlowName, task.RequestID, consumer.queue, RoutingActionAcked)
+	if seq <= 0 {
+		return
+	}
+	consumer.runtime.addHandledLocation(ctx, writes, task.FlowName, task.RequestID, seq)
+}
+
+func locateRequestHandler(runtime *FlowRuntime) func(*gin.Context) {
+	fn := func(c *gin.Context) {
+		flowName, ok := flowNameParam(runtime, c)
+		if !ok {
+			return
+		}
+		requestId := c.Param(RequestIdParamName)
+
+		location, err := runtime.LocateRequest(c.Request.Context(), flowName, requestId)
+		if errors.Is(err, ErrRequestNotLocated) {
+			c.String(http.StatusNotFound, err.Error())
+			return
+		}
+		if err != nil {
+			runtimeCommon.HandleError(c.Writer, fmt.Sprintf("Failed to locate request, %v", err))
+			return
+		}
+		c.JSON(http.StatusOK, location)
+	}
+	return fn
+}
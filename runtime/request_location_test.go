@@ -0,0 +1,50 @@
+package runtime_test
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/yuyang0/goflow/runtime"
+	"github.com/yuyang0/goflow/types"
+)
+
+func TestLocateRequest(t *testing.T) {
+	mr := miniredis.RunT(t)
+	fRuntime := &runtime.FlowRuntime{RedisCfg: types.RedisConfig{Addr: mr.Addr()}}
+	if err := fRuntime.Init(); err != nil {
+		t.Fatal(err)
+	}
+	mr.Set(runtime.WorkerKeyInitial+":alive", "{}")
+	transitionedAt := strconv.FormatInt(time.Now().UnixNano(), 10)
+	locate := func(requestId string, fields ...string) *runtime.RequestLocation {
+		t.Helper()
+		mr.HSet(runtime.LocationKeyInitial+":flow:"+requestId, append([]string{"transitioned_at", transitionedAt}, fields...)...)
+		location, err := fRuntime.LocateRequest(context.Background(), "flow", requestId)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return location
+	}
+
+	location := locate("executing", "status", runtime.LocationStatusExecuting, "queue", "main", "worker", "alive", "seq", "2")
+	if location.Status != runtime.LocationStatusExecuting || location.Worker != "alive" {
+		t.Fatalf("expected the request to be executing on the worker, got %+v", location)
+	}
+	location = locate("handled", "status", runtime.LocationStatusExecuting, "queue", "main", "worker", "alive", "seq", "2",
+		"handled", "2", "handled_at", transitionedAt)
+	if location.Status != runtime.LocationStatusIdle || location.Worker != "" {
+		t.Fatalf("expected the acknowledged request to be idle, got %+v", location)
+	}
+	location = locate("moved", "status", runtime.LocationStatusExecuting, "queue", "main", "worker", "alive", "seq", "3",
+		"handled", "2")
+	if location.Status != runtime.LocationStatusExecuting {
+		t.Fatalf("expected the request executing again since its acknowledgment to be executing, got %+v", location)
+	}
+	location = locate("stranded", "status", runtime.LocationStatusExecuting, "queue", "push-0", "worker", "dead", "seq", "1")
+	if location.Status != runtime.LocationStatusQueued || location.Queue != "push-0" || location.Worker != "" {
+		t.Fatalf("expected the request of the dead worker to be queued back, got %+v", location)
+	}
+}
@@ -0,0 +1,171 @@
+package runtime
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yuyang0/goflow/core/sdk"
+	runtimeCommon "github.com/yuyang0/goflow/runtime/common"
+)
+
+const (
+	RequestLogKeyInitial = "goflow-request-logs"
+
+	DefaultRequestLogMaxEntries = 1000
+	DefaultRequestLogTTL        = 24 * time.Hour
+	// RequestLogMaxMessageBytes is the size above which a captured message is truncated
+	RequestLogMaxMessageBytes = 4096
+	// RequestLogFlushInterval is the interval the workers write the logs they captured at
+	RequestLogFlushInterval = time.Second
+)
+
+// RequestLogEntry is a log message emitted during the execution of a request
+type RequestLogEntry struct {
+	Time    time.Time `json:"time"`
+	Message string    `json:"message"`
+}
+
+func requestLogKey(flowName string, requestID string) string {
+	return fmt.Sprintf("%s:%s:%s", RequestLogKeyInitial, flowName, requestID)
+}
+
+func (fRuntime *FlowRuntime) requestLogMaxEntries() int {
+	if fRuntime.RequestLogMaxEntries <= 0 {
+		return DefaultRequestLogMaxEntries
+	}
+	return fRuntime.RequestLogMaxEntries
+}
+
+func (fRuntime *FlowRuntime) requestLogTTL() time.Duration {
+	if fRuntime.RequestLogTTL <= 0 {
+		return DefaultRequestLogTTL
+	}
+	return fRuntime.RequestLogTTL
+}
+
+// GetRequestLogs returns the captured logs of a request, oldest first
+func (fRuntime *FlowRuntime) GetRequestLogs(ctx context.Context, flowName string, requestID string) ([]*RequestLogEntry, error) {
+	values, err := fRuntime.redisClient().LRange(ctx, requestLogKey(flowName, requestID), 0, -1).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get logs of request %s, error %v", requestID, err)
+	}
+	entries := make([]*RequestLogEntry, 0, len(values))
+	for _, value := range values {
+		entry := &RequestLogEntry{}
+		if err := json.Unmarshal([]byte(value), entry); err != nil {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// requestLogBuffer is the logs captured by the worker since they were last flushed to redis, by request log key
+type requestLogBuffer struct {
+	mu      sync.Mutex
+	entries map[string][][]byte
+}
+
+// add buffers the entries of a request log, keeping the latest maxEntries
+func (buffer *requestLogBuffer) add(key string, maxEntries int, entries ...[]byte) {
+	buffer.mu.Lock()
+	defer buffer.mu.Unlock()
+	if buffer.entries == nil {
+		buffer.entries = make(map[string][][]byte)
+	}
+	buffered := append(buffer.entries[key], entries...)
+	if len(buffered) > maxEntries {
+		buffered = buffered[len(buffered)-maxEntries:]
+	}
+	buffer.entries[key] = buffered
+}
+
+func (buffer *requestLogBuffer) take() map[string][][]byte {
+	buffer.mu.Lock()
+	defer buffer.mu.Unlock()
+	entries := buffer.entries
+	buffer.entries = nil
+	return entries
+}
+
+// flushRequestLogs appends the logs captured by the worker since the last flush to the lists of their requests, the
+// logs failing to be flushed are written again in the next flush
+func (fRuntime *FlowRuntime) flushRequestLogs() {
+	entries := fRuntime.requestLogs.take()
+	if len(entries) == 0 {
+		return
+	}
+	ctx := context.TODO()
+	maxEntries := fRuntime.requestLogMaxEntries()
+	pipe := fRuntime.redisClient().Pipeline()
+	for key, data := range entries {
+		values := make([]interface{}, len(data))
+		for idx, value := range data {
+			values[idx] = value
+		}
+		pipe.RPush(ctx, key, values...)
+		pipe.LTrim(ctx, key, int64(-maxEntries), -1)
+		pipe.Expire(ctx, key, fRuntime.requestLogTTL())
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		fRuntime.Logger.Log(fmt.Sprintf("[goflow] failed to capture request logs, error %v", err))
+		for key, data := range entries {
+			fRuntime.requestLogs.add(key, maxEntries, data...)
+		}
+	}
+}
+
+// requestLogger tees the logs of a request to the Logger of the runtime and to its capped redis list, the logs are
+// written by the worker every RequestLogFlushInterval so that logging doesn't add a round trip to the execution
+type requestLogger struct {
+	sdk.Logger
+	runtime   *FlowRuntime
+	flowName  string
+	requestID string
+}
+
+func (logger *requestLogger) Configure(flowName string, requestId string) {
+	logger.flowName = flowName
+	logger.requestID = requestId
+	logger.Logger.Configure(flowName, requestId)
+}
+
+func (logger *requestLogger) Log(str string) {
+	logger.Logger.Log(str)
+
+	if len(str) > RequestLogMaxMessageBytes {
+		str = str[:RequestLogMaxMessageBytes] + "...(truncated)"
+	}
+	data, err := json.Marshal(&RequestLogEntry{Time: logger.runtime.clock().Now(), Message: str})
+	if err != nil {
+		return
+	}
+	key := requestLogKey(logger.flowName, logger.requestID)
+	logger.runtime.requestLogs.add(key, logger.runtime.requestLogMaxEntries(), data)
+}
+
+func requestLogsHandler(runtime *FlowRuntime) func(*gin.Context) {
+	fn := func(c *gin.Context) {
+		flowName, ok := flowNameParam(runtime, c)
+		if !ok {
+			return
+		}
+		requestId := c.Param(RequestIdParamName)
+
+		entries, err := runtime.GetRequestLogs(c.Request.Context(), flowName, requestId)
+		if err != nil {
+			runtimeCommon.HandleError(c.Writer, fmt.Sprintf("Failed to get request logs, %v", err))
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"request_id": requestId,
+			"entries":    entries,
+		})
+	}
+	return fn
+}
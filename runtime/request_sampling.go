@@ -0,0 +1,165 @@
+package runtime
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yuyang0/goflow/core/runtime/controller"
+	"github.com/yuyang0/goflow/metrics"
+	runtimeCommon "github.com/yuyang0/goflow/runtime/common"
+)
+
+const (
+	SampleKeyInitial = "goflow-sample"
+
+	// SampleMaxEntries is the number of sampled requests kept for a flow
+	SampleMaxEntries = 1000
+)
+
+// sampleRedactedHeaders are the credentials left out of the captured requests
+var sampleRedactedHeaders = []string{AuthorizationHeader, controller.AuthSignatureHeader}
+
+var sampledRequestsCounter = metrics.NewCounterVec("goflow_sampled_requests_total",
+	"Requests captured by the sampling of their flow", "flow")
+
+func sampleKey(flowName string) string {
+	return fmt.Sprintf("%s:%s", SampleKeyInitial, flowName)
+}
+
+// SetSamplingRate sets the share of the requests of a flow, from 0 to 1, that are captured by Execute along with their
+// body for debugging. A zero rate disables the sampling
+func (fRuntime *FlowRuntime) SetSamplingRate(flowName string, rate float64) {
+	rate = max(0, min(rate, 1))
+
+	fRuntime.samplingRatesMu.Lock()
+	defer fRuntime.samplingRatesMu.Unlock()
+	if rate == 0 {
+		delete(fRuntime.samplingRates, flowName)
+		return
+	}
+	if fRuntime.samplingRates == nil {
+		fRuntime.samplingRates = make(map[string]float64)
+	}
+	fRuntime.samplingRates[flowName] = rate
+}
+
+func (fRuntime *FlowRuntime) samplingRate(flowName string) float64 {
+	fRuntime.samplingRatesMu.RLock()
+	defer fRuntime.samplingRatesMu.RUnlock()
+	return fRuntime.samplingRates[flowName]
+}
+
+// sampleTask captures a submitted task depending on the sampling rate of its flow. The sampling is best effort,
+// a task failing to be captured is still submitted
+func (fRuntime *FlowRuntime) sampleTask(task *Task) {
+	if IsWarmupRequest(task.RequestID) {
+		return
+	}
+	rate := fRuntime.samplingRate(task.FlowName)
+	if rate == 0 || rand.Float64() >= rate {
+		return
+	}
+	sample := *task
+	sample.Header = redactSampleHeader(task.Header)
+	data, err := json.Marshal(&sample)
+	if err != nil {
+		return
+	}
+	ctx := context.TODO()
+	key := sampleKey(task.FlowName)
+	pipe := fRuntime.redisClient().TxPipeline()
+	pipe.LPush(ctx, key, data)
+	pipe.LTrim(ctx, key, 0, SampleMaxEntries-1)
+	if _, err := pipe.Exec(ctx); err != nil {
+		fRuntime.Logger.Log(fmt.Sprintf("[goflow] failed to sample request of flow %s, error %v", task.FlowName, err))
+		return
+	}
+	sampledRequestsCounter.Inc(task.FlowName)
+}
+
+// redactSampleHeader copies the header of a sampled request without its credentials
+func redactSampleHeader(header map[string][]string) map[string][]string {
+	if header == nil {
+		return nil
+	}
+	redacted := make(map[string][]string, len(header))
+	for name, values := range header {
+		if isSampleRedactedHeader(name) {
+			continue
+		}
+		redacted[name] = values
+	}
+	return redacted
+}
+
+func isSampleRedactedHeader(name string) bool {
+	for _, redacted := range sampleRedactedHeaders {
+		if strings.EqualFold(name, redacted) {
+			return true
+		}
+	}
+	return false
+}
+
+// debugLogging checks if the executions of a request are logged in full, for all the requests with DebugEnabled and
+// for a DebugSampleRate share of them otherwise. The requests are picked by the hash of their id, so that all the
+// executions of a sampled request are logged, on any worker
+func (fRuntime *FlowRuntime) debugLogging(requestId string) bool {
+	if fRuntime.DebugEnabled {
+		return true
+	}
+	if fRuntime.DebugSampleRate <= 0 || requestId == "" {
+		return false
+	}
+	hash := fnv.New64a()
+	hash.Write([]byte(requestId))
+	return float64(hash.Sum64()%10000) < fRuntime.DebugSampleRate*10000
+}
+
+// GetSampledRequests returns the latest sampled requests of a flow, newest first
+func (fRuntime *FlowRuntime) GetSampledRequests(ctx context.Context, flowName string, limit int) ([]*Task, error) {
+	if limit <= 0 || limit > SampleMaxEntries {
+		limit = SampleMaxEntries
+	}
+	values, err := fRuntime.redisClient().LRange(ctx, sampleKey(flowName), 0, int64(limit-1)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get sampled requests of flow %s, error %v", flowName, err)
+	}
+	tasks := make([]*Task, 0, len(values))
+	for _, value := range values {
+		task := &Task{}
+		if err := json.Unmarshal([]byte(value), task); err != nil {
+			continue
+		}
+		tasks = append(tasks, task)
+	}
+	return tasks, nil
+}
+
+func sampledRequestsHandler(runtime *FlowRuntime) func(*gin.Context) {
+	fn := func(c *gin.Context) {
+		flowName, ok := flowNameParam(runtime, c)
+		if !ok {
+			return
+		}
+		if !runtime.authorizeRequest(c, nil, ActionDiagnose, flowName, "") {
+			return
+		}
+		limit, _ := strconv.Atoi(c.Query("limit"))
+
+		tasks, err := runtime.GetSampledRequests(c.Request.Context(), flowName, limit)
+		if err != nil {
+			runtimeCommon.HandleError(c.Writer, fmt.Sprintf("Failed to get sampled requests, %v", err))
+			return
+		}
+		c.JSON(http.StatusOK, tasks)
+	}
+	return fn
+}
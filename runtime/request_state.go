@@ -0,0 +1,127 @@
+package runtime
+
+import (
+	"context"
+	"fmt"
+
+	redisStateStore "github.com/yuyang0/goflow/core/redis-statestore"
+	"github.com/yuyang0/goflow/core/runtime"
+	"github.com/yuyang0/goflow/core/sdk"
+	"github.com/yuyang0/goflow/core/sdk/executor"
+)
+
+// GetState returns the state of a request along with the node it is currently executing, it tolerates staleness
+func (fRuntime *FlowRuntime) GetState(flowName string, requestID string) (*executor.RequestState, error) {
+	return fRuntime.getState(flowName, requestID, fRuntime.staleStateStore())
+}
+
+// getState returns the state of a request read from a StateStore
+func (fRuntime *FlowRuntime) getState(flowName string, requestID string, stateStore sdk.StateStore) (*executor.RequestState, error) {
+	request := &runtime.Request{
+		FlowName:  flowName,
+		RequestID: requestID,
+		Header:    make(map[string][]string),
+	}
+	ex, err := fRuntime.CreateExecutor(request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get state of request %s, error %v", requestID, err)
+	}
+	ex.(*FlowExecutor).StateStore = &slowLogStateStore{StateStore: stateStore, runtime: fRuntime}
+	state, err := executor.CreateFlowExecutor(ex, nil).GetRequestState(requestID)
+	if err != nil {
+		return nil, err
+	}
+	consumed, err := fRuntime.consumedExecutionTime(context.TODO(), flowName, requestID)
+	if err != nil {
+		return nil, err
+	}
+	state.ExecutionSeconds = consumed.Seconds()
+	// best effort, the state is returned without the queue of the request
+	locations, err := fRuntime.getLocations(context.TODO(), flowName, []string{requestID})
+	if err != nil {
+		fRuntime.Logger.Log(fmt.Sprintf("[request `%s`] failed to locate request, error %v", requestID, err))
+	}
+	if location, ok := locations[requestID]; ok {
+		state.Queue = location.Queue
+		state.QueueTransitionTime = location.TransitionedAt.Unix()
+	}
+	state.Annotations, err = fRuntime.GetAnnotations(context.TODO(), flowName, requestID)
+	if err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+// staleStateStore returns the StateStore for the reads tolerating staleness, routed to the read replicas
+// when the store supports them
+func (fRuntime *FlowRuntime) staleStateStore() sdk.StateStore {
+	return staleReadStore(fRuntime.stateStore)
+}
+
+// staleReadStore returns the store with the reads of its redis stores routed to the read replicas, a fallback
+// store keeps falling back with the stale reads
+func staleReadStore(store sdk.StateStore) sdk.StateStore {
+	switch store := store.(type) {
+	case *redisStateStore.RedisStateStore:
+		return store.StaleReadStore()
+	case *FallbackStateStore:
+		return &FallbackStateStore{primary: staleReadStore(store.primary), secondary: staleReadStore(store.secondary),
+			fallback: store.fallback}
+	case interface{ Unwrap() sdk.StateStore }:
+		return staleReadStore(store.Unwrap())
+	}
+	return store
+}
+
+// unwrapStateStore returns the store wrapped by the stores implementing Unwrap, e.g. the primary of a fallback store
+func unwrapStateStore(store sdk.StateStore) sdk.StateStore {
+	for {
+		wrapper, ok := store.(interface{ Unwrap() sdk.StateStore })
+		if !ok {
+			return store
+		}
+		store = wrapper.Unwrap()
+	}
+}
+
+// ListRequests returns the state of every request of a flow that is known to the StateStore
+func (fRuntime *FlowRuntime) ListRequests(ctx context.Context, flowName string) (map[string]*executor.RequestState, error) {
+	// the requests are listed from the primary of a fallback store
+	stateStore, ok := unwrapStateStore(fRuntime.stateStore).(*redisStateStore.RedisStateStore)
+	if !ok {
+		return nil, fmt.Errorf("listing requests is not supported by the StateStore")
+	}
+	requestIDs, err := stateStore.StaleReadStore().ListRequestIds(flowName, executor.RequestStateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	requests := make(map[string]*executor.RequestState)
+	for _, requestID := range requestIDs {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		state, err := fRuntime.GetState(flowName, requestID)
+		if err != nil {
+			return nil, err
+		}
+		requests[requestID] = state
+	}
+	return requests, nil
+}
+
+// GetNodeRequestCounts returns the number of requests of a flow currently executing each node
+func (fRuntime *FlowRuntime) GetNodeRequestCounts(ctx context.Context, flowName string) (map[string]int, error) {
+	requests, err := fRuntime.ListRequests(ctx, flowName)
+	if err != nil {
+		return nil, err
+	}
+
+	counts := make(map[string]int)
+	for _, state := range requests {
+		if state.CurrentNode != "" {
+			counts[state.CurrentNode]++
+		}
+	}
+	return counts, nil
+}
@@ -0,0 +1,176 @@
+package runtime
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	runtimeCommon "github.com/yuyang0/goflow/runtime/common"
+)
+
+const (
+	StreamKeyInitial = "goflow-stream"
+
+	StreamEventNode = "node"
+	StreamEventEnd  = "end"
+
+	ActionStream = "stream"
+
+	// StreamHeartbeatInterval is the interval of the comments keeping an idle stream open through the proxies
+	StreamHeartbeatInterval = 15 * time.Second
+	// MaxStreamDuration bounds a stream whose request never ends, e.g. when it was never submitted
+	MaxStreamDuration = time.Hour
+)
+
+// StreamEvent is published on the stream channel of a request when a node completes and when the request ends
+type StreamEvent struct {
+	Event  string   `json:"event"` // node or end
+	Node   string   `json:"node,omitempty"`
+	Output TaskBody `json:"output,omitempty"`
+	Status string   `json:"status,omitempty"` // completed, failed or canceled, set on the end event
+	Error  string   `json:"error,omitempty"`
+}
+
+func streamChannel(flowName string, requestId string) string {
+	return fmt.Sprintf("%s:%s:%s", StreamKeyInitial, flowName, requestId)
+}
+
+// PublishNodeOutput publishes the output of a completed node to the clients streaming the request
+func (fe *FlowExecutor) PublishNodeOutput(nodeId string, requestId string, output []byte) {
+	if !fe.Runtime.StreamingEnabled {
+		return
+	}
+	fe.Runtime.publishStreamEvent(fe.flowName, requestId, &StreamEvent{Event: StreamEventNode, Node: nodeId, Output: output})
+}
+
+// publishStreamEnd publishes the end of a request to the clients streaming it
+func (fRuntime *FlowRuntime) publishStreamEnd(flowName string, requestId string, err error) {
+	if !fRuntime.StreamingEnabled {
+		return
+	}
+	event := &StreamEvent{Event: StreamEventEnd, Status: HistoryStatusCompleted}
+	if err != nil {
+		event.Status = HistoryStatusFailed
+		event.Error = err.Error()
+	}
+	fRuntime.publishStreamEvent(flowName, requestId, event)
+}
+
+// publishStreamEvent is best effort, the execution goes on when an event fails to be published
+func (fRuntime *FlowRuntime) publishStreamEvent(flowName string, requestId string, event *StreamEvent) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+	if err := fRuntime.redisClient().Publish(context.TODO(), streamChannel(flowName, requestId), data).Err(); err != nil {
+		fRuntime.Logger.Log(fmt.Sprintf("[request `%s`] failed to publish %s stream event, error %v", requestId, event.Event, err))
+	}
+}
+
+// endedStreamEvent returns the end event of a request that already ended, from its location, nil if it has not
+// ended or its location is unknown
+func (fRuntime *FlowRuntime) endedStreamEvent(ctx context.Context, flowName string, requestId string) *StreamEvent {
+	locations, err := fRuntime.getLocations(ctx, flowName, []string{requestId})
+	if err != nil {
+		fRuntime.Logger.Log(fmt.Sprintf("[request `%s`] failed to check if the streamed request ended, %v", requestId, err))
+		return nil
+	}
+	location, ok := locations[requestId]
+	if !ok || !location.Terminal {
+		return nil
+	}
+	switch location.Status {
+	case LocationStatusCompleted:
+		return &StreamEvent{Event: StreamEventEnd, Status: HistoryStatusCompleted}
+	case LocationStatusCanceled:
+		return &StreamEvent{Event: StreamEventEnd, Status: HistoryStatusCanceled}
+	}
+	return &StreamEvent{Event: StreamEventEnd, Status: HistoryStatusFailed, Error: "request " + location.Status}
+}
+
+// requestStreamHandler streams the node outputs of a request as server-sent events until the request ends.
+// The events published before the client subscribed are not replayed, the end event is sent right away when the
+// request already ended
+func requestStreamHandler(runtime *FlowRuntime) func(*gin.Context) {
+	fn := func(c *gin.Context) {
+		flowName, ok := flowNameParam(runtime, c)
+		if !ok {
+			return
+		}
+		requestId := c.Param(RequestIdParamName)
+		if !runtime.StreamingEnabled {
+			c.String(http.StatusNotFound, "streaming is not enabled")
+			return
+		}
+		if !runtime.authorizeRequest(c, nil, ActionStream, flowName, requestId) {
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), MaxStreamDuration)
+		defer cancel()
+		pubsub := runtime.redisClient().Subscribe(ctx, streamChannel(flowName, requestId))
+		defer pubsub.Close()
+		// waits for the subscription so that no event published after the response starts is missed
+		if _, err := pubsub.Receive(ctx); err != nil {
+			runtimeCommon.HandleError(c.Writer, fmt.Sprintf("Failed to subscribe to request stream, %v", err))
+			return
+		}
+
+		// the stream outlives the WriteTimeout of the server, the write deadlines of the connection are wall clock times
+		rc := http.NewResponseController(c.Writer)
+		rc.SetWriteDeadline(time.Now().Add(2 * StreamHeartbeatInterval))
+		headers := c.Writer.Header()
+		headers.Set("Content-Type", "text/event-stream")
+		headers.Set("Cache-Control", "no-cache")
+		headers.Set("Connection", "keep-alive")
+		headers.Set("X-Accel-Buffering", "no")
+		c.Status(http.StatusOK)
+		rc.Flush()
+
+		// the request may have ended before the subscription, its end event is then never published again
+		if event := runtime.endedStreamEvent(ctx, flowName, requestId); event != nil {
+			if data, err := json.Marshal(event); err == nil {
+				fmt.Fprintf(c.Writer, "event: %s\ndata: %s\n\n", event.Event, data)
+				rc.Flush()
+			}
+			return
+		}
+
+		ticker := runtime.clock().NewTicker(StreamHeartbeatInterval)
+		defer ticker.Stop()
+		messages := pubsub.Channel()
+		for {
+			var err error
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C():
+				rc.SetWriteDeadline(time.Now().Add(2 * StreamHeartbeatInterval))
+				_, err = c.Writer.Write([]byte(": heartbeat\n\n"))
+			case message, ok := <-messages:
+				if !ok {
+					return
+				}
+				event := &StreamEvent{}
+				if json.Unmarshal([]byte(message.Payload), event) != nil {
+					continue
+				}
+				rc.SetWriteDeadline(time.Now().Add(2 * StreamHeartbeatInterval))
+				_, err = fmt.Fprintf(c.Writer, "event: %s\ndata: %s\n\n", event.Event, message.Payload)
+				if event.Event == StreamEventEnd && err == nil {
+					rc.Flush()
+					return
+				}
+			}
+			if err != nil {
+				// the client is gone
+				return
+			}
+			rc.Flush()
+		}
+	}
+	return fn
+}
@@ -0,0 +1,189 @@
+package runtime
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/yuyang0/goflow/core/runtime"
+	"github.com/yuyang0/goflow/core/sdk/executor"
+	"github.com/yuyang0/goflow/metrics"
+	"github.com/yuyang0/goflow/runtime/clock"
+)
+
+const (
+	RequestDeadlineKey = "request-deadline"
+
+	TimeoutHeader            = "X-Goflow-Timeout"
+	DeadlineHeader           = "X-Goflow-Deadline"
+	RequestDeadlineHeader    = "Request-Deadline" // accepted as an alias of DeadlineHeader
+	DefaultMaxRequestTimeout = time.Hour
+
+	// FailureCategoryDeadline denotes a request abandoned for exceeding its deadline
+	FailureCategoryDeadline = "DeadlineExceeded"
+
+	ExpiredActionFailed  = "failed"
+	ExpiredActionDropped = "dropped"
+)
+
+var expiredRequestsCounter = metrics.NewCounterVec("goflow_expired_requests_total",
+	"Requests abandoned for exceeding their deadline", "flow", "action")
+
+// SetGlobalTimeout sets the maximum duration of every new request, a zero value disables it.
+// Requests already in progress keep the deadline they were started with
+func (fRuntime *FlowRuntime) SetGlobalTimeout(timeout time.Duration) {
+	fRuntime.globalTimeout.Store(int64(timeout))
+}
+
+// GlobalTimeout returns the maximum duration of new requests, zero if not set
+func (fRuntime *FlowRuntime) GlobalTimeout() time.Duration {
+	return time.Duration(fRuntime.globalTimeout.Load())
+}
+
+// requestTimeout returns the maximum duration of a new request of the flow,
+// the lower of the global timeout and the MaxDuration of the flow when both are set
+func (fRuntime *FlowRuntime) requestTimeout(flowName string) time.Duration {
+	timeout := fRuntime.GlobalTimeout()
+	if options, ok := fRuntime.getFlowOptions(flowName); ok && options.MaxDuration > 0 {
+		if timeout <= 0 || options.MaxDuration < timeout {
+			timeout = options.MaxDuration
+		}
+	}
+	return timeout
+}
+
+// maxRequestTimeout returns the maximum timeout a client can set for a request
+func (fRuntime *FlowRuntime) maxRequestTimeout() time.Duration {
+	if fRuntime.MaxRequestTimeout <= 0 {
+		return DefaultMaxRequestTimeout
+	}
+	return fRuntime.MaxRequestTimeout
+}
+
+// parseRequestTimeout parses a client timeout, either a duration such as `30s` or a number of seconds,
+// timeouts above the maximum are rejected
+func (fRuntime *FlowRuntime) parseRequestTimeout(value string) (time.Duration, error) {
+	timeout, err := time.ParseDuration(value)
+	if err != nil {
+		seconds, serr := strconv.ParseFloat(value, 64)
+		if serr != nil {
+			return 0, fmt.Errorf("invalid timeout %s, error %v", value, err)
+		}
+		timeout = time.Duration(seconds * float64(time.Second))
+	}
+	if timeout <= 0 {
+		return 0, fmt.Errorf("invalid timeout %s, must be positive", value)
+	}
+	if timeout > fRuntime.maxRequestTimeout() {
+		return 0, fmt.Errorf("timeout %v exceeds the maximum of %v", timeout, fRuntime.maxRequestTimeout())
+	}
+	return timeout, nil
+}
+
+// parseRequestDeadline parses a client deadline, either an RFC3339 time or a number of seconds from now,
+// deadlines already passed or further than the maximum timeout are rejected
+func (fRuntime *FlowRuntime) parseRequestDeadline(value string) (time.Time, error) {
+	now := fRuntime.clock().Now()
+	deadline, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		seconds, serr := strconv.ParseFloat(value, 64)
+		if serr != nil {
+			return time.Time{}, fmt.Errorf("invalid deadline %s, must be an RFC3339 time or a number of seconds", value)
+		}
+		deadline = now.Add(time.Duration(seconds * float64(time.Second)))
+	}
+	if !deadline.After(now) {
+		return time.Time{}, fmt.Errorf("deadline %s has already passed", value)
+	}
+	if deadline.Sub(now) > fRuntime.maxRequestTimeout() {
+		return time.Time{}, fmt.Errorf("deadline %s exceeds the maximum timeout of %v", value, fRuntime.maxRequestTimeout())
+	}
+	return deadline, nil
+}
+
+// abandonExpiredRequest records a request abandoned for exceeding its deadline, it is reported as
+// failed with the DeadlineExceeded category unless DropExpiredRequests is set
+func (fRuntime *FlowRuntime) abandonExpiredRequest(request *runtime.Request, reason string) {
+	if fRuntime.DropExpiredRequests {
+		expiredRequestsCounter.Inc(request.FlowName, ExpiredActionDropped)
+		return
+	}
+	expiredRequestsCounter.Inc(request.FlowName, ExpiredActionFailed)
+	fRuntime.Logger.Log(fmt.Sprintf("[request `%s`] failed (category %s), %s", request.RequestID, FailureCategoryDeadline, reason))
+	fRuntime.recordHistory(request.FlowName, request.RequestID, HistoryStatusFailed, fmt.Errorf("%s", reason))
+	fRuntime.finishLocation(request.FlowName, request.RequestID, LocationStatusFailed)
+	err := fRuntime.updateArchivedTask(request.RequestID, func(task *Task) {
+		task.FailureCategory = FailureCategoryDeadline
+	})
+	if err != nil {
+		fRuntime.Logger.Log(fmt.Sprintf("[request `%s`] failed to update archived task, error %v", request.RequestID, err))
+	}
+}
+
+// applyRequestDeadline stores the deadline of a new request, the earliest of the deadline set by the
+// client and the timeout of the flow, and returns it. The request id must be set
+func (fRuntime *FlowRuntime) applyRequestDeadline(request *runtime.Request) (time.Time, error) {
+	deadline := request.Deadline
+	if timeout := fRuntime.requestTimeout(request.FlowName); timeout > 0 {
+		if flowDeadline := fRuntime.clock().Now().Add(timeout); deadline.IsZero() || flowDeadline.Before(deadline) {
+			deadline = flowDeadline
+		}
+	}
+	if deadline.IsZero() {
+		return deadline, nil
+	}
+	return deadline, fRuntime.setRequestDeadline(request, deadline)
+}
+
+// setRequestDeadline stores the deadline of a new request in the StateStore, the continuations of a paused request
+// get it back once it is resumed
+func (fRuntime *FlowRuntime) setRequestDeadline(request *runtime.Request, deadline time.Time) error {
+	stateStore, err := fRuntime.stateStore.CopyStore()
+	if err != nil {
+		return err
+	}
+	stateStore.Configure(request.FlowName, request.RequestID)
+	return stateStore.Set(RequestDeadlineKey, strconv.FormatInt(deadline.UnixNano(), 10))
+}
+
+// storedDeadline returns the deadline a request was started with, zero if it has none
+func (fRuntime *FlowRuntime) storedDeadline(request *runtime.Request) (time.Time, error) {
+	stateStore, err := fRuntime.stateStore.CopyStore()
+	if err != nil {
+		return time.Time{}, err
+	}
+	stateStore.Configure(request.FlowName, request.RequestID)
+
+	value, err := stateStore.Get(RequestDeadlineKey)
+	if err != nil || value == "" {
+		// the request has no deadline, or it can't be read
+		return time.Time{}, nil
+	}
+	deadline, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid deadline %s, error %v", value, err)
+	}
+	return time.Unix(0, deadline), nil
+}
+
+// withDeadline bounds the nodes executed by the executor with a context canceled at the deadline, the returned
+// function releases the context once the execution returned
+func (fRuntime *FlowRuntime) withDeadline(ex executor.Executor, deadline time.Time) context.CancelFunc {
+	fe, ok := ex.(*FlowExecutor)
+	if !ok || deadline.IsZero() {
+		return func() {}
+	}
+	ctx, cancel := clock.WithDeadline(context.Background(), fRuntime.clock(), deadline)
+	fe.ctx = ctx
+	return cancel
+}
+
+// RequestContext returns the context the nodes of the request are executed with, it is done at the deadline
+// of the request
+func (fe *FlowExecutor) RequestContext(_ string) context.Context {
+	if fe.ctx == nil {
+		return context.Background()
+	}
+	return fe.ctx
+}
@@ -0,0 +1,200 @@
+package runtime_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/alphadose/haxmap"
+	"github.com/yuyang0/goflow/core/sdk"
+	flow "github.com/yuyang0/goflow/flow/v1"
+	"github.com/yuyang0/goflow/runtime"
+	"github.com/yuyang0/goflow/runtime/clock/clocktest"
+	"github.com/yuyang0/goflow/types"
+	goflow "github.com/yuyang0/goflow/v1"
+)
+
+// TestNodeContextDoneAtDeadline checks that a node gets a context done at the deadline of the request, and that
+// the nodes after the deadline are not executed
+func TestNodeContextDoneAtDeadline(t *testing.T) {
+	var mu sync.Mutex
+	var waitErr error
+	waited, executed := false, false
+	handler := func(wf *flow.Workflow, _ *flow.Context) error {
+		dag := wf.Dag()
+		dag.NodeWithContext("wait", func(nodeContext *sdk.NodeContext, data []byte, _ map[string][]string) ([]byte, error) {
+			select {
+			case <-nodeContext.Context().Done():
+			case <-time.After(10 * time.Second):
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			waited, waitErr = true, nodeContext.Context().Err()
+			return data, nil
+		})
+		dag.Node("next", func(data []byte, _ map[string][]string) ([]byte, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			executed = true
+			return data, nil
+		})
+		dag.Edge("wait", "next")
+		return nil
+	}
+
+	fs := &goflow.FlowService{}
+	options := map[string]runtime.FlowOptions{"bounded": {MaxDuration: 500 * time.Millisecond}}
+	_, client := startWorker(t, fs, map[string]runtime.FlowDefinitionHandler{"bounded": handler}, options)
+	if err := client.Execute("bounded", &goflow.Request{Body: []byte("{}")}); err != nil {
+		t.Fatal(err)
+	}
+
+	eventually(t, 5*time.Second, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return waited
+	}, "the node was not released at the deadline")
+	time.Sleep(time.Second)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if waitErr != context.DeadlineExceeded {
+		t.Fatalf("expected the context of the node to exceed its deadline, got %v", waitErr)
+	}
+	if executed {
+		t.Fatal("expected the node after the deadline not to be executed")
+	}
+}
+
+// submitWithHeaders queues a request of the flow through the server of the runtime, and returns the status of the
+// response and the deadline of the queued task, zero if none was queued
+func submitWithHeaders(t *testing.T, mr *miniredis.Miniredis, fRuntime *runtime.FlowRuntime, flowName string,
+	headers map[string]string) (int, time.Time) {
+	t.Helper()
+	// the router logs to gin.log in the working directory
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	req := httptest.NewRequest(http.MethodPost, "/flow/"+flowName, strings.NewReader("{}"))
+	req.Header.Set(runtime.AsyncRequestHeader, "true")
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	recorder := httptest.NewRecorder()
+	runtime.Router(fRuntime).ServeHTTP(recorder, req)
+
+	requestID := recorder.Header().Get(runtime.RequestIdHeaderName)
+	for _, key := range mr.Keys() {
+		if !strings.HasSuffix(key, "::ready") {
+			continue
+		}
+		items, _ := mr.List(key)
+		for _, item := range items {
+			task := runtime.Task{}
+			if err := json.Unmarshal([]byte(item), &task); err == nil && task.RequestID == requestID {
+				if task.Deadline == 0 {
+					return recorder.Code, time.Time{}
+				}
+				return recorder.Code, time.Unix(0, task.Deadline)
+			}
+		}
+	}
+	return recorder.Code, time.Time{}
+}
+
+// TestTimeoutHeaderBoundsRequest checks that X-Goflow-Timeout sets the deadline of a request relatively to its
+// submission, that the earliest of the timeout and of X-Goflow-Deadline applies, and that a timeout above the
+// maximum of the server is rejected
+func TestTimeoutHeaderBoundsRequest(t *testing.T) {
+	mr := miniredis.RunT(t)
+	fc := clocktest.NewFakeClock(time.Now())
+	fRuntime := &runtime.FlowRuntime{
+		Flows:             haxmap.New[string, runtime.FlowDefinitionHandler](),
+		RedisCfg:          types.RedisConfig{Addr: mr.Addr()},
+		MaxRequestTimeout: time.Minute,
+		Clock:             fc,
+	}
+	if err := fRuntime.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if err := fRuntime.Register(map[string]runtime.FlowDefinitionHandler{"timed": echoFlow}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		headers  map[string]string
+		status   int
+		deadline time.Duration
+	}{
+		{map[string]string{runtime.TimeoutHeader: "30s"}, http.StatusOK, 30 * time.Second},
+		{map[string]string{runtime.TimeoutHeader: "45"}, http.StatusOK, 45 * time.Second},
+		{map[string]string{runtime.TimeoutHeader: "30s", runtime.DeadlineHeader: "10"}, http.StatusOK, 10 * time.Second},
+		{map[string]string{runtime.TimeoutHeader: "10s", runtime.DeadlineHeader: "30"}, http.StatusOK, 10 * time.Second},
+		{map[string]string{runtime.TimeoutHeader: "2m"}, http.StatusBadRequest, 0},
+		{map[string]string{runtime.TimeoutHeader: "soon"}, http.StatusBadRequest, 0},
+	} {
+		status, deadline := submitWithHeaders(t, mr, fRuntime, "timed", test.headers)
+		if status != test.status {
+			t.Fatalf("expected the request with %v to get a %d, got %d", test.headers, test.status, status)
+		}
+		if test.deadline > 0 && !deadline.Equal(fc.Now().Add(test.deadline)) {
+			t.Fatalf("expected the request with %v to be queued with a deadline in %v, got %v", test.headers,
+				test.deadline, deadline.Sub(fc.Now()))
+		}
+	}
+}
+
+// TestRequestDeadlineHeaderIsAnAlias checks that Request-Deadline is parsed as X-Goflow-Deadline, the earliest of
+// the two applying, and that it is rejected past the maximum of the server as well
+func TestRequestDeadlineHeaderIsAnAlias(t *testing.T) {
+	mr := miniredis.RunT(t)
+	fc := clocktest.NewFakeClock(time.Now().Truncate(time.Second))
+	fRuntime := &runtime.FlowRuntime{
+		Flows:             haxmap.New[string, runtime.FlowDefinitionHandler](),
+		RedisCfg:          types.RedisConfig{Addr: mr.Addr()},
+		MaxRequestTimeout: time.Minute,
+		Clock:             fc,
+	}
+	if err := fRuntime.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if err := fRuntime.Register(map[string]runtime.FlowDefinitionHandler{"aliased": echoFlow}); err != nil {
+		t.Fatal(err)
+	}
+
+	alias, at := runtime.RequestDeadlineHeader, fc.Now().Add(40*time.Second).Format(time.RFC3339)
+	for _, test := range []struct {
+		headers  map[string]string
+		status   int
+		deadline time.Duration
+	}{
+		{map[string]string{alias: "20"}, http.StatusOK, 20 * time.Second},
+		{map[string]string{alias: at}, http.StatusOK, 40 * time.Second},
+		{map[string]string{alias: "20", runtime.DeadlineHeader: "30"}, http.StatusOK, 20 * time.Second},
+		{map[string]string{alias: "30", runtime.TimeoutHeader: "10s"}, http.StatusOK, 10 * time.Second},
+		{map[string]string{alias: "120"}, http.StatusBadRequest, 0},
+		{map[string]string{alias: "tomorrow"}, http.StatusBadRequest, 0},
+	} {
+		status, deadline := submitWithHeaders(t, mr, fRuntime, "aliased", test.headers)
+		if status != test.status {
+			t.Fatalf("expected the request with %v to get a %d, got %d", test.headers, test.status, status)
+		}
+		if test.deadline > 0 && !deadline.Equal(fc.Now().Add(test.deadline)) {
+			t.Fatalf("expected the request with %v to be queued with a deadline in %v, got %v", test.headers,
+				test.deadline, deadline.Sub(fc.Now()))
+		}
+	}
+}
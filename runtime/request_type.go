@@ -0,0 +1,101 @@
+package runtime
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/adjust/rmq/v5"
+	"github.com/yuyang0/goflow/core/runtime"
+	"github.com/yuyang0/goflow/metrics"
+)
+
+// RequestType selects how a worker handles a task
+type RequestType string
+
+const (
+	PartialRequest RequestType = "PARTIAL"
+	NewRequest     RequestType = "NEW"
+	PauseRequest   RequestType = "PAUSE"
+	ResumeRequest  RequestType = "RESUME"
+	StopRequest    RequestType = "STOP"
+	FailureRequest RequestType = "FAILURE"
+)
+
+var invalidTasksCounter = metrics.NewCounterVec("goflow_invalid_tasks_total",
+	"Tasks dead lettered as their request type is unknown to the worker", "flow")
+
+// requestHandlers handles the tasks of each request type. A request type is only valid with a handler, so that
+// a type can't be published without the workers being able to handle it
+var requestHandlers map[RequestType]func(fRuntime *FlowRuntime, request *runtime.Request) error
+
+func init() {
+	// set in init as the handlers publish tasks, which checks the request types
+	requestHandlers = map[RequestType]func(fRuntime *FlowRuntime, request *runtime.Request) error{
+		PartialRequest: func(fRuntime *FlowRuntime, request *runtime.Request) error {
+			return fRuntime.handleExecutionRequest(request, fRuntime.handlePartialRequest)
+		},
+		NewRequest: func(fRuntime *FlowRuntime, request *runtime.Request) error {
+			return fRuntime.handleExecutionRequest(request, fRuntime.handleNewRequest)
+		},
+		PauseRequest:   (*FlowRuntime).handlePauseRequest,
+		ResumeRequest:  (*FlowRuntime).handleResumeRequest,
+		StopRequest:    (*FlowRuntime).handleStopRequest,
+		FailureRequest: (*FlowRuntime).handleFailureRequest,
+	}
+}
+
+// IsValid checks that the workers can handle the request type
+func (requestType RequestType) IsValid() bool {
+	_, ok := requestHandlers[requestType]
+	return ok
+}
+
+// MarshalText encodes the request type as its name
+func (requestType RequestType) MarshalText() ([]byte, error) {
+	return []byte(requestType), nil
+}
+
+// UnmarshalText decodes the request type from its name. An unknown name is kept rather than rejected so that
+// the task can be dead lettered, e.g. when it was published by a newer version of the runtime
+func (requestType *RequestType) UnmarshalText(text []byte) error {
+	*requestType = RequestType(text)
+	return nil
+}
+
+// marshalTask encodes a task to be published, the tasks with an invalid request type are refused
+func marshalTask(task *Task) ([]byte, error) {
+	if !task.RequestType.IsValid() {
+		return nil, fmt.Errorf("invalid request type %q of request %s", task.RequestType, task.RequestID)
+	}
+	data, err := json.Marshal(task)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal task, error %v", err)
+	}
+	return data, nil
+}
+
+func (fRuntime *FlowRuntime) handleRequest(request *runtime.Request, requestType RequestType) error {
+	handle, ok := requestHandlers[requestType]
+	if !ok {
+		return fmt.Errorf("invalid request %v received with type %s", request, requestType)
+	}
+	return handle(fRuntime, request)
+}
+
+// handleInvalidRequestType dead letters a task which request type is unknown to the worker, retrying it
+// would only fail again
+func (fRuntime *FlowRuntime) handleInvalidRequestType(message rmq.Delivery, task *Task) {
+	reason := fmt.Sprintf("unknown request type %q", task.RequestType)
+	if err := fRuntime.deadLetter(task, reason); err != nil {
+		fRuntime.Logger.Log(fmt.Sprintf("[request `%s`] failed to dead letter task, error %v", task.RequestID, err))
+		if err := message.Reject(); err != nil {
+			fRuntime.handleQueueError(QueueOperationAck, task, err)
+		}
+		return
+	}
+	invalidTasksCounter.Inc(task.FlowName)
+	fRuntime.Logger.Log(fmt.Sprintf("[request `%s`] dead lettered, %s", task.RequestID, reason))
+	if err := message.Ack(); err != nil {
+		fRuntime.handleQueueError(QueueOperationAck, task, err)
+	}
+}
@@ -0,0 +1,66 @@
+package runtime_test
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"strconv"
+	"testing"
+
+	"github.com/yuyang0/goflow/runtime"
+)
+
+// TestRequestTypesHaveHandlers checks that every RequestType declared in the package can be handled by the
+// workers, so that a request type can't be added without its handler
+func TestRequestTypesHaveHandlers(t *testing.T) {
+	files, err := parser.ParseDir(token.NewFileSet(), ".", nil, 0)
+	if err != nil {
+		t.Fatalf("failed to parse package, %v", err)
+	}
+	pkg, ok := files["runtime"]
+	if !ok {
+		t.Fatal("runtime package not found")
+	}
+
+	declared := 0
+	for _, file := range pkg.Files {
+		for _, decl := range file.Decls {
+			genDecl, ok := decl.(*ast.GenDecl)
+			if !ok || genDecl.Tok != token.CONST {
+				continue
+			}
+			for _, spec := range genDecl.Specs {
+				valueSpec := spec.(*ast.ValueSpec)
+				if ident, ok := valueSpec.Type.(*ast.Ident); !ok || ident.Name != "RequestType" {
+					continue
+				}
+				for idx, name := range valueSpec.Names {
+					literal, ok := valueSpec.Values[idx].(*ast.BasicLit)
+					if !ok {
+						t.Fatalf("request type %s is not a string literal", name.Name)
+					}
+					value, err := strconv.Unquote(literal.Value)
+					if err != nil {
+						t.Fatalf("invalid value of request type %s, %v", name.Name, err)
+					}
+					if !runtime.RequestType(value).IsValid() {
+						t.Errorf("request type %s has no handler", name.Name)
+					}
+					declared++
+				}
+			}
+		}
+	}
+	if declared == 0 {
+		t.Fatal("no request type found")
+	}
+}
+
+func TestUnknownRequestTypeIsInvalid(t *testing.T) {
+	if runtime.RequestType("UNKNOWN").IsValid() {
+		t.Fatal("unknown request type is valid")
+	}
+	if runtime.RequestType("").IsValid() {
+		t.Fatal("empty request type is valid")
+	}
+}
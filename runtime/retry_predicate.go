@@ -0,0 +1,62 @@
+package runtime
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/adjust/rmq/v5"
+	"github.com/yuyang0/goflow/metrics"
+)
+
+var unretriedFailuresCounter = metrics.NewCounterVec("goflow_unretried_failures_total",
+	"Failed tasks dead lettered without retry as the ShouldRetry of their flow declined it", "flow")
+
+// attempt returns the attempt of the task, 1 until it is first pushed to the queues of the retries
+func (task *Task) attempt() int {
+	return task.Attempt + 1
+}
+
+// pushRetry pushes a failed task to the next queue of its retries with its attempt counted, whatever the queue
+// it was consumed from, along with the transition of its request. The task is rejected when the consumer has no
+// push queue
+func (consumer *queueConsumer) pushRetry(message rmq.Delivery, task *Task) error {
+	fRuntime := consumer.runtime
+	writes := fRuntime.redisClient().Pipeline()
+	if consumer.pushQueue == "" {
+		if err := message.Push(); err != nil {
+			return err
+		}
+		consumer.pushLocation(task, writes)
+		fRuntime.flushWrites(writes)
+		return nil
+	}
+
+	retried := *task
+	retried.Attempt++
+	if retried.BodyRef != "" {
+		// the body loaded for the execution stays in the DataStore
+		retried.Body = nil
+	}
+	data, err := marshalTask(&retried)
+	if err != nil {
+		return err
+	}
+	readyKey := readyQueueKey(fRuntime.pushQueueId(task.FlowName, consumer.pushIdx))
+	push := writes.LPush(context.TODO(), readyKey, data)
+	consumer.pushLocation(task, writes)
+	fRuntime.flushWrites(writes)
+	if err := push.Err(); err != nil {
+		return fmt.Errorf("failed to push task to %s, error %v", consumer.pushQueue, err)
+	}
+	return message.Ack()
+}
+
+// shouldRetry checks with the ShouldRetry of the flow if a failed task is pushed to the next queue of its retries,
+// the tasks of the flows without ShouldRetry always are
+func (fRuntime *FlowRuntime) shouldRetry(flowName string, err error, attempt int) bool {
+	options, _ := fRuntime.getFlowOptions(flowName)
+	if options.ShouldRetry == nil {
+		return true
+	}
+	return options.ShouldRetry(err, attempt)
+}
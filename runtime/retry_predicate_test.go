@@ -0,0 +1,61 @@
+package runtime_test
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	flow "github.com/yuyang0/goflow/flow/v1"
+	"github.com/yuyang0/goflow/runtime"
+	goflow "github.com/yuyang0/goflow/v1"
+)
+
+var errUpstream = errors.New("upstream unavailable")
+
+func TestShouldRetryGetsWrappedErrorAndAttempt(t *testing.T) {
+	var mu sync.Mutex
+	var attempts []int
+	var matched []bool
+	options := runtime.FlowOptions{
+		ShouldRetry: func(err error, attempt int) bool {
+			mu.Lock()
+			defer mu.Unlock()
+			attempts = append(attempts, attempt)
+			matched = append(matched, errors.Is(err, errUpstream))
+			return attempt < 2
+		},
+	}
+	failing := func(wf *flow.Workflow, _ *flow.Context) error {
+		wf.Dag().Node("call", func(data []byte, _ map[string][]string) ([]byte, error) {
+			return nil, fmt.Errorf("call failed, %w", errUpstream)
+		})
+		return nil
+	}
+
+	fs := &goflow.FlowService{RetryCount: 3}
+	_, client := startWorker(t, fs, map[string]runtime.FlowDefinitionHandler{"retried": failing},
+		map[string]runtime.FlowOptions{"retried": options})
+	if err := client.Execute("retried", &goflow.Request{Body: []byte("{}")}); err != nil {
+		t.Fatal(err)
+	}
+
+	eventually(t, 15*time.Second, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(attempts) >= 2
+	}, "the failed task was not retried")
+	time.Sleep(2 * time.Second)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(attempts) != 2 || attempts[0] != 1 || attempts[1] != 2 {
+		t.Fatalf("expected attempts [1 2], got %v", attempts)
+	}
+	for idx, ok := range matched {
+		if !ok {
+			t.Fatalf("attempt %d: the error does not wrap the node error", attempts[idx])
+		}
+	}
+}
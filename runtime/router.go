@@ -13,6 +13,7 @@ import (
 const (
 	FlowNameParamName  = "flowName"
 	RequestIdParamName = "requestId"
+	SeriesIdParamName  = "seriesId"
 )
 
 func Router(fRuntime *FlowRuntime) http.Handler {
@@ -22,6 +23,9 @@ func Router(fRuntime *FlowRuntime) http.Handler {
 	gin.DefaultWriter = io.MultiWriter(f)
 
 	router := gin.Default()
+	if fRuntime.JWT != nil {
+		router.Use(jwtAuthMiddleware(fRuntime))
+	}
 	// TODO: below two routes are kept to be backward compatible, and will be removed later
 	router.POST(":"+FlowNameParamName, executeRequestHandler(fRuntime, controller.ExecuteFlowHandler))
 	router.GET(":"+FlowNameParamName, executeRequestHandler(fRuntime, controller.ExecuteFlowHandler))
@@ -33,6 +37,49 @@ func Router(fRuntime *FlowRuntime) http.Handler {
 	router.POST("flow/:"+FlowNameParamName+"/request/resume:"+RequestIdParamName, resumeRequestHandler(fRuntime))
 	router.POST("flow/:"+FlowNameParamName+"/request/state:"+RequestIdParamName, requestStateHandler(fRuntime))
 	router.POST("flow/:"+FlowNameParamName+"/request/list", requestListHandler(fRuntime))
+	router.DELETE("flow/:"+FlowNameParamName+"/request/:"+RequestIdParamName, cancelRequestHandler(fRuntime))
+	router.GET("flow/:"+FlowNameParamName+"/request/:"+RequestIdParamName+"/position", queuePositionHandler(fRuntime))
+	router.GET("flow/:"+FlowNameParamName+"/request/:"+RequestIdParamName+"/logs", requestLogsHandler(fRuntime))
+	router.GET("flow/:"+FlowNameParamName+"/request/:"+RequestIdParamName+"/stream", requestStreamHandler(fRuntime))
+	router.GET("flow/:"+FlowNameParamName+"/locate/:"+RequestIdParamName, locateRequestHandler(fRuntime))
+	router.GET("flow/:"+FlowNameParamName+"/request/:"+RequestIdParamName+"/routing", routingLogHandler(fRuntime))
+	router.POST("flow/:"+FlowNameParamName+"/requests/:"+RequestIdParamName+"/annotations", annotateRequestHandler(fRuntime))
+	router.GET("flow/:"+FlowNameParamName+"/requests/:"+RequestIdParamName+"/annotations", annotationsHandler(fRuntime))
+	router.GET("flow/:"+FlowNameParamName+"/request/nodes", nodeRequestCountHandler(fRuntime))
+	router.GET("flow/:"+FlowNameParamName+"/queues", queueDepthHandler(fRuntime))
+	router.GET("flow/:"+FlowNameParamName+"/usage", flowUsageHandler(fRuntime))
+	router.GET("flow/:"+FlowNameParamName+"/samples", sampledRequestsHandler(fRuntime))
+	router.GET("flow/:"+FlowNameParamName+"/stats", flowStatsHandler(fRuntime))
+	router.GET("v1/flow/:"+FlowNameParamName, flowDetailsHandler(fRuntime))
+	router.GET("v1/flow/:"+FlowNameParamName+"/flush-estimate", flushEstimateHandler(fRuntime))
+	router.GET("v1/flow/:"+FlowNameParamName+"/history", executionHistoryHandler(fRuntime))
+	router.GET("v1/flow/:"+FlowNameParamName+"/stats", flowStatsHandler(fRuntime))
+	router.GET("v1/flow/:"+FlowNameParamName+"/diagnose", diagnoseFlowHandler(fRuntime))
+	router.POST("flow/:"+FlowNameParamName+"/sample", flowSampleHandler(fRuntime))
+	router.GET("v1/flows", flowListHandler(fRuntime))
+	router.GET("v1/info", infoHandler(fRuntime))
+	router.GET("version", versionHandler(fRuntime))
+	router.GET("v1/workers", workerListHandler(fRuntime))
+	router.GET("admin/slowlog", slowLogHandler(fRuntime))
+	router.GET("readyz", readyzHandler(fRuntime))
+	router.GET("v1/deadletters", deadLetterListHandler(fRuntime))
+	router.GET("v1/audit", auditLogHandler(fRuntime))
+	router.DELETE("v1/scheduled/:"+RequestIdParamName, cancelScheduledHandler(fRuntime))
+	router.GET("v1/series", seriesListHandler(fRuntime))
+	router.DELETE("v1/series/:"+SeriesIdParamName, cancelSeriesHandler(fRuntime))
+	router.GET("openapi.json", openAPIHandler(fRuntime))
+	router.GET("metrics", prometheusMetricsHandler(fRuntime))
+	router.GET("v1/metrics/json", jsonMetricsHandler(fRuntime))
+	// diagnostic routes configuration
+	router.GET("v1/diff", requestDiffHandler(fRuntime))
+	if fRuntime.UnsafeFaultInjector != nil {
+		router.GET("v1/faults", faultConfigHandler(fRuntime))
+		router.POST("v1/faults", faultConfigHandler(fRuntime))
+	}
+	// admin ui configuration
+	if fRuntime.AdminUIEnabled {
+		router.GET("ui/*filepath", gin.WrapH(adminUIHandler()))
+	}
 
 	return router
 }
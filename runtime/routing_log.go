@@ -0,0 +1,123 @@
+package runtime
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	runtimeCommon "github.com/yuyang0/goflow/runtime/common"
+)
+
+const (
+	RoutingLogKeyInitial = "goflow-routing-log"
+
+	// RoutingLogTTL is how long the routing log of a request is kept after its last entry. The failed tasks are
+	// pushed to the next retry queue right away, so it only has to outlive the time a task waits in the queues
+	RoutingLogTTL = 24 * time.Hour
+	// RoutingLogMaxEntries bounds the routing log of a request requeued over and over, the oldest are dropped
+	RoutingLogMaxEntries = 1000
+
+	RoutingActionPublished = "published"
+	RoutingActionConsumed  = "consumed"
+	RoutingActionAcked     = "acked"
+	RoutingActionRejected  = "rejected"
+	RoutingActionDLQ       = "dlq"
+)
+
+// RoutingLogEntry is a step of a task along the queues, the queue is a location of RequestLocation
+type RoutingLogEntry struct {
+	Timestamp time.Time `json:"timestamp"`
+	QueueName string    `json:"queue_name"`
+	Action    string    `json:"action"`
+}
+
+func routingLogKey(flowName string, requestID string) string {
+	return fmt.Sprintf("%s:%s:%s", RoutingLogKeyInitial, flowName, requestID)
+}
+
+// pushedToRetryAction is the action of a task pushed to the push queue of a location, counted from 1 as the retries
+func pushedToRetryAction(pushQueue string) string {
+	var idx int
+	fmt.Sscanf(pushQueue, "push-%d", &idx)
+	return fmt.Sprintf("pushed-to-retry-%d", idx+1)
+}
+
+// recordRouting appends an entry to the routing log of a request when RoutingLogsEnabled is set
+func (fRuntime *FlowRuntime) recordRouting(flowName string, requestID string, queue string, action string) error {
+	if !fRuntime.RoutingLogsEnabled || requestID == "" {
+		return nil
+	}
+	ctx := context.TODO()
+	pipe := fRuntime.redisClient().TxPipeline()
+	fRuntime.addRouting(ctx, pipe, flowName, requestID, queue, action)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to record routing of request %s, error %v", requestID, err)
+	}
+	return nil
+}
+
+// addRouting queues an entry of the routing log of a request on pipe when RoutingLogsEnabled is set
+func (fRuntime *FlowRuntime) addRouting(ctx context.Context, pipe redis.Pipeliner, flowName string, requestID string,
+	queue string, action string) {
+	if !fRuntime.RoutingLogsEnabled || requestID == "" {
+		return
+	}
+	data, err := json.Marshal(&RoutingLogEntry{Timestamp: fRuntime.clock().Now(), QueueName: queue, Action: action})
+	if err != nil {
+		return
+	}
+	key := routingLogKey(flowName, requestID)
+	pipe.RPush(ctx, key, data)
+	pipe.LTrim(ctx, key, -RoutingLogMaxEntries, -1)
+	pipe.Expire(ctx, key, RoutingLogTTL)
+}
+
+// logRouting appends an entry to the routing log of a request on the worker, an entry failing to be recorded is logged
+func (fRuntime *FlowRuntime) logRouting(flowName string, requestID string, queue string, action string) {
+	if err := fRuntime.recordRouting(flowName, requestID, queue, action); err != nil {
+		fRuntime.Logger.Log(fmt.Sprintf("[request `%s`] %v", requestID, err))
+	}
+}
+
+// GetRoutingLog returns the routing log of a request of a flow, oldest first
+func (fRuntime *FlowRuntime) GetRoutingLog(ctx context.Context, flowName string,
+	requestID string) ([]*RoutingLogEntry, error) {
+	values, err := fRuntime.redisClient().LRange(ctx, routingLogKey(flowName, requestID), 0, -1).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get routing log of request %s, error %v", requestID, err)
+	}
+	entries := make([]*RoutingLogEntry, 0, len(values))
+	for _, value := range values {
+		entry := &RoutingLogEntry{}
+		if err := json.Unmarshal([]byte(value), entry); err != nil {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+func routingLogHandler(runtime *FlowRuntime) func(*gin.Context) {
+	fn := func(c *gin.Context) {
+		flowName, ok := flowNameParam(runtime, c)
+		if !ok {
+			return
+		}
+		requestId := c.Param(RequestIdParamName)
+
+		entries, err := runtime.GetRoutingLog(c.Request.Context(), flowName, requestId)
+		if err != nil {
+			runtimeCommon.HandleError(c.Writer, fmt.Sprintf("Failed to get routing log, %v", err))
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"request_id": requestId,
+			"entries":    entries,
+		})
+	}
+	return fn
+}
@@ -0,0 +1,28 @@
+package runtime_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/yuyang0/goflow/runtime"
+	goflow "github.com/yuyang0/goflow/v1"
+)
+
+// TestRoutingLogKeyedByFlow checks that the routing log of a request is recorded under its flow, so that the requests
+// of two flows with the same id don't share a log
+func TestRoutingLogKeyedByFlow(t *testing.T) {
+	fs := &goflow.FlowService{RoutingLogsEnabled: true}
+	flows := map[string]runtime.FlowDefinitionHandler{"routed": echoFlow, "other": echoFlow}
+	mr, client := startWorker(t, fs, flows, nil)
+	if err := client.Execute("routed", &goflow.Request{Body: []byte("{}"), RequestId: "shared"}); err != nil {
+		t.Fatal(err)
+	}
+
+	eventually(t, 5*time.Second, func() bool {
+		entries, _ := mr.List(runtime.RoutingLogKeyInitial + ":routed:shared")
+		return len(entries) >= 2
+	}, "the routing log of the request was not recorded under its flow")
+	if mr.Exists(runtime.RoutingLogKeyInitial + ":other:shared") {
+		t.Fatal("expected the routing log of the request not to be recorded under another flow")
+	}
+}
@@ -0,0 +1,320 @@
+package runtime
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	runtimeCommon "github.com/yuyang0/goflow/runtime/common"
+)
+
+const (
+	SeriesKey = "goflow-series" // hash of the request series by id
+
+	// MaxSeriesOccurrences is the limit of the requests of a series, they are all scheduled upfront
+	MaxSeriesOccurrences = 1000
+)
+
+// SeriesSpec describes the requests of a series, either every Interval or following the Cron expression,
+// until Count requests are scheduled or EndTime is reached
+type SeriesSpec struct {
+	ID       string        // id of the series, generated if not set. A series with the same id is scheduled only once
+	Interval time.Duration // interval between two requests
+	Cron     string        // standard 5 fields cron expression, evaluated in the location of Start
+	Start    time.Time     // time of the first request, now if not set
+	Count    int           // number of requests of the series
+	EndTime  time.Time     // no request is scheduled after it
+}
+
+// Series is a series of requests scheduled with ExecuteSeries
+type Series struct {
+	ID          string        `json:"id"`
+	FlowName    string        `json:"flow_name"`
+	Interval    time.Duration `json:"interval,omitempty"`
+	Cron        string        `json:"cron,omitempty"`
+	Occurrences int           `json:"occurrences"`
+	First       time.Time     `json:"first"`
+	Last        time.Time     `json:"last"`
+	CreatedAt   time.Time     `json:"created_at"`
+
+	Pending int       `json:"pending"` // requests not submitted yet
+	Next    time.Time `json:"next"`    // due time of the next pending request
+}
+
+// scheduleSeriesScript records a series and schedules all its requests, nothing is scheduled if a series with
+// the same id exists
+var scheduleSeriesScript = redis.NewScript(`
+if redis.call("HSETNX", KEYS[1], ARGV[1], ARGV[2]) == 0 then
+	return 0
+end
+for i = 3, #ARGV, 3 do
+	redis.call("HSET", KEYS[3], ARGV[i], ARGV[i + 2])
+	redis.call("ZADD", KEYS[2], ARGV[i + 1], ARGV[i])
+end
+return 1
+`)
+
+// cancelSeriesScript removes a series and its pending requests, it returns the number of requests removed or -1
+// if the series was already cancelled
+var cancelSeriesScript = redis.NewScript(`
+if redis.call("HDEL", KEYS[1], ARGV[1]) == 0 then
+	return -1
+end
+local removed = 0
+for i = 2, #ARGV do
+	removed = removed + redis.call("ZREM", KEYS[2], ARGV[i])
+	redis.call("HDEL", KEYS[3], ARGV[i])
+end
+return removed
+`)
+
+func seriesRequestID(seriesID string, n int) string {
+	return fmt.Sprintf("%s-%d", seriesID, n)
+}
+
+// occurrences returns the due times of the requests of the series
+func (spec *SeriesSpec) occurrences(now time.Time) ([]time.Time, error) {
+	if (spec.Interval > 0) == (spec.Cron != "") {
+		return nil, fmt.Errorf("invalid series, either an interval or a cron expression must be provided")
+	}
+	if spec.Interval < 0 || spec.Count < 0 {
+		return nil, fmt.Errorf("invalid series, interval and count must not be negative")
+	}
+	if spec.Count == 0 && spec.EndTime.IsZero() {
+		return nil, fmt.Errorf("invalid series, a count or an end time must be provided")
+	}
+	if spec.Count > MaxSeriesOccurrences {
+		return nil, fmt.Errorf("invalid series, at most %d requests can be scheduled", MaxSeriesOccurrences)
+	}
+
+	var schedule *cronSchedule
+	if spec.Cron != "" {
+		var err error
+		if schedule, err = parseCron(spec.Cron); err != nil {
+			return nil, err
+		}
+	}
+	next := spec.Start
+	if next.IsZero() {
+		next = now
+	}
+
+	var times []time.Time
+	for spec.Count == 0 || len(times) < spec.Count {
+		if schedule != nil {
+			at, err := schedule.next(next)
+			if err != nil {
+				if len(times) > 0 {
+					break
+				}
+				return nil, fmt.Errorf("invalid series, %v", err)
+			}
+			next = at
+		}
+		if !spec.EndTime.IsZero() && next.After(spec.EndTime) {
+			break
+		}
+		if len(times) == MaxSeriesOccurrences {
+			return nil, fmt.Errorf("invalid series, more than %d requests before the end time", MaxSeriesOccurrences)
+		}
+		times = append(times, next)
+		if schedule != nil {
+			next = next.Add(time.Minute)
+		} else {
+			next = next.Add(spec.Interval)
+		}
+	}
+	if len(times) == 0 {
+		return nil, fmt.Errorf("invalid series, no request is due before the end time")
+	}
+	return times, nil
+}
+
+// ExecuteSeries schedules a series of requests of a flow with the same body. The requests are all scheduled
+// atomically with the ids <seriesID>-<n> starting at 1, and are submitted by the scheduler like the requests of
+// ExecuteAt. Executing a series again with the same id is a no-op
+func (fRuntime *FlowRuntime) ExecuteSeries(flowName string, body []byte, spec SeriesSpec) (string, error) {
+	flowName, err := fRuntime.resolveFlowName(flowName)
+	if err != nil {
+		return "", err
+	}
+	if err := fRuntime.validateRequestBody(flowName, body); err != nil {
+		return "", err
+	}
+	times, err := spec.occurrences(fRuntime.clock().Now())
+	if err != nil {
+		return "", err
+	}
+	seriesID := spec.ID
+	if seriesID == "" {
+		seriesID = getNewId()
+	}
+
+	series := &Series{
+		ID:          seriesID,
+		FlowName:    flowName,
+		Interval:    spec.Interval,
+		Cron:        spec.Cron,
+		Occurrences: len(times),
+		First:       times[0],
+		Last:        times[len(times)-1],
+		CreatedAt:   fRuntime.clock().Now(),
+	}
+	data, err := json.Marshal(series)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal series, error %v", err)
+	}
+	args := make([]interface{}, 0, 2+3*len(times))
+	args = append(args, seriesID, data)
+	for idx, at := range times {
+		task := &Task{
+			FlowName:    flowName,
+			RequestID:   seriesRequestID(seriesID, idx+1),
+			Body:        body,
+			RequestType: NewRequest,
+		}
+		taskData, err := marshalTask(task)
+		if err != nil {
+			return "", err
+		}
+		args = append(args, task.RequestID, at.UnixMilli(), taskData)
+	}
+
+	// the requests of a series already scheduled are left as is
+	err = scheduleSeriesScript.Run(context.TODO(), fRuntime.redisClient(),
+		[]string{SeriesKey, ScheduledTasksKey, ScheduledTaskDataKey}, args...).Err()
+	if err != nil {
+		return "", fmt.Errorf("failed to schedule series %s, error %v", seriesID, err)
+	}
+	return seriesID, nil
+}
+
+// ListSeries returns the series of requests with their pending requests, the series are kept until cancelled
+func (fRuntime *FlowRuntime) ListSeries(ctx context.Context) ([]*Series, error) {
+	rdb := fRuntime.redisClient()
+	values, err := rdb.HGetAll(ctx, SeriesKey).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list series, error %v", err)
+	}
+
+	seriesList := make([]*Series, 0, len(values))
+	for _, value := range values {
+		series := &Series{}
+		if err := json.Unmarshal([]byte(value), series); err != nil {
+			continue
+		}
+		seriesList = append(seriesList, series)
+	}
+	sort.Slice(seriesList, func(i, j int) bool {
+		return seriesList[i].CreatedAt.Before(seriesList[j].CreatedAt)
+	})
+
+	pipe := rdb.Pipeline()
+	scores := make([][]*redis.FloatCmd, len(seriesList))
+	for idx, series := range seriesList {
+		for n := 1; n <= series.Occurrences; n++ {
+			scores[idx] = append(scores[idx], pipe.ZScore(ctx, ScheduledTasksKey, seriesRequestID(series.ID, n)))
+		}
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, fmt.Errorf("failed to get pending requests of series, error %v", err)
+	}
+	for idx, series := range seriesList {
+		for _, score := range scores[idx] {
+			dueAt, err := score.Result()
+			if err != nil {
+				continue
+			}
+			at := time.UnixMilli(int64(dueAt))
+			if series.Pending == 0 || at.Before(series.Next) {
+				series.Next = at
+			}
+			series.Pending++
+		}
+	}
+	return seriesList, nil
+}
+
+// CancelSeries removes a series and cancels its requests not submitted yet
+func (fRuntime *FlowRuntime) CancelSeries(seriesID string) error {
+	ctx := context.TODO()
+	rdb := fRuntime.redisClient()
+	series, err := fRuntime.getSeries(ctx, seriesID)
+	if err != nil {
+		return fmt.Errorf("failed to cancel series %s, error %v", seriesID, err)
+	}
+	if series == nil {
+		return fmt.Errorf("series %s is not scheduled", seriesID)
+	}
+
+	args := make([]interface{}, 0, 1+series.Occurrences)
+	args = append(args, seriesID)
+	for n := 1; n <= series.Occurrences; n++ {
+		args = append(args, seriesRequestID(seriesID, n))
+	}
+	removed, err := cancelSeriesScript.Run(ctx, rdb,
+		[]string{SeriesKey, ScheduledTasksKey, ScheduledTaskDataKey}, args...).Int()
+	if err != nil {
+		return fmt.Errorf("failed to cancel series %s, error %v", seriesID, err)
+	}
+	if removed < 0 {
+		return fmt.Errorf("series %s is not scheduled", seriesID)
+	}
+	return nil
+}
+
+// getSeries returns a series of requests, nil if it is not scheduled
+func (fRuntime *FlowRuntime) getSeries(ctx context.Context, seriesID string) (*Series, error) {
+	value, err := fRuntime.redisClient().HGet(ctx, SeriesKey, seriesID).Result()
+	if err == redis.Nil {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	series := &Series{}
+	if err := json.Unmarshal([]byte(value), series); err != nil {
+		return nil, fmt.Errorf("failed to parse series %s, error %v", seriesID, err)
+	}
+	return series, nil
+}
+
+func seriesListHandler(runtime *FlowRuntime) func(*gin.Context) {
+	fn := func(c *gin.Context) {
+		seriesList, err := runtime.ListSeries(c.Request.Context())
+		if err != nil {
+			runtimeCommon.HandleError(c.Writer, fmt.Sprintf("Failed to list series, %v", err))
+			return
+		}
+		c.JSON(http.StatusOK, seriesList)
+	}
+	return fn
+}
+
+func cancelSeriesHandler(runtime *FlowRuntime) func(*gin.Context) {
+	fn := func(c *gin.Context) {
+		seriesId := c.Param(SeriesIdParamName)
+		series, err := runtime.getSeries(c.Request.Context(), seriesId)
+		if err != nil {
+			runtimeCommon.HandleError(c.Writer, fmt.Sprintf("Failed to cancel series, %v", err))
+			return
+		}
+		if series == nil {
+			c.String(http.StatusNotFound, "series %s is not scheduled", seriesId)
+			return
+		}
+		if !runtime.authorizeRequest(c, nil, ActionCancel, series.FlowName, "") {
+			return
+		}
+		if err := runtime.CancelSeries(seriesId); err != nil {
+			runtimeCommon.HandleError(c.Writer, fmt.Sprintf("Failed to cancel series, %v", err))
+			return
+		}
+		c.String(http.StatusOK, "Series cancelled")
+	}
+	return fn
+}
@@ -0,0 +1,280 @@
+package runtime
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yuyang0/goflow/core/sdk"
+	"github.com/yuyang0/goflow/metrics"
+	runtimeCommon "github.com/yuyang0/goflow/runtime/common"
+)
+
+const (
+	SlowLogKey = "goflow-slowlog"
+
+	// SlowLogMaxEntries is the number of entries kept in the redis slow log
+	SlowLogMaxEntries = 1000
+
+	SlowLogKindNode       = "node"
+	SlowLogKindStateStore = "statestore"
+	SlowLogKindDataStore  = "datastore"
+)
+
+var slowOperationsCounter = metrics.NewCounterVec("goflow_slow_operations_total",
+	"Node executions and store operations that exceeded the slow log thresholds", "flow", "kind")
+
+// SlowLogEntry is a node execution or a store operation that exceeded its threshold
+type SlowLogEntry struct {
+	Time       time.Time `json:"time"`
+	Flow       string    `json:"flow"`
+	Kind       string    `json:"kind"`
+	Operation  string    `json:"operation"` // the node id or the store operation
+	RequestID  string    `json:"request_id"`
+	DurationMs int64     `json:"duration_ms"`
+}
+
+// SetSlowNodeThreshold sets the duration above which a node execution is reported as slow, a zero value disables it
+func (fRuntime *FlowRuntime) SetSlowNodeThreshold(threshold time.Duration) {
+	fRuntime.slowNodeThreshold.Store(int64(threshold))
+}
+
+// SlowNodeThreshold returns the duration above which a node execution is reported as slow, zero if not set
+func (fRuntime *FlowRuntime) SlowNodeThreshold() time.Duration {
+	return time.Duration(fRuntime.slowNodeThreshold.Load())
+}
+
+// SetSlowStoreThreshold sets the duration above which a StateStore or DataStore operation is reported as slow,
+// a zero value disables it
+func (fRuntime *FlowRuntime) SetSlowStoreThreshold(threshold time.Duration) {
+	fRuntime.slowStoreThreshold.Store(int64(threshold))
+}
+
+// SlowStoreThreshold returns the duration above which a store operation is reported as slow, zero if not set
+func (fRuntime *FlowRuntime) SlowStoreThreshold() time.Duration {
+	return time.Duration(fRuntime.slowStoreThreshold.Load())
+}
+
+// reportSlow logs and counts an operation that took longer than the threshold,
+// and appends it to the redis slow log when SlowLogEnabled is set
+func (fRuntime *FlowRuntime) reportSlow(threshold time.Duration, entry SlowLogEntry, duration time.Duration) {
+	if threshold <= 0 || duration <= threshold {
+		return
+	}
+	entry.Time = fRuntime.clock().Now()
+	entry.DurationMs = duration.Milliseconds()
+
+	fRuntime.Logger.Log(fmt.Sprintf("[goflow] slow %s flow=%s operation=%s request=%s duration=%s",
+		entry.Kind, entry.Flow, entry.Operation, entry.RequestID, duration))
+	slowOperationsCounter.Inc(entry.Flow, entry.Kind)
+
+	if !fRuntime.SlowLogEnabled {
+		return
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	ctx := context.TODO()
+	pipe := fRuntime.redisClient().TxPipeline()
+	pipe.LPush(ctx, SlowLogKey, data)
+	pipe.LTrim(ctx, SlowLogKey, 0, SlowLogMaxEntries-1)
+	if _, err := pipe.Exec(ctx); err != nil {
+		fRuntime.Logger.Log(fmt.Sprintf("[goflow] failed to append to the slow log, error %v", err))
+	}
+}
+
+// GetSlowLog returns the latest entries of the redis slow log, newest first
+func (fRuntime *FlowRuntime) GetSlowLog(ctx context.Context, count int) ([]*SlowLogEntry, error) {
+	if count <= 0 || count > SlowLogMaxEntries {
+		count = SlowLogMaxEntries
+	}
+	values, err := fRuntime.redisClient().LRange(ctx, SlowLogKey, 0, int64(count-1)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the slow log, error %v", err)
+	}
+	entries := make([]*SlowLogEntry, 0, len(values))
+	for _, value := range values {
+		entry := &SlowLogEntry{}
+		if err := json.Unmarshal([]byte(value), entry); err != nil {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// ReportNodeDuration reports the node executions that exceed the SlowNodeThreshold
+func (fe *FlowExecutor) ReportNodeDuration(nodeId string, requestId string, duration time.Duration) {
+	if IsWarmupRequest(requestId) {
+		return
+	}
+	fe.Runtime.reportSlow(fe.Runtime.SlowNodeThreshold(), SlowLogEntry{
+		Flow:      fe.flowName,
+		Kind:      SlowLogKindNode,
+		Operation: nodeId,
+		RequestID: requestId,
+	}, duration)
+}
+
+// slowLogStateStore reports the StateStore operations that exceed the SlowStoreThreshold
+type slowLogStateStore struct {
+	sdk.StateStore
+	runtime   *FlowRuntime
+	flowName  string
+	requestID string
+}
+
+func (store *slowLogStateStore) observe(operation string, start time.Time) {
+	store.runtime.reportSlow(store.runtime.SlowStoreThreshold(), SlowLogEntry{
+		Flow:      store.flowName,
+		Kind:      SlowLogKindStateStore,
+		Operation: operation,
+		RequestID: store.requestID,
+	}, store.runtime.clock().Now().Sub(start))
+}
+
+func (store *slowLogStateStore) Configure(flowName string, requestId string) {
+	store.flowName = flowName
+	store.requestID = requestId
+	store.StateStore.Configure(flowName, requestId)
+}
+
+func (store *slowLogStateStore) Set(key string, value string) error {
+	defer store.observe("set "+key, store.runtime.clock().Now())
+	return store.StateStore.Set(key, value)
+}
+
+func (store *slowLogStateStore) Get(key string) (string, error) {
+	defer store.observe("get "+key, store.runtime.clock().Now())
+	return store.StateStore.Get(key)
+}
+
+func (store *slowLogStateStore) Incr(key string, value int64) (int64, error) {
+	defer store.observe("incr "+key, store.runtime.clock().Now())
+	return store.StateStore.Incr(key, value)
+}
+
+func (store *slowLogStateStore) Update(key string, oldValue string, newValue string) error {
+	defer store.observe("update "+key, store.runtime.clock().Now())
+	return store.StateStore.Update(key, oldValue, newValue)
+}
+
+func (store *slowLogStateStore) Cleanup() error {
+	defer store.observe("cleanup", store.runtime.clock().Now())
+	return store.StateStore.Cleanup()
+}
+
+func (store *slowLogStateStore) Ping() error {
+	return pingStore(store.StateStore)
+}
+
+// Unwrap returns the store the operations are observed on
+func (store *slowLogStateStore) Unwrap() sdk.StateStore {
+	return store.StateStore
+}
+
+func (store *slowLogStateStore) CopyStore() (sdk.StateStore, error) {
+	copied, err := store.StateStore.CopyStore()
+	if err != nil {
+		return nil, err
+	}
+	return &slowLogStateStore{StateStore: copied, runtime: store.runtime, flowName: store.flowName, requestID: store.requestID}, nil
+}
+
+// slowLogDataStore reports the DataStore operations that exceed the SlowStoreThreshold
+type slowLogDataStore struct {
+	sdk.DataStore
+	runtime   *FlowRuntime
+	flowName  string
+	requestID string
+}
+
+func (store *slowLogDataStore) observe(operation string, start time.Time) {
+	store.runtime.reportSlow(store.runtime.SlowStoreThreshold(), SlowLogEntry{
+		Flow:      store.flowName,
+		Kind:      SlowLogKindDataStore,
+		Operation: operation,
+		RequestID: store.requestID,
+	}, store.runtime.clock().Now().Sub(start))
+}
+
+func (store *slowLogDataStore) Configure(flowName string, requestId string) {
+	store.flowName = flowName
+	store.requestID = requestId
+	store.DataStore.Configure(flowName, requestId)
+}
+
+func (store *slowLogDataStore) Set(key string, value []byte) error {
+	defer store.observe("set "+key, store.runtime.clock().Now())
+	return store.DataStore.Set(key, value)
+}
+
+func (store *slowLogDataStore) Get(key string) ([]byte, error) {
+	defer store.observe("get "+key, store.runtime.clock().Now())
+	return store.DataStore.Get(key)
+}
+
+func (store *slowLogDataStore) SetReader(key string, reader io.Reader) error {
+	defer store.observe("set "+key, store.runtime.clock().Now())
+	return sdk.SetReader(store.DataStore, key, reader)
+}
+
+func (store *slowLogDataStore) GetReader(key string) (io.ReadCloser, error) {
+	defer store.observe("get "+key, store.runtime.clock().Now())
+	return sdk.GetReader(store.DataStore, key)
+}
+
+func (store *slowLogDataStore) SetIfAbsent(key string, value []byte) (bool, error) {
+	defer store.observe("setnx "+key, store.runtime.clock().Now())
+	return sdk.SetIfAbsent(store.DataStore, key, value)
+}
+
+func (store *slowLogDataStore) Del(key string) error {
+	defer store.observe("del "+key, store.runtime.clock().Now())
+	return store.DataStore.Del(key)
+}
+
+func (store *slowLogDataStore) Cleanup() error {
+	defer store.observe("cleanup", store.runtime.clock().Now())
+	return store.DataStore.Cleanup()
+}
+
+func (store *slowLogDataStore) Ping() error {
+	return pingStore(store.DataStore)
+}
+
+// Unwrap returns the store the operations are observed on
+func (store *slowLogDataStore) Unwrap() sdk.DataStore {
+	return store.DataStore
+}
+
+func (store *slowLogDataStore) CopyStore() (sdk.DataStore, error) {
+	copied, err := store.DataStore.CopyStore()
+	if err != nil {
+		return nil, err
+	}
+	return &slowLogDataStore{DataStore: copied, runtime: store.runtime, flowName: store.flowName, requestID: store.requestID}, nil
+}
+
+func slowLogHandler(runtime *FlowRuntime) func(*gin.Context) {
+	fn := func(c *gin.Context) {
+		count, _ := strconv.Atoi(c.Query("count"))
+		entries, err := runtime.GetSlowLog(c.Request.Context(), count)
+		if err != nil {
+			runtimeCommon.HandleError(c.Writer, fmt.Sprintf("Failed to get slow log, %v", err))
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"node_threshold_ms":  runtime.SlowNodeThreshold().Milliseconds(),
+			"store_threshold_ms": runtime.SlowStoreThreshold().Milliseconds(),
+			"entries":            entries,
+		})
+	}
+	return fn
+}
@@ -0,0 +1,51 @@
+package runtime_test
+
+import (
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/alphadose/haxmap"
+	runtimepkg "github.com/yuyang0/goflow/core/runtime"
+	"github.com/yuyang0/goflow/core/sdk"
+	"github.com/yuyang0/goflow/runtime"
+	"github.com/yuyang0/goflow/types"
+)
+
+// TestExecutorStoresForwardOptionalInterfaces checks that the stores handed to the executors keep the optional
+// interfaces of the stores they wrap
+func TestExecutorStoresForwardOptionalInterfaces(t *testing.T) {
+	mr := miniredis.RunT(t)
+	fRuntime := &runtime.FlowRuntime{
+		Flows:    haxmap.New[string, runtime.FlowDefinitionHandler](),
+		RedisCfg: types.RedisConfig{Addr: mr.Addr()},
+	}
+	if err := fRuntime.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if err := fRuntime.Register(map[string]runtime.FlowDefinitionHandler{"wrapped": echoFlow}); err != nil {
+		t.Fatal(err)
+	}
+	ex, err := fRuntime.CreateExecutor(&runtimepkg.Request{FlowName: "wrapped", RequestID: "request"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	flowExecutor := ex.(*runtime.FlowExecutor)
+	if _, ok := flowExecutor.StateStore.(sdk.Pinger); !ok {
+		t.Fatal("expected the StateStore of the executor to be a Pinger")
+	}
+	if _, ok := flowExecutor.DataStore.(sdk.Pinger); !ok {
+		t.Fatal("expected the DataStore of the executor to be a Pinger")
+	}
+	dataStore, ok := flowExecutor.DataStore.(sdk.ConditionalDataStore)
+	if !ok {
+		t.Fatal("expected the DataStore of the executor to be a ConditionalDataStore")
+	}
+	flowExecutor.DataStore.Configure("wrapped", "request")
+
+	if set, err := dataStore.SetIfAbsent("claim", []byte("first")); err != nil || !set {
+		t.Fatalf("expected the absent key to be set, got %v %v", set, err)
+	}
+	if set, err := dataStore.SetIfAbsent("claim", []byte("second")); err != nil || set {
+		t.Fatalf("expected the key set to be kept, got %v %v", set, err)
+	}
+}
@@ -0,0 +1,195 @@
+package runtime
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/adjust/rmq/v5"
+	"github.com/redis/go-redis/v9"
+	"github.com/yuyang0/goflow/core/sdk"
+)
+
+// the subsystems the runtime connects to on Init
+const (
+	SubsystemRDB        = "rdb"
+	SubsystemStateStore = "state store"
+	SubsystemDataStore  = "data store"
+	SubsystemTransport  = "transport"
+)
+
+const (
+	DefaultInitTimeout = 10 * time.Second
+
+	// InitRetryBackoff is the wait before the first retry of a failed Init, it doubles up to MaxInitRetryBackoff
+	InitRetryBackoff    = time.Second
+	MaxInitRetryBackoff = 30 * time.Second
+)
+
+// ConnectionError is returned by Init when a subsystem of the runtime fails to connect,
+// the errors of several subsystems are joined
+type ConnectionError struct {
+	Subsystem string
+	Err       error
+}
+
+func (err *ConnectionError) Error() string {
+	return fmt.Sprintf("%s failed to connect, error %v", err.Subsystem, err.Err)
+}
+
+func (err *ConnectionError) Unwrap() error {
+	return err.Err
+}
+
+// connections are the clients opened by Init, they are closed when Init fails so that it can be retried
+type connections struct {
+	rdb             *redis.Client
+	stateStore      sdk.StateStore
+	dataStore       sdk.DataStore // nil when the DataStore of the runtime is provided
+	transport       rmq.Connection
+	transportClient *redis.Client
+}
+
+func (conns *connections) close() {
+	if conns.transport != nil {
+		<-conns.transport.StopAllConsuming()
+	}
+	if conns.transportClient != nil {
+		conns.transportClient.Close()
+	}
+	for _, store := range []interface{}{conns.dataStore, conns.stateStore} {
+		if closer, ok := store.(io.Closer); ok {
+			closer.Close()
+		}
+	}
+	conns.rdb.Close()
+}
+
+func (fRuntime *FlowRuntime) initTimeout() time.Duration {
+	if fRuntime.InitTimeout <= 0 {
+		return DefaultInitTimeout
+	}
+	return fRuntime.InitTimeout
+}
+
+// connect opens and checks the connections of the runtime, retrying with backoff for InitRetryDuration
+func (fRuntime *FlowRuntime) connect() (*connections, error) {
+	deadline := fRuntime.clock().Now().Add(fRuntime.InitRetryDuration)
+	backoff := InitRetryBackoff
+	for {
+		conns, err := fRuntime.openConnections(fRuntime.initTimeout())
+		if err == nil {
+			return conns, nil
+		}
+		remaining := deadline.Sub(fRuntime.clock().Now())
+		if remaining <= 0 {
+			return nil, err
+		}
+		wait := min(backoff, remaining)
+		fRuntime.Logger.Log(fmt.Sprintf("[goflow] failed to initialize the runtime, retrying in %s, %v", wait, err))
+		fRuntime.clock().Sleep(wait)
+		backoff = min(2*backoff, MaxInitRetryBackoff)
+	}
+}
+
+// openConnections opens the connections of the runtime and checks them within the timeout, nothing is left open
+// when it fails
+func (fRuntime *FlowRuntime) openConnections(timeout time.Duration) (*connections, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	type result struct {
+		conns *connections
+		errs  []error
+	}
+	done := make(chan result, 1)
+	go func() {
+		conns, errs := fRuntime.dial(ctx)
+		done <- result{conns: conns, errs: errs}
+	}()
+
+	select {
+	case res := <-done:
+		if len(res.errs) > 0 {
+			res.conns.close()
+			return nil, errors.Join(res.errs...)
+		}
+		return res.conns, nil
+	case <-ctx.Done():
+		// the stores and the transport don't take a context, their calls are left to complete in the background
+		go func() {
+			res := <-done
+			res.conns.close()
+		}()
+		return nil, fmt.Errorf("connections not established within %s", timeout)
+	}
+}
+
+// dial opens the clients of the runtime, then checks them once they are all open
+func (fRuntime *FlowRuntime) dial(ctx context.Context) (*connections, []error) {
+	var err error
+	var errs []error
+	conns := &connections{rdb: fRuntime.RedisCfg.NewRedisClient()}
+
+	conns.stateStore, err = initStateStore(&fRuntime.RedisCfg, fRuntime.StrongConsistency,
+		fRuntime.StateStoreRetryCount, fRuntime.StateStoreRetryBackoff, fRuntime.StoreKeyCountsEnabled)
+	if err != nil {
+		errs = append(errs, &ConnectionError{Subsystem: SubsystemStateStore, Err: err})
+	}
+
+	dataStore := fRuntime.DataStore
+	if dataStore == nil {
+		conns.dataStore, err = initDataStore(&fRuntime.RedisCfg, fRuntime.DataStoreBucketTemplate, fRuntime.Tenant,
+			fRuntime.StoreKeyCountsEnabled)
+		if err != nil {
+			errs = append(errs, &ConnectionError{Subsystem: SubsystemDataStore, Err: err})
+		}
+		dataStore = conns.dataStore
+	}
+
+	// the heartbeat errors of the transport are buffered so that its heartbeat stops once its client is closed
+	conns.transportClient = fRuntime.RedisCfg.NewRedisClient()
+	conns.transport, err = rmq.OpenConnectionWithRedisClient(fRuntime.connectionTag(), conns.transportClient,
+		make(chan error, rmq.HeartbeatErrorLimit))
+	if err != nil {
+		errs = append(errs, &ConnectionError{Subsystem: SubsystemTransport, Err: err})
+	}
+
+	if len(errs) > 0 {
+		return conns, errs
+	}
+	fRuntime.withFallbackStores(conns)
+	return conns, checkConnections(ctx, conns.rdb, conns.stateStore, dataStore, conns.transport, fRuntime.connectionTag())
+}
+
+// checkConnections pings redis, the stores and the transport, and opens a queue. The stores that don't implement
+// sdk.Pinger are not checked
+func checkConnections(ctx context.Context, rdb *redis.Client, stateStore sdk.StateStore, dataStore sdk.DataStore,
+	transport rmq.Connection, tag string) []error {
+	var errs []error
+	if err := rdb.Ping(ctx).Err(); err != nil {
+		errs = append(errs, &ConnectionError{Subsystem: SubsystemRDB, Err: err})
+	}
+	if pinger, ok := stateStore.(sdk.Pinger); ok {
+		if err := pinger.Ping(); err != nil {
+			errs = append(errs, &ConnectionError{Subsystem: SubsystemStateStore, Err: err})
+		}
+	}
+	if pinger, ok := dataStore.(sdk.Pinger); ok {
+		if err := pinger.Ping(); err != nil {
+			errs = append(errs, &ConnectionError{Subsystem: SubsystemDataStore, Err: err})
+		}
+	}
+
+	// a queue is opened and destroyed right away, so that it is not listed along with the queues of the flows
+	queue, err := transport.OpenQueue(fmt.Sprintf("%s-init-check", tag))
+	if err == nil {
+		_, _, err = queue.Destroy()
+	}
+	if err != nil {
+		errs = append(errs, &ConnectionError{Subsystem: SubsystemTransport, Err: err})
+	}
+	return errs
+}
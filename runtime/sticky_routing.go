@@ -0,0 +1,197 @@
+package runtime
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/yuyang0/goflow/core/runtime"
+)
+
+// stickyRingReplicas is the number of slots of each worker on the hash ring
+const stickyRingReplicas = 64
+
+// hashRing maps keys to workers with consistent hashing, so that only the keys of a
+// worker joining or leaving the ring are moved
+type hashRing struct {
+	slots  []uint32
+	owners map[uint32]string
+}
+
+func hashKey(key string) uint32 {
+	hash := fnv.New32a()
+	hash.Write([]byte(key))
+	return hash.Sum32()
+}
+
+func newHashRing(workerIDs []string) *hashRing {
+	ring := &hashRing{owners: make(map[uint32]string)}
+	for _, workerID := range workerIDs {
+		for idx := 0; idx < stickyRingReplicas; idx++ {
+			slot := hashKey(workerID + "#" + strconv.Itoa(idx))
+			ring.slots = append(ring.slots, slot)
+			ring.owners[slot] = workerID
+		}
+	}
+	sort.Slice(ring.slots, func(i, j int) bool { return ring.slots[i] < ring.slots[j] })
+	return ring
+}
+
+// get returns the worker owning the first slot after the hash of the key
+func (ring *hashRing) get(key string) (string, bool) {
+	if ring == nil || len(ring.slots) == 0 {
+		return "", false
+	}
+	hash := hashKey(key)
+	idx := sort.Search(len(ring.slots), func(i int) bool { return ring.slots[i] >= hash })
+	if idx == len(ring.slots) {
+		idx = 0
+	}
+	return ring.owners[ring.slots[idx]], true
+}
+
+// stickyRings returns the hash rings of the workers of each flow, the rings are rebuilt
+// from the registered workers once per registration interval
+func (fRuntime *FlowRuntime) stickyRings() (map[string]*hashRing, error) {
+	fRuntime.stickyRingMu.Lock()
+	defer fRuntime.stickyRingMu.Unlock()
+
+	ringAge := fRuntime.clock().Now().Sub(fRuntime.stickyRingUpdated)
+	if fRuntime.stickyRing != nil && ringAge < GoFlowRegisterInterval*time.Second {
+		return fRuntime.stickyRing, nil
+	}
+
+	workers, err := fRuntime.ListWorkers(context.TODO())
+	if err != nil {
+		return nil, err
+	}
+	flowWorkers := make(map[string]map[string]bool)
+	for _, worker := range workers {
+		for _, flowName := range worker.Flows {
+			if flowWorkers[flowName] == nil {
+				flowWorkers[flowName] = make(map[string]bool)
+			}
+			flowWorkers[flowName][worker.ID] = true
+		}
+	}
+	rings := make(map[string]*hashRing, len(flowWorkers))
+	for flowName, workerIDs := range flowWorkers {
+		ids := make([]string, 0, len(workerIDs))
+		for workerID := range workerIDs {
+			ids = append(ids, workerID)
+		}
+		rings[flowName] = newHashRing(ids)
+	}
+
+	fRuntime.stickyRing = rings
+	fRuntime.stickyRingUpdated = fRuntime.clock().Now()
+	return rings, nil
+}
+
+// stickyQueueId returns the queue of the worker a request is routed to by the StickyRoutingKey of the flow,
+// false if the flow has no sticky routing or the worker is unavailable, the request then goes to the flow
+// queue which is consumed by all the workers
+func (fRuntime *FlowRuntime) stickyQueueId(flowName string, request *runtime.Request) (string, bool) {
+	options, ok := fRuntime.getFlowOptions(flowName)
+	if !ok || options.StickyRoutingKey == nil {
+		return "", false
+	}
+	key := options.StickyRoutingKey(request)
+	if key == "" {
+		return "", false
+	}
+
+	rings, err := fRuntime.stickyRings()
+	if err != nil {
+		fRuntime.Logger.Log(fmt.Sprintf("[goflow] failed to get workers for sticky routing, error %v", err))
+		return "", false
+	}
+	workerID, ok := rings[flowName].get(key)
+	if !ok {
+		return "", false
+	}
+
+	// the worker may have gone down since the ring was built
+	alive, err := fRuntime.redisClient().Exists(context.TODO(), fmt.Sprintf("%s:%s", WorkerKeyInitial, workerID)).Result()
+	if err != nil || alive == 0 {
+		return "", false
+	}
+	return workerQueueId(workerID), true
+}
+
+// requeueStrandedTasks moves the tasks of the queues of the workers that went down to the queues of their flow,
+// so that the requests routed to a dead worker are picked up by the other workers. The queue of a dead worker
+// is removed once empty, the cleaner having returned its unacked tasks first
+func (fRuntime *FlowRuntime) requeueStrandedTasks(ctx context.Context) error {
+	queueIds, err := fRuntime.rmqConnection.GetOpenQueues()
+	if err != nil {
+		return fmt.Errorf("failed to get queues, error %v", err)
+	}
+	for _, queueId := range queueIds {
+		workerID, ok := strings.CutPrefix(queueId, WorkerQueueInitial+":")
+		if !ok {
+			continue
+		}
+		alive, err := fRuntime.redisClient().Exists(ctx, fmt.Sprintf("%s:%s", WorkerKeyInitial, workerID)).Result()
+		if err != nil {
+			return fmt.Errorf("failed to get worker %s, error %v", workerID, err)
+		}
+		if alive > 0 {
+			continue
+		}
+		if err := fRuntime.requeueWorkerQueue(queueId, workerID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// requeueWorkerQueue moves the tasks of the queue of a dead worker one by one to the queues of their flow
+func (fRuntime *FlowRuntime) requeueWorkerQueue(queueId string, workerID string) error {
+	queue, err := fRuntime.rmqConnection.OpenQueue(queueId)
+	if err != nil {
+		return fmt.Errorf("failed to open queue of worker %s, error %v", workerID, err)
+	}
+	requeued := 0
+	for {
+		payloads, err := queue.Drain(1)
+		// the queue is empty
+		if err == redis.Nil {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to drain queue of worker %s, error %v", workerID, err)
+		}
+		task := &Task{}
+		if err := json.Unmarshal([]byte(payloads[0]), task); err != nil {
+			fRuntime.handleQueueError(QueueOperationParse, nil, err)
+			continue
+		}
+		if err := fRuntime.requeueTask(task); err != nil {
+			// put back for the next run of the cleaner
+			queue.Publish(payloads[0])
+			return fmt.Errorf("failed to requeue task of worker %s, error %v", workerID, err)
+		}
+		requeued++
+	}
+	if requeued > 0 {
+		fRuntime.Logger.Log(fmt.Sprintf("[goflow] requeued %d tasks of dead worker %s", requeued, workerID))
+	}
+
+	stats, err := fRuntime.rmqConnection.CollectStats([]string{queueId})
+	if err != nil {
+		return fmt.Errorf("failed to get queue of worker %s, error %v", workerID, err)
+	}
+	if stat := stats.QueueStats[queueId]; stat.ReadyCount == 0 && stat.ConnectionCount() == 0 {
+		if _, _, err := queue.Destroy(); err != nil {
+			return fmt.Errorf("failed to remove queue of worker %s, error %v", workerID, err)
+		}
+	}
+	return nil
+}
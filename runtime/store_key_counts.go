@@ -0,0 +1,106 @@
+package runtime
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	redisDataStore "github.com/yuyang0/goflow/core/redis-datastore"
+	redisStateStore "github.com/yuyang0/goflow/core/redis-statestore"
+	"github.com/yuyang0/goflow/metrics"
+)
+
+const (
+	// StoreKeyCountsKey is the hash of the key counts of the stores of each flow, written by the leader
+	StoreKeyCountsKey = "goflow-store-key-counts"
+
+	LeaderRoleStoreKeyCounter = "store-key-counter"
+
+	DefaultStoreKeyCountInterval = time.Minute
+
+	StoreTypeState = "state"
+	StoreTypeData  = "data"
+)
+
+var storeKeysGauge = metrics.NewGaugeVec("goflow_store_keys",
+	"Keys held in the StateStore and the DataStore by the requests of the flow", "flow", "store")
+
+func (fRuntime *FlowRuntime) storeKeyCountInterval() time.Duration {
+	if fRuntime.StoreKeyCountInterval < time.Second {
+		return DefaultStoreKeyCountInterval
+	}
+	return fRuntime.StoreKeyCountInterval
+}
+
+// countStoreKeys counts the keys tracked by the stores for each flow, see StoreKeyCountsEnabled. Only the leader
+// of the store key counter role counts, the counts are shared with the other workers through StoreKeyCountsKey
+func (fRuntime *FlowRuntime) countStoreKeys() {
+	ctx := context.TODO()
+	interval := fRuntime.storeKeyCountInterval()
+	leader, err := fRuntime.acquireLeadership(ctx, LeaderRoleStoreKeyCounter, 3*interval)
+	if err != nil {
+		fRuntime.Logger.Log(fmt.Sprintf("[goflow] failed to count store keys, error %v", err))
+		return
+	}
+	if !leader {
+		return
+	}
+
+	rdb := fRuntime.redisClient()
+	flows := fRuntime.ListFlows()
+	stateCounts := make([]*redis.IntCmd, len(flows))
+	dataCounts := make([]*redis.IntCmd, len(flows))
+	_, err = rdb.Pipelined(ctx, func(pl redis.Pipeliner) error {
+		for idx, flowName := range flows {
+			stateCounts[idx] = pl.SCard(ctx, redisStateStore.TrackedKeysSet(flowName))
+			dataCounts[idx] = pl.SCard(ctx, redisDataStore.TrackedKeysSet(flowName))
+		}
+		return nil
+	})
+	if err != nil {
+		fRuntime.Logger.Log(fmt.Sprintf("[goflow] failed to count store keys, error %v", err))
+		return
+	}
+
+	counts := make(map[string]interface{}, 2*len(flows))
+	for idx, flowName := range flows {
+		counts[storeKeyCountField(StoreTypeState, flowName)] = stateCounts[idx].Val()
+		counts[storeKeyCountField(StoreTypeData, flowName)] = dataCounts[idx].Val()
+	}
+	// replaced as a whole so that the flows no longer registered are dropped, and expired if the leader is gone
+	pipe := rdb.TxPipeline()
+	pipe.Del(ctx, StoreKeyCountsKey)
+	if len(counts) > 0 {
+		pipe.HSet(ctx, StoreKeyCountsKey, counts)
+		pipe.Expire(ctx, StoreKeyCountsKey, 3*interval)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		fRuntime.Logger.Log(fmt.Sprintf("[goflow] failed to save store key counts, error %v", err))
+	}
+}
+
+func storeKeyCountField(store string, flowName string) string {
+	return store + ":" + flowName
+}
+
+// refreshStoreKeyGauges sets the store key gauges from the counts of the leader
+func (fRuntime *FlowRuntime) refreshStoreKeyGauges() {
+	counts, err := fRuntime.redisClient().HGetAll(context.TODO(), StoreKeyCountsKey).Result()
+	if err != nil {
+		return
+	}
+	for field, value := range counts {
+		store, flowName, ok := strings.Cut(field, ":")
+		if !ok {
+			continue
+		}
+		count, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			continue
+		}
+		storeKeysGauge.Set(float64(count), flowName, store)
+	}
+}
@@ -0,0 +1,38 @@
+package runtime_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	redisDataStore "github.com/yuyang0/goflow/core/redis-datastore"
+	"github.com/yuyang0/goflow/types"
+)
+
+// TestSetReaderTracksKey checks that the values streamed into the data store are tracked, and that the set of the
+// tracked keys expires
+func TestSetReaderTracksKey(t *testing.T) {
+	mr := miniredis.RunT(t)
+	store, err := redisDataStore.GetRedisDataStore(&types.RedisConfig{Addr: mr.Addr()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.(*redisDataStore.RedisDataStore).TrackKeys = true
+	store.Configure("tracked", "request")
+
+	if err := store.(*redisDataStore.RedisDataStore).SetReader("streamed", strings.NewReader("value")); err != nil {
+		t.Fatal(err)
+	}
+
+	set := redisDataStore.TrackedKeysSet("tracked")
+	members, err := mr.Members(set)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(members) != 1 || !strings.HasSuffix(members[0], ".streamed.value") {
+		t.Fatalf("expected the streamed key to be tracked, got %v", members)
+	}
+	if ttl := mr.TTL(set); ttl != redisDataStore.TrackedKeysExpiration {
+		t.Fatalf("expected the tracked keys to expire after %v, got %v", redisDataStore.TrackedKeysExpiration, ttl)
+	}
+}
@@ -0,0 +1,111 @@
+package runtime
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/xid"
+	runtimepkg "github.com/yuyang0/goflow/core/runtime"
+	"github.com/yuyang0/goflow/core/runtime/controller"
+)
+
+const (
+	// SyncWriteModeExtend moves the write deadline of a synchronous execution past WriteTimeout
+	SyncWriteModeExtend = "extend"
+	// SyncWriteModeHeartbeat sends the status upfront and writes a space every SyncHeartbeatInterval
+	// until the result is ready
+	SyncWriteModeHeartbeat = "heartbeat"
+
+	DefaultSyncHeartbeatInterval = 10 * time.Second
+
+	// SyncErrorTrailer carries the error of a synchronous execution failing after the status was sent
+	SyncErrorTrailer = "X-Goflow-Error"
+
+	// syncWriteGrace is left to write the result of a request with a deadline
+	syncWriteGrace = 5 * time.Second
+)
+
+func (fRuntime *FlowRuntime) syncHeartbeatInterval() time.Duration {
+	if fRuntime.SyncHeartbeatInterval <= 0 {
+		return DefaultSyncHeartbeatInterval
+	}
+	return fRuntime.SyncHeartbeatInterval
+}
+
+// syncWriteDeadline returns the write deadline of a synchronous execution, now plus SyncWriteTimeout if set,
+// the deadline of the request plus a grace period otherwise. It is zero, i.e. no deadline, for the requests
+// without a deadline. As a write deadline of the connection, it is a wall clock time rather than one of the clock
+// of the runtime
+func (fRuntime *FlowRuntime) syncWriteDeadline(request *runtimepkg.Request) time.Time {
+	if fRuntime.SyncWriteTimeout > 0 {
+		return time.Now().Add(fRuntime.SyncWriteTimeout)
+	}
+	if !request.Deadline.IsZero() {
+		return request.Deadline.Add(syncWriteGrace)
+	}
+	return time.Time{}
+}
+
+// extendSyncWriteDeadline exempts a synchronous execution from the WriteTimeout of the server
+func (fRuntime *FlowRuntime) extendSyncWriteDeadline(c *gin.Context, request *runtimepkg.Request) {
+	err := http.NewResponseController(c.Writer).SetWriteDeadline(fRuntime.syncWriteDeadline(request))
+	if err != nil {
+		fRuntime.Logger.Log(fmt.Sprintf("[goflow] failed to extend write deadline of flow %s, error %v", request.FlowName, err))
+	}
+}
+
+// executeWithHeartbeat runs a synchronous execution while keeping its connection alive. The status and the
+// request id are sent upfront, then a space every heartbeat interval, each one moving the write deadline
+// forward. The result follows the spaces, or the error is sent in the X-Goflow-Error trailer
+func (fRuntime *FlowRuntime) executeWithHeartbeat(c *gin.Context, request *runtimepkg.Request, response *runtimepkg.Response,
+	execute func() error) {
+	if request.RequestID == "" {
+		request.RequestID = xid.New().String()
+	}
+	response.RequestID = request.RequestID
+
+	interval := fRuntime.syncHeartbeatInterval()
+	rc := http.NewResponseController(c.Writer)
+	headers := c.Writer.Header()
+	headers.Set(controller.RequestIdHeader, request.RequestID)
+	headers.Set("Trailer", SyncErrorTrailer)
+	c.Writer.WriteHeader(http.StatusOK)
+	c.Writer.WriteHeaderNow()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- execute()
+	}()
+
+	ticker := fRuntime.clock().NewTicker(interval)
+	defer ticker.Stop()
+	alive := true
+	for {
+		select {
+		case err := <-done:
+			if !alive {
+				return
+			}
+			rc.SetWriteDeadline(fRuntime.syncWriteDeadline(request))
+			if err != nil {
+				headers.Set(SyncErrorTrailer, fmt.Sprintf("request failed to be processed, %v", err))
+				return
+			}
+			c.Writer.Write(response.Body)
+			return
+		case <-ticker.C():
+			if !alive {
+				continue
+			}
+			rc.SetWriteDeadline(time.Now().Add(2 * interval))
+			if _, err := c.Writer.Write([]byte(" ")); err != nil {
+				// the client is gone, the execution goes on
+				alive = false
+				continue
+			}
+			rc.Flush()
+		}
+	}
+}
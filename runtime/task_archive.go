@@ -0,0 +1,90 @@
+package runtime
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/sergi/go-diff/diffmatchpatch"
+)
+
+const (
+	TaskArchiveKeyInitial = "goflow-task"
+
+	TaskArchiveTimeOut = 24 * time.Hour
+)
+
+// archiveTask stores a submitted task so that it can be inspected later,
+// it is a no-op unless DurableTasksEnabled is set
+func (fRuntime *FlowRuntime) archiveTask(task *Task) error {
+	if !fRuntime.DurableTasksEnabled {
+		return nil
+	}
+	data, err := json.Marshal(task)
+	if err != nil {
+		return fmt.Errorf("failed to marshal task, error %v", err)
+	}
+	key := fmt.Sprintf("%s:%s", TaskArchiveKeyInitial, task.RequestID)
+	return fRuntime.redisClient().Set(context.TODO(), key, data, TaskArchiveTimeOut).Err()
+}
+
+// getArchivedTask retrieves a task from the task archive by request id
+func (fRuntime *FlowRuntime) getArchivedTask(ctx context.Context, requestID string) (*Task, error) {
+	key := fmt.Sprintf("%s:%s", TaskArchiveKeyInitial, requestID)
+	data, err := fRuntime.redisClient().Get(ctx, key).Result()
+	if err == redis.Nil {
+		return nil, fmt.Errorf("request %s not found in task archive", requestID)
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to get request %s from task archive, error %v", requestID, err)
+	}
+	task := &Task{}
+	if err := json.Unmarshal([]byte(data), task); err != nil {
+		return nil, fmt.Errorf("failed to parse archived request %s, error %v", requestID, err)
+	}
+	return task, nil
+}
+
+// DiffRequestBodies returns a line based unified diff between the bodies of two archived requests
+func (fRuntime *FlowRuntime) DiffRequestBodies(ctx context.Context, requestID1, requestID2 string) (string, error) {
+	if !fRuntime.DurableTasksEnabled {
+		return "", fmt.Errorf("task archive is disabled, DurableTasksEnabled must be set")
+	}
+
+	task1, err := fRuntime.getArchivedTask(ctx, requestID1)
+	if err != nil {
+		return "", err
+	}
+	task2, err := fRuntime.getArchivedTask(ctx, requestID2)
+	if err != nil {
+		return "", err
+	}
+
+	dmp := diffmatchpatch.New()
+	chars1, chars2, lines := dmp.DiffLinesToChars(string(task1.Body), string(task2.Body))
+	diffs := dmp.DiffCharsToLines(dmp.DiffMain(chars1, chars2, false), lines)
+
+	var builder strings.Builder
+	builder.WriteString(fmt.Sprintf("--- %s\n+++ %s\n", requestID1, requestID2))
+	for _, diff := range diffs {
+		prefix := " "
+		switch diff.Type {
+		case diffmatchpatch.DiffDelete:
+			prefix = "-"
+		case diffmatchpatch.DiffInsert:
+			prefix = "+"
+		}
+		for _, line := range strings.SplitAfter(diff.Text, "\n") {
+			if line == "" {
+				continue
+			}
+			builder.WriteString(prefix + line)
+			if !strings.HasSuffix(line, "\n") {
+				builder.WriteString("\n")
+			}
+		}
+	}
+	return builder.String(), nil
+}
@@ -0,0 +1,205 @@
+package runtime
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// TaskBodyEncodingBase64 marks the tasks whose body is base64 encoded as it is not valid utf-8
+const TaskBodyEncodingBase64 = "base64"
+
+// TaskBody is the request body of a task. It is encoded as a json string so that the queued tasks keep
+// their format, and decoded straight into a byte slice that is handed to the request without copies
+type TaskBody []byte
+
+func (body TaskBody) MarshalJSON() ([]byte, error) {
+	return quoteBytes(body), nil
+}
+
+func (body *TaskBody) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+	value, err := unquoteBytes(data)
+	if err != nil {
+		return fmt.Errorf("invalid task body, %v", err)
+	}
+	*body = value
+	return nil
+}
+
+// taskFields is a Task without its json methods
+type taskFields Task
+
+// MarshalJSON encodes the body of a task in base64 when it is not valid utf-8, e.g. an image, as a json string
+// only carries text. The encoded bodies are marked by the BodyEncoding of the task
+func (task Task) MarshalJSON() ([]byte, error) {
+	fields := taskFields(task)
+	if fields.BodyEncoding == "" && !utf8.Valid(fields.Body) {
+		fields.Body = TaskBody(base64.StdEncoding.EncodeToString(fields.Body))
+		fields.BodyEncoding = TaskBodyEncodingBase64
+	}
+	return json.Marshal(fields)
+}
+
+// UnmarshalJSON decodes a task, along with its body when it is encoded
+func (task *Task) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, (*taskFields)(task)); err != nil {
+		return err
+	}
+	switch task.BodyEncoding {
+	case "":
+		return nil
+	case TaskBodyEncodingBase64:
+		body := make([]byte, base64.StdEncoding.DecodedLen(len(task.Body)))
+		size, err := base64.StdEncoding.Decode(body, task.Body)
+		if err != nil {
+			return fmt.Errorf("invalid task body, %v", err)
+		}
+		task.Body = body[:size]
+		task.BodyEncoding = ""
+		return nil
+	default:
+		return fmt.Errorf("invalid task body, unknown encoding %s", task.BodyEncoding)
+	}
+}
+
+// isBinaryBody checks if a request body is binary from its content type. The bodies of the textual content types,
+// or without a content type, are binary when they are not valid utf-8
+func isBinaryBody(header map[string][]string, body []byte) bool {
+	if len(body) == 0 {
+		return false
+	}
+	mediaType, _, err := mime.ParseMediaType(http.Header(header).Get(ContentTypeHeader))
+	if err != nil || strings.HasPrefix(mediaType, "text/") || strings.HasSuffix(mediaType, "json") ||
+		strings.HasSuffix(mediaType, "xml") || strings.HasSuffix(mediaType, "yaml") ||
+		mediaType == "application/x-www-form-urlencoded" || mediaType == "application/javascript" {
+		return !utf8.Valid(body)
+	}
+	return true
+}
+
+// quoteBytes encodes a slice as a json string literal, as encoding/json encodes a string but without converting it
+// to a string first. Invalid utf-8 is replaced by the replacement character
+func quoteBytes(data []byte) []byte {
+	const hex = "0123456789abcdef"
+	quoted := make([]byte, 0, len(data)+2)
+	quoted = append(quoted, '"')
+	for idx := 0; idx < len(data); {
+		if c := data[idx]; c < utf8.RuneSelf {
+			switch {
+			case c == '"' || c == '\\':
+				quoted = append(quoted, '\\', c)
+			case c == '\n':
+				quoted = append(quoted, '\\', 'n')
+			case c == '\r':
+				quoted = append(quoted, '\\', 'r')
+			case c == '\t':
+				quoted = append(quoted, '\\', 't')
+			case c < 0x20 || c == '<' || c == '>' || c == '&':
+				quoted = append(quoted, '\\', 'u', '0', '0', hex[c>>4], hex[c&0xf])
+			default:
+				quoted = append(quoted, c)
+			}
+			idx++
+			continue
+		}
+		r, size := utf8.DecodeRune(data[idx:])
+		switch {
+		case r == utf8.RuneError && size == 1:
+			quoted = append(quoted, `\ufffd`...)
+		case r == '\u2028' || r == '\u2029':
+			quoted = append(quoted, '\\', 'u', '2', '0', '2', hex[r&0xf])
+		default:
+			quoted = append(quoted, data[idx:idx+size]...)
+		}
+		idx += size
+	}
+	return append(quoted, '"')
+}
+
+// unquoteBytes decodes a json string literal into a newly allocated slice, the single copy of a task body
+func unquoteBytes(data []byte) ([]byte, error) {
+	if len(data) < 2 || data[0] != '"' || data[len(data)-1] != '"' {
+		return nil, fmt.Errorf("expected a json string")
+	}
+	data = data[1 : len(data)-1]
+
+	decoded := make([]byte, 0, len(data))
+	for idx := 0; idx < len(data); {
+		next := bytes.IndexByte(data[idx:], '\\')
+		if next < 0 {
+			decoded = append(decoded, data[idx:]...)
+			break
+		}
+		decoded = append(decoded, data[idx:idx+next]...)
+		idx += next
+		if idx+1 >= len(data) {
+			return nil, fmt.Errorf("unterminated escape sequence")
+		}
+		switch data[idx+1] {
+		case '"', '\\', '/':
+			decoded = append(decoded, data[idx+1])
+		case 'b':
+			decoded = append(decoded, '\b')
+		case 'f':
+			decoded = append(decoded, '\f')
+		case 'n':
+			decoded = append(decoded, '\n')
+		case 'r':
+			decoded = append(decoded, '\r')
+		case 't':
+			decoded = append(decoded, '\t')
+		case 'u':
+			r, size, err := decodeUnicodeEscape(data[idx:])
+			if err != nil {
+				return nil, err
+			}
+			decoded = utf8.AppendRune(decoded, r)
+			idx += size
+			continue
+		default:
+			return nil, fmt.Errorf("invalid escape sequence \\%c", data[idx+1])
+		}
+		idx += 2
+	}
+	return decoded, nil
+}
+
+// decodeUnicodeEscape decodes a \uXXXX escape, or a surrogate pair of them, and returns the rune
+// along with the number of bytes consumed
+func decodeUnicodeEscape(data []byte) (rune, int, error) {
+	r, err := parseHex4(data)
+	if err != nil {
+		return 0, 0, err
+	}
+	if !utf16.IsSurrogate(r) {
+		return r, 6, nil
+	}
+	if r2, err := parseHex4(data[6:]); err == nil {
+		if decoded := utf16.DecodeRune(r, r2); decoded != utf8.RuneError {
+			return decoded, 12, nil
+		}
+	}
+	// a lone surrogate is replaced like encoding/json does
+	return utf8.RuneError, 6, nil
+}
+
+func parseHex4(data []byte) (rune, error) {
+	if len(data) < 6 || data[0] != '\\' || data[1] != 'u' {
+		return 0, fmt.Errorf("invalid unicode escape sequence")
+	}
+	value, err := strconv.ParseUint(string(data[2:6]), 16, 16)
+	if err != nil {
+		return 0, fmt.Errorf("invalid unicode escape sequence %s", data[:6])
+	}
+	return rune(value), nil
+}
@@ -0,0 +1,137 @@
+package runtime_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	flow "github.com/yuyang0/goflow/flow/v1"
+	"github.com/yuyang0/goflow/runtime"
+	goflow "github.com/yuyang0/goflow/v1"
+)
+
+// binaryFlow passes the body through three nodes, recording the data each node received
+type binaryFlow struct {
+	mu       sync.Mutex
+	received map[string][][]byte
+}
+
+func (bf *binaryFlow) definition(wf *flow.Workflow, _ *flow.Context) error {
+	dag := wf.Dag()
+	for _, node := range []string{"first", "second", "third"} {
+		node := node
+		dag.Node(node, func(data []byte, _ map[string][]string) ([]byte, error) {
+			bf.mu.Lock()
+			defer bf.mu.Unlock()
+			bf.received[node] = append(bf.received[node], data)
+			return data, nil
+		})
+	}
+	dag.Edge("first", "second")
+	dag.Edge("second", "third")
+	return nil
+}
+
+// TestBinaryBodyRoundTrip checks that binary bodies reach every node of a multi-node flow unchanged, whether they
+// are carried in the task or stored in the DataStore
+func TestBinaryBodyRoundTrip(t *testing.T) {
+	for _, storeBinaryBodies := range []bool{false, true} {
+		t.Run(fmt.Sprintf("StoreBinaryBodies=%v", storeBinaryBodies), func(t *testing.T) {
+			bf := &binaryFlow{}
+			bf.received = make(map[string][][]byte)
+			fs := &goflow.FlowService{StoreBinaryBodies: storeBinaryBodies}
+			_, client := startWorker(t, fs, map[string]runtime.FlowDefinitionHandler{"binary": bf.definition}, nil)
+			client.StoreBinaryBodies = storeBinaryBodies
+
+			random := rand.New(rand.NewSource(1))
+			var bodies [][]byte
+			for _, size := range []int{1, 1024, 300 * 1024} {
+				body := make([]byte, size)
+				random.Read(body)
+				// invalid utf-8, so that a body carried as a json string would be corrupted
+				body[0] = 0xff
+				bodies = append(bodies, body)
+				err := client.Execute("binary", &goflow.Request{
+					Body:   body,
+					Header: map[string][]string{"Content-Type": {"application/octet-stream"}},
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			eventually(t, 15*time.Second, func() bool {
+				bf.mu.Lock()
+				defer bf.mu.Unlock()
+				return len(bf.received["third"]) == len(bodies)
+			}, "the bodies didn't reach the last node")
+			bf.mu.Lock()
+			defer bf.mu.Unlock()
+			for _, node := range []string{"first", "second", "third"} {
+				for _, body := range bodies {
+					if !containsBody(bf.received[node], body) {
+						t.Fatalf("expected the body of %d bytes to reach the node %s unchanged", len(body), node)
+					}
+				}
+			}
+		})
+	}
+}
+
+func containsBody(received [][]byte, body []byte) bool {
+	for _, data := range received {
+		if bytes.Equal(data, body) {
+			return true
+		}
+	}
+	return false
+}
+
+// TestTaskBodyEncodesAsString checks that a task body is encoded as encoding/json encodes the same string, so that
+// the tasks already queued keep their format, and that it decodes back
+func TestTaskBodyEncodesAsString(t *testing.T) {
+	bodies := []string{"", "{\"key\": \"value\"}", "tab\tnew line\n\"quoted\" back\\slash", "<html> & \x01\x1f",
+		"héllo wörld ✓ 😀", "separators   ", "invalid \xff\xfe utf-8"}
+	for _, body := range bodies {
+		encoded, err := json.Marshal(runtime.TaskBody(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the replacement of invalid utf-8 is encoded differently across go versions
+		expected, _ := json.Marshal(body)
+		if utf8.ValidString(body) && !bytes.Equal(encoded, expected) {
+			t.Fatalf("expected the body %q to be encoded as %s, got %s", body, expected, encoded)
+		}
+		var decoded runtime.TaskBody
+		if err := json.Unmarshal(encoded, &decoded); err != nil {
+			t.Fatal(err)
+		}
+		var decodedString string
+		json.Unmarshal(expected, &decodedString)
+		if string(decoded) != decodedString {
+			t.Fatalf("expected the body %q to be decoded as %q, got %q", body, decodedString, decoded)
+		}
+	}
+}
+
+func BenchmarkTaskDecode(b *testing.B) {
+	body := bytes.Repeat([]byte("goflow "), 1<<20/7)
+	payload, err := json.Marshal(runtime.Task{FlowName: "bench", RequestID: "bench-request", Body: body})
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	b.SetBytes(int64(len(body)))
+	b.ResetTimer()
+	for idx := 0; idx < b.N; idx++ {
+		var task runtime.Task
+		if err := json.Unmarshal(payload, &task); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package runtime
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/adjust/rmq/v5"
+	"github.com/gin-gonic/gin"
+	"github.com/yuyang0/goflow/metrics"
+	runtimeCommon "github.com/yuyang0/goflow/runtime/common"
+)
+
+const (
+	DeadLetterKey = "goflow-dead-letter"
+
+	// DeadLetterMaxEntries is the number of tasks kept in the dead letter list
+	DeadLetterMaxEntries = 10000
+
+	// DefaultUnroutableGracePeriod is how long a task of a flow no worker advertises is requeued before it is dead lettered
+	DefaultUnroutableGracePeriod = 5 * time.Minute
+	// UnroutableRequeueDelay is the wait before requeuing a task of a flow that is not registered on the worker
+	UnroutableRequeueDelay = time.Second
+
+	UnroutableActionRequeued   = "requeued"
+	UnroutableActionDeadLetter = "dead_letter"
+)
+
+var unroutableTasksCounter = metrics.NewCounterVec("goflow_unroutable_tasks_total",
+	"Tasks consumed by a worker that doesn't have their flow registered", "flow", "action")
+
+// DeadLetter is a task that could not be processed
+type DeadLetter struct {
+	Task     *Task     `json:"task"`
+	Reason   string    `json:"reason"`
+	Category string    `json:"category,omitempty"` // error category of the failures routed by the DeadLetterRouting
+	Time     time.Time `json:"time"`
+
+	// Location is where the request currently is, set when the dead letters are listed
+	Location *RequestLocation `json:"location,omitempty"`
+}
+
+func (fRuntime *FlowRuntime) unroutableGracePeriod() time.Duration {
+	if fRuntime.UnroutableGracePeriod <= 0 {
+		return DefaultUnroutableGracePeriod
+	}
+	return fRuntime.UnroutableGracePeriod
+}
+
+// flowHasWorkers checks if any registered worker advertises the flow
+func (fRuntime *FlowRuntime) flowHasWorkers(flowName string) (bool, error) {
+	rings, err := fRuntime.stickyRings()
+	if err != nil {
+		return false, err
+	}
+	_, ok := rings[flowName]
+	return ok, nil
+}
+
+// handleUnregisteredFlow handles a task of a flow that is not registered on this worker. The task is
+// requeued after a short delay for a capable worker to pick it up, without going through the retry queues.
+// Once no worker has advertised the flow for the grace period the task is dead lettered
+func (fRuntime *FlowRuntime) handleUnregisteredFlow(message rmq.Delivery, task *Task) {
+	if task.UnroutableSince == 0 {
+		task.UnroutableSince = fRuntime.clock().Now().UnixNano()
+	}
+
+	hasWorkers, err := fRuntime.flowHasWorkers(task.FlowName)
+	if err != nil {
+		fRuntime.Logger.Log(fmt.Sprintf("[goflow] failed to check workers of flow %s, error %v", task.FlowName, err))
+		hasWorkers = true
+	}
+	if !hasWorkers && fRuntime.clock().Now().Sub(time.Unix(0, task.UnroutableSince)) > fRuntime.unroutableGracePeriod() {
+		reason := fmt.Sprintf("flow %s is not registered on any worker since %s",
+			task.FlowName, time.Unix(0, task.UnroutableSince).Format(time.RFC3339))
+		if err := fRuntime.deadLetter(task, reason); err != nil {
+			fRuntime.Logger.Log(fmt.Sprintf("[request `%s`] failed to dead letter task, error %v", task.RequestID, err))
+			if err := message.Reject(); err != nil {
+				fRuntime.handleQueueError(QueueOperationAck, task, err)
+			}
+			return
+		}
+		unroutableTasksCounter.Inc(task.FlowName, UnroutableActionDeadLetter)
+		fRuntime.Logger.Log(fmt.Sprintf("[request `%s`] dead lettered, %s", task.RequestID, reason))
+		if err := message.Ack(); err != nil {
+			fRuntime.handleQueueError(QueueOperationAck, task, err)
+		}
+		return
+	}
+
+	if err := fRuntime.requeueDelivery(message, task, UnroutableRequeueDelay); err != nil {
+		fRuntime.Logger.Log(fmt.Sprintf("[request `%s`] failed to requeue task of unregistered flow %s, error %v",
+			task.RequestID, task.FlowName, err))
+		return
+	}
+	unroutableTasksCounter.Inc(task.FlowName, UnroutableActionRequeued)
+}
+
+// deadLetter stores a task that can't be processed along with the reason
+func (fRuntime *FlowRuntime) deadLetter(task *Task, reason string) error {
+	return fRuntime.deadLetterTo("", &DeadLetter{Task: task, Reason: reason})
+}
+
+// deadLetterTo stores a dead letter in a dead letter queue, the default dead letter list for an empty name
+func (fRuntime *FlowRuntime) deadLetterTo(queue string, deadLetter *DeadLetter) error {
+	deadLetter.Time = fRuntime.clock().Now()
+	data, err := json.Marshal(deadLetter)
+	if err != nil {
+		return fmt.Errorf("failed to marshal dead letter, error %v", err)
+	}
+	ctx := context.TODO()
+	key := deadLetterQueueKey(queue)
+	pipe := fRuntime.redisClient().TxPipeline()
+	pipe.LPush(ctx, key, data)
+	pipe.LTrim(ctx, key, 0, DeadLetterMaxEntries-1)
+	if tracksLocation(deadLetter.Task) {
+		fRuntime.addLocation(ctx, pipe, deadLetter.Task.FlowName, deadLetter.Task.RequestID, deadLetterQueueLocation(queue),
+			LocationStatusDeadLettered, "")
+		fRuntime.addRouting(ctx, pipe, deadLetter.Task.FlowName, deadLetter.Task.RequestID, deadLetterQueueLocation(queue),
+			RoutingActionDLQ)
+	}
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// GetDeadLetters returns the latest dead lettered tasks, newest first
+func (fRuntime *FlowRuntime) GetDeadLetters(ctx context.Context, count int) ([]*DeadLetter, error) {
+	return fRuntime.GetDeadLetterQueue(ctx, "", count)
+}
+
+// GetDeadLetterQueue returns the latest tasks of a dead letter queue of the DeadLetterRouting, newest first
+func (fRuntime *FlowRuntime) GetDeadLetterQueue(ctx context.Context, queue string, count int) ([]*DeadLetter, error) {
+	if count <= 0 || count > DeadLetterMaxEntries {
+		count = DeadLetterMaxEntries
+	}
+	values, err := fRuntime.redisClient().LRange(ctx, deadLetterQueueKey(queue), 0, int64(count-1)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get dead letters, error %v", err)
+	}
+	deadLetters := make([]*DeadLetter, 0, len(values))
+	requestIds := make(map[string][]string) // by flow
+	for _, value := range values {
+		deadLetter := &DeadLetter{}
+		if err := json.Unmarshal([]byte(value), deadLetter); err != nil {
+			continue
+		}
+		deadLetters = append(deadLetters, deadLetter)
+		if tracksLocation(deadLetter.Task) {
+			requestIds[deadLetter.Task.FlowName] = append(requestIds[deadLetter.Task.FlowName], deadLetter.Task.RequestID)
+		}
+	}
+
+	locations := make(map[string]map[string]*RequestLocation)
+	for flowName, ids := range requestIds {
+		// best effort, the dead letters are listed without their location
+		locations[flowName], err = fRuntime.getLocations(ctx, flowName, ids)
+		if err != nil {
+			fRuntime.Logger.Log(fmt.Sprintf("[goflow] failed to locate dead letters of flow %s, error %v", flowName, err))
+		}
+	}
+	for _, deadLetter := range deadLetters {
+		if deadLetter.Task != nil {
+			deadLetter.Location = locations[deadLetter.Task.FlowName][deadLetter.Task.RequestID]
+		}
+	}
+	return deadLetters, nil
+}
+
+func deadLetterListHandler(runtime *FlowRuntime) func(*gin.Context) {
+	fn := func(c *gin.Context) {
+		count, _ := strconv.Atoi(c.Query("count"))
+		deadLetters, err := runtime.GetDeadLetterQueue(c.Request.Context(), c.Query("queue"), count)
+		if err != nil {
+			runtimeCommon.HandleError(c.Writer, fmt.Sprintf("Failed to get dead letters, %v", err))
+			return
+		}
+		c.JSON(http.StatusOK, deadLetters)
+	}
+	return fn
+}
@@ -0,0 +1,246 @@
+package runtime
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yuyang0/goflow/core/sdk"
+	"github.com/yuyang0/goflow/metrics"
+	runtimeCommon "github.com/yuyang0/goflow/runtime/common"
+)
+
+const (
+	UsageKeyInitial = "goflow-usage"
+
+	// UsageRetentionDays is the number of daily usage buckets kept in redis
+	UsageRetentionDays = 31
+	// UsageFlushInterval is the interval the workers add the usage they counted to the daily buckets at
+	UsageFlushInterval = 10 * time.Second
+
+	// UsageExecutionSeconds is the wall time workers spent handling new and partial requests of the flow,
+	// it covers the node execution along with the state bookkeeping of the executor
+	UsageExecutionSeconds = "execution_seconds"
+	// UsageDataStoreBytes is the size of the values set into and read from the DataStore by the nodes of the flow
+	UsageDataStoreBytes = "datastore_bytes"
+	// UsageQueueMessages is the number of deliveries consumed by the workers for the flow, including retries
+	UsageQueueMessages = "queue_messages"
+)
+
+var (
+	executionSecondsCounter = metrics.NewCounterVec("goflow_flow_execution_seconds_total",
+		"Wall time spent by workers handling requests of the flow", "flow")
+	dataStoreBytesCounter = metrics.NewCounterVec("goflow_flow_datastore_bytes_total",
+		"Bytes set into and read from the DataStore by the flow", "flow")
+	queueMessagesCounter = metrics.NewCounterVec("goflow_flow_queue_messages_total",
+		"Queue deliveries consumed for the flow", "flow")
+)
+
+// usageBuffer is the usage counted by the worker since it was last flushed to redis, by flow and counter
+type usageBuffer struct {
+	mu     sync.Mutex
+	counts map[string]map[string]float64
+}
+
+func (buffer *usageBuffer) add(flowName string, counter string, value float64) {
+	buffer.mu.Lock()
+	defer buffer.mu.Unlock()
+	if buffer.counts == nil {
+		buffer.counts = make(map[string]map[string]float64)
+	}
+	if buffer.counts[flowName] == nil {
+		buffer.counts[flowName] = make(map[string]float64)
+	}
+	buffer.counts[flowName][counter] += value
+}
+
+func (buffer *usageBuffer) take() map[string]map[string]float64 {
+	buffer.mu.Lock()
+	defer buffer.mu.Unlock()
+	counts := buffer.counts
+	buffer.counts = nil
+	return counts
+}
+
+// recordUsage increases a usage counter of a flow in the prometheus counters, and in the daily bucket once the
+// usage of the worker is flushed, so that counting doesn't add a round trip to the execution
+func (fRuntime *FlowRuntime) recordUsage(flowName string, counter string, value float64) {
+	switch counter {
+	case UsageExecutionSeconds:
+		executionSecondsCounter.Add(value, flowName)
+	case UsageDataStoreBytes:
+		dataStoreBytesCounter.Add(value, flowName)
+	case UsageQueueMessages:
+		queueMessagesCounter.Add(value, flowName)
+	}
+	fRuntime.usage.add(flowName, counter, value)
+}
+
+// flushUsage adds the usage counted by the worker since the last flush to the current daily buckets, the usage
+// failing to be flushed is counted again in the next flush
+func (fRuntime *FlowRuntime) flushUsage() {
+	counts := fRuntime.usage.take()
+	if len(counts) == 0 {
+		return
+	}
+	ctx := context.TODO()
+	now := fRuntime.clock().Now().UTC()
+	pipe := fRuntime.redisClient().TxPipeline()
+	for flowName, counters := range counts {
+		key := usageKey(flowName, now)
+		for counter, value := range counters {
+			pipe.HIncrByFloat(ctx, key, counter, value)
+		}
+		pipe.Expire(ctx, key, UsageRetentionDays*24*time.Hour)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		fRuntime.Logger.Log(fmt.Sprintf("[goflow] failed to record usage, error %v", err))
+		for flowName, counters := range counts {
+			for counter, value := range counters {
+				fRuntime.usage.add(flowName, counter, value)
+			}
+		}
+	}
+}
+
+// GetUsage returns the daily usage counters of a flow for the last days, keyed by the date (UTC)
+func (fRuntime *FlowRuntime) GetUsage(ctx context.Context, flowName string, days int) (map[string]map[string]float64, error) {
+	if days <= 0 || days > UsageRetentionDays {
+		days = UsageRetentionDays
+	}
+
+	usage := make(map[string]map[string]float64)
+	now := fRuntime.clock().Now().UTC()
+	for idx := 0; idx < days; idx++ {
+		day := now.AddDate(0, 0, -idx)
+		values, err := fRuntime.redisClient().HGetAll(ctx, usageKey(flowName, day)).Result()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get usage of flow %s, error %v", flowName, err)
+		}
+		if len(values) == 0 {
+			continue
+		}
+		counters := make(map[string]float64, len(values))
+		for counter, value := range values {
+			counters[counter], _ = strconv.ParseFloat(value, 64)
+		}
+		usage[day.Format("2006-01-02")] = counters
+	}
+	return usage, nil
+}
+
+func usageKey(flowName string, day time.Time) string {
+	return fmt.Sprintf("%s:%s:%s", UsageKeyInitial, flowName, day.Format("2006-01-02"))
+}
+
+// usageDataStore records the bytes going through the DataStore of a flow
+type usageDataStore struct {
+	sdk.DataStore
+	flowName string
+	runtime  *FlowRuntime
+}
+
+func (store *usageDataStore) Set(key string, value []byte) error {
+	err := store.DataStore.Set(key, value)
+	if err == nil {
+		store.runtime.recordUsage(store.flowName, UsageDataStoreBytes, float64(len(value)))
+	}
+	return err
+}
+
+func (store *usageDataStore) Get(key string) ([]byte, error) {
+	value, err := store.DataStore.Get(key)
+	if err == nil {
+		store.runtime.recordUsage(store.flowName, UsageDataStoreBytes, float64(len(value)))
+	}
+	return value, err
+}
+
+func (store *usageDataStore) SetReader(key string, reader io.Reader) error {
+	counter := &countingReader{Reader: reader}
+	err := sdk.SetReader(store.DataStore, key, counter)
+	if err == nil {
+		store.runtime.recordUsage(store.flowName, UsageDataStoreBytes, float64(counter.count))
+	}
+	return err
+}
+
+func (store *usageDataStore) GetReader(key string) (io.ReadCloser, error) {
+	reader, err := sdk.GetReader(store.DataStore, key)
+	if err != nil {
+		return nil, err
+	}
+	return &usageReadCloser{countingReader: countingReader{Reader: reader}, closer: reader, store: store}, nil
+}
+
+func (store *usageDataStore) SetIfAbsent(key string, value []byte) (bool, error) {
+	set, err := sdk.SetIfAbsent(store.DataStore, key, value)
+	if set {
+		store.runtime.recordUsage(store.flowName, UsageDataStoreBytes, float64(len(value)))
+	}
+	return set, err
+}
+
+func (store *usageDataStore) Ping() error {
+	return pingStore(store.DataStore)
+}
+
+// Unwrap returns the store the usage is recorded on
+func (store *usageDataStore) Unwrap() sdk.DataStore {
+	return store.DataStore
+}
+
+func (store *usageDataStore) CopyStore() (sdk.DataStore, error) {
+	copied, err := store.DataStore.CopyStore()
+	if err != nil {
+		return nil, err
+	}
+	return &usageDataStore{DataStore: copied, flowName: store.flowName, runtime: store.runtime}, nil
+}
+
+// countingReader counts the bytes read from a reader
+type countingReader struct {
+	io.Reader
+	count int64
+}
+
+func (reader *countingReader) Read(p []byte) (int, error) {
+	n, err := reader.Reader.Read(p)
+	reader.count += int64(n)
+	return n, err
+}
+
+// usageReadCloser records the bytes read from a DataStore value once closed
+type usageReadCloser struct {
+	countingReader
+	closer io.Closer
+	store  *usageDataStore
+}
+
+func (reader *usageReadCloser) Close() error {
+	reader.store.runtime.recordUsage(reader.store.flowName, UsageDataStoreBytes, float64(reader.count))
+	return reader.closer.Close()
+}
+
+func flowUsageHandler(runtime *FlowRuntime) func(*gin.Context) {
+	fn := func(c *gin.Context) {
+		flowName, ok := flowNameParam(runtime, c)
+		if !ok {
+			return
+		}
+		days, _ := strconv.Atoi(c.Query("days"))
+
+		usage, err := runtime.GetUsage(c.Request.Context(), flowName, days)
+		if err != nil {
+			runtimeCommon.HandleError(c.Writer, fmt.Sprintf("Failed to get usage, %v", err))
+			return
+		}
+		c.JSON(http.StatusOK, usage)
+	}
+	return fn
+}
@@ -0,0 +1,91 @@
+package runtime
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Warmup pings redis, fills the connection pool, opens the queues of every registered flow and,
+// in worker mode, makes sure their consumers are running. The runtime reports ready on `readyz` once it succeeds
+func (fRuntime *FlowRuntime) Warmup(ctx context.Context) error {
+	if !fRuntime.initialized.Load() {
+		return fmt.Errorf("unable to warmup, runtime not initialized, Init must be called first")
+	}
+
+	rdb := fRuntime.redisClient()
+	if err := rdb.Ping(ctx).Err(); err != nil {
+		return fmt.Errorf("failed to warmup, redis ping failed, error %v", err)
+	}
+
+	// concurrent pings establish as many pooled connections as the consumers will use
+	connections := fRuntime.Concurrency
+	if connections < 1 {
+		connections = 1
+	}
+	if poolSize := rdb.Options().PoolSize; poolSize > 0 && connections > poolSize {
+		connections = poolSize
+	}
+	var wg sync.WaitGroup
+	errs := make(chan error, connections)
+	for idx := 0; idx < connections; idx++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- rdb.Ping(ctx).Err()
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			return fmt.Errorf("failed to warmup, connection pool priming failed, error %v", err)
+		}
+	}
+
+	for _, flowName := range fRuntime.ListFlows() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if _, err := fRuntime.rmqConnection.OpenQueue(fRuntime.internalRequestQueueId(flowName)); err != nil {
+			return fmt.Errorf("failed to warmup, failed to open queue of flow %s, error %v", flowName, err)
+		}
+		for idx := 0; idx < fRuntime.PartitionCount; idx++ {
+			if _, err := fRuntime.rmqConnection.OpenQueue(fRuntime.partitionQueueId(flowName, idx)); err != nil {
+				return fmt.Errorf("failed to warmup, failed to open partition queue of flow %s, error %v", flowName, err)
+			}
+		}
+	}
+
+	if fRuntime.workerMode.Load() {
+		fRuntime.queueMu.Lock()
+		// consumers are only started for the flows registered since entering worker mode
+		err := fRuntime.initializeTaskQueues(&fRuntime.rmqConnection, fRuntime.Flows)
+		fRuntime.queueMu.Unlock()
+		if err != nil {
+			return fmt.Errorf("failed to warmup, failed to start consumers, error %v", err)
+		}
+	}
+
+	fRuntime.ready.Store(true)
+	return nil
+}
+
+// Ready returns true once the runtime has been warmed up and until it is shutdown
+func (fRuntime *FlowRuntime) Ready() bool {
+	return fRuntime.ready.Load()
+}
+
+func readyzHandler(runtime *FlowRuntime) func(*gin.Context) {
+	fn := func(c *gin.Context) {
+		if !runtime.Ready() {
+			c.String(http.StatusServiceUnavailable, "not ready")
+			return
+		}
+		c.String(http.StatusOK, "ok")
+	}
+	return fn
+}
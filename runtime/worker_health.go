@@ -0,0 +1,182 @@
+package runtime
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	WorkerJoined = "joined" // the registration of a worker was created
+	WorkerLeft   = "left"   // the registration of a worker expired, or was removed on shutdown
+
+	// keyspaceEventFlags are the keyspace notifications WatchWorkerHealth needs, the keyspace events (K) of the
+	// generic commands (g), the string commands (set) and the expired keys (x)
+	keyspaceEventFlags = "Kg$x"
+)
+
+// WorkerHealthEvent is emitted by WatchWorkerHealth when a worker joins or leaves
+type WorkerHealthEvent struct {
+	WorkerID  string    `json:"worker_id"`
+	EventType string    `json:"event_type"` // joined or left
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// WatchWorkerHealth emits an event when a worker registers and when its registration expires or is removed, from
+// the keyspace notifications of the registrations when redis publishes them, notify-keyspace-events including
+// Kg$x, and by polling the registrations every GoFlowRegisterInterval otherwise. The workers registered when the
+// watch starts are not reported as joined. The channel is closed once ctx is done
+func (fRuntime *FlowRuntime) WatchWorkerHealth(ctx context.Context) (<-chan WorkerHealthEvent, error) {
+	known, err := fRuntime.registeredWorkerIDs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	events := make(chan WorkerHealthEvent, 16)
+	if !fRuntime.keyspaceEventsEnabled(ctx) {
+		fRuntime.Logger.Log(fmt.Sprintf("[goflow] keyspace notifications %q are not enabled, polling the worker registrations",
+			keyspaceEventFlags))
+		go fRuntime.pollWorkerHealth(ctx, known, events)
+		return events, nil
+	}
+
+	rdb := fRuntime.redisClient()
+	prefix := fmt.Sprintf("__keyspace@%d__:%s:", rdb.Options().DB, WorkerKeyInitial)
+	pubsub := rdb.PSubscribe(ctx, prefix+"*")
+	if _, err := pubsub.Receive(ctx); err != nil {
+		pubsub.Close()
+		return nil, fmt.Errorf("failed to subscribe to worker registrations, error %v", err)
+	}
+	// listed again once subscribed, the workers joining or leaving before the subscription are reported from the
+	// difference, the notifications of the ones joining or leaving after are deduplicated with the listing
+	current, err := fRuntime.registeredWorkerIDs(ctx)
+	if err != nil {
+		pubsub.Close()
+		return nil, err
+	}
+
+	go func() {
+		defer close(events)
+		defer pubsub.Close()
+		if !emitWorkerChanges(ctx, events, known, current, fRuntime.clock().Now()) {
+			return
+		}
+		known = current
+		messages := pubsub.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case message, ok := <-messages:
+				if !ok {
+					return
+				}
+				workerID := strings.TrimPrefix(message.Channel, prefix)
+				event := WorkerHealthEvent{WorkerID: workerID, Timestamp: fRuntime.clock().Now()}
+				// the registrations are refreshed with a set, only the first one is a join
+				switch message.Payload {
+				case "set":
+					if known[workerID] {
+						continue
+					}
+					known[workerID] = true
+					event.EventType = WorkerJoined
+				case "expired", "del":
+					if !known[workerID] {
+						continue
+					}
+					delete(known, workerID)
+					event.EventType = WorkerLeft
+				default:
+					continue
+				}
+				select {
+				case events <- event:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return events, nil
+}
+
+// pollWorkerHealth lists the registrations every GoFlowRegisterInterval and emits the workers joining and leaving
+// in between, until ctx is done
+func (fRuntime *FlowRuntime) pollWorkerHealth(ctx context.Context, known map[string]bool, events chan<- WorkerHealthEvent) {
+	defer close(events)
+	ticker := fRuntime.clock().NewTicker(GoFlowRegisterInterval * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+		}
+		current, err := fRuntime.registeredWorkerIDs(ctx)
+		if err != nil {
+			fRuntime.Logger.Log(fmt.Sprintf("[goflow] failed to poll worker registrations, error %v", err))
+			continue
+		}
+		if !emitWorkerChanges(ctx, events, known, current, fRuntime.clock().Now()) {
+			return
+		}
+		known = current
+	}
+}
+
+// emitWorkerChanges emits the workers of current missing from known as joined and the ones of known missing from
+// current as left at now, it returns false once ctx is done
+func emitWorkerChanges(ctx context.Context, events chan<- WorkerHealthEvent, known map[string]bool,
+	current map[string]bool, now time.Time) bool {
+	var changes []WorkerHealthEvent
+	for workerID := range current {
+		if !known[workerID] {
+			changes = append(changes, WorkerHealthEvent{WorkerID: workerID, EventType: WorkerJoined, Timestamp: now})
+		}
+	}
+	for workerID := range known {
+		if !current[workerID] {
+			changes = append(changes, WorkerHealthEvent{WorkerID: workerID, EventType: WorkerLeft, Timestamp: now})
+		}
+	}
+	for _, event := range changes {
+		select {
+		case events <- event:
+		case <-ctx.Done():
+			return false
+		}
+	}
+	return true
+}
+
+// registeredWorkerIDs returns the ids of the registered workers
+func (fRuntime *FlowRuntime) registeredWorkerIDs(ctx context.Context) (map[string]bool, error) {
+	workers := make(map[string]bool)
+	iter := fRuntime.redisClient().Scan(ctx, 0, WorkerKeyInitial+":*", 0).Iterator()
+	for iter.Next(ctx) {
+		workers[strings.TrimPrefix(iter.Val(), WorkerKeyInitial+":")] = true
+	}
+	if err := iter.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list workers, error %v", err)
+	}
+	return workers, nil
+}
+
+// keyspaceEventsEnabled checks if redis publishes the keyspace notifications needed by WatchWorkerHealth. The
+// notifications are configured by the operator, they are shared by all the clients of redis. A redis restricting
+// CONFIG, e.g. a managed one, is polled
+func (fRuntime *FlowRuntime) keyspaceEventsEnabled(ctx context.Context) bool {
+	config, err := fRuntime.redisClient().ConfigGet(ctx, "notify-keyspace-events").Result()
+	if err != nil {
+		return false
+	}
+	flags := config["notify-keyspace-events"]
+	for _, flag := range keyspaceEventFlags {
+		// A is the alias of all the event types but K and E
+		if !strings.ContainsRune(flags, flag) && (flag == 'K' || !strings.ContainsRune(flags, 'A')) {
+			return false
+		}
+	}
+	return true
+}
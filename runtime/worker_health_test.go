@@ -0,0 +1,59 @@
+package runtime_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/yuyang0/goflow/runtime"
+	"github.com/yuyang0/goflow/runtime/clock/clocktest"
+	"github.com/yuyang0/goflow/types"
+)
+
+func nextWorkerHealthEvent(t *testing.T, events <-chan runtime.WorkerHealthEvent) runtime.WorkerHealthEvent {
+	t.Helper()
+	select {
+	case event := <-events:
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatal("no worker health event")
+		return runtime.WorkerHealthEvent{}
+	}
+}
+
+// TestWatchWorkerHealthPolls checks that the registrations are polled when redis doesn't publish the keyspace
+// notifications, as miniredis
+func TestWatchWorkerHealthPolls(t *testing.T) {
+	mr := miniredis.RunT(t)
+	fakeClock := clocktest.NewFakeClock(time.Now())
+	fRuntime := &runtime.FlowRuntime{RedisCfg: types.RedisConfig{Addr: mr.Addr()}, Clock: fakeClock}
+	if err := fRuntime.Init(); err != nil {
+		t.Fatal(err)
+	}
+	mr.Set(runtime.WorkerKeyInitial+":registered", "{}")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events, err := fRuntime.WatchWorkerHealth(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fakeClock.BlockUntil(1)
+
+	mr.Set(runtime.WorkerKeyInitial+":joining", "{}")
+	fakeClock.Advance(runtime.GoFlowRegisterInterval * time.Second)
+	if event := nextWorkerHealthEvent(t, events); event.WorkerID != "joining" || event.EventType != runtime.WorkerJoined {
+		t.Fatalf("expected the worker joining to be reported as joined, got %+v", event)
+	}
+
+	mr.Del(runtime.WorkerKeyInitial + ":registered")
+	fakeClock.Advance(runtime.GoFlowRegisterInterval * time.Second)
+	if event := nextWorkerHealthEvent(t, events); event.WorkerID != "registered" || event.EventType != runtime.WorkerLeft {
+		t.Fatalf("expected the registered worker to be reported as left, got %+v", event)
+	}
+
+	cancel()
+	for range events {
+	}
+}
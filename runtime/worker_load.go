@@ -0,0 +1,201 @@
+package runtime
+
+import (
+	"context"
+	"fmt"
+	"os"
+	goRuntime "runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adjust/rmq/v5"
+	"github.com/yuyang0/goflow/metrics"
+)
+
+const (
+	DefaultLoadSampleInterval = 5 * time.Second
+	// LoadThrottleRequeueDelay is the delay before a new request deferred by a throttled worker is queued again
+	LoadThrottleRequeueDelay = 5 * time.Second
+
+	// clockTicks is the unit of the cpu times of /proc/self/stat, USER_HZ is 100 on all the supported platforms
+	clockTicks = 100
+)
+
+var (
+	workerCPUGauge = metrics.NewGaugeVec("goflow_worker_cpu_percent",
+		"Share of the cpus of the host used by the worker process", "worker")
+	workerRSSGauge = metrics.NewGaugeVec("goflow_worker_rss_bytes",
+		"Resident memory of the worker process", "worker")
+	workerThrottledGauge = metrics.NewGaugeVec("goflow_worker_throttled",
+		"Set to 1 while the worker doesn't consume new requests as it is overloaded", "worker")
+	throttledTasksCounter = metrics.NewCounterVec("goflow_throttled_tasks_total",
+		"New requests deferred by an overloaded worker to be queued again", "flow")
+)
+
+// LoadThrottle stops the consumption of the new requests while the worker is above a cpu or memory threshold,
+// until it is back under the low water marks. The requests already started are still continued
+type LoadThrottle struct {
+	MaxCPUPercent    float64 // share of the cpus of the host, not checked if zero
+	MaxRSSBytes      uint64  // resident memory of the process, not checked if zero
+	ResumeCPUPercent float64 // 80% of MaxCPUPercent if not set
+	ResumeRSSBytes   uint64  // 80% of MaxRSSBytes if not set
+}
+
+// LoadSample is a reading of the resources used by the worker process
+type LoadSample struct {
+	CPUPercent float64   `json:"cpu_percent"` // over the last sample interval, as a share of the cpus of the host
+	RSSBytes   uint64    `json:"rss_bytes"`
+	Time       time.Time `json:"time"`
+}
+
+// loadSampler computes the cpu usage of the process from the difference of its cpu time between two samples
+type loadSampler struct {
+	mu      sync.Mutex
+	sample  LoadSample
+	cpuTime time.Duration
+}
+
+func validateLoadThrottle(throttle *LoadThrottle) error {
+	if throttle.MaxCPUPercent < 0 || throttle.ResumeCPUPercent < 0 {
+		return fmt.Errorf("invalid load throttle, cpu thresholds must not be negative")
+	}
+	if throttle.ResumeCPUPercent > throttle.MaxCPUPercent || throttle.ResumeRSSBytes > throttle.MaxRSSBytes {
+		return fmt.Errorf("invalid load throttle, the resume thresholds must not exceed the max thresholds")
+	}
+	return nil
+}
+
+func (throttle *LoadThrottle) resumeCPUPercent() float64 {
+	if throttle.ResumeCPUPercent == 0 {
+		return throttle.MaxCPUPercent * 0.8
+	}
+	return throttle.ResumeCPUPercent
+}
+
+func (throttle *LoadThrottle) resumeRSSBytes() uint64 {
+	if throttle.ResumeRSSBytes == 0 {
+		return throttle.MaxRSSBytes / 10 * 8
+	}
+	return throttle.ResumeRSSBytes
+}
+
+// overloaded decides if a worker is to be throttled. A throttled worker is only released once all the
+// readings are under the resume thresholds, so that a reading around a threshold doesn't flap the throttle
+func (throttle *LoadThrottle) overloaded(sample LoadSample, throttled bool) bool {
+	if throttled {
+		return (throttle.MaxCPUPercent > 0 && sample.CPUPercent >= throttle.resumeCPUPercent()) ||
+			(throttle.MaxRSSBytes > 0 && sample.RSSBytes >= throttle.resumeRSSBytes())
+	}
+	return (throttle.MaxCPUPercent > 0 && sample.CPUPercent > throttle.MaxCPUPercent) ||
+		(throttle.MaxRSSBytes > 0 && sample.RSSBytes > throttle.MaxRSSBytes)
+}
+
+func (fRuntime *FlowRuntime) loadSampleInterval() time.Duration {
+	if fRuntime.LoadSampleInterval < time.Second {
+		return DefaultLoadSampleInterval
+	}
+	return fRuntime.LoadSampleInterval
+}
+
+// LoadSample returns the latest reading of the resources used by the worker
+func (fRuntime *FlowRuntime) LoadSample() LoadSample {
+	fRuntime.loadSampler.mu.Lock()
+	defer fRuntime.loadSampler.mu.Unlock()
+	return fRuntime.loadSampler.sample
+}
+
+// IsThrottled checks if the worker stopped consuming new requests as it is overloaded
+func (fRuntime *FlowRuntime) IsThrottled() bool {
+	return fRuntime.throttled.Load()
+}
+
+// sampleLoad reads the resources used by the worker and updates the throttle
+func (fRuntime *FlowRuntime) sampleLoad() {
+	sampler := &fRuntime.loadSampler
+	now := fRuntime.clock().Now()
+	cpuTime, rss := readProcessUsage()
+
+	sampler.mu.Lock()
+	sample := LoadSample{RSSBytes: rss, Time: now}
+	if !sampler.sample.Time.IsZero() && cpuTime > 0 {
+		elapsed := now.Sub(sampler.sample.Time) * time.Duration(goRuntime.NumCPU())
+		sample.CPUPercent = float64(cpuTime-sampler.cpuTime) / float64(elapsed) * 100
+	}
+	sampler.sample = sample
+	sampler.cpuTime = cpuTime
+	sampler.mu.Unlock()
+
+	workerID := fRuntime.WorkerID()
+	workerCPUGauge.Set(sample.CPUPercent, workerID)
+	workerRSSGauge.Set(float64(sample.RSSBytes), workerID)
+
+	if fRuntime.LoadThrottle == nil {
+		return
+	}
+	throttled := fRuntime.throttled.Load()
+	overloaded := fRuntime.LoadThrottle.overloaded(sample, throttled)
+	if overloaded == throttled {
+		return
+	}
+	fRuntime.throttled.Store(overloaded)
+	if overloaded {
+		workerThrottledGauge.Set(1, workerID)
+		fRuntime.Logger.Log(fmt.Sprintf("[goflow] worker overloaded, cpu %.1f%% rss %d bytes, new requests are deferred",
+			sample.CPUPercent, sample.RSSBytes))
+	} else {
+		workerThrottledGauge.Set(0, workerID)
+		fRuntime.Logger.Log(fmt.Sprintf("[goflow] worker load recovered, cpu %.1f%% rss %d bytes, new requests are consumed",
+			sample.CPUPercent, sample.RSSBytes))
+	}
+}
+
+// deferThrottledTask hands a new request consumed by a throttled worker over to the scheduler, which queues it
+// again after LoadThrottleRequeueDelay, and acks its delivery rather than holding it. It returns whether the task
+// was deferred, a task that can't be deferred is consumed
+func (fRuntime *FlowRuntime) deferThrottledTask(message rmq.Delivery, task *Task) bool {
+	if !fRuntime.throttled.Load() {
+		return false
+	}
+	deferred := *task
+	deferred.Requeued = true
+	at := fRuntime.clock().Now().Add(LoadThrottleRequeueDelay)
+	if err := fRuntime.scheduleTask(context.TODO(), &deferred, at); err != nil {
+		fRuntime.Logger.Log(fmt.Sprintf("[request `%s`] failed to defer task of throttled worker, error %v",
+			task.RequestID, err))
+		return false
+	}
+	throttledTasksCounter.Inc(task.FlowName)
+	if err := message.Ack(); err != nil {
+		fRuntime.handleQueueError(QueueOperationAck, task, err)
+	}
+	return true
+}
+
+// readProcessUsage returns the cpu time and the resident memory of the process from /proc, zero cpu time and the
+// memory obtained from the go runtime where /proc is not available
+func readProcessUsage() (time.Duration, uint64) {
+	var cpuTime time.Duration
+	if data, err := os.ReadFile("/proc/self/stat"); err == nil {
+		// the fields following the command name, which may contain spaces, start with the state
+		stat := string(data)
+		fields := strings.Fields(stat[strings.LastIndexByte(stat, ')')+1:])
+		if len(fields) > 12 {
+			utime, _ := strconv.ParseInt(fields[11], 10, 64)
+			stime, _ := strconv.ParseInt(fields[12], 10, 64)
+			cpuTime = time.Duration(utime+stime) * time.Second / clockTicks
+		}
+	}
+
+	if data, err := os.ReadFile("/proc/self/statm"); err == nil {
+		fields := strings.Fields(string(data))
+		if len(fields) > 1 {
+			pages, _ := strconv.ParseUint(fields[1], 10, 64)
+			return cpuTime, pages * uint64(os.Getpagesize())
+		}
+	}
+	memStats := &goRuntime.MemStats{}
+	goRuntime.ReadMemStats(memStats)
+	return cpuTime, memStats.Sys
+}
@@ -0,0 +1,138 @@
+package runtime_test
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/alphadose/haxmap"
+	runtimepkg "github.com/yuyang0/goflow/core/runtime"
+	flow "github.com/yuyang0/goflow/flow/v1"
+	"github.com/yuyang0/goflow/runtime"
+	"github.com/yuyang0/goflow/types"
+)
+
+func echoFlow(wf *flow.Workflow, _ *flow.Context) error {
+	wf.Dag().Node("echo", func(data []byte, _ map[string][]string) ([]byte, error) {
+		return data, nil
+	})
+	return nil
+}
+
+// hammer runs call concurrently for every index and fails on the first error
+func hammer(t *testing.T, count int, calls ...func(idx int) error) {
+	t.Helper()
+	var wg sync.WaitGroup
+	errs := make(chan error, count*len(calls))
+	for idx := 0; idx < count; idx++ {
+		for _, call := range calls {
+			idx, call := idx, call
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				errs <- call(idx)
+			}()
+		}
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// TestWorkerModeTransitionsKeepConsumerCount checks that entering and leaving the worker mode while flows are
+// registered concurrently never registers the consumers of a flow twice
+func TestWorkerModeTransitionsKeepConsumerCount(t *testing.T) {
+	mr := miniredis.RunT(t)
+	fRuntime := &runtime.FlowRuntime{
+		Flows:       haxmap.New[string, runtime.FlowDefinitionHandler](),
+		RedisCfg:    types.RedisConfig{Addr: mr.Addr()},
+		Concurrency: 2,
+	}
+	if err := fRuntime.Init(); err != nil {
+		t.Fatal(err)
+	}
+	register := func(prefix string) func(idx int) error {
+		return func(idx int) error {
+			return fRuntime.Register(map[string]runtime.FlowDefinitionHandler{fmt.Sprintf("%s-%d", prefix, idx): echoFlow})
+		}
+	}
+	consumers := func(flowName string) int64 {
+		t.Helper()
+		depths, err := fRuntime.GetQueueDepths(flowName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return depths[runtime.InternalRequestQueueInitial+":"+flowName].Consumers
+	}
+
+	enter := func(int) error { return fRuntime.EnterWorkerMode() }
+	hammer(t, 8, enter, register("entering"))
+	for idx := 0; idx < 8; idx++ {
+		flowName := fmt.Sprintf("entering-%d", idx)
+		if count := consumers(flowName); count != int64(fRuntime.Concurrency) {
+			t.Fatalf("expected %d consumers of flow %s, got %d", fRuntime.Concurrency, flowName, count)
+		}
+	}
+
+	// the flows registered once the worker mode is left are not consumed
+	exit := func(int) error { return fRuntime.ExitWorkerMode() }
+	hammer(t, 8, exit, register("exiting"))
+	if err := fRuntime.ExitWorkerMode(); err != nil {
+		t.Fatal(err)
+	}
+	if count := fRuntime.FlowCount(); count != 16 {
+		t.Fatalf("expected the 16 flows to be registered, got %d", count)
+	}
+	for idx := 0; idx < 8; idx++ {
+		flowName := fmt.Sprintf("exiting-%d", idx)
+		if count := consumers(flowName); count > int64(fRuntime.Concurrency) {
+			t.Fatalf("expected at most %d consumers of flow %s, got %d", fRuntime.Concurrency, flowName, count)
+		}
+	}
+}
+
+// TestPartialRequestsEnqueuedWhileQueuesChange checks that the continuations of the requests of a flow are enqueued
+// while flows are registered and the flow itself is shut down, replacing the queues of the runtime
+func TestPartialRequestsEnqueuedWhileQueuesChange(t *testing.T) {
+	mr := miniredis.RunT(t)
+	fRuntime := &runtime.FlowRuntime{
+		Flows:          haxmap.New[string, runtime.FlowDefinitionHandler](),
+		RedisCfg:       types.RedisConfig{Addr: mr.Addr()},
+		Concurrency:    2,
+		PartitionCount: 2,
+	}
+	if err := fRuntime.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if err := fRuntime.EnterWorkerMode(); err != nil {
+		t.Fatal(err)
+	}
+	defer fRuntime.ExitWorkerMode()
+	if err := fRuntime.Register(map[string]runtime.FlowDefinitionHandler{"partial": echoFlow}); err != nil {
+		t.Fatal(err)
+	}
+	enqueue := func(idx int) error {
+		return fRuntime.EnqueuePartialRequest(&runtimepkg.Request{
+			FlowName:     "partial",
+			RequestID:    fmt.Sprintf("request-%d", idx),
+			Body:         []byte("{}"),
+			PartitionKey: fmt.Sprintf("key-%d", idx),
+		})
+	}
+	register := func(idx int) error {
+		return fRuntime.Register(map[string]runtime.FlowDefinitionHandler{fmt.Sprintf("other-%d", idx): echoFlow})
+	}
+	shutdown := func(idx int) error {
+		if idx != 0 {
+			return nil
+		}
+		return fRuntime.ShutdownFlow(context.Background(), "partial")
+	}
+	hammer(t, 32, enqueue, register, shutdown)
+}
@@ -0,0 +1,48 @@
+package runtime_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/yuyang0/goflow/runtime"
+	"github.com/yuyang0/goflow/types"
+	goflow "github.com/yuyang0/goflow/v1"
+)
+
+// startWorker registers the flows on a worker consuming from a fresh redis and starts it, it returns the redis
+// and a client FlowService for the requests
+func startWorker(t *testing.T, fs *goflow.FlowService, flows map[string]runtime.FlowDefinitionHandler,
+	options map[string]runtime.FlowOptions) (*miniredis.Miniredis, *goflow.FlowService) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	fs.RedisCfg = types.RedisConfig{Addr: mr.Addr()}
+	if fs.WorkerConcurrency == 0 {
+		fs.WorkerConcurrency = 2
+	}
+	if fs.CleanerInterval == 0 {
+		fs.CleanerInterval = time.Hour
+	}
+	for flowName, handler := range flows {
+		if err := fs.RegisterWithOptions(flowName, handler, options[flowName]); err != nil {
+			t.Fatalf("failed to register flow %s, %v", flowName, err)
+		}
+	}
+	go fs.StartWorker()
+
+	client := &goflow.FlowService{RedisCfg: fs.RedisCfg, PartitionCount: fs.PartitionCount}
+	return mr, client
+}
+
+// eventually polls cond until it holds or the timeout elapses
+func eventually(t *testing.T, timeout time.Duration, cond func() bool, msg string) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatal(msg)
+}
@@ -0,0 +1,87 @@
+package types
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/yuyang0/goflow/metrics"
+)
+
+const (
+	ReadTargetPrimary  = "primary"
+	ReadTargetReplica  = "replica"
+	ReadTargetFallback = "fallback" // the primary, as the replicas failed
+
+	// ReplicaRetryInterval is how long the reads skip a replica that failed, going to the next replica or the
+	// primary right away
+	ReplicaRetryInterval = 10 * time.Second
+)
+
+var staleReadsCounter = metrics.NewCounterVec("goflow_redis_stale_reads_total",
+	"Reads tolerating staleness by the redis instance they were served by", "store", "target")
+
+// ReadRouter routes the reads tolerating staleness to the read replicas in turn, falling back to
+// the primary when the replicas fail. The other reads and the writes must use the primary
+type ReadRouter struct {
+	store     string // the name of the store in the metrics
+	primary   redis.UniversalClient
+	replicas  []*redis.Client
+	downUntil []atomic.Int64 // unix nano time until which each replica is skipped after a failure
+	next      atomic.Uint32
+}
+
+// NewReadRouter returns a router of the reads of a store to the replicas of the config
+func (cfg *RedisConfig) NewReadRouter(store string, primary redis.UniversalClient) *ReadRouter {
+	replicas := cfg.NewReadRedisClients()
+	return &ReadRouter{store: store, primary: primary, replicas: replicas, downUntil: make([]atomic.Int64, len(replicas))}
+}
+
+// Read runs a read on a replica, then on the next replicas and finally on the primary while it fails.
+// A missing key is not a failure, the key may be missing on the primary as well. A replica that failed
+// is skipped for ReplicaRetryInterval, so that the reads don't wait for it to time out again
+func (router *ReadRouter) Read(read func(client redis.UniversalClient) error) error {
+	if len(router.replicas) == 0 {
+		staleReadsCounter.Inc(router.store, ReadTargetPrimary)
+		return read(router.primary)
+	}
+
+	start := int(router.next.Add(1))
+	for idx := 0; idx < len(router.replicas); idx++ {
+		replica := (start + idx) % len(router.replicas)
+		if time.Now().UnixNano() < router.downUntil[replica].Load() {
+			continue
+		}
+		err := read(router.replicas[replica])
+		if err == nil || err == redis.Nil {
+			staleReadsCounter.Inc(router.store, ReadTargetReplica)
+			return err
+		}
+		router.downUntil[replica].Store(time.Now().Add(ReplicaRetryInterval).UnixNano())
+	}
+	staleReadsCounter.Inc(router.store, ReadTargetFallback)
+	return read(router.primary)
+}
+
+// Ping checks the connection to every replica
+func (router *ReadRouter) Ping(ctx context.Context) error {
+	for _, replica := range router.replicas {
+		if err := replica.Ping(ctx).Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close closes the clients of the replicas, the primary is left to its owner
+func (router *ReadRouter) Close() error {
+	var errs []error
+	for _, replica := range router.replicas {
+		if err := replica.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
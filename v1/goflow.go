@@ -1,40 +1,115 @@
 package v1
 
 import (
+	"context"
 	"fmt"
 	"time"
 
 	"github.com/alphadose/haxmap"
 	runtimePkg "github.com/yuyang0/goflow/core/runtime"
 	"github.com/yuyang0/goflow/core/sdk"
+	"github.com/yuyang0/goflow/core/sdk/executor"
+	"github.com/yuyang0/goflow/dag"
+	"github.com/yuyang0/goflow/eventhandler"
+	log2 "github.com/yuyang0/goflow/log"
 	"github.com/yuyang0/goflow/runtime"
+	"github.com/yuyang0/goflow/runtime/clock"
 	"github.com/yuyang0/goflow/types"
 )
 
 type FlowService struct {
 	Port                    int
+	Namespace               string
+	NormalizeFlowNames      bool
+	AllowLegacyFlowNames    bool
 	RedisCfg                types.RedisConfig
+	AMQP                    *types.AMQPConfig // broker the results of the flows with an AMQP output are published to
 	RequestAuthSharedSecret string
 	RequestAuthEnabled      bool
+	Authorizer              runtime.Authorizer
+	JWT                     *runtime.JWTConfig
+	ClientRateLimits        runtime.ClientRateLimits            // default rate limits of the http requests of a client
+	RateLimitOverrides      map[string]runtime.ClientRateLimits // rate limits keyed by client, e.g. `jwt:alice` or `ip:10.0.0.1`
+	SamplingRates           map[string]float64                  // share of the requests of each flow captured for debugging
 	WorkerConcurrency       int
+	FailureConcurrency      int
+	MaxGoroutinesPerWorker  int
+	SaturationWarnThreshold time.Duration
+	LoadSampleInterval      time.Duration
+	LoadThrottle            *runtime.LoadThrottle // stop consuming new requests while the worker is overloaded
 	RetryCount              int
+	MaxFlows                int
+	PauseConflictingFlows   bool
+	CleanerInterval         time.Duration
+	UnroutableGracePeriod   time.Duration
+	DeadLetterRouting       *runtime.DeadLetterRouting // dead letter the tasks failing their last retry by error category
+	ArchiveProvider         runtime.ArchiveProvider    // archives the flow definitions replaced by a new version
+	MaxContinuations        int
+	GlobalTimeout           time.Duration
+	SlowNodeThreshold       time.Duration
+	SlowStoreThreshold      time.Duration
+	SlowLogEnabled          bool
+	RequestLogsEnabled      bool
+	RequestLogMaxEntries    int
+	RequestLogTTL           time.Duration
+	StreamingEnabled        bool // stream the node outputs of the requests as server-sent events
+	RoutingLogsEnabled      bool // record the queues every request goes through
+	ErrorBudgetInterval     time.Duration
+	AlertWebhookURL         string
+	PoisonThreshold         int // crashes of the consumer by a message before it is routed to the poison queue
+	DurableTasksEnabled     bool
+	ClaimTTL                time.Duration
+	BodyStoreThreshold      int
+	StoreBinaryBodies       bool          // store the binary request bodies in the DataStore rather than base64 encoded in the task
+	InitTimeout             time.Duration // bound of the connection checks on start
+	InitRetryDuration       time.Duration // retry to connect with backoff for this long on start, e.g. while redis starts
+	RequireJSONBody         bool
+	PartitionCount          int
+	StrongConsistency       bool
+	StateStoreRetryCount    int
+	StateStoreRetryBackoff  time.Duration
+	StoreKeyCountsEnabled   bool // count the keys of the stores of each flow in the goflow_store_keys gauge
+	StoreKeyCountInterval   time.Duration
+	FallbackRedisCfg        *types.RedisConfig // the stores fall back to this redis while RedisCfg is unavailable
+	FallbackWritePolicy     string             // runtime.FallbackWritePrimary or runtime.FallbackWriteMirror
 	Flows                   map[string]runtime.FlowDefinitionHandler
 	RequestReadTimeout      time.Duration
+	MaxRequestTimeout       time.Duration
+	DropExpiredRequests     bool
 	RequestWriteTimeout     time.Duration
+	SyncWriteMode           string
+	SyncWriteTimeout        time.Duration
+	SyncHeartbeatInterval   time.Duration
 	OpenTraceUrl            string
 	DataStore               sdk.DataStore
+	LargeOutputStore        runtime.LargeOutputStore // stores the node outputs exceeding the MaxNodeOutputBytes of their flow
+	DataStoreBucketTemplate string
+	Tenant                  string
 	Logger                  sdk.Logger
 	EnableMonitoring        bool
+	EventSinks              []sdk.EventHandler         // receive the lifecycle events of the requests along with the tracer
+	KafkaSinks              []eventhandler.KafkaConfig // write the lifecycle events of the requests to kafka topics
+	AdminUIEnabled          bool
 	DebugEnabled            bool
+	DebugSampleRate         float64 // share of the requests, picked by the hash of their id, logged in full without DebugEnabled
+	OnQueueError            func(operation string, task *runtime.Task, err error)
+	OnErrorBudgetAlert      func(alert *runtime.ErrorBudgetAlert)
+	OnPoisonMessage         func(message *runtime.PoisonMessage)
+	PreExecHooks            []runtime.PreExecHook          // run on every new request before its execution, in order
+	BodyDecoders            map[string]runtime.BodyDecoder // request body decoders keyed by content type
+	UnsafeFaultInjector     *runtime.FaultInjector
+	Clock                   clock.Clock // time source of the schedules, deadlines and watchdogs, the real clock if nil
 
 	runtime *runtime.FlowRuntime
 }
 
 type Request struct {
-	Body      []byte
-	RequestId string
-	Query     map[string][]string
-	Header    map[string][]string
+	Body         []byte
+	RequestId    string
+	Query        map[string][]string
+	Header       map[string][]string
+	PartitionKey string
+	Deadline     time.Time // the request is abandoned if not completed by then, zero for no deadline
 }
 
 const (
@@ -53,21 +128,189 @@ func (fs *FlowService) Execute(flowName string, req *Request) error {
 
 	fs.ConfigureDefault()
 	fs.runtime = &runtime.FlowRuntime{
+		Namespace:               fs.Namespace,
+		NormalizeFlowNames:      fs.NormalizeFlowNames,
+		AllowLegacyFlowNames:    fs.AllowLegacyFlowNames,
 		RedisCfg:                fs.RedisCfg,
 		RequestAuthEnabled:      fs.RequestAuthEnabled,
 		RequestAuthSharedSecret: fs.RequestAuthSharedSecret,
+		DurableTasksEnabled:     fs.DurableTasksEnabled,
+		BodyStoreThreshold:      fs.BodyStoreThreshold,
+		StoreBinaryBodies:       fs.StoreBinaryBodies,
+		RoutingLogsEnabled:      fs.RoutingLogsEnabled,
+		RequireJSONBody:         fs.RequireJSONBody,
+		PartitionCount:          fs.PartitionCount,
+		Clock:                   fs.Clock,
+		StoreKeyCountsEnabled:   fs.StoreKeyCountsEnabled,
+		DataStore:               fs.DataStore,
+		DataStoreBucketTemplate: fs.DataStoreBucketTemplate,
+		Tenant:                  fs.Tenant,
+	}
+	for flowName, rate := range fs.SamplingRates {
+		fs.runtime.SetSamplingRate(flowName, rate)
+	}
+	if err := fs.registerBodyDecoders(); err != nil {
+		return err
 	}
 
 	request := &runtimePkg.Request{
-		Header:    req.Header,
-		RequestID: req.RequestId,
-		Body:      req.Body,
-		Query:     req.Query,
+		Header:       req.Header,
+		RequestID:    req.RequestId,
+		Body:         req.Body,
+		Query:        req.Query,
+		PartitionKey: req.PartitionKey,
+		Deadline:     req.Deadline,
 	}
 
 	err := fs.runtime.Execute(flowName, request)
 	if err != nil {
-		return fmt.Errorf("failed to execute request, %v", err)
+		return fmt.Errorf("failed to execute request, %w", err)
+	}
+
+	return nil
+}
+
+// ExecuteAt schedules a request of the flow to be executed at the given time, the id of the
+// request is generated if not provided and can be used to cancel it with CancelScheduled
+func (fs *FlowService) ExecuteAt(flowName string, req *Request, at time.Time) error {
+	if flowName == "" {
+		return fmt.Errorf("flowName must be provided to execute flow")
+	}
+
+	if fs.runtime == nil {
+		fs.ConfigureDefault()
+		fs.runtime = &runtime.FlowRuntime{
+			Namespace:            fs.Namespace,
+			NormalizeFlowNames:   fs.NormalizeFlowNames,
+			AllowLegacyFlowNames: fs.AllowLegacyFlowNames,
+			RedisCfg:             fs.RedisCfg,
+			RequireJSONBody:      fs.RequireJSONBody,
+		}
+		if err := fs.registerBodyDecoders(); err != nil {
+			return err
+		}
+	}
+
+	request := &runtimePkg.Request{
+		Header:       req.Header,
+		RequestID:    req.RequestId,
+		Body:         req.Body,
+		Query:        req.Query,
+		PartitionKey: req.PartitionKey,
+		Deadline:     req.Deadline,
+	}
+
+	err := fs.runtime.ExecuteAt(flowName, request, at)
+	if err != nil {
+		return fmt.Errorf("failed to schedule request, %w", err)
+	}
+	req.RequestId = request.RequestID
+
+	return nil
+}
+
+// Cancel cancels a request queued with Execute before a worker starts it
+func (fs *FlowService) Cancel(flowName string, requestId string) error {
+	if flowName == "" {
+		return fmt.Errorf("flowName must be provided to cancel request")
+	}
+	if requestId == "" {
+		return fmt.Errorf("request Id must be provided")
+	}
+
+	if fs.runtime == nil {
+		fs.ConfigureDefault()
+		// the cancellation of a request removed from its queue is logged
+		logger := fs.Logger
+		if logger == nil {
+			logger = &log2.StdErrLogger{}
+		}
+		fs.runtime = &runtime.FlowRuntime{
+			Namespace:               fs.Namespace,
+			NormalizeFlowNames:      fs.NormalizeFlowNames,
+			AllowLegacyFlowNames:    fs.AllowLegacyFlowNames,
+			RedisCfg:                fs.RedisCfg,
+			PartitionCount:          fs.PartitionCount,
+			DataStore:               fs.DataStore,
+			DataStoreBucketTemplate: fs.DataStoreBucketTemplate,
+			Tenant:                  fs.Tenant,
+			Logger:                  logger,
+		}
+	}
+
+	err := fs.runtime.Cancel(flowName, requestId)
+	if err != nil {
+		return fmt.Errorf("failed to cancel request, %w", err)
+	}
+
+	return nil
+}
+
+// CancelScheduled cancels a request scheduled with ExecuteAt before it is executed
+func (fs *FlowService) CancelScheduled(requestId string) error {
+	if requestId == "" {
+		return fmt.Errorf("request Id must be provided")
+	}
+
+	if fs.runtime == nil {
+		fs.ConfigureDefault()
+		fs.runtime = &runtime.FlowRuntime{
+			Namespace: fs.Namespace,
+			RedisCfg:  fs.RedisCfg,
+		}
+	}
+
+	err := fs.runtime.CancelScheduled(requestId)
+	if err != nil {
+		return fmt.Errorf("failed to cancel scheduled request, %v", err)
+	}
+
+	return nil
+}
+
+// ExecuteSeries schedules a series of requests of the flow with the same body, every interval or following a
+// cron expression. The requests have the ids <seriesId>-<n> and the series can be cancelled with CancelSeries
+func (fs *FlowService) ExecuteSeries(flowName string, body []byte, spec runtime.SeriesSpec) (string, error) {
+	if flowName == "" {
+		return "", fmt.Errorf("flowName must be provided to execute flow")
+	}
+
+	if fs.runtime == nil {
+		fs.ConfigureDefault()
+		fs.runtime = &runtime.FlowRuntime{
+			Namespace:            fs.Namespace,
+			NormalizeFlowNames:   fs.NormalizeFlowNames,
+			AllowLegacyFlowNames: fs.AllowLegacyFlowNames,
+			RedisCfg:             fs.RedisCfg,
+			RequireJSONBody:      fs.RequireJSONBody,
+		}
+	}
+
+	seriesId, err := fs.runtime.ExecuteSeries(flowName, body, spec)
+	if err != nil {
+		return "", fmt.Errorf("failed to schedule series, %w", err)
+	}
+
+	return seriesId, nil
+}
+
+// CancelSeries cancels the requests of a series scheduled with ExecuteSeries that are not executed yet
+func (fs *FlowService) CancelSeries(seriesId string) error {
+	if seriesId == "" {
+		return fmt.Errorf("series Id must be provided")
+	}
+
+	if fs.runtime == nil {
+		fs.ConfigureDefault()
+		fs.runtime = &runtime.FlowRuntime{
+			Namespace: fs.Namespace,
+			RedisCfg:  fs.RedisCfg,
+		}
+	}
+
+	err := fs.runtime.CancelSeries(seriesId)
+	if err != nil {
+		return fmt.Errorf("failed to cancel series, %v", err)
 	}
 
 	return nil
@@ -84,6 +327,9 @@ func (fs *FlowService) Pause(flowName string, requestId string) error {
 
 	fs.ConfigureDefault()
 	fs.runtime = &runtime.FlowRuntime{
+		Namespace:               fs.Namespace,
+		NormalizeFlowNames:      fs.NormalizeFlowNames,
+		AllowLegacyFlowNames:    fs.AllowLegacyFlowNames,
 		RedisCfg:                fs.RedisCfg,
 		RequestAuthEnabled:      fs.RequestAuthEnabled,
 		RequestAuthSharedSecret: fs.RequestAuthSharedSecret,
@@ -112,6 +358,9 @@ func (fs *FlowService) Resume(flowName string, requestId string) error {
 
 	fs.ConfigureDefault()
 	fs.runtime = &runtime.FlowRuntime{
+		Namespace:               fs.Namespace,
+		NormalizeFlowNames:      fs.NormalizeFlowNames,
+		AllowLegacyFlowNames:    fs.AllowLegacyFlowNames,
 		RedisCfg:                fs.RedisCfg,
 		RequestAuthEnabled:      fs.RequestAuthEnabled,
 		RequestAuthSharedSecret: fs.RequestAuthSharedSecret,
@@ -140,6 +389,9 @@ func (fs *FlowService) Stop(flowName string, requestId string) error {
 
 	fs.ConfigureDefault()
 	fs.runtime = &runtime.FlowRuntime{
+		Namespace:               fs.Namespace,
+		NormalizeFlowNames:      fs.NormalizeFlowNames,
+		AllowLegacyFlowNames:    fs.AllowLegacyFlowNames,
 		RedisCfg:                fs.RedisCfg,
 		RequestAuthEnabled:      fs.RequestAuthEnabled,
 		RequestAuthSharedSecret: fs.RequestAuthSharedSecret,
@@ -157,7 +409,38 @@ func (fs *FlowService) Stop(flowName string, requestId string) error {
 	return nil
 }
 
+// InterruptNode interrupts the execution of a single node of a request
+func (fs *FlowService) InterruptNode(requestId string, nodeId string) error {
+	if requestId == "" {
+		return fmt.Errorf("request Id must be provided")
+	}
+
+	if nodeId == "" {
+		return fmt.Errorf("node Id must be provided")
+	}
+
+	if fs.runtime == nil {
+		fs.ConfigureDefault()
+		fs.runtime = &runtime.FlowRuntime{
+			Namespace: fs.Namespace,
+			RedisCfg:  fs.RedisCfg,
+		}
+	}
+
+	err := fs.runtime.InterruptNode(requestId, nodeId)
+	if err != nil {
+		return fmt.Errorf("failed to interrupt node, %v", err)
+	}
+
+	return nil
+}
+
 func (fs *FlowService) Register(flowName string, handler runtime.FlowDefinitionHandler) error {
+	return fs.RegisterWithOptions(flowName, handler, runtime.FlowOptions{})
+}
+
+// RegisterWithOptions registers a flow along with its options, such as the input schema
+func (fs *FlowService) RegisterWithOptions(flowName string, handler runtime.FlowDefinitionHandler, options runtime.FlowOptions) error {
 	if flowName == "" {
 		return fmt.Errorf("flow-name must not be empty")
 	}
@@ -177,6 +460,7 @@ func (fs *FlowService) Register(flowName string, handler runtime.FlowDefinitionH
 
 	errorChan := make(chan error)
 	if err := fs.initRuntime(errorChan); err != nil {
+		delete(fs.Flows, flowName)
 		return err
 	}
 	go func() {
@@ -185,14 +469,80 @@ func (fs *FlowService) Register(flowName string, handler runtime.FlowDefinitionH
 		fs.Logger.Log("runtime has stopped, error: " + err.Error())
 	}()
 
-	err := fs.runtime.Register(map[string]runtime.FlowDefinitionHandler{flowName: handler})
+	err := fs.runtime.RegisterWithOptions(flowName, handler, options)
 	if err != nil {
+		delete(fs.Flows, flowName)
 		return err
 	}
 
 	return nil
 }
 
+// UpdateFlowOptions changes the options of a registered flow while the service is running
+func (fs *FlowService) UpdateFlowOptions(flowName string, options runtime.FlowOptions) error {
+	if fs.runtime == nil || fs.Flows[flowName] == nil {
+		return fmt.Errorf("flow %s is not registered", flowName)
+	}
+	return fs.runtime.UpdateFlowOptions(flowName, options)
+}
+
+// PushConfigUpdate changes the options of a flow on all the workers, including the ones started later
+func (fs *FlowService) PushConfigUpdate(ctx context.Context, flowName string, options runtime.FlowOptions) error {
+	if fs.runtime == nil {
+		fs.ConfigureDefault()
+		fs.runtime = &runtime.FlowRuntime{
+			Namespace:            fs.Namespace,
+			NormalizeFlowNames:   fs.NormalizeFlowNames,
+			AllowLegacyFlowNames: fs.AllowLegacyFlowNames,
+			RedisCfg:             fs.RedisCfg,
+		}
+	}
+	return fs.runtime.PushConfigUpdate(ctx, flowName, options)
+}
+
+// RefreshFlowDefinition writes the definition of a registered flow to redis right away
+func (fs *FlowService) RefreshFlowDefinition(ctx context.Context, flowName string) error {
+	if fs.runtime == nil || fs.Flows[flowName] == nil {
+		return fmt.Errorf("flow %s is not registered", flowName)
+	}
+	return fs.runtime.RefreshFlowDefinition(ctx, flowName)
+}
+
+// ShutdownFlow stops the flow on this service without affecting the other flows, see FlowRuntime.ShutdownFlow
+func (fs *FlowService) ShutdownFlow(ctx context.Context, flowName string) error {
+	if fs.runtime == nil || fs.Flows[flowName] == nil {
+		return fmt.Errorf("flow %s is not registered", flowName)
+	}
+	err := fs.runtime.ShutdownFlow(ctx, flowName)
+	delete(fs.Flows, flowName)
+	return err
+}
+
+// GetFlowDAG returns the definition of a flow as a typed DAG, the service must be started
+func (fs *FlowService) GetFlowDAG(flowName string) (*dag.DAG, error) {
+	if fs.runtime == nil {
+		return nil, fmt.Errorf("runtime is not initialized")
+	}
+	return fs.runtime.GetFlowDAG(flowName)
+}
+
+// DiagnoseFlow runs the self-test of a registered flow, see FlowRuntime.DiagnoseFlow. The service must be started
+func (fs *FlowService) DiagnoseFlow(ctx context.Context, flowName string) (*runtime.DiagnosticReport, error) {
+	if fs.runtime == nil {
+		return nil, fmt.Errorf("runtime is not initialized")
+	}
+	return fs.runtime.DiagnoseFlow(ctx, flowName)
+}
+
+// CancelBranches cancels the branches of the foreach nodes of a request, see FlowRuntime.CancelBranches. The flow
+// must be registered with CancelableBranches and the service started
+func (fs *FlowService) CancelBranches(flowName string, requestId string, selector executor.BranchSelector) ([]*executor.CanceledBranch, error) {
+	if fs.runtime == nil || fs.Flows[flowName] == nil {
+		return nil, fmt.Errorf("flow %s is not registered", flowName)
+	}
+	return fs.runtime.CancelBranches(flowName, requestId, selector)
+}
+
 func (fs *FlowService) Start() error {
 	fs.ConfigureDefault()
 
@@ -205,6 +555,9 @@ func (fs *FlowService) Start() error {
 	if err := fs.setWorkerMode(true); err != nil {
 		return err
 	}
+	if err := fs.runtime.Warmup(context.Background()); err != nil {
+		return err
+	}
 
 	go fs.server(errorChan)
 	err := <-errorChan
@@ -223,6 +576,9 @@ func (fs *FlowService) StartServer() error {
 	if err := fs.setWorkerMode(false); err != nil {
 		return err
 	}
+	if err := fs.runtime.Warmup(context.Background()); err != nil {
+		return err
+	}
 
 	go fs.server(errorChan)
 	err := <-errorChan
@@ -241,8 +597,11 @@ func (fs *FlowService) StartWorker() error {
 	if err := fs.setWorkerMode(true); err != nil {
 		return err
 	}
+	if err := fs.runtime.Warmup(context.Background()); err != nil {
+		return err
+	}
 
-	go fs.runtimeWorker(errorChan)
+	// the runtime is started by initRuntime
 	err := <-errorChan
 	return fmt.Errorf("worker has stopped, error: %v", err)
 }
@@ -277,22 +636,99 @@ func (fs *FlowService) initRuntime(errorChan chan error) error {
 
 	fs.runtime = &runtime.FlowRuntime{
 		Flows:                   haxmap.New[string, runtime.FlowDefinitionHandler](),
+		Namespace:               fs.Namespace,
+		NormalizeFlowNames:      fs.NormalizeFlowNames,
+		AllowLegacyFlowNames:    fs.AllowLegacyFlowNames,
 		OpenTracingUrl:          fs.OpenTraceUrl,
 		RedisCfg:                fs.RedisCfg,
+		AMQP:                    fs.AMQP,
 		DataStore:               fs.DataStore,
+		LargeOutputStore:        fs.LargeOutputStore,
+		DataStoreBucketTemplate: fs.DataStoreBucketTemplate,
+		Tenant:                  fs.Tenant,
 		Logger:                  fs.Logger,
 		ServerPort:              fs.Port,
 		ReadTimeout:             fs.RequestReadTimeout,
+		MaxRequestTimeout:       fs.MaxRequestTimeout,
+		DropExpiredRequests:     fs.DropExpiredRequests,
 		WriteTimeout:            fs.RequestWriteTimeout,
+		SyncWriteMode:           fs.SyncWriteMode,
+		SyncWriteTimeout:        fs.SyncWriteTimeout,
+		SyncHeartbeatInterval:   fs.SyncHeartbeatInterval,
 		Concurrency:             fs.WorkerConcurrency,
+		FailureConcurrency:      fs.FailureConcurrency,
+		MaxGoroutinesPerWorker:  fs.MaxGoroutinesPerWorker,
+		SaturationWarnThreshold: fs.SaturationWarnThreshold,
+		LoadSampleInterval:      fs.LoadSampleInterval,
+		LoadThrottle:            fs.LoadThrottle,
 		RequestAuthSharedSecret: fs.RequestAuthSharedSecret,
 		RequestAuthEnabled:      fs.RequestAuthEnabled,
+		Authorizer:              fs.Authorizer,
+		JWT:                     fs.JWT,
+		DefaultClientRateLimits: fs.ClientRateLimits,
 		EnableMonitoring:        fs.EnableMonitoring,
+		EventSinks:              fs.EventSinks,
+		KafkaSinks:              fs.KafkaSinks,
+		AdminUIEnabled:          fs.AdminUIEnabled,
 		RetryQueueCount:         fs.RetryCount,
+		MaxFlows:                fs.MaxFlows,
+		PauseConflictingFlows:   fs.PauseConflictingFlows,
+		CleanerInterval:         fs.CleanerInterval,
+		UnroutableGracePeriod:   fs.UnroutableGracePeriod,
+		DeadLetterRouting:       fs.DeadLetterRouting,
+		ArchiveProvider:         fs.ArchiveProvider,
+		MaxContinuations:        fs.MaxContinuations,
+		DurableTasksEnabled:     fs.DurableTasksEnabled,
+		ClaimTTL:                fs.ClaimTTL,
+		BodyStoreThreshold:      fs.BodyStoreThreshold,
+		StoreBinaryBodies:       fs.StoreBinaryBodies,
+		InitTimeout:             fs.InitTimeout,
+		InitRetryDuration:       fs.InitRetryDuration,
+		RequireJSONBody:         fs.RequireJSONBody,
+		PartitionCount:          fs.PartitionCount,
+		StrongConsistency:       fs.StrongConsistency,
+		StateStoreRetryCount:    fs.StateStoreRetryCount,
+		StateStoreRetryBackoff:  fs.StateStoreRetryBackoff,
+		StoreKeyCountsEnabled:   fs.StoreKeyCountsEnabled,
+		StoreKeyCountInterval:   fs.StoreKeyCountInterval,
+		FallbackRedisCfg:        fs.FallbackRedisCfg,
+		FallbackWritePolicy:     fs.FallbackWritePolicy,
+		SlowLogEnabled:          fs.SlowLogEnabled,
+		RequestLogsEnabled:      fs.RequestLogsEnabled,
+		RequestLogMaxEntries:    fs.RequestLogMaxEntries,
+		RequestLogTTL:           fs.RequestLogTTL,
+		StreamingEnabled:        fs.StreamingEnabled,
+		RoutingLogsEnabled:      fs.RoutingLogsEnabled,
+		ErrorBudgetInterval:     fs.ErrorBudgetInterval,
+		AlertWebhookURL:         fs.AlertWebhookURL,
+		PoisonThreshold:         fs.PoisonThreshold,
 		DebugEnabled:            fs.DebugEnabled,
+		DebugSampleRate:         fs.DebugSampleRate,
+		OnQueueError:            fs.OnQueueError,
+		OnErrorBudgetAlert:      fs.OnErrorBudgetAlert,
+		OnPoisonMessage:         fs.OnPoisonMessage,
+		UnsafeFaultInjector:     fs.UnsafeFaultInjector,
+		Clock:                   fs.Clock,
 	}
 
 	if err := fs.runtime.Init(); err != nil {
+		// dropped so that starting again initializes a new runtime
+		fs.runtime = nil
+		return err
+	}
+	fs.runtime.SetGlobalTimeout(fs.GlobalTimeout)
+	fs.runtime.SetSlowNodeThreshold(fs.SlowNodeThreshold)
+	fs.runtime.SetSlowStoreThreshold(fs.SlowStoreThreshold)
+	for _, hook := range fs.PreExecHooks {
+		fs.runtime.RegisterPreExecHook(hook)
+	}
+	for client, limits := range fs.RateLimitOverrides {
+		fs.runtime.SetClientRateLimits(client, limits)
+	}
+	for flowName, rate := range fs.SamplingRates {
+		fs.runtime.SetSamplingRate(flowName, rate)
+	}
+	if err := fs.registerBodyDecoders(); err != nil {
 		return err
 	}
 	go fs.runtimeWorker(errorChan)
@@ -300,6 +736,37 @@ func (fs *FlowService) initRuntime(errorChan chan error) error {
 	return nil
 }
 
+// registerBodyDecoders registers the BodyDecoders on the runtime
+func (fs *FlowService) registerBodyDecoders() error {
+	for contentType, decoder := range fs.BodyDecoders {
+		if err := fs.runtime.RegisterBodyDecoder(contentType, decoder); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetSlowLogThresholds changes the slow log thresholds of the running service, a zero value disables a threshold
+func (fs *FlowService) SetSlowLogThresholds(node time.Duration, store time.Duration) {
+	fs.SlowNodeThreshold = node
+	fs.SlowStoreThreshold = store
+	if fs.runtime != nil {
+		fs.runtime.SetSlowNodeThreshold(node)
+		fs.runtime.SetSlowStoreThreshold(store)
+	}
+}
+
+// SetSamplingRate changes the share of the requests of a flow captured for debugging, a zero rate disables it
+func (fs *FlowService) SetSamplingRate(flowName string, rate float64) {
+	if fs.SamplingRates == nil {
+		fs.SamplingRates = make(map[string]float64)
+	}
+	fs.SamplingRates[flowName] = rate
+	if fs.runtime != nil {
+		fs.runtime.SetSamplingRate(flowName, rate)
+	}
+}
+
 func (fs *FlowService) setWorkerMode(workerMode bool) error {
 	if fs.runtime == nil {
 		return fmt.Errorf("runtime is not initialized")